@@ -0,0 +1,95 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/advisory"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// auditCommand prints the locked dependencies of the project in dir that
+// the advisories of feed report as vulnerable or yanked. It fails with
+// exitAdvisory if any of them is of severity failOn or higher.
+func auditCommand(ctx context.Context, dir, caFile, feed string, failOn advisory.Severity) int {
+	if feed == "" {
+		kingpin.Errorf("no advisory feed, give --advisories or set %s", advisory.Env)
+		return exitUsage
+	}
+	if dir == "" {
+		dir = "."
+	}
+
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to load lock file"))
+	}
+	f, err := advisory.Load(ctx, caFile, feed)
+	if err != nil {
+		return fail(err)
+	}
+
+	findings := f.Check(lock)
+	code := 0
+	for _, finding := range findings {
+		if finding.Advisory.Severity.AtLeast(failOn) {
+			code = exitAdvisory
+		}
+	}
+
+	if output != nil {
+		output.setFindings(findings)
+		return code
+	}
+	if len(findings) == 0 {
+		color.Green(">>> No advisory affects the %d locked dependencies\n", len(lock.Dependencies))
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tADVISORY\tSEVERITY\tSUMMARY")
+	for _, finding := range findings {
+		version := finding.Tag
+		if version == "" {
+			version = shortCommit(finding.Version)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", finding.Name, version, finding.Advisory.ID, severityLabel(finding.Advisory), finding.Advisory.Summary)
+	}
+	w.Flush()
+	color.Yellow(">>> Locked dependencies affected by advisories: %d\n", len(findings))
+
+	return code
+}
+
+// severityLabel describes the severity of a, and whether it is about a
+// yanked version.
+func severityLabel(a advisory.Advisory) string {
+	label := string(a.Severity)
+	if label == "" {
+		label = "unknown"
+	}
+	if a.Yanked {
+		label += " (yanked)"
+	}
+	return label
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const cacheDirEnv = "JB_CACHE_DIR"
+
+// defaultCacheDir returns the user cache directory of jsonnet-bundler,
+// ~/.cache/jsonnet-bundler on Linux. It is empty if the user has no cache
+// directory, which disables caching.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "jsonnet-bundler")
+}
+
+func cacheInfoCommand(dir string) int {
+	if dir == "" {
+		kingpin.Errorf("no cache directory, set --cache-dir or %s", cacheDirEnv)
+		return exitFailure
+	}
+
+	info, err := pkg.NewCache(dir).Info()
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to read cache"))
+	}
+
+	fmt.Printf("Location: %s\n", dir)
+	fmt.Printf("Packages: %d\n", info.Entries)
+	fmt.Printf("Size:     %s\n", humanSize(info.Size))
+	return 0
+}
+
+func cacheCleanCommand(dir string, lockTimeout time.Duration) int {
+	if dir == "" {
+		kingpin.Errorf("no cache directory, set --cache-dir or %s", cacheDirEnv)
+		return exitFailure
+	}
+
+	cache := pkg.NewCache(dir)
+	cache.LockTimeout = lockTimeout
+	if err := cache.Clean(); err != nil {
+		return fail(errors.Wrap(err, "failed to clean cache"))
+	}
+
+	color.Green(">>> Cleaned %s\n", dir)
+	return 0
+}
+
+func humanSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
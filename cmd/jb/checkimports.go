@@ -0,0 +1,82 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/imports"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// checkImportsCommand reports the imports of the jsonnet files in dir and
+// in the vendored packages that do not resolve against the vendor
+// directory, the way jsonnet would look for them with the flags of jb
+// jpath and JSONNET_PATH. Nothing is rewritten, see rewriteImportsCommand.
+func checkImportsCommand(dir, jsonnetHome string) int {
+	unresolved, total, err := unresolvedImports(dir, jsonnetHome)
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, imp := range unresolved {
+		fmt.Printf("%s:%d: %s %q does not resolve\n", imp.File, imp.Line, imp.Kind, imp.Path)
+	}
+	if len(unresolved) > 0 {
+		kingpin.Errorf("%d of %d imports do not resolve", len(unresolved), total)
+		return exitFailure
+	}
+	color.Green(">>> All %d imports resolve\n", total)
+	return 0
+}
+
+// unresolvedImports returns the imports of dir and of the vendored packages
+// that do not resolve, and the number of imports checked.
+func unresolvedImports(dir, jsonnetHome string) ([]imports.Import, int, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if !filepath.IsAbs(jsonnetHome) {
+		jsonnetHome = filepath.Join(dir, jsonnetHome)
+	}
+
+	own, err := imports.Scan(dir, filepath.Base(jsonnetHome))
+	if err != nil {
+		return nil, 0, errors.Wrapf(err, "failed to scan %s for imports", dir)
+	}
+	vendored, err := imports.Scan(jsonnetHome)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, 0, errors.Wrapf(err, "failed to scan %s for imports", jsonnetHome)
+	}
+
+	jpath, err := projectJpath(dir, jsonnetHome)
+	if err != nil {
+		return nil, 0, err
+	}
+	jpath = append(jpath, envJpath()...)
+
+	all := append(own, vendored...)
+	unresolved := []imports.Import{}
+	for _, imp := range all {
+		if !imports.Resolves(imp, jpath) {
+			unresolved = append(unresolved, imp)
+		}
+	}
+	return unresolved, len(all), nil
+}
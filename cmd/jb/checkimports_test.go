@@ -0,0 +1,53 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/imports"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnresolvedImports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-check-imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"main.jsonnet":                 "(import 'lib/lib.libsonnet') + (import 'utils.libsonnet') + { txt: importstr 'missing.txt' }",
+		"utils.libsonnet":              "{}",
+		"vendor/lib/lib.libsonnet":     "(import 'other/other.libsonnet') + (import 'helpers.libsonnet')",
+		"vendor/lib/helpers.libsonnet": "{}",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	unresolved, total, err := unresolvedImports(dir, "vendor")
+	assert.NoError(t, err)
+	assert.Equal(t, 5, total)
+	assert.Equal(t, []imports.Import{
+		{File: filepath.Join(dir, "main.jsonnet"), Kind: "importstr", Path: "missing.txt", Line: 1},
+		{File: filepath.Join(dir, "vendor", "lib", "lib.libsonnet"), Kind: "import", Path: "other/other.libsonnet", Line: 1},
+	}, unresolved)
+}
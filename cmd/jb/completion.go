@@ -0,0 +1,108 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+)
+
+// Completion scripts ask jb itself for the candidates of the current word
+// through the hidden --completion-bash flag of kingpin. Partial words are
+// passed as empty ones, kingpin would take them for complete arguments,
+// and filtered by the shell.
+var completionTemplates = map[string]string{
+	"bash": `_{{.}}_completion() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" word="${COMP_WORDS[COMP_CWORD]}"
+    [[ "$word" == -* ]] || word=""
+    local opts=$("${COMP_WORDS[0]}" --completion-bash "${COMP_WORDS[@]:1:$COMP_CWORD-1}" "$word")
+    COMPREPLY=( $(compgen -W "${opts}" -- "${cur}") )
+}
+complete -F _{{.}}_completion {{.}}
+`,
+	"zsh": `#compdef {{.}}
+autoload -U +X bashcompinit && bashcompinit
+
+_{{.}}_completion() {
+    local cur="${COMP_WORDS[COMP_CWORD]}" word="${COMP_WORDS[COMP_CWORD]}"
+    [[ "$word" == -* ]] || word=""
+    local opts=$("${COMP_WORDS[0]}" --completion-bash "${COMP_WORDS[@]:1:$COMP_CWORD-1}" "$word")
+    COMPREPLY=( $(compgen -W "${opts}" -- "${cur}") )
+}
+complete -F _{{.}}_completion {{.}}
+`,
+	"fish": `function __{{.}}_completion
+    set -l args (commandline -opc)
+    set -l word (commandline -ct)
+    string match -q -- '-*' $word; or set word ''
+    set -l cmd $args[1]
+    set -e args[1]
+    $cmd --completion-bash $args $word
+end
+complete -c {{.}} -f -a '(__{{.}}_completion)'
+`,
+}
+
+// completionShells lists the shells completion scripts are generated for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// completionCommand writes the completion script of app for shell to w.
+func completionCommand(w io.Writer, app, shell string) int {
+	t, ok := completionTemplates[shell]
+	if !ok {
+		return fail(fmt.Errorf("no completion for shell %s, expected one of %s", shell, strings.Join(completionShells, ", ")))
+	}
+	if err := template.Must(template.New(shell).Parse(t)).Execute(w, app); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// dependencyNames returns the sorted names of the dependencies of the
+// jsonnetfile in dir, completed for the commands taking packages. Nothing
+// is completed without a jsonnetfile.
+func dependencyNames(dir string) []string {
+	m, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(m.Dependencies))
+	for _, d := range m.Dependencies {
+		names = append(names, d.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// targetNames returns the sorted names of the targets of the jsonnetfile in
+// dir, completed for --target.
+func targetNames(dir string) []string {
+	m, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
+	if err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(m.Targets))
+	for name := range m.Targets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompletionCommand(t *testing.T) {
+	for _, shell := range completionShells {
+		b := bytes.NewBuffer(nil)
+		assert.Equal(t, 0, completionCommand(b, "jb", shell), shell)
+		assert.Contains(t, b.String(), "--completion-bash", shell)
+		assert.Contains(t, b.String(), "_jb_completion", shell)
+	}
+}
+
+func TestDependencyNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-completion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	assert.Empty(t, dependencyNames(dir))
+
+	jf := `{"version": 1, "dependencies": [
+  {"name": "zeta", "source": {"git": {"remote": "https://github.com/org/zeta"}}, "version": "main"},
+  {"name": "alpha", "source": {"git": {"remote": "https://github.com/org/repo", "subdir": "alpha"}}, "version": "main"}
+]}`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "jsonnetfile.json"), []byte(jf), 0644))
+	assert.Equal(t, []string{"alpha", "zeta"}, dependencyNames(dir))
+}
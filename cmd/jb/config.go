@@ -0,0 +1,471 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Project config files, in JSON and in YAML.
+const (
+	projectConfigFile     = ".jbrc"
+	projectYAMLConfigFile = ".jb.yaml"
+)
+
+// config maps long flag names to the default values configured for them.
+type config map[string][]string
+
+// authEnv maps the config keys for credentials, which have no flags, to
+// the environment variables they stand in for.
+var authEnv = map[string]string{
+	"git-username": pkg.GitUsernameEnv,
+	"git-password": pkg.GitPasswordEnv,
+	"git-hosts":    pkg.GitHostsEnv,
+	"github-token": pkg.GithubTokenEnv,
+	"gitlab-token": pkg.GitlabTokenEnv,
+	"netrc":        "NETRC",
+}
+
+// projectConfigKeys are the keys the config of a project may set. The
+// project config comes with the repository that was cloned, so keys that
+// run code or decide what is trusted and where it is fetched from, like
+// allow-hooks, policy-hook, ca-file, mirror and the credentials, are only
+// read from the user config and the command line.
+var projectConfigKeys = map[string]bool{
+	"jsonnetpkg-home":    true,
+	"jobs":               true,
+	"flatten":            true,
+	"default-branch":     true,
+	"conflict-strategy":  true,
+	"disambiguate-names": true,
+	"strip":              true,
+	"keep-docs":          true,
+	"copy":               true,
+	"reproducible":       true,
+	"prune":              true,
+	"frozen":             true,
+	"include-frozen":     true,
+	"check-imports":      true,
+	"retries":            true,
+	"retry-delay":        true,
+	"timeout":            true,
+	"lock-timeout":       true,
+	"max-rate":           true,
+	"github-tarballs":    true,
+	"prefer":             true,
+}
+
+// userConfigFiles returns the locations of the per-user config files,
+// honoring XDG_CONFIG_HOME: the JSON one of jsonnet-bundler, followed by
+// the YAML one of jb.
+func userConfigFiles() []string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return []string{
+		filepath.Join(dir, "jsonnet-bundler", "config.json"),
+		filepath.Join(dir, "jb", "config.yaml"),
+	}
+}
+
+// projectConfigFiles returns the locations of the config files of the
+// project in dir.
+func projectConfigFiles(dir string) []string {
+	return []string{filepath.Join(dir, projectConfigFile), filepath.Join(dir, projectYAMLConfigFile)}
+}
+
+// loadProjectConfig reads the config files of the project in dir, see
+// loadConfig, ignoring the keys it may not set with a warning, see
+// projectConfigKeys.
+func loadProjectConfig(dir string) (config, error) {
+	conf, err := loadConfig(projectConfigFiles(dir)...)
+	if err != nil {
+		return nil, err
+	}
+	for key := range conf {
+		if !projectConfigKeys[key] {
+			color.Yellow(">>> Ignoring %s in the project config, it is only read from the user config and the command line\n", key)
+			delete(conf, key)
+		}
+	}
+	return conf, nil
+}
+
+// mergeConfig reads the user config files, followed by the config of the
+// project in dir, whose values take precedence, see loadProjectConfig.
+func mergeConfig(userFiles []string, dir string) (config, error) {
+	conf, err := loadConfig(userFiles...)
+	if err != nil {
+		return nil, err
+	}
+	project, err := loadProjectConfig(dir)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range project {
+		conf[key] = values
+	}
+	return conf, nil
+}
+
+// recordJsonnetHome records jsonnetHome, given on the command line, as the
+// vendor directory in the config of the project in dir, relative to dir
+// unless it is on another volume, so that later runs vendor into it as
+// well. Nothing is written if the project config has it already, or if it
+// is the default one and the project config has none.
+func recordJsonnetHome(dir, jsonnetHome string) error {
+	home := jsonnetHome
+	if filepath.IsAbs(home) {
+		if rel, err := filepath.Rel(dir, home); err == nil {
+			home = rel
+		}
+	}
+	home = filepath.ToSlash(filepath.Clean(home))
+
+	conf, err := loadConfig(projectConfigFiles(dir)...)
+	if err != nil {
+		return err
+	}
+	recorded, ok := conf["jsonnetpkg-home"]
+	if ok && len(recorded) == 1 && filepath.ToSlash(filepath.Clean(recorded[0])) == home || !ok && home == "vendor" {
+		return nil
+	}
+
+	file, err := recordProjectConfig(dir, "jsonnetpkg-home", home)
+	if err != nil {
+		return errors.Wrap(err, "failed to record the vendor directory in the project config")
+	}
+	color.Green(">>> Recorded the vendor directory %s in %s\n", home, filepath.Base(file))
+	return nil
+}
+
+// recordProjectConfig sets key to value in the config of the project in dir
+// and returns the file written: the YAML config if there is one, as it
+// takes precedence, or else the JSON one, created if need be. The other
+// keys are kept.
+func recordProjectConfig(dir, key, value string) (string, error) {
+	files := projectConfigFiles(dir)
+	if exists, err := pkg.FileExists(files[1]); err != nil {
+		return "", err
+	} else if exists {
+		return files[1], recordYAMLConfig(files[1], key, value)
+	}
+
+	raw := map[string]interface{}{}
+	b, err := ioutil.ReadFile(files[0])
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	if err == nil {
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return "", errors.Wrapf(err, "failed to parse config file %s", files[0])
+		}
+	}
+	raw[key] = value
+	b, err = json.MarshalIndent(raw, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return files[0], ioutil.WriteFile(files[0], append(b, '\n'), 0644)
+}
+
+// recordYAMLConfig sets key to value in the YAML config file, replacing
+// the line of the key, and the sequence following it, or adding one.
+func recordYAMLConfig(file, key, value string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	entry := key + ": " + strconv.Quote(value)
+	lines := []string{}
+	replaced, list := false, false
+	for _, line := range strings.Split(strings.TrimRight(string(b), "\n"), "\n") {
+		trimmed := strings.TrimSpace(stripYAMLComment(line))
+		if list && (strings.HasPrefix(trimmed, "- ") || trimmed == "-") {
+			continue
+		}
+		list = false
+		if strings.HasPrefix(line, key+":") {
+			list = strings.TrimSpace(strings.TrimPrefix(trimmed, key+":")) == ""
+			lines = append(lines, entry)
+			replaced = true
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if !replaced {
+		lines = append(lines, entry)
+	}
+	return ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// loadConfig reads the given config files in order, values of later files
+// overriding those of earlier ones. Files that do not exist are skipped.
+// Files ending in .yaml or .yml are YAML, see parseYAMLConfig, all others
+// JSON.
+func loadConfig(files ...string) (config, error) {
+	res := config{}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(file)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read config file %s", file)
+		}
+
+		raw := map[string]interface{}{}
+		switch filepath.Ext(file) {
+		case ".yaml", ".yml":
+			raw, err = parseYAMLConfig(b)
+		default:
+			err = json.Unmarshal(b, &raw)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse config file %s", file)
+		}
+
+		for name, value := range raw {
+			values, err := configValues(value)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid value for %s in config file %s", name, file)
+			}
+			res[name] = values
+		}
+	}
+
+	return res, nil
+}
+
+// parseYAMLConfig parses the subset of YAML needed for config files: a
+// mapping of keys to scalars, to flow sequences like [a, b] or to block
+// sequences of scalars. Scalars are returned as strings, quoted or not, and
+// keys without a value are left out.
+func parseYAMLConfig(b []byte) (map[string]interface{}, error) {
+	res := map[string]interface{}{}
+	list := ""
+	for n, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimRight(stripYAMLComment(line), " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if list == "" {
+				return nil, fmt.Errorf("line %d: sequence entry outside of a sequence", n+1)
+			}
+			value, err := yamlScalar(strings.TrimSpace(strings.TrimPrefix(trimmed, "-")))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			res[list] = append(res[list].([]interface{}), value)
+			continue
+		}
+
+		i := strings.Index(line, ":")
+		if trimmed != line || i < 0 || (i+1 < len(line) && line[i+1] != ' ') {
+			return nil, fmt.Errorf("line %d: expected key: value, nested mappings are not supported", n+1)
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+
+		list = ""
+		switch {
+		case value == "":
+			list = key
+			res[key] = []interface{}{}
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			values := []interface{}{}
+			for _, e := range strings.Split(value[1:len(value)-1], ",") {
+				if e = strings.TrimSpace(e); e == "" {
+					continue
+				}
+				v, err := yamlScalar(e)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %v", n+1, err)
+				}
+				values = append(values, v)
+			}
+			res[key] = values
+		default:
+			v, err := yamlScalar(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n+1, err)
+			}
+			res[key] = v
+		}
+	}
+
+	// Keys without a value are null, not empty sequences.
+	for key, value := range res {
+		if values, ok := value.([]interface{}); ok && len(values) == 0 {
+			delete(res, key)
+		}
+	}
+	return res, nil
+}
+
+// stripYAMLComment removes a comment, starting at a # at the start of line
+// or after a space, outside of quotes.
+func stripYAMLComment(line string) string {
+	quote := byte(0)
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// yamlScalar returns the string value of a plain or quoted scalar.
+func yamlScalar(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted string %s", s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return "", fmt.Errorf("invalid quoted string %s", s)
+		}
+		return strings.Replace(s[1:len(s)-1], "''", "'", -1), nil
+	case strings.HasPrefix(s, "{") || strings.HasPrefix(s, "[") || strings.HasPrefix(s, "&") || strings.HasPrefix(s, "*"):
+		return "", fmt.Errorf("unsupported value %s", s)
+	}
+	return s, nil
+}
+
+func configValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case []interface{}:
+		res := []string{}
+		for _, e := range v {
+			values, err := configValues(e)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, values...)
+		}
+		return res, nil
+	}
+
+	return nil, fmt.Errorf("unsupported type %T", value)
+}
+
+// apply sets the configured values as the defaults of the matching flags of
+// the application and all of its commands. Flags given on the command line
+// and environment variables still take precedence, see applyEnv, and flags
+// without a configured value keep their built-in defaults.
+func (c config) apply(a *kingpin.Application) {
+	for name, values := range c {
+		if f := a.GetFlag(name); f != nil {
+			f.Default(values...)
+		}
+	}
+
+	for _, cmd := range a.Model().Commands {
+		c.applyCommand(a.GetCommand(cmd.Name), cmd)
+	}
+}
+
+// setenv sets the environment variables of the credentials configured in
+// authEnv, unless they are set already.
+func (c config) setenv() {
+	for key, env := range authEnv {
+		if values, ok := c[key]; ok && len(values) > 0 && os.Getenv(env) == "" {
+			os.Setenv(env, values[0])
+		}
+	}
+}
+
+func (c config) applyCommand(clause *kingpin.CmdClause, model *kingpin.CmdModel) {
+	for name, values := range c {
+		if f := clause.GetFlag(name); f != nil {
+			f.Default(values...)
+		}
+	}
+
+	for _, cmd := range model.Commands {
+		c.applyCommand(clause.GetCommand(cmd.Name), cmd)
+	}
+}
+
+// applyEnv sets the flags of the application and of the parsed command
+// whose environment variables are set to their values once the command line
+// was parsed, so that the environment takes precedence over flags given on
+// the command line, which take precedence over the config files.
+func applyEnv(a *kingpin.Application, command string) error {
+	model := a.Model()
+	flags := model.Flags
+	commands := model.Commands
+	for _, name := range strings.Fields(command) {
+		for _, cmd := range commands {
+			if cmd.Name == name {
+				flags = append(flags, cmd.Flags...)
+				commands = cmd.Commands
+				break
+			}
+		}
+	}
+
+	for _, f := range flags {
+		if f.Envar == "" {
+			continue
+		}
+		if value := os.Getenv(f.Envar); value != "" {
+			if err := f.Value.Set(value); err != nil {
+				return errors.Wrapf(err, "invalid value of %s", f.Envar)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestConfigPrecedence(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	userFile := filepath.Join(tempDir, "config.json")
+	projectDir := filepath.Join(tempDir, "project")
+	emptyDir := filepath.Join(tempDir, "empty")
+	assert.NoError(t, os.MkdirAll(projectDir, os.ModePerm))
+	err = ioutil.WriteFile(userFile, []byte(`{"jsonnetpkg-home": "user-vendor", "jobs": 4, "flatten": false, "prefer": "ssh"}`), 0644)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(projectDir, projectConfigFile), []byte(`{"jsonnetpkg-home": "project-vendor", "prefer": "https"}`), 0644)
+	assert.NoError(t, err)
+
+	const preferEnv = "JB_TEST_PREFER"
+	defer os.Unsetenv(preferEnv)
+
+	testcases := []struct {
+		Name    string
+		Files   []string
+		Dir     string
+		Args    []string
+		Env     string
+		Home    string
+		Jobs    int
+		Flatten bool
+		Prefer  string
+	}{{
+		Name:    "BuiltinDefaults",
+		Files:   []string{filepath.Join(tempDir, "missing.json")},
+		Dir:     emptyDir,
+		Args:    []string{"install"},
+		Home:    "vendor",
+		Jobs:    1,
+		Flatten: true,
+		Prefer:  "",
+	}, {
+		Name:    "UserConfig",
+		Files:   []string{userFile},
+		Dir:     emptyDir,
+		Args:    []string{"install"},
+		Home:    "user-vendor",
+		Jobs:    4,
+		Flatten: false,
+		Prefer:  "ssh",
+	}, {
+		Name:    "ProjectOverridesUser",
+		Files:   []string{userFile},
+		Dir:     projectDir,
+		Args:    []string{"install"},
+		Home:    "project-vendor",
+		Jobs:    4,
+		Flatten: false,
+		Prefer:  "https",
+	}, {
+		Name:    "FlagsOverrideConfig",
+		Files:   []string{userFile},
+		Dir:     projectDir,
+		Args:    []string{"--jsonnetpkg-home=flag-vendor", "--prefer=ssh", "install", "--jobs=8", "--flatten"},
+		Home:    "flag-vendor",
+		Jobs:    8,
+		Flatten: true,
+		Prefer:  "ssh",
+	}, {
+		Name:    "EnvOverridesConfig",
+		Files:   []string{userFile},
+		Dir:     projectDir,
+		Args:    []string{"install"},
+		Env:     "ssh",
+		Home:    "project-vendor",
+		Jobs:    4,
+		Flatten: false,
+		Prefer:  "ssh",
+	}, {
+		Name:    "EnvOverridesFlags",
+		Files:   []string{userFile},
+		Dir:     projectDir,
+		Args:    []string{"--prefer=ssh", "install"},
+		Env:     "https",
+		Home:    "project-vendor",
+		Jobs:    4,
+		Flatten: false,
+		Prefer:  "https",
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var (
+				home    string
+				jobs    int
+				flatten bool
+				prefer  string
+			)
+			os.Setenv(preferEnv, tc.Env)
+			a := kingpin.New("jb", "")
+			a.Flag("jsonnetpkg-home", "").Default("vendor").StringVar(&home)
+			a.Flag("prefer", "").Envar(preferEnv).EnumVar(&prefer, "https", "ssh")
+			install := a.Command("install", "")
+			install.Flag("jobs", "").Default("1").IntVar(&jobs)
+			install.Flag("flatten", "").Default("true").BoolVar(&flatten)
+
+			conf, err := mergeConfig(tc.Files, tc.Dir)
+			assert.NoError(t, err)
+			conf.apply(a)
+
+			command, err := a.Parse(tc.Args)
+			assert.NoError(t, err)
+			assert.NoError(t, applyEnv(a, command))
+			assert.Equal(t, tc.Home, home)
+			assert.Equal(t, tc.Jobs, jobs)
+			assert.Equal(t, tc.Flatten, flatten)
+			assert.Equal(t, tc.Prefer, prefer)
+		})
+	}
+}
+
+func TestProjectConfigCannotEnableHooks(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	err = ioutil.WriteFile(filepath.Join(tempDir, projectConfigFile), []byte(`{"allow-hooks": true, "policy-hook": "true", "ca-file": "ca.pem", "mirror": "https://github.com/=https://evil.example/", "github-token": "t", "jobs": 8}`), 0644)
+	assert.NoError(t, err)
+
+	conf, err := loadProjectConfig(tempDir)
+	assert.NoError(t, err)
+	assert.Equal(t, config{"jobs": {"8"}}, conf)
+
+	var allowHooks bool
+	a := kingpin.New("jb", "")
+	install := a.Command("install", "")
+	install.Flag("allow-hooks", "").BoolVar(&allowHooks)
+	conf.apply(a)
+	_, err = a.Parse([]string{"install"})
+	assert.NoError(t, err)
+	assert.False(t, allowHooks)
+}
+
+func TestLoadConfigInvalid(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	file := filepath.Join(tempDir, "config.json")
+	err = ioutil.WriteFile(file, []byte(`{"jobs": {"nested": true}}`), 0644)
+	assert.NoError(t, err)
+
+	_, err = loadConfig(file)
+	assert.Error(t, err)
+}
+
+func TestLoadConfigYAML(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	userFile := filepath.Join(tempDir, "config.json")
+	projectFile := filepath.Join(tempDir, projectYAMLConfigFile)
+	err = ioutil.WriteFile(userFile, []byte(`{"jsonnetpkg-home": "user-vendor", "jobs": 4}`), 0644)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(projectFile, []byte(`# defaults of the team
+---
+jsonnetpkg-home: "project vendor" # quoted
+default-branch: main
+mirror:
+  - https://github.com/=https://mirror.example.com/
+  - 'https://gitlab.com/=https://mirror.example.com/gitlab/'
+exclude: [docs, "tests"]
+retries:
+`), 0644)
+	assert.NoError(t, err)
+
+	conf, err := loadConfig(userFile, projectFile)
+	assert.NoError(t, err)
+	assert.Equal(t, config{
+		"jsonnetpkg-home": {"project vendor"},
+		"jobs":            {"4"},
+		"default-branch":  {"main"},
+		"mirror":          {"https://github.com/=https://mirror.example.com/", "https://gitlab.com/=https://mirror.example.com/gitlab/"},
+		"exclude":         {"docs", "tests"},
+	}, conf)
+
+	for _, invalid := range []string{
+		"- vendor\n",
+		"install:\n  jobs: 4\n",
+		"jobs: {a: 1}\n",
+		"jsonnetpkg-home: 'vendor\n",
+	} {
+		err = ioutil.WriteFile(projectFile, []byte(invalid), 0644)
+		assert.NoError(t, err)
+		_, err = loadConfig(projectFile)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestConfigSetenv(t *testing.T) {
+	for _, env := range []string{"GITHUB_TOKEN", "GITLAB_TOKEN"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	os.Setenv("GITHUB_TOKEN", "")
+	os.Setenv("GITLAB_TOKEN", "from-env")
+
+	config{"github-token": {"from-config"}, "gitlab-token": {"from-config"}}.setenv()
+	assert.Equal(t, "from-config", os.Getenv("GITHUB_TOKEN"))
+	assert.Equal(t, "from-env", os.Getenv("GITLAB_TOKEN"))
+}
+
+func TestRecordJsonnetHome(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	dir := filepath.Join(tempDir, "project")
+	assert.NoError(t, os.Mkdir(dir, os.ModePerm))
+	projectFile := filepath.Join(dir, projectConfigFile)
+
+	// The default vendor directory is not worth recording.
+	assert.NoError(t, recordJsonnetHome(dir, "vendor"))
+	_, err = os.Stat(projectFile)
+	assert.True(t, os.IsNotExist(err))
+
+	// Absolute paths are recorded relative to the project, next to the
+	// other keys.
+	assert.NoError(t, ioutil.WriteFile(projectFile, []byte(`{"jobs": 8}`), 0644))
+	assert.NoError(t, recordJsonnetHome(dir, filepath.Join(tempDir, "shared", "vendor")))
+	conf, err := loadConfig(projectConfigFiles(dir)...)
+	assert.NoError(t, err)
+	assert.Equal(t, config{"jobs": {"8"}, "jsonnetpkg-home": {"../shared/vendor"}}, conf)
+
+	// The YAML config takes precedence, it is the one updated.
+	yamlFile := filepath.Join(dir, projectYAMLConfigFile)
+	assert.NoError(t, ioutil.WriteFile(yamlFile, []byte("jsonnetpkg-home:\n  - other\n# comment\njobs: 4\n"), 0644))
+	assert.NoError(t, recordJsonnetHome(dir, "../vendor"))
+	b, err := ioutil.ReadFile(yamlFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "jsonnetpkg-home: \"../vendor\"\n# comment\njobs: 4\n", string(b))
+	conf, err = loadConfig(projectConfigFiles(dir)...)
+	assert.NoError(t, err)
+	assert.Equal(t, config{"jobs": {"4"}, "jsonnetpkg-home": {"../vendor"}}, conf)
+}
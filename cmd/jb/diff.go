@@ -0,0 +1,80 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// diffCommand prints the files of the vendored version of the package of
+// ref, given as <package>@<version>, that differ at version, or the patch
+// between both if patch is set.
+func diffCommand(ctx context.Context, dir, ref string, patch bool, opts client.Options) int {
+	at := strings.LastIndex(ref, "@")
+	if at <= 0 || at == len(ref)-1 {
+		kingpin.Errorf("expected <package>@<version>, got %s", ref)
+		return exitUsage
+	}
+	opts.Dir = dir
+
+	d, err := client.Diff(ctx, client.DiffOptions{Options: opts, Package: ref[:at], Version: ref[at+1:]})
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to compare package"))
+	}
+	defer d.Close()
+
+	if output != nil {
+		output.setFiles(d.Files)
+		return 0
+	}
+	color.Green(">>> %s: %d files differ between %s and %s\n", d.Locked.Name, len(d.Files), versionLabel(d.Locked), versionLabel(d.Other))
+	if patch {
+		if err := pkg.DiffPatch(ctx, d.LockedDir, d.OtherDir, os.Stdout); err != nil {
+			return fail(errors.Wrap(err, "failed to compare package"))
+		}
+		return 0
+	}
+	printFileChanges(os.Stdout, d.Files)
+	return 0
+}
+
+// versionLabel returns the tag of the locked dependency d, or else its
+// abbreviated commit.
+func versionLabel(d spec.Dependency) string {
+	if d.Tag != "" {
+		return d.Tag
+	}
+	return shortCommit(d.Version)
+}
+
+// printFileChanges prints a line per file, prefixed with + when added, -
+// when removed and ~ when modified, like jb verify does for packages.
+func printFileChanges(out io.Writer, files []pkg.FileChange) {
+	marks := map[pkg.FileChangeKind]string{pkg.FileAdded: "+", pkg.FileRemoved: "-", pkg.FileModified: "~"}
+	for _, f := range files {
+		fmt.Fprintf(out, "%s %s\n", marks[f.Kind], f.Path)
+	}
+}
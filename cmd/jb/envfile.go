@@ -0,0 +1,110 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// envNameRegex matches the characters of a package name that cannot be part
+// of the name of an environment variable.
+var envNameRegex = regexp.MustCompile(`[^A-Z0-9]+`)
+
+// shellSafeRegex matches the values that need no quoting in a shell or a
+// Makefile.
+var shellSafeRegex = regexp.MustCompile(`^[A-Za-z0-9_./:@%+=,-]*$`)
+
+// envFileCommand writes the variables describing the install of the project
+// in dir to filename, relative to dir unless absolute, see writeEnvFile.
+// With target, those of the target installed are written.
+func envFileCommand(dir, jsonnetHome, name, target string) int {
+	filename := name
+	if !filepath.IsAbs(filename) {
+		filename = filepath.Join(dir, filename)
+	}
+
+	lock, err := pkg.LoadJsonnetfile(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fail(errors.Wrap(err, "failed to load lock file"))
+	}
+	if target != "" {
+		if home := lock.Targets[target].JsonnetHome; home != "" {
+			jsonnetHome = home
+		}
+		lock = client.TargetLock(lock, target)
+	}
+
+	// Paths are written the way jsonnetHome is given, relative to dir
+	// unless absolute.
+	home := jsonnetHome
+	if !filepath.IsAbs(home) {
+		home = filepath.Join(dir, home)
+	}
+	jpath := pkg.Jpath(home, lock)
+	if !filepath.IsAbs(jsonnetHome) {
+		for i, p := range jpath {
+			if rel, err := filepath.Rel(dir, p); err == nil {
+				jpath[i] = rel
+			}
+		}
+	}
+	if err := writeEnvFile(filename, jsonnetHome, jpath, lock.Dependencies); err != nil {
+		return fail(errors.Wrapf(err, "failed to write %s", filename))
+	}
+	color.Green(">>> Wrote %s\n", name)
+	return 0
+}
+
+// writeEnvFile writes JB_VENDOR_DIR, JSONNET_PATH and the locked version of
+// every package of deps, as JB_VERSION_ followed by its name in upper case
+// with other characters than letters and digits replaced by underscores,
+// one assignment per line, so that the file can be sourced by a shell or
+// included by a Makefile.
+func writeEnvFile(filename, jsonnetHome string, jpath []string, deps []spec.Dependency) error {
+	dirs := make([]string, 0, len(jpath))
+	for _, p := range jpath {
+		dirs = append(dirs, filepath.ToSlash(p))
+	}
+	lines := []string{
+		"# Written by jb, do not edit.",
+		envLine("JB_VENDOR_DIR", filepath.ToSlash(jsonnetHome)),
+		envLine(jsonnetPathEnv, strings.Join(dirs, string(os.PathListSeparator))),
+	}
+	for _, d := range deps {
+		name := strings.Trim(envNameRegex.ReplaceAllString(strings.ToUpper(d.Name), "_"), "_")
+		lines = append(lines, envLine("JB_VERSION_"+name, d.Version))
+	}
+	return ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
+
+// envLine assigns value to name, single quoted unless it needs no quoting.
+func envLine(name, value string) string {
+	if !shellSafeRegex.MatchString(value) {
+		value = "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+	}
+	return fmt.Sprintf("%s=%s", name, value)
+}
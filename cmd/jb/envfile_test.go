@@ -0,0 +1,52 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-envfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, ".jb.env")
+
+	deps := []spec.Dependency{
+		{Name: "grafonnet", Version: "abc123"},
+		{Name: "github.com/grafana/jsonnet-libs/grafana-builder", Version: "def456"},
+		{Name: "odd", Version: "it's a $version"},
+	}
+	assert.NoError(t, writeEnvFile(filename, "vendor", []string{"vendor", filepath.Join("vendor", "github.com", "grafana")}, deps))
+	b, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join([]string{
+		"# Written by jb, do not edit.",
+		"JB_VENDOR_DIR=vendor",
+		"JSONNET_PATH=vendor" + string(os.PathListSeparator) + "vendor/github.com/grafana",
+		"JB_VERSION_GRAFONNET=abc123",
+		"JB_VERSION_GITHUB_COM_GRAFANA_JSONNET_LIBS_GRAFANA_BUILDER=def456",
+		`JB_VERSION_ODD='it'\''s a $version'`,
+	}, "\n")+"\n", string(b))
+}
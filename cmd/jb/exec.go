@@ -0,0 +1,84 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// execCommand runs args in dir with JSONNET_PATH set to the jpath of the
+// project, see projectJpath, followed by the directories already in it,
+// so that jsonnet, tk and other tools resolve the vendored imports without
+// -J flags. It exits with the exit code of the command.
+func execCommand(dir, jsonnetHome string, args []string) int {
+	jpath, err := projectJpath(dir, jsonnetHome)
+	if err != nil {
+		return fail(err)
+	}
+	for i, p := range jpath {
+		if abs, err := filepath.Abs(p); err == nil {
+			jpath[i] = abs
+		}
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = dir
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), jsonnetPathEnv+"="+strings.Join(append(jpath, envJpath()...), string(os.PathListSeparator)))
+
+	// The terminal interrupts the command itself, jb waits for it to exit.
+	// SIGTERM is sent to jb only and passed on.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return fail(errors.Wrapf(err, "failed to run %s", args[0]))
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case s := <-sigs:
+				if s != os.Interrupt {
+					cmd.Process.Signal(s)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		// Killed by a signal, most likely an interrupt.
+		if exitErr.ExitCode() < 0 {
+			return exitInterrupted
+		}
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		return fail(errors.Wrapf(err, "failed to run %s", args[0]))
+	}
+	return 0
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+
+	dir, err := ioutil.TempDir("", "jb-exec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv(jsonnetPathEnv, "/lib")
+	defer os.Unsetenv(jsonnetPathEnv)
+
+	// The command runs in the project, with the vendor directory first on
+	// JSONNET_PATH, and its exit code is the one of jb.
+	code := execCommand(dir, "vendor", []string{"sh", "-c", `echo "$JSONNET_PATH" > path; exit 3`})
+	assert.Equal(t, 3, code)
+	b, err := ioutil.ReadFile(filepath.Join(dir, "path"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "vendor")+":/lib\n", string(b))
+
+	assert.Equal(t, exitFailure, execCommand(dir, "vendor", []string{filepath.Join(dir, "missing")}))
+}
@@ -0,0 +1,125 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/parser"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// Exit codes of jb, documented in the README, so that scripts can tell why
+// it failed.
+const (
+	// exitFailure is any failure without a more specific code.
+	exitFailure = 1
+	// exitUsage is an invalid command line or package reference.
+	exitUsage = 2
+	// exitOutOfSync is a lock file or vendor directory that does not match
+	// what it is checked against, see --frozen, --no-lock-write and verify.
+	exitOutOfSync = 3
+	// exitNotFound is a repository, version or local package that does not
+	// exist.
+	exitNotFound = 4
+	// exitAuth is a remote refusing access for missing or wrong
+	// credentials.
+	exitAuth = 5
+	// exitNetwork is a remote that could not be reached, even after
+	// retrying, or a package that is not in the cache of an offline
+	// install.
+	exitNetwork = 6
+	// exitConflict is dependencies that cannot be resolved together, like
+	// incompatible versions or colliding names.
+	exitConflict = 7
+	// exitIntegrity is a package whose checksum or signature does not
+	// match.
+	exitIntegrity = 8
+	// exitChanged is an install or update with --diff that succeeded and
+	// changed the lock file, or would have for a dry run. It is no failure.
+	exitChanged = 9
+	// exitAdvisory is a locked dependency affected by an advisory of at
+	// least the severity given to audit.
+	exitAdvisory = 10
+	// exitPolicy is a dependency the policy does not allow, see
+	// --allow-source and --policy-hook.
+	exitPolicy = 11
+	// exitInterrupted is jb being interrupted by SIGINT or SIGTERM, like a
+	// shell reports a process killed by SIGINT.
+	exitInterrupted = 130
+)
+
+// exitCode returns the exit code for err, by the first error of its cause
+// chain that has a specific one. Errors caused by an interrupt are
+// exitInterrupted, whatever they are wrapped in.
+func exitCode(err error) int {
+	code := exitFailure
+	for ; err != nil; err = cause(err) {
+		if err == context.Canceled {
+			return exitInterrupted
+		}
+		if code != exitFailure {
+			continue
+		}
+
+		switch err.(type) {
+		case *parser.Error:
+			code = exitUsage
+		case *pkg.LockOutOfSyncError, *client.LockDivergedError, *pkg.VendorMismatchError:
+			code = exitOutOfSync
+		case *pkg.NotFoundError:
+			code = exitNotFound
+		case *pkg.AuthError:
+			code = exitAuth
+		case *pkg.NetworkError, *pkg.RetryableError, *pkg.OfflineError:
+			code = exitNetwork
+		case *pkg.VersionConflictError, *pkg.NameCollisionError, *pkg.NestedNameError:
+			code = exitConflict
+		case *pkg.SumMismatchError, *pkg.SignatureError:
+			code = exitIntegrity
+		case *pkg.PolicyError:
+			code = exitPolicy
+		}
+	}
+	return code
+}
+
+// cause returns the error err wraps, or nil.
+func cause(err error) error {
+	if c, ok := err.(interface{ Cause() error }); ok {
+		return c.Cause()
+	}
+	return nil
+}
+
+// fail prints err and returns the exit code for it. Authentication failures
+// of non-interactive runs tell how to authenticate without prompts, and
+// dependencies that moved upstream how to re-resolve them.
+func fail(err error) int {
+	code := exitCode(err)
+	if nonInteractive && code == exitAuth {
+		kingpin.Errorf("%v\n%s", err, authHint())
+		return code
+	}
+	if moved, ok := pkg.IsMoved(err); ok {
+		kingpin.Errorf("%v\njb install --heal re-resolves %s from %s, keeping all other dependencies locked", err, moved.Name, jsonnetfile.File)
+		return code
+	}
+	kingpin.Errorf("%v", err)
+	return code
+}
@@ -0,0 +1,58 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/parser"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	err := fmt.Errorf("exit status 128")
+	testcases := []struct {
+		Err      error
+		Expected int
+	}{
+		{err, exitFailure},
+		{&parser.Error{Ref: "foo", Part: parser.Host, Reason: "not a host"}, exitUsage},
+		{&pkg.LockOutOfSyncError{Reasons: []string{"foo is not locked"}}, exitOutOfSync},
+		{errors.Wrap(&client.LockDivergedError{}, "failed to update"), exitOutOfSync},
+		{&pkg.VendorMismatchError{}, exitOutOfSync},
+		{errors.Wrap(&pkg.NotFoundError{Err: err}, "downloading foo"), exitNotFound},
+		{&pkg.AuthError{Err: err}, exitAuth},
+		{&pkg.RetryableError{Err: &pkg.NetworkError{Err: err}}, exitNetwork},
+		{&pkg.NetworkError{Err: err}, exitNetwork},
+		{&pkg.VersionConflictError{Name: "foo"}, exitConflict},
+		{&pkg.NameCollisionError{Name: "foo"}, exitConflict},
+		{&pkg.SumMismatchError{Name: "foo"}, exitIntegrity},
+		{&pkg.SignatureError{Name: "foo"}, exitIntegrity},
+		{&pkg.PolicyError{Name: "foo"}, exitPolicy},
+		{&pkg.OfflineError{Name: "foo"}, exitNetwork},
+		{errors.Wrap(&pkg.NetworkError{Err: context.Canceled}, "downloading foo"), exitInterrupted},
+	}
+
+	for _, tc := range testcases {
+		t.Run(fmt.Sprintf("%T", tc.Err), func(t *testing.T) {
+			assert.Equal(t, tc.Expected, exitCode(tc.Err))
+		})
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/pkg/errors"
+)
+
+// exportCommand writes the bundle of the project in dir to filename, or to
+// stdout if it is -, for jb install --from-bundle.
+func exportCommand(dir, filename string, opts client.Options) int {
+	opts.Dir = dir
+
+	var w io.Writer = os.Stdout
+	var f *os.File
+	if filename != "-" {
+		var err error
+		f, err = os.Create(filename)
+		if err != nil {
+			return fail(errors.Wrap(err, "failed to create bundle"))
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err := client.Export(w, opts)
+	if mismatch, ok := err.(*pkg.VendorMismatchError); ok {
+		for _, d := range mismatch.Diff {
+			fmt.Fprintln(os.Stderr, d)
+		}
+	}
+	if err != nil {
+		if f != nil {
+			f.Close()
+			os.Remove(filename)
+		}
+		return fail(errors.Wrap(err, "failed to export"))
+	}
+
+	if filename != "-" {
+		color.Green(">>> Exported the vendor directory and lock file to %s\n", filename)
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// fmtCommand validates the jsonnetfile and the lock file in dir and
+// rewrites them in the canonical format. With check, nothing is written
+// and the files that are not formatted are listed, failing if there are
+// any.
+func fmtCommand(dir string, check bool) int {
+	unformatted := 0
+	for _, name := range []string{jsonnetfile.File, jsonnetfile.LockFile} {
+		filename := filepath.Join(dir, name)
+		b, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fail(errors.Wrapf(err, "failed to read %s", name))
+		}
+
+		formatted, err := jsonnetfile.Format(b)
+		if err != nil {
+			return fail(errors.Wrap(err, name))
+		}
+		if bytes.Equal(b, formatted) {
+			continue
+		}
+
+		unformatted++
+		if check {
+			fmt.Println(name)
+			continue
+		}
+		if err := ioutil.WriteFile(filename, formatted, 0644); err != nil {
+			return fail(errors.Wrapf(err, "failed to write %s", name))
+		}
+		color.Green(">>> Formatted %s\n", name)
+	}
+
+	if check && unformatted > 0 {
+		kingpin.Errorf("%d files are not formatted, run jb fmt", unformatted)
+		return exitFailure
+	}
+	return 0
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// Formats of jb graph.
+const (
+	graphDOT     = "dot"
+	graphMermaid = "mermaid"
+)
+
+// graphNode is a package of the dependency graph, labeled with its name
+// and locked version.
+type graphNode struct {
+	name  string
+	label string
+}
+
+// graphEdge is a dependency of the package from on the package to, labeled
+// with the version it is required at.
+type graphEdge struct {
+	from, to int
+	version  string
+}
+
+// dependencyGraph is the dependency tree of jb list with every package
+// appearing once.
+type dependencyGraph struct {
+	nodes []graphNode
+	edges []graphEdge
+	index map[string]int
+	seen  map[graphEdge]bool
+}
+
+// graphCommand prints the dependency graph of the jsonnetfile in dir in
+// format, DOT or Mermaid.
+func graphCommand(dir, jsonnetHome, format string) int {
+	m, lock, tree, err := dependencyTree(dir, jsonnetHome)
+	if err != nil {
+		return fail(err)
+	}
+
+	root := m.Name
+	if root == "" {
+		root = jsonnetfile.File
+	}
+	g := newDependencyGraph(root, tree, lock)
+	switch format {
+	case graphMermaid:
+		g.writeMermaid(os.Stdout)
+	default:
+		g.writeDOT(os.Stdout)
+	}
+	return 0
+}
+
+// newDependencyGraph returns the graph of tree, the dependencies of the
+// project root, with the versions of lock.
+func newDependencyGraph(root string, tree []listEntry, lock spec.JsonnetFile) *dependencyGraph {
+	locked := make(map[string]string, len(lock.Dependencies))
+	for _, d := range lock.Dependencies {
+		version := d.Tag
+		if version == "" {
+			version = d.Version
+		}
+		if len(version) == 40 {
+			version = version[:12]
+		}
+		locked[d.Name] = version
+	}
+
+	g := &dependencyGraph{index: map[string]int{}, seen: map[graphEdge]bool{}}
+	g.nodes = append(g.nodes, graphNode{name: root, label: root})
+	g.add(0, tree, locked)
+	return g
+}
+
+// add adds the entries as dependencies of the node from, along with their
+// own dependencies.
+func (g *dependencyGraph) add(from int, entries []listEntry, locked map[string]string) {
+	for _, e := range entries {
+		to, ok := g.index[e.Name]
+		if !ok {
+			label := e.Name
+			if v := locked[e.Name]; v != "" {
+				label += " " + v
+			}
+			to = len(g.nodes)
+			g.index[e.Name] = to
+			g.nodes = append(g.nodes, graphNode{name: e.Name, label: label})
+		}
+
+		edge := graphEdge{from: from, to: to, version: e.Version}
+		if g.seen[edge] {
+			continue
+		}
+		g.seen[edge] = true
+		g.edges = append(g.edges, edge)
+		g.add(to, e.Dependencies, locked)
+	}
+}
+
+// writeDOT writes the graph in the DOT language of Graphviz.
+func (g *dependencyGraph) writeDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph dependencies {")
+	for n, node := range g.nodes {
+		shape := ""
+		if n == 0 {
+			shape = ", shape=box"
+		}
+		fmt.Fprintf(w, "  %q [label=%q%s];\n", node.name, node.label, shape)
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(w, "  %q -> %q", g.nodes[e.from].name, g.nodes[e.to].name)
+		if e.version != "" {
+			fmt.Fprintf(w, " [label=%q]", e.version)
+		}
+		fmt.Fprintln(w, ";")
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// writeMermaid writes the graph as a Mermaid flowchart.
+func (g *dependencyGraph) writeMermaid(w io.Writer) {
+	escape := strings.NewReplacer(`"`, "#quot;", "|", "#124;")
+	fmt.Fprintln(w, "graph TD")
+	for n, node := range g.nodes {
+		fmt.Fprintf(w, "  n%d[\"%s\"]\n", n, escape.Replace(node.label))
+	}
+	for _, e := range g.edges {
+		if e.version != "" {
+			fmt.Fprintf(w, "  n%d -->|%s| n%d\n", e.from, escape.Replace(e.version), e.to)
+		} else {
+			fmt.Fprintf(w, "  n%d --> n%d\n", e.from, e.to)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencyGraph(t *testing.T) {
+	foo := listEntry{Name: "foo", Version: "^1.2"}
+	tree := []listEntry{
+		{Name: "bar", Version: "v2", Dependencies: []listEntry{foo}},
+		{Name: "foo", Version: "^1.2"},
+		{Name: "baz", Dependencies: []listEntry{{Name: "bar", Version: "v2", Dependencies: []listEntry{foo}}}},
+	}
+	lock := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		{Name: "foo", Version: "0123456789abcdef0123456789abcdef01234567", Tag: "v1.3.0"},
+		{Name: "bar", Version: "0123456789abcdef0123456789abcdef01234567"},
+	}}
+
+	g := newDependencyGraph("myproject", tree, lock)
+
+	var buf bytes.Buffer
+	g.writeDOT(&buf)
+	assert.Equal(t, `digraph dependencies {
+  "myproject" [label="myproject", shape=box];
+  "bar" [label="bar 0123456789ab"];
+  "foo" [label="foo v1.3.0"];
+  "baz" [label="baz"];
+  "myproject" -> "bar" [label="v2"];
+  "bar" -> "foo" [label="^1.2"];
+  "myproject" -> "foo" [label="^1.2"];
+  "myproject" -> "baz";
+  "baz" -> "bar" [label="v2"];
+}
+`, buf.String())
+
+	buf.Reset()
+	g.writeMermaid(&buf)
+	assert.Equal(t, `graph TD
+  n0["myproject"]
+  n1["bar 0123456789ab"]
+  n2["foo v1.3.0"]
+  n3["baz"]
+  n0 -->|v2| n1
+  n1 -->|^1.2| n2
+  n0 -->|^1.2| n2
+  n0 --> n3
+  n3 -->|v2| n1
+`, buf.String())
+}
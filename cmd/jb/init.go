@@ -15,32 +15,125 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/fatih/color"
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/imports"
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
-func initCommand(dir string) int {
-	exists, err := pkg.FileExists(jsonnetfile.File)
+// initOptions are the flags of jb init.
+type initOptions struct {
+	// FromImport scaffolds dependencies from the imports found in it.
+	FromImport string
+	Name       string
+	LegacyName string
+	// Scaffold writes an empty dependency list and ignores the vendor
+	// directory in .gitignore.
+	Scaffold bool
+	// Force overwrites an existing jsonnetfile.
+	Force bool
+}
+
+func initCommand(dir, jsonnetHome string, opts initOptions) int {
+	filename := filepath.Join(dir, jsonnetfile.File)
+
+	exists, err := pkg.FileExists(filename)
 	if err != nil {
 		kingpin.Errorf("Failed to check for jsonnetfile.json: %v", err)
-		return 1
+		return exitFailure
 	}
 
-	if exists {
-		kingpin.Errorf("jsonnetfile.json already exists")
-		return 1
+	if exists && !opts.Force {
+		kingpin.Errorf("jsonnetfile.json already exists, use --force to overwrite it")
+		return exitFailure
 	}
 
-	filename := filepath.Join(dir, jsonnetfile.File)
+	m := spec.JsonnetFile{Name: opts.Name, LegacyName: opts.LegacyName}
+	if opts.Scaffold {
+		m.Dependencies = []spec.Dependency{}
+	}
+	if opts.FromImport != "" {
+		found, err := imports.Scan(opts.FromImport, filepath.Base(jsonnetHome))
+		if err != nil {
+			kingpin.Errorf("Failed to scan %s for imports: %v", opts.FromImport, err)
+			return exitFailure
+		}
 
-	if err := ioutil.WriteFile(filename, []byte("{}\n"), 0644); err != nil {
+		// Remotes that could not be inferred are left empty, the user is
+		// expected to fill them in before running jb install.
+		m.Dependencies = imports.Dependencies(found, jsonnetHome)
+		for _, d := range m.Dependencies {
+			if d.Source.GitSource.Remote == "" {
+				color.Yellow(">>> Could not infer the remote of %s, please fill it in\n", d.Name)
+			}
+		}
+	}
+
+	content := []byte("{}\n")
+	if m.Name != "" || m.LegacyName != "" || m.Dependencies != nil {
+		b, err := json.MarshalIndent(m, "", "    ")
+		if err != nil {
+			kingpin.Errorf("Failed to encode jsonnetfile.json: %v", err)
+			return exitFailure
+		}
+		content = append(b, []byte("\n")...)
+	}
+
+	if err := ioutil.WriteFile(filename, content, 0644); err != nil {
 		kingpin.Errorf("Failed to write new jsonnetfile.json: %v", err)
-		return 1
+		return exitFailure
+	}
+
+	if opts.Scaffold {
+		if err := ignoreVendor(dir, jsonnetHome); err != nil {
+			kingpin.Errorf("Failed to update .gitignore: %v", err)
+			return exitFailure
+		}
 	}
 
 	return 0
 }
+
+// ignoreVendor adds jsonnetHome to the .gitignore of dir, unless it is
+// already ignored there or lies outside of dir.
+func ignoreVendor(dir, jsonnetHome string) error {
+	if filepath.IsAbs(jsonnetHome) {
+		rel, err := filepath.Rel(dir, jsonnetHome)
+		if err != nil {
+			return nil
+		}
+		jsonnetHome = rel
+	}
+	if rel := filepath.ToSlash(filepath.Clean(jsonnetHome)); rel == ".." || strings.HasPrefix(rel, "../") {
+		return nil
+	}
+	entry := "/" + strings.Trim(filepath.ToSlash(filepath.Clean(jsonnetHome)), "/") + "/"
+
+	filename := filepath.Join(dir, ".gitignore")
+	b, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == entry || "/"+strings.Trim(line, "/")+"/" == entry {
+			return nil
+		}
+	}
+
+	if len(b) > 0 && !bytes.HasSuffix(b, []byte("\n")) {
+		b = append(b, '\n')
+	}
+	b = append(b, []byte(entry+"\n")...)
+	return ioutil.WriteFile(filename, b, 0644)
+}
@@ -19,6 +19,7 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -31,6 +32,38 @@ func TestInitCommand(t *testing.T) {
 	}
 	defer os.Remove(tempDir)
 
-	code := initCommand(tempDir)
+	code := initCommand(tempDir, "vendor", initOptions{})
 	assert.Equal(t, 0, code)
 }
+
+func TestInitCommandScaffold(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-init")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	err = ioutil.WriteFile(filepath.Join(tempDir, ".gitignore"), []byte("*.o"), 0644)
+	assert.NoError(t, err)
+
+	opts := initOptions{Name: "mylib", Scaffold: true}
+	assert.Equal(t, 0, initCommand(tempDir, "vendor", opts))
+
+	b, err := ioutil.ReadFile(filepath.Join(tempDir, "jsonnetfile.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n    \"name\": \"mylib\",\n    \"dependencies\": []\n}\n", string(b))
+
+	b, err = ioutil.ReadFile(filepath.Join(tempDir, ".gitignore"))
+	assert.NoError(t, err)
+	assert.Equal(t, "*.o\n/vendor/\n", string(b))
+
+	// An existing jsonnetfile is only overwritten with --force, and the
+	// vendor directory is ignored once.
+	assert.Equal(t, 1, initCommand(tempDir, "vendor", opts))
+	opts.Force = true
+	assert.Equal(t, 0, initCommand(tempDir, "vendor", opts))
+
+	b, err = ioutil.ReadFile(filepath.Join(tempDir, ".gitignore"))
+	assert.NoError(t, err)
+	assert.Equal(t, "*.o\n/vendor/\n", string(b))
+}
@@ -15,113 +15,236 @@
 package main
 
 import (
+	"bufio"
 	"context"
-	"encoding/json"
-	"io/ioutil"
-	"net/url"
+	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"strings"
 
+	"github.com/fatih/color"
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
-	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
-	"gopkg.in/alecthomas/kingpin.v2"
+	"github.com/pkg/errors"
 )
 
-func installCommand(dir, jsonnetHome string, urls ...*url.URL) int {
-	if dir == "" {
-		dir = "."
-	}
+// installCommand adds the packages of urls to the jsonnetfile in dir and
+// installs all its dependencies. A single package may be installed under
+// another name, and the packages added with their submodules. Repositories
+// holding nested packages are expanded into them, see expandNested. With
+// groups, only the locked dependencies in them are installed. With heal,
+// the locked dependencies that moved upstream are re-resolved.
+func installCommand(ctx context.Context, dir, name string, submodules, expand, heal bool, groups []string, opts client.Options, urls ...string) int {
+	opts.Dir = dir
 
-	filename, isLock, err := jsonnetfile.Choose(dir)
+	deps, err := parseDependencies(name, submodules, urls)
+	if err != nil {
+		return fail(err)
+	}
+	committed, err := committedGroups(dir, groups)
 	if err != nil {
-		kingpin.Fatalf("failed to choose jsonnetfile: %v", err)
-		return 1
+		return fail(err)
 	}
 
-	jsonnetFile, err := jsonnetfile.Load(filename)
+	lock, err := client.Install(ctx, client.InstallOptions{Options: opts, Dependencies: deps, Expand: expandNested(expand), Groups: groups, Heal: heal})
 	if err != nil {
-		kingpin.Fatalf("failed to load jsonnetfile: %v", err)
-		return 1
-	}
-
-	if len(urls) > 0 {
-		for _, url := range urls {
-			// install package specified in command
-			// $ jsonnetpkg install ksonnet git@github.com:ksonnet/ksonnet-lib
-			// $ jsonnetpkg install grafonnet git@github.com:grafana/grafonnet-lib grafonnet
-			// $ jsonnetpkg install github.com/grafana/grafonnet-lib/grafonnet
-			//
-			// github.com/(slug)/(dir)
-
-			urlString := url.String()
-			newDep := parseDepedency(urlString)
-			if newDep == nil {
-				kingpin.Errorf("ignoring unrecognized url: %s", url)
-				continue
-			}
-
-			oldDeps := jsonnetFile.Dependencies
-			newDeps := []spec.Dependency{}
-			oldDepReplaced := false
-			for _, d := range oldDeps {
-				if d.Name == newDep.Name {
-					newDeps = append(newDeps, *newDep)
-					oldDepReplaced = true
-				} else {
-					newDeps = append(newDeps, d)
-				}
-			}
-
-			if !oldDepReplaced {
-				newDeps = append(newDeps, *newDep)
-			}
-
-			jsonnetFile.Dependencies = newDeps
-		}
+		return fail(err)
 	}
+	output.setLock(opts.JsonnetHome, *lock)
 
-	srcPath := filepath.Join(jsonnetHome)
-	err = os.MkdirAll(srcPath, os.ModePerm)
+	return printChanges(committed, *lock, opts.DryRun)
+}
+
+// singleInstallCommand vendors the packages of urls into the vendor
+// directory of dir without adding them to the jsonnetfile or the lock file,
+// marking them as unmanaged.
+func singleInstallCommand(ctx context.Context, dir, name string, submodules, expand bool, opts client.Options, urls ...string) int {
+	opts.Dir = dir
+
+	deps, err := parseDependencies(name, submodules, urls)
 	if err != nil {
-		kingpin.Fatalf("failed to create jsonnet home path: %v", err)
-		return 3
+		return fail(err)
 	}
 
-	lock, err := pkg.Install(context.TODO(), isLock, filename, jsonnetFile, jsonnetHome)
+	lock, err := client.Install(ctx, client.InstallOptions{Options: opts, Dependencies: deps, Single: true, Expand: expandNested(expand)})
 	if err != nil {
-		kingpin.Fatalf("failed to install: %v", err)
-		return 3
+		return fail(err)
+	}
+	output.setLock(opts.JsonnetHome, *lock)
+	// Unmanaged packages are not part of the lock file, all of them would
+	// be added.
+	if opts.DryRun {
+		printLockChanges(client.Changes(spec.JsonnetFile{}, *lock), true)
+		return 0
 	}
+	color.Yellow(">>> Vendored %d unmanaged packages, jb install --prune removes them\n", len(lock.Dependencies))
 
-	// If installing from lock file there is no need to write any files back.
-	if !isLock {
-		b, err := json.MarshalIndent(jsonnetFile, "", "    ")
+	return 0
+}
+
+// parseDependencies returns the dependencies of the package references
+// urls, the single one named name if set, initializing the submodules of
+// git dependencies if submodules is set. The first invalid reference fails
+// with a *parser.Error explaining what is wrong with it.
+func parseDependencies(name string, submodules bool, urls []string) ([]spec.Dependency, error) {
+	deps := []spec.Dependency{}
+	for _, url := range urls {
+		// install package specified in command
+		// $ jsonnetpkg install ksonnet git@github.com:ksonnet/ksonnet-lib
+		// $ jsonnetpkg install grafonnet git@github.com:grafana/grafonnet-lib grafonnet
+		// $ jsonnetpkg install github.com/grafana/grafonnet-lib/grafonnet
+		//
+		// github.com/(slug)/(dir)
+		newDep, err := parseDepedency(url)
 		if err != nil {
-			kingpin.Fatalf("failed to encode jsonnet file: %v", err)
-			return 3
+			return nil, err
+		}
+		if name != "" {
+			newDep.Name = name
 		}
-		b = append(b, []byte("\n")...)
+		if submodules && newDep.Source.GitSource != nil {
+			newDep.Submodules = true
+		}
+		deps = append(deps, *newDep)
+	}
+	return deps, nil
+}
 
-		err = ioutil.WriteFile(filepath.Join(dir, jsonnetfile.File), b, 0644)
-		if err != nil {
-			kingpin.Fatalf("failed to write jsonnet file: %v", err)
-			return 3
+// packageList reads the packages listed in filename, or on stdin if it is
+// -, see readPackageList.
+func packageList(filename string) ([]string, error) {
+	if filename == "-" {
+		return readPackageList(os.Stdin)
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read package list")
+	}
+	defer f.Close()
+	packages, err := readPackageList(f)
+	return packages, errors.Wrapf(err, "invalid package list %s", filename)
+}
+
+// readPackageList returns the packages listed in r, one package reference
+// per line. Empty lines are skipped and comments, starting with #, run to
+// the end of the line.
+func readPackageList(r io.Reader) ([]string, error) {
+	packages := []string{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		switch len(fields) {
+		case 0:
+			continue
+		case 1:
+			packages = append(packages, fields[0])
+		default:
+			return nil, fmt.Errorf("line %d lists more than one package: %s", n, strings.Join(fields, " "))
 		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(packages) == 0 {
+		return nil, errors.New("no packages listed")
+	}
+	return packages, nil
+}
 
-		b, err = json.MarshalIndent(lock, "", "    ")
-		if err != nil {
-			kingpin.Fatalf("failed to encode jsonnet file: %v", err)
-			return 3
+// expandNested returns whether the packages added on the root of a
+// repository holding nested packages are installed as those packages, see
+// client.InstallOptions: always with --expand, otherwise as asked on a
+// terminal. Without one, or with --json, repositories are installed as a
+// whole without looking for nested packages.
+func expandNested(expand bool) func(spec.Dependency, []spec.Dependency) bool {
+	if expand {
+		return func(spec.Dependency, []spec.Dependency) bool { return true }
+	}
+	if nonInteractive || output != nil {
+		return nil
+	}
+	return func(dep spec.Dependency, nested []spec.Dependency) bool {
+		subdirs := []string{}
+		for _, d := range nested {
+			subdirs = append(subdirs, d.Source.GitSource.Subdir)
 		}
-		b = append(b, []byte("\n")...)
+		return confirm(os.Stdin, os.Stderr, fmt.Sprintf("%s holds %d packages: %s. Install them individually instead of the whole repository?", dep.Name, len(nested), strings.Join(subdirs, ", ")))
+	}
+}
 
-		err = ioutil.WriteFile(filepath.Join(dir, jsonnetfile.LockFile), b, 0644)
-		if err != nil {
-			kingpin.Fatalf("failed to write lock file: %v", err)
-			return 3
+// frozenInstallCommand installs exactly what the lock file in dir
+// describes, failing if there is no lock file or if it is out of sync with
+// the jsonnetfile. Neither file is written. With groups, only the locked
+// dependencies in them are installed.
+func frozenInstallCommand(ctx context.Context, dir string, groups []string, opts client.Options) int {
+	opts.Dir = dir
+	committed, err := committedGroups(dir, groups)
+	if err != nil {
+		return fail(err)
+	}
+
+	lock, err := client.Install(ctx, client.InstallOptions{Options: opts, Frozen: true, Groups: groups})
+	if err != nil {
+		return fail(err)
+	}
+	output.setLock(opts.JsonnetHome, *lock)
+
+	return printChanges(committed, *lock, opts.DryRun)
+}
+
+// targetInstallCommand installs the target of the jsonnetfile in dir into
+// its vendor directory, failing with frozen if the lock file does not
+// record it in sync with the jsonnetfile.
+func targetInstallCommand(ctx context.Context, dir, target string, frozen bool, opts client.Options) int {
+	opts.Dir = dir
+	committed, err := committedLock(dir)
+	if err != nil {
+		return fail(err)
+	}
+
+	lock, err := client.Install(ctx, client.InstallOptions{Options: opts, Frozen: frozen, Target: target})
+	if err != nil {
+		return fail(err)
+	}
+	output.setLock(opts.JsonnetHome, *lock)
+
+	return printChanges(client.TargetLock(committed, target), *lock, opts.DryRun)
+}
+
+// repairInstallCommand reinstalls the packages of the lock file in dir
+// that are missing from the vendor directory or were modified.
+func repairInstallCommand(ctx context.Context, dir string, opts client.Options) int {
+	opts.Dir = dir
+
+	lock, err := client.Install(ctx, client.InstallOptions{Options: opts, Repair: true})
+	if err != nil {
+		return fail(err)
+	}
+	output.setLock(opts.JsonnetHome, *lock)
+
+	return 0
+}
+
+// bundleInstallCommand installs the bundle filename, written by jb export,
+// into the project in dir without fetching anything.
+func bundleInstallCommand(ctx context.Context, dir, filename string, opts client.Options) int {
+	opts.Dir = dir
+
+	lock, err := client.Install(ctx, client.InstallOptions{Options: opts, FromBundle: filename})
+	if mismatch, ok := errors.Cause(err).(*pkg.VendorMismatchError); ok {
+		for _, d := range mismatch.Diff {
+			fmt.Println(d)
 		}
 	}
+	if err != nil {
+		return fail(err)
+	}
+	output.setLock(opts.JsonnetHome, *lock)
 
 	return 0
 }
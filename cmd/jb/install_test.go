@@ -17,12 +17,13 @@
 package main
 
 import (
+	"context"
 	"io/ioutil"
-	"net/url"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
 	"github.com/stretchr/testify/assert"
 )
@@ -30,7 +31,7 @@ import (
 func TestInstallCommand(t *testing.T) {
 	testcases := []struct {
 		Name                    string
-		URLs                    []*url.URL
+		URLs                    []string
 		ExpectedCode            int
 		ExpectedJsonnetFile     []byte
 		ExpectedJsonnetLockFile []byte
@@ -42,13 +43,7 @@ func TestInstallCommand(t *testing.T) {
 			ExpectedJsonnetLockFile: []byte(`{"dependencies":null}`),
 		}, {
 			Name: "OneURL",
-			URLs: []*url.URL{
-				{
-					Scheme: "https",
-					Host:   "github.com",
-					Path:   "jsonnet-bundler/jsonnet-bundler@v0.1.0",
-				},
-			},
+			URLs: []string{"https://github.com/jsonnet-bundler/jsonnet-bundler@v0.1.0"},
 			ExpectedCode:            0,
 			ExpectedJsonnetFile:     []byte(`{"dependencies": [{"name": "jsonnet-bundler", "source": {"git": {"remote": "https://github.com/jsonnet-bundler/jsonnet-bundler", "subdir": ""}}, "version": "v0.1.0"}]}`),
 			ExpectedJsonnetLockFile: []byte(`{"dependencies": [{"name": "jsonnet-bundler", "source": {"git": {"remote": "https://github.com/jsonnet-bundler/jsonnet-bundler", "subdir": ""}}, "version": "080f157c7fb85ad0281ea78f6c641eaa570a582f"}]}`),
@@ -59,18 +54,17 @@ func TestInstallCommand(t *testing.T) {
 		t.Run(tc.Name, func(t *testing.T) {
 			tempDir, err := ioutil.TempDir("", "jb-install")
 			assert.NoError(t, err)
-			defer os.Remove(tempDir)
-			defer os.RemoveAll("vendor") // delete test vendor folder
+			defer os.RemoveAll(tempDir)
 
 			jsonnetFile := filepath.Join(tempDir, jsonnetfile.File)
 			jsonnetLockFile := filepath.Join(tempDir, jsonnetfile.LockFile)
 
-			code := initCommand(tempDir)
+			code := initCommand(tempDir, "vendor", initOptions{})
 			assert.Equal(t, 0, code)
 
 			jsonnetFileContent(t, jsonnetFile, []byte(`{}`))
 
-			code = installCommand(tempDir, "vendor", tc.URLs...)
+			code = installCommand(context.TODO(), tempDir, "", false, false, false, nil, client.Options{JsonnetHome: "vendor"}, tc.URLs...)
 			assert.Equal(t, tc.ExpectedCode, code)
 
 			jsonnetFileContent(t, jsonnetFile, tc.ExpectedJsonnetFile)
@@ -78,11 +72,3 @@ func TestInstallCommand(t *testing.T) {
 		})
 	}
 }
-
-func jsonnetFileContent(t *testing.T, filename string, content []byte) {
-	bytes, err := ioutil.ReadFile(filename)
-	assert.NoError(t, err)
-	if eq := assert.JSONEq(t, string(content), string(bytes)); !eq {
-		t.Log(string(bytes))
-	}
-}
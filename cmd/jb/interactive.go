@@ -0,0 +1,106 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+)
+
+// githubActionsEnv is set to true by GitHub Actions in the environment of
+// the steps of a workflow.
+const githubActionsEnv = "GITHUB_ACTIONS"
+
+// nonInteractive is set by --non-interactive, which defaults to true when
+// stdin is not a terminal. git and ssh then fail instead of prompting, see
+// setNonInteractive.
+var nonInteractive = false
+
+// setNonInteractive keeps the git and ssh processes of jb from prompting for
+// credentials or for the confirmation of unknown host keys, which would hang
+// in CI until timed out. They fail right away instead.
+func setNonInteractive() {
+	os.Setenv("GIT_TERMINAL_PROMPT", "0")
+	os.Setenv("GCM_INTERACTIVE", "never")
+	// A GIT_SSH program is left alone, it takes no ssh options.
+	if os.Getenv("GIT_SSH") == "" {
+		ssh := os.Getenv("GIT_SSH_COMMAND")
+		if ssh == "" {
+			ssh = "ssh"
+		}
+		if !strings.Contains(ssh, "BatchMode") {
+			os.Setenv("GIT_SSH_COMMAND", ssh+" -o BatchMode=yes")
+		}
+	}
+}
+
+// confirm asks question on out and reports whether it was answered with yes
+// on in. Anything else, like an empty answer, is no.
+func confirm(in io.Reader, out io.Writer, question string) bool {
+	fmt.Fprintf(out, "? %s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// authHint tells how to authenticate without prompts, for authentication
+// failures of non-interactive runs.
+func authHint() string {
+	return fmt.Sprintf("jb does not prompt for credentials when run non-interactively: set %s and %s for the hosts of %s, %s or %s, or %s and %s for SSH remotes",
+		pkg.GitUsernameEnv, pkg.GitPasswordEnv, pkg.GitHostsEnv, pkg.GithubTokenEnv, pkg.GitlabTokenEnv, pkg.SSHKeyEnv, pkg.SSHKnownHostsEnv)
+}
+
+// annotations returns w, turning the errors kingpin writes to it into error
+// annotations of GitHub Actions when running in a workflow.
+func annotations(w io.Writer) io.Writer {
+	if os.Getenv(githubActionsEnv) != "true" {
+		return w
+	}
+	return &annotationWriter{w: w}
+}
+
+// annotationWriter rewrites lines like "jb: error: message" to
+// "::error::message", which GitHub Actions shows on the summary of the run.
+// kingpin writes every error with a single Write.
+type annotationWriter struct {
+	w io.Writer
+}
+
+func (a *annotationWriter) Write(b []byte) (int, error) {
+	s := string(b)
+	i := strings.Index(s, ": error: ")
+	if i < 0 {
+		return a.w.Write(b)
+	}
+	msg := strings.TrimSuffix(s[i+len(": error: "):], "\n")
+	if _, err := fmt.Fprintf(a.w, "::error::%s\n", escapeAnnotation(msg)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// escapeAnnotation escapes the characters that end or corrupt the message of
+// a workflow command.
+func escapeAnnotation(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
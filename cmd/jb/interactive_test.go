@@ -0,0 +1,65 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetNonInteractive(t *testing.T) {
+	for _, env := range []string{"GIT_TERMINAL_PROMPT", "GCM_INTERACTIVE", "GIT_SSH", "GIT_SSH_COMMAND"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	os.Setenv("GIT_SSH", "")
+	os.Setenv("GIT_SSH_COMMAND", "ssh -i key")
+
+	setNonInteractive()
+	assert.Equal(t, "0", os.Getenv("GIT_TERMINAL_PROMPT"))
+	assert.Equal(t, "never", os.Getenv("GCM_INTERACTIVE"))
+	assert.Equal(t, "ssh -i key -o BatchMode=yes", os.Getenv("GIT_SSH_COMMAND"))
+
+	// Only added once.
+	setNonInteractive()
+	assert.Equal(t, "ssh -i key -o BatchMode=yes", os.Getenv("GIT_SSH_COMMAND"))
+}
+
+func TestAnnotations(t *testing.T) {
+	defer os.Setenv(githubActionsEnv, os.Getenv(githubActionsEnv))
+
+	os.Setenv(githubActionsEnv, "")
+	b := bytes.NewBuffer(nil)
+	fmt.Fprint(annotations(b), "jb: error: failed\n")
+	assert.Equal(t, "jb: error: failed\n", b.String())
+
+	os.Setenv(githubActionsEnv, "true")
+	b.Reset()
+	w := annotations(b)
+	fmt.Fprint(w, "jb: error: failed at 100%\nhint\n")
+	fmt.Fprint(w, "other output\n")
+	assert.Equal(t, "::error::failed at 100%25%0Ahint\nother output\n", b.String())
+}
+
+func TestConfirm(t *testing.T) {
+	for answer, want := range map[string]bool{"y\n": true, "Yes\n": true, "n\n": false, "\n": false, "": false} {
+		out := bytes.NewBuffer(nil)
+		assert.Equal(t, want, confirm(bytes.NewBufferString(answer), out, "Proceed?"), answer)
+		assert.Equal(t, "? Proceed? [y/N] ", out.String())
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/pkg/errors"
+)
+
+// jsonnetPathEnv is the environment variable jsonnet reads additional
+// library directories from, searched after those given with -J.
+const jsonnetPathEnv = "JSONNET_PATH"
+
+// envrcFile is the file direnv loads when entering a directory.
+const envrcFile = ".envrc"
+
+// jpathCommand prints the -J flags jsonnet needs for the vendor layout of
+// the project in dir, see pkg.Jpath. With env it prints the value of
+// JSONNET_PATH instead, keeping the directories already in it, and with
+// envrc it sets JSONNET_PATH in the .envrc of dir.
+func jpathCommand(dir, jsonnetHome string, env, envrc bool) int {
+	jpath, err := projectJpath(dir, jsonnetHome)
+	if err != nil {
+		return fail(err)
+	}
+	// Paths are printed the way jsonnetHome is given, relative to dir
+	// unless absolute.
+	if !filepath.IsAbs(jsonnetHome) {
+		for i, p := range jpath {
+			if rel, err := filepath.Rel(dir, p); err == nil {
+				jpath[i] = rel
+			}
+		}
+	}
+
+	switch {
+	case envrc:
+		filename := filepath.Join(dir, envrcFile)
+		if err := writeEnvrc(filename, jpath); err != nil {
+			return fail(errors.Wrapf(err, "failed to write %s", filename))
+		}
+		color.Green(">>> Set %s in %s, run direnv allow to load it\n", jsonnetPathEnv, envrcFile)
+	case env:
+		fmt.Println(strings.Join(append(jpath, envJpath()...), string(os.PathListSeparator)))
+	default:
+		flags := make([]string, 0, len(jpath))
+		for _, p := range jpath {
+			flags = append(flags, "-J "+p)
+		}
+		fmt.Println(strings.Join(flags, " "))
+	}
+	return 0
+}
+
+// projectJpath returns pkg.Jpath for the lock file in dir and its vendor
+// directory jsonnetHome, relative to dir unless absolute. Projects without
+// a lock file only need the vendor directory.
+func projectJpath(dir, jsonnetHome string) ([]string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if !filepath.IsAbs(jsonnetHome) {
+		jsonnetHome = filepath.Join(dir, jsonnetHome)
+	}
+
+	lock, err := pkg.LoadJsonnetfile(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to load lock file")
+	}
+	return pkg.Jpath(jsonnetHome, lock), nil
+}
+
+// envJpath returns the directories of JSONNET_PATH.
+func envJpath() []string {
+	res := []string{}
+	for _, p := range filepath.SplitList(os.Getenv(jsonnetPathEnv)) {
+		if p != "" {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+// writeEnvrc sets JSONNET_PATH to jpath, relative to the directory of
+// filename, followed by the value it had before, replacing an earlier
+// export of JSONNET_PATH so the file can be rewritten after the layout
+// changed. Other lines are kept.
+func writeEnvrc(filename string, jpath []string) error {
+	dirs := make([]string, 0, len(jpath))
+	for _, p := range jpath {
+		if !filepath.IsAbs(p) {
+			p = "$PWD/" + filepath.ToSlash(p)
+		}
+		dirs = append(dirs, p)
+	}
+	prefix := "export " + jsonnetPathEnv + "="
+	export := fmt.Sprintf(`%s"%s${%s:+:$%s}"`, prefix, strings.Join(dirs, ":"), jsonnetPathEnv, jsonnetPathEnv)
+
+	content, err := ioutil.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := []string{}
+	replaced := false
+	for _, line := range strings.Split(strings.TrimRight(string(content), "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, prefix) && !replaced:
+			lines = append(lines, export)
+			replaced = true
+		case strings.HasPrefix(line, prefix):
+		case line != "" || len(lines) > 0:
+			lines = append(lines, line)
+		}
+	}
+	if !replaced {
+		lines = append(lines, export)
+	}
+
+	return ioutil.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+}
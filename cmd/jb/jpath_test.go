@@ -0,0 +1,48 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteEnvrc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-envrc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, envrcFile)
+
+	assert.NoError(t, writeEnvrc(filename, []string{"vendor"}))
+	b, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, `export JSONNET_PATH="$PWD/vendor${JSONNET_PATH:+:$JSONNET_PATH}"
+`, string(b))
+
+	// The export is replaced, other lines are kept.
+	assert.NoError(t, ioutil.WriteFile(filename, append([]byte("export FOO=bar\n"), b...), 0644))
+	assert.NoError(t, writeEnvrc(filename, []string{"vendor", filepath.Join("vendor", "github.com", "a"), "/opt/jsonnet"}))
+	b, err = ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, `export FOO=bar
+export JSONNET_PATH="$PWD/vendor:$PWD/vendor/github.com/a:/opt/jsonnet${JSONNET_PATH:+:$JSONNET_PATH}"
+`, string(b))
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/sbom"
+	"github.com/pkg/errors"
+)
+
+// lockedPackages returns the packages of the lock file in dir with their
+// licenses.
+func lockedPackages(dir, jsonnetHome string) ([]sbom.Package, error) {
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load lock file")
+	}
+
+	if !filepath.IsAbs(jsonnetHome) {
+		jsonnetHome = filepath.Join(dir, jsonnetHome)
+	}
+	return sbom.Packages(jsonnetHome, lock), nil
+}
+
+// licensesCommand prints the license and source of every locked package.
+func licensesCommand(dir, jsonnetHome string) int {
+	if dir == "" {
+		dir = "."
+	}
+
+	packages, err := lockedPackages(dir, jsonnetHome)
+	if err != nil {
+		return fail(err)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tLICENSE\tSOURCE")
+	for _, p := range packages {
+		version := p.Tag
+		if version == "" {
+			version = shortCommit(p.Version)
+		}
+		license := p.License
+		if license == "" {
+			license = "unknown"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Name, version, license, p.Source)
+	}
+	w.Flush()
+
+	return 0
+}
+
+// sbomCommand prints a software bill of materials of the locked packages.
+func sbomCommand(dir, jsonnetHome string, format sbom.Format) int {
+	if dir == "" {
+		dir = "."
+	}
+
+	name := filepath.Base(dir)
+	if abs, err := filepath.Abs(dir); err == nil {
+		name = filepath.Base(abs)
+	}
+	if jf, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File)); err == nil && jf.Name != "" {
+		name = jf.Name
+	}
+
+	packages, err := lockedPackages(dir, jsonnetHome)
+	if err != nil {
+		return fail(err)
+	}
+	if err := sbom.Write(os.Stdout, format, name, packages, time.Now()); err != nil {
+		return fail(errors.Wrap(err, "failed to write bill of materials"))
+	}
+
+	return 0
+}
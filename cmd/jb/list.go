@@ -0,0 +1,166 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// listEntry is a dependency in the output of jb list.
+type listEntry struct {
+	Name         string      `json:"name"`
+	Source       string      `json:"source"`
+	Version      string      `json:"version"`
+	Locked       string      `json:"locked,omitempty"`
+	Comment      string      `json:"comment,omitempty"`
+	Owner        string      `json:"owner,omitempty"`
+	Link         string      `json:"link,omitempty"`
+	Dependencies []listEntry `json:"dependencies,omitempty"`
+}
+
+// listCommand prints the dependency tree of the jsonnetfile in dir, with the
+// versions resolved in the lock file. Transitive dependencies are read from
+// the jsonnetfiles of the vendored packages.
+func listCommand(dir, jsonnetHome string) int {
+	_, _, tree, err := dependencyTree(dir, jsonnetHome)
+	if err != nil {
+		return fail(err)
+	}
+
+	if output != nil {
+		output.result.Tree = tree
+		return 0
+	}
+
+	printEntries(os.Stdout, tree, 0)
+	return 0
+}
+
+// dependencyTree loads the jsonnetfile and lock file in dir and returns
+// them with the dependency tree of the jsonnetfile.
+func dependencyTree(dir, jsonnetHome string) (m, lock spec.JsonnetFile, tree []listEntry, err error) {
+	if dir == "" {
+		dir = "."
+	}
+
+	m, err = jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
+	if err != nil {
+		return m, lock, nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	lock, err = pkg.LoadJsonnetfile(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil && !os.IsNotExist(err) {
+		return m, lock, nil, errors.Wrap(err, "failed to load lock file")
+	}
+	locked := make(map[string]spec.Dependency, len(lock.Dependencies))
+	for _, d := range lock.Dependencies {
+		locked[d.Name] = d
+	}
+
+	if !filepath.IsAbs(jsonnetHome) {
+		jsonnetHome = filepath.Join(dir, jsonnetHome)
+	}
+	tree = listEntries(pkg.ExpandLockedSubdirs(m.Dependencies, lock.Dependencies), locked, jsonnetHome, nil)
+	return m, lock, tree, nil
+}
+
+// listEntries builds the tree of deps, chain holding the names of the
+// packages leading to deps so that cycles are only listed once.
+func listEntries(deps []spec.Dependency, locked map[string]spec.Dependency, jsonnetHome string, chain []string) []listEntry {
+	entries := make([]listEntry, 0, len(deps))
+	for _, d := range deps {
+		e := listEntry{
+			Name:    d.Name,
+			Source:  pkg.SourceString(d.Source),
+			Version: d.Version,
+			Comment: d.Comment,
+			Owner:   d.Owner,
+			Link:    d.Link,
+		}
+		l, ok := locked[d.Name]
+		if ok {
+			e.Locked = l.Version
+		}
+
+		if !inChain(chain, d.Name) {
+			if sub, err := pkg.LoadJsonnetfile(filepath.Join(vendoredPath(jsonnetHome, d, l), jsonnetfile.File)); err == nil {
+				e.Dependencies = listEntries(sub.Dependencies, locked, jsonnetHome, append(chain[:len(chain):len(chain)], d.Name))
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// vendoredPath returns where dep is vendored, which includes its subdir if
+// the lock records it was not flattened.
+func vendoredPath(jsonnetHome string, dep, locked spec.Dependency) string {
+	p := filepath.Join(jsonnetHome, dep.Name)
+	if locked.Flatten == nil || *locked.Flatten {
+		return p
+	}
+	switch {
+	case dep.Source.GitSource != nil:
+		p = filepath.Join(p, dep.Source.GitSource.Subdir)
+	case dep.Source.ArchiveSource != nil:
+		p = filepath.Join(p, dep.Source.ArchiveSource.Subdir)
+	case dep.Source.HgSource != nil:
+		p = filepath.Join(p, dep.Source.HgSource.Subdir)
+	}
+	return p
+}
+
+func printEntries(w io.Writer, entries []listEntry, depth int) {
+	for _, e := range entries {
+		version := e.Version
+		if e.Locked != "" && e.Locked != e.Version {
+			locked := e.Locked
+			if len(locked) > 12 {
+				locked = locked[:12]
+			}
+			version = fmt.Sprintf("%s (%s)", version, locked)
+		}
+		if version == "" {
+			version = "-"
+		}
+
+		comment := ""
+		if e.Comment != "" {
+			comment = "  # " + e.Comment
+		}
+		fmt.Fprintf(w, "%s%s %s %s%s\n", strings.Repeat("  ", depth), e.Name, version, e.Source, comment)
+		printEntries(w, e.Dependencies, depth+1)
+	}
+}
+
+func inChain(chain []string, name string) bool {
+	for _, c := range chain {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
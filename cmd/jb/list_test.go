@@ -0,0 +1,93 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListEntries(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	foo := spec.Dependency{
+		Name:    "foo",
+		Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/org/foo"}},
+		Version: "master",
+	}
+	bar := spec.Dependency{
+		Name:    "bar",
+		Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/org/bar", Subdir: "lib"}},
+		Version: "v1",
+	}
+
+	// foo depends on bar, which depends on foo again.
+	vendor := filepath.Join(tempDir, "vendor")
+	assert.NoError(t, os.MkdirAll(filepath.Join(vendor, "foo"), os.ModePerm))
+	assert.NoError(t, os.MkdirAll(filepath.Join(vendor, "bar"), os.ModePerm))
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(vendor, "foo", jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{bar}}))
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(vendor, "bar", jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{foo}}))
+
+	locked := map[string]spec.Dependency{
+		"foo": {Name: "foo", Version: "0123456789abcdef0123456789abcdef01234567"},
+		"bar": {Name: "bar", Version: "v1"},
+	}
+
+	tree := listEntries([]spec.Dependency{foo}, locked, vendor, nil)
+	assert.Equal(t, []listEntry{{
+		Name:    "foo",
+		Source:  "https://github.com/org/foo",
+		Version: "master",
+		Locked:  "0123456789abcdef0123456789abcdef01234567",
+		Dependencies: []listEntry{{
+			Name:    "bar",
+			Source:  "https://github.com/org/bar/lib",
+			Version: "v1",
+			Locked:  "v1",
+			Dependencies: []listEntry{{
+				Name:    "foo",
+				Source:  "https://github.com/org/foo",
+				Version: "master",
+				Locked:  "0123456789abcdef0123456789abcdef01234567",
+			}},
+		}},
+	}}, tree)
+
+	var buf bytes.Buffer
+	printEntries(&buf, tree, 0)
+	assert.Equal(t, `foo master (0123456789ab) https://github.com/org/foo
+  bar v1 https://github.com/org/bar/lib
+    foo master (0123456789ab) https://github.com/org/foo
+`, buf.String())
+
+	// Comments are printed after the source.
+	foo.Comment = "for the dashboards"
+	tree = listEntries([]spec.Dependency{foo}, nil, filepath.Join(tempDir, "empty"), nil)
+	assert.Equal(t, "for the dashboards", tree[0].Comment)
+	buf.Reset()
+	printEntries(&buf, tree, 0)
+	assert.Equal(t, "foo master https://github.com/org/foo  # for the dashboards\n", buf.String())
+}
@@ -16,242 +16,833 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"net/url"
 	"os"
-	"path"
+	"os/signal"
 	"path/filepath"
-	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/fatih/color"
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/advisory"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/registry"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/sbom"
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/mattn/go-isatty"
 	"github.com/pkg/errors"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 const (
-	installActionName = "install"
-	updateActionName  = "update"
-	initActionName    = "init"
-	basePath          = ".jsonnetpkg"
-	srcDirName        = "src"
+	installActionName       = "install"
+	updateActionName        = "update"
+	upgradeActionName       = "upgrade"
+	syncActionName          = "sync"
+	initActionName          = "init"
+	removeActionName        = "rm"
+	pinActionName           = "pin"
+	unpinActionName         = "unpin"
+	cacheActionName         = "cache"
+	listActionName          = "list"
+	whyActionName           = "why"
+	graphActionName         = "graph"
+	rewriteActionName       = "rewrite-imports"
+	checkActionName         = "check-imports"
+	jpathActionName         = "jpath"
+	execActionName          = "exec"
+	verifyActionName        = "verify"
+	exportActionName        = "export"
+	outdatedActionName      = "outdated"
+	auditActionName         = "audit"
+	diffActionName          = "diff"
+	prefetchActionName      = "prefetch"
+	licensesActionName      = "licenses"
+	sbomActionName          = "sbom"
+	statsActionName         = "stats"
+	migrateActionName       = "migrate"
+	migrateLayoutActionName = "migrate-layout"
+	fmtActionName           = "fmt"
+	searchActionName        = "search"
+	infoActionName          = "info"
+	publishActionName       = "publish"
+	serveActionName         = "serve"
+	completionActionName    = "completion"
+	versionActionName       = "version"
 )
 
 var (
 	availableSubcommands = []string{
 		initActionName,
 		installActionName,
+		syncActionName,
+		upgradeActionName,
+		removeActionName,
+		pinActionName,
+		unpinActionName,
+		cacheActionName,
+		listActionName,
+		whyActionName,
+		graphActionName,
+		rewriteActionName,
+		checkActionName,
+		jpathActionName,
+		execActionName,
+		verifyActionName,
+		exportActionName,
+		outdatedActionName,
+		auditActionName,
+		diffActionName,
+		prefetchActionName,
+		licensesActionName,
+		sbomActionName,
+		statsActionName,
+		migrateActionName,
+		migrateLayoutActionName,
+		fmtActionName,
+		searchActionName,
+		infoActionName,
+		publishActionName,
+		serveActionName,
+		completionActionName,
+		versionActionName,
 	}
-	gitSSHRegex                   = regexp.MustCompile("git\\+ssh://git@([^:]+):([^/]+)/([^/]+).git")
-	gitSSHWithVersionRegex        = regexp.MustCompile("git\\+ssh://git@([^:]+):([^/]+)/([^/]+).git@(.*)")
-	gitSSHWithPathRegex           = regexp.MustCompile("git\\+ssh://git@([^:]+):([^/]+)/([^/]+).git/(.*)")
-	gitSSHWithPathAndVersionRegex = regexp.MustCompile("git\\+ssh://git@([^:]+):([^/]+)/([^/]+).git/(.*)@(.*)")
-
-	githubSlugRegex                   = regexp.MustCompile("github.com/([-_a-zA-Z0-9]+)/([-_a-zA-Z0-9]+)")
-	githubSlugWithVersionRegex        = regexp.MustCompile("github.com/([-_a-zA-Z0-9]+)/([-_a-zA-Z0-9]+)@(.*)")
-	githubSlugWithPathRegex           = regexp.MustCompile("github.com/([-_a-zA-Z0-9]+)/([-_a-zA-Z0-9]+)/(.*)")
-	githubSlugWithPathAndVersionRegex = regexp.MustCompile("github.com/([-_a-zA-Z0-9]+)/([-_a-zA-Z0-9]+)/(.*)@(.*)")
+
+	// diffLock is set by --diff, which prints how install and update changed
+	// the lock file and exits with exitChanged if they did.
+	diffLock = false
+
+	// defaultBranch is the version of git dependencies installed without
+	// one, set by --default-branch. When empty, the default branch of the
+	// remote is installed, whatever its name.
+	defaultBranch = ""
 )
 
 func main() {
 	os.Exit(Main())
 }
 
-func Main() int {
+func Main() (code int) {
 	cfg := struct {
 		JsonnetHome string
+		CacheDir    string
+		LockTimeout time.Duration
+		CAFile      string
+		SSHKey      string
+		KnownHosts  string
+		Mirrors     []string
+		Prefer      string
+		Tarballs    bool
+		Policy      pkg.Policy
+		PolicyHook  string
+		JSON        bool
+		Verbose     bool
+		Quiet       bool
+		NoColor     bool
 	}{}
 
+	workdir, err := os.Getwd()
+	if err != nil {
+		return exitFailure
+	}
+
 	a := kingpin.New(filepath.Base(os.Args[0]), "A jsonnet package manager")
 	a.HelpFlag.Short('h')
-
-	a.Flag("jsonnetpkg-home", "The directory used to cache packages in.").
-		Default("vendor").StringVar(&cfg.JsonnetHome)
+	a.Version(versionString())
+	// Files requiring a newer jb are refused rather than misread.
+	spec.JBVersion = version
+
+	// A vendor directory given on the command line is recorded in the
+	// project config by init, install and update.
+	homeSet := false
+	setHome := func(*kingpin.ParseContext) error {
+		homeSet = true
+		return nil
+	}
+	// The chdir flag is looked up before the arguments are parsed, the
+	// config of the project is read from its directory.
+	chdir := a.Flag("chdir", "Run in this directory instead of the nearest one holding a jsonnetfile.json from the current directory up. Paths on the command line are relative to it.").
+		Short('C').PlaceHolder("DIR")
+	chdir.String()
+	a.Flag("jsonnetpkg-home", "The directory packages are vendored into, relative to the project unless absolute, also outside of it. Recorded in the project config by init, install and update.").
+		Default("vendor").Action(setHome).StringVar(&cfg.JsonnetHome)
+	a.Flag("cache-dir", "The directory packages are cached in across projects, defaults to the user cache directory (~/.cache/jsonnet-bundler).").
+		Envar(cacheDirEnv).StringVar(&cfg.CacheDir)
+	a.Flag("lock-timeout", "How long to wait for another jb process using the vendor directory or the cache, like 30s. No limit if 0.").
+		Default("5m").DurationVar(&cfg.LockTimeout)
+	a.Flag("ca-file", "PEM file of the certificate authorities trusted for HTTPS instead of the system ones, e.g. of a TLS intercepting proxy.").
+		Envar(pkg.CAFileEnv).StringVar(&cfg.CAFile)
+	a.Flag("ssh-key", "Private key to authenticate to git+ssh remotes with, instead of those of the ssh agent and ~/.ssh.").
+		Envar(pkg.SSHKeyEnv).StringVar(&cfg.SSHKey)
+	a.Flag("ssh-known-hosts", "Known hosts file the host keys of git+ssh remotes must be in, instead of ~/.ssh/known_hosts.").
+		Envar(pkg.SSHKnownHostsEnv).StringVar(&cfg.KnownHosts)
+	a.Flag("mirror", "Fetch packages from a mirror, given as from=to like https://github.com/org/*=https://git.corp/org/*. Repeatable, the lock file keeps the original remotes.").
+		StringsVar(&cfg.Mirrors)
+	a.Flag("prefer", "Fetch git remotes over this transport, https or ssh, translating git@host:org/repo and https://host/org/repo into each other, falling back to the remote as written if it fails. Dependencies pinned to a transport keep theirs.").
+		Envar(pkg.PreferEnv).EnumVar(&cfg.Prefer, pkg.Transports...)
+	a.Flag("github-tarballs", "Download GitHub repositories as tarballs instead of cloning them with git, much faster for large ones. Authenticated with GITHUB_TOKEN if set.").
+		BoolVar(&cfg.Tarballs)
+	a.Flag("allow-source", "Only vendor dependencies from this host or organization, like github.com or github.com/grafana. Repeatable.").
+		PlaceHolder("SOURCE").StringsVar(&cfg.Policy.AllowSources)
+	a.Flag("deny-source", "Never vendor dependencies from this host or organization. Repeatable.").
+		PlaceHolder("SOURCE").StringsVar(&cfg.Policy.DenySources)
+	a.Flag("allow-license", "Only vendor dependencies under this license, an SPDX identifier like Apache-2.0. Repeatable.").
+		PlaceHolder("LICENSE").StringsVar(&cfg.Policy.AllowLicenses)
+	a.Flag("deny-license", "Never vendor dependencies under this license. Repeatable.").
+		PlaceHolder("LICENSE").StringsVar(&cfg.Policy.DenyLicenses)
+	a.Flag("policy-hook", "Command run for every dependency before it is vendored, given it as JSON on stdin. A failure rejects the dependency, for the reason printed on stderr. Split at whitespace and run without a shell.").
+		Envar(pkg.PolicyHookEnv).PlaceHolder("COMMAND").StringVar(&cfg.PolicyHook)
+	a.Flag("json", "Print the results of install, update, list, outdated, audit, diff and stats as JSON on stdout, logs are written to stderr.").
+		BoolVar(&cfg.JSON)
+	a.Flag("verbose", "Report every stage of every package and show the output of git.").
+		Short('v').BoolVar(&cfg.Verbose)
+	a.Flag("quiet", "Print nothing but errors.").
+		Short('q').BoolVar(&cfg.Quiet)
+	a.Flag("no-color", "Print without colors, also if the NO_COLOR environment variable is set.").
+		BoolVar(&cfg.NoColor)
+	a.Flag("non-interactive", "Never prompt, failing instead when git or ssh need credentials or the confirmation of a host key. The default unless stdin is a terminal.").
+		Default(strconv.FormatBool(!isatty.IsTerminal(os.Stdin.Fd()))).BoolVar(&nonInteractive)
+
+	opts := client.Options{}
+	flatten := true
+	copyPackages := false
+	checkImports := false
+	envFile := ""
+	strip := []string{}
+	keepDocs := true
+	conflicts := string(pkg.ConflictFail)
+	strategies := make([]string, 0, len(pkg.ConflictStrategies))
+	for _, s := range pkg.ConflictStrategies {
+		strategies = append(strategies, string(s))
+	}
 
 	initCmd := a.Command(initActionName, "Initialize a new empty jsonnetfile")
+	initOpts := initOptions{}
+	initCmd.Flag("from-import", "Scaffold dependencies from the vendored imports of the jsonnet files in this directory.").StringVar(&initOpts.FromImport)
+	initCmd.Flag("name", "Name of the package.").StringVar(&initOpts.Name)
+	initCmd.Flag("legacy-name", "Former name of the package, for importers still using it.").StringVar(&initOpts.LegacyName)
+	initCmd.Flag("scaffold", "Write a starter jsonnetfile with an empty dependency list and ignore the vendor directory in .gitignore.").BoolVar(&initOpts.Scaffold)
+	initCmd.Flag("force", "Overwrite an existing jsonnetfile.").BoolVar(&initOpts.Force)
+
+	installCmd := a.Command(installActionName, "Install the dependencies of the lock file, of the jsonnetfile if there is none, or add and install specific ones.")
+	// Not URLs, which would escape versions like ^1.2 or main@{2023-06-01}.
+	installCmdURLs := installCmd.Arg("packages", "URLs to package to install").Strings()
+	installCmdFile := installCmd.Flag("file", "Install the packages listed in this file too, one per line with # comments, - for stdin.").
+		Short('f').PlaceHolder("FILE").String()
+	installCmdName := installCmd.Flag("name", "Install the package under this name, a path below the vendor directory like lib/grafonnet, for example to vendor two major versions of it.").String()
+	installCmdSubmodules := installCmd.Flag("submodules", "Initialize the git submodules of the packages added, recursively.").Bool()
+	installCmdFrozen := installCmd.Flag("frozen", "Install exactly the lock file, failing if it is missing or out of sync with the jsonnetfile.").Bool()
+	installCmdSingle := installCmd.Flag("single", "Vendor the packages without adding them to the jsonnetfile or the lock file, marked as unmanaged, for trying them out.").Bool()
+	installCmdExpand := installCmd.Flag("expand", "Install the packages nested in the repositories added, the subdirs holding a jsonnetfile, instead of the repositories as a whole. Asked on a terminal.").Bool()
+	installCmdHeal := installCmd.Flag("heal", "Re-resolve the locked dependencies whose commits no longer exist upstream, like after a force-push or a deleted tag, from the jsonnetfile, keeping all others locked.").Bool()
+	installCmdRepair := installCmd.Flag("repair", "Reinstall only the locked packages that are missing from the vendor directory or were modified, leaving all others as they are.").Bool()
+	installCmdFromBundle := installCmd.Flag("from-bundle", "Install the vendor directory and lock file of a bundle written by jb export, verifying the digests of its packages.").String()
+	installCmdOnly := installCmd.Flag("only", "Install only the locked dependencies in this group, prod for those without groups, and what they depend on. Repeatable.").Strings()
+	installCmdWith := installCmd.Flag("with", "Install the dependencies of this group too, in addition to those of --only. Repeatable.").Strings()
+	installCmdTarget := installCmd.Flag("target", "Install the dependencies of this target of the jsonnetfile, like an environment, into its vendor directory instead of those of the project, resolving and locking them if needed.").
+		HintAction(func() []string { return targetNames(workdir) }).String()
+	installCmd.Flag("disambiguate-names", "Prefix dependencies whose names collide with the organization of their remote, writing the new names to the jsonnetfile.").
+		BoolVar(&opts.Disambiguate)
+	installCmd.Flag("flatten", "Vendor the contents of a dependency's subdir directly into its directory, --no-flatten preserves the subdir path.").
+		Default("true").BoolVar(&flatten)
+	installCmd.Flag("conflict-strategy", "How to resolve a dependency required at different versions: fail, prefer-newest or prefer-direct.").
+		Default(string(pkg.ConflictFail)).EnumVar(&conflicts, strategies...)
+	installCmd.Flag("jobs", "Number of dependencies to download concurrently.").
+		Short('j').Default("4").IntVar(&opts.Jobs)
+	installCmd.Flag("timeout", "Abort the download of a package that takes longer than this duration, like 5m. No limit if 0.").
+		DurationVar(&opts.Timeout)
+	installCmd.Flag("retries", "Number of times a download failing with a network or server error is retried.").
+		Default("3").IntVar(&opts.Retries)
+	installCmd.Flag("retry-delay", "Delay before the first retry, doubled for every next one.").
+		Default(pkg.DefaultRetryDelay.String()).DurationVar(&opts.RetryDelay)
+	maxRate := installCmd.Flag("max-rate", "Limit the downloads of archives, release assets, tarballs and OCI artifacts to this many bytes per second in total, like 2MB. Interrupted downloads resume where they stopped if the server supports it.").
+		Default("0").Bytes()
+	installCmd.Flag("offline", "Install the lock file from the cache without touching the network, failing for packages that are not in it.").
+		BoolVar(&opts.Offline)
+	installCmd.Flag("prune", "Remove packages from the vendor directory that are no longer dependencies, --no-prune keeps them.").
+		Default("true").BoolVar(&opts.Prune)
+	installCmd.Flag("copy", "Copy packages into the vendor directory instead of hard linking them from the store of the cache directory, whose read-only files every project linking them shares.").
+		BoolVar(&copyPackages)
+	installCmd.Flag("strip", "Exclude pattern of the files removed from every vendored package, instead of VCS metadata and CI configs like .git, .github and .gitlab-ci.yml. Repeatable, --strip= removes nothing.").
+		StringsVar(&strip)
+	installCmd.Flag("keep-docs", "Vendor the license and readme files of the packages, --no-keep-docs removes them.").
+		Default("true").BoolVar(&keepDocs)
+	installCmd.Flag("reproducible", "Normalize the file modes and modification times of the vendor directory, dated to the commits installed, so that installs of the same lock produce identical trees.").
+		BoolVar(&opts.Reproducible)
+	installCmd.Flag("allow-hooks", "Run the post-install hooks of the packages and of the project and generate its Kubernetes libraries, which are only listed otherwise.").
+		BoolVar(&opts.AllowHooks)
+	installCmd.Flag("workspace", "Install the dependencies of all subprojects into the vendor directory and lock file of this directory.").
+		BoolVar(&opts.Workspace)
+	installCmd.Flag("dry-run", "Resolve and fetch the dependencies without changing any file, printing how the lock file would change.").
+		BoolVar(&opts.DryRun)
+	installCmd.Flag("diff", "Print the packages added, removed, upgraded or downgraded in the lock file, exiting with 9 if there are any.").
+		BoolVar(&diffLock)
+	installCmd.Flag("check-imports", "Fail if imports of the project or of the vendored packages do not resolve after installing, like jb check-imports.").
+		BoolVar(&checkImports)
+	installCmd.Flag("env-file", "Write the vendor directory, JSONNET_PATH and the locked version of every package as shell variables to this file after installing, like .jb.env.").
+		StringVar(&envFile)
+	installCmd.Flag("default-branch", "Version of git packages given without one, the default branch of the remote (HEAD) if empty.").
+		StringVar(&defaultBranch)
+
+	syncCmd := a.Command(syncActionName, "Make the vendor directory match the lock file, installing the locked versions and pruning everything else, without resolving anything or writing either file.")
+	syncCmd.Flag("jobs", "Number of dependencies to download concurrently.").
+		Short('j').Default("4").IntVar(&opts.Jobs)
+
+	updateCmd := a.Command(updateActionName, "Resolve the branches and version constraints of all dependencies again, or only of the given ones keeping all others locked, within the versions the jsonnetfile requires, and write the lock file.")
+	updateCmdPackages := updateCmd.Arg("packages", "Names or URLs of the packages to update, followed by @latest to track their newest release").HintAction(func() []string { return dependencyNames(workdir) }).Strings()
+	updateCmdNoLockWrite := updateCmd.Flag("no-lock-write", "Vendor dependencies without writing the lock file, failing if it would change.").Bool()
+	updateCmdSince := updateCmd.Flag("since", "Only update dependencies with upstream commits newer than this duration (72h, 14d) or date (2006-01-02).").String()
+	updateCmdInteractive := updateCmd.Flag("interactive", "Select which dependencies with newer versions upstream to update, in a list of their locked and candidate versions. Updates all of them without a terminal.").
+		Short('i').Bool()
+	updateCmdTarget := updateCmd.Flag("target", "Update all dependencies of this target of the jsonnetfile instead of those of the project.").
+		HintAction(func() []string { return targetNames(workdir) }).String()
+	updateCmdIncludeFrozen := updateCmd.Flag("include-frozen", "Update the frozen dependencies as well, which keep their locked version otherwise.").Bool()
+	updateCmdAsOf := updateCmd.Flag("as-of", "Update dependencies on branches to their last commit before this duration ago (72h, 14d) or date (2006-01-02).").String()
+	updateCmd.Flag("disambiguate-names", "Prefix dependencies whose names collide with the organization of their remote, writing the new names to the jsonnetfile.").
+		BoolVar(&opts.Disambiguate)
+	updateCmd.Flag("flatten", "Vendor the contents of a dependency's subdir directly into its directory, --no-flatten preserves the subdir path.").
+		Default("true").BoolVar(&flatten)
+	updateCmd.Flag("conflict-strategy", "How to resolve a dependency required at different versions: fail, prefer-newest or prefer-direct.").
+		Default(string(pkg.ConflictFail)).EnumVar(&conflicts, strategies...)
+	updateCmd.Flag("jobs", "Number of dependencies to download concurrently.").
+		Short('j').Default("4").IntVar(&opts.Jobs)
+	updateCmd.Flag("timeout", "Abort the download of a package that takes longer than this duration, like 5m. No limit if 0.").
+		DurationVar(&opts.Timeout)
+	updateCmd.Flag("retries", "Number of times a download failing with a network or server error is retried.").
+		Default("3").IntVar(&opts.Retries)
+	updateCmd.Flag("retry-delay", "Delay before the first retry, doubled for every next one.").
+		Default(pkg.DefaultRetryDelay.String()).DurationVar(&opts.RetryDelay)
+	updateCmd.Flag("max-rate", "Limit the downloads of archives, release assets, tarballs and OCI artifacts to this many bytes per second in total, like 2MB. Interrupted downloads resume where they stopped if the server supports it.").
+		Default("0").BytesVar(maxRate)
+	updateCmd.Flag("prune", "Remove packages from the vendor directory that are no longer dependencies, --no-prune keeps them.").
+		Default("true").BoolVar(&opts.Prune)
+	updateCmd.Flag("copy", "Copy packages into the vendor directory instead of hard linking them from the store of the cache directory, whose read-only files every project linking them shares.").
+		BoolVar(&copyPackages)
+	updateCmd.Flag("strip", "Exclude pattern of the files removed from every vendored package, instead of VCS metadata and CI configs like .git, .github and .gitlab-ci.yml. Repeatable, --strip= removes nothing.").
+		StringsVar(&strip)
+	updateCmd.Flag("keep-docs", "Vendor the license and readme files of the packages, --no-keep-docs removes them.").
+		Default("true").BoolVar(&keepDocs)
+	updateCmd.Flag("reproducible", "Normalize the file modes and modification times of the vendor directory, dated to the commits installed, so that installs of the same lock produce identical trees.").
+		BoolVar(&opts.Reproducible)
+	updateCmd.Flag("allow-hooks", "Run the post-install hooks of the packages and of the project and generate its Kubernetes libraries, which are only listed otherwise.").
+		BoolVar(&opts.AllowHooks)
+	updateCmd.Flag("workspace", "Install the dependencies of all subprojects into the vendor directory and lock file of this directory.").
+		BoolVar(&opts.Workspace)
+	updateCmd.Flag("dry-run", "Resolve and fetch the dependencies without changing any file, printing how the lock file would change.").
+		BoolVar(&opts.DryRun)
+	updateCmd.Flag("diff", "Print the packages added, removed, upgraded or downgraded in the lock file, exiting with 9 if there are any.").
+		BoolVar(&diffLock)
+	updateCmd.Flag("check-imports", "Fail if imports of the project or of the vendored packages do not resolve after installing, like jb check-imports.").
+		BoolVar(&checkImports)
+	updateCmd.Flag("env-file", "Write the vendor directory, JSONNET_PATH and the locked version of every package as shell variables to this file after installing, like .jb.env.").
+		StringVar(&envFile)
+
+	upgradeCmd := a.Command(upgradeActionName, "Raise the version constraints and tags of all dependencies, or only of the given ones, to their latest release, and write the jsonnetfile and the lock file.")
+	upgradeCmdPackages := upgradeCmd.Arg("packages", "Names or URLs of the packages to upgrade").HintAction(func() []string { return dependencyNames(workdir) }).Strings()
+	upgradeCmdIncludeFrozen := upgradeCmd.Flag("include-frozen", "Upgrade the frozen dependencies as well, which are skipped otherwise.").Bool()
+	upgradeCmd.Flag("jobs", "Number of dependencies to download concurrently.").
+		Short('j').Default("4").IntVar(&opts.Jobs)
+	upgradeCmd.Flag("dry-run", "Resolve and fetch the latest releases without changing any file, printing how the lock file would change.").
+		BoolVar(&opts.DryRun)
+
+	removeCmd := a.Command(removeActionName, "Remove dependencies from the jsonnetfile, the lock file and the vendor directory.").
+		Alias("remove").Alias("uninstall")
+	removeCmdPackages := removeCmd.Arg("packages", "Names or URLs of the packages to remove").Required().
+		HintAction(func() []string { return dependencyNames(workdir) }).Strings()
+
+	pinCmd := a.Command(pinActionName, "Freeze dependencies at their locked version, jb update keeps them unless --include-frozen is given.")
+	pinCmdPackages := pinCmd.Arg("packages", "Names or URLs of the packages to freeze").Required().
+		HintAction(func() []string { return dependencyNames(workdir) }).Strings()
+	unpinCmd := a.Command(unpinActionName, "Unfreeze dependencies frozen by jb pin, so that jb update updates them again.")
+	unpinCmdPackages := unpinCmd.Arg("packages", "Names or URLs of the packages to unfreeze").Required().
+		HintAction(func() []string { return dependencyNames(workdir) }).Strings()
+
+	listCmd := a.Command(listActionName, "List the dependency tree with the versions resolved in the lock file.").Alias("ls")
+
+	whyCmd := a.Command(whyActionName, "Show the chains of dependencies that pull in a package, with the versions they require.")
+	whyCmdPackage := whyCmd.Arg("package", "Name or URL of the package").Required().String()
+
+	graphCmd := a.Command(graphActionName, "Print the dependency graph with the versions resolved in the lock file.")
+	graphCmdFormat := graphCmd.Flag("format", "Format of the graph: dot or mermaid.").
+		Default(graphDOT).Enum(graphDOT, graphMermaid)
+
+	rewriteCmd := a.Command(rewriteActionName, "Rewrite the imports of this project to the vendor layout, reporting imports that do not resolve.")
+	rewriteCmdCheck := rewriteCmd.Flag("check", "Only report imports that need to be rewritten, failing if there are any.").Bool()
+
+	checkCmd := a.Command(checkActionName, "Report imports of this project and of the vendored packages that do not resolve against the vendor directory.")
+
+	jpathCmd := a.Command(jpathActionName, "Print the -J flags jsonnet needs to resolve the imports of this project.")
+	jpathCmdEnv := jpathCmd.Flag("env", "Print the value of JSONNET_PATH instead, keeping the directories already in it.").Bool()
+	jpathCmdEnvrc := jpathCmd.Flag("envrc", "Set JSONNET_PATH in the .envrc of this project, loaded by direnv.").Bool()
+
+	execCmd := a.Command(execActionName, "Run a command, like jsonnet or tk, with JSONNET_PATH set to resolve the imports of this project. Arguments starting with - follow --.").Alias("run")
+	execCmdArgs := execCmd.Arg("command", "The command and its arguments").Required().Strings()
+
+	verifyCmd := a.Command(verifyActionName, "Check that the vendor directory matches the lock file, without modifications or extraneous packages.")
+
+	exportCmd := a.Command(exportActionName, "Write the vendor directory and the lock file to a bundle for jb install --from-bundle, like in air-gapped environments.")
+	exportCmdFile := exportCmd.Arg("file", "The bundle to write, a .tar.gz file, or - for stdout").Required().String()
+
+	outdatedCmd := a.Command(outdatedActionName, "List the dependencies with newer versions upstream than the locked ones, without updating them.")
+
+	auditCmd := a.Command(auditActionName, "Check the locked dependencies against a feed of advisories on vulnerable or yanked versions.")
+	auditCmdFeed := auditCmd.Flag("advisories", "URL or file of the advisory feed, a list of advisories or OSV entries.").Envar(advisory.Env).String()
+	severities := make([]string, 0, len(advisory.Severities))
+	for _, s := range advisory.Severities {
+		severities = append(severities, string(s))
+	}
+	auditCmdFailOn := auditCmd.Flag("fail-on", "Lowest severity failing the audit: low, medium, high or critical. Advisories without a severity always do.").
+		Default(string(advisory.Low)).Enum(severities...)
+
+	diffCmd := a.Command(diffActionName, "Show the files of a vendored package that differ at another version, fetched into the cache, to review an upgrade before updating the lock file.")
+	diffCmdPackage := diffCmd.Arg("package", "Name or URL of the package, followed by @ and the version to compare with, like grafonnet@v10.0.0.").Required().HintAction(func() []string { return dependencyNames(workdir) }).String()
+	diffCmdPatch := diffCmd.Flag("patch", "Print the changes of the files as a patch, with git diff.").Bool()
+
+	prefetchCmd := a.Command(prefetchActionName, "Fetch the packages of the lock file into the cache without vendoring them, so that later installs need no network.")
+	prefetchCmd.Flag("jobs", "Number of dependencies to download concurrently.").
+		Short('j').Default("4").IntVar(&opts.Jobs)
+	prefetchCmd.Flag("timeout", "Abort the download of a package that takes longer than this duration, like 5m. No limit if 0.").
+		DurationVar(&opts.Timeout)
+	prefetchCmd.Flag("retries", "Number of times a download failing with a network or server error is retried.").
+		Default("3").IntVar(&opts.Retries)
+	prefetchCmd.Flag("retry-delay", "Delay before the first retry, doubled for every next one.").
+		Default(pkg.DefaultRetryDelay.String()).DurationVar(&opts.RetryDelay)
+	prefetchCmd.Flag("max-rate", "Limit the downloads of archives, release assets, tarballs and OCI artifacts to this many bytes per second in total, like 2MB. Interrupted downloads resume where they stopped if the server supports it.").
+		Default("0").BytesVar(maxRate)
+
+	licensesCmd := a.Command(licensesActionName, "List the license and source of every locked package.")
+
+	sbomCmd := a.Command(sbomActionName, "Print a software bill of materials of the locked packages.")
+	sbomCmdFormat := sbomCmd.Flag("format", "Format of the bill of materials: cyclonedx or spdx.").
+		Default(string(sbom.CycloneDX)).Enum(string(sbom.CycloneDX), string(sbom.SPDX))
+
+	statsCmd := a.Command(statsActionName, "Report the size and file count of every vendored package, the largest files and the files vendored more than once, without network access.")
+	statsCmdTop := statsCmd.Flag("top", "Number of largest files to list.").Default("10").Int()
+
+	migrateCmd := a.Command(migrateActionName, "Upgrade the jsonnetfile and the lock file to the latest version of the format.")
+
+	migrateLayoutCmd := a.Command(migrateLayoutActionName, "Move the packages of the legacy .jsonnetpkg/src layout into the vendor directory.")
+	migrateLayoutCmdDryRun := migrateLayoutCmd.Flag("dry-run", "Print what would be moved and relinked without changing any file.").Bool()
+
+	fmtCmd := a.Command(fmtActionName, "Validate the jsonnetfile and the lock file and rewrite them in the canonical format.")
+	fmtCmdCheck := fmtCmd.Flag("check", "Only list the files that are not formatted, failing if there are any.").Bool()
+
+	searchCmd := a.Command(searchActionName, "Search the registry index for packages by name, description or remote.")
+	searchCmdTerm := searchCmd.Arg("term", "The text to search for").Required().String()
+	searchCmdRegistry := searchCmd.Flag("registry", "URL or file of the registry index.").Envar(registry.Env).String()
+
+	infoCmd := a.Command(infoActionName, "Show a package of the registry index and how to install it.")
+	infoCmdPackage := infoCmd.Arg("package", "Name or URL of the package").Required().String()
+	infoCmdRegistry := infoCmd.Flag("registry", "URL or file of the registry index.").Envar(registry.Env).String()
+
+	publishOpts := client.PublishOptions{}
+	publishCmd := a.Command(publishActionName, "Validate this package and tag it with the next semantic version.")
+	publishCmd.Arg("version", "The tag to create instead of bumping the latest one").StringVar(&publishOpts.Version)
+	publishCmd.Flag("bump", "The part of the latest version to increment: major, minor or patch.").
+		Default("patch").EnumVar(&publishOpts.Bump, "major", "minor", "patch")
+	publishCmd.Flag("sign", "Create a GPG-signed tag.").BoolVar(&publishOpts.Sign)
+	publishCmd.Flag("push", "Push the tag to the remote.").BoolVar(&publishOpts.Push)
+	publishCmd.Flag("remote", "The remote to push the tag to.").Default("origin").StringVar(&publishOpts.Remote)
+	publishCmd.Flag("oci", "Push the files of the tag as an OCI artifact with the same tag to this repository, like oci://ghcr.io/org/lib.").StringVar(&publishOpts.OCI)
+	publishCmd.Flag("dry-run", "Validate the package and print the tag without creating it.").BoolVar(&publishOpts.DryRun)
+
+	serveCmd := a.Command(serveActionName, "Serve a local HTTP API to resolve package references, list the locked packages, install and watch the jsonnetfile, for editor integrations.")
+	serveCmdListen := serveCmd.Flag("listen", "The address to listen on, like localhost:7755. Only requests for localhost are served.").
+		Default("localhost:7755").String()
+	serveCmdToken := serveCmd.Flag("token", "The bearer token clients must authorize with, a random one printed at startup if not set.").
+		Envar(serveTokenEnv).String()
+
+	cacheCmd := a.Command(cacheActionName, "Manage the package cache shared across projects.")
+	cacheInfoCmd := cacheCmd.Command("info", "Show the location and size of the cache.")
+	cacheCleanCmd := cacheCmd.Command("clean", "Remove all packages from the cache.")
+
+	versionCmd := a.Command(versionActionName, "Print the version, commit and build date of jb.")
+	versionCmdCheck := versionCmd.Flag("check", "Query the GitHub releases of jb for a newer version.").Bool()
+
+	completionCmd := a.Command(completionActionName, "Print the shell completion script for bash, zsh or fish.")
+	completionCmdShell := completionCmd.Arg("shell", "The shell to complete jb in: bash, zsh or fish").Required().
+		HintOptions(completionShells...).Enum(completionShells...)
+
+	// Running from a subdirectory of the project is like running from the
+	// project itself.
+	dir, err := projectDir(a, chdir, workdir, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	if dir != workdir {
+		if err := os.Chdir(dir); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitFailure
+		}
+		workdir = dir
+	}
 
-	installCmd := a.Command(installActionName, "Install all dependencies or install specific ones")
-	installCmdURLs := installCmd.Arg("packages", "URLs to package to install").URLList()
-
-	updateCmd := a.Command(updateActionName, "Update all dependencies.")
+	// Config files supply the defaults of flags, the project config taking
+	// precedence over the user config for the keys it may set. Flags given
+	// on the command line take precedence over both, and environment
+	// variables over everything, see applyEnv.
+	conf, err := mergeConfig(userConfigFiles(), workdir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailure
+	}
+	conf.apply(a)
+	conf.setenv()
 
 	command, err := a.Parse(os.Args[1:])
+	if err == nil {
+		err = applyEnv(a, command)
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, errors.Wrapf(err, "Error parsing commandline arguments"))
 		a.Usage(os.Args[1:])
-		return 2
+		return exitUsage
 	}
-
-	workdir, err := os.Getwd()
-	if err != nil {
-		return 1
+	kingpin.CommandLine.ErrorWriter(annotations(os.Stderr))
+	if nonInteractive {
+		setNonInteractive()
 	}
 
-	switch command {
-	case initCmd.FullCommand():
-		return initCommand(workdir)
-	case installCmd.FullCommand():
-		return installCommand(workdir, cfg.JsonnetHome, *installCmdURLs...)
-	case updateCmd.FullCommand():
-		return updateCommand(cfg.JsonnetHome)
-	default:
-		installCommand(workdir, cfg.JsonnetHome)
+	if cfg.CacheDir == "" {
+		cfg.CacheDir = defaultCacheDir()
 	}
 
-	return 0
-}
-
-func parseDepedency(urlString string) *spec.Dependency {
-	if spec := parseGitSSHDependency(urlString); spec != nil {
-		return spec
+	// Everything but the result goes to stderr, including the output of
+	// git. kingpin exits right away on invalid arguments, so the result is
+	// printed when terminating as well.
+	if cfg.JSON {
+		output = newJSONOutput(os.Stdout, command, kingpin.CommandLine.Name)
+		os.Stdout = os.Stderr
+		color.Output = os.Stderr
+		kingpin.CommandLine.ErrorWriter(io.MultiWriter(annotations(os.Stderr), output))
+		kingpin.CommandLine.Terminate(func(code int) {
+			output.flush(code)
+			os.Exit(code)
+		})
+		defer func() { output.flush(code) }()
 	}
 
-	if spec := parseGithubDependency(urlString); spec != nil {
-		return spec
+	// GitHub Actions folds the log of the command into a group, the
+	// annotations of errors are shown on the summary of the run.
+	if os.Getenv(githubActionsEnv) == "true" && !cfg.JSON {
+		fmt.Printf("::group::jb %s\n", command)
+		defer fmt.Println("::endgroup::")
 	}
 
-	return nil
-}
-
-func parseGitSSHDependency(urlString string) *spec.Dependency {
-	if !gitSSHRegex.MatchString(urlString) {
-		return nil
+	opts.JsonnetHome = cfg.JsonnetHome
+	opts.CacheDir = cfg.CacheDir
+	opts.LockTimeout = cfg.LockTimeout
+	// Projects share the packages of the store instead of holding copies.
+	if !copyPackages && cfg.CacheDir != "" {
+		opts.StoreDir = filepath.Join(cfg.CacheDir, "store")
 	}
-
-	subdir := ""
-	host := ""
-	org := ""
-	repo := ""
-	version := "master"
-
-	if gitSSHWithPathAndVersionRegex.MatchString(urlString) {
-		matches := gitSSHWithPathAndVersionRegex.FindStringSubmatch(urlString)
-		host = matches[1]
-		org = matches[2]
-		repo = matches[3]
-		subdir = matches[4]
-		version = matches[5]
-	} else if gitSSHWithPathRegex.MatchString(urlString) {
-		matches := gitSSHWithPathRegex.FindStringSubmatch(urlString)
-		host = matches[1]
-		org = matches[2]
-		repo = matches[3]
-		subdir = matches[4]
-	} else if gitSSHWithVersionRegex.MatchString(urlString) {
-		matches := gitSSHWithVersionRegex.FindStringSubmatch(urlString)
-		host = matches[1]
-		org = matches[2]
-		repo = matches[3]
-		version = matches[4]
-	} else {
-		matches := gitSSHRegex.FindStringSubmatch(urlString)
-		host = matches[1]
-		org = matches[2]
-		repo = matches[3]
+	opts.CAFile = cfg.CAFile
+	opts.SSHKey = cfg.SSHKey
+	opts.SSHKnownHosts = cfg.KnownHosts
+	opts.GitHubTarballs = cfg.Tarballs
+	opts.Prefer = cfg.Prefer
+	for _, s := range cfg.Mirrors {
+		m, err := pkg.ParseMirror(s)
+		if err != nil {
+			kingpin.Errorf("%v", err)
+			return exitUsage
+		}
+		opts.Mirrors = append(opts.Mirrors, m)
+	}
+	cfg.Policy.Hook = strings.Fields(cfg.PolicyHook)
+	if p := cfg.Policy; len(p.AllowSources)+len(p.DenySources)+len(p.AllowLicenses)+len(p.DenyLicenses)+len(p.Hook) > 0 {
+		opts.Policy = &cfg.Policy
 	}
+	opts.PreserveSubdirs = !flatten
+	if len(strip) > 0 {
+		opts.Strip = []string{}
+		for _, s := range strip {
+			if s != "" {
+				opts.Strip = append(opts.Strip, s)
+			}
+		}
+	}
+	opts.StripDocs = !keepDocs
+	opts.Conflicts = pkg.ConflictStrategy(conflicts)
+	opts.MaxRate = int64(*maxRate)
 
-	return &spec.Dependency{
-		Name: repo,
-		Source: spec.Source{
-			GitSource: &spec.GitSource{
-				Remote: fmt.Sprintf("git@%s:%s/%s", host, org, repo),
-				Subdir: subdir,
-			},
-		},
-		Version: version,
+	opts.Renamed = func(r pkg.Rename) {
+		color.Yellow(">>> Renaming %s from %s to %s, another dependency has the same name\n", r.Name, r.Remote, r.To)
 	}
-}
 
-func parseGithubDependency(urlString string) *spec.Dependency {
-	if !githubSlugRegex.MatchString(urlString) {
-		return nil
+	// Colors are off with NO_COLOR set to anything, see no-color.org.
+	if cfg.NoColor || os.Getenv("NO_COLOR") != "" {
+		color.NoColor = true
 	}
 
-	name := ""
-	user := ""
-	repo := ""
-	subdir := ""
-	version := "master"
-
-	if githubSlugWithPathRegex.MatchString(urlString) {
-		if githubSlugWithPathAndVersionRegex.MatchString(urlString) {
-			matches := githubSlugWithPathAndVersionRegex.FindStringSubmatch(urlString)
-			user = matches[1]
-			repo = matches[2]
-			subdir = matches[3]
-			version = matches[4]
-			name = path.Base(subdir)
-		} else {
-			matches := githubSlugWithPathRegex.FindStringSubmatch(urlString)
-			user = matches[1]
-			repo = matches[2]
-			subdir = matches[3]
-			name = path.Base(subdir)
-		}
-	} else {
-		if githubSlugWithVersionRegex.MatchString(urlString) {
-			matches := githubSlugWithVersionRegex.FindStringSubmatch(urlString)
-			user = matches[1]
-			repo = matches[2]
-			name = repo
-			version = matches[3]
-		} else {
-			matches := githubSlugRegex.FindStringSubmatch(urlString)
-			user = matches[1]
-			repo = matches[2]
-			name = repo
+	switch {
+	case cfg.Verbose && cfg.Quiet:
+		kingpin.Errorf("--verbose and --quiet cannot be combined")
+		return exitUsage
+	case cfg.Quiet:
+		color.Output = ioutil.Discard
+	case cfg.Verbose:
+		opts.Verbose = true
+		opts.Progress = func(name string, stage pkg.Stage) {
+			color.Cyan(">>> %s: %s\n", name, stage)
 		}
+	case !cfg.JSON && isatty.IsTerminal(os.Stdout.Fd()):
+		bar := newProgressBar(os.Stdout)
+		color.Output = bar.writer(color.Output)
+		kingpin.CommandLine.ErrorWriter(bar.writer(annotations(os.Stderr)))
+		opts.Progress = bar.report
+		defer bar.finish()
 	}
 
-	return &spec.Dependency{
-		Name: name,
-		Source: spec.Source{
-			GitSource: &spec.GitSource{
-				Remote: fmt.Sprintf("https://github.com/%s/%s", user, repo),
-				Subdir: subdir,
-			},
-		},
-		Version: version,
+	// The command run by exec handles interrupts itself.
+	if command == execCmd.FullCommand() {
+		return execCommand(workdir, cfg.JsonnetHome, *execCmdArgs)
 	}
-}
 
-func updateCommand(jsonnetHome string, urls ...*url.URL) int {
-	jsonnetfile := pkg.JsonnetFile
+	ctx, stop := interruptContext()
+	defer stop()
 
-	m, err := pkg.LoadJsonnetfile(jsonnetfile)
-	if err != nil {
-		kingpin.Fatalf("failed to load jsonnetfile: %v", err)
-		return 1
+	// checked checks the imports after a successful install or update with
+	// --check-imports, and writes the --env-file.
+	checked := func(code int) int {
+		if (code != 0 && code != exitChanged) || opts.DryRun {
+			return code
+		}
+		if checkImports {
+			if c := checkImportsCommand(workdir, cfg.JsonnetHome); c != 0 {
+				return c
+			}
+		}
+		if envFile != "" {
+			target := *installCmdTarget
+			if command == updateCmd.FullCommand() {
+				target = *updateCmdTarget
+			}
+			if c := envFileCommand(workdir, cfg.JsonnetHome, envFile, target); c != 0 {
+				return c
+			}
+		}
+		return code
 	}
 
-	err = os.MkdirAll(jsonnetHome, os.ModePerm)
-	if err != nil {
-		kingpin.Fatalf("failed to create jsonnet home path: %v", err)
-		return 3
-	}
+	defer func() {
+		switch command {
+		case initCmd.FullCommand(), installCmd.FullCommand(), updateCmd.FullCommand(), upgradeCmd.FullCommand():
+		default:
+			return
+		}
+		if (code == 0 || code == exitChanged) && homeSet && !opts.DryRun {
+			if err := recordJsonnetHome(workdir, cfg.JsonnetHome); err != nil {
+				code = fail(err)
+			}
+		}
+	}()
 
-	// When updating, the lockfile is explicitly ignored.
-	isLock := false
-	lock, err := pkg.Install(context.TODO(), isLock, jsonnetfile, m, jsonnetHome)
-	if err != nil {
-		kingpin.Fatalf("failed to install: %v", err)
-		return 3
+	switch command {
+	case installCmd.FullCommand(), syncCmd.FullCommand(), updateCmd.FullCommand(), upgradeCmd.FullCommand():
+		if client.HasLegacyLayout(workdir) {
+			color.Yellow(">>> %s holds packages of the legacy layout, run jb migrate-layout to move them into %s\n", filepath.ToSlash(client.LegacyLayoutDir), cfg.JsonnetHome)
+		}
 	}
 
-	b, err := json.MarshalIndent(lock, "", "    ")
-	if err != nil {
-		kingpin.Fatalf("failed to encode jsonnet file: %v", err)
-		return 3
+	switch command {
+	case initCmd.FullCommand():
+		return initCommand(workdir, cfg.JsonnetHome, initOpts)
+	case installCmd.FullCommand():
+		if *installCmdFile != "" {
+			packages, err := packageList(*installCmdFile)
+			if err != nil {
+				return fail(err)
+			}
+			*installCmdURLs = append(*installCmdURLs, packages...)
+		}
+		if len(*installCmdWith) > 0 && len(*installCmdOnly) == 0 {
+			kingpin.Errorf("--with requires --only")
+			return exitUsage
+		}
+		groups := append(*installCmdOnly, *installCmdWith...)
+		if *installCmdHeal && (len(*installCmdURLs) > 0 || len(groups) > 0 || *installCmdFrozen || *installCmdSingle || *installCmdRepair || *installCmdFromBundle != "" || *installCmdTarget != "" || opts.Workspace) {
+			kingpin.Errorf("--heal installs the whole lock file, packages cannot be added and --only, --with, --frozen, --single, --repair, --from-bundle, --target and --workspace are not supported")
+			return exitUsage
+		}
+		if len(groups) > 0 && (len(*installCmdURLs) > 0 || *installCmdSingle || *installCmdRepair || *installCmdFromBundle != "" || opts.Workspace) {
+			kingpin.Errorf("--only and --with install the lock file, packages cannot be added and --single, --repair, --from-bundle and --workspace are not supported")
+			return exitUsage
+		}
+		if *installCmdTarget != "" {
+			if len(*installCmdURLs) > 0 || len(groups) > 0 || *installCmdSingle || *installCmdRepair || *installCmdFromBundle != "" || opts.Workspace {
+				kingpin.Errorf("--target installs the target from the jsonnetfile and the lock file, packages cannot be added and --only, --with, --single, --repair, --from-bundle and --workspace are not supported")
+				return exitUsage
+			}
+			return checked(targetInstallCommand(ctx, workdir, *installCmdTarget, *installCmdFrozen, opts))
+		}
+		if *installCmdFromBundle != "" {
+			if len(*installCmdURLs) > 0 {
+				kingpin.Errorf("packages cannot be added with --from-bundle")
+				return exitUsage
+			}
+			return checked(bundleInstallCommand(ctx, workdir, *installCmdFromBundle, opts))
+		}
+		if *installCmdRepair {
+			if len(*installCmdURLs) > 0 {
+				kingpin.Errorf("packages cannot be added with --repair")
+				return exitUsage
+			}
+			return checked(repairInstallCommand(ctx, workdir, opts))
+		}
+		if *installCmdFrozen {
+			if len(*installCmdURLs) > 0 {
+				kingpin.Errorf("packages cannot be added with --frozen")
+				return exitUsage
+			}
+			return checked(frozenInstallCommand(ctx, workdir, groups, opts))
+		}
+		if *installCmdName != "" && len(*installCmdURLs) != 1 {
+			kingpin.Errorf("--name requires exactly one package")
+			return exitUsage
+		}
+		if *installCmdSingle {
+			if len(*installCmdURLs) == 0 {
+				kingpin.Errorf("--single requires packages to install")
+				return exitUsage
+			}
+			return checked(singleInstallCommand(ctx, workdir, *installCmdName, *installCmdSubmodules, *installCmdExpand, opts, *installCmdURLs...))
+		}
+		return checked(installCommand(ctx, workdir, *installCmdName, *installCmdSubmodules, *installCmdExpand, *installCmdHeal, groups, opts, *installCmdURLs...))
+	case updateCmd.FullCommand():
+		since, err := parseTime(*updateCmdSince, time.Now())
+		if err != nil {
+			kingpin.Errorf("invalid --since: %v", err)
+			return exitUsage
+		}
+		asOf, err := parseTime(*updateCmdAsOf, time.Now())
+		if err != nil {
+			kingpin.Errorf("invalid --as-of: %v", err)
+			return exitUsage
+		}
+		updateOpts := client.UpdateOptions{
+			Options:       opts,
+			Packages:      *updateCmdPackages,
+			Since:         since,
+			AsOf:          asOf,
+			NoLockWrite:   *updateCmdNoLockWrite,
+			Target:        *updateCmdTarget,
+			IncludeFrozen: *updateCmdIncludeFrozen,
+		}
+		if updateOpts.Target != "" && (len(updateOpts.Packages) > 0 || !since.IsZero() || updateOpts.NoLockWrite || *updateCmdInteractive || opts.Workspace) {
+			kingpin.Errorf("--target updates the whole target, packages cannot be given and --since, --no-lock-write, --interactive and --workspace are not supported")
+			return exitUsage
+		}
+		if *updateCmdInteractive {
+			return checked(interactiveUpdateCommand(ctx, workdir, updateOpts))
+		}
+		return checked(updateCommand(ctx, updateOpts))
+	case syncCmd.FullCommand():
+		return syncCommand(ctx, workdir, opts)
+	case upgradeCmd.FullCommand():
+		return upgradeCommand(ctx, client.UpgradeOptions{Options: opts, Packages: *upgradeCmdPackages, IncludeFrozen: *upgradeCmdIncludeFrozen})
+	case removeCmd.FullCommand():
+		return removeCommand(workdir, cfg.JsonnetHome, *removeCmdPackages...)
+	case pinCmd.FullCommand():
+		return pinCommand(workdir, true, *pinCmdPackages...)
+	case unpinCmd.FullCommand():
+		return pinCommand(workdir, false, *unpinCmdPackages...)
+	case listCmd.FullCommand():
+		return listCommand(workdir, cfg.JsonnetHome)
+	case whyCmd.FullCommand():
+		return whyCommand(workdir, cfg.JsonnetHome, *whyCmdPackage)
+	case graphCmd.FullCommand():
+		return graphCommand(workdir, cfg.JsonnetHome, *graphCmdFormat)
+	case rewriteCmd.FullCommand():
+		return rewriteImportsCommand(workdir, cfg.JsonnetHome, *rewriteCmdCheck)
+	case checkCmd.FullCommand():
+		return checkImportsCommand(workdir, cfg.JsonnetHome)
+	case jpathCmd.FullCommand():
+		return jpathCommand(workdir, cfg.JsonnetHome, *jpathCmdEnv, *jpathCmdEnvrc)
+	case verifyCmd.FullCommand():
+		return verifyCommand(workdir, cfg.JsonnetHome)
+	case exportCmd.FullCommand():
+		return exportCommand(workdir, *exportCmdFile, opts)
+	case outdatedCmd.FullCommand():
+		return outdatedCommand(ctx, workdir, opts)
+	case auditCmd.FullCommand():
+		return auditCommand(ctx, workdir, cfg.CAFile, *auditCmdFeed, advisory.Severity(*auditCmdFailOn))
+	case diffCmd.FullCommand():
+		return diffCommand(ctx, workdir, *diffCmdPackage, *diffCmdPatch, opts)
+	case prefetchCmd.FullCommand():
+		return prefetchCommand(ctx, workdir, opts)
+	case licensesCmd.FullCommand():
+		return licensesCommand(workdir, cfg.JsonnetHome)
+	case sbomCmd.FullCommand():
+		return sbomCommand(workdir, cfg.JsonnetHome, sbom.Format(*sbomCmdFormat))
+	case statsCmd.FullCommand():
+		return statsCommand(workdir, cfg.JsonnetHome, *statsCmdTop)
+	case migrateCmd.FullCommand():
+		return migrateCommand(workdir)
+	case migrateLayoutCmd.FullCommand():
+		return migrateLayoutCommand(workdir, cfg.JsonnetHome, *migrateLayoutCmdDryRun)
+	case fmtCmd.FullCommand():
+		return fmtCommand(workdir, *fmtCmdCheck)
+	case searchCmd.FullCommand():
+		return searchCommand(ctx, cfg.CAFile, *searchCmdRegistry, *searchCmdTerm)
+	case infoCmd.FullCommand():
+		return infoCommand(ctx, cfg.CAFile, *infoCmdRegistry, *infoCmdPackage)
+	case publishCmd.FullCommand():
+		publishOpts.Dir = workdir
+		publishOpts.JsonnetHome = cfg.JsonnetHome
+		publishOpts.CAFile = cfg.CAFile
+		return publishCommand(ctx, publishOpts)
+	case serveCmd.FullCommand():
+		return serveCommand(ctx, workdir, *serveCmdListen, *serveCmdToken, opts)
+	case cacheInfoCmd.FullCommand():
+		return cacheInfoCommand(cfg.CacheDir)
+	case cacheCleanCmd.FullCommand():
+		return cacheCleanCommand(cfg.CacheDir, cfg.LockTimeout)
+	case versionCmd.FullCommand():
+		return versionCommand(ctx, cfg.CAFile, latestReleaseURL, *versionCmdCheck)
+	case completionCmd.FullCommand():
+		return completionCommand(os.Stdout, a.Name, *completionCmdShell)
+	default:
+		installCommand(ctx, workdir, "", false, false, false, nil, opts)
 	}
-	b = append(b, []byte("\n")...)
 
-	err = ioutil.WriteFile(pkg.JsonnetLockFile, b, 0644)
-	if err != nil {
-		kingpin.Fatalf("failed to write lock file: %v", err)
-		return 3
+	return 0
+}
+
+// interruptContext returns a context that is canceled on SIGINT or
+// SIGTERM, so that running downloads are aborted and their temporary
+// directories removed instead of being left in the vendor directory. A
+// second signal terminates right away.
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case <-sigs:
+			signal.Stop(sigs)
+			color.Yellow(">>> Interrupted, cleaning up\n")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
 	}
+}
 
-	return 0
+// parseDepedency returns the dependency urlString refers to, with git
+// packages given without a version at the --default-branch. Invalid
+// references fail with a *parser.Error.
+func parseDepedency(urlString string) (*spec.Dependency, error) {
+	return client.Resolve(urlString, client.ResolveOptions{DefaultVersion: defaultBranch})
 }
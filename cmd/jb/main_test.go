@@ -0,0 +1,254 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/parser"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDependency(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		URL      string
+		Expected *spec.Dependency
+	}{{
+		Name: "GithubSlug",
+		URL:  "github.com/foo/bar/lib@v1",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foo/bar", Subdir: "lib"}},
+			Version: "v1",
+		},
+	}, {
+		Name: "GithubShortCommit",
+		URL:  "github.com/foo/bar@3f9c0a1",
+		Expected: &spec.Dependency{
+			Name:    "bar",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foo/bar"}},
+			Version: "3f9c0a1",
+		},
+	}, {
+		Name: "GithubReleaseAsset",
+		URL:  "https://github.com/foo/bar/releases/download/v1.2.0/lib.libsonnet",
+		Expected: &spec.Dependency{
+			Name: "bar",
+			Source: spec.Source{ReleaseAssetSource: &spec.ReleaseAssetSource{
+				URL: "https://github.com/foo/bar/releases/download/v1.2.0/lib.libsonnet",
+			}},
+			Version: "v1.2.0",
+		},
+	}, {
+		Name: "GitlabSlug",
+		URL:  "gitlab.com/group/project",
+		Expected: &spec.Dependency{
+			Name:    "project",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://gitlab.com/group/project"}},
+			Version: "",
+		},
+	}, {
+		Name: "GitlabSlugWithVersion",
+		URL:  "gitlab.com/group/project@v1",
+		Expected: &spec.Dependency{
+			Name:    "project",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://gitlab.com/group/project"}},
+			Version: "v1",
+		},
+	}, {
+		Name: "GitlabNestedGroups",
+		URL:  "gitlab.com/group/subgroup/project@v1",
+		Expected: &spec.Dependency{
+			Name:    "project",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://gitlab.com/group/subgroup/project"}},
+			Version: "v1",
+		},
+	}, {
+		Name: "GitlabNestedGroupsWithPath",
+		URL:  "gitlab.com/group/subgroup/project//path/lib@v1",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://gitlab.com/group/subgroup/project", Subdir: "path/lib"}},
+			Version: "v1",
+		},
+	}, {
+		Name: "GitlabSuffixWithPath",
+		URL:  "gitlab.com/group/subgroup/project.git/lib",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://gitlab.com/group/subgroup/project", Subdir: "lib"}},
+			Version: "",
+		},
+	}, {
+		Name: "GitlabSuffixWithVersion",
+		URL:  "gitlab.com/group/project.git@v1.2.3",
+		Expected: &spec.Dependency{
+			Name:    "project",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://gitlab.com/group/project"}},
+			Version: "v1.2.3",
+		},
+	}, {
+		Name: "BitbucketSlug",
+		URL:  "bitbucket.org/team/repo",
+		Expected: &spec.Dependency{
+			Name:    "repo",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://bitbucket.org/team/repo"}},
+			Version: "",
+		},
+	}, {
+		Name: "BitbucketSlugWithVersion",
+		URL:  "bitbucket.org/team/repo@v1.0.0",
+		Expected: &spec.Dependency{
+			Name:    "repo",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://bitbucket.org/team/repo"}},
+			Version: "v1.0.0",
+		},
+	}, {
+		Name: "BitbucketSlugWithPath",
+		URL:  "bitbucket.org/team/repo/path/lib",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://bitbucket.org/team/repo", Subdir: "path/lib"}},
+			Version: "",
+		},
+	}, {
+		Name: "BitbucketSlugWithPathAndVersion",
+		URL:  "bitbucket.org/team/my.repo/lib@main",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://bitbucket.org/team/my.repo", Subdir: "lib"}},
+			Version: "main",
+		},
+	}, {
+		Name: "BitbucketSuffix",
+		URL:  "bitbucket.org/team/repo.git/lib@v1",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://bitbucket.org/team/repo", Subdir: "lib"}},
+			Version: "v1",
+		},
+	}, {
+		Name: "GitHTTPSNestedGroups",
+		URL:  "https://gitlab.example.com/group/subgroup/repo.git/path/lib@v1.2.3",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://gitlab.example.com/group/subgroup/repo.git", Subdir: "path/lib"}},
+			Version: "v1.2.3",
+		},
+	}, {
+		Name: "GitHTTPSWithoutSubdir",
+		URL:  "https://bitbucket.example.com/scm/team/repo.git",
+		Expected: &spec.Dependency{
+			Name:    "repo",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://bitbucket.example.com/scm/team/repo.git"}},
+			Version: "",
+		},
+	}, {
+		Name: "GitHTTPSWithoutSuffix",
+		URL:  "https://gitea.example.com/org/repo@v1",
+		Expected: &spec.Dependency{
+			Name:    "repo",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://gitea.example.com/org/repo"}},
+			Version: "v1",
+		},
+	}, {
+		Name:     "GitHTTPSWithoutOwner",
+		URL:      "https://example.com/repo",
+		Expected: nil,
+	}, {
+		Name: "Local",
+		URL:  "./libs/mylib/",
+		Expected: &spec.Dependency{
+			Name:   "mylib",
+			Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "libs/mylib"}},
+		},
+	}, {
+		Name: "Archive",
+		URL:  "https://example.com/pkg-1.2.3.tar.gz",
+		Expected: &spec.Dependency{
+			Name:    "pkg",
+			Source:  spec.Source{ArchiveSource: &spec.ArchiveSource{URL: "https://example.com/pkg-1.2.3.tar.gz"}},
+			Version: "1.2.3",
+		},
+	}, {
+		Name: "ArchiveWithSubdir",
+		URL:  "https://example.com/releases/mixins.zip//jsonnet/lib",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{ArchiveSource: &spec.ArchiveSource{URL: "https://example.com/releases/mixins.zip", Subdir: "jsonnet/lib"}},
+			Version: "mixins",
+		},
+	}, {
+		Name: "ImportPath",
+		URL:  "example.com/foo",
+		Expected: &spec.Dependency{
+			Name: "foo",
+			Source: spec.Source{
+				GitSource: &spec.GitSource{
+					Remote: "example.com/foo",
+				},
+			},
+		},
+	}, {
+		Name:     "Unknown",
+		URL:      "foo",
+		Expected: nil,
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dep, err := parseDepedency(tc.URL)
+			assert.Equal(t, tc.Expected, dep)
+			if tc.Expected == nil {
+				assert.IsType(t, &parser.Error{}, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func jsonnetFileContent(t *testing.T, filename string, content []byte) {
+	bytes, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	if eq := assert.JSONEq(t, string(content), string(bytes)); !eq {
+		t.Log(string(bytes))
+	}
+}
+
+func TestReadPackageList(t *testing.T) {
+	packages, err := readPackageList(strings.NewReader(`# Libraries of the platform.
+github.com/grafana/grafonnet-lib/grafonnet@v1.0.0
+
+  github.com/jsonnet-libs/k8s-libsonnet/1.29   # pinned by the cluster
+git@github.com:org/private.git
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"github.com/grafana/grafonnet-lib/grafonnet@v1.0.0",
+		"github.com/jsonnet-libs/k8s-libsonnet/1.29",
+		"git@github.com:org/private.git",
+	}, packages)
+
+	_, err = readPackageList(strings.NewReader("# nothing\n\n"))
+	assert.EqualError(t, err, "no packages listed")
+
+	_, err = readPackageList(strings.NewReader("github.com/org/a\ngithub.com/org/b github.com/org/c\n"))
+	assert.EqualError(t, err, "line 2 lists more than one package: github.com/org/b github.com/org/c")
+}
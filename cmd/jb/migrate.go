@@ -0,0 +1,81 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// migrateCommand upgrades the jsonnetfile and the lock file in dir to the
+// latest version of the format.
+func migrateCommand(dir string) int {
+	changed, err := client.Migrate(dir)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to migrate"))
+	}
+
+	if len(changed) == 0 {
+		color.Green(">>> Already at version %d\n", spec.LatestVersion)
+		return 0
+	}
+	for _, name := range changed {
+		color.Green(">>> Migrated %s to version %d\n", name, spec.LatestVersion)
+	}
+	color.Yellow(">>> Run jb install to migrate the vendor directory\n")
+	return 0
+}
+
+// migrateLayoutCommand moves the packages of the legacy .jsonnetpkg/src
+// layout of the project in dir into its vendor directory.
+func migrateLayoutCommand(dir, jsonnetHome string, dryRun bool) int {
+	opts := client.MigrateLayoutOptions{}
+	opts.Dir = dir
+	opts.JsonnetHome = jsonnetHome
+	opts.DryRun = dryRun
+	migration, err := client.MigrateLayout(opts)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to migrate the layout"))
+	}
+
+	if migration == nil {
+		color.Green(">>> No legacy layout to migrate\n")
+		return 0
+	}
+	moved := make([]string, 0, len(migration.Moved))
+	for to := range migration.Moved {
+		moved = append(moved, to)
+	}
+	sort.Strings(moved)
+	verb := map[bool]string{false: "Moved", true: "Would move"}[dryRun]
+	for _, to := range moved {
+		color.Green(">>> %s %s to %s\n", verb, migration.Moved[to], to)
+	}
+	verb = map[bool]string{false: "Relinked", true: "Would relink"}[dryRun]
+	for _, link := range migration.Relinked {
+		color.Green(">>> %s %s\n", verb, link)
+	}
+	if dryRun {
+		return 0
+	}
+	color.Green(">>> Removed %s\n", filepath.ToSlash(filepath.Dir(client.LegacyLayoutDir)))
+	color.Yellow(">>> Run jb install to vendor the packages of the jsonnetfile and lock them\n")
+	return 0
+}
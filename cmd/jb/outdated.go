@@ -0,0 +1,66 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/pkg/errors"
+)
+
+// outdatedCommand prints the dependencies of the jsonnetfile in dir that
+// have newer versions upstream than the locked ones.
+func outdatedCommand(ctx context.Context, dir string, opts client.Options) int {
+	opts.Dir = dir
+
+	outdated, err := client.Outdated(ctx, opts)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to check for outdated dependencies"))
+	}
+
+	if output != nil {
+		output.setOutdated(outdated)
+		return 0
+	}
+	if len(outdated) == 0 {
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tLOCKED\tWANTED\tLATEST")
+	for _, o := range outdated {
+		locked := o.LockedTag
+		if locked == "" {
+			locked = shortCommit(o.Locked)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", o.Name, o.Version, locked, shortCommit(o.Wanted), o.Latest)
+	}
+	w.Flush()
+
+	return 0
+}
+
+// shortCommit abbreviates full commit hashes, anything else is returned as
+// is.
+func shortCommit(version string) string {
+	if len(version) == 40 {
+		return version[:7]
+	}
+	return version
+}
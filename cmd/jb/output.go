@@ -0,0 +1,207 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/advisory"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// output collects the result of the command when run with --json, it is
+// nil otherwise.
+var output *jsonOutput
+
+// jsonResult is printed on stdout by commands run with --json, for tools
+// consuming the results of jb. Logs are written to stderr instead.
+type jsonResult struct {
+	Command string `json:"command"`
+	Success bool   `json:"success"`
+	// Dependencies are the packages vendored by install and update.
+	Dependencies []jsonDependency `json:"dependencies,omitempty"`
+	// Tree is the dependency tree printed by list.
+	Tree []listEntry `json:"tree,omitempty"`
+	// Outdated are the dependencies with newer versions found by outdated.
+	Outdated []jsonOutdated `json:"outdated,omitempty"`
+	// Changes are the changes of the lock file found by a dry run or
+	// --diff.
+	Changes []jsonChange `json:"changes,omitempty"`
+	// Files are the files of a package that differ at another version,
+	// found by diff.
+	Files []jsonFileChange `json:"files,omitempty"`
+	// Findings are the locked dependencies affected by advisories, found
+	// by audit.
+	Findings []jsonFinding `json:"findings,omitempty"`
+	// Stats are the sizes of the vendored packages found by stats.
+	Stats  *pkg.VendorStats `json:"stats,omitempty"`
+	Errors []string         `json:"errors,omitempty"`
+}
+
+type jsonDependency struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Version string `json:"version"`
+	Tag     string `json:"tag,omitempty"`
+	Sum     string `json:"sum,omitempty"`
+	Path    string `json:"path"`
+}
+
+type jsonOutdated struct {
+	Name      string `json:"name"`
+	Version   string `json:"version"`
+	Locked    string `json:"locked"`
+	LockedTag string `json:"lockedTag,omitempty"`
+	Wanted    string `json:"wanted,omitempty"`
+	Latest    string `json:"latest,omitempty"`
+}
+
+type jsonChange struct {
+	Name      string            `json:"name"`
+	Kind      client.ChangeKind `json:"kind"`
+	Locked    string            `json:"locked,omitempty"`
+	LockedTag string            `json:"lockedTag,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Tag       string            `json:"tag,omitempty"`
+}
+
+type jsonFinding struct {
+	Name     string            `json:"name"`
+	Version  string            `json:"version"`
+	Tag      string            `json:"tag,omitempty"`
+	Advisory advisory.Advisory `json:"advisory"`
+}
+
+type jsonFileChange struct {
+	Path string             `json:"path"`
+	Kind pkg.FileChangeKind `json:"kind"`
+}
+
+// jsonOutput is also the error writer of kingpin, so that the errors of the
+// command end up in the result.
+type jsonOutput struct {
+	w       io.Writer
+	prefix  string
+	result  jsonResult
+	flushed bool
+}
+
+// newJSONOutput returns a jsonOutput printing to w. prefix is the program
+// name kingpin puts in front of errors.
+func newJSONOutput(w io.Writer, command, prefix string) *jsonOutput {
+	return &jsonOutput{
+		w:      w,
+		prefix: prefix + ": error: ",
+		result: jsonResult{Command: command},
+	}
+}
+
+func (o *jsonOutput) Write(p []byte) (int, error) {
+	msg := strings.TrimPrefix(strings.TrimSpace(string(p)), o.prefix)
+	if msg != "" {
+		o.result.Errors = append(o.result.Errors, msg)
+	}
+	return len(p), nil
+}
+
+// setLock records the dependencies of lock as vendored into jsonnetHome.
+func (o *jsonOutput) setLock(jsonnetHome string, lock spec.JsonnetFile) {
+	if o == nil {
+		return
+	}
+
+	o.result.Dependencies = make([]jsonDependency, 0, len(lock.Dependencies))
+	for _, d := range lock.Dependencies {
+		o.result.Dependencies = append(o.result.Dependencies, jsonDependency{
+			Name:    d.Name,
+			Source:  pkg.SourceString(d.Source),
+			Version: d.Version,
+			Tag:     d.Tag,
+			Sum:     d.Sum,
+			Path:    filepath.ToSlash(vendoredPath(jsonnetHome, d, d)),
+		})
+	}
+}
+
+// setOutdated records the outdated dependencies.
+func (o *jsonOutput) setOutdated(outdated []pkg.Outdated) {
+	if o == nil {
+		return
+	}
+
+	o.result.Outdated = make([]jsonOutdated, 0, len(outdated))
+	for _, d := range outdated {
+		o.result.Outdated = append(o.result.Outdated, jsonOutdated(d))
+	}
+}
+
+// setChanges records the changes of the lock file found by a dry run or
+// --diff.
+func (o *jsonOutput) setChanges(changes []client.Change) {
+	if o == nil {
+		return
+	}
+
+	o.result.Changes = make([]jsonChange, 0, len(changes))
+	for _, c := range changes {
+		o.result.Changes = append(o.result.Changes, jsonChange(c))
+	}
+}
+
+// setFiles records the files of a package that differ at another version.
+func (o *jsonOutput) setFiles(files []pkg.FileChange) {
+	if o == nil {
+		return
+	}
+
+	o.result.Files = make([]jsonFileChange, 0, len(files))
+	for _, f := range files {
+		o.result.Files = append(o.result.Files, jsonFileChange(f))
+	}
+}
+
+// setFindings records the locked dependencies affected by advisories.
+func (o *jsonOutput) setFindings(findings []advisory.Finding) {
+	if o == nil {
+		return
+	}
+
+	o.result.Findings = make([]jsonFinding, 0, len(findings))
+	for _, f := range findings {
+		o.result.Findings = append(o.result.Findings, jsonFinding(f))
+	}
+}
+
+// flush prints the result, as a success if code is 0 or exitChanged. Only
+// the first call prints anything.
+func (o *jsonOutput) flush(code int) {
+	if o == nil || o.flushed {
+		return
+	}
+	o.flushed = true
+
+	o.result.Success = code == 0 || code == exitChanged
+	b, err := json.MarshalIndent(o.result, "", "    ")
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"command": %q, "success": false, "errors": [%q]}`, o.result.Command, err.Error()))
+	}
+	fmt.Fprintln(o.w, string(b))
+}
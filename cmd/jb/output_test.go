@@ -0,0 +1,65 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONOutput(t *testing.T) {
+	var buf bytes.Buffer
+	o := newJSONOutput(&buf, "install", "jb")
+
+	flatten := false
+	o.setLock("vendor", spec.JsonnetFile{Dependencies: []spec.Dependency{{
+		Name:    "foo",
+		Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/org/foo", Subdir: "lib"}},
+		Version: "0123456789abcdef0123456789abcdef01234567",
+		Tag:     "v1.2.0",
+		Sum:     "c3Vt",
+		Flatten: &flatten,
+	}}})
+	o.Write([]byte("jb: error: failed to write lock file\n"))
+	o.flush(3)
+	// Only the first flush prints.
+	o.flush(0)
+
+	var result jsonResult
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &result))
+	assert.Equal(t, jsonResult{
+		Command: "install",
+		Success: false,
+		Dependencies: []jsonDependency{{
+			Name:    "foo",
+			Source:  "https://github.com/org/foo/lib",
+			Version: "0123456789abcdef0123456789abcdef01234567",
+			Tag:     "v1.2.0",
+			Sum:     "c3Vt",
+			Path:    "vendor/foo/lib",
+		}},
+		Errors: []string{"failed to write lock file"},
+	}, result)
+}
+
+func TestJSONOutputNil(t *testing.T) {
+	var o *jsonOutput
+	o.setLock("vendor", spec.JsonnetFile{})
+	o.flush(0)
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// pinCommand freezes the given packages, by name or by the URL they were
+// installed with, in the jsonnetfile, so that jb update keeps them at their
+// locked version, or unfreezes them if frozen is false.
+func pinCommand(dir string, frozen bool, packages ...string) int {
+	if dir == "" {
+		dir = "."
+	}
+
+	filename := filepath.Join(dir, jsonnetfile.File)
+	jsonnetFile, err := jsonnetfile.Load(filename)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to load jsonnetfile"))
+	}
+
+	names := map[string]bool{}
+	for _, p := range packages {
+		name := client.DependencyName(jsonnetFile, p)
+		if name == "" {
+			kingpin.Errorf("package %s is not a dependency in %s", p, jsonnetfile.File)
+			return exitFailure
+		}
+		names[name] = true
+	}
+
+	for i, d := range jsonnetFile.Dependencies {
+		if !names[d.Name] {
+			continue
+		}
+		jsonnetFile.Dependencies[i].Frozen = frozen
+		if frozen {
+			color.Green(">>> Froze %s\n", d.Name)
+		} else {
+			color.Green(">>> Unfroze %s\n", d.Name)
+		}
+	}
+
+	if err := jsonnetfile.Write(filename, jsonnetFile); err != nil {
+		return fail(errors.Wrap(err, "failed to write jsonnet file"))
+	}
+	return 0
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPinCommand(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	jsonnetFile := filepath.Join(tempDir, jsonnetfile.File)
+	err = ioutil.WriteFile(jsonnetFile, []byte(`{"dependencies": [
+		{"name": "foo", "source": {"git": {"remote": "https://github.com/org/foo", "subdir": ""}}, "version": "master"},
+		{"name": "lib", "source": {"git": {"remote": "https://github.com/org/bar", "subdir": "lib"}}, "version": "master"}
+	]}`), 0644)
+	assert.NoError(t, err)
+
+	// Packages can be pinned by name or by URL.
+	assert.Equal(t, 0, pinCommand(tempDir, true, "foo", "github.com/org/bar/lib"))
+	jsonnetFileContent(t, jsonnetFile, []byte(`{"dependencies": [
+		{"name": "foo", "source": {"git": {"remote": "https://github.com/org/foo", "subdir": ""}}, "version": "master", "frozen": true},
+		{"name": "lib", "source": {"git": {"remote": "https://github.com/org/bar", "subdir": "lib"}}, "version": "master", "frozen": true}
+	]}`))
+
+	assert.Equal(t, 0, pinCommand(tempDir, false, "lib"))
+	jsonnetFileContent(t, jsonnetFile, []byte(`{"dependencies": [
+		{"name": "foo", "source": {"git": {"remote": "https://github.com/org/foo", "subdir": ""}}, "version": "master", "frozen": true},
+		{"name": "lib", "source": {"git": {"remote": "https://github.com/org/bar", "subdir": "lib"}}, "version": "master"}
+	]}`))
+
+	assert.Equal(t, 1, pinCommand(tempDir, true, "unknown"))
+}
@@ -0,0 +1,35 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+)
+
+// prefetchCommand fetches the packages of the lock file in dir into the
+// cache without vendoring them.
+func prefetchCommand(ctx context.Context, dir string, opts client.Options) int {
+	opts.Dir = dir
+	lock, err := client.Prefetch(ctx, client.PrefetchOptions{Options: opts})
+	if err != nil {
+		return fail(err)
+	}
+
+	color.Green(">>> Prefetched %d packages into %s\n", len(lock.Dependencies), opts.CacheDir)
+	return 0
+}
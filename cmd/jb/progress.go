@@ -0,0 +1,120 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+)
+
+// progressWidth is the number of characters of the bar itself.
+const progressWidth = 20
+
+// progressBar shows the progress of an install on the last line of a
+// terminal, redrawn whenever a package reaches another stage. Messages
+// written through writer clear the line first and redraw it afterwards,
+// so that they do not mix with it.
+type progressBar struct {
+	mu     sync.Mutex
+	term   io.Writer
+	stages map[string]pkg.Stage
+	// order holds the packages in the order they were started.
+	order []string
+	done  int
+	drawn bool
+}
+
+func newProgressBar(term io.Writer) *progressBar {
+	return &progressBar{term: term, stages: map[string]pkg.Stage{}}
+}
+
+// report is the pkg.ProgressFunc of the bar.
+func (b *progressBar) report(name string, stage pkg.Stage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.stages[name]; !ok {
+		b.order = append(b.order, name)
+	}
+	if stage == pkg.StageDone && b.stages[name] != pkg.StageDone {
+		b.done++
+	}
+	b.stages[name] = stage
+	b.draw()
+}
+
+// draw writes the line of the bar: the share of packages done and the
+// stage of the most recently started package still in progress.
+func (b *progressBar) draw() {
+	filled := progressWidth
+	if total := len(b.order); total > 0 {
+		filled = progressWidth * b.done / total
+	}
+	line := fmt.Sprintf("[%s%s] %d/%d", strings.Repeat("#", filled), strings.Repeat("-", progressWidth-filled), b.done, len(b.order))
+
+	for n := len(b.order) - 1; n >= 0; n-- {
+		name := b.order[n]
+		if stage := b.stages[name]; stage != pkg.StageDone {
+			line += fmt.Sprintf(" %s %s", stage, name)
+			break
+		}
+	}
+
+	fmt.Fprintf(b.term, "\r\033[K%s", line)
+	b.drawn = true
+}
+
+// clear removes the line of the bar.
+func (b *progressBar) clear() {
+	if b.drawn {
+		fmt.Fprint(b.term, "\r\033[K")
+		b.drawn = false
+	}
+}
+
+// finish removes the bar for good.
+func (b *progressBar) finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clear()
+	b.order = nil
+}
+
+// writer returns a writer to w that keeps the bar below what is written.
+func (b *progressBar) writer(w io.Writer) io.Writer {
+	return progressWriter{bar: b, w: w}
+}
+
+type progressWriter struct {
+	bar *progressBar
+	w   io.Writer
+}
+
+func (p progressWriter) Write(data []byte) (int, error) {
+	p.bar.mu.Lock()
+	defer p.bar.mu.Unlock()
+
+	redraw := p.bar.drawn
+	p.bar.clear()
+	n, err := p.w.Write(data)
+	if redraw && len(p.bar.order) > 0 {
+		p.bar.draw()
+	}
+	return n, err
+}
@@ -0,0 +1,51 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressBar(t *testing.T) {
+	term := &bytes.Buffer{}
+	bar := newProgressBar(term)
+
+	bar.report("foo", pkg.StageCloning)
+	assert.Equal(t, "\r\033[K[--------------------] 0/1 cloning foo", term.String())
+
+	term.Reset()
+	bar.report("bar", pkg.StageFetching)
+	bar.report("bar", pkg.StageDone)
+	assert.Equal(t, "\r\033[K[--------------------] 0/2 fetching bar\r\033[K[##########----------] 1/2 cloning foo", term.String())
+
+	// Messages replace the bar, which is drawn again below them.
+	term.Reset()
+	fmt.Fprintln(bar.writer(term), ">>> message")
+	assert.Equal(t, "\r\033[K>>> message\n\r\033[K[##########----------] 1/2 cloning foo", term.String())
+
+	term.Reset()
+	bar.report("foo", pkg.StageDone)
+	bar.finish()
+	assert.Equal(t, "\r\033[K[####################] 2/2\r\033[K", term.String())
+
+	term.Reset()
+	fmt.Fprintln(bar.writer(term), ">>> message")
+	assert.Equal(t, ">>> message\n", term.String())
+}
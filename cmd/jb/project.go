@@ -0,0 +1,70 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// projectDir returns the directory jb runs in for args: the one given by
+// the chdir flag, relative to cwd, or else the nearest directory holding a
+// jsonnetfile from cwd up, see findProject. jb init creates a project
+// where it is run and is not looked up for. The arguments are only
+// tokenized here, without running any action, they are parsed once the
+// config of the project was applied.
+func projectDir(a *kingpin.Application, chdir *kingpin.FlagClause, cwd string, args []string) (string, error) {
+	ctx, _ := a.ParseContext(args)
+	if ctx == nil {
+		return cwd, nil
+	}
+
+	for _, e := range ctx.Elements {
+		if e.Clause != chdir || e.Value == nil {
+			continue
+		}
+		dir := *e.Value
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(cwd, dir)
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return "", errors.Errorf("cannot change to %s, no such directory", *e.Value)
+		}
+		return dir, nil
+	}
+
+	if ctx.SelectedCommand != nil && ctx.SelectedCommand.FullCommand() == initActionName {
+		return cwd, nil
+	}
+	return findProject(cwd), nil
+}
+
+// findProject returns the nearest directory holding a jsonnetfile from dir
+// up, like git finds the root of a repository, so that jb can be run from
+// any subdirectory of a project. It is dir if there is none.
+func findProject(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		if exists, _ := pkg.FileExists(filepath.Join(d, pkg.JsonnetFile)); exists {
+			return d
+		}
+		if filepath.Dir(d) == d {
+			return dir
+		}
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func TestProjectDir(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	project := filepath.Join(tempDir, "project")
+	sub := filepath.Join(project, "lib", "sub")
+	assert.NoError(t, os.MkdirAll(sub, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(project, pkg.JsonnetFile), []byte("{}"), 0644))
+
+	a := kingpin.New("jb", "")
+	chdir := a.Flag("chdir", "").Short('C')
+	chdir.String()
+	a.Flag("jsonnetpkg-home", "").String()
+	a.Command(initActionName, "")
+	a.Command(installActionName, "").Arg("packages", "").Strings()
+
+	testcases := []struct {
+		Name     string
+		Cwd      string
+		Args     []string
+		Expected string
+		Error    string
+	}{{
+		Name:     "Root",
+		Cwd:      project,
+		Args:     []string{"install"},
+		Expected: project,
+	}, {
+		Name:     "Subdirectory",
+		Cwd:      sub,
+		Args:     []string{"--jsonnetpkg-home", "vendor", "install", "github.com/foo/bar"},
+		Expected: project,
+	}, {
+		Name:     "NoProject",
+		Cwd:      tempDir,
+		Args:     []string{"install"},
+		Expected: tempDir,
+	}, {
+		Name:     "Init",
+		Cwd:      sub,
+		Args:     []string{"init"},
+		Expected: sub,
+	}, {
+		Name:     "Chdir",
+		Cwd:      project,
+		Args:     []string{"-C", "lib", "install"},
+		Expected: filepath.Join(project, "lib"),
+	}, {
+		Name:     "ChdirAbsolute",
+		Cwd:      sub,
+		Args:     []string{"--chdir=" + tempDir, "install"},
+		Expected: tempDir,
+	}, {
+		Name:  "ChdirMissing",
+		Cwd:   project,
+		Args:  []string{"-C", "missing", "install"},
+		Error: "cannot change to missing, no such directory",
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir, err := projectDir(a, chdir, tc.Cwd, tc.Args)
+			if tc.Error != "" {
+				assert.EqualError(t, err, tc.Error)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Expected, dir)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// publishCommand validates the package in opts.Dir and tags it with the
+// next version, see client.Publish.
+func publishCommand(ctx context.Context, opts client.PublishOptions) int {
+	tag, err := client.Publish(ctx, opts)
+	if invalid, ok := errors.Cause(err).(*client.InvalidPackageError); ok {
+		for _, reason := range invalid.Reasons {
+			color.Yellow("%s\n", reason)
+		}
+		kingpin.Errorf("the package cannot be published")
+		return exitFailure
+	}
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to publish"))
+	}
+
+	switch {
+	case opts.DryRun:
+		color.Green(">>> Would tag %s\n", tag)
+	case opts.Push:
+		color.Green(">>> Tagged and pushed %s\n", tag)
+	default:
+		color.Green(">>> Tagged %s, push it with git push %s %s\n", tag, opts.Remote, tag)
+	}
+	if opts.OCI != "" && !opts.DryRun {
+		color.Green(">>> Pushed %s:%s\n", opts.OCI, tag)
+	}
+	return 0
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// removeCommand removes the given packages, by name or by the URL they were
+// installed with, from the jsonnetfile and the lock file and deletes them
+// from the vendor directory.
+func removeCommand(dir, jsonnetHome string, packages ...string) int {
+	if dir == "" {
+		dir = "."
+	}
+
+	filename := filepath.Join(dir, jsonnetfile.File)
+	jsonnetFile, err := jsonnetfile.Load(filename)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to load jsonnetfile"))
+	}
+
+	lockFilename := filepath.Join(dir, jsonnetfile.LockFile)
+	lockExists, err := pkg.FileExists(lockFilename)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to check for lock file"))
+	}
+	lockFile := spec.JsonnetFile{}
+	if lockExists {
+		lockFile, err = jsonnetfile.Load(lockFilename)
+		if err != nil {
+			return fail(errors.Wrap(err, "failed to load lock file"))
+		}
+	}
+
+	names := map[string]bool{}
+	for _, p := range packages {
+		name := client.DependencyName(jsonnetFile, p)
+		if name == "" {
+			kingpin.Errorf("package %s is not a dependency in %s", p, jsonnetfile.File)
+			return exitFailure
+		}
+		names[name] = true
+	}
+
+	jsonnetFile.Dependencies = withoutDependencies(jsonnetFile.Dependencies, names)
+	lockFile.Dependencies = withoutDependencies(lockFile.Dependencies, names)
+
+	for name := range names {
+		if err := os.RemoveAll(filepath.Join(jsonnetHome, name)); err != nil {
+			return fail(errors.Wrapf(err, "failed to remove %s from %s", name, jsonnetHome))
+		}
+		// The link of the short name of a qualified package goes with it.
+		if strings.Contains(name, "/") {
+			link := filepath.Join(jsonnetHome, path.Base(name))
+			if fi, err := os.Lstat(link); err == nil && fi.Mode()&os.ModeSymlink != 0 {
+				os.Remove(link)
+			}
+		}
+		color.Green(">>> Removed %s\n", name)
+	}
+
+	if err := jsonnetfile.Write(filename, jsonnetFile); err != nil {
+		return fail(errors.Wrap(err, "failed to write jsonnet file"))
+	}
+	if lockExists {
+		if err := jsonnetfile.Write(lockFilename, lockFile); err != nil {
+			return fail(errors.Wrap(err, "failed to write lock file"))
+		}
+	}
+
+	return 0
+}
+
+func withoutDependencies(deps []spec.Dependency, names map[string]bool) []spec.Dependency {
+	res := []spec.Dependency{}
+	for _, d := range deps {
+		if !names[d.Name] {
+			res = append(res, d)
+		}
+	}
+	return res
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRemoveCommand(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-remove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	deps := `{"dependencies": [
+		{"name": "foo", "source": {"git": {"remote": "https://github.com/org/foo", "subdir": ""}}, "version": "%s"},
+		{"name": "lib", "source": {"git": {"remote": "https://github.com/org/bar", "subdir": "lib"}}, "version": "%s"}
+	]}`
+	jsonnetFile := filepath.Join(tempDir, jsonnetfile.File)
+	jsonnetLockFile := filepath.Join(tempDir, jsonnetfile.LockFile)
+	vendor := filepath.Join(tempDir, "vendor")
+
+	err = ioutil.WriteFile(jsonnetFile, []byte(fmt.Sprintf(deps, "master", "master")), 0644)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(jsonnetLockFile, []byte(fmt.Sprintf(deps, "abc", "def")), 0644)
+	assert.NoError(t, err)
+	for _, name := range []string{"foo", "lib"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(vendor, name), os.ModePerm))
+	}
+
+	// Packages can be removed by name or by URL.
+	code := removeCommand(tempDir, vendor, "foo", "github.com/org/bar/lib")
+	assert.Equal(t, 0, code)
+
+	jsonnetFileContent(t, jsonnetFile, []byte(`{"dependencies": []}`))
+	jsonnetFileContent(t, jsonnetLockFile, []byte(`{"dependencies": []}`))
+
+	entries, err := ioutil.ReadDir(vendor)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	code = removeCommand(tempDir, vendor, "foo")
+	assert.Equal(t, 1, code)
+}
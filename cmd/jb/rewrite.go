@@ -0,0 +1,102 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/imports"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// rewriteImportsCommand validates the imports of the jsonnet files in dir
+// and in the vendored packages against the vendor layout, and rewrites the
+// imports of dir that use the paths of another layout, like the one of
+// --no-flatten or of other package managers. Vendored packages are only
+// checked, as a reinstall would undo any change to them. With check
+// nothing is rewritten, and needed rewrites fail the command.
+func rewriteImportsCommand(dir, jsonnetHome string, check bool) int {
+	if dir == "" {
+		dir = "."
+	}
+
+	filename := filepath.Join(dir, jsonnetfile.LockFile)
+	m, err := pkg.LoadJsonnetfile(filename)
+	if os.IsNotExist(err) {
+		filename = filepath.Join(dir, jsonnetfile.File)
+		m, err = pkg.LoadJsonnetfile(filename)
+	}
+	if err != nil {
+		return fail(errors.Wrapf(err, "failed to load %s", filename))
+	}
+
+	vendorDir := jsonnetHome
+	if !filepath.IsAbs(jsonnetHome) {
+		jsonnetHome = filepath.Join(dir, jsonnetHome)
+	}
+	jpath, err := projectJpath(dir, vendorDir)
+	if err != nil {
+		return fail(err)
+	}
+	jpath = append(jpath, envJpath()...)
+	aliases := imports.Aliases(m.Dependencies)
+
+	own, err := imports.Scan(dir, filepath.Base(jsonnetHome))
+	if err != nil {
+		return fail(errors.Wrapf(err, "failed to scan %s for imports", dir))
+	}
+	vendored, err := imports.Scan(jsonnetHome)
+	if err != nil && !os.IsNotExist(err) {
+		return fail(errors.Wrapf(err, "failed to scan %s for imports", jsonnetHome))
+	}
+
+	rewrites, unresolved := imports.Check(own, aliases, jpath, vendorDir)
+	_, unresolvedVendored := imports.Check(vendored, nil, jpath, vendorDir)
+	unresolved = append(unresolved, unresolvedVendored...)
+
+	for _, r := range rewrites {
+		fmt.Printf("%s:%d: %s -> %s\n", r.Import.File, r.Import.Line, r.Import.Path, r.Path)
+	}
+	for _, imp := range unresolved {
+		color.Yellow("%s:%d: %s does not resolve\n", imp.File, imp.Line, imp.Path)
+	}
+
+	if check {
+		if len(rewrites) > 0 || len(unresolved) > 0 {
+			kingpin.Errorf("%d imports need to be rewritten, %d do not resolve", len(rewrites), len(unresolved))
+			return exitFailure
+		}
+		return 0
+	}
+
+	if err := imports.Apply(rewrites); err != nil {
+		return fail(errors.Wrap(err, "failed to rewrite imports"))
+	}
+	if len(rewrites) > 0 {
+		color.Green(">>> Rewrote %d imports\n", len(rewrites))
+	}
+
+	if len(unresolved) > 0 {
+		kingpin.Errorf("%d imports do not resolve", len(unresolved))
+		return exitFailure
+	}
+	return 0
+}
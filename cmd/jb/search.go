@@ -0,0 +1,92 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/registry"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// searchCommand prints the packages of the registry index that match term.
+func searchCommand(ctx context.Context, caFile, index, term string) int {
+	ix, err := loadIndex(ctx, caFile, index)
+	if err != nil {
+		return fail(err)
+	}
+
+	found := ix.Search(term)
+	if len(found) == 0 {
+		kingpin.Errorf("no package matches %s", term)
+		return exitNotFound
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tLATEST\tDESCRIPTION")
+	for _, p := range found {
+		latest := p.Latest
+		if latest == "" {
+			latest = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", p.Name, latest, p.Description)
+	}
+	w.Flush()
+
+	return 0
+}
+
+// infoCommand prints the package name of the registry index, and how to
+// install it.
+func infoCommand(ctx context.Context, caFile, index, name string) int {
+	ix, err := loadIndex(ctx, caFile, index)
+	if err != nil {
+		return fail(err)
+	}
+
+	p, ok := ix.Lookup(name)
+	if !ok {
+		kingpin.Errorf("%s is not in the registry", name)
+		return exitNotFound
+	}
+
+	fmt.Printf("name:        %s\n", p.Name)
+	if p.Description != "" {
+		fmt.Printf("description: %s\n", p.Description)
+	}
+	fmt.Printf("remote:      %s\n", p.Remote)
+	if p.Latest != "" {
+		fmt.Printf("latest:      %s\n", p.Latest)
+	}
+	fmt.Printf("install:     jb install %s\n", p.Remote)
+
+	return 0
+}
+
+// loadIndex loads the registry index at the URL or file index.
+func loadIndex(ctx context.Context, caFile, index string) (*registry.Index, error) {
+	if index == "" {
+		return nil, fmt.Errorf("no registry configured, set --registry or %s", registry.Env)
+	}
+	ix, err := registry.Load(ctx, caFile, index)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load registry")
+	}
+	return ix, nil
+}
@@ -0,0 +1,213 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/mattn/go-isatty"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// errSelectionCanceled is returned by selectUpdates when the selection is
+// left without updating anything.
+var errSelectionCanceled = errors.New("selection canceled")
+
+// updateCandidate is a version a dependency can be updated to, Package
+// being the argument of jb update that selects it.
+type updateCandidate struct {
+	Name      string
+	Locked    string
+	Candidate string
+	Package   string
+}
+
+// interactiveUpdateCommand lists the dependencies of dir that have newer
+// versions upstream and updates the ones selected, like jb update with
+// them as arguments. Without a terminal, or with --non-interactive or
+// --json, it updates like jb update.
+func interactiveUpdateCommand(ctx context.Context, dir string, opts client.UpdateOptions) int {
+	if len(opts.Packages) > 0 {
+		kingpin.Errorf("--interactive selects the packages to update, none can be given")
+		return exitUsage
+	}
+	if nonInteractive || output != nil || !isatty.IsTerminal(os.Stdout.Fd()) {
+		return updateCommand(ctx, opts)
+	}
+
+	opts.Dir = dir
+	outdated, err := client.Outdated(ctx, opts.Options)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to check for outdated dependencies"))
+	}
+	candidates := updateCandidates(outdated)
+	if len(candidates) == 0 {
+		color.Green(">>> All dependencies are up to date\n")
+		return 0
+	}
+
+	restore, err := rawTerminal()
+	if err != nil {
+		color.Yellow(">>> %v, updating all dependencies\n", err)
+		return updateCommand(ctx, opts)
+	}
+	packages, err := selectUpdates(os.Stdin, os.Stdout, candidates)
+	restore()
+	switch {
+	case err == errSelectionCanceled:
+		color.Yellow(">>> Canceled, nothing was updated\n")
+		return 0
+	case err != nil:
+		return fail(err)
+	case len(packages) == 0:
+		color.Yellow(">>> Nothing selected, nothing was updated\n")
+		return 0
+	}
+
+	opts.Packages = packages
+	return updateCommand(ctx, opts)
+}
+
+// updateCandidates returns the versions the dependencies of outdated can
+// be updated to: the newest version their jsonnetfile allows, and their
+// latest release if it is another one, which they then track.
+func updateCandidates(outdated []pkg.Outdated) []updateCandidate {
+	candidates := []updateCandidate{}
+	for _, o := range outdated {
+		locked := displayVersion(o.Locked, o.LockedTag)
+		if o.Wanted != "" {
+			candidates = append(candidates, updateCandidate{Name: o.Name, Locked: locked, Candidate: shortCommit(o.Wanted), Package: o.Name})
+		}
+		if o.Latest != "" && o.Latest != o.Wanted {
+			candidates = append(candidates, updateCandidate{Name: o.Name, Locked: locked, Candidate: o.Latest + " (latest)", Package: o.Name + "@latest"})
+		}
+	}
+	return candidates
+}
+
+// selectUpdates lets the candidates to update be selected with the keys
+// read from in, a terminal reading single keys, drawing the list on out.
+// The arrow keys or j and k move, space selects, a selects all or none,
+// enter updates the selected candidates and q cancels. It returns the
+// packages of the selected candidates, a dependency selected at its
+// latest release only once, and context.Canceled for ^C.
+func selectUpdates(in io.Reader, out io.Writer, candidates []updateCandidate) ([]string, error) {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	for _, c := range candidates {
+		fmt.Fprintf(w, "%s\t%s\t-> %s\n", c.Name, c.Locked, c.Candidate)
+	}
+	w.Flush()
+	labels := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n")
+
+	selected := make([]bool, len(candidates))
+	cursor := 0
+	draw := func(again bool) {
+		if again {
+			fmt.Fprintf(out, "\x1b[%dA", len(labels))
+		}
+		for n, label := range labels {
+			pointer, box := " ", "[ ]"
+			if n == cursor {
+				pointer = ">"
+			}
+			if selected[n] {
+				box = "[x]"
+			}
+			fmt.Fprintf(out, "\r\x1b[2K%s %s %s\n", pointer, box, label)
+		}
+	}
+
+	fmt.Fprintln(out, "Select the dependencies to update (up/down moves, space selects, a selects all, enter updates, q cancels):")
+	draw(false)
+	r := bufio.NewReader(in)
+	for {
+		key, err := r.ReadByte()
+		if err == io.EOF {
+			return nil, errSelectionCanceled
+		}
+		if err != nil {
+			return nil, err
+		}
+		if key == 0x1b {
+			// Arrow keys are ESC [ A and ESC [ B.
+			seq := make([]byte, 2)
+			if _, err := io.ReadFull(r, seq); err != nil || seq[0] != '[' {
+				continue
+			}
+			key = map[byte]byte{'A': 'k', 'B': 'j'}[seq[1]]
+		}
+
+		switch key {
+		case 'k':
+			if cursor > 0 {
+				cursor--
+			}
+		case 'j':
+			if cursor < len(candidates)-1 {
+				cursor++
+			}
+		case ' ':
+			selected[cursor] = !selected[cursor]
+		case 'a':
+			all := true
+			for _, s := range selected {
+				all = all && s
+			}
+			for n := range selected {
+				selected[n] = !all
+			}
+		case '\r', '\n':
+			return selectedPackages(candidates, selected), nil
+		case 'q':
+			return nil, errSelectionCanceled
+		case 0x03:
+			return nil, context.Canceled
+		default:
+			continue
+		}
+		draw(true)
+	}
+}
+
+// selectedPackages returns the packages of the selected candidates, the
+// latest release of a dependency taking precedence over its other version.
+func selectedPackages(candidates []updateCandidate, selected []bool) []string {
+	latest := map[string]bool{}
+	for n, c := range candidates {
+		if selected[n] && c.Package != c.Name {
+			latest[c.Name] = true
+		}
+	}
+
+	packages := []string{}
+	for n, c := range candidates {
+		if selected[n] && (c.Package != c.Name || !latest[c.Name]) {
+			packages = append(packages, c.Package)
+		}
+	}
+	return packages
+}
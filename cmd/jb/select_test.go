@@ -0,0 +1,75 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateCandidates(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	candidates := updateCandidates([]pkg.Outdated{
+		{Name: "branch", Version: "master", Locked: commit, Wanted: "fedcba9876543210fedcba9876543210fedcba98"},
+		{Name: "ranged", Version: "~1.2", Locked: commit, LockedTag: "v1.2.0", Wanted: "v1.2.3", Latest: "v2.0.0"},
+		{Name: "pinned", Version: "v1.0.0", Locked: commit, LockedTag: "v1.0.0", Latest: "v1.1.0"},
+	})
+	assert.Equal(t, []updateCandidate{
+		{Name: "branch", Locked: "0123456", Candidate: "fedcba9", Package: "branch"},
+		{Name: "ranged", Locked: "v1.2.0", Candidate: "v1.2.3", Package: "ranged"},
+		{Name: "ranged", Locked: "v1.2.0", Candidate: "v2.0.0 (latest)", Package: "ranged@latest"},
+		{Name: "pinned", Locked: "v1.0.0", Candidate: "v1.1.0 (latest)", Package: "pinned@latest"},
+	}, candidates)
+}
+
+func TestSelectUpdates(t *testing.T) {
+	candidates := []updateCandidate{
+		{Name: "foo", Locked: "v1.0.0", Candidate: "v1.1.0", Package: "foo"},
+		{Name: "foo", Locked: "v1.0.0", Candidate: "v2.0.0 (latest)", Package: "foo@latest"},
+		{Name: "bar", Locked: "0123456", Candidate: "fedcba9", Package: "bar"},
+	}
+
+	testcases := []struct {
+		Name     string
+		Keys     string
+		Packages []string
+		Err      error
+	}{
+		{Name: "Nothing", Keys: "\r", Packages: []string{}},
+		{Name: "Arrows", Keys: "\x1b[B\x1b[B \x1b[A\x1b[A \r", Packages: []string{"foo", "bar"}},
+		{Name: "Toggle", Keys: "  jj \r", Packages: []string{"bar"}},
+		{Name: "LatestWins", Keys: " j \r", Packages: []string{"foo@latest"}},
+		{Name: "All", Keys: "a\n", Packages: []string{"foo@latest", "bar"}},
+		{Name: "NoneAgain", Keys: "aa\r", Packages: []string{}},
+		{Name: "Quit", Keys: " q", Err: errSelectionCanceled},
+		{Name: "EOF", Keys: " ", Err: errSelectionCanceled},
+		{Name: "Interrupt", Keys: "\x03", Err: context.Canceled},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			var out bytes.Buffer
+			packages, err := selectUpdates(strings.NewReader(tc.Keys), &out, candidates)
+			assert.Equal(t, tc.Err, err)
+			assert.Equal(t, tc.Packages, packages)
+			assert.Contains(t, out.String(), "> [ ] foo  v1.0.0   -> v1.1.0")
+		})
+	}
+}
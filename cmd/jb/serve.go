@@ -0,0 +1,62 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/server"
+	"github.com/pkg/errors"
+)
+
+// serveTokenEnv sets the bearer token of jb serve, see server.Server.
+const serveTokenEnv = "JB_SERVE_TOKEN"
+
+// serveCommand serves the API of package server for the project in dir on
+// the address listen until ctx is canceled, to clients authorizing with
+// token, a random one printed if empty.
+func serveCommand(ctx context.Context, dir, listen, token string, opts client.Options) int {
+	opts.Dir = dir
+	if token == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return fail(errors.Wrap(err, "failed to generate a token"))
+		}
+		token = hex.EncodeToString(b)
+		color.Green(">>> Clients authorize with Authorization: Bearer %s\n", token)
+	}
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to listen"))
+	}
+
+	srv := &http.Server{Handler: &server.Server{Options: opts, DefaultVersion: defaultBranch, Token: token}}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+
+	color.Green(">>> Serving the API of %s on http://%s\n", dir, l.Addr())
+	if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+		return fail(err)
+	}
+	return 0
+}
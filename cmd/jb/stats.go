@@ -0,0 +1,97 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/pkg/errors"
+)
+
+// statsCommand prints the size and file count of every locked package, the
+// top largest files and the files vendored more than once, read from the
+// vendor directory alone.
+func statsCommand(dir, jsonnetHome string, top int) int {
+	if dir == "" {
+		dir = "."
+	}
+
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to load lock file"))
+	}
+	if !filepath.IsAbs(jsonnetHome) {
+		jsonnetHome = filepath.Join(dir, jsonnetHome)
+	}
+	stats, err := pkg.Stats(jsonnetHome, lock, top)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to read vendor directory"))
+	}
+
+	if output != nil {
+		output.result.Stats = stats
+		return 0
+	}
+
+	printStats(os.Stdout, stats)
+	return 0
+}
+
+func printStats(out io.Writer, stats *pkg.VendorStats) {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFILES\tSIZE\tSHARE")
+	for _, p := range stats.Packages {
+		if p.Linked {
+			fmt.Fprintf(w, "%s\t-\tlinked\t-\n", p.Name)
+			continue
+		}
+		share := 0.0
+		if stats.Size > 0 {
+			share = 100 * float64(p.Size) / float64(stats.Size)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%.0f%%\n", p.Name, p.Files, humanSize(p.Size), share)
+	}
+	fmt.Fprintf(w, "TOTAL\t%d\t%s\t\n", stats.Files, humanSize(stats.Size))
+	w.Flush()
+
+	if len(stats.Largest) > 0 {
+		fmt.Fprintln(out, "\nLargest files:")
+		w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		for _, f := range stats.Largest {
+			fmt.Fprintf(w, "  %s\t%s\n", humanSize(f.Size), f.Path)
+		}
+		w.Flush()
+	}
+
+	if len(stats.Duplicates) > 0 {
+		wasted := int64(0)
+		for _, d := range stats.Duplicates {
+			wasted += d.Wasted()
+		}
+		fmt.Fprintf(out, "\nDuplicate files, %s wasted:\n", humanSize(wasted))
+		w = tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		for _, d := range stats.Duplicates {
+			fmt.Fprintf(w, "  %s\tx%d\t%s\n", humanSize(d.Size), len(d.Paths), strings.Join(d.Paths, ", "))
+		}
+		w.Flush()
+	}
+}
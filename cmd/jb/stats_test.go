@@ -0,0 +1,50 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintStats(t *testing.T) {
+	stats := &pkg.VendorStats{
+		Packages: []pkg.PackageStats{{Name: "a", Files: 2, Size: 3072}, {Name: "b", Files: 1, Size: 1024}, {Name: "local", Linked: true}},
+		Files:    3,
+		Size:     4096,
+		Largest:  []pkg.FileStats{{Path: "a/big.json", Size: 2048}},
+		Duplicates: []pkg.DuplicateStats{
+			{Size: 1024, Paths: []string{"a/util.libsonnet", "b/util.libsonnet"}},
+		},
+	}
+
+	b := bytes.NewBuffer(nil)
+	printStats(b, stats)
+	assert.Equal(t, `NAME   FILES  SIZE     SHARE
+a      2      3.0 KiB  75%
+b      1      1.0 KiB  25%
+local  -      linked   -
+TOTAL  3      4.0 KiB  
+
+Largest files:
+  2.0 KiB  a/big.json
+
+Duplicate files, 1.0 KiB wasted:
+  1.0 KiB  x2  a/util.libsonnet, b/util.libsonnet
+`, b.String())
+}
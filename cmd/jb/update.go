@@ -0,0 +1,226 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+func updateCommand(ctx context.Context, opts client.UpdateOptions) int {
+	committed, err := committedLock(opts.Dir)
+	if err != nil {
+		return fail(err)
+	}
+	if opts.Target != "" {
+		committed = client.TargetLock(committed, opts.Target)
+	}
+
+	lock, err := client.Update(ctx, opts)
+	if diverged, ok := errors.Cause(err).(*client.LockDivergedError); ok {
+		kingpin.Errorf("resolved dependencies diverge from %s:", jsonnetfile.LockFile)
+		for _, d := range diverged.Diff {
+			fmt.Fprintf(os.Stderr, "  %s\n", d)
+		}
+		return exitOutOfSync
+	}
+	if err != nil {
+		return fail(err)
+	}
+	output.setLock(opts.JsonnetHome, *lock)
+
+	return printChanges(committed, *lock, opts.DryRun)
+}
+
+// syncCommand makes the vendor directory of the project in dir match its
+// lock file.
+func syncCommand(ctx context.Context, dir string, opts client.Options) int {
+	opts.Dir = dir
+	lock, err := client.Sync(ctx, opts)
+	if err != nil {
+		return fail(err)
+	}
+	output.setLock(opts.JsonnetHome, *lock)
+
+	color.Green(">>> %s matches %s, %d packages\n", opts.JsonnetHome, jsonnetfile.LockFile, len(lock.Dependencies))
+	return 0
+}
+
+// upgradeCommand raises the dependencies of the project to their latest
+// releases and prints how the lock file changed.
+func upgradeCommand(ctx context.Context, opts client.UpgradeOptions) int {
+	committed, err := committedLock(opts.Dir)
+	if err != nil {
+		return fail(err)
+	}
+
+	lock, err := client.Upgrade(ctx, opts)
+	if err != nil {
+		return fail(err)
+	}
+	output.setLock(opts.JsonnetHome, *lock)
+
+	return printChanges(committed, *lock, opts.DryRun)
+}
+
+// committedLock loads the lock file in dir before an install, for
+// printChanges. It is empty if there is none.
+func committedLock(dir string) (spec.JsonnetFile, error) {
+	if dir == "" {
+		dir = "."
+	}
+	committed, err := pkg.LoadJsonnetfile(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil && !os.IsNotExist(err) {
+		return committed, errors.Wrap(err, "failed to load lock file")
+	}
+	return committed, nil
+}
+
+// committedGroups returns the dependencies of the lock file in dir in one
+// of groups, see committedLock, which is all an install of only these
+// groups installs.
+func committedGroups(dir string, groups []string) (spec.JsonnetFile, error) {
+	committed, err := committedLock(dir)
+	committed.Dependencies = pkg.FilterGroups(committed.Dependencies, groups)
+	return committed, err
+}
+
+// printChanges prints how lock, installed by a dry run or with --diff,
+// differs from committed, the lock file before, or a summary of the changes
+// after any other install. With --diff, it returns exitChanged if there are
+// differences.
+func printChanges(committed, lock spec.JsonnetFile, dryRun bool) int {
+	changes := client.Changes(committed, lock)
+	if !dryRun && !diffLock {
+		if output == nil {
+			printSummary(color.Output, changes, len(lock.Dependencies))
+		}
+		return 0
+	}
+
+	printLockChanges(changes, dryRun)
+	if diffLock && len(changes) > 0 {
+		return exitChanged
+	}
+	return 0
+}
+
+// printLockChanges prints changes as a table of how every changed
+// dependency changed, from the locked version to the resolved one, or
+// records them in the JSON output. dryRun tells that the lock file was not
+// written.
+func printLockChanges(changes []client.Change, dryRun bool) {
+	if output != nil {
+		output.setChanges(changes)
+		return
+	}
+	if len(changes) == 0 {
+		if dryRun {
+			color.Green(">>> Dry run, %s would not change\n", jsonnetfile.LockFile)
+		} else {
+			color.Green(">>> %s did not change\n", jsonnetfile.LockFile)
+		}
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	if dryRun {
+		fmt.Fprintln(w, "NAME\tCHANGE\tLOCKED\tWOULD BE")
+	} else {
+		fmt.Fprintln(w, "NAME\tCHANGE\tWAS\tNOW")
+	}
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Name, c.Kind, displayVersion(c.Locked, c.LockedTag), displayVersion(c.Version, c.Tag))
+	}
+	w.Flush()
+}
+
+// printSummary prints changes, those of an install, to w: a line per
+// dependency marked ✚ if it was added, ⬆ or ⬇ if it was updated, with its
+// old and new version, and ✖ if it was removed, followed by the number of
+// dependencies of each kind and of the unchanged ones, out of the total
+// installed.
+func printSummary(w io.Writer, changes []client.Change, total int) {
+	added, updated, removed := 0, 0, 0
+	for _, c := range changes {
+		switch c.Kind {
+		case client.ChangeAdded:
+			added++
+			color.New(color.FgGreen).Fprintf(w, "✚ %s %s\n", c.Name, displayVersion(c.Version, c.Tag))
+		case client.ChangeRemoved:
+			removed++
+			color.New(color.FgRed).Fprintf(w, "✖ %s %s\n", c.Name, displayVersion(c.Locked, c.LockedTag))
+		default:
+			updated++
+			mark := "⬆"
+			if c.Kind == client.ChangeDowngraded {
+				mark = "⬇"
+			}
+			color.New(color.FgYellow).Fprintf(w, "%s %s %s → %s\n", mark, c.Name, displayVersion(c.Locked, c.LockedTag), displayVersion(c.Version, c.Tag))
+		}
+	}
+	fmt.Fprintf(w, ">>> %d added, %d updated, %d removed, %d unchanged\n", added, updated, removed, total-added-updated)
+}
+
+// displayVersion returns the tag of a locked version, or else the
+// abbreviated commit. Missing versions are shown as -.
+func displayVersion(version, tag string) string {
+	switch {
+	case tag != "":
+		return tag
+	case version == "":
+		return "-"
+	}
+	return shortCommit(version)
+}
+
+// parseTime parses the argument of the --since and --as-of flags, either a
+// duration relative to now (72h, 14d) or a date (2006-01-02 or RFC 3339).
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if strings.HasSuffix(s, "d") {
+		if days, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil {
+			return now.AddDate(0, 0, -days), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%q is neither a duration like 72h or 14d, nor a date like 2006-01-02", s)
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrintSummary(t *testing.T) {
+	defer func(noColor bool) { color.NoColor = noColor }(color.NoColor)
+	color.NoColor = true
+
+	dep := func(name, version, tag string) spec.Dependency {
+		return spec.Dependency{
+			Name:    name,
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/org/" + name}},
+			Version: version,
+			Tag:     tag,
+		}
+	}
+	committed := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		dep("same", "0123456789abcdef0123456789abcdef01234567", ""),
+		dep("up", "1111111111111111111111111111111111111111", "v1.0.0"),
+		dep("down", "2222222222222222222222222222222222222222", "v2.0.0"),
+		dep("gone", "3333333333333333333333333333333333333333", "v0.1.0"),
+	}}
+	lock := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		dep("same", "0123456789abcdef0123456789abcdef01234567", ""),
+		dep("up", "4444444444444444444444444444444444444444", "v1.1.0"),
+		dep("down", "5555555555555555555555555555555555555555", "v1.9.0"),
+		dep("new", "6666666666666666666666666666666666666666", ""),
+	}}
+
+	var buf bytes.Buffer
+	printSummary(&buf, client.Changes(committed, lock), len(lock.Dependencies))
+	assert.Equal(t, `⬆ up v1.0.0 → v1.1.0
+⬇ down v2.0.0 → v1.9.0
+✚ new 6666666
+✖ gone v0.1.0
+>>> 1 added, 2 updated, 1 removed, 1 unchanged
+`, buf.String())
+
+	buf.Reset()
+	printSummary(&buf, nil, 4)
+	assert.Equal(t, ">>> 0 added, 0 updated, 0 removed, 4 unchanged\n", buf.String())
+}
@@ -0,0 +1,53 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/pkg/errors"
+)
+
+// verifyCommand checks that the vendor directory holds exactly what the
+// lock file in dir describes, printing the differences otherwise.
+func verifyCommand(dir, jsonnetHome string) int {
+	if dir == "" {
+		dir = "."
+	}
+
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to load lock file"))
+	}
+
+	if !filepath.IsAbs(jsonnetHome) {
+		jsonnetHome = filepath.Join(dir, jsonnetHome)
+	}
+	err = pkg.Verify(jsonnetHome, lock)
+	if mismatch, ok := err.(*pkg.VendorMismatchError); ok {
+		for _, d := range mismatch.Diff {
+			fmt.Println(d)
+		}
+		return fail(err)
+	}
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to verify vendor directory"))
+	}
+
+	return 0
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"runtime"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/pkg/errors"
+)
+
+// latestReleaseURL is where GitHub describes the latest release of jb.
+const latestReleaseURL = "https://api.github.com/repos/jsonnet-bundler/jsonnet-bundler/releases/latest"
+
+// version, commit and date describe the build, set by the Makefile with
+// -ldflags "-X main.version=v0.2.0 -X main.commit=... -X main.date=...".
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// versionString describes the build of jb on one line, for support tickets.
+func versionString() string {
+	s := "jb " + version
+	if commit != "" {
+		s += ", commit " + commit
+	}
+	if date != "" {
+		s += ", built " + date
+	}
+	return fmt.Sprintf("%s, %s %s/%s", s, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// versionCommand prints the build of jb and, with check, whether the latest
+// release at releaseURL is newer.
+func versionCommand(ctx context.Context, caFile, releaseURL string, check bool) int {
+	fmt.Println(versionString())
+	if !check {
+		return 0
+	}
+
+	latest, url, err := latestRelease(ctx, caFile, releaseURL)
+	if err != nil {
+		return fail(errors.Wrap(err, "failed to check for a newer release"))
+	}
+	current, err := semver.Parse(version)
+	switch {
+	case err != nil:
+		color.Yellow(">>> The latest release is %s, this is a development build\n", latest.Original)
+	case semver.Compare(latest, current) > 0:
+		color.Yellow(">>> A newer release is available: %s, see %s\n", latest.Original, url)
+	default:
+		color.Green(">>> jb is up to date\n")
+	}
+	return 0
+}
+
+// latestRelease returns the version and the page of the release described
+// at releaseURL by the GitHub API.
+func latestRelease(ctx context.Context, caFile, releaseURL string) (semver.Version, string, error) {
+	var b bytes.Buffer
+	if err := pkg.Download(ctx, caFile, releaseURL, &b); err != nil {
+		return semver.Version{}, "", err
+	}
+
+	release := struct {
+		TagName string `json:"tag_name"`
+		HTMLURL string `json:"html_url"`
+	}{}
+	if err := json.Unmarshal(b.Bytes(), &release); err != nil {
+		return semver.Version{}, "", errors.Wrapf(err, "invalid release at %s", releaseURL)
+	}
+	v, err := semver.Parse(release.TagName)
+	if err != nil {
+		return semver.Version{}, "", errors.Wrapf(err, "invalid release at %s", releaseURL)
+	}
+	return v, release.HTMLURL, nil
+}
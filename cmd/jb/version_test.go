@@ -0,0 +1,50 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatestRelease(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tag_name": "v0.5.1", "html_url": "https://github.com/jsonnet-bundler/jsonnet-bundler/releases/tag/v0.5.1"}`)
+	}))
+	defer srv.Close()
+
+	v, url, err := latestRelease(context.TODO(), "", srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, "v0.5.1", v.Original)
+	assert.Equal(t, "https://github.com/jsonnet-bundler/jsonnet-bundler/releases/tag/v0.5.1", url)
+
+	invalid := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"tag_name": "nightly"}`)
+	}))
+	defer invalid.Close()
+	_, _, err = latestRelease(context.TODO(), "", invalid.URL)
+	assert.EqualError(t, err, `invalid release at `+invalid.URL+`: invalid semantic version "nightly"`)
+}
+
+func TestVersionString(t *testing.T) {
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+	version, commit, date = "v0.5.1", "0123abc", "2026-01-02T03:04:05Z"
+	assert.Contains(t, versionString(), "jb v0.5.1, commit 0123abc, built 2026-01-02T03:04:05Z, go")
+}
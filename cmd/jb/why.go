@@ -0,0 +1,98 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// whyCommand prints the chains of dependencies of the jsonnetfile in dir
+// leading to the package ref, given by name or by reference, each ending
+// with the version it is required at.
+func whyCommand(dir, jsonnetHome, ref string) int {
+	m, lock, tree, err := dependencyTree(dir, jsonnetHome)
+	if err != nil {
+		return fail(err)
+	}
+
+	name := ref
+	if n := client.DependencyName(lock, ref); n != "" {
+		name = n
+	}
+	paths := whyPaths(tree, name, nil)
+	if len(paths) == 0 {
+		kingpin.Errorf("%s is not a dependency", ref)
+		return exitFailure
+	}
+
+	root := m.Name
+	if root == "" {
+		root = jsonnetfile.File
+	}
+	printWhy(os.Stdout, root, name, lock, paths)
+	return 0
+}
+
+// whyPaths returns the chains of entries of tree leading to the dependency
+// name, each chain starting with a direct dependency and ending with one
+// named name.
+func whyPaths(tree []listEntry, name string, chain []listEntry) [][]listEntry {
+	paths := [][]listEntry{}
+	for _, e := range tree {
+		path := append(chain[:len(chain):len(chain)], e)
+		if e.Name == name {
+			paths = append(paths, path)
+			continue
+		}
+		paths = append(paths, whyPaths(e.Dependencies, name, path)...)
+	}
+	return paths
+}
+
+// printWhy prints the locked version of the dependency name and the paths
+// leading to it from root, one per line.
+func printWhy(w io.Writer, root, name string, lock spec.JsonnetFile, paths [][]listEntry) {
+	for _, d := range lock.Dependencies {
+		if d.Name != name {
+			continue
+		}
+		version := d.Version
+		if d.Tag != "" {
+			version = fmt.Sprintf("%s (%s)", d.Version, d.Tag)
+		}
+		fmt.Fprintf(w, "%s is locked at %s from %s\n", name, version, pkg.SourceString(d.Source))
+	}
+
+	for _, path := range paths {
+		elements := []string{root}
+		for _, e := range path {
+			version := e.Version
+			if version == "" {
+				version = "-"
+			}
+			elements = append(elements, fmt.Sprintf("%s %s", e.Name, version))
+		}
+		fmt.Fprintln(w, strings.Join(elements, " -> "))
+	}
+}
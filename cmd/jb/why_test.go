@@ -0,0 +1,53 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWhy(t *testing.T) {
+	foo := listEntry{Name: "foo", Source: "https://github.com/org/foo", Version: "^1.2"}
+	tree := []listEntry{
+		{Name: "bar", Source: "https://github.com/org/bar", Version: "v2", Dependencies: []listEntry{foo}},
+		{Name: "baz", Source: "https://github.com/org/baz", Version: "master", Dependencies: []listEntry{
+			{Name: "qux", Source: "https://github.com/org/qux", Dependencies: []listEntry{{Name: "foo", Version: "v1.3.0"}}},
+		}},
+		{Name: "foo", Source: "https://github.com/org/foo", Version: "^1.0"},
+	}
+
+	paths := whyPaths(tree, "foo", nil)
+	assert.Len(t, paths, 3)
+	assert.Empty(t, whyPaths(tree, "missing", nil))
+
+	lock := spec.JsonnetFile{Dependencies: []spec.Dependency{{
+		Name:    "foo",
+		Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/org/foo"}},
+		Version: "0123456789abcdef0123456789abcdef01234567",
+		Tag:     "v1.3.0",
+	}}}
+
+	var buf bytes.Buffer
+	printWhy(&buf, "myproject", "foo", lock, paths)
+	assert.Equal(t, `foo is locked at 0123456789abcdef0123456789abcdef01234567 (v1.3.0) from https://github.com/org/foo
+myproject -> bar v2 -> foo ^1.2
+myproject -> baz master -> qux - -> foo v1.3.0
+myproject -> foo ^1.0
+`, buf.String())
+}
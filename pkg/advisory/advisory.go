@@ -0,0 +1,372 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package advisory checks locked dependencies against a feed of advisories
+// on vulnerable or yanked versions, a JSON document served over HTTP(S) or
+// read from a file, for jb audit.
+package advisory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// Env is the environment variable holding the URL of the feed.
+const Env = "JB_ADVISORIES"
+
+// Severity is how bad an advisory is, one of Severities.
+type Severity string
+
+const (
+	Low      Severity = "low"
+	Medium   Severity = "medium"
+	High     Severity = "high"
+	Critical Severity = "critical"
+)
+
+// Severities lists the severities from the lowest to the highest.
+var Severities = []Severity{Low, Medium, High, Critical}
+
+// AtLeast reports whether s is min or higher. Advisories without a
+// severity are treated as critical, so that they are never overlooked.
+func (s Severity) AtLeast(min Severity) bool {
+	return s.rank() >= min.rank()
+}
+
+func (s Severity) rank() int {
+	for n, sev := range Severities {
+		if s == sev {
+			return n
+		}
+	}
+	return len(Severities) - 1
+}
+
+func (s Severity) known() bool {
+	for _, sev := range Severities {
+		if s == sev {
+			return true
+		}
+	}
+	return false
+}
+
+// Advisory reports versions of a package as vulnerable, or as yanked by
+// its authors.
+type Advisory struct {
+	ID string `json:"id"`
+	// Package is the package reference the advisory is about, like
+	// github.com/grafana/grafonnet-lib, which covers its subdirs too, or
+	// the URL of an archive.
+	Package  string   `json:"package"`
+	Summary  string   `json:"summary,omitempty"`
+	Severity Severity `json:"severity,omitempty"`
+	URL      string   `json:"url,omitempty"`
+	// Versions are the affected tags, or version constraints like
+	// >=1.0.0 <1.2.3 the affected tags satisfy, see semver.ParseConstraint.
+	// Commits are the affected commits, full or abbreviated. All versions
+	// are affected if there are neither.
+	Versions []string `json:"versions,omitempty"`
+	Commits  []string `json:"commits,omitempty"`
+	// Yanked tells that the versions were withdrawn by the authors of the
+	// package rather than found vulnerable.
+	Yanked bool `json:"yanked,omitempty"`
+}
+
+// Feed is the list of advisories of a feed.
+type Feed struct {
+	Advisories []Advisory `json:"advisories"`
+}
+
+// Load reads the feed at location, an HTTP(S) URL, downloaded trusting the
+// certificates of caFile like pkg.Download, or a file.
+func Load(ctx context.Context, caFile, location string) (*Feed, error) {
+	var b []byte
+	if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+		var buf bytes.Buffer
+		if err := pkg.Download(ctx, caFile, location, &buf); err != nil {
+			return nil, err
+		}
+		b = buf.Bytes()
+	} else {
+		var err error
+		if b, err = ioutil.ReadFile(location); err != nil {
+			return nil, errors.Wrap(err, "failed to read advisory feed")
+		}
+	}
+
+	f, err := Parse(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid advisory feed %s", location)
+	}
+	return f, nil
+}
+
+// Parse decodes a feed, either an object listing advisories, every one of
+// which needs an ID, a package and valid version constraints, or OSV
+// entries, a single one or a list of them, see parseOSV.
+func Parse(b []byte) (*Feed, error) {
+	b = bytes.TrimSpace(b)
+	if bytes.HasPrefix(b, []byte("[")) {
+		return parseOSV(b)
+	}
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, err
+	}
+	if _, ok := probe["affected"]; ok {
+		return parseOSV(append(append([]byte("["), b...), ']'))
+	}
+
+	f := &Feed{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, err
+	}
+	for n := range f.Advisories {
+		if err := validate(&f.Advisories[n], n); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func validate(a *Advisory, n int) error {
+	switch {
+	case a.ID == "":
+		return fmt.Errorf("advisory %d has no id", n+1)
+	case a.Package == "":
+		return fmt.Errorf("advisory %s has no package", a.ID)
+	}
+	if a.Severity != "" && !a.Severity.known() {
+		return fmt.Errorf("advisory %s has the unknown severity %s", a.ID, a.Severity)
+	}
+	for _, v := range a.Versions {
+		if !semver.IsConstraint(v) {
+			continue
+		}
+		if _, err := semver.ParseConstraint(v); err != nil {
+			return errors.Wrapf(err, "advisory %s", a.ID)
+		}
+	}
+	return nil
+}
+
+// osvEntry is the part of an entry of the OSV schema (https://ossf.github.io/osv-schema)
+// that is understood.
+type osvEntry struct {
+	ID        string `json:"id"`
+	Summary   string `json:"summary"`
+	Withdrawn string `json:"withdrawn"`
+	Affected  []struct {
+		Package struct {
+			Name string `json:"name"`
+		} `json:"package"`
+		Ranges []struct {
+			Type   string              `json:"type"`
+			Repo   string              `json:"repo"`
+			Events []map[string]string `json:"events"`
+		} `json:"ranges"`
+		Versions []string `json:"versions"`
+	} `json:"affected"`
+	References []struct {
+		Type string `json:"type"`
+		URL  string `json:"url"`
+	} `json:"references"`
+	DatabaseSpecific struct {
+		Severity string `json:"severity"`
+	} `json:"database_specific"`
+}
+
+// parseOSV converts OSV entries into one advisory per affected package,
+// named by the repository of its GIT range or else by its package name.
+// The listed versions are affected, along with the versions within its
+// SEMVER ranges. The commits between the events of GIT ranges are not
+// known without the repository, only the commits of the events themselves
+// are, entries usually list the tags within them as versions too.
+// Withdrawn entries are left out, and so are unknown severities.
+func parseOSV(b []byte) (*Feed, error) {
+	entries := []osvEntry{}
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return nil, err
+	}
+
+	f := &Feed{Advisories: []Advisory{}}
+	for _, e := range entries {
+		if e.Withdrawn != "" {
+			continue
+		}
+		severity := Severity(strings.ToLower(e.DatabaseSpecific.Severity))
+		if severity == "moderate" {
+			severity = Medium
+		}
+		if !severity.known() {
+			severity = ""
+		}
+		url := ""
+		for _, r := range e.References {
+			if r.Type == "ADVISORY" || url == "" {
+				url = r.URL
+			}
+		}
+
+		for _, affected := range e.Affected {
+			a := Advisory{ID: e.ID, Package: affected.Package.Name, Summary: e.Summary, Severity: severity, URL: url, Versions: affected.Versions}
+			for _, r := range affected.Ranges {
+				switch r.Type {
+				case "GIT":
+					a.Package = r.Repo
+					a.Commits = append(a.Commits, osvCommits(r.Events)...)
+				case "SEMVER":
+					a.Versions = append(a.Versions, osvConstraints(r.Events)...)
+				}
+			}
+			// Without versions, the advisory would cover all of them.
+			if len(affected.Ranges) > 0 && len(a.Versions) == 0 && len(a.Commits) == 0 {
+				continue
+			}
+			if err := validate(&a, len(f.Advisories)); err != nil {
+				return nil, err
+			}
+			f.Advisories = append(f.Advisories, a)
+		}
+	}
+	return f, nil
+}
+
+// osvConstraints returns the constraints of the version range of events,
+// which introduce affected versions up to the next fixed or last affected
+// one.
+func osvConstraints(events []map[string]string) []string {
+	constraints := []string{}
+	lower := ""
+	for _, e := range events {
+		switch {
+		case e["introduced"] != "":
+			lower = ">=" + e["introduced"]
+			if e["introduced"] == "0" {
+				lower = ">=0.0.0"
+			}
+		case e["fixed"] != "" && lower != "":
+			constraints = append(constraints, lower+" <"+e["fixed"])
+			lower = ""
+		case e["last_affected"] != "" && lower != "":
+			constraints = append(constraints, lower+" <="+e["last_affected"])
+			lower = ""
+		}
+	}
+	if lower != "" {
+		constraints = append(constraints, lower)
+	}
+	return constraints
+}
+
+// osvCommits returns the commits of events that are affected, the ones
+// introducing the range and the last affected one.
+func osvCommits(events []map[string]string) []string {
+	commits := []string{}
+	for _, e := range events {
+		for _, kind := range []string{"introduced", "last_affected"} {
+			if c := e[kind]; c != "" && c != "0" {
+				commits = append(commits, c)
+			}
+		}
+	}
+	return commits
+}
+
+// Finding is a locked dependency affected by an advisory.
+type Finding struct {
+	Name     string
+	Version  string
+	Tag      string
+	Advisory Advisory
+}
+
+// Check returns the dependencies of lock affected by the advisories of the
+// feed, ordered by name. Local dependencies have no version and are never
+// affected.
+func (f *Feed) Check(lock spec.JsonnetFile) []Finding {
+	findings := []Finding{}
+	for _, d := range lock.Dependencies {
+		if d.Source.LocalSource != nil {
+			continue
+		}
+		for _, a := range f.Advisories {
+			if matchPackage(a.Package, d) && matchVersion(a, d) {
+				findings = append(findings, Finding{Name: d.Name, Version: d.Version, Tag: d.Tag, Advisory: a})
+			}
+		}
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Name < findings[j].Name
+	})
+	return findings
+}
+
+// matchPackage reports whether d is the package ref or below it, by its
+// qualified name, the import path it was resolved from or its source.
+func matchPackage(ref string, d spec.Dependency) bool {
+	ref = normalize(ref)
+	for _, p := range []string{pkg.QualifiedName(d), d.Import, pkg.SourceString(d.Source)} {
+		if p = normalize(p); p != "" && (p == ref || strings.HasPrefix(p, ref+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize strips the scheme and the .git suffix of package references.
+func normalize(ref string) string {
+	if i := strings.Index(ref, "://"); i >= 0 {
+		ref = ref[i+3:]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(ref, "/"), ".git")
+}
+
+func matchVersion(a Advisory, d spec.Dependency) bool {
+	if len(a.Versions) == 0 && len(a.Commits) == 0 {
+		return true
+	}
+	for _, c := range a.Commits {
+		if len(c) >= 7 && strings.HasPrefix(d.Version, c) {
+			return true
+		}
+	}
+	for _, v := range a.Versions {
+		if !semver.IsConstraint(v) {
+			if v == d.Tag || v == d.Version {
+				return true
+			}
+			continue
+		}
+		c, err := semver.ParseConstraint(v)
+		if err != nil {
+			continue
+		}
+		if tag, err := semver.Parse(d.Tag); err == nil && c.Check(tag) {
+			return true
+		}
+	}
+	return false
+}
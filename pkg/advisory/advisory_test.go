@@ -0,0 +1,112 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package advisory
+
+import (
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+const commit = "3f9c0a1e2b4d5c6f7a8b9c0d1e2f3a4b5c6d7e8f"
+
+func gitDependency(name, remote, subdir, version, tag string) spec.Dependency {
+	return spec.Dependency{
+		Name:    name,
+		Source:  spec.Source{GitSource: &spec.GitSource{Remote: remote, Subdir: subdir}},
+		Version: version,
+		Tag:     tag,
+	}
+}
+
+func TestCheck(t *testing.T) {
+	feed, err := Parse([]byte(`{"advisories": [
+		{"id": "JB-1", "package": "github.com/foo/lib", "severity": "high", "versions": [">=1.0.0 <1.2.0"]},
+		{"id": "JB-2", "package": "https://github.com/foo/lib.git", "versions": ["v2.0.0"], "yanked": true},
+		{"id": "JB-3", "package": "github.com/bar/util", "commits": ["3f9c0a1"]},
+		{"id": "JB-4", "package": "github.com/baz/all"}
+	]}`))
+	assert.NoError(t, err)
+
+	lock := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("lib", "https://github.com/foo/lib", "", commit, "v1.1.0"),
+		gitDependency("lib-v2", "git@github.com:foo/lib.git", "jsonnet", commit, "v2.0.0"),
+		gitDependency("fixed", "https://github.com/foo/lib", "", commit, "v1.2.0"),
+		gitDependency("util", "https://github.com/bar/util", "", commit, ""),
+		gitDependency("other", "https://github.com/bar/utility", "", commit, ""),
+		{Name: "local", Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "github.com/baz/all"}}},
+	}}
+
+	ids := map[string]string{}
+	for _, f := range feed.Check(lock) {
+		ids[f.Name] += f.Advisory.ID
+	}
+	assert.Equal(t, map[string]string{"lib": "JB-1", "lib-v2": "JB-2", "util": "JB-3"}, ids)
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse([]byte(`{"advisories": [{"package": "github.com/foo/lib"}]}`))
+	assert.EqualError(t, err, "advisory 1 has no id")
+	_, err = Parse([]byte(`{"advisories": [{"id": "JB-1"}]}`))
+	assert.EqualError(t, err, "advisory JB-1 has no package")
+	_, err = Parse([]byte(`{"advisories": [{"id": "JB-1", "package": "github.com/foo/lib", "severity": "severe"}]}`))
+	assert.EqualError(t, err, "advisory JB-1 has the unknown severity severe")
+}
+
+func TestParseOSV(t *testing.T) {
+	feed, err := Parse([]byte(`[{
+		"id": "GHSA-1",
+		"summary": "Injection",
+		"affected": [{
+			"package": {"ecosystem": "GitHub Actions", "name": "foo/lib"},
+			"ranges": [
+				{"type": "GIT", "repo": "https://github.com/foo/lib", "events": [{"introduced": "0"}, {"fixed": "abcdef0"}]},
+				{"type": "SEMVER", "events": [{"introduced": "1.0.0"}, {"fixed": "1.2.0"}, {"introduced": "2.0.0"}]}
+			],
+			"versions": ["v0.9.0"]
+		}, {
+			"package": {"name": "github.com/foo/git-only"},
+			"ranges": [{"type": "GIT", "repo": "https://github.com/foo/git-only", "events": [{"introduced": "0"}, {"fixed": "abcdef0"}]}]
+		}],
+		"references": [{"type": "WEB", "url": "https://example.com"}, {"type": "ADVISORY", "url": "https://example.com/GHSA-1"}],
+		"database_specific": {"severity": "MODERATE"}
+	}, {
+		"id": "GHSA-2",
+		"withdrawn": "2024-01-01T00:00:00Z",
+		"affected": [{"package": {"name": "github.com/foo/lib"}}]
+	}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Advisory{{
+		ID:       "GHSA-1",
+		Package:  "https://github.com/foo/lib",
+		Summary:  "Injection",
+		Severity: Medium,
+		URL:      "https://example.com/GHSA-1",
+		Versions: []string{"v0.9.0", ">=1.0.0 <1.2.0", ">=2.0.0"},
+	}}, feed.Advisories)
+
+	// A single entry is a feed too.
+	feed, err = Parse([]byte(`{"id": "GHSA-3", "affected": [{"package": {"name": "github.com/foo/lib"}}], "database_specific": {"severity": "UNKNOWN"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Advisory{{ID: "GHSA-3", Package: "github.com/foo/lib"}}, feed.Advisories)
+}
+
+func TestSeverityAtLeast(t *testing.T) {
+	assert.True(t, High.AtLeast(Medium))
+	assert.False(t, Low.AtLeast(Medium))
+	// Advisories without a severity are never overlooked.
+	assert.True(t, Severity("").AtLeast(Critical))
+}
@@ -0,0 +1,312 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// ArchivePackage installs a tarball or zip archive downloaded over HTTP(S),
+// so consumers do not need git, or from object storage, see IsObjectURL.
+type ArchivePackage struct {
+	Source *spec.ArchiveSource
+
+	// CAFile holds the certificate authorities trusted for HTTPS, see
+	// CAFileEnv. The system ones are trusted if it is empty.
+	CAFile string
+
+	// Mirrors rewrite the URL that is downloaded. The lock file keeps the
+	// URL of Source.
+	Mirrors Mirrors
+
+	// SHA256 is the checksum of the downloaded archive, set by Install.
+	SHA256 string
+
+	// ETag and Generation are the revision of the downloaded object in S3
+	// and Google Cloud Storage respectively, set by Install.
+	ETag       string
+	Generation string
+}
+
+func NewArchivePackage(source *spec.ArchiveSource) *ArchivePackage {
+	return &ArchivePackage{
+		Source: source,
+	}
+}
+
+// IsArchive reports whether name has the extension of a supported archive
+// format (.tar.gz, .tgz, .tar or .zip).
+func IsArchive(name string) bool {
+	return archiveExt(name) != ""
+}
+
+// TrimArchiveExt returns name without its archive extension.
+func TrimArchiveExt(name string) string {
+	return strings.TrimSuffix(name, archiveExt(name))
+}
+
+func archiveExt(name string) string {
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(name, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// Install downloads the archive and extracts it into dir. If the source
+// declares a checksum, an archive with a different checksum fails the
+// install, as does an object of another revision than the one of the source.
+// Archives holding a single top-level directory, as produced by most release
+// tooling, are extracted without it.
+func (p *ArchivePackage) Install(ctx context.Context, dir, version string) (lockVersion string, err error) {
+	f, err := ioutil.TempFile("", "jsonnetpkg-archive")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	rawurl := p.Mirrors.Rewrite(p.Source.URL)
+	var sum string
+	switch {
+	case strings.HasPrefix(rawurl, "s3://"):
+		sum, p.ETag, err = downloadObject(ctx, p.CAFile, rawurl, p.Source.ETag, f)
+	case strings.HasPrefix(rawurl, "gs://"):
+		sum, p.Generation, err = downloadObject(ctx, p.CAFile, rawurl, p.Source.Generation, f)
+	default:
+		sum, err = download(ctx, p.CAFile, rawurl, f)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := verifySHA256(p.Source.URL, p.Source.SHA256, sum); err != nil {
+		return "", err
+	}
+	p.SHA256 = sum
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	if err := extract(f, path.Base(p.Source.URL), dir); err != nil {
+		return "", errors.Wrapf(err, "failed to extract %s", p.Source.URL)
+	}
+
+	if err := stripTopLevelDir(dir); err != nil {
+		return "", err
+	}
+
+	return version, nil
+}
+
+// LockSource returns the source with the checksum of the downloaded archive,
+// and the revision of objects.
+func (p *ArchivePackage) LockSource() spec.Source {
+	return spec.Source{ArchiveSource: &spec.ArchiveSource{
+		URL:        p.Source.URL,
+		Subdir:     p.Source.Subdir,
+		SHA256:     p.SHA256,
+		ETag:       p.ETag,
+		Generation: p.Generation,
+	}}
+}
+
+// extract extracts the archive f, in the format given by the extension of
+// name, into dir.
+func extract(f *os.File, name, dir string) error {
+	switch archiveExt(name) {
+	case ".zip":
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		return extractZip(f, info.Size(), dir)
+	case ".tar.gz", ".tgz":
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		_, err = extractTar(gz, dir)
+		return err
+	case ".tar":
+		_, err := extractTar(f, dir)
+		return err
+	}
+
+	return fmt.Errorf("unsupported archive format of %s", name)
+}
+
+// extractTar extracts the tar stream r into dir. It returns the comment of
+// the global header, which git archive sets to the archived commit. Only
+// directories and regular files are extracted: symlinks, hard links and
+// special files like devices are skipped, so an archive cannot make later
+// entries, or the vendored package, point outside of dir.
+func extractTar(r io.Reader, dir string) (comment string, err error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return comment, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			comment = hdr.PAXRecords["comment"]
+			continue
+		}
+
+		target, err := extractPath(dir, hdr.Name)
+		if err != nil {
+			return "", err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, os.ModePerm)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(target, tr, os.FileMode(hdr.Mode))
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// extractZip extracts the zip archive r of size bytes into dir, see
+// extractTar.
+func extractZip(r io.ReaderAt, size int64, dir string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	for _, zf := range zr.File {
+		target, err := extractPath(dir, zf.Name)
+		if err != nil {
+			return err
+		}
+
+		if zf.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				return err
+			}
+			continue
+		}
+		// Like tar archives, only regular files are extracted.
+		if !zf.Mode().IsRegular() {
+			continue
+		}
+
+		rc, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		err = writeFile(target, rc, zf.Mode())
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractPath returns the path an archive entry is extracted to, refusing
+// absolute entries, entries with .. elements and entries that would end up
+// outside of dir.
+func extractPath(dir, name string) (string, error) {
+	slashed := strings.Replace(name, "\\", "/", -1)
+	if strings.HasPrefix(slashed, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("archive entry %s is an absolute path", name)
+	}
+	for _, element := range strings.Split(slashed, "/") {
+		if element == ".." {
+			return "", fmt.Errorf("archive entry %s is outside of the archive", name)
+		}
+	}
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	if target != filepath.Clean(dir) && !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive entry %s is outside of the archive", name)
+	}
+	return target, nil
+}
+
+func writeFile(name string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	// An existing file keeps its mode when opened, and new ones are
+	// created with the umask applied.
+	return f.Chmod(mode.Perm() | 0600)
+}
+
+// stripTopLevelDir moves the contents of the only entry of dir up, if that
+// entry is a directory.
+func stripTopLevelDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return nil
+	}
+
+	top := filepath.Join(dir, entries[0].Name())
+	children, err := ioutil.ReadDir(top)
+	if err != nil {
+		return err
+	}
+
+	// The top-level directory is renamed first, in case one of its children
+	// has the same name.
+	tmp := top + ".strip"
+	if err := os.Rename(top, tmp); err != nil {
+		return err
+	}
+	for _, c := range children {
+		if err := os.Rename(filepath.Join(tmp, c.Name()), filepath.Join(dir, c.Name())); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(tmp)
+}
@@ -0,0 +1,216 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func tarGz(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+		assert.NoError(t, err)
+		_, err = tw.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func zipped(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func TestInstallerArchive(t *testing.T) {
+	files := map[string]string{
+		"pkg-1.2.3/README.md":                  "pkg",
+		"pkg-1.2.3/jsonnet/lib/main.libsonnet": "{ lib: true }",
+	}
+	archives := map[string][]byte{
+		"/pkg-1.2.3.tar.gz": tarGz(t, files),
+		"/pkg-1.2.3.zip":    zipped(t, files),
+		"/evil.tar.gz":      tarGz(t, map[string]string{"../evil.libsonnet": "{}"}),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := archives[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	for _, name := range []string{"pkg-1.2.3.tar.gz", "pkg-1.2.3.zip"} {
+		t.Run(name, func(t *testing.T) {
+			tempDir, err := ioutil.TempDir("", "jb-archive")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			m := spec.JsonnetFile{Dependencies: []spec.Dependency{{
+				Name:    "lib",
+				Source:  spec.Source{ArchiveSource: &spec.ArchiveSource{URL: srv.URL + "/" + name, Subdir: "jsonnet/lib"}},
+				Version: "1.2.3",
+			}}}
+
+			i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+			lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+			assert.NoError(t, err)
+
+			// The top-level directory of the archive is stripped.
+			content, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+			assert.NoError(t, err)
+			assert.Equal(t, "{ lib: true }", string(content))
+			assert.NotEmpty(t, lock.Dependencies[0].Source.ArchiveSource.SHA256)
+
+			_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+			assert.NoError(t, err)
+
+			lock.Dependencies[0].Source.ArchiveSource.SHA256 = "0000"
+			_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "checksum mismatch")
+		})
+	}
+
+	t.Run("OutsideOfArchive", func(t *testing.T) {
+		tempDir, err := ioutil.TempDir("", "jb-archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		m := spec.JsonnetFile{Dependencies: []spec.Dependency{{
+			Name:   "evil",
+			Source: spec.Source{ArchiveSource: &spec.ArchiveSource{URL: srv.URL + "/evil.tar.gz"}},
+		}}}
+
+		i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+		_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+		assert.Error(t, err)
+	})
+}
+
+func TestExtractPath(t *testing.T) {
+	dir := filepath.Join("vendor", "pkg")
+	for name, reason := range map[string]string{
+		"/etc/passwd":      "archive entry /etc/passwd is an absolute path",
+		"../escape":        "archive entry ../escape is outside of the archive",
+		"lib/../../x":      "archive entry lib/../../x is outside of the archive",
+		"lib/../main":      "archive entry lib/../main is outside of the archive",
+		`lib\..\..\x`:      `archive entry lib\..\..\x is outside of the archive`,
+		"lib/main.jsonnet": "",
+	} {
+		target, err := extractPath(dir, name)
+		if reason != "" {
+			assert.EqualError(t, err, reason, name)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "lib", "main.jsonnet"), target)
+	}
+}
+
+func TestExtractSkipsLinksAndSpecialFiles(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "pkg/passwd", Linkname: "/etc/passwd", Typeflag: tar.TypeSymlink},
+		{Name: "pkg/up", Linkname: "../..", Typeflag: tar.TypeSymlink},
+		{Name: "pkg/shadow", Linkname: "/etc/shadow", Typeflag: tar.TypeLink},
+		{Name: "pkg/null", Typeflag: tar.TypeChar, Devmajor: 1, Devminor: 3},
+		{Name: "pkg/fifo", Typeflag: tar.TypeFifo},
+	} {
+		hdr.Mode = 0644
+		assert.NoError(t, tw.WriteHeader(hdr))
+	}
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "pkg/up/main.libsonnet", Mode: 0644, Size: 2, Typeflag: tar.TypeReg}))
+	_, err = tw.Write([]byte("{}"))
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+
+	tarDir := filepath.Join(tempDir, "tar")
+	_, err = extractTar(&buf, tarDir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pkg/up/main.libsonnet"}, walkFiles(t, tarDir))
+
+	buf.Reset()
+	zw := zip.NewWriter(&buf)
+	hdr := &zip.FileHeader{Name: "pkg/passwd"}
+	hdr.SetMode(os.ModeSymlink | 0777)
+	w, err := zw.CreateHeader(hdr)
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("/etc/passwd"))
+	assert.NoError(t, err)
+	w, err = zw.Create("pkg/main.libsonnet")
+	assert.NoError(t, err)
+	_, err = w.Write([]byte("{}"))
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+
+	zipDir := filepath.Join(tempDir, "zip")
+	assert.NoError(t, extractZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), zipDir))
+	assert.Equal(t, []string{"pkg/main.libsonnet"}, walkFiles(t, zipDir))
+}
+
+// walkFiles returns the slash-separated paths of the files below dir,
+// directories left out.
+func walkFiles(t *testing.T, dir string) []string {
+	files := []string{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		files = append(files, filepath.ToSlash(rel))
+		return err
+	})
+	assert.NoError(t, err)
+	return files
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SplitAsOf splits a version resolving a branch as of a date, like
+// main@{2023-06-01}, or {2023-06-01} for the default branch, into the
+// branch and the date. The date is a day, which stands for its start in
+// UTC, or an RFC 3339 time. ok is false for versions of any other form.
+func SplitAsOf(version string) (branch string, date time.Time, ok bool, err error) {
+	if !strings.HasSuffix(version, "}") {
+		return version, time.Time{}, false, nil
+	}
+	i := strings.LastIndex(version, "{")
+	if i < 0 || (i > 0 && version[i-1] != '@') {
+		return version, time.Time{}, false, nil
+	}
+
+	branch = strings.TrimSuffix(version[:i], "@")
+	s := version[i+1 : len(version)-1]
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if date, err := time.Parse(layout, s); err == nil {
+			return branch, date, true, nil
+		}
+	}
+	return branch, time.Time{}, true, fmt.Errorf("invalid date %q in version %s, expected a day like 2006-01-02 or an RFC 3339 time", s, version)
+}
+
+// asOfRef returns the last commit before date on the first-parent history
+// of the branch that fetchRef fetched version from as ref, for which the
+// history of the branch is fetched in full. Versions that are not
+// branches, like tags and commits, keep ref unless required, in which
+// case they fail.
+func (p *GitPackage) asOfRef(ctx context.Context, dir, version, ref string, date time.Time, required bool) (string, error) {
+	var branch string
+	switch {
+	case strings.HasPrefix(ref, "refs/remotes/origin/"):
+		branch = strings.TrimPrefix(ref, "refs/remotes/origin/")
+	case ref == "FETCH_HEAD" && strings.HasPrefix(version, "refs/heads/"):
+		branch = strings.TrimPrefix(version, "refs/heads/")
+	case required:
+		return "", fmt.Errorf("version %s of %s is not a branch, only branches are resolved as of a date", version, p.Source.Remote)
+	default:
+		return ref, nil
+	}
+
+	ref = "refs/remotes/origin/" + branch
+	args := []string{"origin", "+refs/heads/" + branch + ":" + ref}
+	if _, err := os.Stat(filepath.Join(dir, ".git", "shallow")); err == nil {
+		args = append([]string{"--unshallow"}, args...)
+	}
+	if err := p.fetch(ctx, dir, args...); err != nil {
+		return "", errors.Wrapf(err, "failed to fetch the history of %s from %s", branch, p.Source.Remote)
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "rev-list", "-1", "--first-parent", "--before="+date.Format(time.RFC3339), ref)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to list the history of %s", branch)
+	}
+	commit := strings.TrimSpace(string(out))
+	if commit == "" {
+		return "", &NotFoundError{Err: fmt.Errorf("branch %s of %s has no commit before %s", branch, p.Source.Remote, date.Format(time.RFC3339))}
+	}
+	return commit, nil
+}
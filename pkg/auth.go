@@ -0,0 +1,170 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Environment variables holding credentials for private repositories.
+// JB_GIT_HOSTS lists the hosts, separated by commas, JB_GIT_USERNAME and
+// JB_GIT_PASSWORD are sent to.
+const (
+	GitUsernameEnv = "JB_GIT_USERNAME"
+	GitPasswordEnv = "JB_GIT_PASSWORD"
+	GitHostsEnv    = "JB_GIT_HOSTS"
+	GithubTokenEnv = "GITHUB_TOKEN"
+	GitlabTokenEnv = "GITLAB_TOKEN"
+)
+
+// credentialHelper answers git's credential requests from the environment
+// of the git process, so that secrets never appear in arguments or in the
+// remote URL.
+const credentialHelper = `!f() { test "$1" = get && echo "username=${JB_AUTH_USERNAME}" && echo "password=${JB_AUTH_PASSWORD}"; }; f`
+
+// credentials returns the credentials for HTTP(S) requests to host: a token
+// from GITHUB_TOKEN or GITLAB_TOKEN for the respective hosts, or else the
+// entry for host in the netrc file.
+func credentials(host string) (username, password string, ok bool) {
+	switch host {
+	case "github.com", "codeload.github.com", "api.github.com":
+		if token := os.Getenv(GithubTokenEnv); token != "" {
+			return "x-access-token", token, true
+		}
+	case "gitlab.com":
+		if token := os.Getenv(GitlabTokenEnv); token != "" {
+			return "oauth2", token, true
+		}
+	}
+
+	return netrcCredentials(host)
+}
+
+// gitCredentials returns the credentials git should use for remote, where
+// JB_GIT_USERNAME and JB_GIT_PASSWORD apply to the hosts of JB_GIT_HOSTS.
+// Credentials are never sent over plain HTTP, remotes other than HTTPS, and
+// remotes with credentials of their own, get none.
+func gitCredentials(remote string) (username, password string, ok bool) {
+	u, err := url.Parse(remote)
+	if err != nil || u.Scheme != "https" || u.User != nil {
+		return "", "", false
+	}
+
+	if password := os.Getenv(GitPasswordEnv); password != "" && gitHost(u.Hostname()) {
+		username := os.Getenv(GitUsernameEnv)
+		if username == "" {
+			username = "git"
+		}
+		return username, password, true
+	}
+
+	return credentials(u.Hostname())
+}
+
+// gitHost reports whether host is one of JB_GIT_HOSTS.
+func gitHost(host string) bool {
+	for _, h := range strings.Split(os.Getenv(GitHostsEnv), ",") {
+		if h = strings.TrimSpace(h); h != "" && strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitAuth returns the git arguments and the environment that authenticate
+// requests to remote, if there are credentials for it.
+func gitAuth(remote string) (args, env []string) {
+	username, password, ok := gitCredentials(remote)
+	if !ok {
+		return nil, nil
+	}
+
+	// The empty helper resets helpers configured by the user, which would
+	// otherwise be asked first.
+	args = []string{"-c", "credential.helper=", "-c", "credential.helper=" + credentialHelper}
+	env = append(os.Environ(), "JB_AUTH_USERNAME="+username, "JB_AUTH_PASSWORD="+password, "GIT_TERMINAL_PROMPT=0")
+	return args, env
+}
+
+// netrcCredentials looks host up in the netrc file, $NETRC or ~/.netrc.
+func netrcCredentials(host string) (username, password string, ok bool) {
+	filename := os.Getenv("NETRC")
+	if filename == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", false
+		}
+		filename = filepath.Join(home, ".netrc")
+	}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", "", false
+	}
+	return parseNetrc(string(b), host)
+}
+
+// parseNetrc returns the login and password of the machine host in the
+// netrc content, falling back to the default entry.
+func parseNetrc(content, host string) (username, password string, ok bool) {
+	type entry struct{ login, password string }
+	var (
+		machines = map[string]*entry{}
+		def      *entry
+		current  *entry
+	)
+
+	fields := strings.Fields(content)
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			if i+1 < len(fields) {
+				i++
+				current = &entry{}
+				machines[fields[i]] = current
+			}
+		case "default":
+			current = &entry{}
+			def = current
+		case "login", "password", "account":
+			if i+1 < len(fields) && current != nil {
+				i++
+				if fields[i-1] == "login" {
+					current.login = fields[i]
+				} else if fields[i-1] == "password" {
+					current.password = fields[i]
+				}
+			}
+		case "macdef":
+			// Macros run until the next empty line, which Fields cannot
+			// tell, so nothing after them is read.
+			current = nil
+			i = len(fields)
+		}
+	}
+
+	e, found := machines[host]
+	if !found {
+		e = def
+	}
+	if e == nil || e.password == "" {
+		return "", "", false
+	}
+	return e.login, e.password, true
+}
@@ -0,0 +1,185 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setenv sets the environment variables and returns a function restoring
+// them.
+func setenv(vars map[string]string) func() {
+	old := map[string]*string{}
+	for k, v := range vars {
+		if prev, ok := os.LookupEnv(k); ok {
+			old[k] = &prev
+		} else {
+			old[k] = nil
+		}
+		os.Setenv(k, v)
+	}
+	return func() {
+		for k, v := range old {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}
+}
+
+func TestParseNetrc(t *testing.T) {
+	content := `machine example.com
+  login alice
+  password secret
+default login anon password guest
+machine other.com login bob`
+
+	username, password, ok := parseNetrc(content, "example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "alice", username)
+	assert.Equal(t, "secret", password)
+
+	username, password, ok = parseNetrc(content, "unknown.com")
+	assert.True(t, ok)
+	assert.Equal(t, "anon", username)
+	assert.Equal(t, "guest", password)
+
+	// Entries without password are useless.
+	_, _, ok = parseNetrc(content, "other.com")
+	assert.False(t, ok)
+}
+
+func TestGitCredentials(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	netrc := filepath.Join(tempDir, "netrc")
+	assert.NoError(t, ioutil.WriteFile(netrc, []byte("machine git.example.com login alice password secret"), 0600))
+
+	defer setenv(map[string]string{
+		"NETRC":        netrc,
+		GithubTokenEnv: "ghtoken",
+		GitlabTokenEnv: "",
+		GitUsernameEnv: "",
+		GitPasswordEnv: "",
+	})()
+
+	testcases := []struct {
+		Remote   string
+		Username string
+		Password string
+		OK       bool
+	}{
+		{Remote: "https://github.com/org/private", Username: "x-access-token", Password: "ghtoken", OK: true},
+		{Remote: "https://git.example.com/org/private.git", Username: "alice", Password: "secret", OK: true},
+		{Remote: "https://other.example.com/org/repo.git"},
+		{Remote: "https://bob:pw@github.com/org/private"},
+		{Remote: "http://git.example.com/org/private.git"},
+		{Remote: "git@github.com:org/private.git"},
+	}
+	for _, tc := range testcases {
+		username, password, ok := gitCredentials(tc.Remote)
+		assert.Equal(t, tc.OK, ok, tc.Remote)
+		assert.Equal(t, tc.Username, username, tc.Remote)
+		assert.Equal(t, tc.Password, password, tc.Remote)
+	}
+
+	// JB_GIT_USERNAME and JB_GIT_PASSWORD apply to the hosts of
+	// JB_GIT_HOSTS, over HTTPS only.
+	defer setenv(map[string]string{GitUsernameEnv: "ci", GitPasswordEnv: "cipw", GitHostsEnv: "git.corp, Other.example.com"})()
+	username, password, ok := gitCredentials("https://other.example.com/org/repo.git")
+	assert.True(t, ok)
+	assert.Equal(t, "ci", username)
+	assert.Equal(t, "cipw", password)
+	_, _, ok = gitCredentials("http://other.example.com/org/repo.git")
+	assert.False(t, ok)
+	_, _, ok = gitCredentials("https://evil.example.com/org/repo.git")
+	assert.False(t, ok)
+}
+
+func TestGitAuthHelper(t *testing.T) {
+	defer setenv(map[string]string{GitUsernameEnv: "ci", GitPasswordEnv: "s3cret", GitHostsEnv: "git.example.com"})()
+
+	args, env := gitAuth("https://git.example.com/org/repo.git")
+	assert.NotContains(t, strings.Join(args, " "), "s3cret")
+
+	// git asks the helper, which answers from the environment.
+	cmd := exec.Command("git", append(args, "credential", "fill")...)
+	cmd.Env = env
+	cmd.Stdin = strings.NewReader("protocol=https\nhost=git.example.com\n\n")
+	out, err := cmd.Output()
+	assert.NoError(t, err)
+	assert.Contains(t, string(out), "username=ci\n")
+	assert.Contains(t, string(out), "password=s3cret\n")
+}
+
+func TestDownloadNetrc(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("private"))
+	})
+	srv := httptest.NewTLSServer(handler)
+	defer srv.Close()
+
+	tempDir, err := ioutil.TempDir("", "jb-auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	caFile := filepath.Join(tempDir, "ca.pem")
+	assert.NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644))
+
+	u, err := url.Parse(srv.URL)
+	assert.NoError(t, err)
+	netrc := filepath.Join(tempDir, "netrc")
+	assert.NoError(t, ioutil.WriteFile(netrc, []byte("machine "+u.Hostname()+" login alice password secret"), 0600))
+
+	var buf bytes.Buffer
+	defer setenv(map[string]string{"NETRC": filepath.Join(tempDir, "missing")})()
+	_, err = download(context.TODO(), caFile, srv.URL, &buf)
+	assert.Error(t, err)
+
+	defer setenv(map[string]string{"NETRC": netrc})()
+	_, err = download(context.TODO(), caFile, srv.URL, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "private", buf.String())
+
+	// Credentials are never sent over plain HTTP.
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	_, err = download(context.TODO(), "", plain.URL, &buf)
+	assert.Error(t, err)
+}
@@ -0,0 +1,234 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// bundleVendorDir is the directory of a bundle the packages are stored in,
+// whatever the vendor directory of the exporting project is called.
+const bundleVendorDir = "vendor"
+
+// WriteBundle writes a gzipped tarball of the project in dir to w, holding
+// its jsonnetfile, its lock file and exactly the packages of lock vendored
+// in jsonnetHome, which must match lock, see Verify. Linked packages, like
+// local ones, are stored as copies and the links of the legacy layout are
+// left out, LinkLegacyNames recreates them.
+func WriteBundle(w io.Writer, dir, jsonnetHome string, lock spec.JsonnetFile) error {
+	if err := Verify(jsonnetHome, lock); err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, name := range []string{JsonnetFile, JsonnetLockFile} {
+		filename := filepath.Join(dir, name)
+		info, err := os.Stat(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeBundleEntry(tw, name, filename, info); err != nil {
+			return err
+		}
+	}
+
+	written := map[string]bool{}
+	for _, d := range lock.Dependencies {
+		root, err := filepath.EvalSymlinks(filepath.Join(jsonnetHome, d.Name))
+		if err != nil {
+			return err
+		}
+		err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			name := path.Join(bundleVendorDir, filepath.ToSlash(d.Name), filepath.ToSlash(rel))
+			if written[name] {
+				return nil
+			}
+			written[name] = true
+			return writeBundleEntry(tw, name, p, info)
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to bundle %s", d.Name)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeBundleEntry writes the file, directory or symlink filename to tw as
+// name. Modification times are left out, so the same vendor directory
+// always yields the same bundle.
+func writeBundleEntry(tw *tar.Writer, name, filename string, info os.FileInfo) error {
+	link := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(filename)
+		if err != nil {
+			return err
+		}
+		link = filepath.ToSlash(target)
+	}
+
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.ModTime = time.Unix(0, 0)
+	hdr.AccessTime, hdr.ChangeTime = time.Time{}, time.Time{}
+	hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// ReadBundle extracts the bundle r, as written by WriteBundle, into dest
+// and returns its lock file. The packages end up in the vendor directory
+// of dest and must match the digests of the lock file, otherwise a
+// VendorMismatchError is returned.
+func ReadBundle(r io.Reader, dest string) (spec.JsonnetFile, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return spec.JsonnetFile{}, errors.Wrap(err, "failed to read bundle")
+	}
+	defer gz.Close()
+	if err := extractBundle(gz, dest); err != nil {
+		return spec.JsonnetFile{}, errors.Wrap(err, "failed to extract bundle")
+	}
+
+	lock, err := LoadJsonnetfile(filepath.Join(dest, JsonnetLockFile))
+	if os.IsNotExist(err) {
+		return lock, fmt.Errorf("bundle has no %s", JsonnetLockFile)
+	}
+	if err != nil {
+		return lock, errors.Wrap(err, "failed to load lock file of bundle")
+	}
+
+	vendor := filepath.Join(dest, bundleVendorDir)
+	if err := os.MkdirAll(vendor, os.ModePerm); err != nil {
+		return lock, err
+	}
+	if err := Verify(vendor, lock); err != nil {
+		return lock, err
+	}
+	return lock, nil
+}
+
+// extractBundle extracts the tar stream r into dir like extractTar, but
+// keeps symlinks, which the digests of packages cover. Links must point
+// inside of dir, and no entry may be extracted through one.
+func extractBundle(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := extractPath(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if err := checkNoSymlink(dir, filepath.Dir(target)); err != nil {
+			return err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, os.ModePerm)
+		case tar.TypeReg, tar.TypeRegA:
+			err = writeFile(target, tr, os.FileMode(hdr.Mode))
+		case tar.TypeSymlink:
+			resolved := path.Join(path.Dir(strings.TrimSuffix(hdr.Name, "/")), hdr.Linkname)
+			if path.IsAbs(hdr.Linkname) || resolved == ".." || strings.HasPrefix(resolved, "../") {
+				return fmt.Errorf("bundle entry %s links outside of the bundle", hdr.Name)
+			}
+			if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err == nil {
+				err = symlink(filepath.FromSlash(hdr.Linkname), target)
+			}
+		default:
+			return fmt.Errorf("unsupported bundle entry %s", hdr.Name)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// checkNoSymlink returns an error if any directory from below root down to
+// dir is a symlink.
+func checkNoSymlink(root, dir string) error {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil || rel == "." {
+		return err
+	}
+
+	p := root
+	for _, segment := range strings.Split(rel, string(os.PathSeparator)) {
+		p = filepath.Join(p, segment)
+		info, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("bundle entry below the link %s", p)
+		}
+	}
+	return nil
+}
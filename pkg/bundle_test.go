@@ -0,0 +1,133 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBundle(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("lib/main.libsonnet", "{}")
+	assert.NoError(t, os.Symlink("lib/main.libsonnet", filepath.Join(repo.Dir, "main.libsonnet")))
+	repo.commit("lib/other.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	local := filepath.Join(tempDir, "local")
+	assert.NoError(t, os.MkdirAll(local, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(local, "local.libsonnet"), []byte("{}"), 0644))
+
+	project := filepath.Join(tempDir, "project")
+	deps := []spec.Dependency{
+		gitDependency("github.com/org/foo", repo.Dir, "master"),
+		{Name: "local", Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "../local"}}},
+	}
+	i := &Installer{JsonnetHome: filepath.Join(project, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(project, JsonnetFile), spec.JsonnetFile{Dependencies: deps})
+	assert.NoError(t, err)
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(project, JsonnetLockFile), *lock))
+
+	var bundle bytes.Buffer
+	assert.NoError(t, WriteBundle(&bundle, project, i.JsonnetHome, *lock))
+
+	// Bundles are reproducible.
+	var again bytes.Buffer
+	assert.NoError(t, WriteBundle(&again, project, i.JsonnetHome, *lock))
+	assert.Equal(t, bundle.Bytes(), again.Bytes())
+
+	dest := filepath.Join(tempDir, "dest")
+	read, err := ReadBundle(bytes.NewReader(bundle.Bytes()), dest)
+	assert.NoError(t, err)
+	assert.Equal(t, lock.Dependencies[0].Sum, read.Dependencies[0].Sum)
+	assert.NoError(t, Verify(filepath.Join(dest, "vendor"), read))
+	target, err := os.Readlink(filepath.Join(dest, "vendor", "github.com", "org", "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("lib", "main.libsonnet"), target)
+	// Local packages are copied.
+	info, err := os.Lstat(filepath.Join(dest, "vendor", "local"))
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+	// Legacy links are not bundled.
+	_, err = os.Lstat(filepath.Join(dest, "vendor", "foo"))
+	assert.True(t, os.IsNotExist(err))
+
+	// Modified packages can neither be exported nor imported.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(i.JsonnetHome, "github.com", "org", "foo", "lib", "other.libsonnet"), []byte("{ edited: true }"), 0644))
+	err = WriteBundle(ioutil.Discard, project, i.JsonnetHome, *lock)
+	assert.IsType(t, &VendorMismatchError{}, err)
+
+	tampered := *lock
+	tampered.Dependencies = append([]spec.Dependency{}, lock.Dependencies...)
+	sum, err := hashDir(filepath.Join(i.JsonnetHome, "github.com", "org", "foo"))
+	assert.NoError(t, err)
+	tampered.Dependencies[0].Sum = sum
+	bundle.Reset()
+	assert.NoError(t, WriteBundle(&bundle, project, i.JsonnetHome, tampered))
+	_, err = ReadBundle(&bundle, filepath.Join(tempDir, "tampered"))
+	assert.IsType(t, &VendorMismatchError{}, err)
+}
+
+func TestReadBundleUnsafe(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tests := map[string][]tar.Header{
+		"absolute link": {{Name: "vendor/foo/link", Typeflag: tar.TypeSymlink, Linkname: "/etc"}},
+		"escaping link": {{Name: "vendor/foo/link", Typeflag: tar.TypeSymlink, Linkname: "../../../x"}},
+		"through link": {
+			{Name: "vendor/foo/link", Typeflag: tar.TypeSymlink, Linkname: "../bar"},
+			{Name: "vendor/foo/link/x", Typeflag: tar.TypeReg, Mode: 0644},
+		},
+		"escaping file": {{Name: "../x", Typeflag: tar.TypeReg, Mode: 0644}},
+	}
+	for name, entries := range tests {
+		t.Run(name, func(t *testing.T) {
+			var b bytes.Buffer
+			gz := gzip.NewWriter(&b)
+			tw := tar.NewWriter(gz)
+			for _, hdr := range entries {
+				hdr := hdr
+				assert.NoError(t, tw.WriteHeader(&hdr))
+			}
+			assert.NoError(t, tw.Close())
+			assert.NoError(t, gz.Close())
+
+			_, err := ReadBundle(&b, filepath.Join(tempDir, name))
+			assert.Error(t, err)
+			_, err = os.Lstat(filepath.Join(tempDir, "x"))
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
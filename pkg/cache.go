@@ -0,0 +1,301 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var fullCommitRegex = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// cacheLockFile is the lock file of a Cache or Store within its directory.
+const cacheLockFile = ".lock"
+
+// Cache stores fetched packages by source and commit, so that installing the
+// same commit again, in any project, does not fetch it again. It is locked
+// while in use, so that jb processes sharing it, like parallel CI jobs, do
+// not see it cleaned under them.
+type Cache struct {
+	Dir string
+	// LockTimeout limits how long the lock of the cache held by another
+	// process is waited for, see acquireLock. No limit if zero.
+	LockTimeout time.Duration
+}
+
+func NewCache(dir string) *Cache {
+	return &Cache{
+		Dir: dir,
+	}
+}
+
+// CacheInfo describes the contents of a Cache.
+type CacheInfo struct {
+	Entries int
+	Size    int64
+}
+
+func cacheKey(source, commit string) string {
+	h := sha256.Sum256([]byte(source + "@" + commit))
+	return hex.EncodeToString(h[:])
+}
+
+func (c *Cache) lock(ctx context.Context) (func(), error) {
+	return acquireLock(ctx, filepath.Join(c.Dir, cacheLockFile), c.LockTimeout)
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, "git", key)
+}
+
+// Get copies the entry key into dir. It returns false if there is no such
+// entry.
+func (c *Cache) Get(ctx context.Context, key, dir string) (bool, error) {
+	release, err := c.lock(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	src := c.path(key)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := copyDir(src, dir); err != nil {
+		return false, errors.Wrap(err, "failed to copy from cache")
+	}
+	return true, nil
+}
+
+// Put stores a copy of dir as the entry key. Entries are written to a
+// temporary directory first, so concurrent installs never see a partial
+// entry.
+func (c *Cache) Put(ctx context.Context, key, dir string) error {
+	release, err := c.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	dest := c.path(key)
+	if _, err := os.Stat(dest); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(filepath.Dir(dest), "tmp-"+key)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	if err := copyDir(dir, tmp); err != nil {
+		return errors.Wrap(err, "failed to copy to cache")
+	}
+	// Another install may have added the same entry in the meantime.
+	if err := os.Rename(tmp, dest); err != nil {
+		if exists, _ := FileExists(dest); !exists {
+			return err
+		}
+	}
+	return nil
+}
+
+// Info counts the entries of the cache and their size on disk.
+func (c *Cache) Info() (CacheInfo, error) {
+	info := CacheInfo{}
+
+	entries, err := ioutil.ReadDir(filepath.Join(c.Dir, "git"))
+	if os.IsNotExist(err) {
+		return info, nil
+	}
+	if err != nil {
+		return info, err
+	}
+	info.Entries = len(entries)
+
+	err = filepath.Walk(c.Dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			info.Size += fi.Size()
+		}
+		return nil
+	})
+	return info, err
+}
+
+// Clean removes all entries of the cache and the directories within it,
+// like a Store. It waits for the processes using the cache or a store in
+// one of its directories, but keeps the lock file of the cache.
+func (c *Cache) Clean() error {
+	ctx := context.Background()
+	release, err := c.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entries, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == cacheLockFile {
+			continue
+		}
+		path := filepath.Join(c.Dir, e.Name())
+		if e.IsDir() {
+			release, err := acquireLock(ctx, filepath.Join(path, cacheLockFile), c.LockTimeout)
+			if err != nil {
+				return err
+			}
+			err = os.RemoveAll(path)
+			release()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedPackage serves a package from a Cache when a full commit is
+// requested, and adds every fetched commit to the cache. Offline, it only
+// serves the cache, see Installer.Offline.
+type cachedPackage struct {
+	Interface
+	cache   *Cache
+	source  string
+	name    string
+	offline bool
+}
+
+func (p *cachedPackage) Install(ctx context.Context, dir, version string) (string, error) {
+	if fullCommitRegex.MatchString(version) {
+		ok, err := p.cache.Get(ctx, cacheKey(p.source, version), dir)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return version, nil
+		}
+	}
+	if p.offline && !fullCommitRegex.MatchString(version) {
+		return "", &OfflineError{Name: p.name, Reason: fmt.Sprintf("version %s is not a locked commit", version)}
+	}
+	if p.offline {
+		return "", &OfflineError{Name: p.name, Reason: fmt.Sprintf("commit %s is not in the cache", version)}
+	}
+
+	lockVersion, err := p.Interface.Install(ctx, dir, version)
+	if err != nil {
+		return "", err
+	}
+
+	if fullCommitRegex.MatchString(lockVersion) {
+		if err := p.cache.Put(ctx, cacheKey(p.source, lockVersion), dir); err != nil {
+			return "", err
+		}
+	}
+	return lockVersion, nil
+}
+
+// LockTag returns the tag of the wrapped package, packages served from the
+// cache have none.
+func (p *cachedPackage) LockTag() string {
+	if t, ok := p.Interface.(Tagger); ok {
+		return t.LockTag()
+	}
+	return ""
+}
+
+// LockCommit returns the commit metadata of the wrapped package, packages
+// served from the cache have none.
+func (p *cachedPackage) LockCommit(subdir string) (time.Time, string) {
+	if c, ok := p.Interface.(CommitLocker); ok {
+		return c.LockCommit(subdir)
+	}
+	return time.Time{}, ""
+}
+
+// LockSubmodules returns the submodule commits of the wrapped package,
+// packages served from the cache have none.
+func (p *cachedPackage) LockSubmodules(subdir string) map[string]string {
+	if s, ok := p.Interface.(SubmoduleLocker); ok {
+		return s.LockSubmodules(subdir)
+	}
+	return nil
+}
+
+// Resumes reports whether the wrapped package continues failed installs,
+// see Resumer.
+func (p *cachedPackage) Resumes() bool {
+	r, ok := p.Interface.(Resumer)
+	return ok && r.Resumes()
+}
+
+// copyDir copies the tree at src into dst, which is created if necessary.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, os.ModePerm)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		return writeFile(target, in, info.Mode())
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallerCache(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cache := NewCache(filepath.Join(tempDir, "cache"))
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), CacheDir: cache.Dir}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, "master")}}
+
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+
+	info, err := cache.Info()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, info.Entries)
+	assert.NotZero(t, info.Size)
+
+	// With the remote gone, the locked commit is installed from the cache,
+	// into another project.
+	repo.Close()
+	other := &Installer{JsonnetHome: filepath.Join(tempDir, "other"), CacheDir: cache.Dir}
+	_, err = other.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+
+	exists, err := FileExists(filepath.Join(other.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	assert.NoError(t, cache.Clean())
+	info, err = cache.Info()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, info.Entries)
+
+	_, err = other.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.Error(t, err)
+}
+
+func TestInstallerOffline(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	cache := NewCache(filepath.Join(tempDir, "cache"))
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), CacheDir: cache.Dir}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, "master")}}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+
+	// The lock is installed from the cache.
+	offline := &Installer{JsonnetHome: filepath.Join(tempDir, "other"), CacheDir: cache.Dir, Offline: true}
+	_, err = offline.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+	exists, err := FileExists(filepath.Join(offline.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Versions that are not locked commits, and commits that are not in the
+	// cache, are never fetched from the remote, although it is there.
+	_, err = offline.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.IsType(t, &OfflineError{}, errors.Cause(err))
+	assert.NoError(t, cache.Clean())
+	_, err = offline.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.IsType(t, &OfflineError{}, errors.Cause(err))
+
+	archive := spec.JsonnetFile{Dependencies: []spec.Dependency{{
+		Name:   "bar",
+		Source: spec.Source{ArchiveSource: &spec.ArchiveSource{URL: "https://example.com/bar.tar.gz"}},
+	}}}
+	_, err = offline.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), archive)
+	assert.IsType(t, &OfflineError{}, errors.Cause(err))
+}
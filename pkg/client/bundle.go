@@ -0,0 +1,113 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// Export writes a bundle of the project to w, like jb export: a gzipped
+// tarball of its jsonnetfile, its lock file and its vendor directory, which
+// must match the lock file, see pkg.WriteBundle. Install with FromBundle
+// installs it without network access.
+func Export(w io.Writer, opts Options) error {
+	dir := opts.dir()
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil {
+		return errors.Wrap(err, "failed to load lock file")
+	}
+
+	return pkg.WriteBundle(w, dir, opts.installer().JsonnetHome, lock)
+}
+
+// installBundle replaces the vendor directory and the lock file of the
+// project in dir with those of the bundle filename, once the digests of its
+// packages were verified. The bundle must match the jsonnetfile of the
+// project, if it has one, or else its jsonnetfile is written as well.
+func installBundle(dir, filename string, installer *pkg.Installer, workspace bool) (*spec.JsonnetFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open bundle")
+	}
+	defer f.Close()
+
+	// The bundle is extracted next to the vendor directory, so that it can
+	// be moved into place.
+	parent := filepath.Dir(installer.JsonnetHome)
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempDir(parent, ".jsonnetpkg-bundle")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	lock, err := pkg.ReadBundle(f, tmp)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid bundle %s", filename)
+	}
+
+	var m spec.JsonnetFile
+	if workspace {
+		m, err = workspaceJsonnetfile(dir, installer.JsonnetHome)
+	} else {
+		m, err = pkg.LoadJsonnetfile(filepath.Join(dir, jsonnetfile.File))
+	}
+	switch {
+	case err == nil:
+		if err := pkg.CheckLock(m, lock); err != nil {
+			return nil, err
+		}
+	case os.IsNotExist(err) || isNoJsonnetfile(err):
+		if err := os.Rename(filepath.Join(tmp, jsonnetfile.File), filepath.Join(dir, jsonnetfile.File)); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrap(err, "failed to write jsonnetfile")
+		}
+	default:
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	if err := os.RemoveAll(installer.JsonnetHome); err != nil {
+		return nil, errors.Wrap(err, "failed to remove vendor directory")
+	}
+	if err := os.Rename(filepath.Join(tmp, "vendor"), installer.JsonnetHome); err != nil {
+		return nil, errors.Wrap(err, "failed to move vendor directory into place")
+	}
+	if err := pkg.LinkLegacyNames(installer.JsonnetHome, lock); err != nil {
+		return nil, err
+	}
+	if err := jsonnetfile.Write(filepath.Join(dir, jsonnetfile.LockFile), lock); err != nil {
+		return nil, errors.Wrap(err, "failed to write lock file")
+	}
+
+	for _, d := range lock.Dependencies {
+		color.Green(">>> Installed %s version %s from bundle\n", d.Name, d.Version)
+	}
+	return &lock, nil
+}
+
+func isNoJsonnetfile(err error) bool {
+	_, ok := err.(*NoJsonnetfileError)
+	return ok
+}
@@ -0,0 +1,238 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package client is the library interface of jsonnet-bundler, for tools
+// that embed it instead of running jb. Load reads a project, Resolve turns
+// package references into dependencies, and Install and Update vendor the
+// dependencies and write the jsonnetfile and lock file just like the jb
+// commands of the same names. Failures are returned as errors, never
+// printed or exited on; errors.Cause of github.com/pkg/errors reveals the
+// typed errors of this package and of pkg.
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// NoJsonnetfileError is returned for directories without a jsonnetfile.
+type NoJsonnetfileError struct {
+	Dir string
+}
+
+func (e *NoJsonnetfileError) Error() string {
+	return fmt.Sprintf("no %s in %s", jsonnetfile.File, e.Dir)
+}
+
+// LockDivergedError is returned by Update with NoLockWrite when the
+// dependencies it vendored differ from the lock file.
+type LockDivergedError struct {
+	// Diff holds one line per dependency that differs, prefixed with +
+	// when added, - when removed and ~ when changed.
+	Diff []string
+}
+
+func (e *LockDivergedError) Error() string {
+	return fmt.Sprintf("resolved dependencies diverge from %s: %s", jsonnetfile.LockFile, strings.Join(e.Diff, ", "))
+}
+
+// Project is a directory with a jsonnetfile.
+type Project struct {
+	// Dir is the directory of the jsonnetfile.
+	Dir string
+	// Jsonnetfile is the content of the jsonnetfile.
+	Jsonnetfile spec.JsonnetFile
+	// Lock is the content of the lock file, nil if there is none.
+	Lock *spec.JsonnetFile
+}
+
+// Load reads the jsonnetfile of the project in dir and its lock file, if
+// any. It returns a NoJsonnetfileError if dir has no jsonnetfile.
+func Load(dir string) (*Project, error) {
+	if dir == "" {
+		dir = "."
+	}
+
+	filename := filepath.Join(dir, jsonnetfile.File)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, &NoJsonnetfileError{Dir: dir}
+	}
+	m, err := jsonnetfile.Load(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+	p := &Project{Dir: dir, Jsonnetfile: m}
+
+	lockFilename := filepath.Join(dir, jsonnetfile.LockFile)
+	if _, err := os.Stat(lockFilename); os.IsNotExist(err) {
+		return p, nil
+	}
+	lock, err := jsonnetfile.Load(lockFilename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load lock file")
+	}
+	p.Lock = &lock
+
+	return p, nil
+}
+
+// Options configure how Install and Update vendor dependencies. The fields
+// without documentation have the meaning of the pkg.Installer fields of the
+// same name.
+type Options struct {
+	// Dir is the directory of the project, the current directory if empty.
+	Dir string
+	// JsonnetHome is the directory packages are vendored into, relative to
+	// Dir unless absolute. It defaults to vendor.
+	JsonnetHome string
+
+	CacheDir        string
+	Offline         bool
+	StoreDir        string
+	LockTimeout     time.Duration
+	CAFile          string
+	SSHKey          string
+	SSHKnownHosts   string
+	Mirrors         pkg.Mirrors
+	Prefer          string
+	GitHubTarballs  bool
+	PreserveSubdirs bool
+	Disambiguate    bool
+	Renamed         func(pkg.Rename)
+	Conflicts       pkg.ConflictStrategy
+	Jobs            int
+	Timeout         time.Duration
+	Retries         int
+	RetryDelay      time.Duration
+	MaxRate         int64
+	Prune           bool
+	AllowHooks      bool
+	Policy          *pkg.Policy
+	Strip           []string
+	StripDocs       bool
+	Reproducible    bool
+	Verbose         bool
+	Progress        pkg.ProgressFunc
+	Fetchers        []pkg.Fetcher
+
+	// Workspace installs the dependencies of all subprojects of Dir into
+	// its vendor directory, resolved together into its lock file, see
+	// WorkspaceMembers.
+	Workspace bool
+
+	// DryRun resolves and fetches the dependencies without changing the
+	// vendor directory or writing any file, see pkg.Installer. The
+	// returned lock is the one that would have been written, see Changes
+	// for comparing it with the current one.
+	DryRun bool
+}
+
+func (o Options) dir() string {
+	if o.Dir == "" {
+		return "."
+	}
+	return o.Dir
+}
+
+func (o Options) installer() *pkg.Installer {
+	home := o.JsonnetHome
+	if home == "" {
+		home = "vendor"
+	}
+	if !filepath.IsAbs(home) {
+		home = filepath.Join(o.dir(), home)
+	}
+
+	return &pkg.Installer{
+		JsonnetHome:     home,
+		CacheDir:        o.CacheDir,
+		Offline:         o.Offline,
+		StoreDir:        o.StoreDir,
+		LockTimeout:     o.LockTimeout,
+		CAFile:          o.CAFile,
+		SSHKey:          o.SSHKey,
+		SSHKnownHosts:   o.SSHKnownHosts,
+		Mirrors:         o.Mirrors,
+		Prefer:          o.Prefer,
+		GitHubTarballs:  o.GitHubTarballs,
+		PreserveSubdirs: o.PreserveSubdirs,
+		Disambiguate:    o.Disambiguate,
+		Renamed:         o.Renamed,
+		Conflicts:       o.Conflicts,
+		Jobs:            o.Jobs,
+		Timeout:         o.Timeout,
+		Retries:         o.Retries,
+		RetryDelay:      o.RetryDelay,
+		MaxRate:         o.MaxRate,
+		Prune:           o.Prune,
+		AllowHooks:      o.AllowHooks,
+		Policy:          o.Policy,
+		Strip:           o.Strip,
+		StripDocs:       o.StripDocs,
+		Reproducible:    o.Reproducible,
+		Verbose:         o.Verbose,
+		Progress:        o.Progress,
+		Fetchers:        o.Fetchers,
+		DryRun:          o.DryRun,
+	}
+}
+
+// DependencyName returns the name of the dependency of m matching ref,
+// either by name or by the package reference it would be installed with.
+// A reference matches the dependency of the same source, of the same
+// version if there are several, so that dependencies installed under
+// another name are found as well, or else the dependency of the same name.
+// It is empty if there is no such dependency.
+func DependencyName(m spec.JsonnetFile, ref string) string {
+	for _, d := range m.Dependencies {
+		if d.Name == ref {
+			return d.Name
+		}
+	}
+
+	dep, err := Resolve(ref, ResolveOptions{})
+	if err != nil {
+		return ""
+	}
+
+	same := []spec.Dependency{}
+	for _, d := range m.Dependencies {
+		if pkg.SameSource(d.Source, dep.Source) {
+			same = append(same, d)
+		}
+	}
+	if len(same) == 1 {
+		return same[0].Name
+	}
+	for _, d := range same {
+		if d.Version == dep.Version {
+			return d.Name
+		}
+	}
+	for _, d := range m.Dependencies {
+		if d.Name == dep.Name {
+			return d.Name
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,1213 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/parser"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRepo is a git repository serving as the remote of dependencies, like
+// the one of the tests of pkg.
+type testRepo struct {
+	t   *testing.T
+	Dir string
+}
+
+// newTestRepo creates an empty repository at dir.
+func newTestRepo(t *testing.T, dir string) *testRepo {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	r := &testRepo{t: t, Dir: dir}
+	r.git("-c", "init.defaultBranch=master", "init", "-q")
+	return r
+}
+
+func (r *testRepo) git(args ...string) string {
+	args = append([]string{"-c", "user.name=jb", "-c", "user.email=jb@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes the given file and commits it, returning the commit hash.
+// Empty content removes the file.
+func (r *testRepo) commit(name, content string) string {
+	path := filepath.Join(r.Dir, name)
+	if content == "" {
+		if err := os.Remove(path); err != nil {
+			r.t.Fatal(err)
+		}
+	} else if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "update "+name)
+	return r.git("rev-parse", "HEAD")
+}
+
+// dependency returns the dependency name on the repository at version.
+func (r *testRepo) dependency(name, version string) spec.Dependency {
+	return spec.Dependency{Name: name, Source: spec.Source{GitSource: &spec.GitSource{Remote: r.Dir}}, Version: version}
+}
+
+// newTestProject creates the project at dir with a jsonnetfile requiring
+// deps.
+func newTestProject(t *testing.T, dir string, deps ...spec.Dependency) string {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: deps}); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestResolve(t *testing.T) {
+	dep, err := Resolve("github.com/foo/bar/lib", ResolveOptions{DefaultVersion: "main"})
+	assert.NoError(t, err)
+	assert.Equal(t, &spec.Dependency{
+		Name: "lib",
+		Source: spec.Source{GitSource: &spec.GitSource{
+			Remote: "https://github.com/foo/bar",
+			Subdir: "lib",
+		}},
+		Version: "main",
+	}, dep)
+
+	dep, err = Resolve("github.com/foo/monorepo/{lib-a,lib-b}@v1", ResolveOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, "{lib-a,lib-b}", dep.Source.GitSource.Subdir)
+	assert.Equal(t, "v1", dep.Version)
+
+	_, err = Resolve("foo", ResolveOptions{})
+	assert.Equal(t, &parser.Error{Ref: "foo", Part: parser.Host, Reason: "foo is not a host, local paths start with ./ or /"}, err)
+}
+
+func TestInstallAndUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = Load(dir)
+	assert.Equal(t, &NoJsonnetfileError{Dir: dir}, err)
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}})
+	assert.Equal(t, &NoJsonnetfileError{Dir: dir}, errors.Cause(err))
+
+	lib := filepath.Join(dir, "libs", "mylib")
+	assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{}))
+
+	dep, err := Resolve("./libs/mylib", ResolveOptions{})
+	assert.NoError(t, err)
+	lock, err := Install(context.TODO(), InstallOptions{
+		Options:      Options{Dir: dir},
+		Dependencies: []spec.Dependency{*dep},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 1)
+
+	p, err := Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []spec.Dependency{*dep}, p.Jsonnetfile.Dependencies)
+	if assert.NotNil(t, p.Lock) {
+		assert.Equal(t, lock.Dependencies[0].Name, p.Lock.Dependencies[0].Name)
+	}
+	_, err = os.Stat(filepath.Join(dir, "vendor", "mylib", "main.libsonnet"))
+	assert.NoError(t, err)
+
+	// A dependency missing from the committed lock makes it diverge.
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.LockFile), spec.JsonnetFile{}))
+	_, err = Update(context.TODO(), UpdateOptions{Options: Options{Dir: dir}, NoLockWrite: true})
+	assert.Equal(t, &LockDivergedError{Diff: []string{"+ mylib "}}, errors.Cause(err))
+
+	_, err = Update(context.TODO(), UpdateOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+	p, err = Load(dir)
+	assert.NoError(t, err)
+	assert.Len(t, p.Lock.Dependencies, 1)
+}
+
+func TestInstallPrefersLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"mylib", "other"} {
+		lib := filepath.Join(dir, "libs", name)
+		assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+	}
+	mylib, err := Resolve("./libs/mylib", ResolveOptions{})
+	assert.NoError(t, err)
+	other, err := Resolve("./libs/other", ResolveOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{*mylib}}))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+
+	// Dependencies added to the jsonnetfile by hand are not installed while
+	// there is a lock file, but warned about.
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{*mylib, *other}}))
+	var out bytes.Buffer
+	defer func(w io.Writer) { color.Output = w }(color.Output)
+	color.Output = &out
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 1)
+	assert.Contains(t, out.String(), "other is not locked")
+	_, err = os.Stat(filepath.Join(dir, "vendor", "other"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestUpdateNoLockWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := newTestRepo(t, filepath.Join(dir, "repo"))
+	first := repo.commit("main.libsonnet", "{ v: 1 }")
+	project := newTestProject(t, filepath.Join(dir, "project"), repo.dependency("lib", "master"))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project}})
+	assert.NoError(t, err)
+	lockFile := filepath.Join(project, jsonnetfile.LockFile)
+	committed, err := ioutil.ReadFile(lockFile)
+	assert.NoError(t, err)
+	vendored := filepath.Join(project, "vendor", "lib", "main.libsonnet")
+
+	// The lock matches what is vendored, the vendor directory is restored
+	// and the lock file left alone.
+	assert.NoError(t, os.RemoveAll(filepath.Join(project, "vendor")))
+	lock, err := Update(context.TODO(), UpdateOptions{Options: Options{Dir: project}, NoLockWrite: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, first, lock.Dependencies[0].Version)
+	}
+	b, err := ioutil.ReadFile(vendored)
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", string(b))
+
+	// A new upstream commit diverges from the lock, neither the lock file
+	// nor the vendor directory change.
+	second := repo.commit("main.libsonnet", "{ v: 2 }")
+	_, err = Update(context.TODO(), UpdateOptions{Options: Options{Dir: project}, NoLockWrite: true})
+	assert.Equal(t, &LockDivergedError{Diff: []string{"~ lib " + first + " -> " + second}}, errors.Cause(err))
+	b, err = ioutil.ReadFile(vendored)
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", string(b))
+	after, err := ioutil.ReadFile(lockFile)
+	assert.NoError(t, err)
+	assert.Equal(t, string(committed), string(after))
+}
+
+func TestInstallHeal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := newTestRepo(t, filepath.Join(dir, "repo"))
+	repo.commit("main.libsonnet", "{}")
+	project := newTestProject(t, filepath.Join(dir, "project"), repo.dependency("lib", "master"))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project}})
+	assert.NoError(t, err)
+
+	// The locked commit is force-pushed away and garbage collected.
+	repo.git("commit", "-q", "--amend", "-m", "rewritten")
+	repo.git("reflog", "expire", "--expire=now", "--all")
+	repo.git("gc", "-q", "--prune=now")
+	rewritten := repo.git("rev-parse", "HEAD")
+
+	// The vendor directory is gone as well, or else there is nothing to
+	// install.
+	assert.NoError(t, os.RemoveAll(filepath.Join(project, "vendor")))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project}})
+	_, moved := pkg.IsMoved(err)
+	assert.True(t, moved, "%v", err)
+
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: project}, Heal: true})
+	if assert.NoError(t, err) {
+		assert.Equal(t, rewritten, lock.Dependencies[0].Version)
+	}
+	p, err := Load(project)
+	assert.NoError(t, err)
+	assert.Equal(t, rewritten, p.Lock.Dependencies[0].Version)
+
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project}, Heal: true, Frozen: true})
+	assert.Error(t, err)
+}
+
+func TestDryRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"mylib", "other"} {
+		lib := filepath.Join(dir, "libs", name)
+		assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+	}
+	mylib, err := Resolve("./libs/mylib", ResolveOptions{})
+	assert.NoError(t, err)
+	other, err := Resolve("./libs/other", ResolveOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{*mylib}}))
+	committed, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+
+	// Neither the files nor the vendor directory change.
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: dir, DryRun: true}, Dependencies: []spec.Dependency{*other}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{{Name: "other", Kind: ChangeAdded}}, Changes(*committed, *lock))
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{*other}}))
+	lock, err = Update(context.TODO(), UpdateOptions{Options: Options{Dir: dir, DryRun: true, Prune: true}})
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{{Name: "other", Kind: ChangeAdded}, {Name: "mylib", Kind: ChangeRemoved}}, Changes(*committed, *lock))
+
+	p, err := Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []Change{}, Changes(*committed, *p.Lock))
+	_, err = os.Stat(filepath.Join(dir, "vendor", "mylib", "main.libsonnet"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "vendor", "other"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestChanges(t *testing.T) {
+	commit := func(c string) string { return strings.Repeat(c, 40) }
+	dep := func(name, version, tag string) spec.Dependency {
+		return spec.Dependency{
+			Name:    name,
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foo/" + name}},
+			Version: version,
+			Tag:     tag,
+		}
+	}
+	committed := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		dep("same", commit("a"), "v1.0.0"),
+		dep("bumped", commit("b"), "v1.0.0"),
+		dep("removed", commit("c"), ""),
+		dep("downgraded", commit("f"), "v2.0.0"),
+		dep("moved", commit("g"), ""),
+	}}
+	resolved := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		dep("same", commit("a"), "v1.0.0"),
+		dep("bumped", commit("d"), "v1.1.0"),
+		dep("added", commit("e"), ""),
+		dep("downgraded", commit("h"), "v1.9.0"),
+		dep("moved", commit("i"), ""),
+	}}
+
+	assert.Equal(t, []Change{
+		{Name: "bumped", Kind: ChangeUpgraded, Locked: commit("b"), LockedTag: "v1.0.0", Version: commit("d"), Tag: "v1.1.0"},
+		{Name: "added", Kind: ChangeAdded, Version: commit("e")},
+		{Name: "downgraded", Kind: ChangeDowngraded, Locked: commit("f"), LockedTag: "v2.0.0", Version: commit("h"), Tag: "v1.9.0"},
+		{Name: "moved", Kind: ChangeChanged, Locked: commit("g"), Version: commit("i")},
+		{Name: "removed", Kind: ChangeRemoved, Locked: commit("c")},
+	}, Changes(committed, resolved))
+}
+
+func TestInstallSingle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"mylib", "other"} {
+		lib := filepath.Join(dir, "libs", name)
+		assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+	}
+	mylib, err := Resolve("./libs/mylib", ResolveOptions{})
+	assert.NoError(t, err)
+	other, err := Resolve("./libs/other", ResolveOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{*mylib}}))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+	before, err := Load(dir)
+	assert.NoError(t, err)
+
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Single: true})
+	assert.Error(t, err)
+
+	// Neither the jsonnetfile nor the lock file learn about other, which
+	// the vendor directory marks as unmanaged.
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Dependencies: []spec.Dependency{*other}, Single: true})
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 1)
+	after, err := Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+	_, err = os.Stat(filepath.Join(dir, "vendor", "mylib", "main.libsonnet"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "vendor", "other", "main.libsonnet"))
+	assert.NoError(t, err)
+
+	err = pkg.Verify(filepath.Join(dir, "vendor"), *after.Lock)
+	assert.Equal(t, &pkg.VendorMismatchError{Diff: []string{"+ other: unmanaged, not in jsonnetfile.lock.json"}}, err)
+
+	// Pruning removes the unmanaged package and its record.
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir, Prune: true}})
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "vendor", "other"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(dir, "vendor", pkg.UnmanagedFile))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, pkg.Verify(filepath.Join(dir, "vendor"), *after.Lock))
+}
+
+func TestInstallRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Repair: true})
+	assert.Error(t, err)
+
+	deps := []spec.Dependency{}
+	for _, name := range []string{"mylib", "other"} {
+		lib := filepath.Join(dir, "libs", name)
+		assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+		deps = append(deps, spec.Dependency{Name: name, Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "libs/" + name}}})
+	}
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: deps}))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+
+	// The missing package is reinstalled, the other one and the stray
+	// directory are left alone.
+	vendor := filepath.Join(dir, "vendor")
+	assert.NoError(t, os.Remove(filepath.Join(vendor, "mylib")))
+	assert.NoError(t, os.Remove(filepath.Join(vendor, "other")))
+	assert.NoError(t, os.MkdirAll(filepath.Join(vendor, "other"), os.ModePerm))
+	assert.NoError(t, os.MkdirAll(filepath.Join(vendor, "stray"), os.ModePerm))
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: dir, Prune: true}, Repair: true})
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 2)
+	_, err = os.Stat(filepath.Join(vendor, "mylib", "main.libsonnet"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(vendor, "other", "main.libsonnet"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(vendor, "stray"))
+	assert.NoError(t, err)
+
+	// Nothing is reinstalled if nothing is broken.
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Repair: true})
+	assert.NoError(t, err)
+}
+
+func TestInstallUpToDate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := newTestRepo(t, filepath.Join(dir, "repo"))
+	repo.commit("main.libsonnet", "{}")
+	dep := repo.dependency("lib", "master")
+	project := newTestProject(t, filepath.Join(dir, "project"), dep)
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project}})
+	assert.NoError(t, err)
+	vendor := filepath.Join(project, "vendor")
+	_, err = os.Stat(filepath.Join(vendor, pkg.HashFile))
+	assert.NoError(t, err)
+
+	// Nothing is fetched while the vendor directory is what the files were
+	// installed to, so the remote is not needed.
+	assert.NoError(t, os.RemoveAll(repo.Dir))
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: project}})
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 1)
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project}, Frozen: true})
+	assert.NoError(t, err)
+
+	// A modified vendor directory or other options are installed again.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(vendor, "lib", "main.libsonnet"), []byte("{a: 1}"), 0644))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project}})
+	assert.Error(t, err)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(vendor, "lib", "main.libsonnet"), []byte("{}"), 0644))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project, StripDocs: true}})
+	assert.Error(t, err)
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project}})
+	assert.NoError(t, err)
+
+	// So is a changed jsonnetfile.
+	dep.Version = "main"
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(project, jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{dep}}))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project}})
+	assert.Error(t, err)
+}
+
+func TestSyncAndUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	repo := newTestRepo(t, filepath.Join(dir, "repo"))
+	for _, tag := range []string{"v1.0.0", "v1.1.0", "v2.0.0"} {
+		repo.commit("main.libsonnet", `"`+tag+`"`)
+		repo.git("tag", tag)
+	}
+
+	project := filepath.Join(dir, "project")
+	assert.NoError(t, os.MkdirAll(project, os.ModePerm))
+	_, err = Sync(context.TODO(), Options{Dir: project})
+	assert.Error(t, err)
+
+	newTestProject(t, project, repo.dependency("lib", "^1.0.0"))
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: project}})
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.1.0", lock.Dependencies[0].Tag)
+
+	// Sync restores the vendor directory and prunes it, without touching
+	// either file.
+	before, err := Load(project)
+	assert.NoError(t, err)
+	vendor := filepath.Join(project, "vendor")
+	assert.NoError(t, os.RemoveAll(filepath.Join(vendor, "lib")))
+	assert.NoError(t, os.MkdirAll(filepath.Join(vendor, "stray"), os.ModePerm))
+	_, err = Sync(context.TODO(), Options{Dir: project})
+	assert.NoError(t, err)
+	assert.NoError(t, pkg.Verify(vendor, *before.Lock))
+	after, err := Load(project)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	// Upgrade raises the constraint to the latest release.
+	lock, err = Upgrade(context.TODO(), UpgradeOptions{Options: Options{Dir: project}})
+	assert.NoError(t, err)
+	assert.Equal(t, "v2.0.0", lock.Dependencies[0].Tag)
+	p, err := Load(project)
+	assert.NoError(t, err)
+	assert.Equal(t, "^2.0.0", p.Jsonnetfile.Dependencies[0].Version)
+	assert.Equal(t, "v2.0.0", p.Lock.Dependencies[0].Tag)
+	installed, err := ioutil.ReadFile(filepath.Join(vendor, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, `"v2.0.0"`, string(installed))
+
+	_, err = Upgrade(context.TODO(), UpgradeOptions{Options: Options{Dir: project}, Packages: []string{"other"}})
+	assert.Error(t, err)
+}
+
+func TestUpdateDisambiguate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	deps := []spec.Dependency{}
+	for _, org := range []string{"foo", "bar"} {
+		repo := newTestRepo(t, filepath.Join(dir, org, "util"))
+		repo.commit("main.libsonnet", `"`+org+`"`)
+		deps = append(deps, repo.dependency("util", "master"))
+	}
+	project := newTestProject(t, filepath.Join(dir, "project"), deps...)
+
+	// The renamed dependencies keep their names in the jsonnetfile.
+	renamed := []string{}
+	_, err = Update(context.TODO(), UpdateOptions{Options: Options{Dir: project, Disambiguate: true, Renamed: func(r pkg.Rename) {
+		renamed = append(renamed, r.To)
+	}}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"foo-util", "bar-util"}, renamed)
+	p, err := Load(project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"foo-util", "bar-util"}, []string{p.Jsonnetfile.Dependencies[0].Name, p.Jsonnetfile.Dependencies[1].Name})
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: project}})
+	assert.NoError(t, err)
+}
+
+func TestUpgradedVersion(t *testing.T) {
+	for _, c := range []struct {
+		version, expected string
+		ok                bool
+	}{
+		{"^1.2.0", "^2.0.0", true},
+		{"~1.2", "~2.0.0", true},
+		{">=1.0.0", ">=1.0.0", true},
+		{"=1.2.0", "=2.0.0", true},
+		{">=1.0 <2.0", "", true},
+		{"<2", "", true},
+		{"1.0.0 || 1.5.0", "", true},
+		{"1.x || 2.x", "", true},
+		{"^1.0 || ^2.0", "^1.0 || ^2.0", true},
+		{"v1.2.0", "v2.0.0", true},
+		{"tags/v1.2.0", "tags/v2.0.0", true},
+		{"refs/tags/v1.2.0", "refs/tags/v2.0.0", true},
+		{"latest", "latest", true},
+		{"main", "", false},
+	} {
+		version, ok := upgradedVersion(c.version, "v2.0.0")
+		assert.Equal(t, c.ok, ok, c.version)
+		assert.Equal(t, c.expected, version, c.version)
+	}
+}
+
+func TestInstallGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	deps := []spec.Dependency{}
+	for _, name := range []string{"mylib", "testlib"} {
+		lib := filepath.Join(dir, "libs", name)
+		assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+		deps = append(deps, spec.Dependency{Name: name, Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "libs/" + name}}})
+	}
+	deps[1].Groups = []string{"dev"}
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: deps}))
+
+	// The groups of the dependencies are known from the lock file only.
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Groups: []string{"prod"}})
+	assert.EqualError(t, err, "selecting groups requires jsonnetfile.lock.json, install all of them first")
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+	written, err := ioutil.ReadFile(filepath.Join(dir, jsonnetfile.LockFile))
+	assert.NoError(t, err)
+
+	vendor := filepath.Join(dir, "vendor")
+	assert.NoError(t, os.RemoveAll(vendor))
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: dir, Prune: true}, Groups: []string{"prod"}})
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 1)
+	_, err = os.Stat(filepath.Join(vendor, "mylib", "main.libsonnet"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(vendor, "testlib"))
+	assert.True(t, os.IsNotExist(err))
+
+	// The lock file keeps all groups.
+	after, err := ioutil.ReadFile(filepath.Join(dir, jsonnetfile.LockFile))
+	assert.NoError(t, err)
+	assert.Equal(t, string(written), string(after))
+
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Groups: []string{"dev"}, Dependencies: deps[:1]})
+	assert.EqualError(t, err, "groups can only be selected when installing the lock file")
+}
+
+func TestInstallAs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"one", "two"} {
+		lib := filepath.Join(dir, name, "lib")
+		assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+	}
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{}))
+
+	one, err := Resolve("./one/lib", ResolveOptions{})
+	assert.NoError(t, err)
+	two, err := Resolve("./two/lib", ResolveOptions{})
+	assert.NoError(t, err)
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Dependencies: []spec.Dependency{*one}})
+	assert.NoError(t, err)
+
+	// Another package of the same name does not replace the first one.
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Dependencies: []spec.Dependency{*two}})
+	assert.IsType(t, &pkg.NameCollisionError{}, errors.Cause(err))
+
+	two, err = Resolve("./two/lib", ResolveOptions{Name: "lib-two"})
+	assert.NoError(t, err)
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Dependencies: []spec.Dependency{*two}})
+	assert.NoError(t, err)
+
+	p, err := Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []spec.Dependency{*one, *two}, p.Jsonnetfile.Dependencies)
+	assert.Equal(t, "lib-two", DependencyName(p.Jsonnetfile, "./two/lib"))
+	assert.Equal(t, "lib", DependencyName(p.Jsonnetfile, "./one/lib"))
+	for _, name := range []string{"lib", "lib-two"} {
+		_, err = os.Stat(filepath.Join(dir, "vendor", name, "main.libsonnet"))
+		assert.NoError(t, err)
+	}
+}
+
+func TestInstallKeepsAnnotations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lib := filepath.Join(dir, "libs", "mylib")
+	assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+
+	dep, err := Resolve("./libs/mylib", ResolveOptions{})
+	assert.NoError(t, err)
+	annotated := *dep
+	annotated.Comment = "needed by the dashboards"
+	annotated.Owner = "team-observability"
+	annotated.Link = "https://example.com/mylib"
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{annotated}}))
+
+	// Installing the dependency again replaces it, but keeps what it was
+	// annotated with.
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Dependencies: []spec.Dependency{*dep}})
+	assert.NoError(t, err)
+	p, err := Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []spec.Dependency{annotated}, p.Jsonnetfile.Dependencies)
+	if assert.Len(t, lock.Dependencies, 1) {
+		assert.Equal(t, "", lock.Dependencies[0].Comment)
+	}
+
+	_, err = Update(context.TODO(), UpdateOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+	p, err = Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []spec.Dependency{annotated}, p.Jsonnetfile.Dependencies)
+}
+
+func TestMigrateNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	deps := []spec.Dependency{
+		{Name: "utils", Source: spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/a/utils"}}, Version: "master"},
+		{Name: "utils-v2", Source: spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/a/utils"}}, Version: "v2"},
+	}
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: deps}))
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.LockFile), spec.JsonnetFile{Dependencies: deps}))
+
+	// Legacy jsonnetfiles are left alone.
+	installer := &pkg.Installer{}
+	assert.NoError(t, migrateNames(dir, installer, true))
+	assert.False(t, installer.QualifiedNames)
+	p, err := Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, "utils", p.Jsonnetfile.Dependencies[0].Name)
+
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Version: spec.QualifiedVersion, Dependencies: deps}))
+	assert.NoError(t, migrateNames(dir, installer, true))
+	assert.True(t, installer.QualifiedNames)
+	p, err = Load(dir)
+	assert.NoError(t, err)
+	for _, f := range []spec.JsonnetFile{p.Jsonnetfile, *p.Lock} {
+		assert.Equal(t, "github.com/a/utils", f.Dependencies[0].Name)
+		assert.Equal(t, "utils-v2", f.Dependencies[1].Name)
+	}
+	assert.Equal(t, spec.QualifiedVersion, p.Jsonnetfile.Version)
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	_, err = Migrate(dir)
+	assert.Equal(t, &NoJsonnetfileError{Dir: dir}, err)
+
+	deps := []spec.Dependency{
+		{Name: "utils", Source: spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/a/utils"}}, Version: "master"},
+	}
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: deps}))
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.LockFile), spec.JsonnetFile{Dependencies: deps}))
+
+	changed, err := Migrate(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{jsonnetfile.File, jsonnetfile.LockFile}, changed)
+	p, err := Load(dir)
+	assert.NoError(t, err)
+	for _, f := range []spec.JsonnetFile{p.Jsonnetfile, *p.Lock} {
+		assert.Equal(t, spec.LatestVersion, f.Version)
+		assert.Equal(t, "github.com/a/utils", f.Dependencies[0].Name)
+	}
+
+	changed, err = Migrate(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, changed)
+}
+
+func TestInstallWorkspace(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"mylib", "other"} {
+		lib := filepath.Join(dir, "libs", name)
+		assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+	}
+	local := func(name string) spec.Dependency {
+		return spec.Dependency{Name: name, Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "../../libs/" + name}}}
+	}
+	// Both environments depend on mylib, only prod on other. The vendor
+	// directory of dev is not searched for members.
+	for env, deps := range map[string][]spec.Dependency{
+		"dev":  {local("mylib")},
+		"prod": {local("mylib"), local("other")},
+	} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, "envs", env, "vendor", "pkg"), os.ModePerm))
+		assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, "envs", env, jsonnetfile.File), spec.JsonnetFile{Dependencies: deps}))
+		assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, "envs", env, "vendor", "pkg", jsonnetfile.File), spec.JsonnetFile{}))
+	}
+
+	members, err := WorkspaceMembers(dir, "vendor")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join("envs", "dev"), filepath.Join("envs", "prod")}, members)
+
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: dir, Workspace: true}})
+	assert.NoError(t, err)
+	names := []string{}
+	for _, d := range lock.Dependencies {
+		names = append(names, d.Name)
+	}
+	assert.Equal(t, []string{"mylib", "other"}, names)
+	assert.Equal(t, "libs/mylib", lock.Dependencies[0].Source.LocalSource.Directory)
+	for _, name := range names {
+		_, err = os.Stat(filepath.Join(dir, "vendor", name, "main.libsonnet"))
+		assert.NoError(t, err)
+	}
+	_, err = os.Stat(filepath.Join(dir, jsonnetfile.LockFile))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, jsonnetfile.File))
+	assert.True(t, os.IsNotExist(err))
+
+	// The workspace jsonnetfile may list its members.
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Workspace: []string{"envs/dev"}}))
+	members, err = WorkspaceMembers(dir, "vendor")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join("envs", "dev")}, members)
+
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir, Workspace: true}, Frozen: true})
+	assert.NoError(t, err)
+	lock, err = Update(context.TODO(), UpdateOptions{Options: Options{Dir: dir, Workspace: true}})
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 1)
+}
+
+func TestMergeDependencies(t *testing.T) {
+	git := func(version string) spec.Dependency {
+		return spec.Dependency{Name: "lib", Source: spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foo/lib"}}, Version: version}
+	}
+
+	merged := mergeDependencies([]spec.Dependency{git("^1.0"), git("~1.2"), git("^1.0"), git("main"), git(">=1 || <0.5")})
+	assert.Equal(t, []spec.Dependency{git("^1.0 ~1.2"), git("main"), git(">=1 || <0.5")}, merged)
+}
+
+func TestExportAndInstallBundle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lib := filepath.Join(dir, "libs", "mylib")
+	assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+	src := filepath.Join(dir, "src")
+	assert.NoError(t, os.MkdirAll(src, os.ModePerm))
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(src, jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{
+		{Name: "mylib", Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "../libs/mylib"}}},
+	}}))
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: src}})
+	assert.NoError(t, err)
+
+	bundle := filepath.Join(dir, "bundle.tar.gz")
+	f, err := os.Create(bundle)
+	assert.NoError(t, err)
+	assert.NoError(t, Export(f, Options{Dir: src}))
+	assert.NoError(t, f.Close())
+
+	// The bundle installs without the local package and writes the
+	// jsonnetfile of a new project.
+	assert.NoError(t, os.RemoveAll(lib))
+	dest := filepath.Join(dir, "dest")
+	assert.NoError(t, os.MkdirAll(filepath.Join(dest, "vendor", "stale"), os.ModePerm))
+	installed, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: dest}, FromBundle: bundle})
+	assert.NoError(t, err)
+	assert.Len(t, installed.Dependencies, 1)
+	assert.Equal(t, lock.Dependencies[0].Source, installed.Dependencies[0].Source)
+	b, err := ioutil.ReadFile(filepath.Join(dest, "vendor", "mylib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(b))
+	_, err = os.Stat(filepath.Join(dest, "vendor", "stale"))
+	assert.True(t, os.IsNotExist(err))
+	for _, name := range []string{jsonnetfile.File, jsonnetfile.LockFile} {
+		_, err = os.Stat(filepath.Join(dest, name))
+		assert.NoError(t, err)
+	}
+
+	// A bundle that does not match the jsonnetfile is refused.
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dest, jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{
+		{Name: "other", Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "../libs/other"}}},
+	}}))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dest}, FromBundle: bundle})
+	assert.IsType(t, &pkg.LockOutOfSyncError{}, errors.Cause(err))
+	_, err = os.Stat(filepath.Join(dest, "vendor", "mylib", "main.libsonnet"))
+	assert.NoError(t, err)
+}
+
+func TestPublish(t *testing.T) {
+	root, err := ioutil.TempDir("", "jb-publish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo := newTestRepo(t, filepath.Join(root, "lib"))
+	dir := repo.Dir
+	// Publish tags with the identity of the repository.
+	repo.git("config", "user.name", "jb")
+	repo.git("config", "user.email", "jb@example.com")
+	repo.commit(".gitignore", "vendor\n")
+
+	// Without a jsonnetfile and with imports broken for consumers nothing
+	// is tagged.
+	repo.commit("main.libsonnet", "(import '/etc/lib.libsonnet') + (import 'missing.libsonnet') + (import '../outside.libsonnet')")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "outside.libsonnet"), []byte("{}"), 0644))
+	_, err = Publish(context.TODO(), PublishOptions{Dir: dir, Bump: "minor"})
+	main := filepath.Join(dir, "main.libsonnet")
+	assert.Equal(t, &InvalidPackageError{Reasons: []string{
+		"there is no jsonnetfile",
+		main + ":1: absolute import /etc/lib.libsonnet",
+		main + ":1: missing.libsonnet does not resolve",
+		main + ":1: ../outside.libsonnet leaves the package",
+	}}, err)
+	assert.Equal(t, "", repo.git("tag", "--list"))
+
+	// Uncommitted changes are refused as well.
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{}))
+	assert.NoError(t, ioutil.WriteFile(main, []byte("{}"), 0644))
+	_, err = Publish(context.TODO(), PublishOptions{Dir: dir, Bump: "minor"})
+	assert.Equal(t, &InvalidPackageError{Reasons: []string{"the working tree has uncommitted changes"}}, err)
+
+	// The first release bumps v0.0.0, later ones the latest tag.
+	repo.git("add", "-A")
+	repo.git("commit", "-q", "-m", "fix")
+	tag, err := Publish(context.TODO(), PublishOptions{Dir: dir, Bump: "minor"})
+	assert.NoError(t, err)
+	assert.Equal(t, "v0.1.0", tag)
+	tag, err = Publish(context.TODO(), PublishOptions{Dir: dir, Bump: "patch", DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "v0.1.1", tag)
+	tag, err = Publish(context.TODO(), PublishOptions{Dir: dir, Version: "v1.0.0-rc.1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.0.0-rc.1", tag)
+	tag, err = Publish(context.TODO(), PublishOptions{Dir: dir, Bump: "major"})
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.0.0", tag)
+	assert.Equal(t, "v0.1.0\nv1.0.0\nv1.0.0-rc.1", repo.git("tag", "--list"))
+
+	_, err = Publish(context.TODO(), PublishOptions{Dir: dir, Version: "v0.2.0"})
+	assert.EqualError(t, err, "version v0.2.0 is not newer than the latest tag v1.0.0")
+
+	// Tags are pushed to the remote.
+	remote := filepath.Join(root, "remote")
+	_, err = gitOutput(context.TODO(), root, "init", "-q", "--bare", remote)
+	assert.NoError(t, err)
+	repo.git("remote", "add", "origin", remote)
+	tag, err = Publish(context.TODO(), PublishOptions{Dir: dir, Bump: "patch", Push: true})
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.0.1", tag)
+	pushed, err := gitOutput(context.TODO(), remote, "tag", "--list")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.0.1", pushed)
+}
+
+func TestDiff(t *testing.T) {
+	root, err := ioutil.TempDir("", "jb-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo := newTestRepo(t, filepath.Join(root, "lib"))
+	release := func(tag string, files map[string]string) {
+		for name, content := range files {
+			repo.commit(name, content)
+		}
+		repo.git("tag", tag)
+	}
+	release("v1.0.0", map[string]string{"main.libsonnet": "{ a: 1 }", "old.libsonnet": "{}", "same.libsonnet": "{}"})
+	release("v1.1.0", map[string]string{"main.libsonnet": "{ a: 2 }", "old.libsonnet": "", "new.libsonnet": "{}"})
+	dir := newTestProject(t, filepath.Join(root, "project"), repo.dependency("lib", "v1.0.0"))
+	_, err = Diff(context.TODO(), DiffOptions{Options: Options{Dir: dir}, Package: "lib", Version: "v1.1.0"})
+	assert.Error(t, err)
+
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+	before, err := Load(dir)
+	assert.NoError(t, err)
+
+	d, err := Diff(context.TODO(), DiffOptions{Options: Options{Dir: dir}, Package: "lib", Version: "v1.1.0"})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer d.Close()
+	assert.Equal(t, []pkg.FileChange{
+		{Path: "main.libsonnet", Kind: pkg.FileModified},
+		{Path: "new.libsonnet", Kind: pkg.FileAdded},
+		{Path: "old.libsonnet", Kind: pkg.FileRemoved},
+	}, d.Files)
+	assert.Equal(t, "v1.0.0", d.Locked.Tag)
+	assert.Equal(t, "v1.1.0", d.Other.Tag)
+	assert.Equal(t, filepath.Join(dir, "vendor", "lib"), d.LockedDir)
+
+	// Nothing the project has changes.
+	after, err := Load(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.NoError(t, pkg.Verify(filepath.Join(dir, "vendor"), *after.Lock))
+
+	assert.NoError(t, d.Close())
+	_, err = os.Stat(d.OtherDir)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = Diff(context.TODO(), DiffOptions{Options: Options{Dir: dir}, Package: "other", Version: "v1.1.0"})
+	assert.EqualError(t, err, "other is not locked in jsonnetfile.lock.json")
+}
+
+func TestPrefetch(t *testing.T) {
+	root, err := ioutil.TempDir("", "jb-prefetch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	repo := newTestRepo(t, filepath.Join(root, "lib"))
+	repo.commit("main.libsonnet", "{}")
+	dir := filepath.Join(root, "project")
+	cache := filepath.Join(root, "cache")
+	assert.NoError(t, os.Mkdir(dir, os.ModePerm))
+
+	_, err = Prefetch(context.TODO(), PrefetchOptions{Options: Options{Dir: dir, CacheDir: cache}})
+	assert.Error(t, err)
+
+	newTestProject(t, dir, repo.dependency("lib", "master"))
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+	assert.NoError(t, os.RemoveAll(filepath.Join(dir, "vendor")))
+
+	_, err = Prefetch(context.TODO(), PrefetchOptions{Options: Options{Dir: dir}})
+	assert.EqualError(t, err, "prefetching requires a cache directory")
+
+	opts := Options{Dir: dir, CacheDir: cache, StoreDir: filepath.Join(cache, "store")}
+	fetched, err := Prefetch(context.TODO(), PrefetchOptions{Options: opts})
+	assert.NoError(t, err)
+	if assert.NotNil(t, fetched) {
+		assert.Len(t, fetched.Dependencies, 1)
+	}
+	_, err = os.Stat(filepath.Join(dir, "vendor"))
+	assert.True(t, os.IsNotExist(err))
+
+	// The lock installs from the cache once the remote is gone.
+	assert.NoError(t, os.RemoveAll(repo.Dir))
+	_, err = Install(context.TODO(), InstallOptions{Options: opts, Frozen: true})
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dir, "vendor", "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+}
+
+func TestInstallTarget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for lib, content := range map[string]string{"common": "{}", "v1/k8s": "{ v: 1 }", "v2/k8s": "{ v: 2 }"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, lib), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, lib, "main.libsonnet"), []byte(content), 0644))
+	}
+	common, err := Resolve("./common", ResolveOptions{})
+	assert.NoError(t, err)
+	k8s1, err := Resolve("./v1/k8s", ResolveOptions{})
+	assert.NoError(t, err)
+	k8s2, err := Resolve("./v2/k8s", ResolveOptions{})
+	assert.NoError(t, err)
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{
+		Dependencies: []spec.Dependency{*common, *k8s1},
+		Targets: map[string]spec.Target{
+			"next":   {Dependencies: []spec.Dependency{*k8s2}, JsonnetHome: "vendor-next"},
+			"stable": {Dependencies: []spec.Dependency{}},
+		},
+	}))
+	content := func(home string) string {
+		b, err := ioutil.ReadFile(filepath.Join(dir, home, "k8s", "main.libsonnet"))
+		assert.NoError(t, err)
+		return string(b)
+	}
+
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Target: "next"})
+	assert.EqualError(t, err, "installing a target requires jsonnetfile.lock.json, install the project first")
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Target: "missing"})
+	assert.EqualError(t, err, "no target missing in jsonnetfile.json")
+
+	// The next target gets its own version of k8s, in its own vendor
+	// directory.
+	lock, err := Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Target: "next"})
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 2)
+	assert.Equal(t, "{ v: 2 }", content("vendor-next"))
+	assert.Equal(t, "{ v: 1 }", content("vendor"))
+
+	p, err := Load(dir)
+	assert.NoError(t, err)
+	assert.Len(t, p.Lock.Dependencies, 2)
+	if assert.Len(t, p.Lock.Targets["next"].Dependencies, 2) {
+		assert.Equal(t, k8s2.Source.LocalSource.Directory, p.Lock.Targets["next"].Dependencies[1].Source.LocalSource.Directory)
+	}
+	assert.Equal(t, "vendor-next", p.Lock.Targets["next"].JsonnetHome)
+
+	// Updating the project keeps the locked targets.
+	_, err = Update(context.TODO(), UpdateOptions{Options: Options{Dir: dir}})
+	assert.NoError(t, err)
+	p, err = Load(dir)
+	assert.NoError(t, err)
+	assert.Contains(t, p.Lock.Targets, "next")
+
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Target: "next", Frozen: true})
+	assert.NoError(t, err)
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Target: "stable", Frozen: true})
+	assert.Equal(t, &pkg.LockOutOfSyncError{Reasons: []string{"the target stable is not locked"}}, errors.Cause(err))
+
+	// A target without a vendor directory of its own is vendored into the
+	// one of the project.
+	_, err = Install(context.TODO(), InstallOptions{Options: Options{Dir: dir}, Target: "stable"})
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", content("vendor"))
+}
+
+func TestTargetJsonnetfile(t *testing.T) {
+	a := spec.Dependency{Name: "a", Version: "v1"}
+	b := spec.Dependency{Name: "b", Version: "v1"}
+	b2 := spec.Dependency{Name: "b", Version: "v2"}
+	c := spec.Dependency{Name: "c", Version: "v1"}
+	m := spec.JsonnetFile{
+		Dependencies: []spec.Dependency{a, b},
+		Targets:      map[string]spec.Target{"prod": {Dependencies: []spec.Dependency{b2, c}}},
+	}
+
+	prod, err := TargetJsonnetfile(m, "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, spec.JsonnetFile{Dependencies: []spec.Dependency{a, b2, c}}, prod)
+	_, err = TargetJsonnetfile(m, "dev")
+	assert.EqualError(t, err, "no target dev in jsonnetfile.json")
+}
+
+func TestMigrateLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	opts := MigrateLayoutOptions{}
+	opts.Dir = dir
+	migration, err := MigrateLayout(opts)
+	assert.NoError(t, err)
+	assert.Nil(t, migration)
+
+	legacy := filepath.Join(dir, LegacyLayoutDir)
+	for _, name := range []string{"github.com/a/repo/lib/main.libsonnet", "github.com/a/repo/lib/nested/util.libsonnet", "other/main.libsonnet"} {
+		filename := filepath.Join(legacy, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(filename, []byte("{}"), 0644))
+	}
+	vendor := filepath.Join(dir, "vendor")
+	assert.NoError(t, os.MkdirAll(vendor, os.ModePerm))
+	assert.NoError(t, os.Symlink(filepath.Join("..", LegacyLayoutDir, "github.com", "a", "repo", "lib"), filepath.Join(vendor, "lib")))
+	assert.NoError(t, os.Symlink(filepath.Join("..", LegacyLayoutDir, "github.com", "a", "repo", "lib", "nested"), filepath.Join(vendor, "nested")))
+	assert.NoError(t, os.Symlink(filepath.Join(LegacyLayoutDir, "other"), filepath.Join(dir, "other")))
+
+	// Packages are not moved over existing files.
+	assert.NoError(t, os.MkdirAll(filepath.Join(vendor, "other"), os.ModePerm))
+	_, err = MigrateLayout(opts)
+	assert.EqualError(t, err, "cannot move .jsonnetpkg/src/other to vendor/other, which already exists")
+	assert.NoError(t, os.Remove(filepath.Join(vendor, "other")))
+
+	want := &LayoutMigration{
+		Moved:    map[string]string{"vendor/lib": ".jsonnetpkg/src/github.com/a/repo/lib", "vendor/other": ".jsonnetpkg/src/other"},
+		Relinked: []string{"other", "vendor/nested"},
+	}
+	opts.DryRun = true
+	migration, err = MigrateLayout(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, want, migration)
+	assert.True(t, HasLegacyLayout(dir))
+
+	opts.DryRun = false
+	migration, err = MigrateLayout(opts)
+	assert.NoError(t, err)
+	assert.Equal(t, want, migration)
+	assert.False(t, HasLegacyLayout(dir))
+	_, err = os.Stat(filepath.Join(dir, ".jsonnetpkg"))
+	assert.True(t, os.IsNotExist(err))
+
+	info, err := os.Lstat(filepath.Join(vendor, "lib"))
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+	for _, name := range []string{"vendor/lib/main.libsonnet", "vendor/nested/util.libsonnet", "vendor/other/main.libsonnet", "other/main.libsonnet"} {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		assert.NoError(t, err, name)
+	}
+	target, err := os.Readlink(filepath.Join(vendor, "nested"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("lib", "nested"), target)
+}
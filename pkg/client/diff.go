@@ -0,0 +1,132 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// DiffOptions configure Diff.
+type DiffOptions struct {
+	Options
+
+	// Package is the name of the locked dependency, or a package reference
+	// matching it, see DependencyName.
+	Package string
+	// Version is the version to compare the vendored one with, like a tag,
+	// a branch or a commit.
+	Version string
+}
+
+// PackageDiff is the difference between the vendored version of a
+// dependency and another one, see Diff. Close removes the other version.
+type PackageDiff struct {
+	// Locked is the dependency as locked, vendored at LockedDir.
+	Locked    spec.Dependency
+	LockedDir string
+	// Other is the dependency at the other version, as it would be locked,
+	// installed at OtherDir.
+	Other    spec.Dependency
+	OtherDir string
+	// Files are the files that differ between both.
+	Files []pkg.FileChange
+
+	tmpDir string
+}
+
+// Close removes the other version of the package.
+func (d *PackageDiff) Close() error {
+	return os.RemoveAll(d.tmpDir)
+}
+
+// Diff installs another version of a locked dependency of the project into
+// a temporary directory, through the cache, and compares it with the vendored
+// one, so that an upgrade can be reviewed before updating the lock file. The
+// other version is stripped like the lock and installed without the packages
+// it depends on. Neither the vendor directory nor the lock file change.
+func Diff(ctx context.Context, opts DiffOptions) (*PackageDiff, error) {
+	dir := opts.dir()
+	lockFilename := filepath.Join(dir, jsonnetfile.LockFile)
+	lock, err := jsonnetfile.Load(lockFilename)
+	if err != nil {
+		return nil, errors.Wrap(err, "comparing a package requires a lock file")
+	}
+
+	name := DependencyName(lock, opts.Package)
+	var locked spec.Dependency
+	for _, d := range lock.Dependencies {
+		if d.Name == name {
+			locked = d
+		}
+	}
+	switch {
+	case name == "":
+		return nil, fmt.Errorf("%s is not locked in %s", opts.Package, jsonnetfile.LockFile)
+	case locked.Source.LocalSource != nil, locked.Source.ArchiveSource != nil:
+		return nil, fmt.Errorf("%s is installed from %s, which has no other versions", name, pkg.SourceString(locked.Source))
+	}
+
+	installer := opts.installer()
+	lockedDir := pkg.VendorPath(installer.JsonnetHome, locked)
+	if _, err := os.Stat(lockedDir); err != nil {
+		return nil, errors.Wrapf(err, "%s is not vendored, run jb install first", name)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "jb-diff")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create tmp dir")
+	}
+	d := &PackageDiff{Locked: locked, LockedDir: lockedDir, tmpDir: tmpDir}
+
+	// Installed as a lock file, the other version comes without the
+	// packages it depends on.
+	other := locked
+	other.Version = opts.Version
+	other.Sum = ""
+	other.Tag = ""
+	other.Date = ""
+	other.Tree = ""
+	other.SubmoduleCommits = nil
+	m := spec.JsonnetFile{Version: lock.Version, Strip: lock.Strip, TrustedKeys: lock.TrustedKeys, Dependencies: []spec.Dependency{other}}
+
+	installer.JsonnetHome = filepath.Join(tmpDir, "vendor")
+	installer.StoreDir = ""
+	installer.Prune = false
+	installer.AllowHooks = false
+	installer.DryRun = false
+	stripLike(installer, lock)
+	installed, err := installer.Install(ctx, lockFilename, m)
+	if err != nil {
+		d.Close()
+		return nil, errors.Wrapf(err, "failed to install %s version %s", name, opts.Version)
+	}
+	d.Other = installed.Dependencies[0]
+	d.OtherDir = pkg.VendorPath(installer.JsonnetHome, d.Other)
+
+	if d.Files, err = pkg.DiffDirs(d.LockedDir, d.OtherDir); err != nil {
+		d.Close()
+		return nil, errors.Wrapf(err, "failed to compare %s", name)
+	}
+	return d, nil
+}
@@ -0,0 +1,996 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// InstallOptions configure Install.
+type InstallOptions struct {
+	Options
+
+	// Dependencies are added to the jsonnetfile before installing,
+	// replacing dependencies of the same name and source. A dependency of
+	// the same name but another source fails the install with a
+	// pkg.NameCollisionError, unless Disambiguate is set. See Resolve for
+	// creating them from package references.
+	Dependencies []spec.Dependency
+
+	// Expand is asked about each of Dependencies on the root of a git
+	// repository holding nested packages, see pkg.NestedPackages, whether
+	// to install those instead of the repository as a whole. It is told
+	// the dependencies on them. Dependencies on repository roots are
+	// installed as they are if it is nil, without looking for nested
+	// packages.
+	Expand func(dep spec.Dependency, nested []spec.Dependency) bool
+
+	// Frozen installs exactly the lock file, failing with a
+	// pkg.LockOutOfSyncError if it is missing or does not match the
+	// jsonnetfile. Neither file is written.
+	Frozen bool
+
+	// FromBundle installs the bundle of this file, written by Export,
+	// instead of fetching anything. The vendor directory and the lock file
+	// are replaced with those of the bundle once the digests of all its
+	// packages were verified. The bundle must match the jsonnetfile like
+	// for Frozen.
+	FromBundle string
+
+	// Single vendors Dependencies, and what they depend on, without
+	// writing the jsonnetfile or the lock file, for trying packages out.
+	// They are recorded as unmanaged in the vendor directory instead, see
+	// pkg.UnmanagedFile, which jb verify reports and Prune removes.
+	Single bool
+
+	// Groups installs only the dependencies of the project in one of these
+	// groups, along with everything they depend on, see
+	// spec.Dependency.Groups. It requires a lock file, which is installed
+	// as recorded and not written.
+	Groups []string
+
+	// Repair reinstalls only the packages of the lock file that are missing
+	// from the vendor directory or were modified, see pkg.Verify, leaving
+	// all others as they are. It requires a lock file, and neither file is
+	// written.
+	Repair bool
+
+	// Target installs the dependencies of this target of the jsonnetfile
+	// instead of those of the project, into the vendor directory of the
+	// target, see spec.JsonnetFile.Targets. The target is installed as
+	// locked if the lock file records it in sync with the jsonnetfile, and
+	// resolved and locked otherwise. It requires the lock file of the
+	// project.
+	Target string
+
+	// Heal re-resolves the dependencies of the lock file whose locked
+	// commits no longer exist on their remote, see pkg.MovedError, from the
+	// jsonnetfile, keeping all others locked, instead of failing. The lock
+	// file is written with the commits they resolved to. It only applies
+	// to installs of the whole lock file.
+	Heal bool
+}
+
+// Install vendors the dependencies of the project, like jb install. If
+// there is a lock file, exactly its commits are installed and the
+// jsonnetfile is only checked against it, with a warning if they are out
+// of sync: changes to the jsonnetfile take effect through Update. Only
+// without a lock file are the dependencies of the jsonnetfile resolved.
+// Added dependencies are resolved while all others stay locked. The
+// jsonnetfile, with the added dependencies, and the lock file are written
+// back and the lock is returned.
+func Install(ctx context.Context, opts InstallOptions) (*spec.JsonnetFile, error) {
+	dir := opts.dir()
+	installer := opts.installer()
+
+	if opts.Heal && (len(opts.Dependencies) > 0 || len(opts.Groups) > 0 || opts.Frozen || opts.Single || opts.Repair || opts.FromBundle != "" || opts.Workspace || opts.Target != "") {
+		return nil, errors.New("only installs of the whole lock file heal moved dependencies")
+	}
+
+	if len(opts.Groups) > 0 {
+		if len(opts.Dependencies) > 0 || opts.Single || opts.Repair || opts.FromBundle != "" || opts.Workspace {
+			return nil, errors.New("groups can only be selected when installing the lock file")
+		}
+		installer.Groups = opts.Groups
+	}
+
+	if opts.Target != "" {
+		if len(opts.Dependencies) > 0 || len(opts.Groups) > 0 || opts.Single || opts.Repair || opts.FromBundle != "" || opts.Workspace {
+			return nil, errors.New("a target can only be installed from the jsonnetfile and the lock file")
+		}
+		return installTarget(ctx, dir, installer, opts.Target, opts.Frozen, false)
+	}
+
+	if opts.FromBundle != "" {
+		if len(opts.Dependencies) > 0 {
+			return nil, errors.New("dependencies cannot be added when installing a bundle")
+		}
+		if opts.DryRun {
+			return nil, errors.New("a bundle cannot be installed as a dry run")
+		}
+		return installBundle(dir, opts.FromBundle, installer, opts.Workspace)
+	}
+
+	if opts.Single {
+		if len(opts.Dependencies) == 0 {
+			return nil, errors.New("a single install requires packages to install")
+		}
+		if opts.Workspace || opts.Frozen {
+			return nil, errors.New("a single install cannot be a workspace or frozen install")
+		}
+		deps, err := expandNested(ctx, installer, opts.Dependencies, opts.Expand)
+		if err != nil {
+			return nil, err
+		}
+		return installSingle(ctx, dir, installer, deps)
+	}
+
+	if opts.Repair {
+		if len(opts.Dependencies) > 0 {
+			return nil, errors.New("dependencies cannot be added when repairing the vendor directory")
+		}
+		return installRepair(ctx, dir, installer)
+	}
+
+	if opts.Workspace {
+		if len(opts.Dependencies) > 0 {
+			return nil, errors.New("dependencies cannot be added to a workspace, add them to one of its members")
+		}
+		return installWorkspace(ctx, dir, installer, opts.Frozen)
+	}
+
+	if opts.Frozen {
+		if len(opts.Dependencies) > 0 {
+			return nil, errors.New("dependencies cannot be added to a frozen install")
+		}
+		return installFrozen(ctx, dir, installer)
+	}
+
+	if err := migrateNames(dir, installer, !opts.DryRun); err != nil {
+		return nil, err
+	}
+
+	if len(opts.Dependencies) > 0 {
+		deps, err := expandNested(ctx, installer, opts.Dependencies, opts.Expand)
+		if err != nil {
+			return nil, err
+		}
+		return addAndInstall(ctx, dir, installer, deps)
+	}
+
+	filename, isLock, err := jsonnetfile.Choose(dir)
+	if err == jsonnetfile.ErrNoFile {
+		return nil, &NoJsonnetfileError{Dir: dir}
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to choose jsonnetfile")
+	}
+	// The groups of the dependencies are only known for all of them once
+	// they were resolved into the lock file.
+	if len(installer.Groups) > 0 && !isLock {
+		return nil, fmt.Errorf("selecting groups requires %s, install all of them first", jsonnetfile.LockFile)
+	}
+
+	m, err := jsonnetfile.Load(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	// Renamed dependencies are written back to the jsonnetfile so that they
+	// keep their names on subsequent installs.
+	if installer.Disambiguate {
+		m.Dependencies = installer.DisambiguateNames(m.Dependencies)
+	}
+
+	// Installing the lock file again changes nothing if it is what the
+	// vendor directory was installed from.
+	hash, err := installer.VendorHash(filepath.Join(dir, jsonnetfile.File), filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to hash the jsonnetfile")
+	}
+	if isLock && len(installer.Groups) == 0 && !installer.DryRun && installer.UpToDate(hash, m) {
+		color.Green(">>> %s is up to date with %s\n", filepath.Base(installer.JsonnetHome), jsonnetfile.LockFile)
+		return &m, nil
+	}
+
+	lock, err := installer.Install(ctx, filename, m)
+	if isLock && opts.Heal {
+		lock, err = heal(ctx, dir, installer, err)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to install")
+	}
+
+	// Lock files written by older versions may hold branches or tags instead
+	// of commits. They are rewritten with the commits those resolved to, so
+	// that the next install is reproducible. Otherwise there is no need to
+	// write any files back when installing from the lock file.
+	if isLock {
+		// Only some of the locked dependencies were installed, the lock
+		// file stays as it is.
+		if len(installer.Groups) > 0 {
+			warnOutOfSync(dir, m)
+			return lock, nil
+		}
+		warnOutOfSync(dir, *lock)
+		if installer.DryRun {
+			return lock, nil
+		}
+		if len(lockDiff(m, *lock)) != 0 {
+			color.Yellow(">>> Pinning %s to the installed commits\n", jsonnetfile.LockFile)
+			if err := writeLock(filename, *lock); err != nil {
+				return nil, errors.Wrap(err, "failed to write lock file")
+			}
+		}
+		return lock, recordVendorHash(dir, installer)
+	}
+	if installer.DryRun {
+		return lock, nil
+	}
+
+	if err := jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), m); err != nil {
+		return nil, errors.Wrap(err, "failed to write jsonnet file")
+	}
+	if err := writeLock(filepath.Join(dir, jsonnetfile.LockFile), *lock); err != nil {
+		return nil, errors.Wrap(err, "failed to write lock file")
+	}
+
+	return lock, recordVendorHash(dir, installer)
+}
+
+// recordVendorHash records the hash of the jsonnetfile and the lock file in
+// dir in the vendor directory, which was just installed from them, see
+// pkg.HashFile.
+func recordVendorHash(dir string, installer *pkg.Installer) error {
+	hash, err := installer.VendorHash(filepath.Join(dir, jsonnetfile.File), filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil {
+		return errors.Wrap(err, "failed to hash the jsonnetfile")
+	}
+	return installer.WriteVendorHash(hash)
+}
+
+// heal updates the dependencies of the project in dir that moved, as long
+// as err, the failure to install its lock file, is a pkg.MovedError. Every
+// dependency that moved is re-resolved, and all others stay locked.
+func heal(ctx context.Context, dir string, installer *pkg.Installer, err error) (*spec.JsonnetFile, error) {
+	filename := filepath.Join(dir, jsonnetfile.File)
+	// A frozen dependency that moved cannot stay at its locked commit
+	// either, the others are kept by the update of the moved ones only.
+	installer.IncludeFrozen = true
+	healed := map[string]bool{}
+	names := []string{}
+	for {
+		moved, ok := pkg.IsMoved(err)
+		if !ok || healed[moved.Name] {
+			return nil, err
+		}
+		color.Yellow(">>> %s moved, re-resolving it from %s\n", moved.Name, jsonnetfile.File)
+		healed[moved.Name] = true
+		names = append(names, moved.Name)
+
+		m, lerr := jsonnetfile.Load(filename)
+		if lerr != nil {
+			return nil, errors.Wrap(lerr, "failed to load jsonnetfile")
+		}
+		var lock *spec.JsonnetFile
+		lock, err = installer.Update(ctx, filename, m, names...)
+		if err == nil {
+			return lock, nil
+		}
+	}
+}
+
+// expandNested replaces the dependencies of deps on repository roots with
+// nested packages by the dependencies on those packages, if expand agrees.
+func expandNested(ctx context.Context, installer *pkg.Installer, deps []spec.Dependency, expand func(spec.Dependency, []spec.Dependency) bool) ([]spec.Dependency, error) {
+	if expand == nil {
+		return deps, nil
+	}
+
+	expanded := []spec.Dependency{}
+	for _, dep := range deps {
+		nested, err := installer.NestedPackages(ctx, dep)
+		if err != nil {
+			return nil, err
+		}
+		if len(nested) == 0 || !expand(dep, nested) {
+			expanded = append(expanded, dep)
+			continue
+		}
+		expanded = append(expanded, nested...)
+	}
+	return expanded, nil
+}
+
+// warnOutOfSync warns if the jsonnetfile in dir has dependencies that
+// lock, which was installed instead, does not describe.
+func warnOutOfSync(dir string, lock spec.JsonnetFile) {
+	m, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
+	if err != nil {
+		return
+	}
+	if err := pkg.CheckLock(m, lock); err != nil {
+		color.Yellow(">>> %v\n", err)
+		color.Yellow(">>> Installed the locked versions, run jb update to apply the changes of %s\n", jsonnetfile.File)
+	}
+}
+
+// Outdated returns the dependencies of the project whose remotes have
+// newer versions than the locked ones, like jb outdated. Nothing is
+// installed or written.
+func Outdated(ctx context.Context, opts Options) ([]pkg.Outdated, error) {
+	p, err := Load(opts.dir())
+	if err != nil {
+		return nil, err
+	}
+	if p.Lock == nil {
+		return nil, fmt.Errorf("no %s in %s", jsonnetfile.LockFile, p.Dir)
+	}
+
+	return opts.installer().Outdated(ctx, p.Jsonnetfile, *p.Lock)
+}
+
+// addAndInstall adds deps to the jsonnetfile in dir and installs them,
+// keeping all other dependencies at their locked versions. Both the
+// jsonnetfile and the lock file are written.
+func addAndInstall(ctx context.Context, dir string, installer *pkg.Installer, deps []spec.Dependency) (*spec.JsonnetFile, error) {
+	filename := filepath.Join(dir, jsonnetfile.File)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil, &NoJsonnetfileError{Dir: dir}
+	}
+	m, err := jsonnetfile.Load(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	if installer.QualifiedNames {
+		deps = pkg.QualifyNames(deps)
+	}
+	for _, dep := range deps {
+		m.Dependencies, err = addDependency(m.Dependencies, dep, installer.Disambiguate)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Renamed dependencies are written back to the jsonnetfile so that they
+	// keep their names on subsequent installs.
+	if installer.Disambiguate {
+		m.Dependencies = installer.DisambiguateNames(m.Dependencies)
+	}
+
+	// The added dependencies are looked up again, they may have been
+	// renamed.
+	names := []string{}
+	for _, d := range m.Dependencies {
+		for _, dep := range deps {
+			if pkg.SameSource(d.Source, dep.Source) && d.Version == dep.Version {
+				names = append(names, d.Name)
+				break
+			}
+		}
+	}
+
+	lock, err := installer.Update(ctx, filename, m, names...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to install")
+	}
+	if installer.DryRun {
+		return lock, nil
+	}
+
+	if err := jsonnetfile.Write(filename, m); err != nil {
+		return nil, errors.Wrap(err, "failed to write jsonnet file")
+	}
+	if err := writeLock(filepath.Join(dir, jsonnetfile.LockFile), *lock); err != nil {
+		return nil, errors.Wrap(err, "failed to write lock file")
+	}
+
+	return lock, nil
+}
+
+// installSingle vendors deps into the vendor directory of the project in
+// dir and records them as unmanaged, leaving the jsonnetfile and the lock
+// file alone. Nothing is pruned, the vendored packages of the project stay.
+// The returned lock describes the packages of deps.
+func installSingle(ctx context.Context, dir string, installer *pkg.Installer, deps []spec.Dependency) (*spec.JsonnetFile, error) {
+	if err := migrateNames(dir, installer, false); err != nil {
+		return nil, err
+	}
+	if installer.QualifiedNames {
+		deps = pkg.QualifyNames(deps)
+	}
+	installer.Prune = false
+
+	lock, err := installer.Install(ctx, filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: deps})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to install")
+	}
+	if installer.DryRun {
+		return lock, nil
+	}
+
+	// Dependencies of the packages that the project has locked at the same
+	// version are still managed.
+	project, err := pkg.LoadJsonnetfile(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to load lock file")
+	}
+	unmanaged := []spec.Dependency{}
+	for _, d := range lock.Dependencies {
+		if !isLocked(project, d) {
+			unmanaged = append(unmanaged, d)
+		}
+	}
+	if err := pkg.MarkUnmanaged(installer.JsonnetHome, unmanaged); err != nil {
+		return nil, err
+	}
+	return lock, nil
+}
+
+// isLocked reports whether lock has dep at the same version.
+func isLocked(lock spec.JsonnetFile, dep spec.Dependency) bool {
+	for _, d := range lock.Dependencies {
+		if d.Name == dep.Name && d.Version == dep.Version && pkg.SameSource(d.Source, dep.Source) {
+			return true
+		}
+	}
+	return false
+}
+
+// installFrozen installs exactly what the lock file in dir describes.
+func installFrozen(ctx context.Context, dir string, installer *pkg.Installer) (*spec.JsonnetFile, error) {
+	m, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.File))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "a frozen install requires a lock file")
+	}
+
+	if err := pkg.CheckLock(m, lock); err != nil {
+		return nil, err
+	}
+	return installLock(ctx, dir, installer, lock)
+}
+
+// Sync makes the vendor directory of the project match its lock file, like
+// jb sync: the locked packages are installed at their locked versions,
+// stripped like the lock, and all others are pruned. Nothing is resolved
+// and neither file is written, changes to the jsonnetfile take effect
+// through Update.
+func Sync(ctx context.Context, opts Options) (*spec.JsonnetFile, error) {
+	dir := opts.dir()
+	installer := opts.installer()
+	installer.Prune = true
+
+	if err := migrateNames(dir, installer, false); err != nil {
+		return nil, err
+	}
+	lock, err := jsonnetfile.Load(filepath.Join(dir, jsonnetfile.LockFile))
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, fmt.Errorf("no %s in %s, run jb install or jb update to create it", jsonnetfile.LockFile, dir)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load lock file")
+	}
+
+	installed, err := installLock(ctx, dir, installer, lock)
+	if err != nil {
+		return nil, err
+	}
+	warnOutOfSync(dir, lock)
+	return installed, nil
+}
+
+// installLock installs lock, the lock file in dir, stripped like it, unless
+// the vendor directory was installed from the same files already, see
+// pkg.HashFile.
+func installLock(ctx context.Context, dir string, installer *pkg.Installer, lock spec.JsonnetFile) (*spec.JsonnetFile, error) {
+	lockFilename := filepath.Join(dir, jsonnetfile.LockFile)
+	// The hash covers the options given, like for the installs that do not
+	// strip like the lock.
+	hash, err := installer.VendorHash(filepath.Join(dir, jsonnetfile.File), lockFilename)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to hash the jsonnetfile")
+	}
+	stripLike(installer, lock)
+	if !installer.DryRun && installer.UpToDate(hash, lock) {
+		color.Green(">>> %s is up to date with %s\n", filepath.Base(installer.JsonnetHome), jsonnetfile.LockFile)
+		return &lock, nil
+	}
+
+	installed, err := installer.Install(ctx, lockFilename, lock)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to install")
+	}
+	if installer.DryRun {
+		return installed, nil
+	}
+	return installed, installer.WriteVendorHash(hash)
+}
+
+// installRepair reinstalls the packages of the lock file in dir that are
+// missing from the vendor directory or were modified, at their locked
+// versions and stripped like the lock, and returns the lock. Packages that
+// are not in the lock file are left for Prune.
+func installRepair(ctx context.Context, dir string, installer *pkg.Installer) (*spec.JsonnetFile, error) {
+	lockFilename := filepath.Join(dir, jsonnetfile.LockFile)
+	lock, err := jsonnetfile.Load(lockFilename)
+	if err != nil {
+		return nil, errors.Wrap(err, "repairing the vendor directory requires a lock file")
+	}
+
+	err = pkg.Verify(installer.JsonnetHome, lock)
+	mismatch, ok := err.(*pkg.VendorMismatchError)
+	switch {
+	case err != nil && !ok:
+		return nil, errors.Wrap(err, "failed to verify the vendor directory")
+	case !ok || len(mismatch.Broken) == 0:
+		color.Green(">>> All packages match %s, nothing to repair\n", jsonnetfile.LockFile)
+		return &lock, nil
+	}
+
+	broken := map[string]bool{}
+	for _, name := range mismatch.Broken {
+		broken[name] = true
+	}
+	repair := lock
+	repair.Dependencies = []spec.Dependency{}
+	for _, d := range lock.Dependencies {
+		if broken[d.Name] {
+			color.Yellow(">>> Repairing %s\n", d.Name)
+			repair.Dependencies = append(repair.Dependencies, d)
+		}
+	}
+
+	// The packages that are fine are not in the partial lock, they must
+	// not be pruned.
+	installer.Prune = false
+	stripLike(installer, lock)
+	if _, err := installer.Install(ctx, lockFilename, repair); err != nil {
+		return nil, errors.Wrap(err, "failed to repair")
+	}
+	return &lock, nil
+}
+
+// stripLike has installer strip the files lock was stripped of, whatever is
+// configured, so that a frozen install vendors exactly what it describes.
+// Locks without Strip were vendored without stripping anything.
+func stripLike(installer *pkg.Installer, lock spec.JsonnetFile) {
+	installer.Strip = append([]string{}, lock.Strip...)
+	installer.StripDocs = false
+}
+
+// UpdateOptions configure Update.
+type UpdateOptions struct {
+	Options
+
+	// Packages are the names, or package references, of the dependencies
+	// to update. All others keep their locked version. Every dependency is
+	// updated if there are none. Packages followed by @latest are updated
+	// to their newest release and track it from then on, their version in
+	// the jsonnetfile is set to semver.Latest.
+	Packages []string
+
+	// Since restricts the update to dependencies with upstream commits
+	// newer than the given time, see pkg.Installer.
+	Since time.Time
+
+	// AsOf resolves the dependencies on branches to their last commit
+	// before the given time instead of their head, see pkg.Installer.
+	AsOf time.Time
+
+	// NoLockWrite vendors the dependencies without writing the lock file,
+	// failing with a LockDivergedError, before the vendor directory is
+	// replaced, if it does not describe exactly what would be vendored.
+	NoLockWrite bool
+
+	// Target resolves the dependencies of this target of the jsonnetfile
+	// again, instead of those of the project, see InstallOptions.Target.
+	// All of them are updated.
+	Target string
+
+	// IncludeFrozen updates the frozen dependencies as well, which are
+	// kept at their locked version otherwise, see pkg.Installer.
+	IncludeFrozen bool
+}
+
+// Update resolves the dependencies of the project again, like jb update,
+// writes the lock file and returns it.
+func Update(ctx context.Context, opts UpdateOptions) (*spec.JsonnetFile, error) {
+	dir := opts.dir()
+	installer := opts.installer()
+	installer.Since = opts.Since
+	installer.AsOf = opts.AsOf
+	installer.IncludeFrozen = opts.IncludeFrozen
+
+	if opts.Target != "" {
+		if len(opts.Packages) > 0 || !opts.Since.IsZero() || opts.NoLockWrite || opts.Workspace {
+			return nil, errors.New("a target can only be updated as a whole")
+		}
+		return installTarget(ctx, dir, installer, opts.Target, false, true)
+	}
+
+	filename := filepath.Join(dir, jsonnetfile.File)
+	lockFilename := filepath.Join(dir, jsonnetfile.LockFile)
+
+	var m spec.JsonnetFile
+	var err error
+	if opts.Workspace {
+		m, err = workspaceJsonnetfile(dir, installer.JsonnetHome)
+		if err != nil {
+			return nil, err
+		}
+		installer.QualifiedNames = m.Version >= spec.QualifiedVersion
+	} else {
+		if err := migrateNames(dir, installer, !opts.NoLockWrite && !opts.DryRun); err != nil {
+			return nil, err
+		}
+		m, err = pkg.LoadJsonnetfile(filename)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load jsonnetfile")
+		}
+	}
+
+	// Renamed dependencies are written back to the jsonnetfile so that they
+	// keep their names on subsequent installs, like on install.
+	renamed := false
+	if installer.Disambiguate && !opts.Workspace {
+		deps := installer.DisambiguateNames(m.Dependencies)
+		for i := range deps {
+			renamed = renamed || deps[i].Name != m.Dependencies[i].Name
+		}
+		m.Dependencies = deps
+	}
+
+	// Load the committed lock file before anything is installed, so a
+	// missing lock fails fast instead of after all packages were fetched.
+	// The resolved lock is checked against it before the vendor directory
+	// is replaced, which stays as it was if they diverge.
+	if opts.NoLockWrite {
+		committed, err := pkg.LoadJsonnetfile(lockFilename)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to load lock file to validate against")
+		}
+		installer.CheckLock = func(lock spec.JsonnetFile) error {
+			if diff := lockDiff(committed, lock); len(diff) > 0 {
+				return &LockDivergedError{Diff: diff}
+			}
+			return nil
+		}
+	}
+
+	names := make([]string, 0, len(opts.Packages))
+	toLatest := false
+	for _, p := range opts.Packages {
+		p, latest := cutLatest(p)
+		if name := DependencyName(m, p); name != "" {
+			p = name
+		}
+		if latest {
+			if opts.Workspace {
+				return nil, fmt.Errorf("%s@%s cannot be updated in a workspace, change the version in the jsonnetfile of its member", p, semver.Latest)
+			}
+			if !setVersion(m.Dependencies, p, semver.Latest) {
+				return nil, fmt.Errorf("package %s is not a dependency in %s", p, filename)
+			}
+			toLatest = true
+		}
+		names = append(names, p)
+	}
+
+	lock, err := installer.Update(ctx, filename, m, names...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to install")
+	}
+
+	// The lock file is not written, it was checked to describe exactly what
+	// was just vendored.
+	if opts.NoLockWrite || opts.DryRun {
+		return lock, nil
+	}
+
+	if toLatest || renamed {
+		if err := jsonnetfile.Write(filename, m); err != nil {
+			return nil, errors.Wrap(err, "failed to write jsonnet file")
+		}
+	}
+	if err := writeLock(lockFilename, *lock); err != nil {
+		return nil, errors.Wrap(err, "failed to write lock file")
+	}
+	return lock, nil
+}
+
+// cutLatest returns the package p without a trailing @latest, and whether
+// it had one.
+func cutLatest(p string) (string, bool) {
+	if strings.HasSuffix(p, "@"+semver.Latest) {
+		return strings.TrimSuffix(p, "@"+semver.Latest), true
+	}
+	return p, false
+}
+
+// setVersion sets the version of the dependency name of deps, reporting
+// whether there is one.
+func setVersion(deps []spec.Dependency, name, version string) bool {
+	for i := range deps {
+		if deps[i].Name == name {
+			deps[i].Version = version
+			return true
+		}
+	}
+	return false
+}
+
+// migrateNames vendors git dependencies under their qualified names if
+// the jsonnetfile in dir is of spec.QualifiedVersion. With write, the
+// dependencies of the jsonnetfile and the lock file that still have the
+// short names of the legacy layout are renamed in place, which migrates a
+// project to the qualified layout once its version is raised.
+func migrateNames(dir string, installer *pkg.Installer, write bool) error {
+	filename := filepath.Join(dir, jsonnetfile.File)
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return nil
+	}
+	m, err := jsonnetfile.Load(filename)
+	if err != nil {
+		return errors.Wrap(err, "failed to load jsonnetfile")
+	}
+	if m.Version < spec.QualifiedVersion {
+		return nil
+	}
+	installer.QualifiedNames = true
+	if !write {
+		return nil
+	}
+
+	changed, err := upgradeFiles(dir, m.Version)
+	for _, name := range changed {
+		color.Yellow(">>> Migrated %s to the qualified layout\n", name)
+	}
+	return err
+}
+
+// Migrate upgrades the jsonnetfile and the lock file of the project in dir
+// to spec.LatestVersion in place, like jb migrate, and returns the names of
+// the files that changed. Git dependencies are renamed to their qualified
+// names, see pkg.QualifyNames. The vendor directory is migrated by the next
+// Install.
+func Migrate(dir string) ([]string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if _, err := os.Stat(filepath.Join(dir, jsonnetfile.File)); os.IsNotExist(err) {
+		return nil, &NoJsonnetfileError{Dir: dir}
+	}
+
+	return upgradeFiles(dir, spec.LatestVersion)
+}
+
+// upgradeFiles raises the jsonnetfile and the lock file in dir to at least
+// version, which must be spec.QualifiedVersion or later, and qualifies the
+// names of their dependencies. It returns the names of the files written.
+func upgradeFiles(dir string, version int) ([]string, error) {
+	changed := []string{}
+	for _, name := range []string{jsonnetfile.File, jsonnetfile.LockFile} {
+		filename := filepath.Join(dir, name)
+		if _, err := os.Stat(filename); os.IsNotExist(err) {
+			continue
+		}
+		f, err := jsonnetfile.Load(filename)
+		if err != nil {
+			return changed, errors.Wrapf(err, "failed to load %s", name)
+		}
+
+		upgrade := f.Version < version
+		qualified := pkg.QualifyNames(f.Dependencies)
+		for n := range qualified {
+			upgrade = upgrade || qualified[n].Name != f.Dependencies[n].Name
+		}
+		if !upgrade {
+			continue
+		}
+
+		if f.Version < version {
+			f.Version = version
+		}
+		if f.Dependencies != nil {
+			f.Dependencies = qualified
+		}
+		if err := jsonnetfile.Write(filename, f); err != nil {
+			return changed, errors.Wrapf(err, "failed to write %s", name)
+		}
+		changed = append(changed, name)
+	}
+	return changed, nil
+}
+
+// addDependency adds dep to deps, replacing the dependency of the same name
+// if it has the same source. Another dependency of the same name is a
+// collision, unless both are kept to be disambiguated.
+func addDependency(deps []spec.Dependency, dep spec.Dependency, disambiguate bool) ([]spec.Dependency, error) {
+	res := make([]spec.Dependency, 0, len(deps)+1)
+	replaced := false
+	for _, d := range deps {
+		switch {
+		case d.Name != dep.Name:
+			res = append(res, d)
+		case pkg.SameSource(d.Source, dep.Source):
+			res = append(res, keepAnnotations(dep, d))
+			replaced = true
+		case disambiguate:
+			res = append(res, d)
+		default:
+			return nil, &pkg.NameCollisionError{Name: dep.Name, First: pkg.SourceString(d.Source), Second: pkg.SourceString(dep.Source)}
+		}
+	}
+
+	if !replaced {
+		res = append(res, dep)
+	}
+	return res, nil
+}
+
+// keepAnnotations returns dep with the comment, owner and link of the
+// dependency it replaces, unless it has its own, and frozen if it was.
+func keepAnnotations(dep, replaced spec.Dependency) spec.Dependency {
+	dep.Frozen = dep.Frozen || replaced.Frozen
+	if dep.Comment == "" {
+		dep.Comment = replaced.Comment
+	}
+	if dep.Owner == "" {
+		dep.Owner = replaced.Owner
+	}
+	if dep.Link == "" {
+		dep.Link = replaced.Link
+	}
+	return dep
+}
+
+// ChangeKind tells how the version of a dependency changed, see Change.
+type ChangeKind string
+
+const (
+	ChangeAdded   ChangeKind = "added"
+	ChangeRemoved ChangeKind = "removed"
+	// ChangeUpgraded and ChangeDowngraded are changes between tags that are
+	// semantic versions.
+	ChangeUpgraded   ChangeKind = "upgraded"
+	ChangeDowngraded ChangeKind = "downgraded"
+	// ChangeChanged is any other change, like to another commit of a branch
+	// or to another source.
+	ChangeChanged ChangeKind = "changed"
+)
+
+// Change is a dependency whose locked version differs between two locks,
+// see Changes.
+type Change struct {
+	Name string
+	Kind ChangeKind
+	// Locked is the version of the current lock and LockedTag its tag.
+	// Both are empty for added dependencies.
+	Locked    string
+	LockedTag string
+	// Version is the version of the new lock and Tag its tag. Both are
+	// empty for removed dependencies.
+	Version string
+	Tag     string
+}
+
+// Changes returns the dependencies of resolved whose version or source
+// differs from committed, followed by those of committed that resolved no
+// longer has, for reviewing what a DryRun would change or what an install
+// changed. Installing again what is locked changes nothing.
+func Changes(committed, resolved spec.JsonnetFile) []Change {
+	old := make(map[string]spec.Dependency, len(committed.Dependencies))
+	for _, d := range committed.Dependencies {
+		old[d.Name] = d
+	}
+
+	changes := []Change{}
+	seen := map[string]bool{}
+	for _, d := range resolved.Dependencies {
+		seen[d.Name] = true
+		o, ok := old[d.Name]
+		if ok && o.Version == d.Version && pkg.SameSource(o.Source, d.Source) {
+			continue
+		}
+		kind := ChangeAdded
+		if ok {
+			kind = changeKind(o, d)
+		}
+		changes = append(changes, Change{Name: d.Name, Kind: kind, Locked: o.Version, LockedTag: o.Tag, Version: d.Version, Tag: d.Tag})
+	}
+	for _, d := range committed.Dependencies {
+		if !seen[d.Name] {
+			changes = append(changes, Change{Name: d.Name, Kind: ChangeRemoved, Locked: d.Version, LockedTag: d.Tag})
+		}
+	}
+
+	return changes
+}
+
+// changeKind tells how the dependency locked as o changed to d.
+func changeKind(o, d spec.Dependency) ChangeKind {
+	if !pkg.SameSource(o.Source, d.Source) {
+		return ChangeChanged
+	}
+	from, err := semver.Parse(o.Tag)
+	if err != nil {
+		return ChangeChanged
+	}
+	to, err := semver.Parse(d.Tag)
+	if err != nil {
+		return ChangeChanged
+	}
+	switch c := semver.Compare(from, to); {
+	case c < 0:
+		return ChangeUpgraded
+	case c > 0:
+		return ChangeDowngraded
+	}
+	return ChangeChanged
+}
+
+// lockDiff returns a human readable line for every dependency that differs
+// between the committed and the resolved lock. Only the persisted fields are
+// compared.
+func lockDiff(committed, resolved spec.JsonnetFile) []string {
+	old := make(map[string]spec.Dependency, len(committed.Dependencies))
+	for _, d := range committed.Dependencies {
+		old[d.Name] = d
+	}
+
+	diff := []string{}
+	seen := map[string]bool{}
+	for _, d := range resolved.Dependencies {
+		seen[d.Name] = true
+		o, ok := old[d.Name]
+		if !ok {
+			diff = append(diff, fmt.Sprintf("+ %s %s", d.Name, d.Version))
+			continue
+		}
+		if o.Version != d.Version || !pkg.SameSource(o.Source, d.Source) || !sameFlatten(o.Flatten, d.Flatten) || (o.Sum != "" && o.Sum != d.Sum) {
+			diff = append(diff, fmt.Sprintf("~ %s %s -> %s", d.Name, o.Version, d.Version))
+		}
+	}
+	for _, d := range committed.Dependencies {
+		if !seen[d.Name] {
+			diff = append(diff, fmt.Sprintf("- %s %s", d.Name, d.Version))
+		}
+	}
+
+	return diff
+}
+
+// sameFlatten treats an unrecorded layout as flattened, as older locks only
+// recorded preserved subdirs.
+func sameFlatten(a, b *bool) bool {
+	return (a == nil || *a) == (b == nil || *b)
+}
@@ -0,0 +1,246 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LegacyLayoutDir is where the first releases of jb, then jsonnetpkg, kept
+// the packages of a project, relative to its directory.
+var LegacyLayoutDir = filepath.Join(".jsonnetpkg", "src")
+
+// HasLegacyLayout reports whether the project in dir still holds packages in
+// LegacyLayoutDir, see MigrateLayout.
+func HasLegacyLayout(dir string) bool {
+	if dir == "" {
+		dir = "."
+	}
+	info, err := os.Stat(filepath.Join(dir, LegacyLayoutDir))
+	return err == nil && info.IsDir()
+}
+
+// MigrateLayoutOptions configure MigrateLayout.
+type MigrateLayoutOptions struct {
+	Options
+}
+
+// LayoutMigration describes the changes of MigrateLayout, with paths
+// relative to the project directory.
+type LayoutMigration struct {
+	// Moved maps the packages moved into the vendor directory to the
+	// directories of the legacy layout they were moved from.
+	Moved map[string]string
+	// Relinked holds the symlinks that pointed into the legacy layout and
+	// now point into the vendor directory.
+	Relinked []string
+}
+
+// move is a directory of the legacy layout and where it is moved to.
+type move struct {
+	from, to string
+}
+
+// MigrateLayout moves the packages of the legacy layout of the project, see
+// LegacyLayoutDir, into its vendor directory and removes the legacy tree.
+// A symlink of the vendor directory into the legacy tree is replaced by the
+// directory it points to, other top-level directories of the tree are moved
+// to the vendor directory under their name, and symlinks of the project
+// into the tree, like the import links of the vendor directory, are
+// relinked to where their targets were moved. Nothing changes if a package
+// would replace an existing file of the vendor directory, or if DryRun is
+// set. The returned migration is nil if there is no legacy layout.
+func MigrateLayout(opts MigrateLayoutOptions) (*LayoutMigration, error) {
+	dir, err := filepath.Abs(opts.dir())
+	if err != nil {
+		return nil, err
+	}
+	if !HasLegacyLayout(dir) {
+		return nil, nil
+	}
+	legacy := filepath.Join(dir, LegacyLayoutDir)
+	home := opts.installer().JsonnetHome
+	if home, err = filepath.Abs(home); err != nil {
+		return nil, err
+	}
+
+	links, err := legacyLinks(dir, legacy)
+	if err != nil {
+		return nil, err
+	}
+
+	// The links of the vendor directory become the packages they point to,
+	// the shortest targets first so that nested ones are relinked below
+	// the moved directory instead of being moved out of it.
+	targets := make([]string, 0, len(links))
+	for link := range links {
+		targets = append(targets, link)
+	}
+	sort.Slice(targets, func(a, b int) bool {
+		return len(links[targets[a]]) < len(links[targets[b]]) || (len(links[targets[a]]) == len(links[targets[b]]) && targets[a] < targets[b])
+	})
+	moves := []move{}
+	for _, link := range targets {
+		if within(link, home) && !within(link, legacy) && movedTo(moves, links[link]) == "" && !containsMove(moves, links[link]) {
+			moves = append(moves, move{from: links[link], to: link})
+		}
+	}
+
+	// The remaining directories of the tree move under their own name.
+	entries, err := ioutil.ReadDir(legacy)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		from := filepath.Join(legacy, e.Name())
+		if movedTo(moves, from) != "" || containsMove(moves, from) {
+			continue
+		}
+		to := filepath.Join(home, e.Name())
+		if _, err := os.Lstat(to); err == nil {
+			return nil, fmt.Errorf("cannot move %s to %s, which already exists", rel(dir, from), rel(dir, to))
+		}
+		moves = append(moves, move{from: from, to: to})
+	}
+
+	migration := &LayoutMigration{Moved: map[string]string{}, Relinked: []string{}}
+	relinks := map[string]string{}
+	for link, target := range links {
+		if isMoveTarget(moves, link) {
+			continue
+		}
+		to := movedTo(moves, target)
+		if to == "" {
+			return nil, fmt.Errorf("cannot relink %s, %s is not moved to the vendor directory", rel(dir, link), rel(dir, target))
+		}
+		relinks[link] = to
+		migration.Relinked = append(migration.Relinked, rel(dir, link))
+	}
+	sort.Strings(migration.Relinked)
+	for _, m := range moves {
+		migration.Moved[rel(dir, m.to)] = rel(dir, m.from)
+	}
+	if opts.DryRun {
+		return migration, nil
+	}
+
+	for _, m := range moves {
+		if err := os.RemoveAll(m.to); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(m.to), os.ModePerm); err != nil {
+			return nil, err
+		}
+		if err := os.Rename(m.from, m.to); err != nil {
+			return nil, errors.Wrapf(err, "failed to move %s", rel(dir, m.from))
+		}
+	}
+	for link, to := range relinks {
+		target, err := filepath.Rel(filepath.Dir(link), to)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Remove(link); err != nil {
+			return nil, err
+		}
+		if err := os.Symlink(target, link); err != nil {
+			return nil, errors.Wrapf(err, "failed to relink %s", rel(dir, link))
+		}
+	}
+	if err := os.RemoveAll(filepath.Dir(legacy)); err != nil {
+		return nil, errors.Wrap(err, "failed to remove the legacy layout")
+	}
+	return migration, nil
+}
+
+// legacyLinks returns the symlinks of the project in dir that point into
+// legacy, by the absolute paths they point to. The .git directory and the
+// legacy tree itself are not searched.
+func legacyLinks(dir, legacy string) (map[string]string, error) {
+	links := map[string]string{}
+	base := filepath.Dir(legacy)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && (path == base || info.Name() == ".git") {
+			return filepath.SkipDir
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		if target = filepath.Clean(target); within(target, legacy) && target != legacy {
+			links[path] = target
+		}
+		return nil
+	})
+	return links, errors.Wrap(err, "failed to find the links into the legacy layout")
+}
+
+// movedTo returns where path is after moves, or an empty string if it is
+// not moved.
+func movedTo(moves []move, path string) string {
+	for _, m := range moves {
+		if within(path, m.from) {
+			return filepath.Join(m.to, strings.TrimPrefix(path, m.from))
+		}
+	}
+	return ""
+}
+
+// containsMove reports whether one of moves is from below path.
+func containsMove(moves []move, path string) bool {
+	for _, m := range moves {
+		if within(m.from, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func isMoveTarget(moves []move, path string) bool {
+	for _, m := range moves {
+		if m.to == path {
+			return true
+		}
+	}
+	return false
+}
+
+// within reports whether path is dir or below it.
+func within(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func rel(dir, path string) string {
+	if r, err := filepath.Rel(dir, path); err == nil {
+		return filepath.ToSlash(r)
+	}
+	return path
+}
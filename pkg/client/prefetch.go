@@ -0,0 +1,83 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// PrefetchOptions configure Prefetch.
+type PrefetchOptions struct {
+	Options
+}
+
+// Prefetch fetches the locked dependencies of the project into the cache
+// and the store, so that installing the lock file later needs no network,
+// for example in the cache warm-up step of a CI pipeline or a layer of a
+// container image. The packages are installed into a temporary directory
+// and checked against the digests of the lock, the vendor directory is not
+// touched and no hooks run. Local dependencies, which are not cached, are
+// skipped.
+// The returned lock holds the dependencies that were fetched.
+func Prefetch(ctx context.Context, opts PrefetchOptions) (*spec.JsonnetFile, error) {
+	dir := opts.dir()
+	lockFilename := filepath.Join(dir, jsonnetfile.LockFile)
+	lock, err := jsonnetfile.Load(lockFilename)
+	if err != nil {
+		return nil, errors.Wrap(err, "prefetching requires a lock file")
+	}
+
+	installer := opts.installer()
+	if installer.CacheDir == "" {
+		return nil, errors.New("prefetching requires a cache directory")
+	}
+
+	// The Kubernetes libraries and the hooks are generated into the vendor
+	// directory, not fetched.
+	m := lock
+	m.Kubernetes = nil
+	m.Hooks = nil
+	m.Dependencies = []spec.Dependency{}
+	for _, d := range lock.Dependencies {
+		if d.Source.LocalSource == nil {
+			m.Dependencies = append(m.Dependencies, d)
+		}
+	}
+
+	tmpDir, err := ioutil.TempDir("", "jb-prefetch")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	installer.JsonnetHome = filepath.Join(tmpDir, "vendor")
+	installer.Prune = false
+	installer.AllowHooks = false
+	installer.Reproducible = false
+	installer.DryRun = false
+	stripLike(installer, lock)
+	fetched, err := installer.Install(ctx, lockFilename, m)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to prefetch packages")
+	}
+	return fetched, nil
+}
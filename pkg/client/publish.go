@@ -0,0 +1,280 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/imports"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/parser"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/pkg/errors"
+)
+
+// PublishOptions configure Publish.
+type PublishOptions struct {
+	// Dir is the git repository of the package, the current directory if
+	// empty.
+	Dir string
+	// JsonnetHome is the vendor directory imports are resolved against,
+	// relative to Dir unless absolute. It defaults to vendor.
+	JsonnetHome string
+
+	// Version is the tag to create. If empty, the latest semver tag of the
+	// repository is bumped by Bump, one of major, minor or patch.
+	Version string
+	Bump    string
+
+	// Sign creates a GPG-signed tag, see spec.Dependency.Verify.
+	Sign bool
+	// Push pushes the tag to Remote, origin if empty.
+	Push   bool
+	Remote string
+
+	// OCI is a repository of a container registry, like
+	// oci://ghcr.io/org/lib, the package is pushed to as well, as an OCI
+	// artifact with the files of the tag and tagged the same.
+	OCI string
+	// CAFile holds the certificate authorities trusted for pushing to
+	// OCI, see pkg.CAFileEnv.
+	CAFile string
+
+	// DryRun validates the package and determines the tag without
+	// creating it.
+	DryRun bool
+}
+
+// InvalidPackageError is returned by Publish for packages that would not
+// install or import cleanly for their consumers.
+type InvalidPackageError struct {
+	// Reasons holds one line per problem.
+	Reasons []string
+}
+
+func (e *InvalidPackageError) Error() string {
+	return fmt.Sprintf("the package cannot be published: %s", strings.Join(e.Reasons, ", "))
+}
+
+// Publish releases the package in opts.Dir, like jb publish: it checks that
+// the package has a jsonnetfile, that all its imports resolve, none of them
+// is absolute or leaves the package, and that the working tree is clean,
+// returning an InvalidPackageError otherwise. It then tags HEAD with the
+// next semantic version, pushes the tag if opts.Push and the package to
+// opts.OCI if set. The returned tag is the one created.
+func Publish(ctx context.Context, opts PublishOptions) (string, error) {
+	dir := opts.Dir
+	if dir == "" {
+		dir = "."
+	}
+	repository := strings.TrimPrefix(opts.OCI, "oci://")
+	if opts.OCI != "" {
+		dep, err := parser.Parse("oci://"+repository, "")
+		if err != nil || dep.Version != "latest" {
+			return "", fmt.Errorf("invalid OCI repository %s, expected oci://<registry>/<repository> without a tag", opts.OCI)
+		}
+	}
+
+	reasons, err := checkPackage(dir, opts.JsonnetHome)
+	if err != nil {
+		return "", err
+	}
+
+	status, err := gitOutput(ctx, dir, "status", "--porcelain")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get the status of the working tree")
+	}
+	if status != "" {
+		reasons = append(reasons, "the working tree has uncommitted changes")
+	}
+	if len(reasons) > 0 {
+		return "", &InvalidPackageError{Reasons: reasons}
+	}
+
+	tag, err := nextTag(ctx, dir, opts.Version, opts.Bump)
+	if err != nil {
+		return "", err
+	}
+	if opts.DryRun {
+		return tag, nil
+	}
+
+	args := []string{"tag", "-a", "-m", "Release " + tag, tag}
+	if opts.Sign {
+		args[1] = "-s"
+	}
+	if _, err := gitOutput(ctx, dir, args...); err != nil {
+		return "", errors.Wrapf(err, "failed to create tag %s", tag)
+	}
+
+	if opts.Push {
+		remote := opts.Remote
+		if remote == "" {
+			remote = "origin"
+		}
+		if _, err := gitOutput(ctx, dir, "push", remote, "refs/tags/"+tag); err != nil {
+			return "", errors.Wrapf(err, "failed to push tag %s to %s", tag, remote)
+		}
+	}
+
+	if opts.OCI != "" {
+		tarball, err := gitOutput(ctx, dir, "archive", "--format=tar", tag)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to archive tag %s", tag)
+		}
+		var archive bytes.Buffer
+		gz := gzip.NewWriter(&archive)
+		if _, err := gz.Write([]byte(tarball)); err != nil {
+			return "", err
+		}
+		if err := gz.Close(); err != nil {
+			return "", err
+		}
+		if _, err := pkg.PushOCI(ctx, opts.CAFile, repository, tag, archive.Bytes()); err != nil {
+			return "", errors.Wrapf(err, "failed to push %s to %s", tag, opts.OCI)
+		}
+	}
+
+	return tag, nil
+}
+
+// checkPackage returns the reasons the package in dir cannot be consumed:
+// a missing jsonnetfile and imports that are absolute, leave dir or do not
+// resolve against the vendor directory jsonnetHome.
+func checkPackage(dir, jsonnetHome string) ([]string, error) {
+	reasons := []string{}
+	if _, err := Load(dir); err != nil {
+		if _, ok := errors.Cause(err).(*NoJsonnetfileError); !ok {
+			return nil, err
+		}
+		reasons = append(reasons, "there is no jsonnetfile")
+	}
+
+	if jsonnetHome == "" {
+		jsonnetHome = "vendor"
+	}
+	if !filepath.IsAbs(jsonnetHome) {
+		jsonnetHome = filepath.Join(dir, jsonnetHome)
+	}
+
+	own, err := imports.Scan(dir, filepath.Base(jsonnetHome))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to scan %s for imports", dir)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, imp := range own {
+		at := fmt.Sprintf("%s:%d", imp.File, imp.Line)
+		switch {
+		case filepath.IsAbs(imp.Path):
+			reasons = append(reasons, fmt.Sprintf("%s: absolute import %s", at, imp.Path))
+		case !imports.Resolves(imp, []string{jsonnetHome}):
+			reasons = append(reasons, fmt.Sprintf("%s: %s does not resolve", at, imp.Path))
+		case leaves(abs, imp):
+			reasons = append(reasons, fmt.Sprintf("%s: %s leaves the package", at, imp.Path))
+		}
+	}
+
+	return reasons, nil
+}
+
+// leaves reports whether imp resolves relative to the importing file, to a
+// file outside of dir.
+func leaves(dir string, imp imports.Import) bool {
+	p, err := filepath.Abs(filepath.Join(filepath.Dir(imp.File), imp.Path))
+	if err != nil {
+		return false
+	}
+	if _, err := os.Stat(p); err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(dir, p)
+	return err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// nextTag returns version if set, and otherwise the latest semver tag of
+// the repository in dir bumped by part, with a v prefix unless the latest
+// tag has none. The tag must be newer than the latest one.
+func nextTag(ctx context.Context, dir, version, part string) (string, error) {
+	out, err := gitOutput(ctx, dir, "tag", "--list")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list tags")
+	}
+
+	latest := semver.Version{Original: "v0.0.0"}
+	tagged := false
+	tags := map[string]bool{}
+	for _, tag := range strings.Fields(out) {
+		tags[tag] = true
+		v, err := semver.Parse(tag)
+		if err != nil {
+			continue
+		}
+		if !tagged || semver.Compare(v, latest) > 0 {
+			latest, tagged = v, true
+		}
+	}
+
+	if version == "" {
+		v, err := latest.Bump(part)
+		if err != nil {
+			return "", err
+		}
+		version = v.String()
+		if strings.HasPrefix(latest.Original, "v") {
+			version = "v" + version
+		}
+	}
+
+	v, err := semver.Parse(version)
+	if err != nil {
+		return "", err
+	}
+	if tags[version] {
+		return "", fmt.Errorf("tag %s already exists", version)
+	}
+	if tagged && semver.Compare(v, latest) <= 0 {
+		return "", fmt.Errorf("version %s is not newer than the latest tag %s", version, latest.Original)
+	}
+	return version, nil
+}
+
+// gitOutput runs git in dir and returns its trimmed output. The last line
+// of the error output is added to the error if the command fails.
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+		if last := lines[len(lines)-1]; last != "" {
+			err = fmt.Errorf("%v: %s", err, last)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/parser"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// ResolveOptions configure how Resolve interprets package references.
+type ResolveOptions struct {
+	// DefaultVersion is the version of git packages referenced without
+	// one. When empty, the default branch of the remote is installed,
+	// whatever its name.
+	DefaultVersion string
+
+	// Name is the name to install the dependency as, instead of the last
+	// element of its path, so that several versions of a package, or
+	// packages of the same name, can be vendored side by side.
+	Name string
+}
+
+// UnknownPackageError is returned by Resolve for references that are not
+// valid, telling which part of them is wrong.
+type UnknownPackageError = parser.Error
+
+// Resolve returns the dependency the package reference ref stands for, the
+// way jb install takes it, see parser.Parse.
+func Resolve(ref string, opts ResolveOptions) (*spec.Dependency, error) {
+	dep, err := parser.Parse(ref, opts.DefaultVersion)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Name != "" {
+		dep.Name = opts.Name
+	}
+	return dep, nil
+}
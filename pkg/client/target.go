@@ -0,0 +1,141 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// TargetJsonnetfile returns m as installed for its target name: with the
+// dependencies of the target instead of those of the project of the same
+// name, the others following them, see spec.JsonnetFile.Targets.
+func TargetJsonnetfile(m spec.JsonnetFile, name string) (spec.JsonnetFile, error) {
+	t, ok := m.Targets[name]
+	if !ok {
+		return spec.JsonnetFile{}, fmt.Errorf("no target %s in %s", name, jsonnetfile.File)
+	}
+
+	deps := []spec.Dependency{}
+	for _, d := range m.Dependencies {
+		if !hasName(t.Dependencies, d.Name) {
+			deps = append(deps, d)
+		}
+	}
+	m.Dependencies = append(deps, t.Dependencies...)
+	m.Targets = nil
+	return m, nil
+}
+
+// TargetLock returns lock with the dependencies locked for its target name
+// instead of those of the project, none if the target is not locked.
+func TargetLock(lock spec.JsonnetFile, name string) spec.JsonnetFile {
+	deps := lock.Targets[name].Dependencies
+	if deps == nil {
+		deps = []spec.Dependency{}
+	}
+	lock.Dependencies = deps
+	lock.Targets = nil
+	return lock
+}
+
+// writeLock writes lock to the lock file filename, keeping the targets the
+// file records, see installTarget, which installing the dependencies of the
+// project does not lock.
+func writeLock(filename string, lock spec.JsonnetFile) error {
+	if lock.Targets == nil {
+		if previous, err := jsonnetfile.Load(filename); err == nil {
+			lock.Targets = previous.Targets
+		}
+	}
+	return jsonnetfile.Write(filename, lock)
+}
+
+func hasName(deps []spec.Dependency, name string) bool {
+	for _, d := range deps {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// installTarget installs the target name of the project in dir into its
+// vendor directory. A target the lock file records in sync with the
+// jsonnetfile is installed as locked, unless resolve is set. Otherwise it
+// is resolved and recorded in the lock file, next to the dependencies of
+// the project, which must have been locked before. Frozen installs fail
+// for targets that are not locked in sync.
+func installTarget(ctx context.Context, dir string, installer *pkg.Installer, name string, frozen, resolve bool) (*spec.JsonnetFile, error) {
+	p, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	if p.Lock == nil {
+		return nil, fmt.Errorf("installing a target requires %s, install the project first", jsonnetfile.LockFile)
+	}
+	m, err := TargetJsonnetfile(p.Jsonnetfile, name)
+	if err != nil {
+		return nil, err
+	}
+	if home := p.Jsonnetfile.Targets[name].JsonnetHome; home != "" {
+		if !filepath.IsAbs(home) {
+			home = filepath.Join(dir, home)
+		}
+		installer.JsonnetHome = home
+	}
+
+	lockFilename := filepath.Join(dir, jsonnetfile.LockFile)
+	locked := TargetLock(*p.Lock, name)
+	var outOfSync error = &pkg.LockOutOfSyncError{Reasons: []string{fmt.Sprintf("the target %s is not locked", name)}}
+	if _, ok := p.Lock.Targets[name]; ok {
+		outOfSync = pkg.CheckLock(m, locked)
+	}
+	if frozen && outOfSync != nil {
+		return nil, outOfSync
+	}
+	if outOfSync == nil && !resolve {
+		lock, err := installer.Install(ctx, lockFilename, locked)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to install")
+		}
+		return lock, nil
+	}
+
+	lock, err := installer.Install(ctx, filepath.Join(dir, jsonnetfile.File), m)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to install")
+	}
+	if installer.DryRun {
+		return lock, nil
+	}
+
+	l := *p.Lock
+	l.Targets = map[string]spec.Target{}
+	for n, t := range p.Lock.Targets {
+		l.Targets[n] = t
+	}
+	l.Targets[name] = spec.Target{Dependencies: lock.Dependencies, JsonnetHome: p.Jsonnetfile.Targets[name].JsonnetHome}
+	if err := jsonnetfile.Write(lockFilename, l); err != nil {
+		return nil, errors.Wrap(err, "failed to write lock file")
+	}
+	return lock, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// UpgradeOptions configure Upgrade.
+type UpgradeOptions struct {
+	Options
+
+	// Packages are the names, or package references, of the dependencies
+	// to upgrade. Every dependency is upgraded if there are none.
+	Packages []string
+
+	// IncludeFrozen upgrades the frozen dependencies as well, which are
+	// skipped otherwise, see pkg.Installer.
+	IncludeFrozen bool
+}
+
+// Upgrade raises the versions the jsonnetfile requires of the dependencies
+// of the project with newer releases upstream to the latest release, like
+// jb upgrade, and updates them to it. A constraint of a single ^, ~ or =
+// comparator keeps its operator, like ^1.2.0 becoming ^2.0.0, unless it
+// already allows the latest release, and a tag is replaced by the latest
+// one. Ranges like >=1.0 <2.0 or 1.0 || 2.0 not allowing the latest
+// release are kept with a warning, as is any constraint that does not
+// parse, to be edited by hand. Dependencies on branches and commits are
+// left alone. Both the jsonnetfile and the lock file are written and the
+// lock is returned.
+func Upgrade(ctx context.Context, opts UpgradeOptions) (*spec.JsonnetFile, error) {
+	dir := opts.dir()
+	installer := opts.installer()
+	installer.IncludeFrozen = opts.IncludeFrozen
+
+	if err := migrateNames(dir, installer, !opts.DryRun); err != nil {
+		return nil, err
+	}
+	p, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+	if p.Lock == nil {
+		return nil, fmt.Errorf("no %s in %s, run jb install first", jsonnetfile.LockFile, p.Dir)
+	}
+	m := p.Jsonnetfile
+
+	only := map[string]bool{}
+	for _, ref := range opts.Packages {
+		name := DependencyName(m, ref)
+		if name == "" {
+			return nil, fmt.Errorf("package %s is not a dependency in %s", ref, jsonnetfile.File)
+		}
+		only[name] = true
+	}
+
+	outdated, err := installer.Outdated(ctx, m, *p.Lock)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, o := range outdated {
+		switch {
+		case o.Latest == "":
+			continue
+		case len(only) > 0 && !only[o.Name]:
+			continue
+		case len(only) == 0 && isFrozen(m, o.Name) && !opts.IncludeFrozen:
+			color.Yellow(">>> Keeping frozen %s at %s, %s is available\n", o.Name, o.Version, o.Latest)
+			continue
+		}
+		version, ok := upgradedVersion(o.Version, o.Latest)
+		switch {
+		case !ok:
+			continue
+		case version == "":
+			color.Yellow(">>> Keeping %s at %s, the range does not allow %s and is only upgraded by hand\n", o.Name, o.Version, o.Latest)
+			continue
+		}
+		if version != o.Version {
+			color.Yellow(">>> Upgrading %s from %s to %s\n", o.Name, o.Version, version)
+			setVersion(m.Dependencies, o.Name, version)
+		}
+		names = append(names, o.Name)
+	}
+	if len(names) == 0 {
+		color.Green(">>> All dependencies require their latest release\n")
+		return p.Lock, nil
+	}
+
+	filename := filepath.Join(dir, jsonnetfile.File)
+	lock, err := installer.Update(ctx, filename, m, names...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to install")
+	}
+	if opts.DryRun {
+		return lock, nil
+	}
+
+	if err := jsonnetfile.Write(filename, m); err != nil {
+		return nil, errors.Wrap(err, "failed to write jsonnet file")
+	}
+	if err := writeLock(filepath.Join(dir, jsonnetfile.LockFile), *lock); err != nil {
+		return nil, errors.Wrap(err, "failed to write lock file")
+	}
+	return lock, nil
+}
+
+// upgradedVersion returns the version to require instead of version to get
+// latest, a release tag, and whether version requires releases at all. The
+// version is empty for constraints that do not allow latest and are not a
+// single ^, ~ or = comparator, whose shape would be lost.
+func upgradedVersion(version, latest string) (string, bool) {
+	qualified := pkg.QualifyRef(version)
+	switch {
+	case version == semver.Latest:
+		return version, true
+	case semver.IsConstraint(qualified):
+		c, err := semver.ParseConstraint(qualified)
+		if err != nil {
+			return "", true
+		}
+		if v, err := semver.Parse(latest); err == nil && c.Check(v) {
+			return version, true
+		}
+		if strings.ContainsAny(strings.TrimSpace(version), " |") || strings.IndexAny(version, "^~=") != 0 {
+			return "", true
+		}
+		return version[:1] + strings.TrimPrefix(latest, "v"), true
+	case strings.HasPrefix(qualified, "refs/tags/"):
+		// The short form tags/<tag> stays short.
+		return strings.TrimSuffix(version, strings.TrimPrefix(qualified, "refs/tags/")) + latest, true
+	}
+	if _, err := semver.Parse(version); err == nil {
+		return latest, true
+	}
+	return "", false
+}
+
+// isFrozen reports whether the dependency name of m is frozen.
+func isFrozen(m spec.JsonnetFile, name string) bool {
+	for _, d := range m.Dependencies {
+		if d.Name == name {
+			return d.Frozen
+		}
+	}
+	return false
+}
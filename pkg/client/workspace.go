@@ -0,0 +1,211 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package client
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// WorkspaceMembers returns the directories of the subprojects of the
+// workspace dir, relative to it and sorted. They are the directories listed
+// in the workspace of its jsonnetfile, if any, or else every directory below
+// dir with a jsonnetfile. Hidden directories, vendor directories named like
+// jsonnetHome and the directories below members are not searched.
+func WorkspaceMembers(dir, jsonnetHome string) ([]string, error) {
+	root, err := pkg.LoadJsonnetfile(filepath.Join(dir, jsonnetfile.File))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	members := []string{}
+	if len(root.Workspace) > 0 {
+		for _, pattern := range root.Workspace {
+			matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(pattern)))
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid workspace member %s", pattern)
+			}
+			for _, m := range matches {
+				if exists, _ := pkg.FileExists(filepath.Join(m, jsonnetfile.File)); !exists {
+					continue
+				}
+				rel, err := filepath.Rel(dir, m)
+				if err != nil {
+					return nil, err
+				}
+				members = append(members, rel)
+			}
+		}
+		sort.Strings(members)
+		return members, nil
+	}
+
+	vendor := filepath.Base(jsonnetHome)
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || path == dir {
+			return nil
+		}
+		if strings.HasPrefix(info.Name(), ".") || info.Name() == vendor {
+			return filepath.SkipDir
+		}
+		if exists, _ := pkg.FileExists(filepath.Join(path, jsonnetfile.File)); !exists {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		members = append(members, rel)
+		return filepath.SkipDir
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to find the members of the workspace")
+	}
+	return members, nil
+}
+
+// workspaceJsonnetfile returns the jsonnetfile of the workspace dir: its
+// own dependencies, if it has a jsonnetfile, and those of its members, see
+// mergeDependencies.
+func workspaceJsonnetfile(dir, jsonnetHome string) (spec.JsonnetFile, error) {
+	m, err := pkg.LoadJsonnetfile(filepath.Join(dir, jsonnetfile.File))
+	if err != nil && !os.IsNotExist(err) {
+		return m, errors.Wrap(err, "failed to load jsonnetfile")
+	}
+
+	members, err := WorkspaceMembers(dir, jsonnetHome)
+	if err != nil {
+		return m, err
+	}
+	if len(members) == 0 {
+		return m, &NoJsonnetfileError{Dir: dir}
+	}
+
+	deps := m.Dependencies
+	for _, member := range members {
+		mm, err := pkg.LoadJsonnetfile(filepath.Join(dir, member, jsonnetfile.File))
+		if err != nil {
+			return m, errors.Wrapf(err, "failed to load jsonnetfile of %s", member)
+		}
+		for _, d := range mm.Dependencies {
+			// Local packages are relative to the member.
+			if l := d.Source.LocalSource; l != nil && !filepath.IsAbs(l.Directory) {
+				d.Source.LocalSource = &spec.LocalSource{Directory: filepath.ToSlash(filepath.Join(member, l.Directory))}
+			}
+			deps = append(deps, d)
+		}
+	}
+	m.Dependencies = mergeDependencies(deps)
+	return m, nil
+}
+
+// mergeDependencies merges the dependencies of the same name and source.
+// Version constraints are combined into one all of them must satisfy, like
+// ^1.0 ~1.2 for ^1.0 and ~1.2. Any other differing versions are kept apart,
+// to be resolved by the conflict strategy of the installer.
+func mergeDependencies(deps []spec.Dependency) []spec.Dependency {
+	merged := []spec.Dependency{}
+next:
+	for _, d := range deps {
+		for n, prev := range merged {
+			if prev.Name != d.Name || !pkg.SameSource(prev.Source, d.Source) {
+				continue
+			}
+			if prev.Version == d.Version {
+				continue next
+			}
+			if combinable(prev.Version) && combinable(d.Version) {
+				for _, c := range strings.Fields(prev.Version) {
+					if c == d.Version {
+						continue next
+					}
+				}
+				merged[n].Version = prev.Version + " " + d.Version
+				continue next
+			}
+		}
+		merged = append(merged, d)
+	}
+	return merged
+}
+
+// combinable reports whether version is a constraint that can be combined
+// with others by listing them one after the other.
+func combinable(version string) bool {
+	return semver.IsConstraint(version) && !strings.Contains(version, "||")
+}
+
+// installWorkspace installs the dependencies of all members of the
+// workspace dir like Install: exactly its lock file if there is one, or
+// else the resolved dependencies, which are written to the lock file.
+// Neither the jsonnetfile of the workspace nor those of its members are
+// written.
+func installWorkspace(ctx context.Context, dir string, installer *pkg.Installer, frozen bool) (*spec.JsonnetFile, error) {
+	m, err := workspaceJsonnetfile(dir, installer.JsonnetHome)
+	if err != nil {
+		return nil, err
+	}
+	installer.QualifiedNames = m.Version >= spec.QualifiedVersion
+
+	lockFilename := filepath.Join(dir, jsonnetfile.LockFile)
+	lock, err := pkg.LoadJsonnetfile(lockFilename)
+	switch {
+	case err == nil:
+		if err := pkg.CheckLock(m, lock); err != nil {
+			if frozen {
+				return nil, err
+			}
+			color.Yellow(">>> %v\n", err)
+			color.Yellow(">>> Installed the locked versions, run jb update --workspace to apply the changes of the workspace\n")
+		}
+		if frozen {
+			stripLike(installer, lock)
+		}
+		installed, err := installer.Install(ctx, lockFilename, lock)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to install")
+		}
+		return installed, nil
+	case !os.IsNotExist(err):
+		return nil, errors.Wrap(err, "failed to load lock file")
+	case frozen:
+		return nil, errors.Wrap(err, "a frozen install requires a lock file")
+	}
+
+	installed, err := installer.Install(ctx, filepath.Join(dir, jsonnetfile.File), m)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to install")
+	}
+	if installer.DryRun {
+		return installed, nil
+	}
+	if err := writeLock(lockFilename, *installed); err != nil {
+		return nil, errors.Wrap(err, "failed to write lock file")
+	}
+	return installed, nil
+}
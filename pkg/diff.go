@@ -0,0 +1,117 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileChangeKind tells how a file differs between two versions of a
+// package, see FileChange.
+type FileChangeKind string
+
+const (
+	FileAdded    FileChangeKind = "added"
+	FileRemoved  FileChangeKind = "removed"
+	FileModified FileChangeKind = "modified"
+)
+
+// FileChange is a file that differs between two versions of a package, see
+// DiffDirs.
+type FileChange struct {
+	// Path is the path of the file within the package, with forward
+	// slashes.
+	Path string
+	Kind FileChangeKind
+}
+
+// DiffDirs returns the files that differ between the trees at old and new,
+// sorted by path. Files are compared by content, symlinks by target, and
+// directories not at all, like the digest of a package.
+func DiffDirs(old, new string) ([]FileChange, error) {
+	before, err := fileSums(old)
+	if err != nil {
+		return nil, err
+	}
+	after, err := fileSums(new)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []FileChange{}
+	for p, sum := range after {
+		switch b, ok := before[p]; {
+		case !ok:
+			changes = append(changes, FileChange{Path: p, Kind: FileAdded})
+		case !bytes.Equal(b, sum):
+			changes = append(changes, FileChange{Path: p, Kind: FileModified})
+		}
+	}
+	for p := range before {
+		if _, ok := after[p]; !ok {
+			changes = append(changes, FileChange{Path: p, Kind: FileRemoved})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+// DiffPatch writes the differences between the trees at old and new to w
+// as the patch of git diff, with the paths of the files relative to them,
+// prefixed by a/ and b/. Renames are an added and a removed file, like for
+// DiffDirs.
+func DiffPatch(ctx context.Context, old, new string, w io.Writer) error {
+	tmpDir, err := ioutil.TempDir("", "jb-diff")
+	if err != nil {
+		return errors.Wrap(err, "failed to create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// git diff --no-index shows the paths it was given, copies at a and b
+	// show the paths within the package.
+	if err := copyDir(old, filepath.Join(tmpDir, "a")); err != nil {
+		return errors.Wrap(err, "failed to copy package")
+	}
+	if err := copyDir(new, filepath.Join(tmpDir, "b")); err != nil {
+		return errors.Wrap(err, "failed to copy package")
+	}
+
+	stderr := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, "git", "-c", "core.quotepath=off", "diff", "--no-index", "--no-prefix", "--no-renames", "a", "b")
+	cmd.Dir = tmpDir
+	cmd.Stdout = w
+	cmd.Stderr = stderr
+	// git diff --no-index exits with 1 if the trees differ.
+	if err := cmd.Run(); err != nil {
+		if e, ok := err.(*exec.ExitError); ok && e.ExitCode() == 1 {
+			return nil
+		}
+		return fmt.Errorf("git diff failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
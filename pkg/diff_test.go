@@ -0,0 +1,66 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+	write("old/main.libsonnet", "{ a: 1 }\n")
+	write("old/same.libsonnet", "{}\n")
+	write("old/lib/removed.libsonnet", "{}\n")
+	write("new/main.libsonnet", "{ a: 2 }\n")
+	write("new/same.libsonnet", "{}\n")
+	write("new/lib/added.libsonnet", "{}\n")
+
+	old, new := filepath.Join(dir, "old"), filepath.Join(dir, "new")
+	changes, err := DiffDirs(old, new)
+	assert.NoError(t, err)
+	assert.Equal(t, []FileChange{
+		{Path: "lib/added.libsonnet", Kind: FileAdded},
+		{Path: "lib/removed.libsonnet", Kind: FileRemoved},
+		{Path: "main.libsonnet", Kind: FileModified},
+	}, changes)
+
+	changes, err = DiffDirs(old, old)
+	assert.NoError(t, err)
+	assert.Empty(t, changes)
+
+	var patch bytes.Buffer
+	assert.NoError(t, DiffPatch(context.TODO(), old, new, &patch))
+	assert.Contains(t, patch.String(), "--- a/main.libsonnet\n+++ b/main.libsonnet\n")
+	assert.Contains(t, patch.String(), "-{ a: 1 }\n+{ a: 2 }\n")
+	assert.Contains(t, patch.String(), "+++ b/lib/added.libsonnet\n")
+	assert.NotContains(t, patch.String(), "same.libsonnet")
+}
@@ -0,0 +1,175 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+)
+
+// NotFoundError is a package, or a version of it, that does not exist, like
+// an unknown repository, branch, tag or commit, or a missing local
+// directory.
+type NotFoundError struct {
+	Err error
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error, for errors.Cause.
+func (e *NotFoundError) Cause() error {
+	return e.Err
+}
+
+// MovedError is the locked commit of a git dependency that no longer
+// exists on its remote, because its branch was force-pushed or its tag was
+// deleted or moved. Its cause is the NotFoundError of the remote.
+type MovedError struct {
+	Name   string
+	Remote string
+	// Version is the locked commit and Tag the tag it was locked from, if
+	// any.
+	Version string
+	Tag     string
+	Err     error
+}
+
+func (e *MovedError) Error() string {
+	version := e.Version
+	if e.Tag != "" {
+		version = fmt.Sprintf("%s (tag %s)", e.Version, e.Tag)
+	}
+	return fmt.Sprintf("%s moved: its locked commit %s no longer exists on %s, its branch was likely force-pushed or its tag deleted: %v", e.Name, version, e.Remote, e.Err)
+}
+
+// Cause returns the underlying error, for errors.Cause.
+func (e *MovedError) Cause() error {
+	return e.Err
+}
+
+// IsMoved reports whether err, or any error it wraps, is a MovedError,
+// and returns it.
+func IsMoved(err error) (*MovedError, bool) {
+	for err != nil {
+		if m, ok := err.(*MovedError); ok {
+			return m, true
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			return nil, false
+		}
+		err = c.Cause()
+	}
+	return nil, false
+}
+
+// isNotFound reports whether err, or any error it wraps, is a
+// NotFoundError.
+func isNotFound(err error) bool {
+	for err != nil {
+		if _, ok := err.(*NotFoundError); ok {
+			return true
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
+// AuthError is a remote refusing access to a package, because there are
+// no credentials for it or they are wrong, see GitUsernameEnv.
+type AuthError struct {
+	Err error
+}
+
+func (e *AuthError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error, for errors.Cause.
+func (e *AuthError) Cause() error {
+	return e.Err
+}
+
+// NetworkError is a remote that cannot be reached or fails to respond, like
+// an unknown host, a refused connection, a timeout or a server error. Most
+// are transient and wrapped in a RetryableError, the last one is returned
+// if all retries fail.
+type NetworkError struct {
+	Err error
+}
+
+func (e *NetworkError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error, for errors.Cause.
+func (e *NetworkError) Cause() error {
+	return e.Err
+}
+
+// OfflineError is returned by offline installs for a dependency that
+// cannot be installed without the network, see Installer.Offline.
+type OfflineError struct {
+	Name   string
+	Reason string
+}
+
+func (e *OfflineError) Error() string {
+	return fmt.Sprintf("cannot install %s offline: %s", e.Name, e.Reason)
+}
+
+var (
+	// authGitRegex matches the messages of git about missing or rejected
+	// credentials, and of ssh about unknown host keys.
+	authGitRegex = regexp.MustCompile(`(?i)authentication failed|could not read (username|password)|terminal prompts disabled|permission denied \(publickey|invalid username or password|returned error: 40[13]|host key verification failed`)
+	// notFoundGitRegex matches the messages of git about repositories and
+	// refs that do not exist.
+	notFoundGitRegex = regexp.MustCompile(`(?i)repository not found|repository '[^']*' not found|does not appear to be a git repository|returned error: 404|couldn't find remote ref`)
+)
+
+// gitError classifies the failure err of a git command by its stderr as an
+// AuthError, a NotFoundError or a transient NetworkError, see retryableGit.
+func gitError(err error, stderr string) error {
+	switch {
+	case err == nil:
+		return nil
+	case authGitRegex.MatchString(stderr):
+		return &AuthError{Err: err}
+	case notFoundGitRegex.MatchString(stderr):
+		return &NotFoundError{Err: err}
+	}
+	return retryableGit(err, stderr)
+}
+
+// statusError classifies err, a request answered with status code, as an
+// AuthError, a NotFoundError or a transient NetworkError.
+func statusError(err error, code int) error {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return &AuthError{Err: err}
+	case code == http.StatusNotFound:
+		return &NotFoundError{Err: err}
+	case code >= 500 || retryableStatus(code):
+		return &NetworkError{Err: err}
+	}
+	return err
+}
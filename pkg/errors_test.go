@@ -0,0 +1,56 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitError(t *testing.T) {
+	err := errors.New("exit status 128")
+	testcases := []struct {
+		Stderr string
+		Want   error
+	}{
+		{"fatal: Authentication failed for 'https://github.com/a/b/'", &AuthError{Err: err}},
+		{"fatal: could not read Username for 'https://github.com': terminal prompts disabled", &AuthError{Err: err}},
+		{"git@github.com: Permission denied (publickey).", &AuthError{Err: err}},
+		{"remote: Repository not found.", &NotFoundError{Err: err}},
+		{"fatal: repository 'https://github.com/a/b/' not found", &NotFoundError{Err: err}},
+		{"fatal: couldn't find remote ref refs/heads/nope", &NotFoundError{Err: err}},
+		{"fatal: unable to access 'https://github.com/a/b/': Could not resolve host: github.com", &RetryableError{Err: &NetworkError{Err: err}}},
+		{"fatal: not a git repository", err},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Stderr, func(t *testing.T) {
+			assert.Equal(t, tc.Want, gitError(err, tc.Stderr))
+		})
+	}
+	assert.Nil(t, gitError(nil, "fatal: Authentication failed"))
+}
+
+func TestStatusError(t *testing.T) {
+	err := errors.New("failed to download")
+	assert.IsType(t, &AuthError{}, statusError(err, http.StatusUnauthorized))
+	assert.IsType(t, &AuthError{}, statusError(err, http.StatusForbidden))
+	assert.IsType(t, &NotFoundError{}, statusError(err, http.StatusNotFound))
+	assert.IsType(t, &NetworkError{}, statusError(err, http.StatusBadGateway))
+	assert.IsType(t, &NetworkError{}, statusError(err, http.StatusTooManyRequests))
+	assert.Equal(t, err, statusError(err, http.StatusBadRequest))
+}
@@ -0,0 +1,253 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IgnoreFile lists the files of a package that are not vendored, one
+// exclude pattern per line, see MatchExclude, so that the authors of a
+// package decide what its consumers receive. Empty lines and lines starting
+// with # are skipped. It is read from the root of the package, its subdir
+// if it has one.
+const IgnoreFile = ".jbignore"
+
+// DefaultStrip lists the exclude patterns of the files removed from every
+// vendored package unless Installer.Strip is set: the metadata of version
+// control systems and the configs of CI services, which are of no use to
+// consumers and only clutter vendor directories that are committed.
+var DefaultStrip = []string{
+	"**/.git",
+	"**/.gitmodules",
+	"**/.hg",
+	"**/.svn",
+	"**/.github",
+	"**/.gitlab",
+	"**/.gitlab-ci.yml",
+	"**/.travis.yml",
+	"**/.circleci",
+	"**/.drone.yml",
+	"**/.appveyor.yml",
+	"**/appveyor.yml",
+	"**/azure-pipelines.yml",
+	"**/Jenkinsfile",
+}
+
+// DocFiles lists the exclude patterns of the license and readme files at the
+// top of a package, removed with Installer.StripDocs.
+var DocFiles = []string{"LICENSE*", "LICENCE*", "COPYING*", "README*"}
+
+// MatchExclude reports whether the slash-separated path name, relative to
+// a package, is matched by the exclude pattern, see spec.Dependency. The
+// elements of the pattern are matched like path.Match, except for **,
+// which matches any number of elements, none included.
+func MatchExclude(pattern, name string) bool {
+	return matchElements(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(name, "/"))
+}
+
+func matchElements(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for n := 0; n <= len(name); n++ {
+				if matchElements(pattern[1:], name[n:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// excludeFiles removes the files and directories below dir that are
+// matched by one of patterns, see MatchExclude, but for those matched by
+// one of include, see spec.Dependency.Include. Excluded directories holding
+// files to include are kept with only these files.
+func excludeFiles(dir string, patterns, include []string) error {
+	if err := checkPatterns("exclude", patterns); err != nil {
+		return err
+	}
+	if err := checkPatterns("include", include); err != nil {
+		return err
+	}
+
+	excluded := []string{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		name := filepath.ToSlash(rel)
+		if !matchAny(patterns, name) && !below(excluded, file) {
+			return nil
+		}
+		if matchAny(include, name) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() && len(include) > 0 {
+			excluded = append(excluded, file)
+			return nil
+		}
+		if err := os.RemoveAll(file); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Nested directories come after their parents, removing them in
+	// reverse removes them before their parents.
+	for n := len(excluded) - 1; n >= 0; n-- {
+		if entries, err := ioutil.ReadDir(excluded[n]); err == nil && len(entries) == 0 {
+			if err := os.Remove(excluded[n]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkPatterns returns an error for the first invalid pattern of patterns,
+// of the kind given.
+func checkPatterns(kind string, patterns []string) error {
+	for _, p := range patterns {
+		if _, err := path.Match(strings.Trim(p, "/"), ""); err != nil || strings.Trim(p, "/") == "" {
+			return errors.Errorf("invalid %s pattern %q", kind, p)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if MatchExclude(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// below reports whether file is below one of dirs.
+func below(dirs []string, file string) bool {
+	for _, d := range dirs {
+		if strings.HasPrefix(file, d+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// strip returns the exclude patterns of the files removed from every
+// vendored package, see Strip and StripDocs.
+func (i *Installer) strip() []string {
+	strip := i.Strip
+	if strip == nil {
+		strip = DefaultStrip
+	}
+	if i.StripDocs {
+		strip = append(append([]string{}, strip...), DocFiles...)
+	}
+	return strip
+}
+
+// lockedStripSum returns the digest of the package at src with the files
+// stripped when the lock was written removed instead of those stripped now,
+// along with the excludes but for the files to include, as the lock file
+// records it, of the files listed if any. It is computed on a copy below
+// dir, src is not changed.
+func (i *Installer) lockedStripSum(dir, src string, exclude, include, files []string) (string, error) {
+	private, err := ioutil.TempDir(filepath.Join(dir, ".tmp"), "jsonnetpkg-strip")
+	if err != nil {
+		return "", errors.Wrap(err, "failed to create tmp dir")
+	}
+	defer os.RemoveAll(private)
+
+	pkg := filepath.Join(private, "package")
+	if err := copyDir(src, pkg); err != nil {
+		return "", errors.Wrap(err, "failed to copy package")
+	}
+	if err := excludeFiles(pkg, append(append([]string{}, exclude...), i.lockedStrip...), include); err != nil {
+		return "", err
+	}
+	if files != nil {
+		return hashFiles(pkg, files)
+	}
+	return hashDir(pkg)
+}
+
+// loadIgnoreFile returns the patterns of the IgnoreFile in dir, none if it
+// has none.
+func loadIgnoreFile(dir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	patterns := []string{}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, s.Err()
+}
+
+// sameExcludes reports whether a and b exclude the same files, as far as
+// their patterns tell.
+func sameExcludes(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for n := range a {
+		if a[n] != b[n] {
+			return false
+		}
+	}
+	return true
+}
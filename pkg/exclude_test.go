@@ -0,0 +1,253 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchExclude(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{pattern: "docs/**", name: "docs", match: true},
+		{pattern: "docs/**", name: "docs/a/b.md", match: true},
+		{pattern: "docs/**", name: "lib/docs", match: false},
+		{pattern: "**/*_test.jsonnet", name: "a_test.jsonnet", match: true},
+		{pattern: "**/*_test.jsonnet", name: "lib/x/a_test.jsonnet", match: true},
+		{pattern: "**/*_test.jsonnet", name: "lib/main.libsonnet", match: false},
+		{pattern: "*.md", name: "README.md", match: true},
+		{pattern: "*.md", name: "docs/README.md", match: false},
+		{pattern: "/examples/", name: "examples", match: true},
+		{pattern: "lib/**/fixtures", name: "lib/a/b/fixtures", match: true},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.match, MatchExclude(tc.pattern, tc.name), "%s %s", tc.pattern, tc.name)
+	}
+
+	assert.Error(t, excludeFiles(os.TempDir(), []string{"[a"}, nil))
+	assert.EqualError(t, excludeFiles(os.TempDir(), []string{"docs/**"}, []string{"[a"}), `invalid include pattern "[a"`)
+}
+
+func TestInstallerExclude(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("a/main.libsonnet", "{ a: 1 }")
+	repo.commit("a/main_test.jsonnet", "{}")
+	repo.commit("a/docs/index.md", "# a")
+	repo.commit("b/main.libsonnet", "{ b: 1 }")
+	repo.commit("b/docs/index.md", "# b")
+
+	tempDir, err := ioutil.TempDir("", "jb-exclude")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// a and b share a clone, the files excluded from a are kept in b.
+	a := gitDependency("a", repo.Dir, "master")
+	a.Source.GitSource.Subdir = "a"
+	a.Exclude = []string{"**/*_test.jsonnet", "docs/**"}
+	b := gitDependency("b", repo.Dir, "master")
+	b.Source.GitSource.Subdir = "b"
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{a, b}}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.FileExists(t, filepath.Join(i.JsonnetHome, "a", "main.libsonnet"))
+	_, err = os.Stat(filepath.Join(i.JsonnetHome, "a", "main_test.jsonnet"))
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(filepath.Join(i.JsonnetHome, "a", "docs"))
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, filepath.Join(i.JsonnetHome, "b", "docs", "index.md"))
+
+	sums := map[string]string{}
+	for _, d := range lock.Dependencies {
+		sums[d.Name] = d.Sum
+		if d.Name == "a" {
+			assert.Equal(t, a.Exclude, d.Exclude)
+		}
+	}
+	sum, err := hashDir(filepath.Join(i.JsonnetHome, "a"))
+	assert.NoError(t, err)
+	assert.Equal(t, sum, sums["a"])
+
+	// The lock is out of sync once the excludes change, and keeping a at
+	// its locked version does not compare its new files with the old
+	// digest.
+	a.Exclude = []string{"docs/**"}
+	m = spec.JsonnetFile{Dependencies: []spec.Dependency{a, b}}
+	assert.Error(t, CheckLock(m, *lock))
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(tempDir, JsonnetLockFile), *lock))
+	_, err = i.Update(context.TODO(), filepath.Join(tempDir, JsonnetFile), m, "b")
+	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(i.JsonnetHome, "a", "main_test.jsonnet"))
+}
+
+func TestInstallerIgnoreFile(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("lib/main.libsonnet", "{}")
+	repo.commit("lib/main_test.jsonnet", "{}")
+	repo.commit("lib/examples/dashboard.jsonnet", "{}")
+	repo.commit("lib/docs/index.md", "# lib")
+	repo.commit("lib/"+IgnoreFile, "# Only the library is published.\n**/*_test.jsonnet\n\nexamples/**\n")
+
+	tempDir, err := ioutil.TempDir("", "jb-exclude")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The excludes of the dependency add to those of the package.
+	lib := gitDependency("lib", repo.Dir, "master")
+	lib.Source.GitSource.Subdir = "lib"
+	lib.Exclude = []string{"docs/**"}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{lib}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.FileExists(t, filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+	for _, name := range []string{"main_test.jsonnet", "examples", "docs"} {
+		_, err = os.Stat(filepath.Join(i.JsonnetHome, "lib", name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+}
+
+func TestInstallerStrip(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("lib/main.libsonnet", "{}")
+	repo.commit("lib/.github/workflows/ci.yml", "on: push")
+	repo.commit("lib/.travis.yml", "language: go")
+	repo.commit("lib/README.md", "# lib")
+	repo.commit("lib/LICENSE", "Apache License\n   Version 2.0, January 2004")
+
+	tempDir, err := ioutil.TempDir("", "jb-strip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	lib := gitDependency("lib", repo.Dir, "master")
+	lib.Source.GitSource.Subdir = "lib"
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{lib}}
+	exists := func(name string) bool {
+		_, err := os.Stat(filepath.Join(tempDir, "vendor", "lib", name))
+		return err == nil
+	}
+
+	// Locks written without stripping anything have no Strip.
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Strip: []string{}}
+	legacy, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	legacy.Strip = nil
+	assert.True(t, exists(".github"))
+
+	// They are verified unstripped, and locked again stripped.
+	i = &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *legacy)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, DefaultStrip, lock.Strip)
+	assert.NotEqual(t, legacy.Dependencies[0].Sum, lock.Dependencies[0].Sum)
+	assert.False(t, exists(".github"))
+	assert.False(t, exists(".travis.yml"))
+	assert.True(t, exists("README.md"))
+	assert.NoError(t, Verify(i.JsonnetHome, *lock))
+
+	tampered := *legacy
+	tampered.Dependencies = []spec.Dependency{legacy.Dependencies[0]}
+	tampered.Dependencies[0].Sum = lock.Dependencies[0].Sum
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), tampered)
+	assert.IsType(t, &SumMismatchError{}, errors.Cause(err))
+
+	i.StripDocs = true
+	lock, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, append(append([]string{}, DefaultStrip...), DocFiles...), lock.Strip)
+	assert.False(t, exists("README.md"))
+	assert.False(t, exists("LICENSE"))
+	assert.Equal(t, "Apache-2.0", lock.Dependencies[0].License)
+}
+
+func TestInstallerInclude(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("lib/main.libsonnet", "{}")
+	repo.commit("lib/docs/index.md", "# lib")
+	repo.commit("lib/docs/templates/panel.json", "{}")
+	repo.commit("lib/.github/workflows/ci.yml", "on: push")
+	repo.commit("lib/.github/schema.json", "{}")
+	repo.commit("lib/"+IgnoreFile, "docs/**\n")
+
+	tempDir, err := ioutil.TempDir("", "jb-exclude")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Included files are kept whether they are ignored by the package,
+	// excluded by the dependency or stripped.
+	lib := gitDependency("lib", repo.Dir, "master")
+	lib.Source.GitSource.Subdir = "lib"
+	lib.Exclude = []string{"**/*.md"}
+	lib.Include = []string{"docs/templates/*.json", "**/schema.json"}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{lib}}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, lib.Include, lock.Dependencies[0].Include)
+	for name, want := range map[string]bool{
+		"main.libsonnet":            true,
+		"docs/templates/panel.json": true,
+		".github/schema.json":       true,
+		"docs/index.md":             false,
+		".github/workflows":         false,
+	} {
+		exists, err := FileExists(filepath.Join(i.JsonnetHome, "lib", filepath.FromSlash(name)))
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, name)
+	}
+
+	lib.Include = nil
+	assert.EqualError(t, CheckLock(spec.JsonnetFile{Dependencies: []spec.Dependency{lib}}, *lock), "jsonnetfile.lock.json is out of sync with jsonnetfile.json: lib is locked with other includes")
+}
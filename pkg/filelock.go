@@ -0,0 +1,244 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/pkg/errors"
+)
+
+var (
+	// lockPollInterval is how often a lock held by another process is
+	// tried again.
+	lockPollInterval = 100 * time.Millisecond
+	// staleLockAge is the age after which a lock that was not refreshed is
+	// considered left behind by a process that died, like on another host
+	// sharing the directory. Locks are refreshed four times as often.
+	staleLockAge = 2 * time.Minute
+)
+
+// LockTimeoutError is returned when a lock held by another jb process was
+// not released within the lock timeout.
+type LockTimeoutError struct {
+	Path string
+	// Owner describes the process holding the lock, as pid@host.
+	Owner   string
+	Timeout time.Duration
+}
+
+func (e *LockTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s waiting for %s, held by jb %s: remove it if that process is no longer running", e.Timeout, e.Path, e.Owner)
+}
+
+// fileLock is a lock file held by this process, shared by its goroutines.
+type fileLock struct {
+	// mu is held while the lock file is acquired and released.
+	mu   sync.Mutex
+	refs int
+	stop chan struct{}
+	done chan struct{}
+}
+
+var (
+	fileLocksMu sync.Mutex
+	fileLocks   = map[string]*fileLock{}
+)
+
+// acquireLock takes the lock file at path, excluding other processes taking
+// it until the returned function releases it. Goroutines of this process
+// share the lock, it only keeps out other jb processes on the same
+// directory. A lock held by another process is waited for, up to timeout if
+// it is not zero. Locks of processes that died are taken over: those of a
+// process of this host that is gone, and those not refreshed for
+// staleLockAge.
+func acquireLock(ctx context.Context, path string, timeout time.Duration) (func(), error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fileLocksMu.Lock()
+	l := fileLocks[path]
+	if l == nil {
+		l = &fileLock{}
+		fileLocks[path] = l
+	}
+	fileLocksMu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.refs == 0 {
+		if err := lockFile(ctx, path, timeout); err != nil {
+			return nil, err
+		}
+		l.stop, l.done = make(chan struct{}), make(chan struct{})
+		go refreshLock(path, l.stop, l.done)
+	}
+	l.refs++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			l.refs--
+			if l.refs == 0 {
+				close(l.stop)
+				<-l.done
+				os.Remove(path)
+			}
+		})
+	}, nil
+}
+
+// lockFile creates the lock file at path, waiting for the process holding
+// it.
+func lockFile(ctx context.Context, path string, timeout time.Duration) error {
+	host, _ := os.Hostname()
+	start := time.Now()
+	waiting := false
+	for {
+		// The directory may have been removed in the meantime, like by
+		// jb cache clean.
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = fmt.Fprintf(f, "%d %s %s\n", os.Getpid(), host, time.Now().UTC().Format(time.RFC3339))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				os.Remove(path)
+				return errors.Wrapf(err, "failed to write lock %s", path)
+			}
+			return nil
+		}
+		if !os.IsExist(err) {
+			return errors.Wrapf(err, "failed to create lock %s", path)
+		}
+
+		owner, info, stale := lockOwner(path, host)
+		if stale && takeOver(path, info) {
+			color.Yellow(">>> Removed stale lock %s of jb %s\n", path, owner)
+			continue
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			return &LockTimeoutError{Path: path, Owner: owner, Timeout: timeout}
+		}
+		if !waiting {
+			color.Yellow(">>> Waiting for %s, held by jb %s\n", path, owner)
+			waiting = true
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// lockOwner returns the owner of the lock file at path, as pid@host, its
+// file info and whether it is stale: its process is gone or it was not
+// refreshed for staleLockAge. Locks that were just created and are still
+// empty are not stale.
+func lockOwner(path, host string) (string, os.FileInfo, bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		// Released in the meantime.
+		return "", nil, false
+	}
+	stale := time.Since(info.ModTime()) > staleLockAge
+	// Locks of this process that are not held are left behind, like by a
+	// release that failed to remove them.
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", info, stale
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 2 {
+		return "", info, stale
+	}
+	pid, err := strconv.Atoi(fields[0])
+	owner := fields[0] + "@" + fields[1]
+	if err == nil && fields[1] == host && (pid == os.Getpid() || !processAlive(pid)) {
+		stale = true
+	}
+	return owner, info, stale
+}
+
+// takeOver removes the lock file at path if it still is the stale one of
+// info, reporting whether it did. Takeovers are serialized by a second lock
+// file, so that a process finding the same stale lock as another one never
+// removes the fresh lock the other one created in its place. A takeover of
+// another process in progress is waited for like the lock itself, one left
+// behind by a process that died during it is removed once stale.
+func takeOver(path string, info os.FileInfo) bool {
+	guard := path + ".takeover"
+	f, err := os.OpenFile(guard, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if g, err := os.Stat(guard); err == nil && time.Since(g.ModTime()) > staleLockAge {
+			os.Remove(guard)
+		}
+		return false
+	}
+	f.Close()
+	defer os.Remove(guard)
+
+	current, err := os.Stat(path)
+	if err != nil || !os.SameFile(current, info) || !current.ModTime().Equal(info.ModTime()) {
+		// Released or taken over in the meantime.
+		return false
+	}
+	return os.Remove(path) == nil
+}
+
+// refreshLock touches the lock file at path until stop is closed, so that it
+// is not taken for stale while it is held.
+func refreshLock(path string, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(staleLockAge / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			now := time.Now()
+			os.Chtimes(path, now, now)
+		}
+	}
+}
+
+// vendorLockPath returns the lock file of the vendor directory dir. It is
+// next to it, as the vendor directory is replaced as a whole by a staging
+// copy.
+func vendorLockPath(dir string) string {
+	dir = filepath.Clean(dir)
+	return filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+".lock")
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAcquireLock(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "sub", ".lock")
+
+	// The goroutines of a process share its lock.
+	release, err := acquireLock(context.TODO(), path, time.Second)
+	assert.NoError(t, err)
+	again, err := acquireLock(context.TODO(), path, time.Second)
+	assert.NoError(t, err)
+
+	again()
+	exists, _ := FileExists(path)
+	assert.True(t, exists)
+	release()
+	exists, _ = FileExists(path)
+	assert.False(t, exists)
+}
+
+func TestAcquireLockHeld(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	host, _ := os.Hostname()
+
+	tests := []struct {
+		name    string
+		content string
+		age     time.Duration
+		held    bool
+	}{
+		{name: "OtherHost", content: "123 elsewhere 2026-01-01T00:00:00Z\n", held: true},
+		{name: "Empty", held: true},
+		{name: "DeadProcess", content: "99999999 " + host + " 2026-01-01T00:00:00Z\n"},
+		{name: "NotRefreshed", content: "123 elsewhere 2026-01-01T00:00:00Z\n", age: 2 * staleLockAge},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, tc.name+".lock")
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if tc.age > 0 {
+				old := time.Now().Add(-tc.age)
+				if err := os.Chtimes(path, old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			release, err := acquireLock(context.TODO(), path, 300*time.Millisecond)
+			if !tc.held {
+				assert.NoError(t, err)
+				release()
+				return
+			}
+			assert.IsType(t, &LockTimeoutError{}, err)
+			exists, _ := FileExists(path)
+			assert.True(t, exists)
+		})
+	}
+}
+
+func TestTakeOverConcurrent(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "vendor.lock")
+
+	// Two waiters find the same stale lock, only one of them may take it
+	// over and the other must not remove the lock created in its place.
+	for round := 0; round < 20; round++ {
+		if err := ioutil.WriteFile(path, []byte("123 elsewhere 2026-01-01T00:00:00Z\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		old := time.Now().Add(-2 * staleLockAge)
+		if err := os.Chtimes(path, old, old); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		took, created := 0, 0
+		for w := 0; w < 2; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if !takeOver(path, info) {
+					return
+				}
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+				mu.Lock()
+				defer mu.Unlock()
+				took++
+				if err == nil {
+					created++
+					f.Close()
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 1, took)
+		assert.Equal(t, 1, created)
+		exists, _ := FileExists(path)
+		assert.True(t, exists)
+	}
+}
+
+func TestAcquireLockCanceled(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, ".lock")
+	if err := ioutil.WriteFile(path, []byte("123 elsewhere 2026-01-01T00:00:00Z\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 200*time.Millisecond)
+	defer cancel()
+	_, err = acquireLock(ctx, path, 0)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestVendorLockPath(t *testing.T) {
+	assert.Equal(t, filepath.Join("project", ".vendor.lock"), vendorLockPath(filepath.Join("project", "vendor")))
+	assert.Equal(t, ".vendor.lock", vendorLockPath("vendor/"))
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// LockOutOfSyncError is returned by CheckLock for dependencies of the
+// jsonnetfile that the lock file does not describe.
+type LockOutOfSyncError struct {
+	// Reasons holds one line per dependency that is out of sync.
+	Reasons []string
+}
+
+func (e *LockOutOfSyncError) Error() string {
+	return fmt.Sprintf("%s is out of sync with %s: %s", JsonnetLockFile, JsonnetFile, strings.Join(e.Reasons, ", "))
+}
+
+// CheckLock returns a LockOutOfSyncError if a dependency of m is missing
+// from lock, comes from a different source, is locked at a branch or tag
+// instead of a commit or digest, is pinned to a commit or digest other than
+// the locked one, or constrained to a range the locked tag is not in, is to
+// be verified but was locked without verification, excludes or includes
+// other files than the locked one, initializes submodules unlike the locked one, or is
+// in groups it was not locked in, and if the replacements of m, which apply
+// to its dependencies, its Kubernetes libraries or whether it vendors
+// sparsely differ from the locked ones.
+// Branches and tags cannot be checked without fetching them, any locked
+// commit is accepted for them. Remotes are compared with their variables
+// expanded, see ExpandRemotes, import paths as the remotes they were
+// locked from, see ExpandLockedImports, and subdir patterns against the
+// subdirs locked for them, see ExpandLockedSubdirs.
+func CheckLock(m, lock spec.JsonnetFile) error {
+	locked := make(map[string]spec.Dependency, len(lock.Dependencies))
+	for _, d := range lock.Dependencies {
+		locked[d.Name] = d
+	}
+
+	deps, err := ExpandRemotes(ApplyReplace(m.Dependencies, m.Replace))
+	if err != nil {
+		return err
+	}
+
+	reasons := []string{}
+	if !sameReplace(m.Replace, lock.Replace) {
+		reasons = append(reasons, "the replacements differ from the locked ones")
+	}
+	if !reflect.DeepEqual(m.Kubernetes, lock.Kubernetes) {
+		reasons = append(reasons, "the Kubernetes libraries differ from the locked ones")
+	}
+	if m.Sparse != lock.Sparse {
+		reasons = append(reasons, "the sparse vendoring differs from the locked one")
+	}
+	deps = ExpandLockedImports(deps, lock.Dependencies)
+	for _, d := range ExpandLockedSubdirs(deps, lock.Dependencies) {
+		l, ok := locked[d.Name]
+		switch {
+		case !ok:
+			reasons = append(reasons, fmt.Sprintf("%s is not locked", d.Name))
+		case !SameSource(l.Source, d.Source):
+			reasons = append(reasons, fmt.Sprintf("%s is locked from %s instead of %s", d.Name, SourceString(l.Source), SourceString(d.Source)))
+		case (fullCommitRegex.MatchString(d.Version) || IsOCIDigest(d.Version)) && d.Version != l.Version:
+			reasons = append(reasons, fmt.Sprintf("%s is locked at %s instead of %s", d.Name, l.Version, d.Version))
+		case (l.Source.GitSource != nil || l.Source.HgSource != nil) && !fullCommitRegex.MatchString(l.Version):
+			reasons = append(reasons, fmt.Sprintf("%s is locked at %s, which is not a commit", d.Name, l.Version))
+		case l.Source.OCISource != nil && !IsOCIDigest(l.Version):
+			reasons = append(reasons, fmt.Sprintf("%s is locked at %s, which is not a digest", d.Name, l.Version))
+		case semver.IsConstraint(d.Version) && !satisfies(d.Version, l.Tag):
+			reasons = append(reasons, fmt.Sprintf("%s is locked at tag %q which does not satisfy %s", d.Name, l.Tag, d.Version))
+		case d.Verify && !l.Verify:
+			reasons = append(reasons, fmt.Sprintf("%s is to be verified, but was locked without verification", d.Name))
+		case !sameExcludes(d.Exclude, l.Exclude):
+			reasons = append(reasons, fmt.Sprintf("%s is locked with other excludes", d.Name))
+		case !sameExcludes(d.Include, l.Include):
+			reasons = append(reasons, fmt.Sprintf("%s is locked with other includes", d.Name))
+		case d.Submodules && !l.Submodules:
+			reasons = append(reasons, fmt.Sprintf("%s initializes submodules, but was locked without them", d.Name))
+		case !d.Submodules && l.Submodules:
+			reasons = append(reasons, fmt.Sprintf("%s was locked with submodules, which it no longer initializes", d.Name))
+		case len(missingGroups(d, l)) > 0:
+			reasons = append(reasons, fmt.Sprintf("%s is in the groups %s, but was not locked in them", d.Name, strings.Join(missingGroups(d, l), ", ")))
+		}
+	}
+
+	if len(reasons) > 0 {
+		return &LockOutOfSyncError{Reasons: reasons}
+	}
+	return nil
+}
+
+// sameReplace reports whether a and b replace the same dependencies the
+// same way, in any order.
+func sameReplace(a, b []spec.Replace) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	key := func(r spec.Replace) string {
+		source := ""
+		if r.Source != nil {
+			source = SourceString(*r.Source)
+		}
+		return r.Name + " " + source + " " + r.Version
+	}
+	keys := map[string]int{}
+	for _, r := range a {
+		keys[key(r)]++
+	}
+	for _, r := range b {
+		if keys[key(r)] == 0 {
+			return false
+		}
+		keys[key(r)]--
+	}
+	return true
+}
+
+// satisfies reports whether tag is a version within constraint.
+func satisfies(constraint, tag string) bool {
+	c, err := semver.ParseConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	v, err := semver.Parse(tag)
+	if err != nil {
+		return false
+	}
+	return c.Check(v)
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLock(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	lock := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("foo", "https://github.com/org/foo", commit),
+		gitDependency("transitive", "https://github.com/org/transitive", commit),
+		gitDependency("tagged", "https://github.com/org/tagged", commit),
+	}}
+	lock.Dependencies[2].Tag = "v1.2.5"
+	lock.Dependencies = append(lock.Dependencies, gitDependency("mutable", "https://github.com/org/mutable", "master"))
+
+	testcases := []struct {
+		Name string
+		Deps []spec.Dependency
+		Err  bool
+	}{
+		{Name: "Branch", Deps: []spec.Dependency{gitDependency("foo", "https://github.com/org/foo", "master")}},
+		{Name: "Commit", Deps: []spec.Dependency{gitDependency("foo", "https://github.com/org/foo", commit)}},
+		{Name: "OtherCommit", Deps: []spec.Dependency{gitDependency("foo", "https://github.com/org/foo", "fedcba9876543210fedcba9876543210fedcba98")}, Err: true},
+		{Name: "OtherSource", Deps: []spec.Dependency{gitDependency("foo", "https://github.com/fork/foo", "master")}, Err: true},
+		{Name: "Constraint", Deps: []spec.Dependency{gitDependency("tagged", "https://github.com/org/tagged", "~1.2")}},
+		{Name: "ConstraintNotSatisfied", Deps: []spec.Dependency{gitDependency("tagged", "https://github.com/org/tagged", "^2.0")}, Err: true},
+		{Name: "ConstraintWithoutTag", Deps: []spec.Dependency{gitDependency("foo", "https://github.com/org/foo", "^1.0")}, Err: true},
+		{Name: "LockedAtBranch", Deps: []spec.Dependency{gitDependency("mutable", "https://github.com/org/mutable", "master")}, Err: true},
+		{Name: "NotLocked", Deps: []spec.Dependency{gitDependency("bar", "https://github.com/org/bar", "master")}, Err: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			err := CheckLock(spec.JsonnetFile{Dependencies: tc.Deps}, lock)
+			if tc.Err {
+				assert.IsType(t, &LockOutOfSyncError{}, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// gcsScope is the OAuth scope of the access tokens, reading objects.
+	gcsScope = "https://www.googleapis.com/auth/devstorage.read_only"
+	// googleTokenURL is the token endpoint of Google, unless the
+	// credentials file names another one.
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+// gcsTokenCache holds the access token of the application default
+// credentials, which is valid for a while.
+var gcsTokenCache struct {
+	sync.Mutex
+	token  string
+	expiry time.Time
+	// none is set once no credentials were found.
+	none bool
+}
+
+// googleCredentials is a credentials file of the application default
+// credentials, of a service account or of a user logged in with gcloud.
+type googleCredentials struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// gcsRequest returns the request of the object key in bucket, from the
+// emulator of STORAGE_EMULATOR_HOST if set, authenticated with the access
+// token of the application default credentials, see gcsToken. Without
+// credentials it is anonymous, for public buckets.
+func gcsRequest(ctx context.Context, caFile, bucket, key string) (*http.Request, error) {
+	endpoint := "https://storage.googleapis.com"
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		endpoint = strings.TrimSuffix(host, "/")
+		if !strings.Contains(endpoint, "://") {
+			endpoint = "http://" + endpoint
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, endpoint+"/"+bucket+"/"+escapeObjectKey(key), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := gcsToken(ctx, caFile)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// gcsToken returns an access token of the application default credentials,
+// found like the Google Cloud SDKs do: the credentials file of
+// GOOGLE_APPLICATION_CREDENTIALS, the one written by
+// gcloud auth application-default login, and the service account of the
+// metadata server of Google Cloud, at GCE_METADATA_HOST if set. It returns
+// an empty token if there are none.
+func gcsToken(ctx context.Context, caFile string) (string, error) {
+	gcsTokenCache.Lock()
+	defer gcsTokenCache.Unlock()
+	if gcsTokenCache.token != "" && time.Now().Add(time.Minute).Before(gcsTokenCache.expiry) {
+		return gcsTokenCache.token, nil
+	}
+	if gcsTokenCache.none {
+		return "", nil
+	}
+
+	var token string
+	var expiry time.Time
+	var err error
+	if filename := googleCredentialsFile(); filename != "" {
+		token, expiry, err = fileToken(ctx, caFile, filename)
+	} else {
+		token, expiry, err = metadataToken(ctx)
+	}
+	if err != nil {
+		return "", err
+	}
+	gcsTokenCache.token, gcsTokenCache.expiry, gcsTokenCache.none = token, expiry, token == ""
+	return token, nil
+}
+
+// googleCredentialsFile returns the credentials file of
+// GOOGLE_APPLICATION_CREDENTIALS, or else the one of gcloud if it exists.
+func googleCredentialsFile() string {
+	if filename := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); filename != "" {
+		return filename
+	}
+	dir := os.Getenv("CLOUDSDK_CONFIG")
+	if dir == "" && runtime.GOOS == "windows" {
+		dir = filepath.Join(os.Getenv("APPDATA"), "gcloud")
+	}
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config", "gcloud")
+	}
+	filename := filepath.Join(dir, "application_default_credentials.json")
+	if _, err := os.Stat(filename); err != nil {
+		return ""
+	}
+	return filename
+}
+
+// fileToken exchanges the credentials of filename for an access token: a
+// JWT signed with the key of a service account, or the refresh token of a
+// user.
+func fileToken(ctx context.Context, caFile, filename string) (string, time.Time, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", time.Time{}, errors.Wrap(err, "failed to read Google Cloud credentials")
+	}
+	var creds googleCredentials
+	if err := json.Unmarshal(b, &creds); err != nil {
+		return "", time.Time{}, errors.Wrapf(err, "invalid Google Cloud credentials %s", filename)
+	}
+	tokenURL := creds.TokenURI
+	if tokenURL == "" {
+		tokenURL = googleTokenURL
+	}
+
+	form := url.Values{}
+	switch creds.Type {
+	case "service_account":
+		assertion, err := serviceAccountJWT(creds, tokenURL, time.Now())
+		if err != nil {
+			return "", time.Time{}, errors.Wrapf(err, "invalid Google Cloud credentials %s", filename)
+		}
+		form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+		form.Set("assertion", assertion)
+	case "authorized_user":
+		form.Set("grant_type", "refresh_token")
+		form.Set("client_id", creds.ClientID)
+		form.Set("client_secret", creds.ClientSecret)
+		form.Set("refresh_token", creds.RefreshToken)
+	default:
+		return "", time.Time{}, fmt.Errorf("unsupported type %q of Google Cloud credentials %s, use a service account key or gcloud auth application-default login", creds.Type, filename)
+	}
+
+	client, err := httpClient(caFile)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", time.Time{}, retryableDownload(ctx, errors.Wrap(err, "failed to get a Google Cloud access token"))
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, retryableDownload(ctx, errors.Wrap(err, "failed to get a Google Cloud access token"))
+	}
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		ExpiresIn        int    `json:"expires_in"`
+		ErrorDescription string `json:"error_description"`
+	}
+	json.Unmarshal(body, &token)
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		err := fmt.Errorf("failed to get a Google Cloud access token: %s", resp.Status)
+		if token.ErrorDescription != "" {
+			err = fmt.Errorf("%v: %s", err, token.ErrorDescription)
+		}
+		if retryableStatus(resp.StatusCode) || resp.StatusCode >= 500 {
+			return "", time.Time{}, &RetryableError{Err: &NetworkError{Err: err}}
+		}
+		return "", time.Time{}, &AuthError{Err: err}
+	}
+	return token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn) * time.Second), nil
+}
+
+// serviceAccountJWT returns the assertion of the service account creds
+// for an access token from tokenURL, issued at now.
+func serviceAccountJWT(creds googleCredentials, tokenURL string, now time.Time) (string, error) {
+	block, _ := pem.Decode([]byte(creds.PrivateKey))
+	if block == nil {
+		return "", errors.New("no private key found")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return "", errors.Wrap(err, "invalid private key")
+		}
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", errors.New("the private key is no RSA key")
+	}
+
+	encode := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	unsigned := encode(map[string]string{"alg": "RS256", "typ": "JWT"}) + "." + encode(map[string]interface{}{
+		"iss":   creds.ClientEmail,
+		"scope": gcsScope,
+		"aud":   tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	sum := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// metadataToken returns the access token of the service account of the
+// instance from the metadata server, none outside of Google Cloud.
+func metadataToken(ctx context.Context) (string, time.Time, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	header := http.Header{}
+	header.Set("Metadata-Flavor", "Google")
+	body, err := metadataGet(ctx, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", header)
+	if err != nil || body == nil {
+		return "", time.Time{}, err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", time.Time{}, errors.Wrap(err, "invalid access token from the metadata server")
+	}
+	return token.AccessToken, time.Now().Add(time.Duration(token.ExpiresIn) * time.Second), nil
+}
@@ -16,17 +16,121 @@ package pkg
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
-	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+var (
+	commitRegex       = regexp.MustCompile("^[0-9a-f]{7,40}$")
+	githubRemoteRegex = regexp.MustCompile("^(?:https://github\\.com/|git@github\\.com:)([-_.a-zA-Z0-9]+)/([-_.a-zA-Z0-9]+?)(?:\\.git)?/?$")
+
+	// githubTarballURL is where GitHub serves the tarball of a repository at
+	// a given ref.
+	githubTarballURL = "https://codeload.github.com/%s/%s/tar.gz/%s"
+	// githubRepoURL is the HTTPS remote of a GitHub repository.
+	githubRepoURL = "https://github.com/%s/%s"
 )
 
 type GitPackage struct {
 	Source *spec.GitSource
+
+	// Since, when set together with Locked, only moves a floating version
+	// away from the Locked commit if its upstream commit is newer than Since.
+	// Versions pinned to a tag or commit always keep the Locked commit.
+	Since  time.Time
+	Locked string
+
+	// CAFile holds the certificate authorities trusted for HTTPS remotes
+	// and tarball downloads, see CAFileEnv. The system ones are trusted if
+	// it is empty.
+	CAFile string
+
+	// SSHKey is the private key ssh authenticates to ssh remotes with,
+	// instead of those of the ssh agent and ~/.ssh, and SSHKnownHosts the
+	// known hosts file their host keys must be in, see SSHKeyEnv.
+	SSHKey        string
+	SSHKnownHosts string
+
+	// Mirrors rewrite the remote that is fetched from. The lock file keeps
+	// the remote of Source.
+	Mirrors Mirrors
+
+	// Transport, https or ssh, translates the remote of Source to that
+	// transport, see TranslateRemote. Prefer does the same unless Transport
+	// is set, but falls back to the remote as written if the preferred
+	// transport is refused access or cannot reach the remote. Mirrors
+	// rewrite the translated remote.
+	Transport string
+	Prefer    string
+	// preferFailed is set once the Prefer transport failed.
+	preferFailed bool
+
+	// Sparse lists the subdirs to check out instead of the subdir of
+	// Source, for a clone shared by the dependencies on several subdirs of
+	// the repository. Globs like libs/* are allowed.
+	Sparse []string
+
+	// Verbose streams the output of git to stderr. Otherwise it is only
+	// reported as part of the error of a failing command.
+	Verbose bool
+
+	// Progress, if set, is told about the stages of Install.
+	Progress func(Stage)
+
+	// Tarballs downloads GitHub repositories as tarballs instead of cloning
+	// them, even if git is installed, which is much faster for large
+	// repositories. Versions that need git, to Verify, as of a date or
+	// restricted by Since, are still cloned.
+	Tarballs bool
+	// TarballCache is the directory downloaded tarballs are kept in with
+	// their ETag, see openTarball. They are not kept if it is empty.
+	TarballCache string
+
+	// AsOf, if set, resolves versions that are branches, the default branch
+	// included, to the last commit of the branch before AsOf instead of its
+	// head, see SplitAsOf for versions that set it themselves. Tags and
+	// commits are not affected.
+	AsOf time.Time
+
+	// Tag is set by Install to the tag installed: the version if it is a
+	// tag, the tag chosen for a version constraint like ^1.2.0, or the
+	// verified tag.
+	Tag string
+
+	// Date and Trees are set by Install to the date of the installed commit
+	// and the git tree hashes of the subdirs checked out, by subdir.
+	Date  time.Time
+	Trees map[string]string
+
+	// Submodules initializes the git submodules of the checked out subdirs
+	// recursively, at the commits recorded by the installed commit, instead
+	// of leaving their directories empty. Install sets SubmoduleCommits to
+	// the commit of every initialized submodule, by path.
+	Submodules       bool
+	SubmoduleCommits map[string]string
+
+	// Verify requires the installed commit to be a tag signed by one of
+	// the armored OpenPGP public keys in the files TrustedKeys, failing
+	// with a SignatureError otherwise. Commits, like locked ones, are
+	// verified through VerifyTag, which must point to them.
+	Verify      bool
+	VerifyTag   string
+	TrustedKeys []string
 }
 
 func NewGitPackage(source *spec.GitSource) Interface {
@@ -35,28 +139,117 @@ func NewGitPackage(source *spec.GitSource) Interface {
 	}
 }
 
+// QualifyRef expands the short forms tags/<tag> and heads/<branch> of a git
+// version to the refs refs/tags/<tag> and refs/heads/<branch>, which are
+// never confused with a branch or tag of the same name. Other versions,
+// refs like refs/pull/123/head included, are returned unchanged.
+func QualifyRef(version string) string {
+	if strings.HasPrefix(version, "tags/") || strings.HasPrefix(version, "heads/") {
+		return "refs/" + version
+	}
+	return version
+}
+
+// Install fetches version into dir. Only the requested commit is fetched,
+// without history, and only the subdir is checked out, so that small
+// packages in large repositories install quickly. Versions that cannot be
+// fetched by themselves, like abbreviated commits, fall back to fetching the
+// whole repository. Without git, or with Tarballs, GitHub repositories are
+// downloaded as tarballs, with their refs listed natively. Version
+// constraints are resolved to the highest matching tag and branches as of a
+// date to the last commit before it, see AsOf. The signature of packages to
+// Verify is checked before anything is checked out. An install failing
+// over the Prefer transport is tried again over the remote as written.
 func (p *GitPackage) Install(ctx context.Context, dir, version string) (lockVersion string, err error) {
-	cmd := exec.CommandContext(ctx, "git", "clone", p.Source.Remote, dir)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err = cmd.Run()
+	lockVersion, err = p.install(ctx, dir, version)
+	if err == nil || ctx.Err() != nil || !p.preferred() || !transportFailed(err) {
+		return lockVersion, err
+	}
+
+	color.Yellow(">>> Fetching %s over %s failed, falling back to the remote as written: %v\n", p.Source.Remote, p.Prefer, err)
+	p.preferFailed = true
+	// The repository is kept, see init, the tarball of a GitHub repository
+	// is downloaded again.
+	if !p.Resumes() {
+		if err := os.RemoveAll(dir); err != nil {
+			return "", err
+		}
+	}
+	return p.install(ctx, dir, version)
+}
+
+func (p *GitPackage) install(ctx context.Context, dir, version string) (lockVersion string, err error) {
+	branch, asOf, explicit, err := SplitAsOf(version)
 	if err != nil {
 		return "", err
 	}
+	if explicit {
+		version = branch
+	} else {
+		asOf = p.AsOf
+	}
+	version = QualifyRef(version)
 
-	cmd = exec.CommandContext(ctx, "git", "-c", "advice.detachedHead=false", "checkout", version)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Dir = dir
-	err = cmd.Run()
+	_, noGit := exec.LookPath("git")
+	if noGit != nil || p.Tarballs {
+		owner, repo, ok := githubRepo(p.remote())
+		// Without git, Since is ignored rather than failing the install.
+		// Tarballs do not contain submodules.
+		tarball := ok && !p.Verify && !p.Submodules && asOf.IsZero() && (noGit != nil || p.Since.IsZero() || p.Locked == "")
+		if tarball {
+			ref, err := p.tarballRef(ctx, version, owner, repo)
+			if err != nil {
+				return "", err
+			}
+			return p.installTarball(ctx, dir, ref, owner, repo)
+		}
+		if noGit != nil {
+			return "", fmt.Errorf("git is required to install %s version %s: %v", p.Source.Remote, version, noGit)
+		}
+	}
+
+	p.progress(StageCloning)
+	if err := p.init(ctx, dir); err != nil {
+		return "", err
+	}
+
+	ref, err := p.fetchRef(ctx, dir, version)
 	if err != nil {
 		return "", err
 	}
+	if !asOf.IsZero() {
+		ref, err = p.asOfRef(ctx, dir, version, ref, asOf, explicit)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if !p.Since.IsZero() && p.Locked != "" {
+		ref, err = p.sinceRef(ctx, dir, version, ref)
+		if err != nil {
+			return "", err
+		}
+		if err := p.fetchCommit(ctx, dir, ref); err != nil {
+			return "", err
+		}
+	}
+
+	if p.Verify {
+		if err := p.verifySignature(ctx, dir, ref); err != nil {
+			return "", err
+		}
+	}
+
+	p.progress(StageCheckingOut)
+	if err := p.sparseCheckout(ctx, dir); err != nil {
+		return "", err
+	}
+	if err := p.git(ctx, dir, "-c", "advice.detachedHead=false", "checkout", "-q", ref); err != nil {
+		return "", err
+	}
 
 	b := bytes.NewBuffer(nil)
-	cmd = exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
 	cmd.Stdout = b
 	cmd.Dir = dir
 	err = cmd.Run()
@@ -66,10 +259,676 @@ func (p *GitPackage) Install(ctx context.Context, dir, version string) (lockVers
 
 	commitHash := strings.TrimSpace(b.String())
 
-	err = os.RemoveAll(path.Join(dir, ".git"))
+	if p.Date, err = commitTime(ctx, dir, "HEAD"); err != nil {
+		return "", err
+	}
+	p.Trees = p.subdirTrees(ctx, dir)
+
+	if p.Submodules {
+		if err := p.updateSubmodules(ctx, dir); err != nil {
+			return "", err
+		}
+	}
+
+	err = os.RemoveAll(filepath.Join(dir, ".git"))
 	if err != nil {
 		return "", err
 	}
 
 	return commitHash, nil
 }
+
+// updateSubmodules checks out the submodules of HEAD recursively and records
+// their commits in SubmoduleCommits. Sparse checkouts only initialize the
+// submodules of the checked out subdirs. The .git files of the submodules
+// are removed, like the repository itself.
+func (p *GitPackage) updateSubmodules(ctx context.Context, dir string) error {
+	args := []string{"submodule", "update", "--init", "--recursive", "--"}
+	subdirs, err := p.subdirs()
+	if err != nil {
+		return err
+	}
+	for _, s := range subdirs {
+		args = append(args, strings.Trim(s, "/"))
+	}
+	if err := p.run(p.remoteCommand(ctx, dir, args...)); err != nil {
+		return errors.Wrapf(err, "failed to update the submodules of %s", p.Source.Remote)
+	}
+
+	b := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, "git", "submodule", "status", "--recursive")
+	cmd.Stdout = b
+	cmd.Dir = dir
+	if err := p.run(cmd); err != nil {
+		return errors.Wrapf(err, "failed to list the submodules of %s", p.Source.Remote)
+	}
+
+	// Lines are the status, the commit and the path, followed by the
+	// description of the commit, like " 0123abc lib/dep (v1.0.0)". Status
+	// "-" marks submodules that were not initialized.
+	p.SubmoduleCommits = map[string]string{}
+	for _, line := range strings.Split(b.String(), "\n") {
+		if len(line) < 2 || line[0] == '-' {
+			continue
+		}
+		fields := strings.Fields(line[1:])
+		if len(fields) < 2 {
+			continue
+		}
+		path := fields[1]
+		p.SubmoduleCommits[path] = fields[0]
+		if err := os.RemoveAll(filepath.Join(dir, filepath.FromSlash(path), ".git")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LockTag returns the tag a version constraint resolved to, if any.
+func (p *GitPackage) LockTag() string {
+	return p.Tag
+}
+
+// LockCommit returns the date of the installed commit and the tree hash of
+// subdir, if it was checked out.
+func (p *GitPackage) LockCommit(subdir string) (time.Time, string) {
+	return p.Date, p.Trees[subdir]
+}
+
+// LockSubmodules returns the commits of the submodules checked out below
+// subdir, by path relative to the repository.
+func (p *GitPackage) LockSubmodules(subdir string) map[string]string {
+	return submodulesBelow(p.SubmoduleCommits, subdir)
+}
+
+// submodulesBelow returns the submodules of commits with a path below
+// subdir, nil if there are none.
+func submodulesBelow(commits map[string]string, subdir string) map[string]string {
+	var res map[string]string
+	prefix := strings.Trim(filepath.ToSlash(subdir), "/") + "/"
+	for path, commit := range commits {
+		if prefix == "/" || strings.HasPrefix(path, prefix) {
+			if res == nil {
+				res = map[string]string{}
+			}
+			res[path] = commit
+		}
+	}
+	return res
+}
+
+// subdirTrees returns the tree hashes of the subdir of Source and of the
+// subdirs of Sparse at HEAD, with their version placeholders expanded.
+// Globs and missing subdirs are left out.
+func (p *GitPackage) subdirTrees(ctx context.Context, dir string) map[string]string {
+	trees := map[string]string{}
+	for _, subdir := range append([]string{p.Source.Subdir}, p.Sparse...) {
+		subdir, err := ExpandVersion(subdir, p.Tag)
+		if err != nil || strings.ContainsAny(subdir, "*?[") {
+			continue
+		}
+		if tree, err := revParse(ctx, dir, "HEAD:"+strings.Trim(subdir, "/")); err == nil {
+			trees[subdir] = tree
+		}
+	}
+	return trees
+}
+
+// tarballRef resolves version of a GitHub repository to the ref its tarball
+// is downloaded at, the best matching tag for a constraint, recorded in
+// p.Tag, and the default branch for no version at all. The refs are
+// listed natively over HTTPS, see httpRefs.
+func (p *GitPackage) tarballRef(ctx context.Context, version, owner, repo string) (string, error) {
+	if version != "" && !semver.IsConstraint(version) {
+		if strings.HasPrefix(version, "refs/tags/") {
+			p.Tag = strings.TrimPrefix(version, "refs/tags/")
+		}
+		return version, nil
+	}
+
+	refs, head, err := httpRefs(ctx, p.CAFile, fmt.Sprintf(githubRepoURL, owner, repo))
+	if err != nil {
+		return "", err
+	}
+	if version == "" {
+		return head, nil
+	}
+
+	c, err := semver.ParseConstraint(version)
+	if err != nil {
+		return "", err
+	}
+	tags := []string{}
+	for ref := range refs {
+		if strings.HasPrefix(ref, "refs/tags/") {
+			tags = append(tags, strings.TrimPrefix(ref, "refs/tags/"))
+		}
+	}
+	tag, ok := c.Best(tags)
+	if !ok {
+		return "", &NotFoundError{Err: fmt.Errorf("no tag of %s satisfies version %s", p.Source.Remote, version)}
+	}
+	p.Tag = tag
+	return tag, nil
+}
+
+// installTarball downloads version of a GitHub repository as a tarball,
+// for systems without git or with Tarballs. The commit is taken from the
+// tarball, which is created by git archive.
+func (p *GitPackage) installTarball(ctx context.Context, dir, version, owner, repo string) (string, error) {
+	if version == "" {
+		version = "HEAD"
+	}
+	url := fmt.Sprintf(githubTarballURL, owner, repo, version)
+	f, err := openTarball(ctx, p.CAFile, p.TarballCache, url)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read %s", url)
+	}
+	defer gz.Close()
+
+	commit, err := extractTar(gz, dir)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to extract %s", url)
+	}
+	if !commitRegex.MatchString(commit) {
+		return "", fmt.Errorf("failed to determine the commit of %s", url)
+	}
+
+	if err := stripTopLevelDir(dir); err != nil {
+		return "", err
+	}
+
+	return commit, nil
+}
+
+// githubRepo returns the owner and name of a GitHub repository from its
+// HTTPS or SSH remote.
+func githubRepo(remote string) (owner, repo string, ok bool) {
+	m := githubRemoteRegex.FindStringSubmatch(remote)
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// init creates an empty repository at dir with the remote as origin.
+// Files are checked out as they were committed, whatever the line endings
+// configured by the user or the .gitattributes of the repository, so that
+// binary fixtures are never converted and the digest of a package is the
+// same on every platform. A repository left at dir by a failed attempt is
+// kept with what it fetched, only its checkout is removed, see Resumes.
+func (p *GitPackage) init(ctx context.Context, dir string) error {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return err
+	}
+	if exists, _ := FileExists(filepath.Join(dir, ".git", "config")); exists {
+		return p.reinit(ctx, dir)
+	}
+	if err := p.git(ctx, dir, "init", "-q", "."); err != nil {
+		return err
+	}
+	if err := p.git(ctx, dir, "config", "core.autocrlf", "false"); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, ".git", "info"), os.ModePerm); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".git", "info", "attributes"), []byte("* -text -ident !working-tree-encoding\n"), 0644); err != nil {
+		return err
+	}
+	return p.git(ctx, dir, "remote", "add", "origin", p.remote())
+}
+
+// reinit prepares the repository a failed attempt left at dir for another
+// one: the files it checked out are removed and origin is set to the
+// remote, keeping the objects it fetched.
+func (p *GitPackage) reinit(ctx context.Context, dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == ".git" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return p.git(ctx, dir, "remote", "set-url", "origin", p.remote())
+}
+
+// Resumes reports whether Install continues from the repository a failed
+// attempt left in its directory, which holds the history fetched so far,
+// rather than needing an empty one. Packages downloaded as tarballs start
+// over.
+func (p *GitPackage) Resumes() bool {
+	_, err := exec.LookPath("git")
+	return err == nil && !p.Tarballs
+}
+
+// subdirs returns the subdirs to check out, those of Sparse or the subdir of
+// Source, with their version placeholders expanded for the tag resolved.
+func (p *GitPackage) subdirs() ([]string, error) {
+	subdirs := p.Sparse
+	if len(subdirs) == 0 && p.Source.Subdir != "" {
+		subdirs = []string{p.Source.Subdir}
+	}
+
+	expanded := make([]string, 0, len(subdirs))
+	for _, s := range subdirs {
+		e, err := ExpandVersion(s, p.Tag)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to check out %s", p.Source.Remote)
+		}
+		expanded = append(expanded, e)
+	}
+	return expanded, nil
+}
+
+// sparseCheckout restricts the checkout of the repository at dir to the
+// subdirs and the license files, if there are subdirs. It is configured once
+// the version is resolved, which version placeholders may depend on.
+func (p *GitPackage) sparseCheckout(ctx context.Context, dir string) error {
+	subdirs, err := p.subdirs()
+	if err != nil || len(subdirs) == 0 {
+		return err
+	}
+	if err := p.git(ctx, dir, "config", "core.sparseCheckout", "true"); err != nil {
+		return err
+	}
+	// The license files at the top of the repository are checked out as
+	// well, to tell the license of the subdir. They are not vendored.
+	pattern := ""
+	for _, s := range subdirs {
+		pattern += "/" + strings.Trim(s, "/") + "/\n"
+	}
+	pattern += "/LICENSE*\n/LICENCE*\n/COPYING*\n"
+	return ioutil.WriteFile(filepath.Join(dir, ".git", "info", "sparse-checkout"), []byte(pattern), 0644)
+}
+
+// fetchRef fetches version and returns what to check out. Fully-qualified
+// refs (refs/heads/main, refs/tags/v1.2.3) are fetched explicitly so they
+// are never confused with one another, while bare names are rejected if
+// they exist both as a branch and as a tag. Constraints are matched against
+// the tags of the remote, and no version at all means its default branch.
+func (p *GitPackage) fetchRef(ctx context.Context, dir, version string) (string, error) {
+	if version == "" {
+		branch, err := p.defaultBranch(ctx, dir)
+		if err != nil {
+			return "", err
+		}
+		version = branch
+	}
+
+	if semver.IsConstraint(version) {
+		return p.fetchConstraint(ctx, dir, version)
+	}
+
+	if strings.HasPrefix(version, "refs/") {
+		if err := p.fetch(ctx, dir, "--depth", "1", "origin", version); err != nil {
+			return "", errors.Wrapf(err, "failed to fetch %s from %s", version, p.Source.Remote)
+		}
+		if strings.HasPrefix(version, "refs/tags/") {
+			p.Tag = strings.TrimPrefix(version, "refs/tags/")
+		}
+		return "FETCH_HEAD", nil
+	}
+
+	refs, err := p.lsRemote(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+
+	branch := refs["refs/heads/"+version]
+	tag := refs["refs/tags/"+version]
+	switch {
+	case branch && tag:
+		return "", fmt.Errorf("version %s of %s is ambiguous, it is both a branch and a tag: use refs/heads/%s or refs/tags/%s", version, p.Source.Remote, version, version)
+	case branch:
+		ref := "refs/remotes/origin/" + version
+		if err := p.fetch(ctx, dir, "--depth", "1", "origin", "+refs/heads/"+version+":"+ref); err != nil {
+			return "", err
+		}
+		return ref, nil
+	case tag:
+		ref := "refs/tags/" + version
+		if err := p.fetch(ctx, dir, "--depth", "1", "origin", "+"+ref+":"+ref); err != nil {
+			return "", err
+		}
+		p.Tag = version
+		return ref, nil
+	}
+
+	// Anything else is most likely a commit.
+	if err := p.fetchCommit(ctx, dir, version); err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// fetchConstraint fetches the highest tag satisfying the version constraint
+// and records it in p.Tag.
+func (p *GitPackage) fetchConstraint(ctx context.Context, dir, version string) (string, error) {
+	c, err := semver.ParseConstraint(version)
+	if err != nil {
+		return "", err
+	}
+
+	refs, err := p.lsRemote(ctx, dir)
+	if err != nil {
+		return "", err
+	}
+	tags := []string{}
+	for ref := range refs {
+		if strings.HasPrefix(ref, "refs/tags/") {
+			tags = append(tags, strings.TrimPrefix(ref, "refs/tags/"))
+		}
+	}
+
+	tag, ok := c.Best(tags)
+	if !ok {
+		return "", &NotFoundError{Err: fmt.Errorf("no tag of %s satisfies version %s", p.Source.Remote, version)}
+	}
+
+	ref := "refs/tags/" + tag
+	if err := p.fetch(ctx, dir, "--depth", "1", "origin", "+"+ref+":"+ref); err != nil {
+		return "", err
+	}
+	p.Tag = tag
+	return ref, nil
+}
+
+// historyDepths are the depths the history of the branches and tags of a
+// repository is deepened to, one fetch each, when a commit is not found at
+// their tips, see fetchCommit.
+var historyDepths = []int{64, 1024}
+
+// fetchCommit makes sure commit is available in the repository at dir. Full
+// commit hashes can usually be fetched by themselves, anything else needs
+// the history of all branches and tags. The history is fetched in steps of
+// historyDepths before the rest of it, so that recent commits do not need
+// all of it and a retry after a failure keeps the steps fetched, see
+// Resumes.
+func (p *GitPackage) fetchCommit(ctx context.Context, dir, commit string) error {
+	if refExists(ctx, dir, commit+"^{commit}") {
+		return nil
+	}
+
+	if len(commit) == 40 && commitRegex.MatchString(commit) {
+		if err := p.fetch(ctx, dir, "--depth", "1", "origin", commit); err == nil {
+			return nil
+		}
+	}
+
+	refs := []string{"origin", "+refs/heads/*:refs/remotes/origin/*"}
+	// A complete history is never made shallow again.
+	if isShallow(dir) || !hasCommits(ctx, dir) {
+		for _, depth := range historyDepths {
+			if err := p.fetch(ctx, dir, append([]string{"--tags", "--depth", strconv.Itoa(depth)}, refs...)...); err != nil {
+				return err
+			}
+			if refExists(ctx, dir, commit+"^{commit}") {
+				return nil
+			}
+			if !isShallow(dir) {
+				break
+			}
+		}
+	}
+
+	args := []string{"--tags"}
+	if isShallow(dir) {
+		args = append(args, "--unshallow")
+	}
+	if err := p.fetch(ctx, dir, append(args, refs...)...); err != nil {
+		return err
+	}
+	if !refExists(ctx, dir, commit+"^{commit}") {
+		return &NotFoundError{Err: fmt.Errorf("version %s of %s is neither a branch, a tag nor a commit", commit, p.Source.Remote)}
+	}
+	return nil
+}
+
+func (p *GitPackage) fetch(ctx context.Context, dir string, args ...string) error {
+	cmd := p.remoteCommand(ctx, dir, append([]string{"fetch", "-q"}, args...)...)
+	return p.run(cmd)
+}
+
+// remote returns the remote to fetch from, the one of Source translated to
+// Transport or Prefer and rewritten by Mirrors.
+func (p *GitPackage) remote() string {
+	remote := p.Source.Remote
+	transport := p.Transport
+	if transport == "" && !p.preferFailed {
+		transport = p.Prefer
+	}
+	if translated, ok := TranslateRemote(remote, transport); ok {
+		remote = translated
+	}
+	return p.Mirrors.Rewrite(remote)
+}
+
+// preferred reports whether the remote is fetched over the Prefer transport
+// rather than as written, with the remote as written to fall back to.
+func (p *GitPackage) preferred() bool {
+	if p.Transport != "" || p.preferFailed {
+		return false
+	}
+	translated, ok := TranslateRemote(p.Source.Remote, p.Prefer)
+	return ok && translated != p.Source.Remote
+}
+
+// remoteCommand returns a git command talking to the remote, authenticated
+// if there are credentials for it, trusting the certificates of CAFile and
+// connecting to ssh remotes like SSHKey and SSHKnownHosts tell.
+func (p *GitPackage) remoteCommand(ctx context.Context, dir string, args ...string) *exec.Cmd {
+	auth, env := gitAuth(p.remote())
+	auth = append(auth, gitCAArgs(p.CAFile)...)
+	if ssh := sshEnv(p.remote(), p.SSHKey, p.SSHKnownHosts); len(ssh) > 0 {
+		if env == nil {
+			env = os.Environ()
+		}
+		env = append(env, ssh...)
+	}
+	cmd := exec.CommandContext(ctx, "git", append(auth, args...)...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Dir = dir
+	return cmd
+}
+
+// run runs cmd, a git command of the package, with its output streamed to
+// stderr if Verbose. Output that is not captured by the caller is kept and
+// its last line added to the error if the command fails, classified by
+// gitError. ssh refusing access to the remote is explained instead, see
+// sshAuthError.
+func (p *GitPackage) run(cmd *exec.Cmd) error {
+	out := bytes.NewBuffer(nil)
+	var w io.Writer = out
+	if p.Verbose {
+		w = io.MultiWriter(os.Stderr, out)
+	}
+	cmd.Stderr = w
+	if cmd.Stdout == nil {
+		cmd.Stdout = w
+	}
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); !p.Verbose && lines[len(lines)-1] != "" {
+		err = fmt.Errorf("%v: %s", err, lines[len(lines)-1])
+	}
+	err = gitError(err, out.String())
+	if auth, ok := err.(*AuthError); ok && isSSHRemote(p.remote()) {
+		if explained := sshAuthError(p.remote(), out.String(), p.SSHKey, p.SSHKnownHosts); explained != nil {
+			auth.Err = explained
+		}
+	}
+	return err
+}
+
+// git runs a local git command in dir, see run.
+func (p *GitPackage) git(ctx context.Context, dir string, args ...string) error {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Stdin = os.Stdin
+	cmd.Dir = dir
+	return p.run(cmd)
+}
+
+// progress reports stage to Progress, if set.
+func (p *GitPackage) progress(stage Stage) {
+	if p.Progress != nil {
+		p.Progress(stage)
+	}
+}
+
+// defaultBranch returns the branch HEAD of the remote points to.
+func (p *GitPackage) defaultBranch(ctx context.Context, dir string) (string, error) {
+	b := bytes.NewBuffer(nil)
+	cmd := p.remoteCommand(ctx, dir, "ls-remote", "--symref", "origin", "HEAD")
+	cmd.Stdout = b
+	if err := p.run(cmd); err != nil {
+		return "", errors.Wrapf(err, "failed to determine the default branch of %s", p.Source.Remote)
+	}
+
+	for _, line := range strings.Split(b.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "ref:" && fields[2] == "HEAD" && strings.HasPrefix(fields[1], "refs/heads/") {
+			return strings.TrimPrefix(fields[1], "refs/heads/"), nil
+		}
+	}
+	return "", fmt.Errorf("failed to determine the default branch of %s: HEAD is not a branch", p.Source.Remote)
+}
+
+// lsRemote returns the names of the branches and tags of the remote.
+func (p *GitPackage) lsRemote(ctx context.Context, dir string) (map[string]bool, error) {
+	b := bytes.NewBuffer(nil)
+	cmd := p.remoteCommand(ctx, dir, "ls-remote", "--heads", "--tags", "origin")
+	cmd.Stdout = b
+	if err := p.run(cmd); err != nil {
+		return nil, errors.Wrapf(err, "failed to list refs of %s", p.Source.Remote)
+	}
+
+	refs := map[string]bool{}
+	for _, line := range strings.Split(b.String(), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			refs[strings.TrimSuffix(fields[1], "^{}")] = true
+		}
+	}
+	return refs, nil
+}
+
+// remoteRefs returns the commits of the branches and tags of the remote,
+// by ref, and its default branch, without needing a repository. Annotated
+// tags map to the commit they point to. Without git, HTTP(S) remotes are
+// listed natively.
+func (p *GitPackage) remoteRefs(ctx context.Context) (refs map[string]string, head string, err error) {
+	if _, err := exec.LookPath("git"); err != nil && (strings.HasPrefix(p.remote(), "https://") || strings.HasPrefix(p.remote(), "http://")) {
+		return httpRefs(ctx, p.CAFile, p.remote())
+	}
+
+	b := bytes.NewBuffer(nil)
+	cmd := p.remoteCommand(ctx, "", "ls-remote", "--symref", p.remote())
+	cmd.Stdout = b
+	if err := p.run(cmd); err != nil {
+		return nil, "", errors.Wrapf(err, "failed to list refs of %s", p.Source.Remote)
+	}
+
+	refs = map[string]string{}
+	for _, line := range strings.Split(b.String(), "\n") {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 3 && fields[0] == "ref:" && fields[2] == "HEAD":
+			head = strings.TrimPrefix(fields[1], "refs/heads/")
+		case len(fields) == 2 && (strings.HasPrefix(fields[1], "refs/heads/") || strings.HasPrefix(fields[1], "refs/tags/")):
+			// Peeled tags are listed after the tag object.
+			refs[strings.TrimSuffix(fields[1], "^{}")] = fields[0]
+		}
+	}
+	return refs, head, nil
+}
+
+// sinceRef decides between the freshly resolved ref and the locked commit
+// for an update restricted to upstream changes newer than p.Since.
+func (p *GitPackage) sinceRef(ctx context.Context, dir, version, ref string) (string, error) {
+	if isPinned(ctx, dir, version) {
+		return p.Locked, nil
+	}
+
+	t, err := commitTime(ctx, dir, ref)
+	if err != nil {
+		return "", err
+	}
+	if t.After(p.Since) {
+		return ref, nil
+	}
+
+	p.Tag = ""
+	return p.Locked, nil
+}
+
+// isPinned reports whether version names a tag or a commit rather than a
+// branch, in which case there is nothing to update.
+func isPinned(ctx context.Context, dir, version string) bool {
+	if strings.HasPrefix(version, "refs/tags/") {
+		return true
+	}
+	if strings.HasPrefix(version, "refs/") {
+		return false
+	}
+	if refExists(ctx, dir, "refs/tags/"+version) {
+		return true
+	}
+
+	return commitRegex.MatchString(version) && !refExists(ctx, dir, "refs/remotes/origin/"+version)
+}
+
+func commitTime(ctx context.Context, dir, ref string) (time.Time, error) {
+	b := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, "git", "show", "-s", "--format=%ct", ref)
+	cmd.Stdout = b
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		return time.Time{}, err
+	}
+
+	sec, err := strconv.ParseInt(strings.TrimSpace(b.String()), 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse commit time of %s: %v", ref, err)
+	}
+
+	return time.Unix(sec, 0), nil
+}
+
+func refExists(ctx context.Context, dir, ref string) bool {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--quiet", ref)
+	cmd.Dir = dir
+	return cmd.Run() == nil
+}
+
+// isShallow reports whether the repository at dir lacks part of the
+// history of the commits it has.
+func isShallow(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git", "shallow"))
+	return err == nil
+}
+
+// hasCommits reports whether anything was fetched into the repository at
+// dir.
+func hasCommits(ctx context.Context, dir string) bool {
+	b := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, "git", "rev-list", "-n", "1", "--all")
+	cmd.Stdout = b
+	cmd.Dir = dir
+	return cmd.Run() == nil && strings.TrimSpace(b.String()) != ""
+}
@@ -0,0 +1,567 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+// testRepo is a local git repository used as a remote in tests, so that
+// the git code paths can be exercised without network access.
+type testRepo struct {
+	t   *testing.T
+	Dir string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	dir, err := ioutil.TempDir("", "jb-git-remote")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &testRepo{t: t, Dir: dir}
+	r.git("-c", "init.defaultBranch=master", "init", "-q")
+	return r
+}
+
+func (r *testRepo) git(args ...string) string {
+	args = append([]string{"-c", "user.name=jb", "-c", "user.email=jb@example.com"}, args...)
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.Dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes the given file and commits it, returning the commit hash.
+func (r *testRepo) commit(name, content string) string {
+	path := filepath.Join(r.Dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		r.t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		r.t.Fatal(err)
+	}
+	r.git("add", "-A")
+	r.git("commit", "-q", "-m", "update "+name)
+	return r.git("rev-parse", "HEAD")
+}
+
+func (r *testRepo) Close() {
+	os.RemoveAll(r.Dir)
+}
+
+func TestGitPackageInstallRefs(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+
+	tagged := repo.commit("main.libsonnet", "{ v: 1 }")
+	repo.git("tag", "-a", "-m", "v1.0.0", "v1.0.0")
+	repo.git("tag", "dup")
+
+	repo.git("checkout", "-q", "-b", "release")
+	release := repo.commit("main.libsonnet", "{ v: 2 }")
+	repo.git("branch", "dup")
+
+	repo.git("checkout", "-q", "master")
+	master := repo.commit("main.libsonnet", "{ v: 3 }")
+	// A pull request is neither a branch nor a tag of the repository.
+	repo.git("update-ref", "refs/pull/1/head", release)
+
+	testcases := []struct {
+		Name     string
+		Version  string
+		Expected string
+		Err      bool
+	}{
+		{Name: "BareBranch", Version: "master", Expected: master},
+		{Name: "BareTag", Version: "v1.0.0", Expected: tagged},
+		{Name: "Commit", Version: release, Expected: release},
+		{Name: "ShortCommit", Version: release[:7], Expected: release},
+		{Name: "QualifiedBranch", Version: "refs/heads/release", Expected: release},
+		{Name: "QualifiedTag", Version: "refs/tags/v1.0.0", Expected: tagged},
+		{Name: "QualifiedAmbiguousBranch", Version: "refs/heads/dup", Expected: release},
+		{Name: "QualifiedAmbiguousTag", Version: "refs/tags/dup", Expected: tagged},
+		{Name: "ShortAmbiguousBranch", Version: "heads/dup", Expected: release},
+		{Name: "ShortAmbiguousTag", Version: "tags/dup", Expected: tagged},
+		{Name: "PullRequest", Version: "refs/pull/1/head", Expected: release},
+		{Name: "Ambiguous", Version: "dup", Err: true},
+		{Name: "MissingRef", Version: "refs/heads/missing", Err: true},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			tempDir, err := ioutil.TempDir("", "jb-git-install")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			p := NewGitPackage(&spec.GitSource{Remote: repo.Dir})
+			lockVersion, err := p.Install(context.TODO(), filepath.Join(tempDir, "pkg"), tc.Version)
+			if tc.Err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Expected, lockVersion)
+		})
+	}
+}
+
+func TestGitPackageInstallSubmodules(t *testing.T) {
+	// Local submodules need the file protocol, which git only allows for
+	// submodules when told to.
+	defer setenv(map[string]string{
+		"GIT_CONFIG_COUNT":   "1",
+		"GIT_CONFIG_KEY_0":   "protocol.file.allow",
+		"GIT_CONFIG_VALUE_0": "always",
+	})()
+
+	sub := newTestRepo(t)
+	defer sub.Close()
+	subCommit := sub.commit("main.libsonnet", "'sub'")
+	other := newTestRepo(t)
+	defer other.Close()
+	other.commit("main.libsonnet", "'other'")
+
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("lib/main.libsonnet", "import 'sub/main.libsonnet'")
+	repo.git("submodule", "add", "-q", sub.Dir, "lib/sub")
+	repo.git("submodule", "add", "-q", other.Dir, "other")
+	repo.git("commit", "-q", "-m", "add submodules")
+
+	for _, submodules := range []bool{false, true} {
+		tempDir, err := ioutil.TempDir("", "jb-git-submodules")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		p := &GitPackage{Source: &spec.GitSource{Remote: repo.Dir, Subdir: "lib"}, Submodules: submodules}
+		_, err = p.Install(context.TODO(), tempDir, "master")
+		assert.NoError(t, err)
+
+		_, err = os.Stat(filepath.Join(tempDir, "lib", "sub", "main.libsonnet"))
+		assert.Equal(t, submodules, err == nil)
+		if !submodules {
+			assert.Nil(t, p.LockSubmodules("lib"))
+			continue
+		}
+
+		// Only the submodules of the subdir are checked out.
+		assert.Equal(t, map[string]string{"lib/sub": subCommit}, p.LockSubmodules("lib"))
+		_, err = os.Stat(filepath.Join(tempDir, "lib", "sub", ".git"))
+		assert.True(t, os.IsNotExist(err))
+		_, err = os.Stat(filepath.Join(tempDir, "other", "main.libsonnet"))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// The commits are locked, and kept for packages served from the cache.
+	tempDir, err := ioutil.TempDir("", "jb-git-submodules")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	dep := gitDependency("lib", repo.Dir, "master")
+	dep.Source.GitSource.Subdir = "lib"
+	dep.Submodules = true
+	lock := &spec.JsonnetFile{Dependencies: []spec.Dependency{dep}}
+	for _, filename := range []string{JsonnetFile, JsonnetLockFile} {
+		i := &Installer{JsonnetHome: filepath.Join(tempDir, filename, "vendor"), CacheDir: filepath.Join(tempDir, "cache")}
+		lock, err = i.Install(context.TODO(), filepath.Join(tempDir, filename, filename), *lock)
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, map[string]string{"lib/sub": subCommit}, lock.Dependencies[0].SubmoduleCommits)
+		_, err = os.Stat(filepath.Join(i.JsonnetHome, "lib", "sub", "main.libsonnet"))
+		assert.NoError(t, err)
+	}
+}
+
+func TestGitPackageInstallAsOf(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+
+	// Commits are dated by their committer date, like git log --before.
+	defer os.Unsetenv("GIT_COMMITTER_DATE")
+	commits := []string{}
+	for _, date := range []string{"2023-01-01T00:00:00Z", "2023-03-01T00:00:00Z", "2023-06-01T00:00:00Z"} {
+		os.Setenv("GIT_COMMITTER_DATE", date)
+		commits = append(commits, repo.commit("main.libsonnet", "{ date: '"+date+"' }"))
+	}
+	os.Unsetenv("GIT_COMMITTER_DATE")
+	repo.git("tag", "v1.0.0", commits[2])
+
+	asOf := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+	testcases := []struct {
+		Name     string
+		Version  string
+		AsOf     time.Time
+		Expected string
+		Err      bool
+	}{
+		{Name: "DefaultBranch", Version: "{2023-04-01}", Expected: commits[1]},
+		{Name: "Branch", Version: "master@{2023-02-01}", Expected: commits[0]},
+		{Name: "Time", Version: "master@{2023-03-01T00:00:00Z}", Expected: commits[1]},
+		{Name: "BeforeFirstCommit", Version: "{2022-01-01}", Err: true},
+		{Name: "Tag", Version: "v1.0.0@{2023-04-01}", Err: true},
+		{Name: "AsOfBranch", Version: "master", AsOf: asOf, Expected: commits[1]},
+		{Name: "AsOfTag", Version: "v1.0.0", AsOf: asOf, Expected: commits[2]},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			tempDir, err := ioutil.TempDir("", "jb-git-install")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			p := &GitPackage{Source: &spec.GitSource{Remote: repo.Dir}, AsOf: tc.AsOf}
+			lockVersion, err := p.Install(context.TODO(), filepath.Join(tempDir, "pkg"), tc.Version)
+			if tc.Err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Expected, lockVersion)
+		})
+	}
+}
+
+func TestGitPackageInstallHistory(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+
+	old := repo.commit("main.libsonnet", "{ v: 1 }")
+	for n := 0; n < historyDepths[0]+5; n++ {
+		repo.git("commit", "-q", "--allow-empty", "-m", strconv.Itoa(n))
+	}
+
+	tempDir, err := ioutil.TempDir("", "jb-git-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A short commit beyond the first step of the history.
+	p := NewGitPackage(&spec.GitSource{Remote: repo.Dir}).(*GitPackage)
+	lockVersion, err := p.Install(context.TODO(), filepath.Join(tempDir, "pkg"), old[:7])
+	assert.NoError(t, err)
+	assert.Equal(t, old, lockVersion)
+
+	// A retry continues from the repository of the failed attempt.
+	dir := filepath.Join(tempDir, "retry")
+	assert.True(t, p.Resumes())
+	assert.NoError(t, p.init(context.TODO(), dir))
+	assert.NoError(t, p.fetch(context.TODO(), dir, "--depth", "1", "origin", "master"))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "partial.libsonnet"), []byte("{"), 0644))
+	lockVersion, err = p.Install(context.TODO(), dir, old[:7])
+	assert.NoError(t, err)
+	assert.Equal(t, old, lockVersion)
+	_, err = os.Stat(filepath.Join(dir, "partial.libsonnet"))
+	assert.True(t, os.IsNotExist(err))
+	assert.FileExists(t, filepath.Join(dir, "main.libsonnet"))
+}
+
+func TestGitPackageInstallDefaultBranch(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("main.libsonnet", "{ v: 1 }")
+	repo.git("checkout", "-q", "-b", "main")
+	main := repo.commit("main.libsonnet", "{ v: 2 }")
+
+	tempDir, err := ioutil.TempDir("", "jb-git-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Without a version the branch HEAD of the remote points to is
+	// installed, not master.
+	p := NewGitPackage(&spec.GitSource{Remote: repo.Dir})
+	lockVersion, err := p.Install(context.TODO(), filepath.Join(tempDir, "pkg"), "")
+	assert.NoError(t, err)
+	assert.Equal(t, main, lockVersion)
+}
+
+func TestGitPackageInstallConstraint(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+
+	commits := map[string]string{}
+	for _, tag := range []string{"v1.0.0", "v1.2.0", "v1.2.5", "v1.3.0-rc.1", "v2.0.0", "v3.0.0-rc.1"} {
+		commits[tag] = repo.commit("main.libsonnet", tag)
+		repo.git("tag", tag)
+	}
+	repo.commit("main.libsonnet", "unreleased")
+
+	testcases := []struct {
+		Version string
+		Tag     string
+		Err     bool
+	}{
+		{Version: "~1.2", Tag: "v1.2.5"},
+		{Version: "^1.0.0", Tag: "v1.2.5"},
+		{Version: ">=1.0 <1.2", Tag: "v1.0.0"},
+		{Version: "^2", Tag: "v2.0.0"},
+		{Version: "^3", Err: true},
+		{Version: "latest", Tag: "v2.0.0"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Version, func(t *testing.T) {
+			tempDir, err := ioutil.TempDir("", "jb-git-install")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			p := &GitPackage{Source: &spec.GitSource{Remote: repo.Dir}}
+			lockVersion, err := p.Install(context.TODO(), filepath.Join(tempDir, "pkg"), tc.Version)
+			if tc.Err {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, commits[tc.Tag], lockVersion)
+			assert.Equal(t, tc.Tag, p.Tag)
+		})
+	}
+}
+
+func TestGitPackageInstallCommitMetadata(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("lib/main.libsonnet", "{}")
+	repo.git("tag", "v1.0.0")
+	tree := repo.git("rev-parse", "HEAD:lib")
+	date := repo.git("show", "-s", "--format=%ct", "HEAD")
+
+	tempDir, err := ioutil.TempDir("", "jb-git-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Tags installed by name are recorded like resolved constraints.
+	p := &GitPackage{Source: &spec.GitSource{Remote: repo.Dir, Subdir: "lib"}}
+	_, err = p.Install(context.TODO(), filepath.Join(tempDir, "pkg"), "v1.0.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1.0.0", p.Tag)
+
+	d, tr := p.LockCommit("lib")
+	assert.Equal(t, date, strconv.FormatInt(d.Unix(), 10))
+	assert.Equal(t, tree, tr)
+	_, tr = p.LockCommit("other")
+	assert.Empty(t, tr)
+}
+
+func TestGitPackageInstallSubdir(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("other/big.txt", "big")
+	commit := repo.commit("jsonnet/lib/main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-git-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dir := filepath.Join(tempDir, "pkg")
+	p := NewGitPackage(&spec.GitSource{Remote: "file://" + repo.Dir, Subdir: "jsonnet/lib"})
+	lockVersion, err := p.Install(context.TODO(), dir, "master")
+	assert.NoError(t, err)
+	assert.Equal(t, commit, lockVersion)
+
+	// Only the subdir is checked out.
+	exists, err := FileExists(filepath.Join(dir, "jsonnet", "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = FileExists(filepath.Join(dir, "other"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestInstallerAssets(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	binary := string([]byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n', 0x00, 0xff, '\r', '\n'})
+	repo.commit(".gitattributes", "* text=auto\n*.txt eol=crlf\n")
+	repo.commit("lib/fixtures/logo.png", binary)
+	repo.commit("lib/templates/page.txt", "line 1\nline 2\n")
+	repo.commit("lib/.schemas/dashboard.json", `{"type": "object"}`)
+	repo.commit("lib/gen.sh", "#!/bin/sh\n")
+	assert.NoError(t, os.Chmod(filepath.Join(repo.Dir, "lib", "gen.sh"), 0755))
+	repo.git("add", "-A")
+	repo.git("commit", "-q", "-m", "executable")
+	repo.commit("other/main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-git-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Both packages are copied from a shared clone.
+	lib := gitDependency("lib", repo.Dir, "master")
+	lib.Source.GitSource.Subdir = "lib"
+	other := gitDependency("other", repo.Dir, "master")
+	other.Source.GitSource.Subdir = "other"
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{lib, other}})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Files are vendored as committed, hidden ones included.
+	for name, content := range map[string]string{
+		"fixtures/logo.png":       binary,
+		"templates/page.txt":      "line 1\nline 2\n",
+		".schemas/dashboard.json": `{"type": "object"}`,
+	} {
+		b, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, "lib", filepath.FromSlash(name)))
+		assert.NoError(t, err)
+		assert.Equal(t, content, string(b), name)
+	}
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(filepath.Join(i.JsonnetHome, "lib", "gen.sh"))
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	}
+}
+
+// githubTarball returns a tarball of commit like GitHub serves it, created
+// by git archive.
+func githubTarball(t *testing.T, commit string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": commit}}))
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "bar-master/main.libsonnet", Mode: 0644, Size: 2}))
+	_, err := tw.Write([]byte("{}"))
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestGitPackageInstallTarball(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	tarball := githubTarball(t, commit)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/foo/bar/tar.gz/master" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(tarball)
+	}))
+	defer srv.Close()
+
+	defer func(url, path string) {
+		githubTarballURL = url
+		os.Setenv("PATH", path)
+	}(githubTarballURL, os.Getenv("PATH"))
+	githubTarballURL = srv.URL + "/%s/%s/tar.gz/%s"
+	os.Setenv("PATH", "")
+
+	tempDir, err := ioutil.TempDir("", "jb-git-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dir := filepath.Join(tempDir, "pkg")
+	p := NewGitPackage(&spec.GitSource{Remote: "https://github.com/foo/bar"})
+	lockVersion, err := p.Install(context.TODO(), dir, "master")
+	assert.NoError(t, err)
+	assert.Equal(t, commit, lockVersion)
+
+	exists, err := FileExists(filepath.Join(dir, "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	p = NewGitPackage(&spec.GitSource{Remote: "https://gitlab.com/foo/bar"})
+	_, err = p.Install(context.TODO(), dir, "master")
+	assert.Error(t, err)
+}
+
+func TestGitPackageInstallTarballCache(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	tarball := githubTarball(t, commit)
+	requests, downloads := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		downloads++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(tarball)
+	}))
+	defer srv.Close()
+
+	defer func(url string) {
+		githubTarballURL = url
+	}(githubTarballURL)
+	githubTarballURL = srv.URL + "/%s/%s/tar.gz/%s"
+
+	tempDir, err := ioutil.TempDir("", "jb-git-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Git is installed, but not used.
+	for _, name := range []string{"a", "b"} {
+		p := &GitPackage{
+			Source:       &spec.GitSource{Remote: "https://github.com/foo/bar"},
+			Tarballs:     true,
+			TarballCache: filepath.Join(tempDir, "cache"),
+		}
+		lockVersion, err := p.Install(context.TODO(), filepath.Join(tempDir, name), "master")
+		assert.NoError(t, err)
+		assert.Equal(t, commit, lockVersion)
+
+		exists, err := FileExists(filepath.Join(tempDir, name, "main.libsonnet"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, 1, downloads)
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"sort"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// Groups returns the groups of dep, spec.DefaultGroup if it lists none.
+func Groups(dep spec.Dependency) []string {
+	if len(dep.Groups) == 0 {
+		return []string{spec.DefaultGroup}
+	}
+	return dep.Groups
+}
+
+// InGroups reports whether dep is in one of groups, see Groups.
+func InGroups(dep spec.Dependency, groups []string) bool {
+	for _, g := range Groups(dep) {
+		for _, want := range groups {
+			if g == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// FilterGroups returns the dependencies of deps in one of groups, all of
+// them if groups is empty.
+func FilterGroups(deps []spec.Dependency, groups []string) []spec.Dependency {
+	if len(groups) == 0 {
+		return deps
+	}
+	res := []spec.Dependency{}
+	for _, d := range deps {
+		if InGroups(d, groups) {
+			res = append(res, d)
+		}
+	}
+	return res
+}
+
+// addGroups adds groups to those of the installed dependency name, in the
+// lock of the session as well once it is in it, and to the dependencies it
+// requires, which are not installed again when it is required once more.
+func (i *Installer) addGroups(name string, groups []string) {
+	set := map[string]bool{}
+	for _, g := range i.groups[name] {
+		set[g] = true
+	}
+	added := false
+	for _, g := range groups {
+		if !set[g] {
+			set[g] = true
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+	merged := make([]string, 0, len(set))
+	for g := range set {
+		merged = append(merged, g)
+	}
+	sort.Strings(merged)
+	i.groups[name] = merged
+
+	for n, d := range i.lock.Dependencies {
+		if d.Name == name {
+			i.lock.Dependencies[n].Groups = lockGroups(merged)
+		}
+	}
+	for _, r := range i.requires[name] {
+		i.addGroups(r, merged)
+	}
+}
+
+// lockGroups returns groups as the lock records them, nothing for only
+// spec.DefaultGroup, so that the lock of projects without groups does not
+// change.
+func lockGroups(groups []string) []string {
+	if len(groups) == 1 && groups[0] == spec.DefaultGroup {
+		return nil
+	}
+	return groups
+}
+
+// missingGroups returns the groups of dep, a dependency of the project,
+// that its lock l does not record.
+func missingGroups(dep, l spec.Dependency) []string {
+	missing := []string{}
+	for _, g := range Groups(dep) {
+		if !InGroups(l, []string{g}) {
+			missing = append(missing, g)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterGroups(t *testing.T) {
+	prod := gitDependency("prod", "https://github.com/foo/prod", "master")
+	dev := gitDependency("dev", "https://github.com/foo/dev", "master")
+	dev.Groups = []string{"dev"}
+	both := gitDependency("both", "https://github.com/foo/both", "master")
+	both.Groups = []string{"dev", "prod"}
+	deps := []spec.Dependency{prod, dev, both}
+
+	assert.Equal(t, deps, FilterGroups(deps, nil))
+	assert.Equal(t, []spec.Dependency{prod, both}, FilterGroups(deps, []string{"prod"}))
+	assert.Equal(t, []spec.Dependency{dev, both}, FilterGroups(deps, []string{"dev"}))
+	assert.Equal(t, []spec.Dependency{}, FilterGroups(deps, []string{"docs"}))
+}
+
+func TestInstallerGroups(t *testing.T) {
+	a, b, c := newTestRepo(t), newTestRepo(t), newTestRepo(t)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	jsonnetfile := func(deps ...spec.Dependency) string {
+		b, err := json.Marshal(spec.JsonnetFile{Dependencies: deps})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// a is required by the project and by b, which is only required for
+	// development, after a brought in c already.
+	a.commit(JsonnetFile, jsonnetfile(gitDependency("c", c.Dir, "master")))
+	b.commit(JsonnetFile, jsonnetfile(gitDependency("a", a.Dir, "master")))
+	c.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dev := gitDependency("b", b.Dir, "master")
+	dev.Groups = []string{"dev"}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("a", a.Dir, "master"), dev}}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	groups := map[string][]string{}
+	for _, d := range lock.Dependencies {
+		groups[d.Name] = d.Groups
+	}
+	assert.Equal(t, map[string][]string{"a": {"dev", "prod"}, "b": {"dev"}, "c": {"dev", "prod"}}, groups)
+	assert.NoError(t, CheckLock(m, *lock))
+
+	for _, tc := range []struct {
+		groups []string
+		names  []string
+	}{
+		{groups: []string{"prod"}, names: []string{"a", "c"}},
+		{groups: []string{"dev"}, names: []string{"a", "c", "b"}},
+	} {
+		i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Groups: tc.groups}
+		installed, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+		if !assert.NoError(t, err) {
+			continue
+		}
+		names := []string{}
+		for _, d := range installed.Dependencies {
+			names = append(names, d.Name)
+		}
+		assert.Equal(t, tc.names, names, "%v", tc.groups)
+	}
+
+	// Moving a dependency to another group requires installing it again.
+	dev.Groups = []string{"dev", "docs"}
+	m.Dependencies[1] = dev
+	assert.EqualError(t, CheckLock(m, *lock), "jsonnetfile.lock.json is out of sync with jsonnetfile.json: b is in the groups docs, but was not locked in them")
+}
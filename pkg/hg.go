@@ -0,0 +1,152 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+var (
+	// authHgRegex matches the messages of hg about missing or rejected
+	// credentials, and of ssh about unknown host keys.
+	authHgRegex = regexp.MustCompile(`(?i)authorization failed|HTTP Error 40[13]|http authorization required|permission denied \(publickey|host key verification failed`)
+	// notFoundHgRegex matches the messages of hg about repositories and
+	// revisions that do not exist.
+	notFoundHgRegex = regexp.MustCompile(`(?i)HTTP Error 404|unknown revision|repository .* not found|no suitable response from remote hg`)
+	// transientHgRegex matches the messages of hg about failures of the
+	// connection that may not happen again.
+	transientHgRegex = regexp.MustCompile(`(?i)abort: error:|connection (refused|reset)|timed out|HTTP Error 50[0234]|temporary failure in name resolution`)
+)
+
+// HgPackage installs a Mercurial repository with hg, which has to be
+// installed. Versions are branches, tags, bookmarks or changesets, the
+// default branch if empty, and are locked at the full changeset ID.
+type HgPackage struct {
+	Source *spec.HgSource
+
+	// CAFile holds the certificate authorities trusted for HTTPS remotes,
+	// see CAFileEnv. The system ones are trusted if it is empty.
+	CAFile string
+
+	// Mirrors rewrite the remote that is cloned. The lock file keeps the
+	// remote of Source.
+	Mirrors Mirrors
+
+	// Verbose streams the output of hg to stderr. Otherwise it is only
+	// reported as part of the error of a failing command.
+	Verbose bool
+}
+
+func NewHgPackage(source *spec.HgSource) *HgPackage {
+	return &HgPackage{
+		Source: source,
+	}
+}
+
+// Install clones the repository at version into dir, without its .hg
+// directory, and returns the changeset ID checked out.
+func (p *HgPackage) Install(ctx context.Context, dir, version string) (lockVersion string, err error) {
+	if semver.IsConstraint(version) {
+		return "", fmt.Errorf("version constraints like %s are not supported for Mercurial remotes, use a tag", version)
+	}
+	if version == "" {
+		version = "default"
+	}
+	if _, err := exec.LookPath("hg"); err != nil {
+		return "", fmt.Errorf("hg is needed to install %s, but it is not installed", p.Source.Remote)
+	}
+
+	args := []string{"clone", "--noninteractive", "--updaterev", version}
+	if p.CAFile != "" {
+		args = append(args, "--config", "web.cacerts="+p.CAFile)
+	}
+	if err := p.hg(ctx, append(args, p.Mirrors.Rewrite(p.Source.Remote), dir)...); err != nil {
+		return "", errors.Wrapf(err, "failed to clone %s at %s", p.Source.Remote, version)
+	}
+
+	node := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, "hg", "--repository", dir, "log", "--rev", ".", "--template", "{node}")
+	cmd.Stdout = node
+	if err := p.run(cmd); err != nil {
+		return "", errors.Wrapf(err, "failed to resolve %s of %s", version, p.Source.Remote)
+	}
+	lockVersion = strings.TrimSpace(node.String())
+	if !fullCommitRegex.MatchString(lockVersion) {
+		return "", fmt.Errorf("hg reported the invalid changeset ID %q for %s of %s", lockVersion, version, p.Source.Remote)
+	}
+
+	if err := os.RemoveAll(filepath.Join(dir, ".hg")); err != nil {
+		return "", err
+	}
+	return lockVersion, nil
+}
+
+// hg runs hg with args, see run.
+func (p *HgPackage) hg(ctx context.Context, args ...string) error {
+	return p.run(exec.CommandContext(ctx, "hg", args...))
+}
+
+// run runs cmd with the plain output of hg, which does not depend on the
+// configuration of the user. Failures are reported with the last line of
+// the output and classified by hgError.
+func (p *HgPackage) run(cmd *exec.Cmd) error {
+	cmd.Env = append(os.Environ(), "HGPLAIN=1")
+	out := bytes.NewBuffer(nil)
+	var w io.Writer = out
+	if p.Verbose {
+		w = io.MultiWriter(os.Stderr, out)
+	}
+	cmd.Stderr = w
+	if cmd.Stdout == nil {
+		cmd.Stdout = w
+	}
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); !p.Verbose && lines[len(lines)-1] != "" {
+		err = fmt.Errorf("%v: %s", err, lines[len(lines)-1])
+	}
+	return hgError(err, out.String())
+}
+
+// hgError classifies the failure err of an hg command by its output as an
+// AuthError, a NotFoundError or a transient NetworkError, like gitError.
+func hgError(err error, output string) error {
+	switch {
+	case err == nil:
+		return nil
+	case authHgRegex.MatchString(output):
+		return &AuthError{Err: err}
+	case notFoundHgRegex.MatchString(output):
+		return &NotFoundError{Err: err}
+	case transientHgRegex.MatchString(output):
+		return &RetryableError{Err: &NetworkError{Err: err}}
+	}
+	return err
+}
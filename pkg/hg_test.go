@@ -0,0 +1,171 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeHg is an hg cloning the revisions of a remote directory, which holds
+// the files of every revision in a directory named after it and its
+// changeset ID in a file next to it.
+const fakeHg = `#!/bin/sh
+case "$1" in
+clone)
+	rev=$4 remote=$5 dir=$6
+	if [ ! -d "$remote/$rev" ]; then
+		echo "abort: unknown revision '$rev'!" >&2
+		exit 255
+	fi
+	mkdir -p "$dir/.hg" && cp -R "$remote/$rev/." "$dir" && cp "$remote/$rev.node" "$dir/.hg/node"
+	;;
+--repository)
+	cat "$2/.hg/node"
+	;;
+esac
+`
+
+const (
+	hgDefaultNode = "0123456789abcdef0123456789abcdef01234567"
+	hgTagNode     = "89abcdef0123456789abcdef0123456789abcdef"
+)
+
+// newHgRemote puts fakeHg on the PATH and returns a remote with the
+// revisions default and 1.0, and a function restoring the PATH and
+// removing it.
+func newHgRemote(t *testing.T) (string, func()) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake hg is a shell script")
+	}
+	tempDir, err := ioutil.TempDir("", "jb-hg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, remote := filepath.Join(tempDir, "bin"), filepath.Join(tempDir, "remote")
+	files := map[string]string{
+		filepath.Join(bin, "hg"):                                  fakeHg,
+		filepath.Join(remote, "default", "lib", "main.libsonnet"): "{ v: 2 }",
+		filepath.Join(remote, "default.node"):                     hgDefaultNode,
+		filepath.Join(remote, "1.0", "lib", "main.libsonnet"):     "{ v: 1 }",
+		filepath.Join(remote, "1.0.node"):                         hgTagNode,
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restore := setenv(map[string]string{"PATH": bin + string(os.PathListSeparator) + os.Getenv("PATH")})
+	return remote, func() {
+		restore()
+		os.RemoveAll(tempDir)
+	}
+}
+
+func TestHgPackageInstall(t *testing.T) {
+	remote, cleanup := newHgRemote(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "jb-hg-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := NewHgPackage(&spec.HgSource{Remote: remote})
+	lockVersion, err := p.Install(context.TODO(), dir, "")
+	assert.NoError(t, err)
+	assert.Equal(t, hgDefaultNode, lockVersion)
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 2 }", string(content))
+	_, err = os.Stat(filepath.Join(dir, ".hg"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = p.Install(context.TODO(), filepath.Join(dir, "missing"), "2.0")
+	assert.EqualError(t, err, "failed to clone "+remote+" at 2.0: exit status 255: abort: unknown revision '2.0'!")
+
+	_, err = p.Install(context.TODO(), filepath.Join(dir, "constraint"), "^1.0")
+	assert.EqualError(t, err, "version constraints like ^1.0 are not supported for Mercurial remotes, use a tag")
+}
+
+func TestInstallerHg(t *testing.T) {
+	remote, cleanup := newHgRemote(t)
+	defer cleanup()
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), CacheDir: filepath.Join(tempDir, "cache")}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{{
+		Name:    "lib",
+		Source:  spec.Source{HgSource: &spec.HgSource{Remote: remote, Subdir: "lib"}},
+		Version: "1.0",
+	}}}
+
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 1)
+	assert.Equal(t, hgTagNode, lock.Dependencies[0].Version)
+	assert.NoError(t, CheckLock(m, *lock))
+
+	content, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", string(content))
+
+	// The locked changeset is served from the cache without hg.
+	os.RemoveAll(i.JsonnetHome)
+	defer setenv(map[string]string{"PATH": tempDir})()
+	lock, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+	assert.Equal(t, hgTagNode, lock.Dependencies[0].Version)
+}
+
+func TestHgError(t *testing.T) {
+	err := errors.New("exit status 255")
+	testcases := []struct {
+		Output string
+		Want   error
+	}{
+		{"abort: authorization failed", &AuthError{Err: err}},
+		{"abort: HTTP Error 403: Forbidden", &AuthError{Err: err}},
+		{"abort: HTTP Error 404: Not Found", &NotFoundError{Err: err}},
+		{"abort: unknown revision 'nope'!", &NotFoundError{Err: err}},
+		{"abort: error: Connection refused", &RetryableError{Err: &NetworkError{Err: err}}},
+		{"abort: destination 'lib' is not empty", err},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Output, func(t *testing.T) {
+			assert.Equal(t, tc.Want, hgError(err, tc.Output))
+		})
+	}
+	assert.Nil(t, hgError(nil, "abort: authorization failed"))
+}
@@ -0,0 +1,157 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// hookEnv are the variables of the environment hooks inherit. Everything
+// else, like credentials, is withheld from them, and HOME is their working
+// directory.
+var hookEnv = []string{"PATH", "SYSTEMROOT", "TMPDIR", "TEMP", "TMP"}
+
+// HookError is returned when a hook fails.
+type HookError struct {
+	// Package is the name of the package declaring the hook, empty for the
+	// hooks of the project.
+	Package string
+	Hook    string
+	Err     error
+}
+
+func (e *HookError) Error() string {
+	if e.Package == "" {
+		return fmt.Sprintf("hook %s failed: %v", e.Hook, e.Err)
+	}
+	return fmt.Sprintf("hook %s of %s failed: %v", e.Hook, e.Package, e.Err)
+}
+
+func (e *HookError) Cause() error {
+	return e.Err
+}
+
+// runPackageHooks runs the hooks of the package name, vendored at dir, see
+// runHooks. They work on a copy of the package, which replaces it once all
+// of them succeeded, so that a failing hook leaves nothing half-done.
+func (i *Installer) runPackageHooks(ctx context.Context, name, dir string) error {
+	m, err := LoadJsonnetfile(filepath.Join(dir, JsonnetFile))
+	if os.IsNotExist(err) || len(m.Hooks) == 0 {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !i.AllowHooks {
+		return i.runHooks(ctx, name, dir, m.Hooks)
+	}
+
+	tmp := filepath.Join(i.JsonnetHome, ".tmp")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create general tmp dir")
+	}
+	sandbox, err := ioutil.TempDir(tmp, "jsonnetpkg-hooks")
+	if err != nil {
+		return errors.Wrap(err, "failed to create tmp dir")
+	}
+	defer os.RemoveAll(sandbox)
+
+	work := filepath.Join(sandbox, "package")
+	if err := copyDir(dir, work); err != nil {
+		return errors.Wrap(err, "failed to copy package for its hooks")
+	}
+	if err := i.runHooks(ctx, name, work, m.Hooks); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return errors.Wrap(err, "failed to replace package with the output of its hooks")
+	}
+	return errors.Wrap(os.Rename(work, dir), "failed to replace package with the output of its hooks")
+}
+
+// runProjectHooks runs the hooks of the jsonnetfile in dir, the project
+// directory, see runHooks.
+func (i *Installer) runProjectHooks(ctx context.Context, dir string) error {
+	m, err := LoadJsonnetfile(filepath.Join(dir, JsonnetFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return i.runHooks(ctx, "", dir, m.Hooks)
+}
+
+// runHooks runs hooks with dir as working directory, name being the package
+// declaring them, empty for the project. Unless AllowHooks is set, the
+// hooks are only reported.
+func (i *Installer) runHooks(ctx context.Context, name, dir string, hooks []spec.Hook) error {
+	of := ""
+	if name != "" {
+		of = " of " + name
+	}
+	for n, h := range hooks {
+		hook := h.Name
+		if hook == "" {
+			hook = fmt.Sprintf("#%d", n+1)
+		}
+		if !i.AllowHooks {
+			color.Yellow(">>> Skipping hook %s%s, pass --allow-hooks to run it: %s\n", hook, of, strings.Join(h.Command, " "))
+			continue
+		}
+		if len(h.Command) == 0 {
+			return &HookError{Package: name, Hook: hook, Err: errors.New("no command")}
+		}
+
+		color.Green(">>> Running hook %s%s\n", hook, of)
+		if err := i.runHook(ctx, name, dir, h.Command); err != nil {
+			return &HookError{Package: name, Hook: hook, Err: err}
+		}
+	}
+	return nil
+}
+
+// runHook runs command in dir, without stdin and with only the variables of
+// hookEnv, JB_PACKAGE naming the package and JB_VENDOR the absolute vendor
+// directory. Commands like ./gen.sh are relative to dir, so that packages
+// can ship their own scripts.
+func (i *Installer) runHook(ctx context.Context, name, dir string, command []string) error {
+	vendor, err := filepath.Abs(i.JsonnetHome)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = []string{"HOME=" + dir, "JB_PACKAGE=" + name, "JB_VENDOR=" + vendor}
+	for _, key := range hookEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			cmd.Env = append(cmd.Env, key+"="+value)
+		}
+	}
+	return cmd.Run()
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallerHooks(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("hooks are tested with sh")
+	}
+
+	tempDir, err := ioutil.TempDir("", "jb-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	pkgfile := `{"dependencies": [], "hooks": [
+		{"name": "generate", "command": ["sh", "-c", "echo \"{ package: '$JB_PACKAGE', home: '$HOME' }\" > gen.libsonnet"]}
+	]}`
+	fetcher := FetcherFunc(func(dep spec.Dependency, from string) (Interface, error) {
+		return &memPackage{files: map[string]string{"main.libsonnet": "{}", JsonnetFile: pkgfile}}, nil
+	})
+
+	// The project runs a hook of its own once its dependency is vendored.
+	project := spec.JsonnetFile{
+		Dependencies: []spec.Dependency{gitDependency("foo", "https://example.com/foo", "1.0.0")},
+		Hooks:        []spec.Hook{{Command: []string{"sh", "-c", "cat \"$JB_VENDOR/foo/gen.libsonnet\" > project.libsonnet"}}},
+	}
+	filename := filepath.Join(tempDir, JsonnetFile)
+	b, err := json.Marshal(project)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(filename, b, 0644))
+	generated := filepath.Join(tempDir, "vendor", "foo", "gen.libsonnet")
+
+	// Hooks are not run unless allowed.
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Fetchers: []Fetcher{fetcher}}
+	_, err = i.Install(context.TODO(), filename, project)
+	assert.NoError(t, err)
+	exists, err := FileExists(generated)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	i.AllowHooks = true
+	_, err = i.Install(context.TODO(), filename, project)
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(generated)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "package: 'foo'")
+	assert.NotContains(t, string(content), os.Getenv("HOME"))
+	content, err = ioutil.ReadFile(filepath.Join(tempDir, "project.libsonnet"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "package: 'foo'")
+
+	// A failing hook leaves the package as it was vendored.
+	pkgfile = `{"dependencies": [], "hooks": [{"command": ["sh", "-c", "touch partial.libsonnet; exit 3"]}]}`
+	_, err = i.Install(context.TODO(), filename, project)
+	assert.EqualError(t, err, "hook #1 of foo failed: exit status 3")
+	exists, err = FileExists(filepath.Join(tempDir, "vendor", "foo", "partial.libsonnet"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,183 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imports
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// Import is a single import, importstr or importbin expression found in a
+// jsonnet file.
+type Import struct {
+	// File is the file containing the import.
+	File string
+	// Kind is one of import, importstr or importbin.
+	Kind string
+	// Path is the imported path as written in the file.
+	Path string
+	// Line is the 1-based line of the import in File.
+	Line int
+}
+
+var importRegex = regexp.MustCompile(`\b(import|importstr|importbin)\s*@?(?:'([^']*)'|"([^"]*)")`)
+
+// Parse returns the imports contained in the jsonnet source content. Imports
+// in line comments and /* ... */ block comments are ignored.
+func Parse(file string, content []byte) []Import {
+	res := []Import{}
+	inComment := false
+	for n, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !inComment && (strings.HasPrefix(trimmed, "//") || strings.HasPrefix(trimmed, "#")) {
+			continue
+		}
+
+		line, inComment = stripBlockComments(line, inComment)
+		for _, m := range importRegex.FindAllStringSubmatch(line, -1) {
+			path := m[2]
+			if path == "" {
+				path = m[3]
+			}
+			res = append(res, Import{File: file, Kind: m[1], Path: path, Line: n + 1})
+		}
+	}
+
+	return res
+}
+
+// stripBlockComments replaces the block comments of line with spaces. A
+// comment spanning several lines is continued if inComment is set, the
+// returned bool tells whether it continues on the next line.
+func stripBlockComments(line string, inComment bool) (string, bool) {
+	var b strings.Builder
+	for line != "" {
+		if inComment {
+			i := strings.Index(line, "*/")
+			if i < 0 {
+				return b.String(), true
+			}
+			b.WriteString(" ")
+			line, inComment = line[i+2:], false
+			continue
+		}
+
+		i := strings.Index(line, "/*")
+		if i < 0 {
+			b.WriteString(line)
+			break
+		}
+		b.WriteString(line[:i] + " ")
+		line, inComment = line[i+2:], true
+	}
+	return b.String(), inComment
+}
+
+// IsJsonnet reports whether path has a .jsonnet or .libsonnet extension.
+func IsJsonnet(path string) bool {
+	ext := filepath.Ext(path)
+	return ext == ".jsonnet" || ext == ".libsonnet"
+}
+
+// Scan walks dir and returns the imports of all jsonnet files in it.
+// Directories whose name is in skip, as well as hidden directories, are not
+// descended into.
+func Scan(dir string, skip ...string) ([]Import, error) {
+	skipped := map[string]bool{}
+	for _, s := range skip {
+		skipped[s] = true
+	}
+
+	res := []Import{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			name := info.Name()
+			if path != dir && (skipped[name] || strings.HasPrefix(name, ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !IsJsonnet(path) {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", path)
+		}
+		res = append(res, Parse(path, content)...)
+		return nil
+	})
+
+	return res, err
+}
+
+// Dependencies guesses the dependencies of a project from imports of paths
+// below vendorDir. The first path component below vendorDir is taken as the
+// dependency name. If the vendored path looks like host/org/repo, that path
+// is the name instead, so that the dependency is vendored where it is
+// imported from, and the remote is inferred. Otherwise the remote is left
+// empty for the user to fill in.
+func Dependencies(imports []Import, vendorDir string) []spec.Dependency {
+	prefix := strings.Trim(filepath.ToSlash(vendorDir), "/") + "/"
+
+	seen := map[string]bool{}
+	res := []spec.Dependency{}
+	for _, imp := range imports {
+		p := strings.TrimPrefix(imp.Path, "./")
+		if i := strings.Index(p, "/"+prefix); i >= 0 {
+			p = p[i+1:]
+		}
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+
+		segments := strings.Split(strings.TrimPrefix(p, prefix), "/")
+		if len(segments) < 2 {
+			continue
+		}
+
+		name, remote := segments[0], ""
+		if strings.Contains(segments[0], ".") && len(segments) > 3 {
+			name = strings.Join(segments[:3], "/")
+			remote = "https://" + strings.Join(segments[:3], "/")
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		res = append(res, spec.Dependency{
+			Name: name,
+			Source: spec.Source{
+				GitSource: &spec.GitSource{Remote: remote},
+			},
+			Version: "",
+		})
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+	return res
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imports_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/imports"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	content := `
+local k = import 'vendor/ksonnet/k.libsonnet';
+local s = importstr "files/script.sh";
+// local x = import 'commented/out.libsonnet';
+{ a: import @'verbatim.libsonnet', b: importbin 'blob.bin' }
+/* local y = import 'block/comment.libsonnet';
+   local z = import 'block/comment2.libsonnet'; */
+local w = /* import 'inline/comment.libsonnet' */ import 'after/comment.libsonnet';
+`
+	expected := []imports.Import{
+		{File: "main.jsonnet", Kind: "import", Path: "vendor/ksonnet/k.libsonnet", Line: 2},
+		{File: "main.jsonnet", Kind: "importstr", Path: "files/script.sh", Line: 3},
+		{File: "main.jsonnet", Kind: "import", Path: "verbatim.libsonnet", Line: 5},
+		{File: "main.jsonnet", Kind: "importbin", Path: "blob.bin", Line: 5},
+		{File: "main.jsonnet", Kind: "import", Path: "after/comment.libsonnet", Line: 8},
+	}
+
+	assert.Equal(t, expected, imports.Parse("main.jsonnet", []byte(content)))
+}
+
+func TestScanDependencies(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"main.jsonnet":               `(import 'vendor/ksonnet/k.libsonnet') + (import 'lib/local.libsonnet')`,
+		"lib/local.libsonnet":        `importstr '../vendor/github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet'`,
+		"lib/other.libsonnet":        `import 'vendor/ksonnet/util.libsonnet'`,
+		"vendor/ksonnet/k.libsonnet": `import 'vendor/should/not-be-scanned.libsonnet'`,
+		"notes.txt":                  `import 'vendor/ignored/file.libsonnet'`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	found, err := imports.Scan(tempDir, "vendor")
+	assert.NoError(t, err)
+	assert.Len(t, found, 4)
+
+	deps := imports.Dependencies(found, "vendor")
+	expected := []spec.Dependency{{
+		Name:    "github.com/grafana/grafonnet-lib",
+		Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/grafana/grafonnet-lib"}},
+		Version: "",
+	}, {
+		Name:    "ksonnet",
+		Source:  spec.Source{GitSource: &spec.GitSource{Remote: ""}},
+		Version: "",
+	}}
+	assert.Equal(t, expected, deps)
+	// Vendored under their names, the dependencies are where they are
+	// imported from, host-qualified ones included.
+	for _, imp := range found {
+		i := strings.Index(imp.Path, "vendor/")
+		if i < 0 {
+			continue
+		}
+		vendored := imp.Path[i+len("vendor/"):]
+		assert.True(t, strings.HasPrefix(vendored, deps[0].Name+"/") || strings.HasPrefix(vendored, deps[1].Name+"/"), imp.Path)
+	}
+
+	// The scaffolded file must be loadable despite placeholder remotes.
+	b, err := json.Marshal(spec.JsonnetFile{Dependencies: deps})
+	assert.NoError(t, err)
+	filename := filepath.Join(tempDir, pkg.JsonnetFile)
+	assert.NoError(t, ioutil.WriteFile(filename, b, 0644))
+	loaded, err := pkg.LoadJsonnetfile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, deps, loaded.Dependencies)
+}
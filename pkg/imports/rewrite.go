@@ -0,0 +1,188 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imports
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// Rewrite replaces the path of an import that does not resolve in the
+// current vendor layout by one that does.
+type Rewrite struct {
+	Import Import
+	Path   string
+}
+
+// Resolves reports whether imp can be found the way jsonnet looks for it:
+// relative to the importing file first, then in each directory of jpath.
+func Resolves(imp Import, jpath []string) bool {
+	return Resolve(imp, jpath) != ""
+}
+
+// Resolve returns the file imp imports, found the way Resolves looks for
+// it, or an empty string if it does not resolve.
+func Resolve(imp Import, jpath []string) string {
+	candidates := []string{imp.Path}
+	if !filepath.IsAbs(imp.Path) {
+		candidates = []string{filepath.Join(filepath.Dir(imp.File), imp.Path)}
+		for _, dir := range jpath {
+			candidates = append(candidates, filepath.Join(dir, imp.Path))
+		}
+	}
+
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && !info.IsDir() {
+			return c
+		}
+	}
+	return ""
+}
+
+// Aliases maps the prefixes under which the packages of a lock may be
+// imported in other vendor layouts to the prefix they have in the current
+// one. A package named lib from github.com/org/repo, subdir jsonnet/lib, is
+// vendored at lib, or at lib/jsonnet/lib when not flattened, and may have
+// been imported as github.com/org/repo/jsonnet/lib by other tools.
+func Aliases(deps []spec.Dependency) map[string]string {
+	aliases := map[string]string{}
+	for _, d := range deps {
+		if d.Source.GitSource == nil {
+			continue
+		}
+
+		subdir := strings.Trim(d.Source.GitSource.Subdir, "/")
+		current := d.Name
+		if d.Flatten != nil && !*d.Flatten && subdir != "" {
+			current = path.Join(d.Name, subdir)
+		}
+
+		candidates := []string{d.Name, path.Join(d.Name, subdir)}
+		if remote := remotePath(d.Source.GitSource.Remote); remote != "" {
+			candidates = append(candidates, path.Join(remote, subdir))
+		}
+		for _, c := range candidates {
+			if c != current {
+				aliases[c] = current
+			}
+		}
+	}
+	return aliases
+}
+
+// remotePath returns host/org/repo of a git remote, or "" if it does not
+// look like one.
+func remotePath(remote string) string {
+	for _, prefix := range []string{"https://", "http://", "ssh://", "git+ssh://"} {
+		remote = strings.TrimPrefix(remote, prefix)
+	}
+	remote = strings.TrimPrefix(remote, "git@")
+	remote = strings.Replace(remote, ":", "/", 1)
+	remote = strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+	if strings.Count(remote, "/") < 2 {
+		return ""
+	}
+	return remote
+}
+
+// Check sorts imports into those that can be rewritten to resolve by
+// replacing one of the aliases, and those that do not resolve at all.
+// Imports which already resolve are left out. The longest matching alias
+// wins. vendorDir is the name of the vendor directory, which may prefix
+// imports resolved relative to the importing file.
+func Check(imports []Import, aliases map[string]string, jpath []string, vendorDir string) ([]Rewrite, []Import) {
+	rewrites := []Rewrite{}
+	unresolved := []Import{}
+	for _, imp := range imports {
+		if Resolves(imp, jpath) {
+			continue
+		}
+
+		if p, ok := rewritePath(imp.Path, aliases, vendorDir); ok {
+			rewritten := imp
+			rewritten.Path = p
+			if Resolves(rewritten, jpath) {
+				rewrites = append(rewrites, Rewrite{Import: imp, Path: p})
+				continue
+			}
+		}
+		unresolved = append(unresolved, imp)
+	}
+	return rewrites, unresolved
+}
+
+func rewritePath(p string, aliases map[string]string, vendorDir string) (string, bool) {
+	// Keep a leading ../vendor/ and the like as is.
+	head, tail := "", p
+	marker := strings.Trim(filepath.ToSlash(vendorDir), "/") + "/"
+	if strings.HasPrefix(p, marker) {
+		head, tail = marker, strings.TrimPrefix(p, marker)
+	} else if i := strings.LastIndex(p, "/"+marker); i >= 0 {
+		head, tail = p[:i+1+len(marker)], p[i+1+len(marker):]
+	}
+
+	best := ""
+	for alias := range aliases {
+		if strings.HasPrefix(tail, alias+"/") && len(alias) > len(best) {
+			best = alias
+		}
+	}
+	if best == "" {
+		return "", false
+	}
+
+	return head + aliases[best] + strings.TrimPrefix(tail, best), true
+}
+
+// Apply writes rewrites to the files containing the imports.
+func Apply(rewrites []Rewrite) error {
+	byFile := map[string][]Rewrite{}
+	for _, r := range rewrites {
+		byFile[r.Import.File] = append(byFile[r.Import.File], r)
+	}
+
+	for file, rs := range byFile {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", file)
+		}
+
+		lines := strings.Split(string(content), "\n")
+		for _, r := range rs {
+			n := r.Import.Line - 1
+			if n < 0 || n >= len(lines) {
+				continue
+			}
+			for _, q := range []string{"'", `"`} {
+				lines[n] = strings.Replace(lines[n], q+r.Import.Path+q, q+r.Path+q, -1)
+			}
+		}
+
+		if err := ioutil.WriteFile(file, []byte(strings.Join(lines, "\n")), info.Mode()); err != nil {
+			return errors.Wrapf(err, "failed to write %s", file)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package imports_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/imports"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRewrite(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-imports")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"main.jsonnet": `local g = import 'github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet';
+local k = import "vendor/ksonnet/k.libsonnet";
+local n = import 'nested/jsonnet/lib/main.libsonnet';
+local m = import 'missing/main.libsonnet';
+local l = import 'lib/local.libsonnet';
+{}
+`,
+		"lib/local.libsonnet":                      `{}`,
+		"vendor/grafonnet/grafana.libsonnet":       `import 'missing.libsonnet'`,
+		"vendor/ksonnet/k.libsonnet":               `{}`,
+		"vendor/nested/main.libsonnet":             `{}`,
+		"vendor/unflattened/sub/dir/lib.libsonnet": `{}`,
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	flatten := false
+	aliases := imports.Aliases([]spec.Dependency{{
+		Name:   "grafonnet",
+		Source: spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/grafana/grafonnet-lib", Subdir: "grafonnet"}},
+	}, {
+		Name:   "nested",
+		Source: spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/org/nested.git", Subdir: "jsonnet/lib"}},
+	}, {
+		Name:    "unflattened",
+		Source:  spec.Source{GitSource: &spec.GitSource{Remote: "git@github.com:org/unflattened.git", Subdir: "sub/dir"}},
+		Flatten: &flatten,
+	}})
+	assert.Equal(t, map[string]string{
+		"grafonnet/grafonnet":                        "grafonnet",
+		"github.com/grafana/grafonnet-lib/grafonnet": "grafonnet",
+		"nested/jsonnet/lib":                         "nested",
+		"github.com/org/nested/jsonnet/lib":          "nested",
+		"unflattened":                                "unflattened/sub/dir",
+		"github.com/org/unflattened/sub/dir":         "unflattened/sub/dir",
+	}, aliases)
+
+	jpath := []string{filepath.Join(tempDir, "vendor")}
+	found, err := imports.Scan(tempDir, "vendor")
+	assert.NoError(t, err)
+
+	main := filepath.Join(tempDir, "main.jsonnet")
+	rewrites, unresolved := imports.Check(found, aliases, jpath, "vendor")
+	assert.Equal(t, []imports.Rewrite{
+		{Import: imports.Import{File: main, Kind: "import", Path: "github.com/grafana/grafonnet-lib/grafonnet/grafana.libsonnet", Line: 1}, Path: "grafonnet/grafana.libsonnet"},
+		{Import: imports.Import{File: main, Kind: "import", Path: "nested/jsonnet/lib/main.libsonnet", Line: 3}, Path: "nested/main.libsonnet"},
+	}, rewrites)
+	assert.Equal(t, []imports.Import{
+		{File: main, Kind: "import", Path: "missing/main.libsonnet", Line: 4},
+	}, unresolved)
+
+	assert.NoError(t, imports.Apply(rewrites))
+	b, err := ioutil.ReadFile(main)
+	assert.NoError(t, err)
+	assert.Equal(t, `local g = import 'grafonnet/grafana.libsonnet';
+local k = import "vendor/ksonnet/k.libsonnet";
+local n = import 'nested/main.libsonnet';
+local m = import 'missing/main.libsonnet';
+local l = import 'lib/local.libsonnet';
+{}
+`, string(b))
+
+	// Vendored packages are checked the same way.
+	vendored, err := imports.Scan(filepath.Join(tempDir, "vendor"))
+	assert.NoError(t, err)
+	_, unresolved = imports.Check(vendored, nil, jpath, "vendor")
+	assert.Len(t, unresolved, 1)
+	assert.Equal(t, "missing.libsonnet", unresolved[0].Path)
+}
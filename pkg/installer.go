@@ -0,0 +1,337 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// Installer vendors jsonnet dependencies. It is the programmatic equivalent
+// of the jb install and update commands and can be used to embed
+// jsonnet-bundler into other tools. JsonnetHome, Jobs, CacheDir and Offline
+// correspond to the --jsonnetpkg-home, --jobs, --cache-dir and --offline
+// flags of jb.
+type Installer struct {
+	// JsonnetHome is the directory packages are vendored into.
+	JsonnetHome string
+
+	// PreserveSubdirs vendors the subdir of a dependency with its
+	// intermediate directories (vendor/<name>/<subdir>) instead of placing
+	// its contents directly in vendor/<name>. Dependencies specifying
+	// Flatten override this.
+	PreserveSubdirs bool
+
+	// Disambiguate renames dependencies whose names collide with a
+	// dependency from a different source, instead of failing the install.
+	// See DisambiguateNames.
+	Disambiguate bool
+
+	// Renamed, if set, is told about every dependency renamed by
+	// Disambiguate, for example to report it.
+	Renamed func(Rename)
+
+	// QualifiedNames vendors git dependencies with the short names of the
+	// legacy layout under their qualified names instead, see QualifyNames
+	// and spec.QualifiedVersion.
+	QualifiedNames bool
+
+	// IncludeFrozen updates the frozen dependencies of the jsonnetfile as
+	// well, which Update keeps at their locked version otherwise.
+	IncludeFrozen bool
+
+	// Since restricts Update to dependencies whose upstream commit is newer
+	// than the given time. All other dependencies keep the version from the
+	// existing lock file.
+	Since time.Time
+
+	// AsOf resolves git dependencies on branches, the default branch
+	// included, to the last commit of the branch before the given time,
+	// see GitPackage.
+	AsOf time.Time
+
+	// Conflicts decides which version is installed when a dependency is
+	// required at different versions. It defaults to ConflictFail.
+	Conflicts ConflictStrategy
+
+	// Jobs is the number of dependencies downloaded concurrently. Values
+	// below 1 download one dependency at a time.
+	Jobs int
+
+	// Timeout limits the time the download of a single dependency may
+	// take, so that a hanging git remote fails the install instead of
+	// blocking it. There is no limit if it is zero.
+	Timeout time.Duration
+
+	// Retries is the number of times the download of a dependency is tried
+	// again after failing with a RetryableError, waiting RetryDelay before
+	// the first retry and twice as long before every next one.
+	Retries    int
+	RetryDelay time.Duration
+
+	// MaxRate limits the downloads of archives, release assets, tarballs
+	// and OCI artifacts to this many bytes per second, all downloads
+	// together. Git clones are not limited. There is no limit if it is
+	// zero.
+	MaxRate int64
+
+	// CacheDir is the directory of a Cache shared by all projects, see
+	// Cache. Packages are not cached if it is empty.
+	CacheDir string
+
+	// Offline installs without touching the network: git and Mercurial
+	// packages are served from the Cache of CacheDir at the commits of the
+	// lock, local packages as usual. Anything else, like a commit missing
+	// from the cache, a version that is not locked or another source,
+	// fails with an OfflineError. Fetchers are asked as usual.
+	Offline bool
+
+	// StoreDir is the directory of a Store shared by all projects, which
+	// the vendored packages are hard linked from instead of being copies.
+	// Packages are copied if it is empty, or if Reproducible is set.
+	StoreDir string
+
+	// LockTimeout limits how long an install waits for another jb process
+	// holding the lock of the vendor directory, the cache or the store, so
+	// that concurrent runs do not corrupt them. There is no limit if it is
+	// zero.
+	LockTimeout time.Duration
+
+	// CAFile is a PEM file of the certificate authorities trusted for
+	// HTTPS instead of the system ones, see CAFileEnv.
+	CAFile string
+	// SSHKey and SSHKnownHosts configure the connections to ssh remotes,
+	// see GitPackage.
+	SSHKey        string
+	SSHKnownHosts string
+
+	// Mirrors rewrite the remotes and URLs packages are fetched from, for
+	// example to an internal mirror of GitHub. The lock file records the
+	// original ones.
+	Mirrors Mirrors
+
+	// Prefer fetches git remotes over a transport, https or ssh, if written
+	// in the other form, falling back to the remote as written if it fails,
+	// see GitPackage. Dependencies pinned to a transport keep theirs.
+	Prefer string
+
+	// GitHubTarballs downloads GitHub repositories as tarballs instead of
+	// cloning them, see GitPackage. The tarballs are kept in CacheDir.
+	GitHubTarballs bool
+
+	// Strip lists the exclude patterns, see MatchExclude, of the files
+	// removed from every vendored package but linked ones, DefaultStrip if
+	// nil. StripDocs removes the DocFiles as well. The lock records them,
+	// a package is verified against a lock stripped differently as it was
+	// stripped back then.
+	Strip     []string
+	StripDocs bool
+
+	// Reproducible normalizes the modes and modification times of the
+	// vendor directory once everything was installed, so that installs of
+	// the same lock produce identical trees, for example for build caches
+	// keyed by their contents. Files are dated to the commit they were
+	// installed from, see ReproducibleTime.
+	Reproducible bool
+
+	// Verbose streams the output of git to stderr instead of only reporting
+	// it when git fails.
+	Verbose bool
+
+	// Progress, if set, is told about every package reaching a Stage, for
+	// example to show a progress bar.
+	Progress ProgressFunc
+
+	// AllowHooks runs the hooks of the installed packages and of the
+	// project, see spec.Hook. Otherwise they are only reported.
+	AllowHooks bool
+
+	// Policy, if set, rejects the dependencies it does not allow before
+	// they are vendored, see Policy.
+	Policy *Policy
+
+	// Prune removes the packages of JsonnetHome that are not part of the
+	// installed lock, once everything was installed successfully.
+	Prune bool
+
+	// DryRun resolves and fetches the dependencies like an install, but
+	// vendors them into a copy of JsonnetHome that is thrown away: the
+	// vendor directory stays as it was, nothing is pruned and no hooks
+	// run. The returned lock is the one the install would have produced.
+	DryRun bool
+
+	// CheckLock, if set, is called with the resolved lock once everything
+	// was vendored into the staging copy of JsonnetHome, before it replaces
+	// the vendor directory. An error fails the install and leaves the vendor
+	// directory as it was, unless it is a symlink or holds a jsonnetfile,
+	// which are installed into in place.
+	CheckLock func(lock spec.JsonnetFile) error
+
+	// Groups restricts the dependencies of the project that are installed
+	// to those in one of the groups, see spec.Dependency.Groups, along with
+	// everything they depend on. All of them are installed if it is empty.
+	Groups []string
+
+	// Fetchers are asked for the package of a dependency before the
+	// built-in git, release asset, archive and local sources, so that
+	// embedding tools can fetch dependencies their own way.
+	Fetchers []Fetcher
+
+	// locked holds the previous lock, by dependency name, during an Update
+	// restricted by Since, to specific packages or with frozen ones.
+	locked map[string]spec.Dependency
+	// only holds the names of the packages selected for an Update, frozen
+	// the names of the frozen ones it keeps.
+	only   map[string]bool
+	frozen map[string]bool
+	// installed holds the requirements installed so far, by name.
+	installed map[string]Requirement
+	// lock collects the dependencies installed so far.
+	lock *spec.JsonnetFile
+	// groups holds the groups of the dependencies installed so far, those
+	// of the dependencies of the project requiring them, by name.
+	groups map[string][]string
+	// requires holds the names of the dependencies each installed
+	// dependency requires, for their groups.
+	requires map[string][]string
+	// trustedKeys holds the files of the keys of the project that
+	// dependencies to verify must be signed with.
+	trustedKeys []string
+	// lockedStrip holds the Strip of the lock file the digests of locked
+	// and Update are recorded in.
+	lockedStrip []string
+	// lockedSparse holds the Sparse of that lock file, whose digests cover
+	// the files it lists for each package.
+	lockedSparse bool
+	// replace holds the replacements of the project, applied to the
+	// dependencies of every package, and replaceDir its directory, which
+	// the local directories replacing them are relative to.
+	replace    []spec.Replace
+	replaceDir string
+	// jobs holds a token for every running download.
+	jobs chan struct{}
+}
+
+// Install vendors the dependencies of m, which was read from the file
+// filename. When filename is a lock file the locked versions are installed
+// as they are, otherwise they are resolved and the dependencies of the
+// installed packages are installed as well. The returned lock describes
+// everything that was vendored.
+func (i *Installer) Install(ctx context.Context, filename string, m spec.JsonnetFile) (*spec.JsonnetFile, error) {
+	if err := os.MkdirAll(i.JsonnetHome, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "failed to create jsonnet home path")
+	}
+
+	isLock := filepath.Base(filename) == JsonnetLockFile
+	return i.install(ctx, isLock, filename, m)
+}
+
+// Update re-resolves the dependencies of m, which was read from the file
+// filename. If packages are given, only the dependencies with these names
+// are re-resolved and all others are installed at the version of the lock
+// file next to filename. Without packages all dependencies are re-resolved,
+// unless Since is set, in which case the lock file is consulted to keep
+// dependencies without recent upstream changes at their locked version.
+// Frozen dependencies of m stay at their locked version unless
+// IncludeFrozen is set, giving them as packages fails.
+func (i *Installer) Update(ctx context.Context, filename string, m spec.JsonnetFile, packages ...string) (*spec.JsonnetFile, error) {
+	if err := os.MkdirAll(i.JsonnetHome, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "failed to create jsonnet home path")
+	}
+
+	if i.Since.IsZero() && len(packages) == 0 && (i.IncludeFrozen || !hasFrozen(m.Dependencies)) {
+		return i.install(ctx, false, filename, m)
+	}
+
+	lockfile := filepath.Join(filepath.Dir(filename), JsonnetLockFile)
+	lock, err := LoadJsonnetfile(lockfile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to load lock file")
+	}
+
+	if i.QualifiedNames {
+		m.Dependencies = QualifyNames(m.Dependencies)
+		lock.Dependencies = QualifyNames(lock.Dependencies)
+	}
+
+	u := *i
+	u.lockedStrip = lock.Strip
+	u.lockedSparse = lock.Sparse
+	u.locked = make(map[string]spec.Dependency, len(lock.Dependencies))
+	for _, d := range lock.Dependencies {
+		u.locked[d.Name] = d
+	}
+
+	if len(packages) > 0 {
+		u.only = make(map[string]bool, len(packages))
+		for _, name := range packages {
+			if !hasDependency(m.Dependencies, name) && !hasDependency(lock.Dependencies, name) {
+				return nil, fmt.Errorf("package %s is neither a dependency in %s nor in %s", name, filename, lockfile)
+			}
+			u.only[name] = true
+		}
+	}
+
+	if !i.IncludeFrozen {
+		u.frozen = map[string]bool{}
+		for _, d := range m.Dependencies {
+			if !d.Frozen {
+				continue
+			}
+			if u.only[d.Name] {
+				return nil, fmt.Errorf("package %s is frozen in %s, it is only updated together with the frozen packages", d.Name, filename)
+			}
+			u.frozen[d.Name] = true
+			if _, ok := u.locked[d.Name]; ok {
+				color.Yellow(">>> Keeping frozen %s at its locked version\n", d.Name)
+			}
+		}
+	}
+
+	return u.install(ctx, false, filename, m)
+}
+
+// keepLocked reports whether the dependency name is excluded from an Update
+// of specific packages, or frozen, and therefore stays at its locked
+// version.
+func (i *Installer) keepLocked(name string) bool {
+	return i.only != nil && !i.only[name] || i.frozen[name]
+}
+
+// hasFrozen reports whether any of deps is frozen.
+func hasFrozen(deps []spec.Dependency) bool {
+	for _, d := range deps {
+		if d.Frozen {
+			return true
+		}
+	}
+	return false
+}
+
+func hasDependency(deps []spec.Dependency, name string) bool {
+	for _, d := range deps {
+		if d.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,623 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func gitDependency(name, remote, version string) spec.Dependency {
+	return spec.Dependency{
+		Name:    name,
+		Source:  spec.Source{GitSource: &spec.GitSource{Remote: remote}},
+		Version: version,
+	}
+}
+
+func TestInstallerInstallAndUpdate(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	first := repo.commit("main.libsonnet", "{ v: 1 }")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	i := &Installer{
+		JsonnetHome: filepath.Join(tempDir, "vendor"),
+		Jobs:        4,
+		CacheDir:    filepath.Join(tempDir, "cache"),
+	}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, "master")}}
+
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 1)
+	assert.Equal(t, first, lock.Dependencies[0].Version)
+
+	// The lock can be installed offline elsewhere from the cache.
+	offline := *i
+	offline.JsonnetHome = filepath.Join(tempDir, "offline")
+	offline.Offline = true
+	_, err = offline.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(filepath.Join(offline.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", string(content))
+
+	content, err = ioutil.ReadFile(filepath.Join(i.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", string(content))
+
+	second := repo.commit("main.libsonnet", "{ v: 2 }")
+
+	// Installing from the lock keeps the pinned commit.
+	lock, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+	assert.Equal(t, first, lock.Dependencies[0].Version)
+
+	lock, err = i.Update(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Equal(t, second, lock.Dependencies[0].Version)
+
+	content, err = ioutil.ReadFile(filepath.Join(i.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 2 }", string(content))
+}
+
+func TestInstallerUpdateSince(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	first := repo.commit("main.libsonnet", "{ v: 1 }")
+	repo.git("tag", "v1")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("floating", repo.Dir, "master"),
+		gitDependency("pinned", repo.Dir, "v1"),
+	}}
+	lock := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("floating", repo.Dir, first),
+		gitDependency("pinned", repo.Dir, first),
+	}}
+	b, err := json.Marshal(lock)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(tempDir, JsonnetLockFile), b, 0644)
+	assert.NoError(t, err)
+
+	// Move the tag as well, a pinned dependency must not follow it.
+	second := repo.commit("main.libsonnet", "{ v: 2 }")
+	repo.git("tag", "-f", "v1")
+
+	versions := func(lock *spec.JsonnetFile) map[string]string {
+		res := map[string]string{}
+		for _, d := range lock.Dependencies {
+			res[d.Name] = d.Version
+		}
+		return res
+	}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Since: time.Now().Add(time.Hour)}
+	res, err := i.Update(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"floating": first, "pinned": first}, versions(res))
+
+	i.Since = time.Now().Add(-time.Hour)
+	res, err = i.Update(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"floating": second, "pinned": first}, versions(res))
+}
+
+func TestInstallerFlatten(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("a/b/lib/main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dep := gitDependency("lib", repo.Dir, "master")
+	dep.Source.GitSource.Subdir = "a/b/lib"
+	noFlatten := false
+	preserved := dep
+	preserved.Name = "preserved"
+	preserved.Flatten = &noFlatten
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{dep, preserved}}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+
+	exists, err := FileExists(filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = FileExists(filepath.Join(i.JsonnetHome, "preserved", "a", "b", "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	flatten := true
+	assert.Equal(t, &flatten, lock.Dependencies[0].Flatten)
+	assert.Equal(t, &noFlatten, lock.Dependencies[1].Flatten)
+
+	// The lock keeps its layout when the default changes.
+	i.PreserveSubdirs = true
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+	exists, err = FileExists(filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = FileExists(filepath.Join(i.JsonnetHome, "preserved", "a", "b", "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Locks without a recorded layout were flattened.
+	lock.Dependencies[0].Flatten = nil
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+	exists, err = FileExists(filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// The installer wide setting applies to dependencies without their own.
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{dep}})
+	assert.NoError(t, err)
+	exists, err = FileExists(filepath.Join(i.JsonnetHome, "lib", "a", "b", "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestInstallerUpdatePackages(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	first := repo.commit("main.libsonnet", "{ v: 1 }")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("foo", repo.Dir, "master"),
+		gitDependency("bar", repo.Dir, "master"),
+	}}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	b, err := json.Marshal(lock)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(tempDir, JsonnetLockFile), b, 0644)
+	assert.NoError(t, err)
+
+	second := repo.commit("main.libsonnet", "{ v: 2 }")
+
+	res, err := i.Update(context.TODO(), filepath.Join(tempDir, JsonnetFile), m, "bar")
+	assert.NoError(t, err)
+	assert.Equal(t, first, res.Dependencies[0].Version)
+	assert.Equal(t, second, res.Dependencies[1].Version)
+
+	_, err = i.Update(context.TODO(), filepath.Join(tempDir, JsonnetFile), m, "unknown")
+	assert.Error(t, err)
+}
+
+func TestInstallerUpdateFrozen(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	first := repo.commit("main.libsonnet", "{ v: 1 }")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	frozen := gitDependency("foo", repo.Dir, "master")
+	frozen.Frozen = true
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{frozen, gitDependency("bar", repo.Dir, "master")}}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	b, err := json.Marshal(lock)
+	assert.NoError(t, err)
+	err = ioutil.WriteFile(filepath.Join(tempDir, JsonnetLockFile), b, 0644)
+	assert.NoError(t, err)
+
+	second := repo.commit("main.libsonnet", "{ v: 2 }")
+
+	res, err := i.Update(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Equal(t, first, res.Dependencies[0].Version)
+	assert.Equal(t, second, res.Dependencies[1].Version)
+	assert.False(t, res.Dependencies[0].Frozen)
+
+	_, err = i.Update(context.TODO(), filepath.Join(tempDir, JsonnetFile), m, "foo")
+	assert.Error(t, err)
+
+	i.IncludeFrozen = true
+	res, err = i.Update(context.TODO(), filepath.Join(tempDir, JsonnetFile), m, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, second, res.Dependencies[0].Version)
+}
+
+func TestInstallerMoved(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	first := repo.commit("main.libsonnet", "{ v: 1 }")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, "master")}}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+
+	// The locked commit is force-pushed away and garbage collected.
+	repo.git("commit", "-q", "--amend", "-m", "rewritten")
+	repo.git("reflog", "expire", "--expire=now", "--all")
+	repo.git("gc", "-q", "--prune=now")
+
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	moved, ok := IsMoved(err)
+	if assert.True(t, ok, "%v", err) {
+		assert.Equal(t, "foo", moved.Name)
+		assert.Equal(t, first, moved.Version)
+		assert.IsType(t, &NotFoundError{}, moved.Err)
+	}
+
+	// A commit pinned in the jsonnetfile did not move, it does not exist.
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, first)}})
+	_, ok = IsMoved(err)
+	assert.False(t, ok)
+}
+
+func TestInstallerTransitive(t *testing.T) {
+	a, b, c := newTestRepo(t), newTestRepo(t), newTestRepo(t)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	jsonnetfile := func(deps ...spec.Dependency) string {
+		b, err := json.Marshal(spec.JsonnetFile{Dependencies: deps})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	// a and b depend on each other and both depend on c.
+	a.commit(JsonnetFile, jsonnetfile(gitDependency("b", b.Dir, "master"), gitDependency("c", c.Dir, "master")))
+	b.commit(JsonnetFile, jsonnetfile(gitDependency("a", a.Dir, "master"), gitDependency("c", c.Dir, "master")))
+	c.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("a", a.Dir, "master")}}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+
+	names := []string{}
+	for _, d := range lock.Dependencies {
+		names = append(names, d.Name)
+	}
+	assert.Equal(t, []string{"a", "b", "c"}, names)
+
+	for _, name := range names {
+		exists, err := FileExists(filepath.Join(i.JsonnetHome, name))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+}
+
+func TestInstallerJobs(t *testing.T) {
+	m := spec.JsonnetFile{}
+	names := []string{}
+	for _, name := range []string{"e", "d", "c", "b", "a"} {
+		repo := newTestRepo(t)
+		defer repo.Close()
+		repo.commit("main.libsonnet", "{}")
+
+		m.Dependencies = append(m.Dependencies, gitDependency(name, repo.Dir, "master"))
+		names = append(names, name)
+	}
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The lock lists the dependencies in order, no matter which download
+	// finishes first.
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Jobs: 3}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+
+	installed := []string{}
+	for _, d := range lock.Dependencies {
+		installed = append(installed, d.Name)
+	}
+	assert.Equal(t, names, installed)
+
+	for _, name := range names {
+		exists, err := FileExists(filepath.Join(i.JsonnetHome, name, "main.libsonnet"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	// A failing download fails the install without leaving temporary
+	// directories behind.
+	m.Dependencies = append(m.Dependencies, gitDependency("broken", filepath.Join(tempDir, "missing"), "master"))
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.Error(t, err)
+
+	exists, err := FileExists(filepath.Join(i.JsonnetHome, ".tmp"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+// memPackage is a package served from memory, tagged with the version.
+type memPackage struct {
+	files map[string]string
+	tag   string
+}
+
+func (p *memPackage) Install(ctx context.Context, dir, version string) (string, error) {
+	for name, content := range p.files {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), os.ModePerm); err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			return "", err
+		}
+	}
+	p.tag = "v" + version
+	return "resolved-" + version, nil
+}
+
+func (p *memPackage) LockTag() string {
+	return p.tag
+}
+
+func TestInstallerFetchers(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Git dependencies of example.com are served from memory, no network
+	// access is needed.
+	fetcher := FetcherFunc(func(dep spec.Dependency, from string) (Interface, error) {
+		if dep.Source.GitSource == nil || dep.Source.GitSource.Remote != "https://example.com/foo" {
+			return nil, nil
+		}
+		return &memPackage{files: map[string]string{"lib/main.libsonnet": "{}"}}, nil
+	})
+
+	dep := gitDependency("foo", "https://example.com/foo", "1.0.0")
+	dep.Source.GitSource.Subdir = "lib"
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{dep}}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Fetchers: []Fetcher{fetcher}}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 1)
+	assert.Equal(t, "resolved-1.0.0", lock.Dependencies[0].Version)
+	assert.Equal(t, "v1.0.0", lock.Dependencies[0].Tag)
+	assert.NotEmpty(t, lock.Dependencies[0].Sum)
+
+	exists, err := FileExists(filepath.Join(i.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Dependencies the fetchers do not handle use the built-in sources.
+	m.Dependencies = append(m.Dependencies, spec.Dependency{Name: "none"})
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.EqualError(t, err, "dependency none has no source")
+}
+
+func TestInstallerLockCommit(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("a/main.libsonnet", "{ a: 1 }")
+	repo.commit("b/main.libsonnet", "{ b: 1 }")
+	treeA := repo.git("rev-parse", "HEAD:a")
+	treeB := repo.git("rev-parse", "HEAD:b")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Both subdirs are installed from one clone, each with its own tree.
+	a := gitDependency("a", repo.Dir, "master")
+	a.Source.GitSource.Subdir = "a"
+	b := gitDependency("b", repo.Dir, "master")
+	b.Source.GitSource.Subdir = "b"
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{a, b}}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 2)
+	trees := map[string]string{}
+	for _, d := range lock.Dependencies {
+		trees[d.Name] = d.Tree
+		_, err := time.Parse(time.RFC3339, d.Date)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, map[string]string{"a": treeA, "b": treeB}, trees)
+}
+
+func TestInstallerRollback(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	content := "{ v: 1 }"
+	fetcher := FetcherFunc(func(dep spec.Dependency, from string) (Interface, error) {
+		if dep.Name == "broken" {
+			return hangingPackage{}, nil
+		}
+		return &memPackage{files: map[string]string{"main.libsonnet": content}}, nil
+	})
+	foo := gitDependency("foo", "https://example.com/foo", "1.0.0")
+	broken := gitDependency("broken", "https://example.com/broken", "1.0.0")
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Fetchers: []Fetcher{fetcher}, Timeout: 10 * time.Millisecond}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{foo}})
+	assert.NoError(t, err)
+
+	// foo was vendored again before broken failed, but the vendor
+	// directory is left as it was.
+	content = "{ v: 2 }"
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{foo, broken}})
+	assert.Error(t, err)
+	b, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", string(b))
+
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{foo}})
+	assert.NoError(t, err)
+	b, err = ioutil.ReadFile(filepath.Join(i.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 2 }", string(b))
+
+	// No staging copies are left behind.
+	entries, err := ioutil.ReadDir(tempDir)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"vendor"}, names)
+
+	// The vendor directory gets the mode of new directories, and keeps it,
+	// instead of the one of a temporary directory.
+	probe := filepath.Join(tempDir, "probe")
+	assert.NoError(t, os.Mkdir(probe, os.ModePerm))
+	expected, err := os.Stat(probe)
+	assert.NoError(t, err)
+	info, err := os.Stat(i.JsonnetHome)
+	assert.NoError(t, err)
+	assert.Equal(t, expected.Mode().Perm(), info.Mode().Perm())
+	assert.NoError(t, os.Chmod(i.JsonnetHome, 0755))
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{foo}})
+	assert.NoError(t, err)
+	info, err = os.Stat(i.JsonnetHome)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+}
+
+func TestInstallerPrune(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("foo", repo.Dir, "master"),
+		gitDependency("bar", repo.Dir, "master"),
+	}}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Prune: true}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+
+	// bar was dropped from the jsonnetfile.
+	m.Dependencies = m.Dependencies[:1]
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+
+	entries, err := ioutil.ReadDir(i.JsonnetHome)
+	assert.NoError(t, err)
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"foo"}, names)
+}
+
+// hangingPackage never finishes downloading, until its context is done.
+type hangingPackage struct{}
+
+func (hangingPackage) Install(ctx context.Context, dir, version string) (string, error) {
+	<-ctx.Done()
+	return "", errors.New("signal: killed")
+}
+
+func TestInstallerTimeout(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	fetcher := FetcherFunc(func(dep spec.Dependency, from string) (Interface, error) {
+		return hangingPackage{}, nil
+	})
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", "https://example.com/foo", "1.0.0")}}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Fetchers: []Fetcher{fetcher}, Timeout: 10 * time.Millisecond}
+
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.EqualError(t, err, "failed to install package: fetching foo timed out after 10ms")
+
+	// Canceling aborts the download, without leaving anything behind.
+	i.Timeout = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = i.Install(ctx, filepath.Join(tempDir, JsonnetFile), m)
+	assert.EqualError(t, err, "failed to install package: context canceled")
+
+	entries, err := ioutil.ReadDir(i.JsonnetHome)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -16,8 +16,68 @@ package pkg
 
 import (
 	"context"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
 )
 
+// Interface is a package that can be fetched, like a git repository or an
+// archive.
 type Interface interface {
 	Install(ctx context.Context, dir, version string) (lockVersion string, err error)
 }
+
+// Fetcher returns the package fetching a dependency required by the
+// jsonnetfile from, or nil if it does not handle the source of the
+// dependency.
+type Fetcher interface {
+	Fetch(dep spec.Dependency, from string) (Interface, error)
+}
+
+// FetcherFunc is a function implementing Fetcher.
+type FetcherFunc func(dep spec.Dependency, from string) (Interface, error)
+
+func (f FetcherFunc) Fetch(dep spec.Dependency, from string) (Interface, error) {
+	return f(dep, from)
+}
+
+// SourceLocker is implemented by packages recording a different source in
+// the lock file than the one they were installed from, for example with the
+// checksum of the download. It is called after Install.
+type SourceLocker interface {
+	LockSource() spec.Source
+}
+
+// Tagger is implemented by packages that resolved their version to a tag,
+// recorded in the lock file. It is called after Install.
+type Tagger interface {
+	LockTag() string
+}
+
+// CommitLocker is implemented by packages checked out from a commit, of
+// which the date and the tree hash of subdir are recorded in the lock file.
+// It is called after Install.
+type CommitLocker interface {
+	LockCommit(subdir string) (date time.Time, tree string)
+}
+
+// SubmoduleLocker is implemented by packages that checked out git
+// submodules, of which the commits below subdir are recorded in the lock
+// file, by path. It is called after Install.
+type SubmoduleLocker interface {
+	LockSubmodules(subdir string) map[string]string
+}
+
+// Resumer is implemented by packages that may continue where a failed
+// Install stopped. Retries of a package that Resumes keep what the failed
+// attempt left in its directory instead of emptying it.
+type Resumer interface {
+	Resumes() bool
+}
+
+// Linker is implemented by packages that are linked into the vendor
+// directory instead of being moved there. Linked packages change in place
+// and have no digest.
+type Linker interface {
+	Link(dest string) error
+}
@@ -15,10 +15,13 @@
 package jsonnetfile
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"os"
-	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
 
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
 	"github.com/pkg/errors"
@@ -30,8 +33,8 @@ const LockFile = "jsonnetfile.lock.json"
 var ErrNoFile = errors.New("no jsonnetfile")
 
 func Choose(dir string) (string, bool, error) {
-	jsonnetfileLock := path.Join(dir, LockFile)
-	jsonnetfile := path.Join(dir, File)
+	jsonnetfileLock := filepath.Join(dir, LockFile)
+	jsonnetfile := filepath.Join(dir, File)
 
 	lockExists, err := fileExists(jsonnetfileLock)
 	if err != nil {
@@ -53,20 +56,142 @@ func Choose(dir string) (string, bool, error) {
 }
 
 func Load(filepath string) (spec.JsonnetFile, error) {
-	m := spec.JsonnetFile{}
-
 	bytes, err := ioutil.ReadFile(filepath)
 	if err != nil {
-		return m, errors.Wrap(err, "failed to read file")
+		return spec.JsonnetFile{}, errors.Wrap(err, "failed to read file")
 	}
 
-	if err := json.Unmarshal(bytes, &m); err != nil {
-		return m, errors.Wrap(err, "failed to unmarshal file")
+	m, err := spec.Parse(bytes)
+	if err != nil {
+		return spec.JsonnetFile{}, errors.Wrapf(err, "failed to unmarshal %s", filepath)
 	}
 
 	return m, nil
 }
 
+// Write encodes m as indented JSON to filename. The file is replaced at
+// once, so that a failing write never leaves a truncated file behind.
+// Dependencies already in the file keep their order, see KeepOrder, and a
+// file whose content does not change is not written at all, so that diffs
+// of the jsonnetfile and lock file only show what changed.
+func Write(filename string, m spec.JsonnetFile) error {
+	var previous []byte
+	if p, err := ioutil.ReadFile(filename); err == nil {
+		previous = p
+		if old, err := spec.Parse(p); err == nil {
+			m.Dependencies = KeepOrder(m.Dependencies, old.Dependencies)
+			m.Targets = keepTargetOrder(m.Targets, old.Targets)
+		}
+	}
+
+	b, err := encode(m)
+	if err != nil {
+		return err
+	}
+	if previous != nil && bytes.Equal(b, previous) {
+		return nil
+	}
+
+	if err := writeFile(filename, b); err != nil {
+		return errors.Wrap(err, "failed to write file")
+	}
+
+	return nil
+}
+
+// KeepOrder returns deps in the order of previous, the dependencies of the
+// same file before it changed: a dependency comes at the position of the
+// previous one of the same name, or else of the same source, and
+// dependencies previous has neither for come last, in the order of deps.
+// deps is not modified.
+func KeepOrder(deps, previous []spec.Dependency) []spec.Dependency {
+	rank := func(d spec.Dependency) int {
+		for n, p := range previous {
+			if p.Name == d.Name {
+				return n
+			}
+		}
+		for n, p := range previous {
+			if reflect.DeepEqual(p.Source, d.Source) {
+				return n
+			}
+		}
+		return len(previous)
+	}
+
+	if deps == nil {
+		return nil
+	}
+	ordered := make([]spec.Dependency, len(deps))
+	copy(ordered, deps)
+	sort.SliceStable(ordered, func(a, b int) bool {
+		return rank(ordered[a]) < rank(ordered[b])
+	})
+	return ordered
+}
+
+// keepTargetOrder returns targets with the dependencies of every target in
+// the order of the same target of previous, see KeepOrder.
+func keepTargetOrder(targets, previous map[string]spec.Target) map[string]spec.Target {
+	if targets == nil {
+		return nil
+	}
+	ordered := make(map[string]spec.Target, len(targets))
+	for name, t := range targets {
+		t.Dependencies = KeepOrder(t.Dependencies, previous[name].Dependencies)
+		ordered[name] = t
+	}
+	return ordered
+}
+
+// writeFile writes b to a temporary file next to filename, which is then
+// renamed to filename. Symlinks are written through, not replaced.
+func writeFile(filename string, b []byte) error {
+	if target, err := filepath.EvalSymlinks(filename); err == nil {
+		filename = target
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filename)
+}
+
+// Format returns b, a jsonnetfile or lock file, the way Write encodes it,
+// with the fields in the order of the format and indented by four spaces.
+// Invalid files fail with a spec.ValidationError.
+func Format(b []byte) ([]byte, error) {
+	m, err := spec.Parse(b)
+	if err != nil {
+		return nil, err
+	}
+	if m.Dependencies == nil {
+		m.Dependencies = []spec.Dependency{}
+	}
+	return encode(m)
+}
+
+func encode(m spec.JsonnetFile) ([]byte, error) {
+	b, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to encode file")
+	}
+	return append(b, []byte("\n")...), nil
+}
+
 func fileExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
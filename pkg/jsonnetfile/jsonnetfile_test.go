@@ -15,14 +15,18 @@
 package jsonnetfile_test
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec/spectest"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -159,3 +163,271 @@ func TestLoad(t *testing.T) {
 		assert.Equal(t, jsonnetFileExpected, jf)
 	}
 }
+
+func TestLoadVersion(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-load-jsonnetfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	tempFile := filepath.Join(tempDir, jsonnetfile.File)
+
+	err = ioutil.WriteFile(tempFile, []byte(`{"version": 1, "dependencies": []}`), os.ModePerm)
+	assert.NoError(t, err)
+	jf, err := jsonnetfile.Load(tempFile)
+	assert.NoError(t, err)
+	assert.Equal(t, spec.QualifiedVersion, jf.Version)
+
+	err = ioutil.WriteFile(tempFile, []byte(`{"version": 99, "dependencies": []}`), os.ModePerm)
+	assert.NoError(t, err)
+	_, err = jsonnetfile.Load(tempFile)
+	assert.Equal(t, &spec.UnsupportedVersionError{Version: 99}, errors.Cause(err))
+}
+
+func TestParseRequirements(t *testing.T) {
+	defer func(v string) { spec.JBVersion = v }(spec.JBVersion)
+
+	testcases := []struct {
+		Name    string
+		Version string
+		Content string
+		Err     error
+	}{{
+		Name:    "Satisfied",
+		Version: "v0.5.1",
+		Content: `{"jb": ">=0.5", "features": ["local-sources"], "dependencies": []}`,
+	}, {
+		Name:    "PastRelease",
+		Version: "v0.5.0-3-g0123abc",
+		Content: `{"jb": ">=0.5", "dependencies": []}`,
+	}, {
+		Name:    "Development",
+		Version: "dev",
+		Content: `{"jb": ">=9.0", "dependencies": []}`,
+	}, {
+		Name:    "TooOld",
+		Version: "v0.4.0",
+		Content: `{"jb": ">=0.5", "dependencies": [], "newField": true}`,
+		Err:     &spec.UnsupportedRequirementError{JB: ">=0.5"},
+	}, {
+		Name:    "UnknownFeature",
+		Version: "v0.5.0",
+		Content: `{"features": ["replace", "teleport"], "dependencies": []}`,
+		Err:     &spec.UnsupportedRequirementError{Features: []string{"teleport"}},
+	}, {
+		Name:    "InvalidConstraint",
+		Version: "v0.5.0",
+		Content: `{"jb": ">=x", "dependencies": []}`,
+		Err:     &spec.ValidationError{Problems: []string{`jb: invalid version constraint ">=x": invalid semantic version "x"`}},
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			spec.JBVersion = tc.Version
+			_, err := spec.Parse([]byte(tc.Content))
+			if tc.Err == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tc.Err, err)
+		})
+	}
+
+	spec.JBVersion = "v0.4.0"
+	err := (&spec.UnsupportedRequirementError{JB: ">=0.5", Features: []string{"teleport"}}).Error()
+	assert.Equal(t, "the jsonnetfile requires jsonnet-bundler >=0.5, this is v0.4.0 and the features teleport, which are not supported: upgrade jsonnet-bundler", err)
+}
+
+func TestWrite(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-write-jsonnetfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	m := spec.JsonnetFile{
+		Dependencies: []spec.Dependency{{
+			Name:    "foobar",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foobar/foobar"}},
+			Version: "master",
+		}},
+	}
+
+	tempFile := filepath.Join(tempDir, jsonnetfile.File)
+	err = jsonnetfile.Write(tempFile, m)
+	assert.NoError(t, err)
+
+	jf, err := jsonnetfile.Load(tempFile)
+	assert.NoError(t, err)
+	assert.Equal(t, m, jf)
+
+	err = jsonnetfile.Write(filepath.Join(notExist, jsonnetfile.File), m)
+	assert.Error(t, err)
+}
+
+func TestWriteKeepsOrder(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-write-jsonnetfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dep := func(name string) spec.Dependency {
+		return spec.Dependency{
+			Name:    name,
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/org/" + name}},
+			Version: "master",
+		}
+	}
+	tempFile := filepath.Join(tempDir, jsonnetfile.File)
+	assert.NoError(t, jsonnetfile.Write(tempFile, spec.JsonnetFile{Dependencies: []spec.Dependency{dep("foo"), dep("bar"), dep("baz")}}))
+
+	// The dependencies already in the file stay where they were, new ones
+	// come last.
+	changed := dep("bar")
+	changed.Version = "v1"
+	assert.NoError(t, jsonnetfile.Write(tempFile, spec.JsonnetFile{Dependencies: []spec.Dependency{dep("qux"), dep("baz"), changed, dep("foo")}}))
+	jf, err := jsonnetfile.Load(tempFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []spec.Dependency{dep("foo"), changed, dep("baz"), dep("qux")}, jf.Dependencies)
+
+	// An unchanged file is not written again.
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NoError(t, os.Chtimes(tempFile, old, old))
+	assert.NoError(t, jsonnetfile.Write(tempFile, jf))
+	info, err := os.Stat(tempFile)
+	assert.NoError(t, err)
+	assert.True(t, info.ModTime().Equal(old))
+}
+
+func TestKeepOrder(t *testing.T) {
+	previous := []spec.Dependency{
+		{Name: "foo", Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "foo"}}},
+		{Name: "bar", Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "bar"}}},
+	}
+	// A renamed dependency keeps the position of its source.
+	deps := []spec.Dependency{
+		{Name: "new", Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "new"}}},
+		{Name: "bar", Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "bar"}}},
+		{Name: "foo-renamed", Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "foo"}}},
+	}
+	ordered := jsonnetfile.KeepOrder(deps, previous)
+	assert.Equal(t, []spec.Dependency{deps[2], deps[1], deps[0]}, ordered)
+	assert.Equal(t, "new", deps[0].Name)
+	assert.Nil(t, jsonnetfile.KeepOrder(nil, previous))
+}
+
+func TestParseInvalid(t *testing.T) {
+	testcases := []struct {
+		Name     string
+		Content  string
+		Problems []string
+	}{{
+		Name:     "Syntax",
+		Content:  "{\n    \"dependencies\": [\n        {,\n    ]\n}",
+		Problems: []string{"line 3, column 10: invalid character ',' looking for beginning of object key string"},
+	}, {
+		Name:    "UnknownFields",
+		Content: `{"dependencies": [{"name": "foo", "source": {"git": {"remot": "https://github.com/foo/foo"}}, "Version": "v1"}], "hoks": []}`,
+		Problems: []string{
+			`dependencies[0].Version: unknown field, did you mean "version"?`,
+			`dependencies[0].source.git.remot: unknown field, did you mean "remote"?`,
+			`hoks: unknown field, did you mean "hooks"?`,
+		},
+	}, {
+		Name:    "WrongTypes",
+		Content: `{"version": "1", "dependencies": {"name": "foo"}, "workspace": [1]}`,
+		Problems: []string{
+			"dependencies: expected an array, got an object",
+			"version: expected an integer, got the string \"1\"",
+			"workspace[0]: expected a string, got the number 1",
+		},
+	}, {
+		Name:     "WrongNestedType",
+		Content:  `{"dependencies": [{"name": "foo", "source": {"git": {"remote": "r"}}, "flatten": "no"}]}`,
+		Problems: []string{`dependencies[0].flatten: expected a boolean, got the string "no"`},
+	}, {
+		Name:     "Duplicates",
+		Content:  `{"dependencies": [{"name": "foo", "source": {"git": {"remote": "r"}}, "version": "v1"}, {"name": "foo", "source": {"git": {"remote": "r"}}, "version": "v2"}]}`,
+		Problems: []string{`dependencies[1]: duplicate of dependencies[0], both named "foo" with the same source`},
+	}, {
+		Name:    "Replace",
+		Content: `{"dependencies": [], "replace": [{"version": "v1"}, {"name": "foo"}, {"name": "bar", "version": "v1"}, {"name": "bar", "version": "v2"}]}`,
+		Problems: []string{
+			"replace[0]: no name of the dependency to replace",
+			`replace[1]: "foo" is replaced by neither a source nor a version`,
+			`replace[3]: duplicate of replace[2], both replacing "bar"`,
+		},
+	}, {
+		Name:     "TopLevel",
+		Content:  `[]`,
+		Problems: []string{"top level: expected an object, got an array"},
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			_, err := spec.Parse([]byte(tc.Content))
+			assert.Equal(t, &spec.ValidationError{Problems: tc.Problems}, err)
+		})
+	}
+
+	// Dependencies of the same name from different sources are left to be
+	// disambiguated, and the fields of former versions are ignored.
+	_, err := spec.Parse([]byte(`{"legacyImports": true, "dependencies": [{"name": "foo", "source": {"git": {"remote": "a"}}}, {"name": "foo", "source": {"git": {"remote": "b"}}}]}`))
+	assert.NoError(t, err)
+}
+
+func TestFormat(t *testing.T) {
+	formatted, err := jsonnetfile.Format([]byte(`{"dependencies":[{"version":"master","source":{"git":{"subdir":"","remote":"https://github.com/foobar/foobar"}},"name":"foobar"}],"version":1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{
+    "version": 1,
+    "dependencies": [
+        {
+            "name": "foobar",
+            "source": {
+                "git": {
+                    "remote": "https://github.com/foobar/foobar",
+                    "subdir": ""
+                }
+            },
+            "version": "master"
+        }
+    ]
+}
+`, string(formatted))
+
+	again, err := jsonnetfile.Format(formatted)
+	assert.NoError(t, err)
+	assert.Equal(t, formatted, again)
+
+	formatted, err = jsonnetfile.Format([]byte(`{}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "{\n    \"dependencies\": []\n}\n", string(formatted))
+
+	_, err = jsonnetfile.Format([]byte(`{"dependencies": [], "unknown": 1}`))
+	assert.IsType(t, &spec.ValidationError{}, err)
+}
+
+// TestGolden checks that the fixtures of testdata are written back by Write
+// without losing anything, in the form of their golden files. Run it with
+// SPECTEST_UPDATE=1 to update them after changing the format.
+func TestGolden(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	n := 0
+	spectest.RunFixtures(t, "testdata", func(m spec.JsonnetFile) ([]byte, error) {
+		// Every file is written anew, Write keeps the order of an
+		// existing one.
+		n++
+		filename := filepath.Join(tempDir, fmt.Sprintf("%d.json", n))
+		if err := jsonnetfile.Write(filename, m); err != nil {
+			return nil, err
+		}
+		return ioutil.ReadFile(filename)
+	})
+}
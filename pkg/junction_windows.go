@@ -0,0 +1,34 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+// +build windows
+
+package pkg
+
+import (
+	"os/exec"
+
+	"github.com/pkg/errors"
+)
+
+// createJunction creates a directory junction at dest pointing to target.
+// Unlike symlinks, junctions need no privileges on Windows.
+func createJunction(target, dest string) error {
+	out, err := exec.Command("cmd", "/c", "mklink", "/J", dest, target).CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "failed to create junction: %s", out)
+	}
+	return nil
+}
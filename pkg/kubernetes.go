@@ -0,0 +1,214 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultKubernetesOutput is the directory of the vendor directory the
+	// Kubernetes libraries are generated into, see spec.Kubernetes.
+	DefaultKubernetesOutput = "kubernetes"
+	// DefaultKubernetesOpenAPI is the URL of the OpenAPI spec of the
+	// Kubernetes version at the git ref {ref}.
+	DefaultKubernetesOpenAPI = "https://raw.githubusercontent.com/kubernetes/kubernetes/{ref}/api/openapi-spec/swagger.json"
+)
+
+// DefaultKubernetesGenerator generates a Kubernetes library with
+// ksonnet-gen of ksonnet-lib, see spec.Kubernetes.
+var DefaultKubernetesGenerator = []string{"ksonnet-gen", "{spec}", "{output}"}
+
+var kubernetesVersionRegex = regexp.MustCompile(`^v?(\d+\.\d+)(\.\d+)?$`)
+
+// KubernetesRef returns the git ref of the Kubernetes repository holding
+// the OpenAPI spec of version: the release branch of a minor version like
+// 1.29, release-1.29, and the tag of a patch version like 1.29.3, v1.29.3.
+func KubernetesRef(version string) (string, error) {
+	m := kubernetesVersionRegex.FindStringSubmatch(version)
+	if m == nil {
+		return "", fmt.Errorf("invalid Kubernetes version %s, expected a version like 1.29 or 1.29.3", version)
+	}
+	if m[2] == "" {
+		return "release-" + m[1], nil
+	}
+	return "v" + m[1] + m[2], nil
+}
+
+// KubernetesPath returns the directory of jsonnetHome the library of the
+// Kubernetes version is generated into, like vendor/kubernetes/1.29.
+func KubernetesPath(jsonnetHome string, k spec.Kubernetes, version string) string {
+	return filepath.Join(jsonnetHome, filepath.FromSlash(kubernetesOutput(k)), version)
+}
+
+func kubernetesOutput(k spec.Kubernetes) string {
+	if k.Output == "" {
+		return DefaultKubernetesOutput
+	}
+	return path.Clean(filepath.ToSlash(k.Output))
+}
+
+// generateKubernetes generates the Kubernetes libraries of k missing from
+// the vendor directory, dir being the project directory the generator runs
+// in. The OpenAPI specs of tags are kept in CacheDir, those of release
+// branches move and are downloaded every time. Like hooks, generators only
+// run if AllowHooks is set, and the libraries are generated into a
+// temporary directory which replaces nothing until the generator succeeded.
+func (i *Installer) generateKubernetes(ctx context.Context, dir string, k *spec.Kubernetes) error {
+	if k == nil || len(k.Versions) == 0 {
+		return nil
+	}
+	output := kubernetesOutput(*k)
+	if output == "." || strings.HasPrefix(output, "../") || path.IsAbs(output) {
+		return fmt.Errorf("the Kubernetes libraries must be generated into a subdir of the vendor directory, not %s", k.Output)
+	}
+	for _, d := range i.lock.Dependencies {
+		name := filepath.ToSlash(d.Name)
+		if name == output || strings.HasPrefix(name, output+"/") || strings.HasPrefix(output, name+"/") {
+			return fmt.Errorf("the Kubernetes libraries are generated into %s, which collides with the dependency %s, set another output", output, d.Name)
+		}
+	}
+	generator := k.Generator
+	if len(generator) == 0 {
+		generator = DefaultKubernetesGenerator
+	}
+
+	for _, version := range k.Versions {
+		ref, err := KubernetesRef(version)
+		if err != nil {
+			return err
+		}
+		out := KubernetesPath(i.JsonnetHome, *k, version)
+		if exists, err := FileExists(out); err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+		if !i.AllowHooks {
+			color.Yellow(">>> Skipping the Kubernetes library %s, pass --allow-hooks to generate it: %s\n", version, strings.Join(generator, " "))
+			continue
+		}
+
+		if err := i.generateKubernetesVersion(ctx, dir, *k, generator, version, ref, out); err != nil {
+			return errors.Wrapf(err, "failed to generate the Kubernetes library %s", version)
+		}
+		color.Green(">>> Generated the Kubernetes library %s into %s\n", version, out)
+	}
+	return nil
+}
+
+// generateKubernetesVersion runs generator on the OpenAPI spec of version,
+// at the git ref ref, and moves the library it generated to out.
+func (i *Installer) generateKubernetesVersion(ctx context.Context, dir string, k spec.Kubernetes, generator []string, version, ref, out string) error {
+	tmp := filepath.Join(i.JsonnetHome, ".tmp")
+	if err := os.MkdirAll(tmp, os.ModePerm); err != nil {
+		return errors.Wrap(err, "failed to create general tmp dir")
+	}
+	defer os.Remove(tmp)
+	tmpDir, err := ioutil.TempDir(tmp, "jsonnetpkg-kubernetes-"+version)
+	if err != nil {
+		return errors.Wrap(err, "failed to create tmp dir")
+	}
+	defer os.RemoveAll(tmpDir)
+
+	openAPI := filepath.Join(tmpDir, "swagger.json")
+	if err := i.kubernetesSpec(ctx, k, ref, openAPI); err != nil {
+		return err
+	}
+	lib, err := filepath.Abs(filepath.Join(tmpDir, "lib"))
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(lib, os.ModePerm); err != nil {
+		return err
+	}
+	openAPI, err = filepath.Abs(openAPI)
+	if err != nil {
+		return err
+	}
+
+	r := strings.NewReplacer("{version}", version, "{spec}", openAPI, "{output}", lib)
+	command := make([]string, 0, len(generator))
+	for _, arg := range generator {
+		command = append(command, r.Replace(arg))
+	}
+	if err := i.runHook(ctx, "", dir, command); err != nil {
+		return errors.Wrapf(err, "%s failed", command[0])
+	}
+
+	if err := os.MkdirAll(filepath.Dir(out), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(lib, out)
+}
+
+// kubernetesSpec writes the OpenAPI spec of the Kubernetes git ref ref to
+// filename, from CacheDir if it was downloaded before.
+func (i *Installer) kubernetesSpec(ctx context.Context, k spec.Kubernetes, ref, filename string) error {
+	rawurl := k.OpenAPI
+	if rawurl == "" {
+		rawurl = DefaultKubernetesOpenAPI
+	}
+	rawurl = strings.Replace(rawurl, "{ref}", ref, -1)
+
+	cached := ""
+	if i.CacheDir != "" && strings.HasPrefix(ref, "v") {
+		cached = filepath.Join(i.CacheDir, "kubernetes", sha256Digest([]byte(rawurl))[len("sha256:"):]+".json")
+		if _, err := os.Stat(cached); err == nil {
+			return copyFile(cached, filename)
+		}
+	}
+	if i.Offline {
+		return &OfflineError{Name: "the Kubernetes OpenAPI spec " + ref, Reason: "it is not in the cache"}
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	_, err = download(ctx, i.CAFile, rawurl, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || cached == "" {
+		return err
+	}
+	// The spec is still there if caching it fails. It is cached whole or
+	// not at all.
+	if err := copyFile(filename, cached+".tmp"); err == nil {
+		os.Rename(cached+".tmp", cached)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	return writeFile(dst, in, 0644)
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKubernetesRef(t *testing.T) {
+	for version, expected := range map[string]string{
+		"1.29":    "release-1.29",
+		"v1.29":   "release-1.29",
+		"1.29.3":  "v1.29.3",
+		"v1.30.0": "v1.30.0",
+	} {
+		ref, err := KubernetesRef(version)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, ref, version)
+	}
+
+	_, err := KubernetesRef("latest")
+	assert.EqualError(t, err, "invalid Kubernetes version latest, expected a version like 1.29 or 1.29.3")
+}
+
+func TestInstallerKubernetes(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("generators are tested with sh")
+	}
+
+	requests := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		w.Write([]byte(`{"swagger": "` + r.URL.Path + `"}`))
+	}))
+	defer srv.Close()
+
+	tempDir, err := ioutil.TempDir("", "jb-kubernetes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	project := spec.JsonnetFile{Dependencies: []spec.Dependency{}, Kubernetes: &spec.Kubernetes{
+		Versions:  []string{"1.29", "1.30.2"},
+		Generator: []string{"sh", "-c", `cp "$1" "$2/swagger.json" && echo "{ version: '$0' }" > "$2/main.libsonnet"`, "{version}", "{spec}", "{output}"},
+		OpenAPI:   srv.URL + "/{ref}/swagger.json",
+	}}
+	filename := filepath.Join(tempDir, JsonnetFile)
+	vendor := filepath.Join(tempDir, "vendor")
+
+	// Libraries are generated like hooks run, only if allowed.
+	i := &Installer{JsonnetHome: vendor, CacheDir: filepath.Join(tempDir, "cache"), Prune: true}
+	_, err = i.Install(context.TODO(), filename, project)
+	assert.NoError(t, err)
+	assert.Empty(t, requests)
+
+	i.AllowHooks = true
+	lock, err := i.Install(context.TODO(), filename, project)
+	assert.NoError(t, err)
+	assert.Equal(t, project.Kubernetes, lock.Kubernetes)
+	assert.Equal(t, []string{"/release-1.29/swagger.json", "/v1.30.2/swagger.json"}, requests)
+	content, err := ioutil.ReadFile(filepath.Join(vendor, "kubernetes", "1.30.2", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{ version: '1.30.2' }\n", string(content))
+	content, err = ioutil.ReadFile(filepath.Join(vendor, "kubernetes", "1.29", "swagger.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"swagger": "/release-1.29/swagger.json"}`, string(content))
+	assert.NoError(t, Verify(vendor, *lock))
+
+	// Generated libraries are kept, those of versions no longer
+	// configured pruned. Specs of tags come from the cache.
+	assert.NoError(t, os.RemoveAll(filepath.Join(vendor, "kubernetes", "1.30.2")))
+	project.Kubernetes.Versions = []string{"1.30.2"}
+	_, err = i.Install(context.TODO(), filename, project)
+	assert.NoError(t, err)
+	assert.Len(t, requests, 2)
+	_, err = os.Stat(filepath.Join(vendor, "kubernetes", "1.30.2", "main.libsonnet"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(vendor, "kubernetes", "1.29"))
+	assert.True(t, os.IsNotExist(err))
+
+	project.Kubernetes.Versions = []string{"1.31"}
+	project.Kubernetes.Generator = []string{"sh", "-c", "touch \"$0/partial.libsonnet\"; exit 3", "{output}"}
+	_, err = i.Install(context.TODO(), filename, project)
+	assert.EqualError(t, err, "failed to generate the Kubernetes library 1.31: sh failed: exit status 3")
+	_, err = os.Stat(filepath.Join(vendor, "kubernetes", "1.31"))
+	assert.True(t, os.IsNotExist(err))
+}
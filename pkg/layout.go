@@ -0,0 +1,169 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// QualifiedName returns the name of a git dependency in the qualified
+// layout of spec.QualifiedVersion: the host and path of its remote,
+// without scheme, user or .git suffix, followed by its subdir, like
+// github.com/a/utils/lib. It is empty for other sources, for remotes
+// without a host, like local repositories, and for remotes with variables,
+// whose host may differ between environments, see ExpandRemotes.
+func QualifiedName(dep spec.Dependency) string {
+	if dep.Source.GitSource == nil || hasVariables(dep.Source.GitSource.Remote) {
+		return ""
+	}
+
+	name := remotePath(dep.Source.GitSource.Remote)
+	if name == "" {
+		return ""
+	}
+	if subdir := strings.Trim(filepath.ToSlash(dep.Source.GitSource.Subdir), "/"); subdir != "" {
+		name += "/" + subdir
+	}
+	return name
+}
+
+// remotePath returns the host and path of a git remote, like
+// github.com/a/utils for https://github.com/a/utils.git and
+// git@github.com:a/utils.
+func remotePath(remote string) string {
+	if i := strings.Index(remote, "://"); i >= 0 {
+		if remote[:i] == "file" {
+			return ""
+		}
+		remote = remote[i+3:]
+	} else if i := strings.Index(remote, ":"); i > 1 && !strings.ContainsAny(remote[:i], `/\`) {
+		// scp-like syntax, user@host:path
+		remote = remote[:i] + "/" + remote[i+1:]
+	} else {
+		return ""
+	}
+
+	host, p := remote, ""
+	if i := strings.Index(remote, "/"); i >= 0 {
+		host, p = remote[:i], remote[i:]
+	}
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if i := strings.Index(host, ":"); i >= 0 {
+		host = host[:i]
+	}
+
+	p = strings.TrimSuffix(strings.Trim(p, "/"), ".git")
+	if host == "" || p == "" {
+		return ""
+	}
+	return host + "/" + p
+}
+
+// QualifyNames renames the git dependencies of deps that have the short
+// name of the legacy layout, the last element of their qualified name, to
+// their qualified name. Dependencies given another name keep it, so that
+// two versions of a package can still be vendored side by side.
+func QualifyNames(deps []spec.Dependency) []spec.Dependency {
+	res := make([]spec.Dependency, 0, len(deps))
+	for _, d := range deps {
+		if q := QualifiedName(d); q != "" && d.Name == path.Base(q) {
+			d.Name = q
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+// legacyLinks returns the links keeping the imports of the legacy layout
+// working for the qualified packages of lock, from the short name to the
+// qualified name, like utils to github.com/a/utils. Short names shared by
+// several packages, or taken by a package, get no link.
+func legacyLinks(lock spec.JsonnetFile) map[string]string {
+	links := map[string]string{}
+	taken := map[string]bool{}
+	for _, d := range lock.Dependencies {
+		name := filepath.ToSlash(d.Name)
+		if !strings.Contains(name, "/") {
+			taken[name] = true
+			continue
+		}
+		short := path.Base(name)
+		if _, ok := links[short]; ok {
+			taken[short] = true
+		}
+		links[short] = name
+	}
+
+	for short := range taken {
+		delete(links, short)
+	}
+	return links
+}
+
+// Jpath returns the directories jsonnet needs on its path to resolve the
+// imports of a project with the packages of lock vendored in jsonnetHome:
+// jsonnetHome itself, followed by the parent directory of each qualified
+// package whose legacy link is missing, like in vendor directories checked
+// out by git without symlink support, so that its short name still
+// resolves.
+func Jpath(jsonnetHome string, lock spec.JsonnetFile) []string {
+	jpath := []string{jsonnetHome}
+	seen := map[string]bool{}
+	for short, name := range legacyLinks(lock) {
+		if info, err := os.Stat(filepath.Join(jsonnetHome, short)); err == nil && info.IsDir() {
+			continue
+		}
+		parent := filepath.Join(jsonnetHome, filepath.FromSlash(path.Dir(name)))
+		if !seen[parent] {
+			seen[parent] = true
+			jpath = append(jpath, parent)
+		}
+	}
+
+	sort.Strings(jpath[1:])
+	return jpath
+}
+
+// linkLegacyNames creates the links of legacyLinks in the vendor
+// directory, see LinkLegacyNames.
+func (i *Installer) linkLegacyNames() error {
+	return LinkLegacyNames(i.JsonnetHome, *i.lock)
+}
+
+// LinkLegacyNames creates the links of the legacy layout for the qualified
+// packages of lock in jsonnetHome, replacing whatever the legacy layout left
+// there, see legacyLinks.
+func LinkLegacyNames(jsonnetHome string, lock spec.JsonnetFile) error {
+	for short, name := range legacyLinks(lock) {
+		dest := filepath.Join(jsonnetHome, short)
+		target := filepath.Join(jsonnetHome, filepath.FromSlash(name))
+		if err := os.RemoveAll(dest); err != nil {
+			return errors.Wrapf(err, "failed to replace %s", dest)
+		}
+		if err := linkDir(filepath.FromSlash(name), target, dest); err != nil {
+			return errors.Wrapf(err, "failed to link %s to %s", short, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// licenseFileRegex matches the names of license files, like LICENSE,
+// LICENSE.md or COPYING.txt.
+var licenseFileRegex = regexp.MustCompile(`(?i)^(licen[cs]e|copying)([-._].*)?$`)
+
+// licenses maps phrases of license texts to SPDX identifiers. They are
+// matched in order against the lowercased text with collapsed whitespace,
+// so more specific phrases come first.
+var licenses = []struct {
+	phrase string
+	id     string
+}{
+	{"apache license version 2.0", "Apache-2.0"},
+	{"apache license, version 2.0", "Apache-2.0"},
+	{"mozilla public license version 2.0", "MPL-2.0"},
+	{"gnu affero general public license version 3", "AGPL-3.0"},
+	{"gnu lesser general public license version 3", "LGPL-3.0"},
+	{"gnu lesser general public license version 2.1", "LGPL-2.1"},
+	{"gnu general public license version 3", "GPL-3.0"},
+	{"gnu general public license version 2", "GPL-2.0"},
+	{"permission is hereby granted, free of charge", "MIT"},
+	{"permission to use, copy, modify, and/or distribute this software for any purpose", "ISC"},
+	{"neither the name of", "BSD-3-Clause"},
+	{"redistribution and use in source and binary forms", "BSD-2-Clause"},
+	{"this is free and unencumbered software released into the public domain", "Unlicense"},
+}
+
+// DetectLicense returns the SPDX identifier of the license in the license
+// file of dir, or else of the first of parents holding one. It is empty if
+// there is no license file or its license is not recognized.
+func DetectLicense(dir string, parents ...string) string {
+	for _, d := range append([]string{dir}, parents...) {
+		entries, err := ioutil.ReadDir(d)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() || !licenseFileRegex.MatchString(e.Name()) {
+				continue
+			}
+			text, err := ioutil.ReadFile(filepath.Join(d, e.Name()))
+			if err != nil {
+				continue
+			}
+			if id := licenseID(string(text)); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// licenseID recognizes the license of text.
+func licenseID(text string) string {
+	text = strings.ToLower(strings.Join(strings.Fields(text), " "))
+	for _, l := range licenses {
+		if strings.Contains(text, l.phrase) {
+			return l.id
+		}
+	}
+	return ""
+}
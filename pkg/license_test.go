@@ -0,0 +1,71 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLicenseID(t *testing.T) {
+	for text, id := range map[string]string{
+		"Apache License\n   Version 2.0, January 2004":                               "Apache-2.0",
+		"MIT License\n\nPermission is hereby granted, free of charge, to any person": "MIT",
+		"Redistribution and use in source and binary forms ... Neither the name of":  "BSD-3-Clause",
+		"Redistribution and use in source and binary forms, with or without":         "BSD-2-Clause",
+		"GNU LESSER GENERAL PUBLIC LICENSE\n Version 3, 29 June 2007":                "LGPL-3.0",
+		"All rights reserved.": "",
+	} {
+		assert.Equal(t, id, licenseID(text), text)
+	}
+}
+
+func TestInstallerLicense(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("LICENSE", "Apache License\nVersion 2.0, January 2004")
+	repo.commit("lib/main.libsonnet", "{}")
+	repo.commit("mit/LICENSE.md", "Permission is hereby granted, free of charge")
+
+	tempDir, err := ioutil.TempDir("", "jb-license")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	lib := gitDependency("lib", repo.Dir, "master")
+	lib.Source.GitSource.Subdir = "lib"
+	mit := gitDependency("mit", repo.Dir, "master")
+	mit.Source.GitSource.Subdir = "mit"
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{lib, mit}}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+
+	// The license of the repository applies to subtrees without their own,
+	// it is not vendored though.
+	assert.Equal(t, "Apache-2.0", lock.Dependencies[0].License)
+	assert.Equal(t, "MIT", lock.Dependencies[1].License)
+	exists, err := FileExists(filepath.Join(i.JsonnetHome, "lib", "LICENSE"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
@@ -0,0 +1,133 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// LocalPackage is a directory on disk, for example a sibling directory in a
+// monorepo. It is not downloaded but linked into the vendor directory, so
+// changes to it are picked up without reinstalling.
+type LocalPackage struct {
+	Source *spec.LocalSource
+
+	// Dir is the absolute path of the directory, relative directories are
+	// resolved against the directory of the jsonnetfile requiring them.
+	Dir string
+}
+
+func NewLocalPackage(source *spec.LocalSource, base string) (*LocalPackage, error) {
+	// The jsonnetfile may itself be part of a linked local package.
+	if resolved, err := filepath.EvalSymlinks(base); err == nil {
+		base = resolved
+	}
+
+	dir := source.Directory
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(base, dir)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalPackage{
+		Source: source,
+		Dir:    dir,
+	}, nil
+}
+
+// Install only checks that the directory exists, the package is linked by
+// Link once its destination is known. Local packages are not versioned, so
+// the returned version is always empty.
+func (p *LocalPackage) Install(ctx context.Context, dir, version string) (lockVersion string, err error) {
+	info, err := os.Stat(p.Dir)
+	if err != nil {
+		return "", &NotFoundError{Err: errors.Wrapf(err, "failed to find local package %s", p.Source.Directory)}
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("local package %s is not a directory", p.Source.Directory)
+	}
+
+	return "", nil
+}
+
+// symlink and junction create links, they are variables so tests can
+// exercise the fallbacks of systems without symlinks.
+var (
+	symlink  = os.Symlink
+	junction = createJunction
+)
+
+// Link creates a symlink at dest pointing to the directory. The link is
+// relative whenever possible, so the vendor directory can be moved along
+// with the project. It is computed between the paths with their symlinks
+// resolved, as that is where the link is followed from, which matters for
+// vendor directories outside of the project and reached through a symlink.
+// Where symlinks cannot be created, like on Windows without developer mode,
+// a directory junction is created instead, or as a last resort the
+// directory is copied, which picks up changes only on the next install.
+func (p *LocalPackage) Link(dest string) error {
+	parent, err := filepath.Abs(filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	dir, err := filepath.Abs(p.Dir)
+	if err != nil {
+		return err
+	}
+	if resolved, err := filepath.EvalSymlinks(parent); err == nil {
+		parent = resolved
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	// Directories on other volumes are linked by their absolute path.
+	target, err := filepath.Rel(parent, dir)
+	if err != nil {
+		target = dir
+	}
+
+	return linkDir(target, p.Dir, dest)
+}
+
+// linkDir creates a symlink at dest to target, which is the directory dir
+// relative to the parent of dest, falling back to a junction or a copy of
+// dir like LocalPackage.Link.
+func linkDir(target, dir, dest string) error {
+	err := symlink(target, dest)
+	if err == nil {
+		return nil
+	}
+	// Junctions need absolute targets.
+	abs, aerr := filepath.Abs(dir)
+	if aerr == nil {
+		if jerr := junction(abs, dest); jerr == nil {
+			return nil
+		}
+	}
+
+	color.Yellow(">>> Copying %s instead of linking it: %v\n", dir, err)
+	os.RemoveAll(dest)
+	return copyDir(dir, dest)
+}
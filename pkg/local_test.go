@@ -0,0 +1,140 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallerLocal(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	lib := filepath.Join(tempDir, "libs", "mylib")
+	assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{{
+		Name:   "mylib",
+		Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "libs/mylib"}},
+	}}}
+
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Len(t, lock.Dependencies, 1)
+	assert.Empty(t, lock.Dependencies[0].Sum)
+
+	target, err := os.Readlink(filepath.Join(i.JsonnetHome, "mylib"))
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join("..", "libs", "mylib"), target)
+
+	// Changes to the directory are visible without reinstalling.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "other.libsonnet"), []byte("{}"), 0644))
+	exists, err := FileExists(filepath.Join(i.JsonnetHome, "mylib", "other.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	// Installing again replaces the link.
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+
+	m.Dependencies[0].Source.LocalSource.Directory = "libs/missing"
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.Error(t, err)
+}
+
+func TestLocalPackageLinkFallback(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	lib := filepath.Join(tempDir, "libs", "mylib")
+	assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+	p := &LocalPackage{Source: &spec.LocalSource{Directory: "libs/mylib"}, Dir: lib}
+
+	// Like Windows without developer mode.
+	defer func(s func(string, string) error, j func(string, string) error) {
+		symlink, junction = s, j
+	}(symlink, junction)
+	symlink = func(string, string) error { return errors.New("symlinks are not permitted") }
+
+	var junctioned string
+	junction = func(target, dest string) error {
+		junctioned = target
+		return os.Symlink(target, dest)
+	}
+	dest := filepath.Join(tempDir, "vendor", "junction")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(dest), os.ModePerm))
+	assert.NoError(t, p.Link(dest))
+	assert.Equal(t, lib, junctioned)
+
+	// Without junctions either, the directory is copied.
+	junction = func(string, string) error { return errors.New("junctions are not supported") }
+	dest = filepath.Join(tempDir, "vendor", "copy")
+	assert.NoError(t, p.Link(dest))
+
+	info, err := os.Lstat(dest)
+	assert.NoError(t, err)
+	assert.True(t, info.IsDir())
+	content, err := ioutil.ReadFile(filepath.Join(dest, "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(content))
+}
+
+func TestLocalPackageLinkOutside(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need developer mode on Windows")
+	}
+	tempDir, err := ioutil.TempDir("", "jb-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The vendor directory is outside of the project, reached through a
+	// symlink to another directory.
+	lib := filepath.Join(tempDir, "project", "libs", "mylib")
+	assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+	assert.NoError(t, os.MkdirAll(filepath.Join(tempDir, "mnt", "shared", "vendor"), os.ModePerm))
+	assert.NoError(t, os.Symlink(filepath.Join(tempDir, "mnt", "shared"), filepath.Join(tempDir, "shared")))
+
+	p := &LocalPackage{Source: &spec.LocalSource{Directory: "libs/mylib"}, Dir: lib}
+	dest := filepath.Join(tempDir, "project", "..", "shared", "vendor", "mylib")
+	assert.NoError(t, p.Link(dest))
+
+	target, err := os.Readlink(dest)
+	assert.NoError(t, err)
+	assert.False(t, filepath.IsAbs(target))
+	content, err := ioutil.ReadFile(filepath.Join(dest, "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(content))
+}
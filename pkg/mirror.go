@@ -0,0 +1,66 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Mirror rewrites remotes starting with From to start with To instead,
+// like git's url.<base>.insteadOf, so that packages are fetched from an
+// internal mirror. A trailing * of either is ignored, so that
+// https://github.com/org/* reads like the pattern it is.
+type Mirror struct {
+	From string
+	To   string
+}
+
+// ParseMirror parses a mirror given as from=to, like
+// https://github.com/org/*=https://git.corp/org/*.
+func ParseMirror(s string) (Mirror, error) {
+	parts := strings.SplitN(s, "=", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Mirror{}, fmt.Errorf("invalid mirror %q, expected from=to", s)
+	}
+
+	return Mirror{
+		From: strings.TrimSuffix(parts[0], "*"),
+		To:   strings.TrimSuffix(parts[1], "*"),
+	}, nil
+}
+
+// Mirrors rewrite the remotes of packages at fetch time. The lock file
+// still records the original remotes, so that it is the same with and
+// without mirrors.
+type Mirrors []Mirror
+
+// Rewrite returns remote rewritten by the mirror with the longest matching
+// From, or remote itself if no mirror matches.
+func (ms Mirrors) Rewrite(remote string) string {
+	sorted := make(Mirrors, len(ms))
+	copy(sorted, ms)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].From) > len(sorted[j].From)
+	})
+
+	for _, m := range sorted {
+		if strings.HasPrefix(remote, m.From) {
+			return m.To + strings.TrimPrefix(remote, m.From)
+		}
+	}
+	return remote
+}
@@ -0,0 +1,81 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorsRewrite(t *testing.T) {
+	mirrors := Mirrors{}
+	for _, s := range []string{
+		"https://github.com/*=https://git.corp/github/*",
+		"https://github.com/org/*=https://git.corp/org/*",
+		"git@github.com:=ssh://git.corp/github/",
+	} {
+		m, err := ParseMirror(s)
+		assert.NoError(t, err)
+		mirrors = append(mirrors, m)
+	}
+
+	for remote, want := range map[string]string{
+		"https://github.com/org/repo":     "https://git.corp/org/repo",
+		"https://github.com/other/repo":   "https://git.corp/github/other/repo",
+		"git@github.com:org/repo.git":     "ssh://git.corp/github/org/repo.git",
+		"https://gitlab.com/org/repo.git": "https://gitlab.com/org/repo.git",
+	} {
+		assert.Equal(t, want, mirrors.Rewrite(remote), remote)
+	}
+
+	for _, s := range []string{"https://github.com/", "=https://git.corp/", "https://github.com/="} {
+		_, err := ParseMirror(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestInstallerMirrors(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-mirror")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The remote does not exist, only its mirror does.
+	dep := gitDependency("lib", "https://jb.invalid/lib", "master")
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{dep}}
+
+	i := &Installer{
+		JsonnetHome: filepath.Join(tempDir, "vendor"),
+		Mirrors:     Mirrors{{From: "https://jb.invalid/lib", To: repo.Dir}},
+	}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://jb.invalid/lib", lock.Dependencies[0].Source.GitSource.Remote)
+
+	exists, err := FileExists(filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
@@ -0,0 +1,218 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// NameCollisionError is returned when two dependencies from different
+// sources would be vendored into the same directory.
+type NameCollisionError struct {
+	Name   string
+	First  string
+	Second string
+}
+
+func (e *NameCollisionError) Error() string {
+	return fmt.Sprintf("dependencies %s and %s would both be vendored as %s: rename one of them or enable name disambiguation", e.First, e.Second, e.Name)
+}
+
+// NestedNameError is returned when a dependency would be vendored inside
+// the directory of another one, which installing the other one would
+// overwrite. Names are paths below the vendor directory, see ValidateName.
+type NestedNameError struct {
+	Name   string
+	Parent string
+}
+
+func (e *NestedNameError) Error() string {
+	return fmt.Sprintf("dependency %s would be vendored inside dependency %s: rename one of them", e.Name, e.Parent)
+}
+
+// ValidateName returns an error unless name, which is the path a
+// dependency is vendored at below the vendor directory, stays within it,
+// like grafonnet or lib/grafonnet. Upstream libraries importing each other
+// by paths unlike the default layout are vendored where they expect each
+// other this way.
+func ValidateName(name string) error {
+	slashed := filepath.ToSlash(name)
+	if slashed == "" || path.IsAbs(slashed) || filepath.VolumeName(name) != "" || path.Clean(slashed) != slashed ||
+		slashed == "." || slashed == ".." || strings.HasPrefix(slashed, "../") {
+		return fmt.Errorf("invalid dependency name %q, names are paths below the vendor directory like grafonnet or lib/grafonnet", name)
+	}
+	return nil
+}
+
+// CheckNameCollisions returns a NameCollisionError if two of deps share a
+// name but come from different sources, and a NestedNameError if one of
+// them would be vendored inside another.
+func CheckNameCollisions(deps []spec.Dependency) error {
+	seen := map[string]spec.Dependency{}
+	for _, d := range deps {
+		prev, ok := seen[d.Name]
+		if ok && !SameSource(prev.Source, d.Source) {
+			return &NameCollisionError{Name: d.Name, First: SourceString(prev.Source), Second: SourceString(d.Source)}
+		}
+		seen[d.Name] = d
+	}
+	for _, d := range deps {
+		for parent := range seen {
+			if nestedName(d.Name, parent) {
+				return &NestedNameError{Name: d.Name, Parent: parent}
+			}
+		}
+	}
+
+	return nil
+}
+
+// nestedName reports whether the dependency name is vendored inside the
+// directory of the dependency parent.
+func nestedName(name, parent string) bool {
+	return strings.HasPrefix(filepath.ToSlash(name), filepath.ToSlash(parent)+"/")
+}
+
+// Rename is a dependency renamed by DisambiguateNames.
+type Rename struct {
+	// Name is the name of the dependency before, To the one after the
+	// rename, and Remote the git remote its new name was taken from.
+	Name   string
+	To     string
+	Remote string
+}
+
+// DisambiguateNames renames dependencies that collide by name with a
+// dependency from a different source, by prefixing them with the
+// organization of their remote, and returns every rename. Dependencies
+// without collisions keep their name.
+func DisambiguateNames(deps []spec.Dependency) ([]spec.Dependency, []Rename) {
+	sources := map[string]map[string]bool{}
+	for _, d := range deps {
+		if sources[d.Name] == nil {
+			sources[d.Name] = map[string]bool{}
+		}
+		sources[d.Name][SourceString(d.Source)] = true
+	}
+
+	res := make([]spec.Dependency, 0, len(deps))
+	var renames []Rename
+	for _, d := range deps {
+		if len(sources[d.Name]) > 1 && d.Source.GitSource != nil {
+			if org := remoteOrg(d.Source.GitSource.Remote); org != "" {
+				renames = append(renames, Rename{Name: d.Name, To: org + "-" + d.Name, Remote: d.Source.GitSource.Remote})
+				d.Name = org + "-" + d.Name
+			}
+		}
+		res = append(res, d)
+	}
+
+	return res, renames
+}
+
+// DisambiguateNames renames the dependencies like the function of the same
+// name, telling Renamed about every rename.
+func (i *Installer) DisambiguateNames(deps []spec.Dependency) []spec.Dependency {
+	deps, renames := DisambiguateNames(deps)
+	if i.Renamed != nil {
+		for _, r := range renames {
+			i.Renamed(r)
+		}
+	}
+	return deps
+}
+
+// SourceString identifies the source s in messages and comparisons: the URL
+// of archives and release assets, the directory of local sources, the
+// repository of OCI artifacts, the type and config of custom sources and the
+// remote, followed by the subdir if any, of git and Mercurial sources.
+func SourceString(s spec.Source) string {
+	if s.ReleaseAssetSource != nil {
+		return s.ReleaseAssetSource.URL
+	}
+	if s.LocalSource != nil {
+		return s.LocalSource.Directory
+	}
+	if s.ArchiveSource != nil {
+		if s.ArchiveSource.Subdir == "" {
+			return s.ArchiveSource.URL
+		}
+		return s.ArchiveSource.URL + "//" + s.ArchiveSource.Subdir
+	}
+	if s.OCISource != nil {
+		return "oci://" + s.OCISource.Repository
+	}
+	if s.CustomSource != nil {
+		if len(s.CustomSource.Config) == 0 {
+			return s.CustomSource.Type
+		}
+		// The keys of the config are sorted.
+		config, _ := json.Marshal(s.CustomSource.Config)
+		return s.CustomSource.Type + ":" + string(config)
+	}
+	if s.HgSource != nil {
+		if s.HgSource.Subdir == "" {
+			return s.HgSource.Remote
+		}
+		return s.HgSource.Remote + "/" + s.HgSource.Subdir
+	}
+	if s.GitSource == nil {
+		return ""
+	}
+	if s.GitSource.Subdir == "" {
+		return s.GitSource.Remote
+	}
+	return s.GitSource.Remote + "/" + s.GitSource.Subdir
+}
+
+// SameSource reports whether a and b are the same source: of the same type
+// and with the same SourceString. The checksums recorded for archives and
+// release assets in the lock are not compared, the digests of the packages
+// cover them.
+func SameSource(a, b spec.Source) bool {
+	switch {
+	case (a.GitSource == nil) != (b.GitSource == nil),
+		(a.HgSource == nil) != (b.HgSource == nil),
+		(a.ArchiveSource == nil) != (b.ArchiveSource == nil),
+		(a.ReleaseAssetSource == nil) != (b.ReleaseAssetSource == nil),
+		(a.LocalSource == nil) != (b.LocalSource == nil),
+		(a.OCISource == nil) != (b.OCISource == nil),
+		(a.CustomSource == nil) != (b.CustomSource == nil):
+		return false
+	}
+	return SourceString(a) == SourceString(b)
+}
+
+// remoteOrg returns the path segment preceding the repository in a git
+// remote, e.g. "foo" for https://github.com/foo/bar and git@host:foo/bar.
+func remoteOrg(remote string) string {
+	if i := strings.Index(remote, "://"); i >= 0 {
+		remote = remote[i+3:]
+	}
+	remote = strings.Replace(remote, ":", "/", -1)
+
+	segments := strings.Split(strings.Trim(remote, "/"), "/")
+	if len(segments) < 3 {
+		return ""
+	}
+
+	return segments[len(segments)-2]
+}
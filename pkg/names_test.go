@@ -0,0 +1,127 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNameCollisions(t *testing.T) {
+	deps := []spec.Dependency{
+		gitDependency("util", "https://github.com/foo/util", "master"),
+		gitDependency("util", "git@github.com:bar/util", "master"),
+		gitDependency("other", "https://github.com/foo/other", "master"),
+	}
+
+	err := CheckNameCollisions(deps)
+	assert.IsType(t, &NameCollisionError{}, err)
+
+	renamed, renames := DisambiguateNames(deps)
+	assert.Equal(t, []Rename{
+		{Name: "util", To: "foo-util", Remote: "https://github.com/foo/util"},
+		{Name: "util", To: "bar-util", Remote: "git@github.com:bar/util"},
+	}, renames)
+	assert.Equal(t, "foo-util", renamed[0].Name)
+	assert.Equal(t, "bar-util", renamed[1].Name)
+	assert.Equal(t, "other", renamed[2].Name)
+	assert.NoError(t, CheckNameCollisions(renamed))
+
+	// The same source listed twice is not a collision.
+	assert.NoError(t, CheckNameCollisions([]spec.Dependency{deps[0], deps[0]}))
+
+	// Names are paths, a package cannot be vendored inside another one.
+	nested := gitDependency("util/lib", "https://github.com/baz/lib", "master")
+	err = CheckNameCollisions([]spec.Dependency{nested, deps[0]})
+	assert.Equal(t, &NestedNameError{Name: "util/lib", Parent: "util"}, err)
+	assert.NoError(t, CheckNameCollisions([]spec.Dependency{gitDependency("utils/lib", "https://github.com/baz/lib", "master"), deps[0]}))
+}
+
+func TestSameSource(t *testing.T) {
+	git := spec.Source{GitSource: &spec.GitSource{Remote: "https://example.com/lib"}}
+	hg := spec.Source{HgSource: &spec.HgSource{Remote: "https://example.com/lib"}}
+	archive := func(sha string) spec.Source {
+		return spec.Source{ArchiveSource: &spec.ArchiveSource{URL: "https://example.com/lib.tar.gz", SHA256: sha}}
+	}
+
+	assert.True(t, SameSource(git, spec.Source{GitSource: &spec.GitSource{Remote: "https://example.com/lib"}}))
+	assert.False(t, SameSource(git, spec.Source{GitSource: &spec.GitSource{Remote: "https://example.com/lib", Subdir: "sub"}}))
+	assert.False(t, SameSource(git, hg))
+	// The checksum recorded in the lock does not make another source.
+	assert.True(t, SameSource(archive(""), archive("abc")))
+}
+
+func TestValidateName(t *testing.T) {
+	for _, name := range []string{"grafonnet", "lib/grafonnet", "github.com/grafana/grafonnet-lib/grafonnet", "v1.2"} {
+		assert.NoError(t, ValidateName(name), name)
+	}
+	for _, name := range []string{"", ".", "..", "../lib", "lib/../../etc", "/etc", "lib/", "lib//grafonnet", "./lib"} {
+		assert.Error(t, ValidateName(name), name)
+	}
+}
+
+func TestInstallerCustomPath(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("grafonnet/main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The subdir is vendored where the name says, below the vendor
+	// directory only.
+	dep := gitDependency("lib/grafonnet", repo.Dir, "master")
+	dep.Source.GitSource.Subdir = "grafonnet"
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{dep}}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Equal(t, "lib/grafonnet", lock.Dependencies[0].Name)
+	exists, err := FileExists(filepath.Join(i.JsonnetHome, "lib", "grafonnet", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+
+	m.Dependencies[0].Name = "../grafonnet"
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.EqualError(t, err, `invalid dependency name "../grafonnet", names are paths below the vendor directory like grafonnet or lib/grafonnet`)
+}
+
+func TestInstallerNameCollision(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The remotes do not exist, the collision must be reported before
+	// anything is cloned.
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("util", NotExist+"/foo/util", "master"),
+		gitDependency("util", NotExist+"/bar/util", "master"),
+	}}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.IsType(t, &NameCollisionError{}, err)
+}
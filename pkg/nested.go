@@ -0,0 +1,89 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// NestedPackages returns the dependencies on the packages nested in the
+// repository of dep, a git dependency on its root: the subdirs holding a
+// jsonnetfile, like those of monorepos such as kube-prometheus, which are
+// better installed individually than the repository as a whole. The
+// repository is fetched at the version of dep to find them. Only the
+// outermost of nested packages are returned, packages in hidden and vendor
+// directories are skipped. Other dependencies have no nested packages.
+func (i *Installer) NestedPackages(ctx context.Context, dep spec.Dependency) ([]spec.Dependency, error) {
+	if dep.Source.GitSource == nil || dep.Source.GitSource.Subdir != "" {
+		return nil, nil
+	}
+	expanded, err := ExpandRemotes([]spec.Dependency{dep})
+	if err != nil {
+		return nil, err
+	}
+	if err := i.checkSources(expanded); err != nil {
+		return nil, err
+	}
+	if expanded, err = i.resolveImports(ctx, expanded); err != nil {
+		return nil, err
+	}
+	if err := i.checkSources(expanded); err != nil {
+		return nil, err
+	}
+	if expanded[0].Source.GitSource == nil || expanded[0].Source.GitSource.Subdir != "" {
+		return nil, nil
+	}
+
+	tmp, err := ioutil.TempDir("", "jb-nested")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	p := i.newGitPackage(expanded[0], expanded[0].Source.GitSource, "", []string{dep.Name}, nil)
+	if _, err := i.fetchOnce(ctx, p, tmp, dep.Name, dep.Version); err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch %s", dep.Name)
+	}
+
+	nested := []spec.Dependency{}
+	err = filepath.Walk(tmp, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() || path == tmp {
+			return nil
+		}
+		if name := info.Name(); name[0] == '.' || name == "vendor" {
+			return filepath.SkipDir
+		}
+		if exists, _ := FileExists(filepath.Join(path, JsonnetFile)); !exists {
+			return nil
+		}
+
+		rel, err := filepath.Rel(tmp, path)
+		if err != nil {
+			return err
+		}
+		nested = append(nested, expandDependency(dep, filepath.ToSlash(rel)))
+		return filepath.SkipDir
+	})
+	return nested, err
+}
@@ -0,0 +1,54 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNestedPackages(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit(JsonnetFile, `{"version": 1, "dependencies": []}`)
+	repo.commit("jsonnet/kube-prometheus/"+JsonnetFile, `{"version": 1, "dependencies": []}`)
+	repo.commit("jsonnet/kube-prometheus/lib/"+JsonnetFile, `{"version": 1, "dependencies": []}`)
+	repo.commit("jsonnet/other/"+JsonnetFile, `{"version": 1, "dependencies": []}`)
+	repo.commit("jsonnet/plain/main.libsonnet", "{}")
+	repo.commit("examples/vendor/dep/"+JsonnetFile, `{"version": 1, "dependencies": []}`)
+	repo.commit(".github/"+JsonnetFile, `{"version": 1, "dependencies": []}`)
+
+	i := &Installer{}
+	nested, err := i.NestedPackages(context.TODO(), gitDependency("repo", repo.Dir, "master"))
+	assert.NoError(t, err)
+	assert.Equal(t, []spec.Dependency{
+		gitSubdirDependency("kube-prometheus", repo.Dir, "jsonnet/kube-prometheus", "master"),
+		gitSubdirDependency("other", repo.Dir, "jsonnet/other", "master"),
+	}, nested)
+
+	// Dependencies on subdirs have none.
+	nested, err = i.NestedPackages(context.TODO(), gitSubdirDependency("other", repo.Dir, "jsonnet/other", "master"))
+	assert.NoError(t, err)
+	assert.Empty(t, nested)
+}
+
+func gitSubdirDependency(name, remote, subdir, version string) spec.Dependency {
+	dep := gitDependency(name, remote, version)
+	dep.Source.GitSource.Subdir = subdir
+	return dep
+}
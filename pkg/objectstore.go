@@ -0,0 +1,94 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IsObjectURL reports whether rawurl is the URL of an object in S3, like
+// s3://bucket/key, or in Google Cloud Storage, like gs://bucket/key.
+func IsObjectURL(rawurl string) bool {
+	return strings.HasPrefix(rawurl, "s3://") || strings.HasPrefix(rawurl, "gs://")
+}
+
+// downloadObject downloads the object of rawurl into w, authenticated with
+// the credentials found like the AWS and Google Cloud SDKs do, see
+// awsCredentials and gcsToken, or anonymously without any. It returns the
+// SHA256 checksum of the object and its revision, the ETag of S3 objects and
+// the generation of Google Cloud Storage ones. Objects that are no longer at
+// the revision given, if any, fail the download before their content is.
+func downloadObject(ctx context.Context, caFile, rawurl, revision string, w io.Writer) (sum, rev string, err error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return "", "", err
+	}
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("invalid object URL %s, expected %s://<bucket>/<key>", rawurl, u.Scheme)
+	}
+
+	var req *http.Request
+	var revisionHeader string
+	switch u.Scheme {
+	case "s3":
+		req, err = s3Request(ctx, bucket, key)
+		revisionHeader = "ETag"
+	case "gs":
+		req, err = gcsRequest(ctx, caFile, bucket, key)
+		revisionHeader = "X-Goog-Generation"
+	default:
+		return "", "", fmt.Errorf("unsupported object URL %s", rawurl)
+	}
+	if err != nil {
+		return "", "", errors.Wrapf(err, "failed to download %s", rawurl)
+	}
+
+	resp, err := send(ctx, caFile, req, rawurl)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	rev = strings.Trim(resp.Header.Get(revisionHeader), `"`)
+	if revision != "" && rev != revision {
+		return "", "", fmt.Errorf("%s changed since it was locked at %s %s, it is %s now", rawurl, strings.ToLower(strings.TrimPrefix(revisionHeader, "X-Goog-")), revision, rev)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), throttle(ctx, resp.Body)); err != nil {
+		return "", "", retryableDownload(ctx, errors.Wrapf(err, "failed to download %s", rawurl))
+	}
+	return hex.EncodeToString(h.Sum(nil)), rev, nil
+}
+
+// escapeObjectKey escapes the segments of the object key p for the path of
+// a URL, keeping the slashes, the way S3 signs it.
+func escapeObjectKey(p string) string {
+	segments := strings.Split(p, "/")
+	for n, s := range segments {
+		segments[n] = awsEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
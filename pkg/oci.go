@@ -0,0 +1,286 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+const (
+	// OCIArtifactType is the artifact type of the manifests of packages.
+	OCIArtifactType = "application/vnd.jsonnet-bundler.package.v1"
+	// OCIConfigMediaType is the media type of the empty config of the
+	// manifests of packages.
+	OCIConfigMediaType = "application/vnd.jsonnet-bundler.package.config.v1+json"
+	// OCILayerMediaType is the media type of the gzipped tarball holding
+	// the files of a package.
+	OCILayerMediaType = "application/vnd.jsonnet-bundler.package.layer.v1.tar+gzip"
+
+	ociManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+)
+
+var ociDigestRegex = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// IsOCIDigest reports whether version is the digest of an OCI manifest,
+// like sha256:0123..., rather than a tag.
+func IsOCIDigest(version string) bool {
+	return ociDigestRegex.MatchString(version)
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType"`
+	ArtifactType  string            `json:"artifactType,omitempty"`
+	Config        ociDescriptor     `json:"config"`
+	Layers        []ociDescriptor   `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// OCIPackage installs a package pushed as an OCI artifact to a container
+// registry, see PushOCI, authenticated with the credentials of docker, see
+// dockerCredentials. Versions are tags, latest if empty, or digests, and
+// are locked at the digest of the manifest.
+type OCIPackage struct {
+	Source *spec.OCISource
+
+	// CAFile holds the certificate authorities trusted for HTTPS, see
+	// CAFileEnv. The system ones are trusted if it is empty.
+	CAFile string
+
+	// tag is the tag installed, set by Install.
+	tag string
+}
+
+func NewOCIPackage(source *spec.OCISource) *OCIPackage {
+	return &OCIPackage{
+		Source: source,
+	}
+}
+
+// Install downloads the manifest of version and extracts its package layer
+// into dir, verifying the digests of both, and returns the digest of the
+// manifest.
+func (p *OCIPackage) Install(ctx context.Context, dir, version string) (lockVersion string, err error) {
+	if version == "" {
+		version = "latest"
+	}
+	if semver.IsConstraint(version) {
+		return "", fmt.Errorf("version constraints like %s are not supported for OCI artifacts, use a tag", version)
+	}
+	r, err := newOCIRegistry(p.CAFile, p.Source.Repository, false)
+	if err != nil {
+		return "", err
+	}
+
+	m, digest, err := r.manifest(ctx, version)
+	if err != nil {
+		return "", err
+	}
+	layer, err := packageLayer(m)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to install %s:%s", p.Source.Repository, version)
+	}
+
+	f, err := ioutil.TempFile("", "jsonnetpkg-oci")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if err := r.blob(ctx, layer.Digest, f); err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to extract %s:%s", p.Source.Repository, version)
+	}
+	defer gz.Close()
+	if _, err := extractTar(gz, dir); err != nil {
+		return "", errors.Wrapf(err, "failed to extract %s:%s", p.Source.Repository, version)
+	}
+
+	if !IsOCIDigest(version) {
+		p.tag = version
+	}
+	return digest, nil
+}
+
+// LockTag returns the tag installed, none if it was a digest.
+func (p *OCIPackage) LockTag() string {
+	return p.tag
+}
+
+// packageLayer returns the layer of m holding the package: the one of
+// OCILayerMediaType, or else the only gzipped tarball, for artifacts pushed
+// by other tools like oras.
+func packageLayer(m ociManifest) (ociDescriptor, error) {
+	tarballs := []ociDescriptor{}
+	for _, l := range m.Layers {
+		switch l.MediaType {
+		case OCILayerMediaType:
+			return l, nil
+		case "application/vnd.oci.image.layer.v1.tar+gzip", "application/tar+gzip", "application/x-gzip":
+			tarballs = append(tarballs, l)
+		}
+	}
+	if len(tarballs) != 1 {
+		return ociDescriptor{}, fmt.Errorf("the artifact has no layer of %s", OCILayerMediaType)
+	}
+	return tarballs[0], nil
+}
+
+// PushOCI pushes archive, the gzipped tarball of a package, as an OCI
+// artifact to repository with tag, like jb install installs it, and returns
+// the digest of the manifest.
+func PushOCI(ctx context.Context, caFile, repository, tag string, archive []byte) (string, error) {
+	r, err := newOCIRegistry(caFile, repository, true)
+	if err != nil {
+		return "", err
+	}
+
+	config := []byte("{}")
+	m := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociManifestMediaType,
+		ArtifactType:  OCIArtifactType,
+		Config:        ociDescriptor{MediaType: OCIConfigMediaType, Digest: sha256Digest(config), Size: int64(len(config))},
+		Layers:        []ociDescriptor{{MediaType: OCILayerMediaType, Digest: sha256Digest(archive), Size: int64(len(archive))}},
+		Annotations:   map[string]string{"org.opencontainers.image.version": tag},
+	}
+	for _, b := range [][]byte{config, archive} {
+		if err := r.upload(ctx, b); err != nil {
+			return "", err
+		}
+	}
+
+	body, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", ociManifestMediaType)
+	resp, err := r.do(ctx, http.MethodPut, "/manifests/"+tag, header, body)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to push the manifest of %s:%s", repository, tag)
+	}
+	resp.Body.Close()
+	return sha256Digest(body), nil
+}
+
+func sha256Digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// manifest returns the manifest of ref, a tag or digest, and its digest,
+// which must be ref if it is a digest.
+func (r *ociRegistry) manifest(ctx context.Context, ref string) (ociManifest, string, error) {
+	header := http.Header{}
+	header.Set("Accept", ociManifestMediaType)
+	resp, err := r.do(ctx, http.MethodGet, "/manifests/"+ref, header, nil)
+	if err != nil {
+		return ociManifest{}, "", errors.Wrapf(err, "failed to get the manifest of %s:%s", r.repository, ref)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ociManifest{}, "", retryableDownload(ctx, errors.Wrapf(err, "failed to get the manifest of %s:%s", r.repository, ref))
+	}
+
+	digest := sha256Digest(body)
+	if IsOCIDigest(ref) && digest != ref {
+		return ociManifest{}, "", fmt.Errorf("the manifest of %s@%s has the digest %s", r.repository, ref, digest)
+	}
+	var m ociManifest
+	if err := json.Unmarshal(body, &m); err != nil {
+		return ociManifest{}, "", errors.Wrapf(err, "invalid manifest of %s:%s", r.repository, ref)
+	}
+	return m, digest, nil
+}
+
+// blob downloads the blob of digest into w, verifying its digest.
+func (r *ociRegistry) blob(ctx context.Context, digest string, w io.Writer) error {
+	if !IsOCIDigest(digest) {
+		return fmt.Errorf("unsupported digest %s of a layer of %s", digest, r.repository)
+	}
+	resp, err := r.do(ctx, http.MethodGet, "/blobs/"+digest, nil, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to download %s of %s", digest, r.repository)
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, h), throttle(ctx, resp.Body)); err != nil {
+		return retryableDownload(ctx, errors.Wrapf(err, "failed to download %s of %s", digest, r.repository))
+	}
+	if got := "sha256:" + hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("the layer %s of %s has the digest %s", digest, r.repository, got)
+	}
+	return nil
+}
+
+// upload uploads the blob b unless the repository has it already.
+func (r *ociRegistry) upload(ctx context.Context, b []byte) error {
+	digest := sha256Digest(b)
+	if resp, err := r.do(ctx, http.MethodHead, "/blobs/"+digest, nil, nil); err == nil {
+		resp.Body.Close()
+		return nil
+	}
+
+	resp, err := r.do(ctx, http.MethodPost, "/blobs/uploads/", nil, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload to %s", r.repository)
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload to %s, the registry did not tell where to", r.repository)
+	}
+	q := location.Query()
+	q.Set("digest", digest)
+	location.RawQuery = q.Encode()
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err = r.doURL(ctx, http.MethodPut, location.String(), header, b)
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload %s to %s", digest, r.repository)
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRegistry is a registry of the OCI distribution API holding blobs and
+// manifests in memory, which hands out tokens to user:secret.
+type fakeRegistry struct {
+	sync.Mutex
+	blobs     map[string][]byte
+	manifests map[string][]byte
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.URL.Path == "/token" {
+		if username, secret, ok := r.BasicAuth(); !ok || username != "user" || secret != "secret" || r.FormValue("scope") == "" {
+			http.Error(w, "denied", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"token": "token"}`))
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+r.Host+`/token",service="registry"`)
+		http.Error(w, `{"errors": [{"code": "UNAUTHORIZED", "message": "authentication required"}]}`, http.StatusUnauthorized)
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/v2/org/lib/")
+	switch {
+	case r.Method == http.MethodPost && p == "blobs/uploads/":
+		w.Header().Set("Location", "/v2/org/lib/blobs/uploads/1?state=x")
+		w.WriteHeader(http.StatusAccepted)
+	case r.Method == http.MethodPut && strings.HasPrefix(p, "blobs/uploads/"):
+		b, _ := ioutil.ReadAll(r.Body)
+		f.blobs[r.FormValue("digest")] = b
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && strings.HasPrefix(p, "manifests/"):
+		b, _ := ioutil.ReadAll(r.Body)
+		f.manifests[strings.TrimPrefix(p, "manifests/")] = b
+		f.manifests[sha256Digest(b)] = b
+		w.WriteHeader(http.StatusCreated)
+	case strings.HasPrefix(p, "blobs/") && f.blobs[strings.TrimPrefix(p, "blobs/")] != nil:
+		w.Write(f.blobs[strings.TrimPrefix(p, "blobs/")])
+	case strings.HasPrefix(p, "manifests/") && f.manifests[strings.TrimPrefix(p, "manifests/")] != nil:
+		w.Header().Set("Content-Type", ociManifestMediaType)
+		w.Write(f.manifests[strings.TrimPrefix(p, "manifests/")])
+	default:
+		http.Error(w, `{"errors": [{"code": "MANIFEST_UNKNOWN", "message": "manifest unknown"}]}`, http.StatusNotFound)
+	}
+}
+
+func TestInstallerOCI(t *testing.T) {
+	srv := httptest.NewServer(&fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}})
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "http://")
+
+	tempDir, err := ioutil.TempDir("", "jb-oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "config.json"), []byte(`{"auths": {"http://`+host+`": {"auth": "`+auth+`"}}}`), 0600))
+	defer setenv(map[string]string{"DOCKER_CONFIG": tempDir})()
+
+	digest, err := PushOCI(context.TODO(), "", host+"/org/lib", "1.4.0", tarGz(t, map[string]string{"main.libsonnet": "{}"}))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{{
+		Name:    "lib",
+		Source:  spec.Source{OCISource: &spec.OCISource{Repository: host + "/org/lib"}},
+		Version: "1.4.0",
+	}}}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, digest, lock.Dependencies[0].Version)
+	assert.Equal(t, "1.4.0", lock.Dependencies[0].Tag)
+	_, err = os.Stat(filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+
+	// The lock file installs the manifest by its digest.
+	lock, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.4.0", lock.Dependencies[0].Tag)
+
+	m.Dependencies[0].Version = "2.0.0"
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.EqualError(t, err, "failed to install package: failed to get the manifest of org/lib:2.0.0: 404 Not Found: manifest unknown")
+}
+
+func TestParseChallenge(t *testing.T) {
+	scheme, params := parseChallenge(`Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="repository:org/lib:pull,push"`)
+	assert.Equal(t, "bearer", scheme)
+	assert.Equal(t, map[string]string{"realm": "https://ghcr.io/token", "service": "ghcr.io", "scope": "repository:org/lib:pull,push"}, params)
+
+	scheme, params = parseChallenge(`Basic realm=registry`)
+	assert.Equal(t, "basic", scheme)
+	assert.Equal(t, map[string]string{"realm": "registry"}, params)
+}
+
+func TestDockerCredentials(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake credential helper is a shell script")
+	}
+	tempDir, err := ioutil.TempDir("", "jb-docker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	helper := "#!/bin/sh\nread host\nif [ \"$host\" = ghcr.io ]; then echo '{\"Username\": \"helper\", \"Secret\": \"s3cret\"}'; else echo 'credentials not found in native keychain'; exit 1; fi\n"
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "docker-credential-fake"), []byte(helper), 0755))
+	auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	config := `{"auths": {"https://index.docker.io/v1/": {"auth": "` + auth + `"}}, "credHelpers": {"ghcr.io": "fake"}, "credsStore": "fake"}`
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "config.json"), []byte(config), 0600))
+	defer setenv(map[string]string{
+		"DOCKER_CONFIG": tempDir,
+		"PATH":          tempDir + string(os.PathListSeparator) + os.Getenv("PATH"),
+	})()
+
+	for host, expected := range map[string][]string{
+		"ghcr.io":               {"helper", "s3cret"},
+		dockerHubCredentialsKey: {"user", "secret"},
+		"registry.example.com":  {"", ""},
+	} {
+		username, secret, err := dockerCredentials(host)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, []string{username, secret}, host)
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// dockerHubCredentialsKey is the key of Docker Hub in the docker config.
+const dockerHubCredentialsKey = "https://index.docker.io/v1/"
+
+// ociRegistry requests the OCI distribution API of the registry of a
+// repository, authenticating as the registry asks for.
+type ociRegistry struct {
+	client *http.Client
+	// base is the URL of the registry, host the name of its credentials.
+	base, host string
+	repository string
+	// scope is the access requested for tokens.
+	scope string
+	// authorization is the header of the requests once authenticated.
+	authorization string
+}
+
+// newOCIRegistry returns the registry of repository, like ghcr.io/org/lib,
+// for pulling or pushing. Docker Hub is docker.io, and registries on the
+// loopback interface are requested over HTTP.
+func newOCIRegistry(caFile, repository string, push bool) (*ociRegistry, error) {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("invalid OCI repository %s, expected <registry>/<repository>", repository)
+	}
+	client, err := httpClient(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ociRegistry{client: client, host: parts[0], repository: parts[1]}
+	scheme := "https"
+	if hostname := strings.Split(r.host, ":")[0]; hostname == "localhost" || net.ParseIP(hostname).IsLoopback() {
+		scheme = "http"
+	}
+	r.base = scheme + "://" + r.host
+	if r.host == "docker.io" {
+		r.base, r.host = "https://registry-1.docker.io", dockerHubCredentialsKey
+		if !strings.Contains(r.repository, "/") {
+			r.repository = "library/" + r.repository
+		}
+	}
+
+	r.scope = "repository:" + r.repository + ":pull"
+	if push {
+		r.scope += ",push"
+	}
+	return r, nil
+}
+
+// do requests path below the repository, see doURL.
+func (r *ociRegistry) do(ctx context.Context, method, path string, header http.Header, body []byte) (*http.Response, error) {
+	return r.doURL(ctx, method, r.base+"/v2/"+r.repository+path, header, body)
+}
+
+// doURL requests rawurl, authenticating once the registry challenges the
+// request, and returns the response if it succeeded. Failures are
+// classified by their status.
+func (r *ociRegistry) doURL(ctx context.Context, method, rawurl string, header http.Header, body []byte) (*http.Response, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	if !u.IsAbs() {
+		base, _ := url.Parse(r.base)
+		u = base.ResolveReference(u)
+	}
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		if r.authorization != "" {
+			req.Header.Set("Authorization", r.authorization)
+		}
+
+		resp, err := r.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, retryableDownload(ctx, err)
+		}
+		if resp.StatusCode < 300 {
+			return resp, nil
+		}
+		msg, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			if err := r.authenticate(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		err = statusError(fmt.Errorf("%s%s", resp.Status, ociErrorMessage(msg)), resp.StatusCode)
+		if retryableStatus(resp.StatusCode) {
+			return nil, &RetryableError{Err: err, After: retryAfter(resp)}
+		}
+		return nil, err
+	}
+}
+
+// ociErrorMessage returns the messages of the errors in the body of a
+// failed response, prefixed by a colon.
+func ociErrorMessage(body []byte) string {
+	var e struct {
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &e) != nil {
+		return ""
+	}
+	messages := []string{}
+	for _, m := range e.Errors {
+		if m.Message != "" {
+			messages = append(messages, m.Message)
+		}
+	}
+	if len(messages) == 0 {
+		return ""
+	}
+	return ": " + strings.Join(messages, ", ")
+}
+
+// authenticate answers the challenge of the registry with the credentials
+// of docker, if any: basic authentication, or a token of the scope of r
+// from the realm of a bearer challenge.
+func (r *ociRegistry) authenticate(ctx context.Context, challenge string) error {
+	username, secret, err := dockerCredentials(r.host)
+	if err != nil {
+		return err
+	}
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "basic":
+		if username == "" {
+			return &AuthError{Err: fmt.Errorf("%s requires credentials, log in with docker login %s", r.host, r.host)}
+		}
+		r.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+secret))
+		return nil
+	case "bearer":
+	default:
+		return &AuthError{Err: fmt.Errorf("%s requires unsupported authentication %q", r.host, challenge)}
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("%s challenged with the invalid realm %q", r.host, params["realm"])
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	q.Set("scope", r.scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, secret)
+	}
+	resp, err := r.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return retryableDownload(ctx, errors.Wrapf(err, "failed to get a token for %s", r.host))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := statusError(fmt.Errorf("failed to get a token for %s: %s", r.host, resp.Status), resp.StatusCode)
+		if retryableStatus(resp.StatusCode) {
+			return &RetryableError{Err: err, After: retryAfter(resp)}
+		}
+		return err
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return errors.Wrapf(err, "invalid token for %s", r.host)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	r.authorization = "Bearer " + token.Token
+	return nil
+}
+
+// parseChallenge parses the WWW-Authenticate header challenge, like
+// Bearer realm="https://ghcr.io/token",service="ghcr.io", into its
+// lowercase scheme and its parameters.
+func parseChallenge(challenge string) (string, map[string]string) {
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	params := map[string]string{}
+	if len(parts) < 2 {
+		return strings.ToLower(parts[0]), params
+	}
+
+	rest := parts[1]
+	for rest != "" {
+		rest = strings.TrimLeft(rest, " ,")
+		eq := strings.Index(rest, "=")
+		if eq < 0 {
+			break
+		}
+		key, value := strings.ToLower(strings.TrimSpace(rest[:eq])), ""
+		rest = rest[eq+1:]
+		if strings.HasPrefix(rest, `"`) {
+			rest = rest[1:]
+			end := strings.Index(rest, `"`)
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], strings.TrimPrefix(rest[end:], `"`)
+		} else {
+			end := strings.Index(rest, ",")
+			if end < 0 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], rest[end:]
+		}
+		params[key] = value
+	}
+	return strings.ToLower(parts[0]), params
+}
+
+// dockerCredentials returns the credentials of the registry host from the
+// docker config, in DOCKER_CONFIG or ~/.docker: those of its credential
+// helper in credHelpers, of its entry in auths or of the credsStore
+// helper. It returns an empty username if there are none.
+func dockerCredentials(host string) (username, secret string, err error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", errors.Wrap(err, "failed to read the docker config")
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+		CredHelpers map[string]string `json:"credHelpers"`
+		CredsStore  string            `json:"credsStore"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return "", "", errors.Wrap(err, "invalid docker config")
+	}
+
+	if helper := config.CredHelpers[host]; helper != "" {
+		return dockerCredentialHelper(helper, host)
+	}
+	for key, a := range config.Auths {
+		if registryHost(key) != host || a.Auth == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "invalid auth of %s in the docker config", key)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", fmt.Errorf("invalid auth of %s in the docker config", key)
+		}
+		return parts[0], parts[1], nil
+	}
+	if config.CredsStore != "" {
+		return dockerCredentialHelper(config.CredsStore, host)
+	}
+	return "", "", nil
+}
+
+// registryHost returns the host of the key of auths in the docker config,
+// which may be a URL.
+func registryHost(key string) string {
+	if key == dockerHubCredentialsKey {
+		return key
+	}
+	key = strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	return strings.Split(key, "/")[0]
+}
+
+// dockerCredentialHelper returns the credentials of host from the docker
+// credential helper docker-credential-<helper>, none if the helper is not
+// installed or has none.
+func dockerCredentialHelper(helper, host string) (username, secret string, err error) {
+	program := "docker-credential-" + helper
+	if _, err := exec.LookPath(program); err != nil {
+		return "", "", nil
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(program, "get")
+	cmd.Stdin = strings.NewReader(host)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if strings.Contains(stdout.String()+stderr.String(), "credentials not found") {
+			return "", "", nil
+		}
+		return "", "", errors.Wrapf(err, "%s failed: %s", program, strings.TrimSpace(stdout.String()+stderr.String()))
+	}
+	var creds struct {
+		Username string
+		Secret   string
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &creds); err != nil {
+		return "", "", errors.Wrapf(err, "%s printed invalid credentials", program)
+	}
+	return creds.Username, creds.Secret, nil
+}
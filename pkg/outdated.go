@@ -0,0 +1,145 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// Outdated is a dependency with a newer version upstream than the locked
+// one.
+type Outdated struct {
+	Name string
+	// Version is the version required by the jsonnetfile.
+	Version string
+	// Locked is the locked commit and LockedTag its tag, if any.
+	Locked    string
+	LockedTag string
+
+	// Wanted is the newest version satisfying Version, the commit its
+	// branch points to or the highest tag matching its constraint. It is
+	// empty if the locked version is still the newest, or if Version is a
+	// tag or commit.
+	Wanted string
+	// Latest is the highest release tag upstream, if it is newer than the
+	// locked tag.
+	Latest string
+}
+
+// Outdated compares the locked versions of the git dependencies of m with
+// their remotes and returns the dependencies that have newer versions,
+// without installing anything. Dependencies that are not locked, or not
+// from git, are skipped.
+func (i *Installer) Outdated(ctx context.Context, m, lock spec.JsonnetFile) ([]Outdated, error) {
+	if i.Offline {
+		return nil, errors.New("outdated dependencies cannot be listed offline, the releases are listed by the remotes")
+	}
+	locked := make(map[string]spec.Dependency, len(lock.Dependencies))
+	for _, d := range lock.Dependencies {
+		locked[d.Name] = d
+	}
+
+	deps, err := ExpandRemotes(m.Dependencies)
+	if err != nil {
+		return nil, err
+	}
+
+	res := []Outdated{}
+	deps = ExpandLockedImports(deps, lock.Dependencies)
+	for _, d := range ExpandLockedSubdirs(deps, lock.Dependencies) {
+		l, ok := locked[d.Name]
+		if !ok || d.Source.GitSource == nil || !SameSource(l.Source, d.Source) {
+			continue
+		}
+
+		if err := i.checkSources([]spec.Dependency{d}); err != nil {
+			return nil, err
+		}
+		p := &GitPackage{Source: d.Source.GitSource, CAFile: i.CAFile, SSHKey: i.SSHKey, SSHKnownHosts: i.SSHKnownHosts, Mirrors: i.Mirrors, Verbose: i.Verbose}
+		refs, head, err := p.remoteRefs(ctx)
+		if err != nil {
+			return nil, err
+		}
+		tags := []string{}
+		for ref := range refs {
+			if strings.HasPrefix(ref, "refs/tags/") {
+				tags = append(tags, strings.TrimPrefix(ref, "refs/tags/"))
+			}
+		}
+
+		o := Outdated{Name: d.Name, Version: d.Version, Locked: l.Version, LockedTag: l.Tag}
+		current := l.Tag
+		switch version := QualifyRef(d.Version); {
+		case semver.IsConstraint(version):
+			c, err := semver.ParseConstraint(version)
+			if err != nil {
+				return nil, err
+			}
+			if best, ok := c.Best(tags); ok && best != l.Tag {
+				o.Wanted = best
+			}
+		case version == "" || strings.HasPrefix(version, "refs/heads/") || refs["refs/heads/"+version] != "":
+			branch := strings.TrimPrefix(version, "refs/heads/")
+			if branch == "" {
+				branch = head
+			}
+			if commit := refs["refs/heads/"+branch]; commit != "" && commit != l.Version {
+				o.Wanted = commit
+			}
+		case strings.HasPrefix(version, "refs/tags/") || refs["refs/tags/"+version] != "":
+			if current == "" {
+				current = strings.TrimPrefix(version, "refs/tags/")
+			}
+		}
+
+		if v, err := semver.Parse(current); err == nil {
+			if latest, ok := latestRelease(tags); ok && semver.Compare(latest, v) > 0 {
+				o.Latest = latest.Original
+			}
+		}
+
+		if o.Wanted != "" || o.Latest != "" {
+			res = append(res, o)
+		}
+	}
+
+	return res, nil
+}
+
+// latestRelease returns the highest of tags that is a semantic version
+// without pre-release.
+func latestRelease(tags []string) (semver.Version, bool) {
+	var latest *semver.Version
+	for _, t := range tags {
+		v, err := semver.Parse(t)
+		if err != nil || v.Pre != "" {
+			continue
+		}
+		if latest == nil || semver.Compare(v, *latest) > 0 {
+			v := v
+			latest = &v
+		}
+	}
+
+	if latest == nil {
+		return semver.Version{}, false
+	}
+	return *latest, true
+}
@@ -0,0 +1,61 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallerOutdated(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+
+	commits := map[string]string{}
+	for _, tag := range []string{"v1.0.0", "v1.1.0", "v2.0.0", "v3.0.0-rc.1"} {
+		commits[tag] = repo.commit("main.libsonnet", tag)
+		repo.git("tag", "-a", "-m", tag, tag)
+	}
+	head := repo.commit("main.libsonnet", "head")
+
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("branch", repo.Dir, "master"),
+		gitDependency("default", repo.Dir, ""),
+		gitDependency("constraint", repo.Dir, "^1.0.0"),
+		gitDependency("tag", repo.Dir, "v1.1.0"),
+		gitDependency("current", repo.Dir, "v2.0.0"),
+		gitDependency("unlocked", repo.Dir, "master"),
+	}}
+	lock := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("branch", repo.Dir, commits["v2.0.0"]),
+		gitDependency("default", repo.Dir, head),
+		gitDependency("constraint", repo.Dir, commits["v1.0.0"]),
+		gitDependency("tag", repo.Dir, commits["v1.1.0"]),
+		gitDependency("current", repo.Dir, commits["v2.0.0"]),
+	}}
+	lock.Dependencies[2].Tag = "v1.0.0"
+
+	i := &Installer{}
+	outdated, err := i.Outdated(context.TODO(), m, lock)
+	assert.NoError(t, err)
+	assert.Equal(t, []Outdated{
+		{Name: "branch", Version: "master", Locked: commits["v2.0.0"], Wanted: head},
+		{Name: "constraint", Version: "^1.0.0", Locked: commits["v1.0.0"], LockedTag: "v1.0.0", Wanted: "v1.1.0", Latest: "v2.0.0"},
+		{Name: "tag", Version: "v1.1.0", Locked: commits["v1.1.0"], Latest: "v2.0.0"},
+	}, outdated)
+}
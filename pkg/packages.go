@@ -16,12 +16,13 @@ package pkg
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
 	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
@@ -34,61 +35,585 @@ var (
 	VersionMismatch = errors.New("multiple colliding versions specified")
 )
 
+// Install vendors the dependencies of m into dir, see Installer.
 func Install(ctx context.Context, isLock bool, dependencySourceIdentifier string, m spec.JsonnetFile, dir string) (*spec.JsonnetFile, error) {
-	lockfile := &spec.JsonnetFile{}
-	for _, dep := range m.Dependencies {
+	i := &Installer{JsonnetHome: dir}
+	return i.install(ctx, isLock, dependencySourceIdentifier, m)
+}
+
+// install vendors the dependencies of m and, unless installing from a lock
+// file, their transitive dependencies. Dependencies are resolved depth-first
+// in the order they are listed, so the same jsonnetfiles always result in
+// the same lock. A dependency that was already installed at the same version
+// during this run, for example because two packages depend on it or because
+// dependencies form a cycle, is not installed again. A dependency required
+// at different versions is resolved according to the Conflicts strategy.
+func (i *Installer) install(ctx context.Context, isLock bool, dependencySourceIdentifier string, m spec.JsonnetFile) (*spec.JsonnetFile, error) {
+	u := *i
+	u.installed = map[string]Requirement{}
+	u.groups = map[string][]string{}
+	u.requires = map[string][]string{}
+	u.lock = &spec.JsonnetFile{}
+	if u.QualifiedNames {
+		u.lock.Version = spec.QualifiedVersion
+	}
+	// Only the keys of the project are trusted, the lock keeps them for
+	// installing it.
+	u.lock.TrustedKeys = m.TrustedKeys
+	u.lock.JB = m.JB
+	u.lock.Features = m.Features
+	u.lock.Kubernetes = m.Kubernetes
+	u.lock.Strip = u.strip()
+	u.lock.Sparse = m.Sparse
+	if isLock {
+		u.lockedStrip = m.Strip
+		u.lockedSparse = m.Sparse
+	}
+	// The dependencies of a lock file were replaced when it was written.
+	u.lock.Replace = m.Replace
+	if !isLock {
+		u.replace = m.Replace
+	}
+	u.replaceDir = filepath.Dir(dependencySourceIdentifier)
+	u.trustedKeys = trustedKeys(dependencySourceIdentifier, m.TrustedKeys)
+	jobs := i.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	u.jobs = make(chan struct{}, jobs)
+	ctx = withMaxRate(ctx, i.MaxRate)
+	if err := checkTransport(i.Prefer); err != nil {
+		return nil, err
+	}
+
+	// Concurrent runs on the same project would stage and replace the
+	// vendor directory under each other.
+	release, err := acquireLock(ctx, vendorLockPath(u.JsonnetHome), u.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-		tmp := filepath.Join(dir, ".tmp")
-		err := os.MkdirAll(tmp, os.ModePerm)
+	// Packages are vendored into a staging copy of the vendor directory,
+	// which replaces it only once everything was installed, so that a
+	// failing install leaves the vendor directory as it was.
+	s, err := stage(u.JsonnetHome)
+	if err != nil {
+		return nil, err
+	}
+	// A dry run is never committed. If the vendor directory would be
+	// installed into in place, it vendors into an empty directory instead.
+	if s == nil && u.DryRun {
+		dir, err := ioutil.TempDir("", "jb-dry-run")
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to create general tmp dir")
+			return nil, errors.Wrap(err, "failed to create staging directory")
 		}
-		tmpDir, err := ioutil.TempDir(tmp, fmt.Sprintf("jsonnetpkg-%s-%s", dep.Name, dep.Version))
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to create tmp dir")
+		s = &staging{dir: dir}
+	}
+	if s != nil {
+		defer s.discard()
+		u.JsonnetHome = s.dir
+	}
+	// Whatever is vendored now, the vendor directory no longer is the one
+	// the recorded hash describes.
+	if !u.DryRun {
+		if err := os.Remove(filepath.Join(u.JsonnetHome, HashFile)); err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "failed to remove %s", HashFile)
 		}
-		defer os.RemoveAll(tmpDir)
+	}
 
-		subdir := ""
-		var p Interface
-		if dep.Source.GitSource != nil {
-			p = NewGitPackage(dep.Source.GitSource)
-			subdir = dep.Source.GitSource.Subdir
+	// The temporary directories of the downloads are gone by the time
+	// installDependencies returns, even when it was canceled.
+	defer os.Remove(filepath.Join(u.JsonnetHome, ".tmp"))
+	if err := u.installDependencies(ctx, isLock, dependencySourceIdentifier, m, nil); err != nil {
+		return nil, err
+	}
+	// The packages just installed are no longer unmanaged, unless they are
+	// marked so again by jb install --single.
+	installed := map[string]bool{}
+	for _, d := range u.lock.Dependencies {
+		installed[d.Name] = true
+	}
+	if err := forgetUnmanaged(u.JsonnetHome, installed); err != nil {
+		return nil, err
+	}
+	if m.Sparse {
+		if err := u.sparsify(filepath.Dir(dependencySourceIdentifier), filepath.Base(i.JsonnetHome)); err != nil {
+			return nil, err
+		}
+	}
+	if u.CheckLock != nil {
+		if err := u.CheckLock(*u.lock); err != nil {
+			return nil, err
 		}
+	}
+	if u.DryRun {
+		return u.lock, nil
+	}
+	if u.Prune {
+		if err := u.prune(); err != nil {
+			return nil, err
+		}
+	}
+	if s != nil {
+		os.Remove(filepath.Join(u.JsonnetHome, ".tmp"))
+		if err := s.commit(); err != nil {
+			return nil, err
+		}
+		u.JsonnetHome = i.JsonnetHome
+	}
+	// Links are created in the vendor directory itself, as junctions and
+	// copies would point into the staging copy.
+	if err := u.linkLegacyNames(); err != nil {
+		return nil, err
+	}
+	if err := u.generateKubernetes(ctx, filepath.Dir(dependencySourceIdentifier), m.Kubernetes); err != nil {
+		return nil, err
+	}
+	if err := u.runProjectHooks(ctx, filepath.Dir(dependencySourceIdentifier)); err != nil {
+		return nil, err
+	}
+	if u.Reproducible {
+		if err := u.normalize(); err != nil {
+			return nil, err
+		}
+	}
+	return u.lock, nil
+}
 
-		lockVersion, err := p.Install(ctx, tmpDir, dep.Version)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to install package")
+// linkStore reports whether packages are hard linked from the Store of
+// StoreDir. Reproducible installs copy them, as normalizing their files would
+// change those of the store.
+func (i *Installer) linkStore() bool {
+	return i.StoreDir != "" && !i.DryRun && !i.Reproducible
+}
+
+// prune removes the packages of the vendor directory which are not part
+// of the lock of the session, like dependencies dropped from the
+// jsonnetfile.
+func (i *Installer) prune() error {
+	// A vendor directory holding a jsonnetfile is most likely the project
+	// itself, which must never be pruned.
+	if exists, _ := FileExists(filepath.Join(i.JsonnetHome, JsonnetFile)); exists {
+		color.Yellow(">>> Not pruning %s, it contains a %s\n", i.JsonnetHome, JsonnetFile)
+		return nil
+	}
+
+	stray, err := extraneous(i.JsonnetHome, *i.lock)
+	if err != nil {
+		return errors.Wrap(err, "failed to list vendored packages")
+	}
+
+	unmanaged, err := unmanagedNames(i.JsonnetHome)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range stray {
+		if err := os.RemoveAll(filepath.Join(i.JsonnetHome, name)); err != nil {
+			return errors.Wrapf(err, "failed to prune %s", name)
+		}
+		if unmanaged[name] {
+			color.Yellow(">>> Pruned %s, it was not managed by %s\n", name, JsonnetFile)
+		} else {
+			color.Yellow(">>> Pruned %s, it is no longer a dependency\n", name)
+		}
+	}
+	return forgetUnmanaged(i.JsonnetHome, unmanaged)
+}
+
+// installDependencies installs the dependencies of m and adds them to the
+// lock of the session, chain holding the names of the packages that led to
+// m being installed.
+func (i *Installer) installDependencies(ctx context.Context, isLock bool, dependencySourceIdentifier string, m spec.JsonnetFile, chain []string) error {
+	dir := i.JsonnetHome
+
+	// Dependencies are downloaded concurrently but installed in order, so
+	// the result does not depend on which download finishes first.
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	var fetches []*fetch
+	clones := map[string]*job{}
+	defer func() {
+		cancel()
+		wg.Wait()
+		removeFetches(fetches)
+		for _, d := range clones {
+			os.RemoveAll(d.tmpDir)
+		}
+	}()
+
+	if i.QualifiedNames {
+		m.Dependencies = QualifyNames(m.Dependencies)
+	}
+	// Only the dependencies of the project in the groups are installed,
+	// along with everything they depend on.
+	if len(chain) == 0 {
+		m.Dependencies = FilterGroups(m.Dependencies, i.Groups)
+	}
+	// Only the remotes of the project are expanded, those of packages must
+	// not leak its environment. Replacements are the project's own.
+	if len(chain) > 0 {
+		if err := checkNoVariables(m.Dependencies, chain[len(chain)-1]); err != nil {
+			return err
+		}
+	}
+	m.Dependencies = ApplyReplace(m.Dependencies, i.replace)
+	// The lock records the expanded remotes.
+	expanded, err := ExpandRemotes(m.Dependencies)
+	if err != nil {
+		return err
+	}
+	// Denied sources are never contacted, neither to resolve import paths
+	// nor to fetch the packages they resolve to.
+	if err := i.checkSources(expanded); err != nil {
+		return err
+	}
+	if expanded, err = i.resolveImports(ctx, expanded); err != nil {
+		return err
+	}
+	if err := i.checkSources(expanded); err != nil {
+		return err
+	}
+	m.Dependencies = expanded
+	deps, err := i.expandSubdirs(ctx, &wg, m.Dependencies, dependencySourceIdentifier, clones)
+	if err != nil {
+		return err
+	}
+	m.Dependencies = deps
+	if i.Disambiguate {
+		m.Dependencies = i.DisambiguateNames(m.Dependencies)
+	}
+	// Colliding names are detected before anything else is cloned, as one
+	// of the packages would end up overwriting the other.
+	for _, dep := range m.Dependencies {
+		if err := ValidateName(dep.Name); err != nil {
+			return err
+		}
+	}
+	if err := CheckNameCollisions(m.Dependencies); err != nil {
+		return err
+	}
+
+	fetches, err = i.prefetch(ctx, &wg, m.Dependencies, dependencySourceIdentifier, clones)
+	if err != nil {
+		return err
+	}
+
+	for n, dep := range m.Dependencies {
+		// Dependencies are in the groups of the dependencies of the project
+		// requiring them, whether they are installed again or not.
+		if len(chain) == 0 {
+			i.addGroups(dep.Name, Groups(dep))
+		} else {
+			parent := chain[len(chain)-1]
+			i.requires[parent] = append(i.requires[parent], dep.Name)
+			i.addGroups(dep.Name, i.groups[parent])
 		}
 
-		color.Green(">>> Installed %s version %s\n", dep.Name, dep.Version)
+		req := Requirement{Dependency: dep, From: dependencySourceIdentifier, Depth: len(chain)}
+		replace := false
+		if prev, ok := i.installed[dep.Name]; ok && SameSource(prev.Dependency.Source, dep.Source) {
+			if prev.Dependency.Version == dep.Version {
+				if inChain(chain, dep.Name) {
+					color.Yellow(">>> Dependency cycle %s -> %s, %s is already being installed\n", strings.Join(chain, " -> "), dep.Name, dep.Name)
+				}
+				continue
+			}
 
-		destPath := path.Join(dir, dep.Name)
+			var err error
+			replace, err = i.Conflicts.Resolve(prev, req)
+			if err != nil {
+				return err
+			}
+			if !replace {
+				color.Yellow(">>> Keeping %s version %s, ignoring version %s\n", dep.Name, prev, req)
+				continue
+			}
+			color.Yellow(">>> Replacing %s version %s with version %s\n", dep.Name, prev, req)
+		}
+		i.installed[dep.Name] = req
 
-		err = os.MkdirAll(path.Dir(destPath), os.ModePerm)
+		f := fetches[n]
+		if f == nil {
+			f, err = i.startFetch(ctx, &wg, dep, dependencySourceIdentifier)
+			if err != nil {
+				return err
+			}
+			fetches[n] = f
+		}
+		if err := f.wait(); err != nil {
+			if i.keptCommit(isLock, f.dep) && isNotFound(err) {
+				err = &MovedError{Name: dep.Name, Remote: f.dep.Source.GitSource.Remote, Version: f.dep.Version, Tag: f.dep.Tag, Err: err}
+			}
+			return errors.Wrap(err, "failed to install package")
+		}
+		dep = f.dep
+		subdir, tmpDir, lockVersion := f.subdir, f.tmpDir, f.lockVersion
+		tag := i.lockedTag(dep, lockVersion)
+		if t, ok := f.pkg.(Tagger); ok && t.LockTag() != "" {
+			tag = t.LockTag()
+		}
+		if subdir, err = ExpandVersion(subdir, tag); err != nil {
+			return errors.Wrapf(err, "failed to install %s", dep.Name)
+		}
+		linker, linked := f.pkg.(Linker)
+		src, shared := filepath.Join(tmpDir, subdir), f.shared
+
+		// Symlinks leading out of the package and special files are removed
+		// before anything reads the package, from a copy of it if the clone
+		// is shared with other packages.
+		if !linked {
+			if err := checkSubdirPath(tmpDir, subdir); err != nil {
+				return errors.Wrapf(err, "failed to install %s", dep.Name)
+			}
+			unsafe, err := unsafeFiles(src)
+			if err != nil {
+				return errors.Wrapf(err, "failed to check the files of %s", dep.Name)
+			}
+			if len(unsafe) > 0 && shared {
+				private, err := ioutil.TempDir(filepath.Join(dir, ".tmp"), "jsonnetpkg-unsafe")
+				if err != nil {
+					return errors.Wrap(err, "failed to create tmp dir")
+				}
+				defer os.RemoveAll(private)
+				if err := copyDir(src, filepath.Join(private, "package")); err != nil {
+					return errors.Wrap(err, "failed to copy package")
+				}
+				src, shared = filepath.Join(private, "package"), false
+			}
+			for _, u := range unsafe {
+				color.Yellow(">>> Not vendoring %s of %s, %s\n", u.Name, dep.Name, u.Reason)
+			}
+			if err := removeUnsafeFiles(src, unsafe); err != nil {
+				return errors.Wrapf(err, "failed to remove the unsafe files of %s", dep.Name)
+			}
+		}
+
+		// The license of a subdir is usually the one of the whole package.
+		license := ""
+		if !linked {
+			license = DetectLicense(src, tmpDir)
+		}
+
+		// Excluded files, by the dependency or by the IgnoreFile of the
+		// package, and stripped ones are removed before the digest is
+		// computed, from a copy of the package if the clone is shared with
+		// other packages. Linked packages are the directory itself.
+		expected, files := i.expectedSum(dep, lockVersion)
+		exclude := dep.Exclude
+		if len(dep.Exclude) > 0 && linked {
+			color.Yellow(">>> Not excluding files of %s, it is linked\n", dep.Name)
+		}
+		if !linked {
+			ignored, err := loadIgnoreFile(src)
+			if err != nil {
+				return errors.Wrapf(err, "failed to load %s of %s", IgnoreFile, dep.Name)
+			}
+			exclude = append(ignored, exclude...)
+
+			// A lock stripped of other files is verified the way it was
+			// stripped, the digest recorded is the one stripped now.
+			strip := i.strip()
+			if expected != "" && !sameExcludes(i.lockedStrip, strip) {
+				actual, err := i.lockedStripSum(dir, src, exclude, dep.Include, files)
+				if err != nil {
+					return errors.Wrapf(err, "failed to verify %s", dep.Name)
+				}
+				if actual != expected {
+					return &SumMismatchError{Name: dep.Name, Version: lockVersion, Expected: expected, Actual: actual}
+				}
+				expected = ""
+			}
+			exclude = append(exclude, strip...)
+		}
+		if len(exclude) > 0 && !linked {
+			if shared {
+				private, err := ioutil.TempDir(filepath.Join(dir, ".tmp"), "jsonnetpkg-exclude")
+				if err != nil {
+					return errors.Wrap(err, "failed to create tmp dir")
+				}
+				defer os.RemoveAll(private)
+				if err := copyDir(src, filepath.Join(private, "package")); err != nil {
+					return errors.Wrap(err, "failed to copy package")
+				}
+				src, shared = filepath.Join(private, "package"), false
+			}
+			if err := excludeFiles(src, exclude, dep.Include); err != nil {
+				return errors.Wrapf(err, "failed to exclude files of %s", dep.Name)
+			}
+		}
+
+		// The digest is verified before anything is moved into the vendor
+		// directory, so a tampered package is never vendored. Linked packages
+		// change all the time and have no digest.
+		sum := ""
+		if !linked {
+			sum, err = hashDir(src)
+			if err != nil {
+				return errors.Wrap(err, "failed to compute checksum")
+			}
+		}
+		// A package vendored sparsely was locked with the digest of the
+		// files it kept, see sparsify.
+		actual := sum
+		if expected != "" && files != nil {
+			if actual, err = hashFiles(src, files); err != nil {
+				return errors.Wrap(err, "failed to compute checksum")
+			}
+		}
+		if expected != "" && expected != actual {
+			return &SumMismatchError{Name: dep.Name, Version: lockVersion, Expected: expected, Actual: actual}
+		}
+
+		// The policy sees the package as it would be vendored, its source
+		// was checked before it was fetched.
+		if i.Policy != nil {
+			err := i.Policy.Check(ctx, PolicyRequest{
+				Name:     dep.Name,
+				Source:   dep.Source,
+				Location: policyLocation(dep.Source),
+				Version:  dep.Version,
+				Locked:   lockVersion,
+				Tag:      tag,
+				Sum:      sum,
+				License:  license,
+				Dir:      src,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		date, tree := i.lockedCommit(dep, lockVersion)
+		if c, ok := f.pkg.(CommitLocker); ok {
+			if d, t := c.LockCommit(subdir); t != "" {
+				date, tree = d.UTC().Format(time.RFC3339), t
+			}
+		}
+		submodules := i.lockedSubmodules(dep, lockVersion)
+		if s, ok := f.pkg.(SubmoduleLocker); ok && dep.Submodules && s.LockSubmodules(subdir) != nil {
+			submodules = s.LockSubmodules(subdir)
+		}
+		if tag != "" && tag != dep.Version {
+			color.Green(">>> Installed %s version %s (%s)\n", dep.Name, dep.Version, tag)
+		} else {
+			color.Green(">>> Installed %s version %s\n", dep.Name, dep.Version)
+		}
+
+		// Once canceled, nothing is moved into the vendor directory anymore.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		i.progress(dep.Name, StageCopying)
+		destPath := filepath.Join(dir, dep.Name)
+
+		err = os.MkdirAll(filepath.Dir(destPath), os.ModePerm)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to create parent path")
+			return errors.Wrap(err, "failed to create parent path")
 		}
 
 		err = os.RemoveAll(destPath)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to clean previous destination path")
+			return errors.Wrap(err, "failed to clean previous destination path")
+		}
+
+		// Unless flattened, the subdir is vendored with its intermediate
+		// directories, e.g. vendor/<name>/<subdir>.
+		flatten := !i.PreserveSubdirs
+		switch {
+		case dep.Flatten != nil:
+			flatten = *dep.Flatten
+		case isLock:
+			// Older locks only recorded preserved subdirs, anything
+			// else was flattened whatever the default is now.
+			flatten = true
+		}
+		pkgPath := destPath
+		if !flatten && subdir != "" {
+			pkgPath = filepath.Join(destPath, subdir)
+			err = os.MkdirAll(filepath.Dir(pkgPath), os.ModePerm)
+			if err != nil {
+				return errors.Wrap(err, "failed to create subdir path")
+			}
+		}
+
+		switch {
+		case linked:
+			err = linker.Link(pkgPath)
+		case i.linkStore():
+			err = (&Store{Dir: i.StoreDir, LockTimeout: i.LockTimeout}).Link(ctx, src, sum, !shared, pkgPath)
+		case shared:
+			err = copyDir(src, pkgPath)
+		default:
+			err = os.Rename(src, pkgPath)
 		}
-		err = os.Rename(path.Join(tmpDir, subdir), destPath)
 		if err != nil {
-			return nil, errors.Wrap(err, "failed to move package")
+			return errors.Wrap(err, "failed to move package")
+		}
+		// Copies are checked against the digest verified, so a file lost or
+		// changed on the way, like by a file system that cannot hold it, is
+		// never vendored unnoticed.
+		if !linked && (shared || i.linkStore()) {
+			copied, err := hashDir(pkgPath)
+			if err != nil {
+				return errors.Wrap(err, "failed to compute checksum")
+			}
+			if copied != sum {
+				return fmt.Errorf("copy of %s into %s does not match its digest %s: %s", dep.Name, pkgPath, sum, copied)
+			}
+		}
+
+		// Linked packages are not copies, their hooks would change the
+		// linked directory.
+		if !linked && !i.DryRun {
+			if err := i.runPackageHooks(ctx, dep.Name, pkgPath); err != nil {
+				return err
+			}
 		}
 
-		lockfile.Dependencies, err = insertDependency(lockfile.Dependencies, spec.Dependency{
+		// The layout is recorded in the lock whenever it differs from the
+		// default, so installing from the lock reproduces the same tree.
+		lockDep := spec.Dependency{
 			Name:      dep.Name,
 			Source:    dep.Source,
 			Version:   lockVersion,
+			Sum:       sum,
+			Tag:       tag,
+			Import:    dep.Import,
+			Groups:    lockGroups(i.groups[dep.Name]),
+			Date:      date,
+			Tree:      tree,
+			License:   license,
+			Verify:    dep.Verify,
+			Exclude:   dep.Exclude,
+			Include:   dep.Include,
 			DepSource: dependencySourceIdentifier,
-		})
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to insert dependency to lock dependencies")
+
+			Submodules:       dep.Submodules,
+			SubmoduleCommits: submodules,
+			Transport:        dep.Transport,
+		}
+		// The effective layout is recorded rather than the dependency's own
+		// setting, so that the lock vendors the same tree under another
+		// default.
+		lockDep.Flatten = &flatten
+		if l, ok := f.pkg.(SourceLocker); ok {
+			lockDep.Source = l.LockSource()
+		}
+
+		if replace {
+			i.lock.Dependencies = replaceDependency(i.lock.Dependencies, lockDep)
+		} else {
+			i.lock.Dependencies, err = insertDependency(i.lock.Dependencies, lockDep)
+			if err != nil {
+				return errors.Wrap(err, "failed to insert dependency to lock dependencies")
+			}
 		}
 
+		i.progress(dep.Name, StageDone)
+
 		// If dependencies are being installed from a lock file, the transitive
 		// dependencies are not questioned, the locked dependencies are just
 		// installed.
@@ -96,31 +621,38 @@ func Install(ctx context.Context, isLock bool, dependencySourceIdentifier string
 			continue
 		}
 
-		filepath, isLock, err := ChooseJsonnetFile(destPath)
+		filepath, isLock, err := ChooseJsonnetFile(pkgPath)
 		if err != nil {
-			return nil, err
+			return err
 		}
 		depsDeps, err := LoadJsonnetfile(filepath)
 		// It is ok for depedencies not to have a JsonnetFile, it just means
 		// they do not have transitive dependencies of their own.
 		if err != nil && !os.IsNotExist(err) {
-			return nil, err
+			return err
 		}
 
-		depsInstalledByDependency, err := Install(ctx, isLock, filepath, depsDeps, dir)
+		err = i.installDependencies(ctx, isLock, filepath, depsDeps, append(chain[:len(chain):len(chain)], dep.Name))
 		if err != nil {
-			return nil, err
+			return err
 		}
+	}
 
-		for _, d := range depsInstalledByDependency.Dependencies {
-			lockfile.Dependencies, err = insertDependency(lockfile.Dependencies, d)
-			if err != nil {
-				return nil, errors.Wrap(err, "failed to insert dependency to lock dependencies")
-			}
+	return nil
+}
+
+// checkSources fails with a *PolicyError for the first of deps whose source
+// the policy does not allow, see Policy.CheckSource.
+func (i *Installer) checkSources(deps []spec.Dependency) error {
+	if i.Policy == nil {
+		return nil
+	}
+	for _, d := range deps {
+		if err := i.Policy.CheckSource(d.Name, d.Source); err != nil {
+			return err
 		}
 	}
-
-	return lockfile, nil
+	return nil
 }
 
 func insertDependency(deps []spec.Dependency, newDep spec.Dependency) ([]spec.Dependency, error) {
@@ -131,7 +663,16 @@ func insertDependency(deps []spec.Dependency, newDep spec.Dependency) ([]spec.De
 	res := []spec.Dependency{}
 	newDepPreviouslyPresent := false
 	for _, d := range deps {
+		switch {
+		case nestedName(newDep.Name, d.Name):
+			return nil, &NestedNameError{Name: newDep.Name, Parent: d.Name}
+		case nestedName(d.Name, newDep.Name):
+			return nil, &NestedNameError{Name: d.Name, Parent: newDep.Name}
+		}
 		if d.Name == newDep.Name {
+			if !SameSource(d.Source, newDep.Source) {
+				return nil, &NameCollisionError{Name: d.Name, First: SourceString(d.Source), Second: SourceString(newDep.Source)}
+			}
 			if d.Version != newDep.Version {
 				return nil, fmt.Errorf("multiple colliding versions specified for %s: %s (from %s) and %s (from %s)", d.Name, d.Version, d.DepSource, newDep.Version, newDep.DepSource)
 			}
@@ -148,6 +689,78 @@ func insertDependency(deps []spec.Dependency, newDep spec.Dependency) ([]spec.De
 	return res, nil
 }
 
+// replaceDependency replaces the dependency with the name of newDep, or
+// appends newDep if there is none.
+func replaceDependency(deps []spec.Dependency, newDep spec.Dependency) []spec.Dependency {
+	for i, d := range deps {
+		if d.Name == newDep.Name {
+			deps[i] = newDep
+			return deps
+		}
+	}
+	return append(deps, newDep)
+}
+
+func inChain(chain []string, name string) bool {
+	for _, c := range chain {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// lockedTag returns the tag recorded for dep resolved to lockVersion, so
+// that the tag a constraint resolved to survives installs from the lock.
+func (i *Installer) lockedTag(dep spec.Dependency, lockVersion string) string {
+	if dep.Tag != "" && dep.Version == lockVersion {
+		return dep.Tag
+	}
+	if locked, ok := i.locked[dep.Name]; ok && SameSource(locked.Source, dep.Source) && locked.Version == lockVersion {
+		return locked.Tag
+	}
+	return ""
+}
+
+// lockedCommit returns the commit date and tree hash recorded for dep
+// resolved to lockVersion, for packages served from the cache, which have
+// none, see lockedTag.
+func (i *Installer) lockedCommit(dep spec.Dependency, lockVersion string) (date, tree string) {
+	if dep.Tree != "" && dep.Version == lockVersion {
+		return dep.Date, dep.Tree
+	}
+	if locked, ok := i.locked[dep.Name]; ok && SameSource(locked.Source, dep.Source) && locked.Version == lockVersion {
+		return locked.Date, locked.Tree
+	}
+	return "", ""
+}
+
+// keptCommit reports whether dep, as fetched, is a git dependency at the
+// commit it was locked at, installed from the lock file or kept locked by
+// an Update.
+func (i *Installer) keptCommit(isLock bool, dep spec.Dependency) bool {
+	if dep.Source.GitSource == nil || !fullCommitRegex.MatchString(dep.Version) {
+		return false
+	}
+	locked, ok := i.locked[dep.Name]
+	return isLock || ok && i.keepLocked(dep.Name) && locked.Version == dep.Version
+}
+
+// lockedSubmodules returns the submodule commits recorded for dep resolved
+// to lockVersion, for packages served from the cache, see lockedCommit.
+func (i *Installer) lockedSubmodules(dep spec.Dependency, lockVersion string) map[string]string {
+	if !dep.Submodules {
+		return nil
+	}
+	if dep.SubmoduleCommits != nil && dep.Version == lockVersion {
+		return dep.SubmoduleCommits
+	}
+	if locked, ok := i.locked[dep.Name]; ok && SameSource(locked.Source, dep.Source) && locked.Version == lockVersion && locked.Submodules {
+		return locked.SubmoduleCommits
+	}
+	return nil
+}
+
 func FileExists(path string) (bool, error) {
 	_, err := os.Stat(path)
 	if os.IsNotExist(err) {
@@ -161,8 +774,8 @@ func FileExists(path string) (bool, error) {
 }
 
 func ChooseJsonnetFile(dir string) (string, bool, error) {
-	lockfile := path.Join(dir, JsonnetLockFile)
-	jsonnetfile := path.Join(dir, JsonnetFile)
+	lockfile := filepath.Join(dir, JsonnetLockFile)
+	jsonnetfile := filepath.Join(dir, JsonnetFile)
 	filename := lockfile
 	isLock := true
 
@@ -192,10 +805,10 @@ func LoadJsonnetfile(filepath string) (spec.JsonnetFile, error) {
 	}
 	defer f.Close()
 
-	err = json.NewDecoder(f).Decode(&m)
+	b, err := ioutil.ReadAll(f)
 	if err != nil {
 		return m, err
 	}
 
-	return m, nil
+	return spec.Parse(b)
 }
@@ -38,6 +38,10 @@ func TestInsertDependency(t *testing.T) {
 	if len(res) != 2 {
 		t.Fatal("Incorrectly inserted")
 	}
+
+	// Transitive dependencies cannot be vendored inside each other either.
+	_, err = insertDependency(res, spec.Dependency{Name: "test1/lib", Version: "latest"})
+	assert.Equal(t, &NestedNameError{Name: "test1/lib", Parent: "test1"}, err)
 }
 
 func TestFileExists(t *testing.T) {
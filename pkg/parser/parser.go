@@ -0,0 +1,665 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package parser parses the package references jb install takes, like
+// github.com/grafana/jsonnet-libs/grafana-builder@master, into dependencies
+// of a jsonnetfile. References that are not valid fail with an *Error
+// telling which part of them is wrong.
+package parser
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// archiveVersionRegex splits the file name of an archive like pkg-1.2.3
+// into the name and the version.
+var archiveVersionRegex = regexp.MustCompile("^(.+?)-(v?[0-9][-.0-9A-Za-z]*)$")
+
+var (
+	// ociNameRegex and ociTagRegex match the path segments of OCI
+	// repositories and their tags, as the distribution spec allows them.
+	ociNameRegex = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*$`)
+	ociTagRegex  = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]{0,127}$`)
+)
+
+// Part is a part of a package reference.
+type Part string
+
+const (
+	Scheme     Part = "scheme"
+	Host       Part = "host"
+	Repository Part = "repository"
+	Subdir     Part = "subdir"
+	Version    Part = "version"
+)
+
+// Error is returned for package references that are not valid. Reason
+// explains what is wrong with Part of Ref.
+type Error struct {
+	Ref    string
+	Part   Part
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("invalid package %s: %s", e.Ref, e.Reason)
+}
+
+// Parse returns the dependency of the package reference ref: a local path
+// (./lib), an archive URL, a GitHub release asset or the URL of a single
+// jsonnet file, a git+ssh:// remote, a
+// GitHub, GitLab or Bitbucket slug, any HTTPS git remote, an hg+https://
+// or hg+ssh:// Mercurial remote or an import path redirecting to a
+// repository, each optionally followed by a subdir and @version. Git packages without a version get defaultVersion, Mercurial
+// ones the default branch.
+func Parse(ref, defaultVersion string) (*spec.Dependency, error) {
+	p := &parser{ref: ref, defaultVersion: defaultVersion}
+	return p.parse()
+}
+
+type parser struct {
+	ref            string
+	defaultVersion string
+}
+
+func (p *parser) errorf(part Part, format string, args ...interface{}) error {
+	return &Error{Ref: p.ref, Part: part, Reason: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) parse() (*spec.Dependency, error) {
+	if p.ref == "" {
+		return nil, p.errorf(Repository, "empty package reference")
+	}
+
+	// Only explicit paths are taken as local, so that references without
+	// scheme are not mistaken for directories.
+	if strings.HasPrefix(p.ref, "./") || strings.HasPrefix(p.ref, "../") || filepath.IsAbs(p.ref) {
+		return p.parseLocal(), nil
+	}
+
+	scheme, rest := "", p.ref
+	if i := strings.Index(p.ref, "://"); i >= 0 {
+		scheme, rest = p.ref[:i], p.ref[i+3:]
+	}
+
+	switch scheme {
+	case "http", "https":
+		if dep, ok, err := p.parseArchive(scheme, rest); ok || err != nil {
+			return dep, err
+		}
+		if dep, ok := p.parseFile(scheme, rest); ok {
+			return dep, nil
+		}
+		if scheme == "http" {
+			return nil, p.errorf(Scheme, "git remotes need https://, http:// is only supported for archives and single files")
+		}
+	case "s3", "gs":
+		if dep, ok, err := p.parseArchive(scheme, rest); ok || err != nil {
+			return dep, err
+		}
+		return nil, p.errorf(Repository, "objects in object storage must be archives, like %s://<bucket>/<path>.tar.gz", scheme)
+	case "oci":
+		return p.parseOCI(rest)
+	case "git+ssh":
+		return p.parseSSH(rest)
+	case "hg+https", "hg+ssh":
+		return p.parseHg(strings.TrimPrefix(scheme, "hg+"), rest)
+	case "":
+	default:
+		return nil, p.errorf(Scheme, "unsupported scheme %s://, use https://, git+ssh://, hg+https://, hg+ssh://, s3://, gs:// or oci://", scheme)
+	}
+
+	host, rest := rest, ""
+	if i := strings.Index(host, "/"); i >= 0 {
+		host, rest = host[:i], host[i+1:]
+	}
+	if err := p.checkHost(host, scheme == ""); err != nil {
+		return nil, err
+	}
+
+	if host == "github.com" {
+		if dep, ok := p.parseReleaseAsset(rest); ok {
+			return dep, nil
+		}
+	}
+
+	rest, version, err := p.splitVersion(rest)
+	if err != nil {
+		return nil, err
+	}
+	version = pkg.QualifyRef(version)
+
+	switch {
+	case host == "github.com", scheme == "" && host == "bitbucket.org":
+		return p.parseSlug(host, rest, version)
+	case scheme == "" && host == "gitlab.com":
+		return p.parseNested(host, rest, version, false)
+	case scheme == "https":
+		return p.parseNested(host, rest, version, true)
+	}
+	return p.parseImport(host, rest, version)
+}
+
+// parseLocal parses a path on disk.
+func (p *parser) parseLocal() *spec.Dependency {
+	dir := filepath.Clean(p.ref)
+	return &spec.Dependency{
+		Name: filepath.Base(dir),
+		Source: spec.Source{
+			LocalSource: &spec.LocalSource{
+				Directory: filepath.ToSlash(dir),
+			},
+		},
+		Version: "",
+	}
+}
+
+// parseArchive parses the URL of a tarball or zip archive, e.g.
+// https://example.com/pkg-1.2.3.tar.gz, optionally followed by //<subdir>.
+// The name and version are taken from the file name where possible. It
+// reports false for URLs of anything else.
+func (p *parser) parseArchive(scheme, rest string) (*spec.Dependency, bool, error) {
+	i := strings.Index(rest, "/")
+	if i <= 0 {
+		return nil, false, nil
+	}
+	archive, subdir := rest, ""
+	if j := strings.Index(rest[i+1:], "//"); j >= 0 {
+		archive, subdir = rest[:i+1+j], rest[i+1+j+2:]
+	}
+	archive = scheme + "://" + archive
+	if !pkg.IsArchive(archive) {
+		return nil, false, nil
+	}
+
+	subdir, err := p.checkSubdir(subdir)
+	if err != nil {
+		return nil, true, err
+	}
+
+	name := pkg.TrimArchiveExt(path.Base(archive))
+	version := name
+	if m := archiveVersionRegex.FindStringSubmatch(name); m != nil {
+		name, version = m[1], m[2]
+	}
+	if subdir != "" {
+		name = path.Base(subdir)
+	}
+
+	return &spec.Dependency{
+		Name: name,
+		Source: spec.Source{
+			ArchiveSource: &spec.ArchiveSource{
+				URL:    archive,
+				Subdir: subdir,
+			},
+		},
+		Version: version,
+	}, true, nil
+}
+
+// parseReleaseAsset parses the path of a GitHub release asset, like
+// owner/repo/releases/download/v1.2.0/lib.libsonnet.
+func (p *parser) parseReleaseAsset(rest string) (*spec.Dependency, bool) {
+	segments := strings.Split(rest, "/")
+	if len(segments) != 6 || segments[2] != "releases" || segments[3] != "download" {
+		return nil, false
+	}
+	for _, s := range segments {
+		if s == "" {
+			return nil, false
+		}
+	}
+	owner, repo, version := segments[0], segments[1], segments[4]
+	if !validSegment(owner) || !validSegment(repo) {
+		return nil, false
+	}
+
+	return &spec.Dependency{
+		Name: repo,
+		Source: spec.Source{
+			ReleaseAssetSource: &spec.ReleaseAssetSource{
+				URL: "https://github.com/" + rest,
+			},
+		},
+		Version: version,
+	}, true
+}
+
+// fileExts are the extensions of the files a URL is taken as a single file
+// dependency for, instead of a git remote.
+var fileExts = map[string]bool{".libsonnet": true, ".jsonnet": true, ".json": true}
+
+// parseFile parses the URL of a single jsonnet file, like
+// https://raw.githubusercontent.com/org/repo/v1.2.3/lib/util.libsonnet,
+// which is vendored as is. The name is the one of the file without its
+// extension. The version is the ref of raw files of GitHub and GitLab
+// repositories, whose blob URLs are turned into those of the raw files, and
+// empty for other URLs. It reports false for URLs of anything else and for
+// GitHub release assets, see parseReleaseAsset.
+func (p *parser) parseFile(scheme, rest string) (*spec.Dependency, bool) {
+	urlPath := rest
+	if i := strings.IndexAny(urlPath, "?#"); i >= 0 {
+		urlPath = urlPath[:i]
+	}
+	segments := strings.Split(urlPath, "/")
+	base := segments[len(segments)-1]
+	if len(segments) < 2 || !fileExts[path.Ext(base)] {
+		return nil, false
+	}
+	host := segments[0]
+	if host == "github.com" {
+		if _, ok := p.parseReleaseAsset(strings.Join(segments[1:], "/")); ok {
+			return nil, false
+		}
+	}
+
+	version := ""
+	switch {
+	case host == "raw.githubusercontent.com" && len(segments) > 4:
+		version = segments[3]
+	case host == "github.com" && len(segments) > 5 && (segments[3] == "blob" || segments[3] == "raw"):
+		version = segments[4]
+		rest = "raw.githubusercontent.com/" + strings.Join(append(segments[1:3:3], segments[4:]...), "/")
+	default:
+		for i := 1; i+2 < len(segments); i++ {
+			if segments[i] == "-" && (segments[i+1] == "raw" || segments[i+1] == "blob") {
+				version = segments[i+2]
+				segments[i+1] = "raw"
+				rest = strings.Join(segments, "/")
+				break
+			}
+		}
+	}
+
+	return &spec.Dependency{
+		Name: strings.TrimSuffix(base, path.Ext(base)),
+		Source: spec.Source{
+			ReleaseAssetSource: &spec.ReleaseAssetSource{
+				URL: scheme + "://" + rest,
+			},
+		},
+		Version: version,
+	}, true
+}
+
+// parseSSH parses a git+ssh:// remote after the scheme, like
+// git@host:group/repo.git/path@v1. The name is the one of the repository.
+func (p *parser) parseSSH(rest string) (*spec.Dependency, error) {
+	i := strings.Index(rest, ":")
+	if i < 0 {
+		return nil, p.errorf(Host, "expected git+ssh://git@<host>:<repository>")
+	}
+	userHost, rest := rest[:i], rest[i+1:]
+	host := userHost
+	if j := strings.LastIndex(userHost, "@"); j >= 0 {
+		host = userHost[j+1:]
+	}
+	if err := p.checkHost(host, false); err != nil {
+		return nil, err
+	}
+
+	rest, version, err := p.splitVersion(rest)
+	if err != nil {
+		return nil, err
+	}
+	version = pkg.QualifyRef(version)
+	repo, subdir, err := p.splitRepository(host, rest, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &spec.Dependency{
+		Name: path.Base(repo),
+		Source: spec.Source{
+			GitSource: &spec.GitSource{
+				Remote: fmt.Sprintf("%s:%s", userHost, repo),
+				Subdir: subdir,
+			},
+		},
+		Version: version,
+	}, nil
+}
+
+// parseHg parses a Mercurial remote after the scheme, like
+// hg.example.com/repo/path@1.2. The first segment of the path is the
+// repository unless the repository and the subdir are separated by //.
+func (p *parser) parseHg(scheme, rest string) (*spec.Dependency, error) {
+	host, rest := rest, ""
+	if i := strings.Index(host, "/"); i >= 0 {
+		host, rest = host[:i], host[i+1:]
+	}
+	userHost := host
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	if err := p.checkHost(host, false); err != nil {
+		return nil, err
+	}
+
+	// defaultVersion is a git branch, which Mercurial repositories do
+	// not have.
+	version := ""
+	if strings.Contains(rest, "@") {
+		var err error
+		if rest, version, err = p.splitVersion(rest); err != nil {
+			return nil, err
+		}
+	}
+
+	repo, subdir := rest, ""
+	if i := strings.Index(rest, "//"); i >= 0 {
+		repo, subdir = rest[:i], rest[i+2:]
+	} else if i := strings.Index(rest, "/"); i >= 0 {
+		repo, subdir = rest[:i], rest[i+1:]
+	}
+	repo = strings.TrimSuffix(repo, "/")
+	if repo == "" {
+		return nil, p.errorf(Repository, "missing repository, expected hg+%s://%s/<repository>", scheme, userHost)
+	}
+	for _, s := range strings.Split(repo, "/") {
+		if !validSegment(s) {
+			return nil, p.errorf(Repository, "invalid repository %s", repo)
+		}
+	}
+	subdir, err := p.checkSubdir(subdir)
+	if err != nil {
+		return nil, err
+	}
+
+	name := path.Base(repo)
+	if subdir != "" {
+		name = path.Base(subdir)
+	}
+
+	return &spec.Dependency{
+		Name: name,
+		Source: spec.Source{
+			HgSource: &spec.HgSource{
+				Remote: fmt.Sprintf("%s://%s/%s", scheme, userHost, repo),
+				Subdir: subdir,
+			},
+		},
+		Version: version,
+	}, nil
+}
+
+// parseOCI parses an OCI artifact after the scheme, like
+// ghcr.io/org/lib:1.4.0 or ghcr.io/org/lib@sha256:<digest>, latest if it
+// has neither.
+func (p *parser) parseOCI(rest string) (*spec.Dependency, error) {
+	version := "latest"
+	if i := strings.Index(rest, "@"); i >= 0 {
+		rest, version = rest[:i], rest[i+1:]
+		if !pkg.IsOCIDigest(version) {
+			return nil, p.errorf(Version, "invalid digest %s, expected sha256:<64 hex digits>", version)
+		}
+	} else if i := strings.LastIndex(rest, ":"); i > strings.LastIndex(rest, "/") {
+		rest, version = rest[:i], rest[i+1:]
+		if !ociTagRegex.MatchString(version) {
+			return nil, p.errorf(Version, "invalid tag %s", version)
+		}
+	}
+
+	host, repo := rest, ""
+	if i := strings.Index(rest, "/"); i >= 0 {
+		host, repo = rest[:i], rest[i+1:]
+	}
+	if err := p.checkHost(host, false); err != nil {
+		return nil, err
+	}
+	if repo == "" {
+		return nil, p.errorf(Repository, "missing repository, expected oci://%s/<repository>:<tag>", host)
+	}
+	for _, s := range strings.Split(repo, "/") {
+		if !ociNameRegex.MatchString(s) {
+			return nil, p.errorf(Repository, "invalid repository %s, OCI repositories are lowercase", repo)
+		}
+	}
+
+	return &spec.Dependency{
+		Name: path.Base(repo),
+		Source: spec.Source{
+			OCISource: &spec.OCISource{
+				Repository: host + "/" + repo,
+			},
+		},
+		Version: version,
+	}, nil
+}
+
+// parseImport parses an import path on any other host, like
+// libs.example.com/jsonnet/foo, which its host redirects to the repository
+// by a go-import meta tag when installed, see pkg.IsImportPath.
+func (p *parser) parseImport(host, rest, version string) (*spec.Dependency, error) {
+	rest = strings.Trim(rest, "/")
+	if rest == "" {
+		return nil, p.errorf(Repository, "missing path, expected %s/<path> or https://%s/<group>/<repository>", host, host)
+	}
+	for _, s := range strings.Split(rest, "/") {
+		if !validSegment(s) {
+			return nil, p.errorf(Repository, "invalid import path %s/%s", host, rest)
+		}
+	}
+
+	return &spec.Dependency{
+		Name: path.Base(rest),
+		Source: spec.Source{
+			GitSource: &spec.GitSource{
+				Remote: host + "/" + rest,
+			},
+		},
+		Version: version,
+	}, nil
+}
+
+// parseSlug parses the path of a repository on a host of owners and
+// repositories, like owner/repo/path on GitHub.
+func (p *parser) parseSlug(host, rest, version string) (*spec.Dependency, error) {
+	segments := strings.SplitN(rest, "/", 3)
+	if len(segments) < 2 || segments[0] == "" || segments[1] == "" {
+		return nil, p.errorf(Repository, "missing repository, expected %s/<owner>/<repository>", host)
+	}
+	owner, repo := segments[0], strings.TrimSuffix(segments[1], ".git")
+	for _, s := range []string{owner, repo} {
+		if !validSegment(s) {
+			return nil, p.errorf(Repository, "invalid repository %s/%s", owner, repo)
+		}
+	}
+
+	subdir := ""
+	if len(segments) == 3 {
+		var err error
+		if subdir, err = p.checkSubdir(segments[2]); err != nil {
+			return nil, err
+		}
+	}
+
+	name := repo
+	if subdir != "" {
+		name = path.Base(subdir)
+	}
+
+	return &spec.Dependency{
+		Name: name,
+		Source: spec.Source{
+			GitSource: &spec.GitSource{
+				Remote: fmt.Sprintf("https://%s/%s/%s", host, owner, repo),
+				Subdir: subdir,
+			},
+		},
+		Version: version,
+	}, nil
+}
+
+// parseNested parses the path of a repository on a host with nested
+// groups, see splitRepository.
+func (p *parser) parseNested(host, rest, version string, keepSuffix bool) (*spec.Dependency, error) {
+	repo, subdir, err := p.splitRepository(host, rest, keepSuffix)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(path.Base(repo), ".git")
+	if subdir != "" {
+		name = path.Base(subdir)
+	}
+
+	return &spec.Dependency{
+		Name: name,
+		Source: spec.Source{
+			GitSource: &spec.GitSource{
+				Remote: fmt.Sprintf("https://%s/%s", host, repo),
+				Subdir: subdir,
+			},
+		},
+		Version: version,
+	}, nil
+}
+
+// splitRepository splits rest into the repository, which may be nested in
+// groups, and the subdir. They are separated by the .git suffix, kept if
+// keepSuffix, or by //. Otherwise the whole path is the repository.
+func (p *parser) splitRepository(host, rest string, keepSuffix bool) (string, string, error) {
+	repo, subdir := strings.TrimSuffix(rest, "/"), ""
+	if i := strings.Index(rest+"/", ".git/"); i >= 0 {
+		repo = rest[:i]
+		if keepSuffix {
+			repo += ".git"
+		}
+		if i+5 <= len(rest) {
+			subdir = rest[i+5:]
+		}
+	} else if i := strings.Index(rest, "//"); i >= 0 {
+		repo, subdir = rest[:i], rest[i+2:]
+	}
+
+	segments := strings.Split(strings.TrimSuffix(repo, ".git"), "/")
+	if len(segments) < 2 {
+		return "", "", p.errorf(Repository, "missing repository, expected %s/<group>/<repository>", host)
+	}
+	for _, s := range segments {
+		if !validSegment(s) {
+			return "", "", p.errorf(Repository, "invalid repository %s", repo)
+		}
+	}
+
+	subdir, err := p.checkSubdir(subdir)
+	if err != nil {
+		return "", "", err
+	}
+	return repo, subdir, nil
+}
+
+// splitVersion splits the version off rest, which is defaultVersion if
+// there is none.
+func (p *parser) splitVersion(rest string) (string, string, error) {
+	i := strings.Index(rest, "@")
+	if i < 0 {
+		return rest, p.defaultVersion, nil
+	}
+
+	version := rest[i+1:]
+	if err := p.checkVersion(version); err != nil {
+		return "", "", err
+	}
+	return rest[:i], version, nil
+}
+
+// checkVersion checks that version is a valid version constraint, the
+// name of a git ref or commit, or a branch as of a date, see pkg.SplitAsOf.
+func (p *parser) checkVersion(version string) error {
+	if version == "" {
+		return p.errorf(Version, "missing version after @")
+	}
+	if branch, _, ok, err := pkg.SplitAsOf(version); ok {
+		if err != nil {
+			return p.errorf(Version, "%v", err)
+		}
+		if branch == "" {
+			return nil
+		}
+		version = branch
+	}
+	if semver.IsConstraint(version) {
+		if _, err := semver.ParseConstraint(version); err != nil {
+			return p.errorf(Version, "%v", err)
+		}
+		return nil
+	}
+
+	switch {
+	case strings.ContainsAny(version, " \t\n~^:?*[\\@"),
+		strings.Contains(version, ".."),
+		strings.HasPrefix(version, "-"), strings.HasPrefix(version, "/"),
+		strings.HasSuffix(version, "/"), strings.HasSuffix(version, "."), strings.HasSuffix(version, ".lock"):
+		return p.errorf(Version, "illegal version %s, it is neither a git ref, a commit nor a version constraint", version)
+	}
+	return nil
+}
+
+// checkHost checks that host is a host name, with an optional port. A host
+// given without scheme must have a domain, so that a bare word is not taken
+// for one. Hosts with variables, see pkg.ExpandRemotes, are not checked.
+func (p *parser) checkHost(host string, shorthand bool) error {
+	if host == "" {
+		return p.errorf(Host, "missing host")
+	}
+	if strings.Contains(host, "${") {
+		return nil
+	}
+
+	name := host
+	if i := strings.LastIndex(host, ":"); i >= 0 && !shorthand {
+		name = host[:i]
+		if port := host[i+1:]; port == "" || strings.Trim(port, "0123456789") != "" {
+			return p.errorf(Host, "invalid port in host %s", host)
+		}
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") || strings.Trim(label, "-0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return p.errorf(Host, "invalid host %s", host)
+		}
+	}
+	if shorthand && !strings.Contains(name, ".") {
+		return p.errorf(Host, "%s is not a host, local paths start with ./ or /", host)
+	}
+	return nil
+}
+
+// checkSubdir checks that subdir stays within the repository and returns
+// it without leading or trailing slashes.
+func (p *parser) checkSubdir(subdir string) (string, error) {
+	subdir = strings.Trim(subdir, "/")
+	for _, s := range strings.Split(subdir, "/") {
+		if s == ".." {
+			return "", p.errorf(Subdir, "subdir %s leaves the repository", subdir)
+		}
+	}
+	return subdir, nil
+}
+
+// validSegment reports whether s is a valid element of the path of a
+// repository, like the owner or the name.
+func validSegment(s string) bool {
+	return s != "" && s != "." && s != ".." && strings.Trim(s, "-_.0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ") == ""
+}
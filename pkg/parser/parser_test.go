@@ -0,0 +1,230 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package parser
+
+import (
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	testcases := []struct {
+		Ref      string
+		Expected *spec.Dependency
+	}{{
+		Ref: "github.com/foo/bar.git/lib@^1.2",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foo/bar", Subdir: "lib"}},
+			Version: "^1.2",
+		},
+	}, {
+		Ref: "https://github.com/foo/bar@refs/heads/main",
+		Expected: &spec.Dependency{
+			Name:    "bar",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foo/bar"}},
+			Version: "refs/heads/main",
+		},
+	}, {
+		Ref: "github.com/foo/bar@tags/v1.2.3",
+		Expected: &spec.Dependency{
+			Name:    "bar",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foo/bar"}},
+			Version: "refs/tags/v1.2.3",
+		},
+	}, {
+		Ref: "github.com/foo/bar@refs/pull/123/head",
+		Expected: &spec.Dependency{
+			Name:    "bar",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foo/bar"}},
+			Version: "refs/pull/123/head",
+		},
+	}, {
+		Ref: "github.com/foo/bar@{2023-06-01}",
+		Expected: &spec.Dependency{
+			Name:    "bar",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foo/bar"}},
+			Version: "{2023-06-01}",
+		},
+	}, {
+		Ref: "github.com/foo/bar/lib@release-1.x@{2023-06-01T12:00:00Z}",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/foo/bar", Subdir: "lib"}},
+			Version: "release-1.x@{2023-06-01T12:00:00Z}",
+		},
+	}, {
+		Ref: "git+ssh://git@gitlab.example.com:group/repo.git/lib@v1",
+		Expected: &spec.Dependency{
+			Name:    "repo",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "git@gitlab.example.com:group/repo", Subdir: "lib"}},
+			Version: "v1",
+		},
+	}, {
+		Ref: "libs.example.com/jsonnet/foo@v1",
+		Expected: &spec.Dependency{
+			Name:    "foo",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "libs.example.com/jsonnet/foo"}},
+			Version: "v1",
+		},
+	}, {
+		Ref: "hg+https://hg.example.com/repo/path@1.2",
+		Expected: &spec.Dependency{
+			Name:    "path",
+			Source:  spec.Source{HgSource: &spec.HgSource{Remote: "https://hg.example.com/repo", Subdir: "path"}},
+			Version: "1.2",
+		},
+	}, {
+		Ref: "hg+ssh://hg@hg.example.com/team/repo//lib",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{HgSource: &spec.HgSource{Remote: "ssh://hg@hg.example.com/team/repo", Subdir: "lib"}},
+			Version: "",
+		},
+	}, {
+		Ref: "https://git.example.com:8443/org/repo.git",
+		Expected: &spec.Dependency{
+			Name:    "repo",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://git.example.com:8443/org/repo.git"}},
+			Version: "main",
+		},
+	}, {
+		Ref: "https://${GIT_HOST}/org/repo",
+		Expected: &spec.Dependency{
+			Name:    "repo",
+			Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://${GIT_HOST}/org/repo"}},
+			Version: "main",
+		},
+	}, {
+		Ref: "http://example.com/pkg-v1.0.0.tgz",
+		Expected: &spec.Dependency{
+			Name:    "pkg",
+			Source:  spec.Source{ArchiveSource: &spec.ArchiveSource{URL: "http://example.com/pkg-v1.0.0.tgz"}},
+			Version: "v1.0.0",
+		},
+	}, {
+		Ref: "https://raw.githubusercontent.com/org/repo/v1.2.3/lib/util.libsonnet",
+		Expected: &spec.Dependency{
+			Name:    "util",
+			Source:  spec.Source{ReleaseAssetSource: &spec.ReleaseAssetSource{URL: "https://raw.githubusercontent.com/org/repo/v1.2.3/lib/util.libsonnet"}},
+			Version: "v1.2.3",
+		},
+	}, {
+		Ref: "https://github.com/org/repo/blob/main/lib/util.libsonnet",
+		Expected: &spec.Dependency{
+			Name:    "util",
+			Source:  spec.Source{ReleaseAssetSource: &spec.ReleaseAssetSource{URL: "https://raw.githubusercontent.com/org/repo/main/lib/util.libsonnet"}},
+			Version: "main",
+		},
+	}, {
+		Ref: "https://gitlab.example.com/group/repo/-/blob/v2/config.jsonnet",
+		Expected: &spec.Dependency{
+			Name:    "config",
+			Source:  spec.Source{ReleaseAssetSource: &spec.ReleaseAssetSource{URL: "https://gitlab.example.com/group/repo/-/raw/v2/config.jsonnet"}},
+			Version: "v2",
+		},
+	}, {
+		Ref: "http://example.com/snippets/util.libsonnet",
+		Expected: &spec.Dependency{
+			Name:    "util",
+			Source:  spec.Source{ReleaseAssetSource: &spec.ReleaseAssetSource{URL: "http://example.com/snippets/util.libsonnet"}},
+			Version: "",
+		},
+	}, {
+		Ref: "https://github.com/foo/bar/releases/download/v1.2.0/lib.libsonnet",
+		Expected: &spec.Dependency{
+			Name:    "bar",
+			Source:  spec.Source{ReleaseAssetSource: &spec.ReleaseAssetSource{URL: "https://github.com/foo/bar/releases/download/v1.2.0/lib.libsonnet"}},
+			Version: "v1.2.0",
+		},
+	}, {
+		Ref: "s3://my-bucket/jsonnet/foo-1.2.0.tar.gz",
+		Expected: &spec.Dependency{
+			Name:    "foo",
+			Source:  spec.Source{ArchiveSource: &spec.ArchiveSource{URL: "s3://my-bucket/jsonnet/foo-1.2.0.tar.gz"}},
+			Version: "1.2.0",
+		},
+	}, {
+		Ref: "oci://ghcr.io/org/lib:1.4.0",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{OCISource: &spec.OCISource{Repository: "ghcr.io/org/lib"}},
+			Version: "1.4.0",
+		},
+	}, {
+		Ref: "oci://localhost:5000/lib@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{OCISource: &spec.OCISource{Repository: "localhost:5000/lib"}},
+			Version: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+		},
+	}, {
+		Ref: "gs://my-bucket/foo-1.2.0.zip//lib",
+		Expected: &spec.Dependency{
+			Name:    "lib",
+			Source:  spec.Source{ArchiveSource: &spec.ArchiveSource{URL: "gs://my-bucket/foo-1.2.0.zip", Subdir: "lib"}},
+			Version: "1.2.0",
+		},
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.Ref, func(t *testing.T) {
+			dep, err := Parse(tc.Ref, "main")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Expected, dep)
+		})
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	testcases := []struct {
+		Ref    string
+		Part   Part
+		Reason string
+	}{
+		{Ref: "", Part: Repository, Reason: "empty package reference"},
+		{Ref: "foo", Part: Host, Reason: "foo is not a host, local paths start with ./ or /"},
+		{Ref: "ftp://example.com/foo", Part: Scheme, Reason: "unsupported scheme ftp://, use https://, git+ssh://, hg+https://, hg+ssh://, s3://, gs:// or oci://"},
+		{Ref: "oci://ghcr.io", Part: Repository, Reason: "missing repository, expected oci://ghcr.io/<repository>:<tag>"},
+		{Ref: "oci://ghcr.io/Org/lib", Part: Repository, Reason: "invalid repository Org/lib, OCI repositories are lowercase"},
+		{Ref: "oci://ghcr.io/org/lib@1.4.0", Part: Version, Reason: "invalid digest 1.4.0, expected sha256:<64 hex digits>"},
+		{Ref: "s3://my-bucket/jsonnet/foo", Part: Repository, Reason: "objects in object storage must be archives, like s3://<bucket>/<path>.tar.gz"},
+		{Ref: "http://example.com/org/repo", Part: Scheme, Reason: "git remotes need https://, http:// is only supported for archives and single files"},
+		{Ref: "example.com", Part: Repository, Reason: "missing path, expected example.com/<path> or https://example.com/<group>/<repository>"},
+		{Ref: "https://exa_mple.com/org/repo", Part: Host, Reason: "invalid host exa_mple.com"},
+		{Ref: "https:///org/repo", Part: Host, Reason: "missing host"},
+		{Ref: "https://example.com:port/org/repo", Part: Host, Reason: "invalid port in host example.com:port"},
+		{Ref: "github.com/foo", Part: Repository, Reason: "missing repository, expected github.com/<owner>/<repository>"},
+		{Ref: "github.com/foo/b%r", Part: Repository, Reason: "invalid repository foo/b%r"},
+		{Ref: "https://example.com/repo", Part: Repository, Reason: "missing repository, expected example.com/<group>/<repository>"},
+		{Ref: "git+ssh://github.com", Part: Host, Reason: "expected git+ssh://git@<host>:<repository>"},
+		{Ref: "hg+https://hg.example.com/", Part: Repository, Reason: "missing repository, expected hg+https://hg.example.com/<repository>"},
+		{Ref: "github.com/foo/bar/../../etc", Part: Subdir, Reason: "subdir ../../etc leaves the repository"},
+		{Ref: "github.com/foo/bar@", Part: Version, Reason: "missing version after @"},
+		{Ref: "github.com/foo/bar@v1..2", Part: Version, Reason: "illegal version v1..2, it is neither a git ref, a commit nor a version constraint"},
+		{Ref: "github.com/foo/bar@^x", Part: Version, Reason: `invalid version constraint "^x": invalid semantic version "x"`},
+		{Ref: "github.com/foo/bar@{yesterday}", Part: Version, Reason: `invalid date "yesterday" in version {yesterday}, expected a day like 2006-01-02 or an RFC 3339 time`},
+		{Ref: "github.com/foo/bar@ma:in@{2023-06-01}", Part: Version, Reason: "illegal version ma:in, it is neither a git ref, a commit nor a version constraint"},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.Ref, func(t *testing.T) {
+			_, err := Parse(tc.Ref, "")
+			assert.Equal(t, &Error{Ref: tc.Ref, Part: tc.Part, Reason: tc.Reason}, err)
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// PluginPrefix is prepended to the type of a custom source to name the
+// plugin installing it, a program looked up in PATH.
+const PluginPrefix = "jb-source-"
+
+// Exit statuses of plugins classifying their failures, from sysexits.h.
+const (
+	// PluginExitNotFound is exited with if the package or version does
+	// not exist.
+	PluginExitNotFound = 66
+	// PluginExitRetry is exited with for failures that may not happen
+	// again, like network errors. The install is retried.
+	PluginExitRetry = 75
+	// PluginExitAuth is exited with if credentials are missing or
+	// rejected.
+	PluginExitAuth = 77
+)
+
+var pluginTypeRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// PluginRequest is written as JSON to the standard input of a plugin.
+type PluginRequest struct {
+	// Config is the one of the custom source, as in the jsonnetfile.
+	Config map[string]interface{} `json:"config"`
+	// Version is the one of the dependency, the locked one when installing
+	// from the lock file. It may be empty.
+	Version string `json:"version"`
+	// Dir is the existing empty directory to write the package into.
+	Dir string `json:"dir"`
+}
+
+// PluginResponse is read as JSON from the standard output of a plugin.
+type PluginResponse struct {
+	// Version is recorded in the lock file, to install exactly the same
+	// package again. The version requested is if it is empty.
+	Version string `json:"version"`
+}
+
+// PluginPackage installs a custom source with the plugin for its type, the
+// program named PluginPrefix followed by the type, so that organizations
+// can vendor from their own artifact stores. The plugin is run as
+//
+//	jb-source-<type> install
+//
+// with a PluginRequest on its standard input, writes the package into the
+// directory of the request and prints a PluginResponse. It fails by exiting
+// with a non-zero status, see PluginExitRetry, the last line of its standard
+// error being the reason.
+type PluginPackage struct {
+	Source *spec.CustomSource
+
+	// Verbose streams the standard error of the plugin to stderr.
+	// Otherwise it is only reported as part of the error of a failing
+	// plugin.
+	Verbose bool
+}
+
+func NewPluginPackage(source *spec.CustomSource) *PluginPackage {
+	return &PluginPackage{
+		Source: source,
+	}
+}
+
+// Install runs the plugin for the type of the source to install version
+// into dir, and returns the version it reports.
+func (p *PluginPackage) Install(ctx context.Context, dir, version string) (lockVersion string, err error) {
+	if !pluginTypeRegex.MatchString(p.Source.Type) {
+		return "", fmt.Errorf("invalid custom source type %q, expected lowercase letters, digits, - and _", p.Source.Type)
+	}
+	program := PluginPrefix + p.Source.Type
+	path, err := exec.LookPath(program)
+	if err != nil {
+		return "", fmt.Errorf("%s is needed to install custom sources of type %s, but it is not in PATH", program, p.Source.Type)
+	}
+
+	req, err := json.Marshal(PluginRequest{Config: p.Source.Config, Version: version, Dir: dir})
+	if err != nil {
+		return "", err
+	}
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	var w io.Writer = stderr
+	if p.Verbose {
+		w = io.MultiWriter(os.Stderr, stderr)
+	}
+	cmd := exec.CommandContext(ctx, path, "install")
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = stdout
+	cmd.Stderr = w
+	if err := cmd.Run(); err != nil {
+		return "", pluginError(errors.Wrapf(err, "%s failed", program), stderr.String(), p.Verbose)
+	}
+
+	var resp PluginResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return "", errors.Wrapf(err, "%s printed an invalid response", program)
+	}
+	if resp.Version == "" {
+		return version, nil
+	}
+	return resp.Version, nil
+}
+
+// pluginError adds the last line of the standard error of a plugin to its
+// failure err, unless it was streamed, and classifies it by the exit status
+// as a NotFoundError, an AuthError or a retryable NetworkError.
+func pluginError(err error, stderr string, verbose bool) error {
+	exit, _ := errors.Cause(err).(*exec.ExitError)
+	if lines := strings.Split(strings.TrimSpace(stderr), "\n"); !verbose && lines[len(lines)-1] != "" {
+		err = fmt.Errorf("%v: %s", err, lines[len(lines)-1])
+	}
+	if exit == nil {
+		return err
+	}
+	switch exit.ExitCode() {
+	case PluginExitNotFound:
+		return &NotFoundError{Err: err}
+	case PluginExitRetry:
+		return &RetryableError{Err: &NetworkError{Err: err}}
+	case PluginExitAuth:
+		return &AuthError{Err: err}
+	}
+	return err
+}
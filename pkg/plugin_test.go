@@ -0,0 +1,133 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePlugin is the plugin of custom sources of type store, which copies
+// the version from the directory of the store in its config and locks it
+// with a suffix.
+const fakePlugin = `#!/bin/sh
+req=$(cat)
+field() { echo "$req" | sed -n "s/.*\"$1\":\"\([^\"]*\)\".*/\1/p"; }
+store=$(field path) version=$(field version) dir=$(field dir)
+if [ ! -d "$store/$version" ]; then
+	echo "no version $version in $store" >&2
+	exit 66
+fi
+cp -R "$store/$version/." "$dir"
+echo "{\"version\": \"$version-locked\"}"
+`
+
+// newPluginStore puts fakePlugin on the PATH and returns a store with the
+// version 1.0, and a function restoring the PATH and removing it.
+func newPluginStore(t *testing.T) (string, func()) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake plugin is a shell script")
+	}
+	tempDir, err := ioutil.TempDir("", "jb-plugin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, store := filepath.Join(tempDir, "bin"), filepath.Join(tempDir, "store")
+	files := map[string]string{
+		filepath.Join(bin, PluginPrefix+"store"):           fakePlugin,
+		filepath.Join(store, "1.0", "main.libsonnet"):      "{}",
+		filepath.Join(store, "1.0-locked", "main.jsonnet"): "{}",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restore := setenv(map[string]string{"PATH": bin + string(os.PathListSeparator) + os.Getenv("PATH")})
+	return store, func() {
+		restore()
+		os.RemoveAll(tempDir)
+	}
+}
+
+func TestPluginPackageInstall(t *testing.T) {
+	store, cleanup := newPluginStore(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "jb-plugin-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := NewPluginPackage(&spec.CustomSource{Type: "store", Config: map[string]interface{}{"path": store}})
+	lockVersion, err := p.Install(context.TODO(), dir, "1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, "1.0-locked", lockVersion)
+	_, err = os.Stat(filepath.Join(dir, "main.libsonnet"))
+	assert.NoError(t, err)
+
+	_, err = p.Install(context.TODO(), dir, "2.0")
+	assert.EqualError(t, err, "jb-source-store failed: exit status 66: no version 2.0 in "+store)
+	assert.IsType(t, &NotFoundError{}, err)
+
+	_, err = NewPluginPackage(&spec.CustomSource{Type: "missing"}).Install(context.TODO(), dir, "1.0")
+	assert.EqualError(t, err, "jb-source-missing is needed to install custom sources of type missing, but it is not in PATH")
+
+	_, err = NewPluginPackage(&spec.CustomSource{Type: "../store"}).Install(context.TODO(), dir, "1.0")
+	assert.EqualError(t, err, `invalid custom source type "../store", expected lowercase letters, digits, - and _`)
+}
+
+func TestInstallerPlugin(t *testing.T) {
+	store, cleanup := newPluginStore(t)
+	defer cleanup()
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	dep := spec.Dependency{
+		Name:    "lib",
+		Source:  spec.Source{CustomSource: &spec.CustomSource{Type: "store", Config: map[string]interface{}{"path": store}}},
+		Version: "1.0",
+	}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{dep}})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "1.0-locked", lock.Dependencies[0].Version)
+	_, err = os.Stat(filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+
+	// The lock file installs the locked version.
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(i.JsonnetHome, "lib", "main.jsonnet"))
+	assert.NoError(t, err)
+}
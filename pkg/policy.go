@@ -0,0 +1,190 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// PolicyHookEnv is the environment variable of the policy hook, see
+// Policy.Hook.
+const PolicyHookEnv = "JB_POLICY_HOOK"
+
+// Policy decides which dependencies may be vendored, so that platform teams
+// can block unapproved sources. The sources of dependencies are checked
+// before anything is fetched from them, see CheckSource, their licenses and
+// the hook once they were fetched, before anything is moved into the vendor
+// directory, see Check.
+type Policy struct {
+	// AllowSources are the hosts, like github.com, or organizations, like
+	// github.com/grafana, dependencies may come from, rejecting all others
+	// if there are any. DenySources are those they must not come from.
+	// Local and custom sources have no host and are always allowed.
+	AllowSources []string
+	DenySources  []string
+
+	// AllowLicenses are the SPDX identifiers, like Apache-2.0, of the
+	// licenses dependencies may have, rejecting all others, unrecognized
+	// ones included, if there are any. DenyLicenses are those they must not
+	// have. See DetectLicense. Local packages are part of the project,
+	// their licenses are not checked.
+	AllowLicenses []string
+	DenyLicenses  []string
+
+	// Hook is a command run directly, without a shell, for every
+	// dependency allowed by the lists, with its PolicyRequest as JSON on
+	// stdin. The dependency is rejected if it fails, for the reason it
+	// prints on stderr.
+	Hook []string
+}
+
+// PolicyRequest is a resolved dependency checked against a Policy.
+type PolicyRequest struct {
+	Name   string      `json:"name"`
+	Source spec.Source `json:"source"`
+	// Location is the host and path of the source, like
+	// github.com/grafana/jsonnet-libs, empty for local and custom sources.
+	Location string `json:"location,omitempty"`
+	// Version is the version required and Locked the one it resolved to,
+	// along with its Tag.
+	Version string `json:"version"`
+	Locked  string `json:"locked"`
+	Tag     string `json:"tag,omitempty"`
+	Sum     string `json:"sum,omitempty"`
+	License string `json:"license,omitempty"`
+	// Dir holds the files about to be vendored.
+	Dir string `json:"dir"`
+}
+
+// PolicyError is returned for a dependency the policy rejects.
+type PolicyError struct {
+	Name     string
+	Location string
+	Reason   string
+}
+
+func (e *PolicyError) Error() string {
+	if e.Location == "" {
+		return fmt.Sprintf("policy rejects %s: %s", e.Name, e.Reason)
+	}
+	return fmt.Sprintf("policy rejects %s from %s: %s", e.Name, e.Location, e.Reason)
+}
+
+// CheckSource returns a *PolicyError if p does not allow the source of the
+// dependency name, see AllowSources.
+func (p *Policy) CheckSource(name string, source spec.Source) error {
+	location := policyLocation(source)
+	if location == "" {
+		return nil
+	}
+	if s, ok := matchSource(p.DenySources, location); ok {
+		return &PolicyError{Name: name, Location: location, Reason: fmt.Sprintf("source %s is denied", s)}
+	}
+	if _, ok := matchSource(p.AllowSources, location); len(p.AllowSources) > 0 && !ok {
+		return &PolicyError{Name: name, Location: location, Reason: "source is not allowed"}
+	}
+	return nil
+}
+
+// Check returns a *PolicyError if p rejects the fetched dependency of req,
+// for its license or by the hook. Its source is checked by CheckSource.
+func (p *Policy) Check(ctx context.Context, req PolicyRequest) error {
+	reject := func(format string, args ...interface{}) error {
+		return &PolicyError{Name: req.Name, Location: req.Location, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	license := req.License
+	if license == "" {
+		license = "unknown"
+	}
+	if req.Source.LocalSource == nil {
+		if containsFold(p.DenyLicenses, req.License) {
+			return reject("license %s is denied", license)
+		}
+		if len(p.AllowLicenses) > 0 && !containsFold(p.AllowLicenses, req.License) {
+			return reject("license %s is not allowed", license)
+		}
+	}
+
+	if len(p.Hook) == 0 {
+		return nil
+	}
+	in, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Hook[0], p.Hook[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok || ctx.Err() != nil {
+			return errors.Wrapf(err, "failed to run policy hook %s", strings.Join(p.Hook, " "))
+		}
+		if reason := strings.TrimSpace(stderr.String()); reason != "" {
+			return reject("%s", reason)
+		}
+		return reject("policy hook %s: %v", strings.Join(p.Hook, " "), err)
+	}
+	return nil
+}
+
+// policyLocation returns the host and path of the source s, see
+// PolicyRequest.Location.
+func policyLocation(s spec.Source) string {
+	switch {
+	case s.GitSource != nil:
+		return remotePath(s.GitSource.Remote)
+	case s.HgSource != nil:
+		return remotePath(s.HgSource.Remote)
+	case s.ArchiveSource != nil:
+		return remotePath(s.ArchiveSource.URL)
+	case s.ReleaseAssetSource != nil:
+		return remotePath(s.ReleaseAssetSource.URL)
+	case s.OCISource != nil:
+		return remotePath("oci://" + s.OCISource.Repository)
+	}
+	return ""
+}
+
+// matchSource returns the first of sources that location is, or is below,
+// ignoring case.
+func matchSource(sources []string, location string) (string, bool) {
+	location = strings.ToLower(location)
+	for _, s := range sources {
+		prefix := strings.ToLower(strings.Trim(s, "/"))
+		if location == prefix || strings.HasPrefix(location, prefix+"/") {
+			return s, true
+		}
+	}
+	return "", false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, l := range list {
+		if strings.EqualFold(l, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyCheck(t *testing.T) {
+	git := func(remote string) spec.Source {
+		return spec.Source{GitSource: &spec.GitSource{Remote: remote}}
+	}
+	local := spec.Source{LocalSource: &spec.LocalSource{Directory: "libs/foo"}}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		source  spec.Source
+		license string
+		reason  string
+	}{
+		{
+			name:   "no lists",
+			source: git("https://github.com/grafana/jsonnet-libs"),
+		},
+		{
+			name:   "allowed host",
+			policy: Policy{AllowSources: []string{"github.com"}},
+			source: git("https://github.com/grafana/jsonnet-libs"),
+		},
+		{
+			name:   "allowed organization, ignoring case",
+			policy: Policy{AllowSources: []string{"GitHub.com/Grafana/"}},
+			source: git("git@github.com:grafana/jsonnet-libs.git"),
+		},
+		{
+			name:   "organization is no prefix of another",
+			policy: Policy{AllowSources: []string{"github.com/grafana"}},
+			source: git("https://github.com/grafana-labs/jsonnet-libs"),
+			reason: "source is not allowed",
+		},
+		{
+			name:   "denied organization",
+			policy: Policy{AllowSources: []string{"github.com"}, DenySources: []string{"github.com/evil"}},
+			source: git("https://github.com/evil/jsonnet-libs"),
+			reason: "source github.com/evil is denied",
+		},
+		{
+			name:   "release asset",
+			policy: Policy{DenySources: []string{"example.com"}},
+			source: spec.Source{ReleaseAssetSource: &spec.ReleaseAssetSource{URL: "https://example.com/foo.tar.gz"}},
+			reason: "source example.com is denied",
+		},
+		{
+			name:    "local packages have no source",
+			policy:  Policy{AllowSources: []string{"github.com"}, AllowLicenses: []string{"MIT"}},
+			source:  local,
+			license: "",
+		},
+		{
+			name:    "allowed license",
+			policy:  Policy{AllowLicenses: []string{"apache-2.0"}},
+			source:  git("https://github.com/grafana/jsonnet-libs"),
+			license: "Apache-2.0",
+		},
+		{
+			name:   "unknown license",
+			policy: Policy{AllowLicenses: []string{"Apache-2.0"}},
+			source: git("https://github.com/grafana/jsonnet-libs"),
+			reason: "license unknown is not allowed",
+		},
+		{
+			name:    "denied license",
+			policy:  Policy{DenyLicenses: []string{"GPL-3.0"}},
+			source:  git("https://github.com/grafana/jsonnet-libs"),
+			license: "GPL-3.0",
+			reason:  "license GPL-3.0 is denied",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := PolicyRequest{Name: "foo", Source: tc.source, Location: policyLocation(tc.source), License: tc.license}
+			err := tc.policy.CheckSource(req.Name, req.Source)
+			if err == nil {
+				err = tc.policy.Check(context.TODO(), req)
+			}
+			if tc.reason == "" {
+				assert.NoError(t, err)
+				return
+			}
+			if assert.IsType(t, &PolicyError{}, err) {
+				assert.Equal(t, tc.reason, err.(*PolicyError).Reason)
+			}
+		})
+	}
+}
+
+func TestPolicyHook(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("policy hooks are tested with sh")
+	}
+	tempDir, err := ioutil.TempDir("", "jb-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	lib := filepath.Join(tempDir, "libs", "mylib")
+	assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{{
+		Name:   "mylib",
+		Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "libs/mylib"}},
+	}}}
+
+	// The hook is given the dependency as JSON.
+	request := filepath.Join(tempDir, "request.json")
+	i := &Installer{
+		JsonnetHome: filepath.Join(tempDir, "vendor"),
+		Policy:      &Policy{Hook: []string{"sh", "-c", "cat > " + request}},
+	}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadFile(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req PolicyRequest
+	assert.NoError(t, json.Unmarshal(data, &req))
+	assert.Equal(t, "mylib", req.Name)
+	assert.Equal(t, m.Dependencies[0].Source, req.Source)
+	assert.NotEmpty(t, req.Dir)
+
+	// A rejected dependency is never vendored.
+	assert.NoError(t, os.RemoveAll(i.JsonnetHome))
+	i.Policy.Hook = []string{"sh", "-c", "echo not approved >&2; exit 1"}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.Equal(t, &PolicyError{Name: "mylib", Reason: "not approved"}, err)
+	exists, err := FileExists(filepath.Join(i.JsonnetHome, "mylib"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestPolicyDeniedSourceNotFetched(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.NotFound(w, r)
+	}))
+	defer srv.Close()
+
+	tempDir, err := ioutil.TempDir("", "jb-policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	source := spec.Source{ArchiveSource: &spec.ArchiveSource{URL: srv.URL + "/lib.tar.gz"}}
+	host := strings.SplitN(policyLocation(source), "/", 2)[0]
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{{Name: "lib", Source: source, Version: "1.0.0"}}}
+	i := &Installer{
+		JsonnetHome: filepath.Join(tempDir, "vendor"),
+		Policy:      &Policy{DenySources: []string{host}},
+	}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.IsType(t, &PolicyError{}, err)
+	assert.Equal(t, 0, requests)
+}
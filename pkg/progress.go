@@ -0,0 +1,43 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+// Stage is a step of installing a package, see Installer.Progress.
+type Stage string
+
+const (
+	// StageFetching starts the download of a package.
+	StageFetching Stage = "fetching"
+	// StageCloning fetches the commit of a git package.
+	StageCloning Stage = "cloning"
+	// StageCheckingOut checks out the files of a git package.
+	StageCheckingOut Stage = "checking out"
+	// StageCopying moves a downloaded package into the vendor directory.
+	StageCopying Stage = "copying"
+	// StageDone is reached once a package is vendored and locked.
+	StageDone Stage = "done"
+)
+
+// ProgressFunc is told that the package name reached stage. Packages are
+// downloaded concurrently, so it may be called concurrently as well.
+type ProgressFunc func(name string, stage Stage)
+
+// progress reports that the package name reached stage to Progress, if
+// set.
+func (i *Installer) progress(name string, stage Stage) {
+	if i.Progress != nil {
+		i.Progress(name, stage)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallerProgress(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-progress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var mu sync.Mutex
+	stages := []Stage{}
+	i := &Installer{
+		JsonnetHome: filepath.Join(tempDir, "vendor"),
+		Progress: func(name string, stage Stage) {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, "foo", name)
+			stages = append(stages, stage)
+		},
+	}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, "master")}}
+
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Equal(t, []Stage{StageFetching, StageCloning, StageCheckingOut, StageCopying, StageDone}, stages)
+}
@@ -0,0 +1,103 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// rateLimiter limits the downloads sharing it to rate bytes per second in
+// total, however many of them run concurrently.
+type rateLimiter struct {
+	rate int64
+
+	mu sync.Mutex
+	// next is the time by which the bytes transferred so far are allowed.
+	next time.Time
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// wait blocks until the rate allows n more bytes to have been transferred,
+// or ctx is done. Time the downloads were idle is not saved up for bursts.
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	l.next = l.next.Add(time.Duration(int64(n) * int64(time.Second) / l.rate))
+	delay := l.next.Sub(now)
+	l.mu.Unlock()
+
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type rateLimiterKey struct{}
+
+// withMaxRate returns a context limiting all downloads made with it to
+// rate bytes per second together, see throttle. Zero does not limit them.
+func withMaxRate(ctx context.Context, rate int64) context.Context {
+	if rate <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, rateLimiterKey{}, newRateLimiter(rate))
+}
+
+// throttle returns r, the body of a download made with ctx, limited by the
+// rate limiter of ctx, if any. Reading it blocks once the limit is reached,
+// which slows the server down through TCP flow control.
+func throttle(ctx context.Context, r io.Reader) io.Reader {
+	l, ok := ctx.Value(rateLimiterKey{}).(*rateLimiter)
+	if !ok {
+		return r
+	}
+	return &throttledReader{ctx: ctx, r: r, l: l}
+}
+
+type throttledReader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rateLimiter
+}
+
+// throttledChunk bounds the reads of a throttledReader, so that slow rates
+// are kept evenly rather than in bursts of whole buffers.
+const throttledChunk = 16 << 10
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttledChunk {
+		p = p[:throttledChunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := t.l.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package registry queries an index of known jsonnet packages, a JSON
+// document served over HTTP(S) or read from a file, for jb search and jb
+// info.
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/pkg/errors"
+)
+
+// Env is the environment variable holding the URL of the index.
+const Env = "JB_REGISTRY"
+
+// Package is a package known to the index.
+type Package struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Remote is the package reference it is installed with, like
+	// github.com/grafana/grafonnet-lib/grafonnet.
+	Remote string `json:"remote"`
+	// Latest is the newest release tag, if there is one.
+	Latest string `json:"latest,omitempty"`
+}
+
+// Index is the list of packages of a registry.
+type Index struct {
+	Packages []Package `json:"packages"`
+}
+
+// Load reads the index at location, an HTTP(S) URL, downloaded trusting the
+// certificates of caFile like pkg.Download, or a file.
+func Load(ctx context.Context, caFile, location string) (*Index, error) {
+	var b []byte
+	if strings.HasPrefix(location, "https://") || strings.HasPrefix(location, "http://") {
+		var buf bytes.Buffer
+		if err := pkg.Download(ctx, caFile, location, &buf); err != nil {
+			return nil, err
+		}
+		b = buf.Bytes()
+	} else {
+		var err error
+		if b, err = ioutil.ReadFile(location); err != nil {
+			return nil, errors.Wrap(err, "failed to read registry index")
+		}
+	}
+
+	ix, err := Parse(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid registry index %s", location)
+	}
+	return ix, nil
+}
+
+// Parse decodes an index, every package of which needs a unique name and a
+// remote.
+func Parse(b []byte) (*Index, error) {
+	ix := &Index{}
+	if err := json.Unmarshal(b, ix); err != nil {
+		return nil, err
+	}
+
+	names := map[string]bool{}
+	for n, p := range ix.Packages {
+		switch {
+		case p.Name == "":
+			return nil, fmt.Errorf("package %d has no name", n+1)
+		case p.Remote == "":
+			return nil, fmt.Errorf("package %s has no remote", p.Name)
+		case names[p.Name]:
+			return nil, fmt.Errorf("package %s is listed twice", p.Name)
+		}
+		names[p.Name] = true
+	}
+	return ix, nil
+}
+
+// Search returns the packages whose name, description or remote contains
+// term, ignoring case. Packages named term come first, the others are
+// ordered by name.
+func (ix *Index) Search(term string) []Package {
+	term = strings.ToLower(term)
+	found := []Package{}
+	for _, p := range ix.Packages {
+		for _, s := range []string{p.Name, p.Description, p.Remote} {
+			if strings.Contains(strings.ToLower(s), term) {
+				found = append(found, p)
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		exactI, exactJ := strings.ToLower(found[i].Name) == term, strings.ToLower(found[j].Name) == term
+		if exactI != exactJ {
+			return exactI
+		}
+		return found[i].Name < found[j].Name
+	})
+	return found
+}
+
+// Lookup returns the package named name, or else the one installed with
+// the package reference name.
+func (ix *Index) Lookup(name string) (Package, bool) {
+	for _, p := range ix.Packages {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	for _, p := range ix.Packages {
+		if p.Remote == name {
+			return p, true
+		}
+	}
+	return Package{}, false
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testIndex = `{
+    "packages": [
+        {"name": "grafonnet", "description": "Jsonnet library for Grafana dashboards", "remote": "github.com/grafana/grafonnet-lib/grafonnet", "latest": "v0.1.0"},
+        {"name": "grafana-builder", "description": "Grafana dashboards built from rows and panels", "remote": "github.com/grafana/jsonnet-libs/grafana-builder"},
+        {"name": "grafana", "description": "Grafana mixin", "remote": "github.com/grafana/grafana/grafana-mixin"},
+        {"name": "ksonnet", "description": "Kubernetes objects", "remote": "github.com/ksonnet/ksonnet-lib/ksonnet.beta.4"}
+    ]
+}`
+
+func TestSearch(t *testing.T) {
+	ix, err := Parse([]byte(testIndex))
+	assert.NoError(t, err)
+
+	names := func(ps []Package) []string {
+		res := []string{}
+		for _, p := range ps {
+			res = append(res, p.Name)
+		}
+		return res
+	}
+	assert.Equal(t, []string{"grafana", "grafana-builder", "grafonnet"}, names(ix.Search("grafana")))
+	assert.Equal(t, []string{"grafana-builder", "grafonnet"}, names(ix.Search("Dashboards")))
+	assert.Equal(t, []string{"ksonnet"}, names(ix.Search("kubernetes")))
+	assert.Equal(t, []string{}, names(ix.Search("prometheus")))
+}
+
+func TestLookup(t *testing.T) {
+	ix, err := Parse([]byte(testIndex))
+	assert.NoError(t, err)
+
+	p, ok := ix.Lookup("grafonnet")
+	assert.True(t, ok)
+	assert.Equal(t, "v0.1.0", p.Latest)
+	p, ok = ix.Lookup("github.com/ksonnet/ksonnet-lib/ksonnet.beta.4")
+	assert.True(t, ok)
+	assert.Equal(t, "ksonnet", p.Name)
+	_, ok = ix.Lookup("prometheus")
+	assert.False(t, ok)
+}
+
+func TestParseInvalid(t *testing.T) {
+	for index, reason := range map[string]string{
+		`{"packages": [{"remote": "github.com/foo/bar"}]}`:                                                "package 1 has no name",
+		`{"packages": [{"name": "bar"}]}`:                                                                 "package bar has no remote",
+		`{"packages": [{"name": "bar", "remote": "github.com/foo/bar"}, {"name": "bar", "remote": "x"}]}`: "package bar is listed twice",
+	} {
+		_, err := Parse([]byte(index))
+		assert.EqualError(t, err, reason)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(testIndex))
+	}))
+	defer srv.Close()
+
+	ix, err := Load(context.TODO(), "", srv.URL)
+	assert.NoError(t, err)
+	assert.Len(t, ix.Packages, 4)
+
+	_, err = Load(context.TODO(), "", "does-not-exist.json")
+	assert.Error(t, err)
+}
@@ -0,0 +1,239 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// ReleaseAssetPackage installs a single file published as a release asset.
+// Unlike archives, the asset is not extracted but placed as is.
+type ReleaseAssetPackage struct {
+	Source *spec.ReleaseAssetSource
+
+	// CAFile holds the certificate authorities trusted for HTTPS, see
+	// CAFileEnv. The system ones are trusted if it is empty.
+	CAFile string
+
+	// Mirrors rewrite the URL that is downloaded. The lock file keeps the
+	// URL of Source.
+	Mirrors Mirrors
+
+	// SHA256 is the checksum of the installed asset, set by Install.
+	SHA256 string
+}
+
+func NewReleaseAssetPackage(source *spec.ReleaseAssetSource) *ReleaseAssetPackage {
+	return &ReleaseAssetPackage{
+		Source: source,
+	}
+}
+
+// Install downloads the asset into dir. If the source declares a checksum,
+// an asset with a different checksum fails the install. The version is
+// taken from the release and returned as is.
+func (p *ReleaseAssetPackage) Install(ctx context.Context, dir, version string) (lockVersion string, err error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	u, err := url.Parse(p.Source.URL)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Create(filepath.Join(dir, path.Base(u.Path)))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	sum, err := download(ctx, p.CAFile, p.Mirrors.Rewrite(p.Source.URL), f)
+	if err != nil {
+		return "", err
+	}
+	if err := verifySHA256(p.Source.URL, p.Source.SHA256, sum); err != nil {
+		return "", err
+	}
+	p.SHA256 = sum
+
+	return version, nil
+}
+
+// LockSource returns the source with the checksum of the installed asset.
+func (p *ReleaseAssetPackage) LockSource() spec.Source {
+	return spec.Source{ReleaseAssetSource: &spec.ReleaseAssetSource{
+		URL:    p.Source.URL,
+		SHA256: p.SHA256,
+	}}
+}
+
+// Download writes the content at rawurl to w the way archives and release
+// assets are downloaded, authenticated with the credentials for its host and
+// trusting the certificates of caFile, see CAFileEnv.
+func Download(ctx context.Context, caFile, rawurl string, w io.Writer) error {
+	_, err := download(ctx, caFile, rawurl, w)
+	return err
+}
+
+// download writes the content at rawurl to w and returns its hex encoded
+// SHA256 checksum, see get and transfer.
+func download(ctx context.Context, caFile, rawurl string, w io.Writer) (string, error) {
+	resp, err := get(ctx, caFile, rawurl, nil)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	h := sha256.New()
+	if err := transfer(ctx, caFile, rawurl, nil, resp, io.MultiWriter(w, h)); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// maxResumes is the number of times an interrupted download is resumed
+// before it fails, see transfer.
+const maxResumes = 5
+
+// transfer copies the body of resp, the response to the download of rawurl
+// with header, to w, throttled by the rate limiter of ctx, see throttle. A
+// transfer interrupted by a transient network failure continues where it
+// stopped with a range request, provided the server accepts them and can
+// tell by a strong ETag or the modification time that the content did not
+// change meanwhile. Otherwise the download fails, to be retried from the
+// start.
+func transfer(ctx context.Context, caFile, rawurl string, header http.Header, resp *http.Response, w io.Writer) error {
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	resumable := resp.StatusCode == http.StatusOK && resp.Header.Get("Accept-Ranges") == "bytes" && validator != ""
+
+	body := resp.Body
+	var written int64
+	for resumes := 0; ; resumes++ {
+		n, err := io.Copy(w, throttle(ctx, body))
+		if body != resp.Body {
+			body.Close()
+		}
+		written += n
+		if err == nil {
+			return nil
+		}
+		err = retryableDownload(ctx, errors.Wrapf(err, "failed to download %s", rawurl))
+		if _, ok := IsRetryable(err); !ok || !resumable || resumes >= maxResumes {
+			return err
+		}
+
+		h := http.Header{}
+		for k, v := range header {
+			h[k] = v
+		}
+		h.Set("Range", fmt.Sprintf("bytes=%d-", written))
+		h.Set("If-Range", validator)
+		next, rerr := get(ctx, caFile, rawurl, h)
+		if rerr != nil {
+			return rerr
+		}
+		// A complete response means the content changed.
+		if next.StatusCode != http.StatusPartialContent || !strings.HasPrefix(next.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", written)) {
+			next.Body.Close()
+			return err
+		}
+		body = next.Body
+	}
+}
+
+// get requests rawurl with the additional header, returning the response
+// if its status is 200 OK, 206 Partial Content for range requests or 304
+// Not Modified. HTTPS requests to hosts with
+// credentials, see credentials, are authenticated. The certificates of
+// caFile are trusted, see httpClient.
+func get(ctx context.Context, caFile, rawurl string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	// Credentials are never sent over plain HTTP.
+	if req.URL.User == nil && req.URL.Scheme == "https" {
+		if username, password, ok := credentials(req.URL.Hostname()); ok {
+			req.SetBasicAuth(username, password)
+		}
+	}
+	return send(ctx, caFile, req, rawurl)
+}
+
+// send sends req, the download of rawurl, like get, classifying failures
+// by the status of the response.
+func send(ctx context.Context, caFile string, req *http.Request, rawurl string) (*http.Response, error) {
+	client, err := httpClient(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, retryableDownload(ctx, errors.Wrapf(err, "failed to download %s", rawurl))
+	}
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	if err := rateLimitError(rawurl, req, resp); err != nil {
+		return nil, err
+	}
+	err = statusError(fmt.Errorf("failed to download %s: %s", rawurl, resp.Status), resp.StatusCode)
+	if retryableStatus(resp.StatusCode) {
+		return nil, &RetryableError{Err: err, After: retryAfter(resp)}
+	}
+	return nil, err
+}
+
+// retryableDownload wraps the failed download err in a NetworkError, which
+// is a RetryableError if the failure is transient, unless ctx was canceled.
+func retryableDownload(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	if transientNetError(err) {
+		return &RetryableError{Err: &NetworkError{Err: err}}
+	}
+	return &NetworkError{Err: err}
+}
+
+// verifySHA256 fails if a checksum is expected and differs from sum.
+func verifySHA256(rawurl, expected, sum string) error {
+	if expected != "" && expected != sum {
+		return fmt.Errorf("checksum mismatch for %s: expected sha256 %s, got %s", rawurl, expected, sum)
+	}
+	return nil
+}
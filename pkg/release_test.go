@@ -0,0 +1,84 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallerReleaseAsset(t *testing.T) {
+	content := "{ lib: true }"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/foo/bar/releases/download/v1/lib.libsonnet" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer srv.Close()
+
+	tempDir, err := ioutil.TempDir("", "jb-release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	url := srv.URL + "/foo/bar/releases/download/v1/lib.libsonnet"
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{{
+		Name:    "bar",
+		Source:  spec.Source{ReleaseAssetSource: &spec.ReleaseAssetSource{URL: url}},
+		Version: "v1",
+	}}}
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+
+	installed, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, "bar", "lib.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, content, string(installed))
+
+	assert.Equal(t, "v1", lock.Dependencies[0].Version)
+	assert.Equal(t, &spec.ReleaseAssetSource{
+		URL:    url,
+		SHA256: "fcba9ba0d6fadedc44a9e924b30373a992ed1b99508e7e466ffe7130142c8a5c",
+	}, lock.Dependencies[0].Source.ReleaseAssetSource)
+
+	// Reinstalling from the lock verifies the recorded checksum.
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+
+	content = "{ lib: false }"
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+
+	missing := spec.JsonnetFile{Dependencies: []spec.Dependency{{
+		Name:    "bar",
+		Source:  spec.Source{ReleaseAssetSource: &spec.ReleaseAssetSource{URL: srv.URL + "/missing"}},
+		Version: "v1",
+	}}}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), missing)
+	assert.Error(t, err)
+}
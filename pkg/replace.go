@@ -0,0 +1,58 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// ApplyReplace returns deps with the source and version of the dependencies
+// named by replace substituted, see spec.Replace. The dependencies keep
+// their names, so they are vendored where the original ones would be.
+func ApplyReplace(deps []spec.Dependency, replace []spec.Replace) []spec.Dependency {
+	if len(replace) == 0 {
+		return deps
+	}
+	byName := make(map[string]spec.Replace, len(replace))
+	for _, r := range replace {
+		byName[r.Name] = r
+	}
+
+	res := make([]spec.Dependency, 0, len(deps))
+	for _, d := range deps {
+		if r, ok := byName[d.Name]; ok {
+			if r.Source != nil {
+				d.Source = *r.Source
+			}
+			if r.Version != "" {
+				d.Version = r.Version
+			}
+		}
+		res = append(res, d)
+	}
+	return res
+}
+
+// replacedLocally reports whether dep was replaced by a local directory of
+// the project, which is then relative to the project rather than to the
+// jsonnetfile requiring it.
+func (i *Installer) replacedLocally(dep spec.Dependency) bool {
+	for _, r := range i.replace {
+		if r.Name == dep.Name && r.Source != nil && r.Source.LocalSource != nil {
+			return true
+		}
+	}
+	return false
+}
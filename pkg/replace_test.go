@@ -0,0 +1,105 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyReplace(t *testing.T) {
+	deps := []spec.Dependency{
+		gitDependency("foo", "https://github.com/org/foo", "v1.0.0"),
+		gitDependency("bar", "https://github.com/org/bar", "master"),
+	}
+	fork := spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/fork/foo"}}
+
+	replaced := ApplyReplace(deps, []spec.Replace{
+		{Name: "foo", Source: &fork, Version: "fix"},
+		{Name: "bar", Version: "v2.0.0"},
+		{Name: "missing", Version: "master"},
+	})
+	assert.Equal(t, []spec.Dependency{
+		{Name: "foo", Source: fork, Version: "fix"},
+		gitDependency("bar", "https://github.com/org/bar", "v2.0.0"),
+	}, replaced)
+	assert.Equal(t, "v1.0.0", deps[0].Version)
+}
+
+func TestInstallerReplace(t *testing.T) {
+	upstream := newTestRepo(t)
+	defer upstream.Close()
+	upstream.commit("main.libsonnet", "'upstream'")
+
+	tempDir, err := ioutil.TempDir("", "jb-replace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// app requires lib, which the project replaces by its own fork.
+	write := func(name, content string) {
+		path := filepath.Join(tempDir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	app, err := json.Marshal(spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("lib", upstream.Dir, "master")}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(filepath.Join("libs", "app", JsonnetFile), string(app))
+	write(filepath.Join("forks", "lib", "main.libsonnet"), "'fork'")
+
+	m := spec.JsonnetFile{
+		Dependencies: []spec.Dependency{{
+			Name:   "app",
+			Source: spec.Source{LocalSource: &spec.LocalSource{Directory: "libs/app"}},
+		}},
+		Replace: []spec.Replace{{
+			Name:   "lib",
+			Source: &spec.Source{LocalSource: &spec.LocalSource{Directory: "forks/lib"}},
+		}},
+	}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "'fork'", string(content))
+	assert.Equal(t, m.Replace, lock.Replace)
+	for _, d := range lock.Dependencies {
+		if d.Name == "lib" {
+			assert.Equal(t, "forks/lib", SourceString(d.Source))
+		}
+	}
+	assert.NoError(t, CheckLock(m, *lock))
+
+	// Installing the lock installs the fork again, and dropping the
+	// replacement puts the lock out of sync.
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+	m.Replace = nil
+	assert.IsType(t, &LockOutOfSyncError{}, CheckLock(m, *lock))
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ReproducibleTime is the modification time of the vendored files of
+// Reproducible installs that have no commit date, like archives. It is the
+// earliest time zip archives can hold.
+var ReproducibleTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// normalize gives the vendor directory the same file metadata on every
+// install of the same lock, see Installer.Reproducible: the files of every
+// locked package are dated to the commit it was installed from, all else
+// to ReproducibleTime. A vendor directory holding a jsonnetfile is most
+// likely the project itself, only its packages are normalized then.
+func (i *Installer) normalize() error {
+	if exists, _ := FileExists(filepath.Join(i.JsonnetHome, JsonnetFile)); !exists {
+		if err := normalizeTree(i.JsonnetHome, ReproducibleTime); err != nil {
+			return errors.Wrap(err, "failed to normalize the vendor directory")
+		}
+	}
+
+	for _, d := range i.lock.Dependencies {
+		mtime := ReproducibleTime
+		if t, err := time.Parse(time.RFC3339, d.Date); err == nil {
+			mtime = t
+		}
+		dir := filepath.Join(i.JsonnetHome, d.Name)
+		if info, err := os.Lstat(dir); err != nil || !info.IsDir() {
+			// Linked packages are the files of the project.
+			continue
+		}
+		if err := normalizeTree(dir, mtime); err != nil {
+			return errors.Wrapf(err, "failed to normalize %s", d.Name)
+		}
+	}
+	return nil
+}
+
+// normalizeTree sets the mode of the files of the tree at dir to 0644, or
+// 0755 if executable by anyone, that of its directories to 0755, and the
+// modification time of both to mtime. Directories are dated after their
+// contents. Symlinks are left as they are, their times cannot be set
+// without following them.
+func normalizeTree(dir string, mtime time.Time) error {
+	dirs := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		mode := os.FileMode(0644)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			return nil
+		case info.IsDir():
+			dirs = append(dirs, path)
+			return os.Chmod(path, 0755)
+		case info.Mode()&0111 != 0:
+			mode = 0755
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+		return os.Chtimes(path, mtime, mtime)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Walk visits directories before their contents, in lexical order.
+	for j := len(dirs) - 1; j >= 0; j-- {
+		if err := os.Chtimes(dirs[j], mtime, mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
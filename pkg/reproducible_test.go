@@ -0,0 +1,109 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+// treeMetadata returns the mode and modification time of every file and
+// directory of the tree at dir, by their path relative to it.
+func treeMetadata(t *testing.T, dir string) map[string]string {
+	metadata := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		metadata[filepath.ToSlash(rel)] = info.Mode().String() + " " + info.ModTime().UTC().Format(time.RFC3339)
+		return nil
+	})
+	assert.NoError(t, err)
+	return metadata
+}
+
+func TestInstallerReproducible(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("lib/main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, "master")}}
+	trees := []map[string]string{}
+	for _, name := range []string{"a", "b"} {
+		i := &Installer{JsonnetHome: filepath.Join(tempDir, name, "vendor"), Reproducible: true}
+		lock, err := i.Install(context.TODO(), filepath.Join(tempDir, name, JsonnetFile), m)
+		assert.NoError(t, err)
+		date, err := time.Parse(time.RFC3339, lock.Dependencies[0].Date)
+		assert.NoError(t, err)
+
+		info, err := os.Stat(filepath.Join(i.JsonnetHome, "foo", "lib", "main.libsonnet"))
+		assert.NoError(t, err)
+		assert.True(t, date.Equal(info.ModTime()))
+		info, err = os.Stat(i.JsonnetHome)
+		assert.NoError(t, err)
+		assert.True(t, ReproducibleTime.Equal(info.ModTime()))
+
+		trees = append(trees, treeMetadata(t, i.JsonnetHome))
+		// The second install happens at another time.
+		time.Sleep(time.Second)
+	}
+	assert.Equal(t, trees[0], trees[1])
+}
+
+func TestNormalizeTree(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("modes are not supported on Windows")
+	}
+
+	dir, err := ioutil.TempDir("", "jb-normalize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "sub", "script.sh"), nil, 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "main.libsonnet"), nil, 0600))
+	assert.NoError(t, os.Symlink("sub", filepath.Join(dir, "link")))
+
+	assert.NoError(t, normalizeTree(dir, ReproducibleTime))
+	date := " " + ReproducibleTime.Format(time.RFC3339)
+	metadata := treeMetadata(t, dir)
+	delete(metadata, "link")
+	assert.Equal(t, map[string]string{
+		".":              "drwxr-xr-x" + date,
+		"main.libsonnet": "-rw-r--r--" + date,
+		"sub":            "drwxr-xr-x" + date,
+		"sub/script.sh":  "-rwxr-xr-x" + date,
+	}, metadata)
+}
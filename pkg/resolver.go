@@ -0,0 +1,114 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// ConflictStrategy decides which version of a package is installed when
+// several versions of it are required.
+type ConflictStrategy string
+
+const (
+	// ConflictFail fails the install on conflicting requirements.
+	ConflictFail ConflictStrategy = "fail"
+	// ConflictPreferNewest installs the highest of the required versions.
+	// Only semantic versions can be compared, other conflicts fail.
+	ConflictPreferNewest ConflictStrategy = "prefer-newest"
+	// ConflictPreferDirect installs the version required closest to the
+	// root jsonnetfile, so direct dependencies always win over transitive
+	// ones. Conflicts at the same depth fail.
+	ConflictPreferDirect ConflictStrategy = "prefer-direct"
+)
+
+// ConflictStrategies lists all valid conflict strategies.
+var ConflictStrategies = []ConflictStrategy{ConflictFail, ConflictPreferNewest, ConflictPreferDirect}
+
+// Requirement is a version of a package required by a jsonnetfile.
+type Requirement struct {
+	Dependency spec.Dependency
+	// From is the jsonnetfile requiring the dependency.
+	From string
+	// Depth is 0 for dependencies of the root jsonnetfile, 1 for their
+	// dependencies and so on.
+	Depth int
+}
+
+func (r Requirement) String() string {
+	return fmt.Sprintf("%s (from %s)", r.Dependency.Version, r.From)
+}
+
+// VersionConflictError is returned when a package is required in different
+// versions and the conflict strategy cannot decide between them.
+type VersionConflictError struct {
+	Name         string
+	Requirements []Requirement
+	Strategy     ConflictStrategy
+	Reason       string
+}
+
+func (e *VersionConflictError) Error() string {
+	reqs := make([]string, 0, len(e.Requirements))
+	for _, r := range e.Requirements {
+		reqs = append(reqs, r.String())
+	}
+
+	msg := fmt.Sprintf("%s for %s: %s", VersionMismatch.Error(), e.Name, strings.Join(reqs, " and "))
+	if e.Reason != "" {
+		msg += fmt.Sprintf(", %s cannot resolve it: %s", e.Strategy, e.Reason)
+	}
+	return msg
+}
+
+// Resolve decides between the already installed requirement prev and the
+// conflicting requirement next. It returns true if next should replace
+// prev, false if prev should be kept, or a VersionConflictError.
+func (s ConflictStrategy) Resolve(prev, next Requirement) (bool, error) {
+	conflict := &VersionConflictError{
+		Name:         next.Dependency.Name,
+		Requirements: []Requirement{prev, next},
+		Strategy:     s,
+	}
+
+	switch s {
+	case ConflictPreferNewest:
+		pv, err := semver.Parse(prev.Dependency.Version)
+		if err != nil {
+			conflict.Reason = err.Error()
+			return false, conflict
+		}
+		nv, err := semver.Parse(next.Dependency.Version)
+		if err != nil {
+			conflict.Reason = err.Error()
+			return false, conflict
+		}
+		return semver.Compare(nv, pv) > 0, nil
+	case ConflictPreferDirect:
+		if prev.Depth == next.Depth {
+			conflict.Reason = "both are required at the same depth"
+			return false, conflict
+		}
+		return next.Depth < prev.Depth, nil
+	case ConflictFail, "":
+		return false, conflict
+	}
+
+	return false, fmt.Errorf("unknown conflict strategy %q", s)
+}
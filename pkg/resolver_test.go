@@ -0,0 +1,178 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConflictStrategyResolve(t *testing.T) {
+	requirement := func(version string, depth int) Requirement {
+		return Requirement{Dependency: gitDependency("c", "https://github.com/foo/c", version), From: "jsonnetfile.json", Depth: depth}
+	}
+
+	testcases := []struct {
+		Name     string
+		Strategy ConflictStrategy
+		Prev     Requirement
+		Next     Requirement
+		Replace  bool
+		Error    bool
+	}{{
+		Name:     "Fail",
+		Strategy: ConflictFail,
+		Prev:     requirement("v1.0.0", 1),
+		Next:     requirement("v2.0.0", 1),
+		Error:    true,
+	}, {
+		Name:  "DefaultFails",
+		Prev:  requirement("v1.0.0", 1),
+		Next:  requirement("v2.0.0", 1),
+		Error: true,
+	}, {
+		Name:     "PreferNewestReplaces",
+		Strategy: ConflictPreferNewest,
+		Prev:     requirement("v1.0.0", 0),
+		Next:     requirement("v2.0.0", 1),
+		Replace:  true,
+	}, {
+		Name:     "PreferNewestKeeps",
+		Strategy: ConflictPreferNewest,
+		Prev:     requirement("v1.10.0", 1),
+		Next:     requirement("v1.9.0", 0),
+	}, {
+		Name:     "PreferNewestNotSemver",
+		Strategy: ConflictPreferNewest,
+		Prev:     requirement("v1.0.0", 0),
+		Next:     requirement("master", 1),
+		Error:    true,
+	}, {
+		Name:     "PreferDirectReplaces",
+		Strategy: ConflictPreferDirect,
+		Prev:     requirement("master", 2),
+		Next:     requirement("v1.0.0", 1),
+		Replace:  true,
+	}, {
+		Name:     "PreferDirectKeeps",
+		Strategy: ConflictPreferDirect,
+		Prev:     requirement("v1.0.0", 0),
+		Next:     requirement("v2.0.0", 1),
+	}, {
+		Name:     "PreferDirectSameDepth",
+		Strategy: ConflictPreferDirect,
+		Prev:     requirement("v1.0.0", 1),
+		Next:     requirement("v2.0.0", 1),
+		Error:    true,
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			replace, err := tc.Strategy.Resolve(tc.Prev, tc.Next)
+			if tc.Error {
+				assert.IsType(t, &VersionConflictError{}, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Replace, replace)
+		})
+	}
+}
+
+func TestInstallerConflicts(t *testing.T) {
+	a, b, c := newTestRepo(t), newTestRepo(t), newTestRepo(t)
+	defer a.Close()
+	defer b.Close()
+	defer c.Close()
+
+	jsonnetfile := func(deps ...spec.Dependency) string {
+		b, err := json.Marshal(spec.JsonnetFile{Dependencies: deps})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+
+	v1 := c.commit("main.libsonnet", "{ v: 1 }")
+	c.git("tag", "v1.0.0")
+	v2 := c.commit("main.libsonnet", "{ v: 2 }")
+	c.git("tag", "v2.0.0")
+
+	// a and b require different versions of c.
+	a.commit(JsonnetFile, jsonnetfile(gitDependency("c", c.Dir, "v1.0.0")))
+	b.commit(JsonnetFile, jsonnetfile(gitDependency("c", c.Dir, "v2.0.0")))
+
+	testcases := []struct {
+		Name     string
+		Strategy ConflictStrategy
+		Deps     []spec.Dependency
+		Version  string
+		Error    bool
+	}{{
+		Name:     "Fail",
+		Strategy: ConflictFail,
+		Deps:     []spec.Dependency{gitDependency("a", a.Dir, "master"), gitDependency("b", b.Dir, "master")},
+		Error:    true,
+	}, {
+		Name:     "PreferNewest",
+		Strategy: ConflictPreferNewest,
+		Deps:     []spec.Dependency{gitDependency("a", a.Dir, "master"), gitDependency("b", b.Dir, "master")},
+		Version:  v2,
+	}, {
+		Name:     "PreferDirect",
+		Strategy: ConflictPreferDirect,
+		Deps:     []spec.Dependency{gitDependency("b", b.Dir, "master"), gitDependency("c", c.Dir, "v1.0.0")},
+		Version:  v1,
+	}}
+
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			tempDir, err := ioutil.TempDir("", "jb-resolver")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tempDir)
+
+			i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Conflicts: tc.Strategy}
+			lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: tc.Deps})
+			if tc.Error {
+				assert.IsType(t, &VersionConflictError{}, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			versions := map[string]string{}
+			for _, d := range lock.Dependencies {
+				versions[d.Name] = d.Version
+			}
+			assert.Equal(t, tc.Version, versions["c"])
+
+			content, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, "c", "main.libsonnet"))
+			assert.NoError(t, err)
+			if tc.Version == v1 {
+				assert.Equal(t, "{ v: 1 }", string(content))
+			} else {
+				assert.Equal(t, "{ v: 2 }", string(content))
+			}
+		})
+	}
+}
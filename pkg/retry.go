@@ -0,0 +1,185 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultRetryDelay is the delay before the first retry of a failed
+// download if the Installer sets none.
+const DefaultRetryDelay = time.Second
+
+// maxRetryDelay caps the exponential backoff between retries.
+const maxRetryDelay = time.Minute
+
+// RetryableError is a failure that may go away when tried again, like a
+// network error, a server error or a rate limit. Failures that are not
+// wrapped in a RetryableError, like unknown versions or checksum
+// mismatches, are permanent.
+type RetryableError struct {
+	Err error
+	// After is the delay the server asked for before trying again, if any.
+	After time.Duration
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+// Cause returns the underlying error, for errors.Cause.
+func (e *RetryableError) Cause() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err, or any error it wraps, is a
+// RetryableError, and returns it.
+func IsRetryable(err error) (*RetryableError, bool) {
+	for err != nil {
+		if r, ok := err.(*RetryableError); ok {
+			return r, true
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			return nil, false
+		}
+		err = c.Cause()
+	}
+	return nil, false
+}
+
+// backoff returns the delay before retry number attempt, counting from 0:
+// delay doubled for every attempt, capped at maxRetryDelay, of which a
+// random half is jitter, so that concurrent downloads do not retry in
+// lockstep. It is never shorter than after.
+func backoff(delay time.Duration, attempt int, after time.Duration) time.Duration {
+	if delay <= 0 {
+		delay = DefaultRetryDelay
+	}
+	for n := 0; n < attempt && delay < maxRetryDelay; n++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	if delay < after {
+		delay = after
+	}
+	return delay
+}
+
+// transientGitRegex matches the messages of git about network problems
+// and server errors, brought up by rate limits for example.
+var transientGitRegex = regexp.MustCompile(`(?i)could not resolve host|temporary failure in name resolution|connection (timed out|reset|refused)|operation timed out|failed to connect|early eof|the remote end hung up unexpectedly|rpc failed|gnutls_handshake|returned error: (408|429|5\d\d)`)
+
+// retryableGit wraps the failure err of a git command talking to a remote
+// in a RetryableError of a NetworkError if its stderr reports a transient
+// problem.
+func retryableGit(err error, stderr string) error {
+	if err != nil && transientGitRegex.MatchString(stderr) {
+		return &RetryableError{Err: &NetworkError{Err: err}}
+	}
+	return err
+}
+
+// retryableStatus reports whether a response of the given status may
+// succeed when requested again.
+func retryableStatus(code int) bool {
+	switch code {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests,
+		http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses the Retry-After header of resp, when given in seconds.
+func retryAfter(resp *http.Response) time.Duration {
+	s, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || s < 0 {
+		return 0
+	}
+	return time.Duration(s) * time.Second
+}
+
+// rateLimitError returns the failure of the request req to rawurl if it
+// exceeded the rate limit of the server, told by the X-RateLimit-Remaining
+// and X-RateLimit-Reset headers of GitHub, or nil. It is a RetryableError
+// if the limit resets within maxRetryDelay. Unauthenticated requests are
+// pointed at GITHUB_TOKEN, which raises the limit.
+func rateLimitError(rawurl string, req *http.Request, resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return nil
+	}
+
+	msg := fmt.Sprintf("failed to download %s: rate limit exceeded", rawurl)
+	wait := retryAfter(resp)
+	if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		at := time.Unix(reset, 0)
+		msg += " until " + at.Format(time.RFC3339)
+		wait = time.Until(at)
+	}
+	if req.Header.Get("Authorization") == "" {
+		msg += fmt.Sprintf(", set %s for a higher limit", GithubTokenEnv)
+	}
+
+	err := &NetworkError{Err: errors.New(msg)}
+	if wait > maxRetryDelay {
+		return err
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return &RetryableError{Err: err, After: wait}
+}
+
+// transientNetError reports whether err is a network failure that may go
+// away, like a timeout, a refused or reset connection or a truncated
+// response. Errors like untrusted certificates are permanent.
+func transientNetError(err error) bool {
+	err = errors.Cause(err)
+	if u, ok := err.(*url.Error); ok {
+		err = u.Err
+	}
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		return true
+	}
+
+	switch e := err.(type) {
+	case *net.DNSError:
+		return e.IsTimeout || e.IsTemporary
+	case *net.OpError:
+		return true
+	case net.Error:
+		return e.Timeout()
+	}
+	return false
+}
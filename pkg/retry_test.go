@@ -0,0 +1,205 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		d := backoff(time.Second, attempt, 0)
+		assert.True(t, d >= max/2 && d <= max, "attempt %d waited %s", attempt, d)
+	}
+	assert.True(t, backoff(time.Second, 100, 0) <= maxRetryDelay)
+	assert.Equal(t, time.Hour, backoff(time.Second, 0, time.Hour))
+}
+
+func TestRetryableGit(t *testing.T) {
+	err := errors.New("exit status 128")
+	_, ok := IsRetryable(retryableGit(err, "fatal: unable to access 'https://github.com/a/b/': Could not resolve host: github.com"))
+	assert.True(t, ok)
+	_, ok = IsRetryable(retryableGit(err, "error: RPC failed; HTTP 429 curl 22 The requested URL returned error: 429"))
+	assert.True(t, ok)
+	_, ok = IsRetryable(retryableGit(err, "fatal: repository 'https://github.com/a/b/' not found"))
+	assert.False(t, ok)
+	assert.Nil(t, retryableGit(nil, "early EOF"))
+}
+
+func TestDownloadRetryable(t *testing.T) {
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "2")
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	_, err := download(context.TODO(), "", srv.URL, &buf)
+	r, ok := IsRetryable(err)
+	if assert.True(t, ok) {
+		assert.Equal(t, 2*time.Second, r.After)
+	}
+
+	status = http.StatusNotFound
+	_, err = download(context.TODO(), "", srv.URL, &buf)
+	_, ok = IsRetryable(err)
+	assert.False(t, ok)
+
+	// Nothing listens on the address of a closed server.
+	srv.Close()
+	_, err = download(context.TODO(), "", srv.URL, &buf)
+	_, ok = IsRetryable(err)
+	assert.True(t, ok)
+}
+
+func TestDownloadRateLimit(t *testing.T) {
+	reset := time.Now().Add(2 * time.Second)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "0")
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	_, err := download(context.TODO(), "", srv.URL, &buf)
+	r, ok := IsRetryable(err)
+	if assert.True(t, ok) {
+		assert.True(t, r.After <= 2*time.Second, "waits %s", r.After)
+	}
+	assert.Contains(t, err.Error(), "rate limit exceeded")
+	assert.Contains(t, err.Error(), GithubTokenEnv)
+
+	// Limits that reset later fail right away.
+	reset = time.Now().Add(time.Hour)
+	_, err = download(context.TODO(), "", srv.URL, &buf)
+	_, ok = IsRetryable(err)
+	assert.False(t, ok)
+	assert.IsType(t, &NetworkError{}, err)
+}
+
+func TestDownloadResume(t *testing.T) {
+	content := bytes.Repeat([]byte("jsonnet"), 10000)
+	etag, current := `"v1"`, `"v1"`
+	ranges := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("Range") != "" {
+			ranges = append(ranges, r.Header.Get("Range"))
+			w.Header().Set("ETag", current)
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+			return
+		}
+		// The connection breaks halfway.
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content[:len(content)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	sum, err := download(context.TODO(), "", srv.URL, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, content, buf.Bytes())
+	assert.Equal(t, sha256Digest(content), "sha256:"+sum)
+	assert.Equal(t, []string{"bytes=" + strconv.Itoa(len(content)/2) + "-"}, ranges)
+
+	// Content that changed meanwhile has to be downloaded again.
+	buf.Reset()
+	current = `"v2"`
+	_, err = download(context.TODO(), "", srv.URL, &buf)
+	_, ok := IsRetryable(err)
+	assert.True(t, ok)
+}
+
+func TestDownloadMaxRate(t *testing.T) {
+	content := make([]byte, 48<<10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer srv.Close()
+
+	start := time.Now()
+	var buf bytes.Buffer
+	_, err := download(withMaxRate(context.TODO(), 32<<10), "", srv.URL, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, len(content), buf.Len())
+	assert.True(t, time.Since(start) >= time.Second, "downloaded in %s", time.Since(start))
+}
+
+// flakyPackage fails with a retryable error until it was tried failures
+// times.
+type flakyPackage struct {
+	failures int
+	tries    int
+	err      error
+}
+
+func (p *flakyPackage) Install(ctx context.Context, dir, version string) (string, error) {
+	p.tries++
+	if p.tries <= p.failures {
+		return "", p.err
+	}
+	return version, ioutil.WriteFile(filepath.Join(dir, "main.libsonnet"), []byte("{}"), 0644)
+}
+
+func TestInstallerRetries(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	p := &flakyPackage{failures: 2, err: &RetryableError{Err: errors.New("connection reset")}}
+	fetcher := FetcherFunc(func(dep spec.Dependency, from string) (Interface, error) {
+		return p, nil
+	})
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", "https://example.com/foo", "1.0.0")}}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Fetchers: []Fetcher{fetcher}, Retries: 2, RetryDelay: time.Millisecond}
+
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, p.tries)
+
+	// Giving up after the last retry.
+	p.tries = 0
+	p.failures = 3
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.EqualError(t, err, "failed to install package: connection reset")
+	assert.Equal(t, 3, p.tries)
+
+	// Permanent errors are not retried.
+	p.tries = 0
+	p.err = errors.New("unknown version")
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.EqualError(t, err, "failed to install package: unknown version")
+	assert.Equal(t, 1, p.tries)
+}
@@ -0,0 +1,363 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// emptySHA256 is the SHA256 checksum of an empty payload, the one of GET
+// requests.
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// awsCredentials are the credentials signing requests to S3.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expiration is when temporary credentials expire, zero for others.
+	Expiration time.Time
+}
+
+var (
+	// awsCredentialsCache holds the credentials fetched from the container
+	// or instance metadata, which are valid for a while.
+	awsCredentialsCache struct {
+		sync.Mutex
+		creds *awsCredentials
+		// none is set once the metadata had no credentials.
+		none bool
+	}
+	// metadataClient requests the metadata endpoints of containers and
+	// instances, which are local and answer quickly if they exist at all.
+	metadataClient = &http.Client{Timeout: time.Second}
+)
+
+// s3Request returns the signed request of the object key in bucket, from
+// the endpoint of AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL if set, like
+// MinIO, or else from the one of the region of the AWS configuration.
+// Without credentials it is not signed, for public buckets.
+func s3Request(ctx context.Context, bucket, key string) (*http.Request, error) {
+	region := awsRegion()
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_S3")
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+
+	var rawurl string
+	switch {
+	case endpoint != "":
+		rawurl = strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + escapeObjectKey(key)
+	case strings.Contains(bucket, "."):
+		// The wildcard certificate of the virtual host does not cover
+		// buckets with dots.
+		rawurl = fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", region, bucket, escapeObjectKey(key))
+	default:
+		rawurl = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, escapeObjectKey(key))
+	}
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := loadAWSCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if creds != nil {
+		signAWS(req, *creds, region, "s3", time.Now())
+	}
+	return req, nil
+}
+
+// awsRegion returns the region of AWS_REGION, AWS_DEFAULT_REGION or the
+// profile of the AWS config file, us-east-1 if none is set.
+func awsRegion() string {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if r := os.Getenv(env); r != "" {
+			return r
+		}
+	}
+	section := "profile " + awsProfile()
+	if awsProfile() == "default" {
+		section = "default"
+	}
+	if r := awsConfigFile("AWS_CONFIG_FILE", "config")[section]["region"]; r != "" {
+		return r
+	}
+	return "us-east-1"
+}
+
+func awsProfile() string {
+	if p := os.Getenv("AWS_PROFILE"); p != "" {
+		return p
+	}
+	return "default"
+}
+
+// loadAWSCredentials returns the credentials of the first source that has
+// them, in the order of the AWS SDKs: AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY, the profile of the shared credentials file, the
+// container credentials of ECS and the instance profile of EC2, unless
+// AWS_EC2_METADATA_DISABLED is true. It returns nil if there are none.
+func loadAWSCredentials(ctx context.Context) (*awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	p := awsConfigFile("AWS_SHARED_CREDENTIALS_FILE", "credentials")[awsProfile()]
+	if p["aws_access_key_id"] != "" && p["aws_secret_access_key"] != "" {
+		return &awsCredentials{AccessKeyID: p["aws_access_key_id"], SecretAccessKey: p["aws_secret_access_key"], SessionToken: p["aws_session_token"]}, nil
+	}
+
+	awsCredentialsCache.Lock()
+	defer awsCredentialsCache.Unlock()
+	if c := awsCredentialsCache.creds; c != nil && time.Now().Add(time.Minute).Before(c.Expiration) {
+		return c, nil
+	}
+	if awsCredentialsCache.none {
+		return nil, nil
+	}
+
+	var creds *awsCredentials
+	var err error
+	switch {
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "":
+		creds, err = metadataCredentials(ctx, "http://169.254.170.2"+os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"), http.Header{})
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+		header := http.Header{}
+		if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+			header.Set("Authorization", token)
+		}
+		creds, err = metadataCredentials(ctx, os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"), header)
+	case strings.ToLower(os.Getenv("AWS_EC2_METADATA_DISABLED")) != "true":
+		creds, err = instanceCredentials(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	awsCredentialsCache.creds, awsCredentialsCache.none = creds, creds == nil
+	return creds, nil
+}
+
+// instanceCredentials returns the credentials of the instance profile from
+// the metadata service of EC2, IMDSv2, at AWS_EC2_METADATA_SERVICE_ENDPOINT
+// if set. It returns nil outside of EC2 or without an instance profile.
+func instanceCredentials(ctx context.Context) (*awsCredentials, error) {
+	endpoint := strings.TrimSuffix(os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "/")
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+
+	req, err := http.NewRequest(http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	resp, err := metadataClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	header := http.Header{}
+	header.Set("X-aws-ec2-metadata-token", string(token))
+
+	role, err := metadataGet(ctx, endpoint+"/latest/meta-data/iam/security-credentials/", header)
+	if err != nil || role == nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	return metadataCredentials(ctx, endpoint+"/latest/meta-data/iam/security-credentials/"+name, header)
+}
+
+// metadataCredentials returns the credentials served as JSON at rawurl by
+// the metadata of containers and instances, nil if there is nothing there.
+func metadataCredentials(ctx context.Context, rawurl string, header http.Header) (*awsCredentials, error) {
+	body, err := metadataGet(ctx, rawurl, header)
+	if err != nil || body == nil {
+		return nil, err
+	}
+	var c struct {
+		AccessKeyID     string `json:"AccessKeyId"`
+		SecretAccessKey string
+		Token           string
+		Expiration      time.Time
+	}
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, errors.Wrap(err, "invalid AWS credentials from the metadata service")
+	}
+	return &awsCredentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.Token, Expiration: c.Expiration}, nil
+}
+
+// metadataGet returns the body at rawurl of a metadata service, nil if it
+// does not answer or has nothing there.
+func metadataGet(ctx context.Context, rawurl string, header http.Header) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = header
+	resp, err := metadataClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// awsConfigFile parses the AWS config file named by env, or else the one
+// in ~/.aws with the name file, into its sections by name. Missing and
+// unreadable files have no sections.
+func awsConfigFile(env, file string) map[string]map[string]string {
+	name := os.Getenv(env)
+	if name == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		name = filepath.Join(home, ".aws", file)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	sections := map[string]map[string]string{}
+	var section map[string]string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			section = map[string]string{}
+			sections[strings.TrimSpace(line[1:len(line)-1])] = section
+		case section != nil && strings.Contains(line, "="):
+			kv := strings.SplitN(line, "=", 2)
+			section[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return sections
+}
+
+// signAWS signs req for service in region with Signature Version 4, at
+// time now. The host and all headers of req are signed, the payload is
+// empty.
+func signAWS(req *http.Request, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptySHA256)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, k := range names {
+		canonicalHeaders += k + ":" + strings.TrimSpace(headers[k]) + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, path, awsCanonicalQuery(req), canonicalHeaders, signedHeaders, emptySHA256}, "\n")
+	scope := strings.Join([]string{date, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hexSHA256(canonicalRequest)}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// awsCanonicalQuery returns the query of req sorted and escaped for
+// signing.
+func awsCanonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		values := append([]string{}, query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsEscape percent-encodes everything in s but unreserved characters.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// unsafeFile is a file of a package that is never vendored, see
+// unsafeFiles.
+type unsafeFile struct {
+	// Name is the slash-separated path of the file, relative to the
+	// package.
+	Name string
+	// Reason tells why it is not vendored.
+	Reason string
+}
+
+// unsafeFiles returns the files below root that are never vendored,
+// whatever the upstream tree contains: symlinks that are absolute or lead
+// out of root, by their target or through other symlinks, and files that
+// are neither regular files, directories nor symlinks, like devices, named
+// pipes and sockets, which reading could block or leak the host.
+func unsafeFiles(root string) ([]unsafeFile, error) {
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+
+	unsafe := []unsafeFile{}
+	err = filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, file)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		switch mode := info.Mode(); {
+		case mode.IsDir(), mode.IsRegular():
+		case mode&os.ModeSymlink != 0:
+			target, err := os.Readlink(file)
+			if err != nil {
+				return err
+			}
+			if reason := unsafeSymlink(root, resolvedRoot, file, target); reason != "" {
+				unsafe = append(unsafe, unsafeFile{Name: name, Reason: reason})
+			}
+		default:
+			unsafe = append(unsafe, unsafeFile{Name: name, Reason: fmt.Sprintf("it is a special file (%s)", mode.Type())})
+		}
+		return nil
+	})
+	return unsafe, err
+}
+
+// unsafeSymlink tells why the symlink file below root, which resolves to
+// resolvedRoot, pointing to target is unsafe, empty if it is not.
+// Targets are checked as written, and as resolved if they exist, since
+// symlinks to parents like .. make the result of a later .. escape.
+func unsafeSymlink(root, resolvedRoot, file, target string) string {
+	if filepath.IsAbs(target) || filepath.VolumeName(target) != "" || strings.HasPrefix(filepath.ToSlash(target), "/") {
+		return fmt.Sprintf("it is a symlink to the absolute path %s", target)
+	}
+	if !within(root, filepath.Join(filepath.Dir(file), target)) {
+		return fmt.Sprintf("it is a symlink to %s, outside of the package", target)
+	}
+	if resolved, err := filepath.EvalSymlinks(file); err == nil && !within(resolvedRoot, resolved) {
+		return fmt.Sprintf("it is a symlink to %s, which resolves outside of the package", target)
+	}
+	return ""
+}
+
+// within reports whether path is dir or below it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// removeUnsafeFiles removes the files of unsafe below root.
+func removeUnsafeFiles(root string, unsafe []unsafeFile) error {
+	for _, u := range unsafe {
+		if err := os.RemoveAll(filepath.Join(root, filepath.FromSlash(u.Name))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSubdirPath fails if the subdir of the package at root is not a
+// directory within it, reached through symlinks, which would vendor
+// whatever they point to.
+func checkSubdirPath(root, subdir string) error {
+	if subdir == "" {
+		return nil
+	}
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(subdir)))
+	if err != nil {
+		return err
+	}
+	if resolved != filepath.Join(resolvedRoot, filepath.FromSlash(subdir)) {
+		return fmt.Errorf("subdir %s is a symlink or below one", subdir)
+	}
+	return nil
+}
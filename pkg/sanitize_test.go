@@ -0,0 +1,108 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsafeFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges")
+	}
+	root, err := ioutil.TempDir("", "jb-unsafe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "src"), os.ModePerm))
+	assert.NoError(t, os.MkdirAll(filepath.Join(root, "a"), os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "src", "main.libsonnet"), []byte("{}"), 0644))
+	for name, target := range map[string]string{
+		"lib":      "src",
+		"main":     "src/main.libsonnet",
+		"dangling": "missing",
+		"passwd":   "/etc/passwd",
+		"escape":   "../outside",
+		"a/sub":    "..",
+		"a/x":      "sub/..",
+	} {
+		assert.NoError(t, os.Symlink(target, filepath.Join(root, filepath.FromSlash(name))))
+	}
+	if _, err := exec.LookPath("mkfifo"); err == nil {
+		assert.NoError(t, exec.Command("mkfifo", filepath.Join(root, "fifo")).Run())
+	}
+
+	unsafe, err := unsafeFiles(root)
+	assert.NoError(t, err)
+	expected := []unsafeFile{
+		{Name: "a/x", Reason: "it is a symlink to sub/.., which resolves outside of the package"},
+		{Name: "escape", Reason: "it is a symlink to ../outside, outside of the package"},
+	}
+	if _, err := exec.LookPath("mkfifo"); err == nil {
+		expected = append(expected, unsafeFile{Name: "fifo", Reason: "it is a special file (p---------)"})
+	}
+	expected = append(expected, unsafeFile{Name: "passwd", Reason: "it is a symlink to the absolute path /etc/passwd"})
+	assert.Equal(t, expected, unsafe)
+
+	assert.NoError(t, removeUnsafeFiles(root, unsafe))
+	unsafe, err = unsafeFiles(root)
+	assert.NoError(t, err)
+	assert.Empty(t, unsafe)
+	_, err = os.Lstat(filepath.Join(root, "lib"))
+	assert.NoError(t, err)
+
+	// A subdir that is a symlink would vendor what it points to.
+	assert.NoError(t, checkSubdirPath(root, "src"))
+	assert.EqualError(t, checkSubdirPath(root, "lib"), "subdir lib is a symlink or below one")
+}
+
+func TestInstallerUnsafeFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges")
+	}
+	repo := newTestRepo(t)
+	defer repo.Close()
+	assert.NoError(t, os.Symlink("/etc/passwd", filepath.Join(repo.Dir, "passwd")))
+	assert.NoError(t, os.Symlink("/etc", filepath.Join(repo.Dir, "etc")))
+	repo.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, "master")}}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	for _, name := range []string{"passwd", "etc"} {
+		_, err := os.Lstat(filepath.Join(i.JsonnetHome, "foo", name))
+		assert.True(t, os.IsNotExist(err), name)
+	}
+	_, err = os.Stat(filepath.Join(i.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,349 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package sbom reports the packages vendored according to a lock file, with
+// their provenance and licenses, as SPDX or CycloneDX documents.
+package sbom
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// Format is the format of a report.
+type Format string
+
+const (
+	CycloneDX Format = "cyclonedx"
+	SPDX      Format = "spdx"
+)
+
+// Formats are all supported formats.
+var Formats = []Format{CycloneDX, SPDX}
+
+// noAssertion stands for unknown values in SPDX documents.
+const noAssertion = "NOASSERTION"
+
+var githubRemoteRegex = regexp.MustCompile(`^(?:https://github\.com/|git@github\.com:)([-_.a-zA-Z0-9]+)/([-_.a-zA-Z0-9]+?)(?:\.git)?/?$`)
+
+// Package is a vendored package and where it came from.
+type Package struct {
+	Name string
+	// Source identifies the source, see pkg.SourceString.
+	Source string
+	// Version is the locked version, the commit of git packages.
+	Version string
+	// Tag is the tag the version was resolved from, if any.
+	Tag string
+	// Sum is the digest of the vendored tree.
+	Sum string
+	// License is the SPDX identifier of the license, empty if unknown.
+	License string
+
+	dep spec.Dependency
+}
+
+// Packages returns the packages of lock. Licenses missing from the lock,
+// for example because it was written by an older version, are detected
+// from the packages vendored in jsonnetHome.
+func Packages(jsonnetHome string, lock spec.JsonnetFile) []Package {
+	packages := make([]Package, 0, len(lock.Dependencies))
+	for _, d := range lock.Dependencies {
+		p := Package{
+			Name:    d.Name,
+			Source:  pkg.SourceString(d.Source),
+			Version: d.Version,
+			Tag:     d.Tag,
+			Sum:     d.Sum,
+			License: d.License,
+			dep:     d,
+		}
+		if p.License == "" {
+			p.License = pkg.DetectLicense(pkg.VendorPath(jsonnetHome, d))
+		}
+		packages = append(packages, p)
+	}
+	return packages
+}
+
+// downloadLocation returns where the package was downloaded from, in the
+// syntax of SPDX: VCS locations like git+https://host/repo@commit#subdir
+// and hg+https://host/repo@changeset#subdir, OCI artifacts by digest, or the
+// URL of archives.
+func (p Package) downloadLocation() string {
+	s := p.dep.Source
+	switch {
+	case s.GitSource != nil:
+		remote := s.GitSource.Remote
+		if !strings.Contains(remote, "://") {
+			remote = "ssh://" + strings.Replace(remote, ":", "/", 1)
+		}
+		loc := "git+" + remote + "@" + p.Version
+		if s.GitSource.Subdir != "" {
+			loc += "#" + strings.Trim(s.GitSource.Subdir, "/")
+		}
+		return loc
+	case s.HgSource != nil:
+		loc := "hg+" + s.HgSource.Remote + "@" + p.Version
+		if s.HgSource.Subdir != "" {
+			loc += "#" + strings.Trim(s.HgSource.Subdir, "/")
+		}
+		return loc
+	case s.OCISource != nil:
+		return "oci://" + s.OCISource.Repository + "@" + p.Version
+	case s.ArchiveSource != nil:
+		return s.ArchiveSource.URL
+	case s.ReleaseAssetSource != nil:
+		return s.ReleaseAssetSource.URL
+	}
+	return noAssertion
+}
+
+// purl returns the package URL of GitHub packages, empty for others.
+func (p Package) purl() string {
+	if p.dep.Source.GitSource == nil {
+		return ""
+	}
+	m := githubRemoteRegex.FindStringSubmatch(p.dep.Source.GitSource.Remote)
+	if m == nil {
+		return ""
+	}
+	purl := fmt.Sprintf("pkg:github/%s/%s@%s", strings.ToLower(m[1]), strings.ToLower(m[2]), p.Version)
+	if subdir := strings.Trim(p.dep.Source.GitSource.Subdir, "/"); subdir != "" {
+		purl += "#" + subdir
+	}
+	return purl
+}
+
+// Write encodes packages as a document of format describing the project
+// name, created at the given time.
+func Write(w io.Writer, format Format, name string, packages []Package, created time.Time) error {
+	var doc interface{}
+	switch format {
+	case CycloneDX:
+		doc = cycloneDX(name, packages, created)
+	case SPDX:
+		doc = spdx(name, packages, created)
+	default:
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	b, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdx returns an SPDX 2.3 document of packages.
+func spdx(name string, packages []Package, created time.Time) spdxDocument {
+	// The namespace must be unique for every version of the document.
+	h := sha256.New()
+	for _, p := range packages {
+		fmt.Fprintf(h, "%s %s %s\n", p.Name, p.Source, p.Version)
+	}
+
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: fmt.Sprintf("https://spdx.org/spdxdocs/%s-%s", spdxSafe(name), hex.EncodeToString(h.Sum(nil))[:16]),
+		CreationInfo: spdxCreationInfo{
+			Created:  created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: jsonnet-bundler"},
+		},
+		Packages:      []spdxPackage{},
+		Relationships: []spdxRelationship{},
+	}
+
+	for _, p := range packages {
+		id := "SPDXRef-Package-" + spdxSafe(p.Name)
+		license := p.License
+		if license == "" {
+			license = noAssertion
+		}
+		version := p.Version
+		if p.Tag != "" {
+			version = p.Tag
+		}
+
+		sp := spdxPackage{
+			Name:             p.Name,
+			SPDXID:           id,
+			VersionInfo:      version,
+			DownloadLocation: p.downloadLocation(),
+			LicenseConcluded: noAssertion,
+			LicenseDeclared:  license,
+			CopyrightText:    noAssertion,
+		}
+		if purl := p.purl(); purl != "" {
+			sp.ExternalRefs = []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: purl}}
+		}
+		doc.Packages = append(doc.Packages, sp)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{
+			SPDXElementID:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: id,
+		})
+	}
+
+	return doc
+}
+
+var spdxUnsafeRegex = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// spdxSafe replaces the characters SPDX identifiers may not contain.
+func spdxSafe(s string) string {
+	return spdxUnsafeRegex.ReplaceAllString(s, "-")
+}
+
+type cdxDocument struct {
+	BOMFormat   string         `json:"bomFormat"`
+	SpecVersion string         `json:"specVersion"`
+	Version     int            `json:"version"`
+	Metadata    cdxMetadata    `json:"metadata"`
+	Components  []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     []cdxTool    `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTool struct {
+	Name string `json:"name"`
+}
+
+type cdxComponent struct {
+	Type               string           `json:"type"`
+	BOMRef             string           `json:"bom-ref,omitempty"`
+	Name               string           `json:"name"`
+	Version            string           `json:"version,omitempty"`
+	PURL               string           `json:"purl,omitempty"`
+	Licenses           []cdxLicense     `json:"licenses,omitempty"`
+	Hashes             []cdxHash        `json:"hashes,omitempty"`
+	ExternalReferences []cdxExternalRef `json:"externalReferences,omitempty"`
+}
+
+type cdxLicense struct {
+	License cdxLicenseID `json:"license"`
+}
+
+type cdxLicenseID struct {
+	ID string `json:"id"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxExternalRef struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// cycloneDX returns a CycloneDX 1.4 document of packages.
+func cycloneDX(name string, packages []Package, created time.Time) cdxDocument {
+	doc := cdxDocument{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: cdxMetadata{
+			Timestamp: created.UTC().Format(time.RFC3339),
+			Tools:     []cdxTool{{Name: "jsonnet-bundler"}},
+			Component: cdxComponent{Type: "application", Name: name},
+		},
+		Components: []cdxComponent{},
+	}
+
+	for _, p := range packages {
+		c := cdxComponent{
+			Type:    "library",
+			BOMRef:  p.Name,
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    p.purl(),
+		}
+		if p.License != "" {
+			c.Licenses = []cdxLicense{{License: cdxLicenseID{ID: p.License}}}
+		}
+		if sum, err := base64.StdEncoding.DecodeString(p.Sum); err == nil && len(sum) == sha256.Size {
+			c.Hashes = []cdxHash{{Alg: "SHA-256", Content: hex.EncodeToString(sum)}}
+		}
+		if loc := p.downloadLocation(); loc != noAssertion {
+			typ := "distribution"
+			if p.dep.Source.GitSource != nil {
+				typ, loc = "vcs", p.dep.Source.GitSource.Remote
+			}
+			c.ExternalReferences = []cdxExternalRef{{Type: typ, URL: loc}}
+		}
+		doc.Components = append(doc.Components, c)
+	}
+
+	return doc
+}
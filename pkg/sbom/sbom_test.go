@@ -0,0 +1,87 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sbom
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLock = spec.JsonnetFile{Dependencies: []spec.Dependency{
+	{
+		Name: "grafonnet",
+		Source: spec.Source{GitSource: &spec.GitSource{
+			Remote: "https://github.com/grafana/grafonnet-lib",
+			Subdir: "grafonnet",
+		}},
+		Version: "3626fc4dc2326931c530861ac5bebe39444f6cbf",
+		Tag:     "v0.1.0",
+		Sum:     "gvwnRYvuUr0MzZsXgTNDBaXRErZPbxxJ6IwV3Dbu/oM=",
+		License: "Apache-2.0",
+	},
+	{
+		Name:    "lib",
+		Source:  spec.Source{ArchiveSource: &spec.ArchiveSource{URL: "https://example.com/lib.tar.gz"}},
+		Version: "abc",
+	},
+}}
+
+func TestPackages(t *testing.T) {
+	packages := Packages("vendor", testLock)
+	assert.Len(t, packages, 2)
+	assert.Equal(t, "https://github.com/grafana/grafonnet-lib/grafonnet", packages[0].Source)
+	assert.Equal(t, "Apache-2.0", packages[0].License)
+	assert.Equal(t, "pkg:github/grafana/grafonnet-lib@3626fc4dc2326931c530861ac5bebe39444f6cbf#grafonnet", packages[0].purl())
+	assert.Equal(t, "git+https://github.com/grafana/grafonnet-lib@3626fc4dc2326931c530861ac5bebe39444f6cbf#grafonnet", packages[0].downloadLocation())
+	assert.Equal(t, "", packages[1].License)
+	assert.Equal(t, "", packages[1].purl())
+	assert.Equal(t, "https://example.com/lib.tar.gz", packages[1].downloadLocation())
+}
+
+func TestWrite(t *testing.T) {
+	packages := Packages("vendor", testLock)
+	created := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var buf bytes.Buffer
+	assert.NoError(t, Write(&buf, SPDX, "project", packages, created))
+	var doc spdxDocument
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, "SPDX-2.3", doc.SPDXVersion)
+	assert.Equal(t, "2020-01-02T03:04:05Z", doc.CreationInfo.Created)
+	assert.Len(t, doc.Packages, 2)
+	assert.Equal(t, "v0.1.0", doc.Packages[0].VersionInfo)
+	assert.Equal(t, "Apache-2.0", doc.Packages[0].LicenseDeclared)
+	assert.Equal(t, noAssertion, doc.Packages[1].LicenseDeclared)
+	assert.Len(t, doc.Relationships, 2)
+
+	buf.Reset()
+	assert.NoError(t, Write(&buf, CycloneDX, "project", packages, created))
+	var bom cdxDocument
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &bom))
+	assert.Equal(t, "CycloneDX", bom.BOMFormat)
+	assert.Equal(t, "project", bom.Metadata.Component.Name)
+	assert.Len(t, bom.Components, 2)
+	assert.Equal(t, []cdxLicense{{License: cdxLicenseID{ID: "Apache-2.0"}}}, bom.Components[0].Licenses)
+	assert.Equal(t, []cdxHash{{Alg: "SHA-256", Content: "82fc27458bee52bd0ccd9b1781334305a5d112b64f6f1c49e88c15dc36eefe83"}}, bom.Components[0].Hashes)
+	assert.Equal(t, []cdxExternalRef{{Type: "vcs", URL: "https://github.com/grafana/grafonnet-lib"}}, bom.Components[0].ExternalReferences)
+	assert.Nil(t, bom.Components[1].Licenses)
+
+	assert.Error(t, Write(&buf, Format("yaml"), "project", packages, created))
+}
@@ -0,0 +1,182 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package semver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint is a version range like ~1.2, ^2.0.0 or >=1.3 <2.0.
+// Comparators separated by spaces must all match, alternatives are
+// separated by ||.
+type Constraint struct {
+	alternatives [][]comparator
+	original     string
+}
+
+type comparator struct {
+	op      string
+	version Version
+}
+
+// Latest is the constraint satisfied by every release, so that the newest
+// one is installed, but no pre-release and no branch.
+const Latest = "latest"
+
+// IsConstraint reports whether s is meant as a range rather than as the name
+// of a branch or tag. Only ranges using an operator qualify, and Latest, so
+// that a tag named 1.2.3 keeps referring to that tag.
+func IsConstraint(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == Latest || strings.ContainsAny(s, " |") || strings.IndexAny(s, "~^<>=") == 0
+}
+
+// ParseConstraint parses a range made of the operators =, <, <=, >, >=,
+// ~ (patch releases: ~1.2 is >=1.2.0 <1.3.0) and ^ (compatible releases:
+// ^1.2 is >=1.2.0 <2.0.0, ^0.2 is >=0.2.0 <0.3.0), and Latest (any release:
+// >=0.0.0).
+func ParseConstraint(s string) (Constraint, error) {
+	c := Constraint{original: s}
+	for _, alt := range strings.Split(s, "||") {
+		fields := strings.Fields(alt)
+		if len(fields) == 0 {
+			return Constraint{}, fmt.Errorf("invalid version constraint %q: empty range", s)
+		}
+
+		comparators := []comparator{}
+		for _, f := range fields {
+			cs, err := parseComparator(f)
+			if err != nil {
+				return Constraint{}, fmt.Errorf("invalid version constraint %q: %v", s, err)
+			}
+			comparators = append(comparators, cs...)
+		}
+		c.alternatives = append(c.alternatives, comparators)
+	}
+
+	return c, nil
+}
+
+func parseComparator(s string) ([]comparator, error) {
+	if s == Latest {
+		return []comparator{{">=", Version{}}}, nil
+	}
+
+	op := ""
+	for _, o := range []string{">=", "<=", ">", "<", "=", "~", "^"} {
+		if strings.HasPrefix(s, o) {
+			op = o
+			break
+		}
+	}
+	raw := strings.TrimPrefix(s, op)
+
+	v, err := Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	// The number of components given decides the width of ~ and ^ ranges.
+	components := strings.Count(strings.SplitN(strings.SplitN(strings.TrimPrefix(raw, "v"), "-", 2)[0], "+", 2)[0], ".") + 1
+
+	switch op {
+	case "~":
+		upper := Version{Major: v.Major + 1}
+		if components > 1 {
+			upper = Version{Major: v.Major, Minor: v.Minor + 1}
+		}
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	case "^":
+		var upper Version
+		switch {
+		case v.Major > 0 || components == 1:
+			upper = Version{Major: v.Major + 1}
+		case v.Minor > 0 || components == 2:
+			upper = Version{Minor: v.Minor + 1}
+		default:
+			upper = Version{Patch: v.Patch + 1}
+		}
+		return []comparator{{">=", v}, {"<", upper}}, nil
+	case "":
+		op = "="
+	}
+
+	return []comparator{{op, v}}, nil
+}
+
+// Check reports whether v satisfies the constraint. Pre-releases only
+// satisfy ranges that mention a pre-release of the same version, so ^1.0.0
+// never picks 1.1.0-rc.1.
+func (c Constraint) Check(v Version) bool {
+	for _, alt := range c.alternatives {
+		if matchAll(alt, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchAll(comparators []comparator, v Version) bool {
+	preAllowed := v.Pre == ""
+	for _, c := range comparators {
+		cmp := Compare(v, c.version)
+		ok := false
+		switch c.op {
+		case "=":
+			ok = cmp == 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		}
+		if !ok {
+			return false
+		}
+
+		if c.version.Pre != "" && c.version.Major == v.Major && c.version.Minor == v.Minor && c.version.Patch == v.Patch {
+			preAllowed = true
+		}
+	}
+	return preAllowed
+}
+
+// Best returns the highest of versions satisfying the constraint, or false
+// if none does. Strings which are not semantic versions are skipped.
+func (c Constraint) Best(versions []string) (string, bool) {
+	var best *Version
+	for _, s := range versions {
+		v, err := Parse(s)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		if best == nil || Compare(v, *best) > 0 {
+			v := v
+			best = &v
+		}
+	}
+
+	if best == nil {
+		return "", false
+	}
+	return best.Original, true
+}
+
+func (c Constraint) String() string {
+	return c.original
+}
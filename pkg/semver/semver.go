@@ -0,0 +1,162 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package semver parses and compares semantic versions as commonly used for
+// git tags, e.g. v1.2.3 or 1.2.0-rc.1.
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a parsed semantic version. Build metadata is ignored.
+type Version struct {
+	Major, Minor, Patch int
+	Pre                 string
+
+	// Original is the string the version was parsed from.
+	Original string
+}
+
+// Parse parses s, with an optional "v" prefix. Missing minor and patch
+// components default to zero, so v2 and v2.0 are both 2.0.0.
+func Parse(s string) (Version, error) {
+	v := Version{Original: s}
+
+	rest := strings.TrimPrefix(s, "v")
+	if i := strings.Index(rest, "+"); i >= 0 {
+		rest = rest[:i]
+	}
+	if i := strings.Index(rest, "-"); i >= 0 {
+		v.Pre = rest[i+1:]
+		rest = rest[:i]
+		if v.Pre == "" {
+			return Version{}, fmt.Errorf("invalid semantic version %q: empty pre-release", s)
+		}
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid semantic version %q: too many components", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return Version{}, fmt.Errorf("invalid semantic version %q", s)
+		}
+		*nums[i] = n
+	}
+
+	return v, nil
+}
+
+// Compare returns -1, 0 or 1 if a is lower than, equal to or greater than
+// b. Pre-releases are lower than the release they precede.
+func Compare(a, b Version) int {
+	for _, c := range [][2]int{{a.Major, b.Major}, {a.Minor, b.Minor}, {a.Patch, b.Patch}} {
+		if c[0] != c[1] {
+			if c[0] < c[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case a.Pre == b.Pre:
+		return 0
+	case a.Pre == "":
+		return 1
+	case b.Pre == "":
+		return -1
+	}
+
+	return comparePre(a.Pre, b.Pre)
+}
+
+func comparePre(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		case as[i] < bs[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// Bump returns the version following v when incrementing part, one of
+// major, minor or patch, resetting the lower components. A pre-release
+// whose lower components are zero already precedes the bumped version,
+// which releases it: bumping the minor version of 1.3.0-rc.1 gives 1.3.0.
+func (v Version) Bump(part string) (Version, error) {
+	pre := v.Pre != ""
+	switch part {
+	case "major":
+		if !pre || v.Minor != 0 || v.Patch != 0 {
+			v.Major++
+		}
+		v.Minor, v.Patch = 0, 0
+	case "minor":
+		if !pre || v.Patch != 0 {
+			v.Minor++
+		}
+		v.Patch = 0
+	case "patch":
+		if !pre {
+			v.Patch++
+		}
+	default:
+		return Version{}, fmt.Errorf("invalid version part %q, expected major, minor or patch", part)
+	}
+
+	v.Pre = ""
+	v.Original = ""
+	return v, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Pre != "" {
+		s += "-" + v.Pre
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package semver_test
+
+import (
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	v, err := semver.Parse("v1.2.3-rc.1+build")
+	assert.NoError(t, err)
+	assert.Equal(t, semver.Version{Major: 1, Minor: 2, Patch: 3, Pre: "rc.1", Original: "v1.2.3-rc.1+build"}, v)
+
+	v, err = semver.Parse("2")
+	assert.NoError(t, err)
+	assert.Equal(t, "2.0.0", v.String())
+
+	for _, invalid := range []string{"master", "v1.2.3.4", "1.x", "v1.0-", ""} {
+		_, err := semver.Parse(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	ordered := []string{"0.9.0", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0", "v1.0.1", "1.2", "v2"}
+
+	for i := range ordered {
+		for j := range ordered {
+			a, err := semver.Parse(ordered[i])
+			assert.NoError(t, err)
+			b, err := semver.Parse(ordered[j])
+			assert.NoError(t, err)
+
+			expected := 0
+			if i < j {
+				expected = -1
+			} else if i > j {
+				expected = 1
+			}
+			assert.Equal(t, expected, semver.Compare(a, b), "%s <=> %s", ordered[i], ordered[j])
+		}
+	}
+}
+
+func TestBump(t *testing.T) {
+	for _, tc := range []struct{ version, part, expected string }{
+		{"v1.2.3", "patch", "1.2.4"},
+		{"v1.2.3", "minor", "1.3.0"},
+		{"v1.2.3", "major", "2.0.0"},
+		{"1.2.3-rc.1", "patch", "1.2.3"},
+		{"1.3.0-rc.1", "minor", "1.3.0"},
+		{"1.3.1-rc.1", "minor", "1.4.0"},
+		{"2.0.0-rc.1", "major", "2.0.0"},
+		{"2.1.0-rc.1", "major", "3.0.0"},
+	} {
+		v, err := semver.Parse(tc.version)
+		assert.NoError(t, err)
+		bumped, err := v.Bump(tc.part)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expected, bumped.String(), "%s %s", tc.version, tc.part)
+	}
+
+	_, err := semver.Version{}.Bump("build")
+	assert.EqualError(t, err, `invalid version part "build", expected major, minor or patch`)
+}
+
+func TestConstraint(t *testing.T) {
+	testcases := []struct {
+		Constraint string
+		Match      []string
+		NoMatch    []string
+	}{
+		{Constraint: "~1.2", Match: []string{"1.2.0", "v1.2.9"}, NoMatch: []string{"1.1.9", "1.3.0"}},
+		{Constraint: "~1.2.3", Match: []string{"1.2.3", "1.2.4"}, NoMatch: []string{"1.2.2", "1.3.0"}},
+		{Constraint: "~1", Match: []string{"1.0.0", "1.9.0"}, NoMatch: []string{"2.0.0"}},
+		{Constraint: "^2.0.0", Match: []string{"2.0.0", "2.5.1"}, NoMatch: []string{"1.9.9", "3.0.0", "2.1.0-rc.1"}},
+		{Constraint: "^0.2.3", Match: []string{"0.2.3", "0.2.9"}, NoMatch: []string{"0.3.0"}},
+		{Constraint: "^0.0.3", Match: []string{"0.0.3"}, NoMatch: []string{"0.0.4"}},
+		{Constraint: ">=1.3 <2.0", Match: []string{"1.3.0", "1.99.0"}, NoMatch: []string{"1.2.9", "2.0.0"}},
+		{Constraint: "<1.0 || >=2.0", Match: []string{"0.9.0", "2.0.0"}, NoMatch: []string{"1.5.0"}},
+		{Constraint: ">=1.0.0-rc.1", Match: []string{"1.0.0-rc.2", "1.0.0"}, NoMatch: []string{"1.1.0-rc.1"}},
+		{Constraint: "=1.2.3", Match: []string{"v1.2.3"}, NoMatch: []string{"1.2.4"}},
+		{Constraint: "latest", Match: []string{"0.0.1", "v12.3.4"}, NoMatch: []string{"2.0.0-rc.1"}},
+		{Constraint: "latest <2", Match: []string{"1.9.0"}, NoMatch: []string{"2.0.0"}},
+	}
+
+	for _, tc := range testcases {
+		c, err := semver.ParseConstraint(tc.Constraint)
+		assert.NoError(t, err, tc.Constraint)
+		for _, s := range tc.Match {
+			v, err := semver.Parse(s)
+			assert.NoError(t, err)
+			assert.True(t, c.Check(v), "%s should satisfy %s", s, tc.Constraint)
+		}
+		for _, s := range tc.NoMatch {
+			v, err := semver.Parse(s)
+			assert.NoError(t, err)
+			assert.False(t, c.Check(v), "%s should not satisfy %s", s, tc.Constraint)
+		}
+	}
+
+	for _, invalid := range []string{">=", "^master", "1.0 ||"} {
+		_, err := semver.ParseConstraint(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestConstraintBest(t *testing.T) {
+	c, err := semver.ParseConstraint("^1.2")
+	assert.NoError(t, err)
+
+	best, ok := c.Best([]string{"v1.2.0", "latest", "v1.10.1", "v1.3.0", "v2.0.0"})
+	assert.True(t, ok)
+	assert.Equal(t, "v1.10.1", best)
+
+	_, ok = c.Best([]string{"v0.1.0", "v2.0.0"})
+	assert.False(t, ok)
+}
+
+func TestIsConstraint(t *testing.T) {
+	for _, s := range []string{"~1.2", "^2.0.0", ">=1.3 <2.0", "=1.0.0", "1.0 || 2.0", "latest"} {
+		assert.True(t, semver.IsConstraint(s), s)
+	}
+	for _, s := range []string{"v1.2.3", "1.2.3", "master", "refs/tags/v1.0.0", "latest-release", ""} {
+		assert.False(t, semver.IsConstraint(s), s)
+	}
+}
@@ -0,0 +1,316 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package server is the local HTTP API of jb serve, for editor plugins and
+// language servers offering to add dependencies and completing imports
+// without running jb and parsing its files for every request.
+//
+// All responses are JSON. Failures respond with an Error and a 4xx or 5xx
+// status.
+//
+// Only local clients are served, never web pages: requests must be for a
+// loopback Host, carry no Origin and, if the server has a Token, authorize
+// with it as a bearer token. Installs must be sent as application/json.
+//
+//	GET  /v1/resolve?ref=REF  the Package a package reference stands for
+//	GET  /v1/packages         the Packages of the lock file
+//	POST /v1/install          installs a Request, responding with the
+//	                          Packages of the lock file
+//	GET  /v1/watch            a stream of server-sent events, a Change for
+//	                          every change of the jsonnetfile or the lock
+//	                          file
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// Package is a dependency, as resolved from a package reference or as
+// locked.
+type Package struct {
+	Name    string `json:"name"`
+	Source  string `json:"source"`
+	Version string `json:"version"`
+	Tag     string `json:"tag,omitempty"`
+	// Path is the directory the package is vendored to, relative to the
+	// project unless the vendor directory is absolute. It is empty for
+	// packages that were only resolved.
+	Path string `json:"path,omitempty"`
+}
+
+// Request is the body of an install. Packages are package references added
+// to the jsonnetfile, like for jb install REF. The lock file is installed if
+// there are none.
+type Request struct {
+	Packages []string `json:"packages,omitempty"`
+}
+
+// Change is the event sent by watch when File, the jsonnetfile or the lock
+// file, was written or removed.
+type Change struct {
+	File string `json:"file"`
+}
+
+// Error is the body of failed requests.
+type Error struct {
+	Error string `json:"error"`
+}
+
+// Server serves the API for the project of Options.Dir. Installs are run
+// one at a time.
+type Server struct {
+	// Options configure installs, see client.Install.
+	Options client.Options
+	// DefaultVersion is the version of git packages referenced without one,
+	// see client.ResolveOptions.
+	DefaultVersion string
+	// Interval is how often watch looks for changes, every second if 0.
+	Interval time.Duration
+	// Token, if set, is required from clients as a bearer token, in an
+	// "Authorization: Bearer TOKEN" header.
+	Token string
+
+	mu   sync.Mutex
+	once sync.Once
+	mux  *http.ServeMux
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.once.Do(func() {
+		s.mux = http.NewServeMux()
+		s.mux.HandleFunc("/v1/resolve", s.only(http.MethodGet, s.resolve))
+		s.mux.HandleFunc("/v1/packages", s.only(http.MethodGet, s.packages))
+		s.mux.HandleFunc("/v1/install", s.only(http.MethodPost, s.install))
+		s.mux.HandleFunc("/v1/watch", s.only(http.MethodGet, s.watch))
+	})
+	if status, err := s.authorize(r); err != nil {
+		respondError(w, status, err)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// authorize returns an error and the status to respond with for requests
+// that are not from a local client: those for another host, which a page
+// rebinding its DNS name to a loopback address would send, those of web
+// pages, which always carry an Origin, and those without the Token.
+func (s *Server) authorize(r *http.Request) (int, error) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	if !strings.EqualFold(host, "localhost") && (ip == nil || !ip.IsLoopback()) {
+		return http.StatusForbidden, fmt.Errorf("host %s is not local", r.Host)
+	}
+	if r.Header.Get("Origin") != "" {
+		return http.StatusForbidden, fmt.Errorf("requests of web pages are not allowed")
+	}
+	if s.Token == "" {
+		return 0, nil
+	}
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == auth || subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+		return http.StatusUnauthorized, fmt.Errorf("missing or wrong bearer token")
+	}
+	return 0, nil
+}
+
+// only restricts h to requests of method.
+func (s *Server) only(method string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			respondError(w, http.StatusMethodNotAllowed, fmt.Errorf("%s requires %s", r.URL.Path, method))
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) resolve(w http.ResponseWriter, r *http.Request) {
+	ref := r.URL.Query().Get("ref")
+	if ref == "" {
+		respondError(w, http.StatusBadRequest, fmt.Errorf("missing the package reference, like ?ref=github.com/org/repo"))
+		return
+	}
+	dep, err := client.Resolve(ref, client.ResolveOptions{DefaultVersion: s.DefaultVersion})
+	if err != nil {
+		respondError(w, http.StatusBadRequest, err)
+		return
+	}
+	respond(w, http.StatusOK, newPackage(*dep, ""))
+}
+
+func (s *Server) packages(w http.ResponseWriter, r *http.Request) {
+	p, err := client.Load(s.dir())
+	if err != nil {
+		respondError(w, statusOf(err), err)
+		return
+	}
+	lock := spec.JsonnetFile{}
+	if p.Lock != nil {
+		lock = *p.Lock
+	}
+	respond(w, http.StatusOK, s.locked(lock))
+}
+
+func (s *Server) install(w http.ResponseWriter, r *http.Request) {
+	// Pages can send text/plain across origins without asking, but not JSON.
+	if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || t != "application/json" {
+		respondError(w, http.StatusUnsupportedMediaType, fmt.Errorf("%s requires Content-Type application/json", r.URL.Path))
+		return
+	}
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %v", err))
+		return
+	}
+	deps := []spec.Dependency{}
+	for _, ref := range req.Packages {
+		dep, err := client.Resolve(ref, client.ResolveOptions{DefaultVersion: s.DefaultVersion})
+		if err != nil {
+			respondError(w, http.StatusBadRequest, err)
+			return
+		}
+		deps = append(deps, *dep)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	lock, err := client.Install(r.Context(), client.InstallOptions{Options: s.Options, Dependencies: deps})
+	if err != nil {
+		respondError(w, statusOf(err), err)
+		return
+	}
+	respond(w, http.StatusOK, s.locked(*lock))
+}
+
+// watch sends a Change whenever the jsonnetfile or the lock file changes,
+// until the client disconnects.
+func (s *Server) watch(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	interval := s.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	files := []string{jsonnetfile.File, jsonnetfile.LockFile}
+	seen := map[string]string{}
+	for _, f := range files {
+		seen[f] = stamp(filepath.Join(s.dir(), f))
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		for _, f := range files {
+			st := stamp(filepath.Join(s.dir(), f))
+			if st == seen[f] {
+				continue
+			}
+			seen[f] = st
+			b, _ := json.Marshal(Change{File: f})
+			fmt.Fprintf(w, "event: change\ndata: %s\n\n", b)
+		}
+		flusher.Flush()
+	}
+}
+
+func (s *Server) dir() string {
+	if s.Options.Dir == "" {
+		return "."
+	}
+	return s.Options.Dir
+}
+
+// locked returns the packages of lock, with the paths they are vendored to.
+func (s *Server) locked(lock spec.JsonnetFile) []Package {
+	home := s.Options.JsonnetHome
+	if home == "" {
+		home = "vendor"
+	}
+	packages := make([]Package, 0, len(lock.Dependencies))
+	for _, d := range lock.Dependencies {
+		packages = append(packages, newPackage(d, filepath.ToSlash(pkg.VendorPath(home, d))))
+	}
+	return packages
+}
+
+func newPackage(d spec.Dependency, path string) Package {
+	return Package{
+		Name:    d.Name,
+		Source:  pkg.SourceString(d.Source),
+		Version: d.Version,
+		Tag:     d.Tag,
+		Path:    path,
+	}
+}
+
+// stamp identifies the state of the file at path, empty if it is missing.
+func stamp(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%d %d", info.ModTime().UnixNano(), info.Size())
+}
+
+// statusOf returns the status to respond with for err.
+func statusOf(err error) int {
+	if _, ok := err.(*client.NoJsonnetfileError); ok {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func respond(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func respondError(w http.ResponseWriter, status int, err error) {
+	respond(w, status, Error{Error: err.Error()})
+}
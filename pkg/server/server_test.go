@@ -0,0 +1,201 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/client"
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lib := filepath.Join(dir, "libs", "mylib")
+	assert.NoError(t, os.MkdirAll(lib, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(lib, "main.libsonnet"), []byte("{}"), 0644))
+
+	srv := httptest.NewServer(&Server{Options: client.Options{Dir: dir}, Interval: 10 * time.Millisecond})
+	defer srv.Close()
+
+	get := func(path string, v interface{}) int {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		return resp.StatusCode
+	}
+
+	var e Error
+	assert.Equal(t, http.StatusNotFound, get("/v1/packages", &e))
+	assert.Contains(t, e.Error, jsonnetfile.File)
+	assert.Equal(t, http.StatusBadRequest, get("/v1/resolve", &e))
+	assert.Equal(t, http.StatusMethodNotAllowed, get("/v1/install", &e))
+
+	var p Package
+	assert.Equal(t, http.StatusOK, get("/v1/resolve?ref="+url.QueryEscape("github.com/foo/bar/baz@v1.0.0"), &p))
+	assert.Equal(t, Package{Name: "baz", Source: "https://github.com/foo/bar/baz", Version: "v1.0.0"}, p)
+
+	assert.NoError(t, jsonnetfile.Write(filepath.Join(dir, jsonnetfile.File), spec.JsonnetFile{Dependencies: []spec.Dependency{}}))
+
+	// Changes made by the install are streamed to the watchers.
+	watch, err := http.Get(srv.URL + "/v1/watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer watch.Body.Close()
+	assert.Equal(t, "text/event-stream", watch.Header.Get("Content-Type"))
+
+	resp, err := http.Post(srv.URL+"/v1/install", "application/json", strings.NewReader(`{"packages": ["./libs/mylib"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var installed []Package
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&installed))
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	if assert.Len(t, installed, 1) {
+		assert.Equal(t, "mylib", installed[0].Name)
+		assert.Equal(t, "vendor/mylib", installed[0].Path)
+	}
+	_, err = os.Stat(filepath.Join(dir, "vendor", "mylib", "main.libsonnet"))
+	assert.NoError(t, err)
+
+	var locked []Package
+	assert.Equal(t, http.StatusOK, get("/v1/packages", &locked))
+	assert.Equal(t, installed, locked)
+
+	events := bufio.NewScanner(watch.Body)
+	changed := map[string]bool{}
+	for len(changed) < 2 && events.Scan() {
+		line := events.Text()
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		var c Change
+		assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &c))
+		changed[c.File] = true
+	}
+	assert.Equal(t, map[string]bool{jsonnetfile.File: true, jsonnetfile.LockFile: true}, changed)
+}
+
+func TestServerRejects(t *testing.T) {
+	srv := httptest.NewServer(&Server{Token: "secret"})
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		method string
+		host   string
+		header map[string]string
+		status int
+	}{
+		{
+			name:   "authorized",
+			method: http.MethodGet,
+			header: map[string]string{"Authorization": "Bearer secret"},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "localhost",
+			method: http.MethodGet,
+			host:   "localhost:7755",
+			header: map[string]string{"Authorization": "Bearer secret"},
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "rebound host",
+			method: http.MethodGet,
+			host:   "evil.example:7755",
+			header: map[string]string{"Authorization": "Bearer secret"},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "web page",
+			method: http.MethodGet,
+			header: map[string]string{"Authorization": "Bearer secret", "Origin": "https://evil.example"},
+			status: http.StatusForbidden,
+		},
+		{
+			name:   "no token",
+			method: http.MethodGet,
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "wrong token",
+			method: http.MethodGet,
+			header: map[string]string{"Authorization": "Bearer guess"},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "token without scheme",
+			method: http.MethodGet,
+			header: map[string]string{"Authorization": "secret"},
+			status: http.StatusUnauthorized,
+		},
+		{
+			name:   "install as text",
+			method: http.MethodPost,
+			header: map[string]string{"Authorization": "Bearer secret", "Content-Type": "text/plain"},
+			status: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := "/v1/resolve"
+			if tc.method == http.MethodPost {
+				path = "/v1/install"
+			}
+			req, err := http.NewRequest(tc.method, srv.URL+path, strings.NewReader(`{"packages": []}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.host != "" {
+				req.Host = tc.host
+			}
+			for k, v := range tc.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			var e Error
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(&e))
+			assert.Equal(t, tc.status, resp.StatusCode, e.Error)
+		})
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SignatureError is returned when a dependency to verify is not installed
+// from a tag signed by one of the trusted keys.
+type SignatureError struct {
+	Name   string
+	Tag    string
+	Reason string
+}
+
+func (e *SignatureError) Error() string {
+	if e.Tag == "" {
+		return fmt.Sprintf("failed to verify %s: %s", e.Name, e.Reason)
+	}
+	return fmt.Sprintf("failed to verify tag %s of %s: %s", e.Tag, e.Name, e.Reason)
+}
+
+// trustedKeys returns the files of keys, the trusted keys of the
+// jsonnetfile filename, relative to the working directory.
+func trustedKeys(filename string, keys []string) []string {
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !filepath.IsAbs(k) {
+			k = filepath.Join(filepath.Dir(filename), filepath.FromSlash(k))
+		}
+		res = append(res, k)
+	}
+	return res
+}
+
+// verifySignature checks that ref, just fetched into the repository at
+// dir, is a tag signed by one of TrustedKeys, or else a commit tagged with
+// VerifyTag that is. The verified tag is recorded in p.Tag.
+func (p *GitPackage) verifySignature(ctx context.Context, dir, ref string) error {
+	name := p.Source.Remote
+	tag := strings.TrimPrefix(ref, "refs/tags/")
+	if tag == ref {
+		if p.VerifyTag == "" {
+			return &SignatureError{Name: name, Reason: fmt.Sprintf("%s is not a tag, only signed tags can be verified", ref)}
+		}
+		tag = p.VerifyTag
+		tagRef := "refs/tags/" + tag
+		if err := p.fetch(ctx, dir, "--depth", "1", "origin", "+"+tagRef+":"+tagRef); err != nil {
+			return errors.Wrapf(err, "failed to fetch tag %s of %s", tag, name)
+		}
+		commit, err := revParse(ctx, dir, ref+"^{commit}")
+		if err != nil {
+			return err
+		}
+		tagged, err := revParse(ctx, dir, tagRef+"^{commit}")
+		if err != nil {
+			return err
+		}
+		if commit != tagged {
+			return &SignatureError{Name: name, Tag: tag, Reason: fmt.Sprintf("the tag points to %s instead of %s", tagged, commit)}
+		}
+	}
+
+	if len(p.TrustedKeys) == 0 {
+		return &SignatureError{Name: name, Tag: tag, Reason: "the project trusts no keys, see trustedKeys"}
+	}
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return &SignatureError{Name: name, Tag: tag, Reason: fmt.Sprintf("gpg is required: %v", err)}
+	}
+
+	// The keyring holds nothing but the trusted keys, so any valid
+	// signature is one of them.
+	home, err := ioutil.TempDir("", "jsonnetpkg-gnupg")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(home)
+	for _, key := range p.TrustedKeys {
+		cmd := exec.CommandContext(ctx, "gpg", "--homedir", home, "--batch", "--quiet", "--import", key)
+		if err := p.run(cmd); err != nil {
+			return errors.Wrapf(err, "failed to import trusted key %s", key)
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "-c", "gpg.format=openpgp", "verify-tag", "refs/tags/"+tag)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GNUPGHOME="+home)
+	if err := p.run(cmd); err != nil {
+		return &SignatureError{Name: name, Tag: tag, Reason: fmt.Sprintf("missing or untrusted signature: %v", err)}
+	}
+
+	p.Tag = tag
+	return nil
+}
+
+// revParse returns the object name rev resolves to in the repository at
+// dir.
+func revParse(ctx context.Context, dir, rev string) (string, error) {
+	b := bytes.NewBuffer(nil)
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "-q", rev)
+	cmd.Stdout = b
+	cmd.Dir = dir
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "failed to resolve %s", rev)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
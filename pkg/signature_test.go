@@ -0,0 +1,152 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestKey generates an OpenPGP key for email in the GnuPG home dir and
+// writes its armored public key to dir, returning the file.
+func newTestKey(t *testing.T, home, dir, email string) string {
+	gpg := func(args ...string) []byte {
+		cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--quiet"}, args...)...)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("gpg %v: %v", args, err)
+		}
+		return out
+	}
+	gpg("--passphrase", "", "--quick-gen-key", email, "ed25519", "sign", "never")
+
+	key := filepath.Join(dir, email+".asc")
+	if err := ioutil.WriteFile(key, gpg("--armor", "--export", email), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVerifySignature(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is required")
+	}
+
+	tempDir, err := ioutil.TempDir("", "jb-signature")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	home := filepath.Join(tempDir, "gnupg")
+	assert.NoError(t, os.Mkdir(home, 0700))
+	defer exec.Command("gpgconf", "--homedir", home, "--kill", "gpg-agent").Run()
+
+	trusted := newTestKey(t, home, tempDir, "trusted@example.com")
+	newTestKey(t, home, tempDir, "other@example.com")
+
+	repo := newTestRepo(t)
+	defer repo.Close()
+	sign := func(key, tag string) string {
+		cmd := exec.Command("git", "-c", "user.name=jb", "-c", "user.email=jb@example.com", "-c", "user.signingkey="+key, "tag", "-s", "-m", tag, tag)
+		cmd.Dir = repo.Dir
+		cmd.Env = append(os.Environ(), "GNUPGHOME="+home)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git tag -s %s: %v\n%s", tag, err, out)
+		}
+		return repo.git("rev-parse", tag+"^{commit}")
+	}
+	signed := repo.commit("main.libsonnet", "{ v: 1 }")
+	sign("trusted@example.com", "v1.0.0")
+	repo.commit("main.libsonnet", "{ v: 2 }")
+	sign("other@example.com", "v2.0.0")
+	repo.git("tag", "-a", "-m", "v3.0.0", "v3.0.0")
+	repo.git("tag", "v4.0.0")
+
+	testcases := []struct {
+		Name    string
+		Version string
+		Tag     string
+		Keys    []string
+		Err     string
+	}{
+		{Name: "signed tag", Version: "v1.0.0", Keys: []string{trusted}},
+		{Name: "constraint", Version: "~1.0", Keys: []string{trusted}},
+		{Name: "locked commit", Version: signed, Tag: "v1.0.0", Keys: []string{trusted}},
+		{Name: "untrusted key", Version: "v2.0.0", Keys: []string{trusted}, Err: "missing or untrusted signature"},
+		{Name: "unsigned tag", Version: "v3.0.0", Keys: []string{trusted}, Err: "missing or untrusted signature"},
+		{Name: "lightweight tag", Version: "v4.0.0", Keys: []string{trusted}, Err: "missing or untrusted signature"},
+		{Name: "branch", Version: "master", Keys: []string{trusted}, Err: "is not a tag"},
+		{Name: "commit of another tag", Version: signed, Tag: "v2.0.0", Keys: []string{trusted}, Err: "the tag points to"},
+		{Name: "no keys", Version: "v1.0.0", Err: "trusts no keys"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "jb-signature-install")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			p := &GitPackage{
+				Source:      &spec.GitSource{Remote: repo.Dir},
+				Verify:      true,
+				VerifyTag:   tc.Tag,
+				TrustedKeys: tc.Keys,
+			}
+			commit, err := p.Install(context.TODO(), dir, tc.Version)
+			if tc.Err != "" {
+				assert.IsType(t, &SignatureError{}, errors.Cause(err))
+				assert.Contains(t, err.Error(), tc.Err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, signed, commit)
+			assert.Equal(t, "v1.0.0", p.LockTag())
+		})
+	}
+
+	// The lock records the verification and the trusted keys, installing
+	// it verifies the locked tag again.
+	project := filepath.Join(tempDir, "project")
+	dep := gitDependency("foo", repo.Dir, "^1.0")
+	dep.Verify = true
+	i := &Installer{JsonnetHome: filepath.Join(project, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(project, JsonnetFile), spec.JsonnetFile{
+		Dependencies: []spec.Dependency{dep},
+		TrustedKeys:  []string{"../trusted@example.com.asc"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"../trusted@example.com.asc"}, lock.TrustedKeys)
+	assert.True(t, lock.Dependencies[0].Verify)
+	assert.Equal(t, "v1.0.0", lock.Dependencies[0].Tag)
+	_, err = i.Install(context.TODO(), filepath.Join(project, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+
+	lock.Dependencies[0].Tag = "v2.0.0"
+	_, err = i.Install(context.TODO(), filepath.Join(project, JsonnetLockFile), *lock)
+	assert.IsType(t, &SignatureError{}, errors.Cause(err))
+
+	// Locks written without verification are out of sync.
+	lock.Dependencies[0].Verify = false
+	assert.IsType(t, &LockOutOfSyncError{}, CheckLock(spec.JsonnetFile{Dependencies: []spec.Dependency{dep}}, *lock))
+}
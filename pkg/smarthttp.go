@@ -0,0 +1,96 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// httpRefs lists the branches and tags of an HTTPS remote like remoteRefs,
+// speaking the smart HTTP protocol of git natively instead of running git
+// ls-remote, so that refs can be resolved on systems without git.
+func httpRefs(ctx context.Context, caFile, remote string) (refs map[string]string, head string, err error) {
+	url := strings.TrimSuffix(remote, "/") + "/info/refs?service=git-upload-pack"
+	b := bytes.NewBuffer(nil)
+	if _, err := download(ctx, caFile, url, b); err != nil {
+		return nil, "", err
+	}
+
+	refs, head, err = parseRefAdvertisement(b.Bytes())
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "failed to list refs of %s", remote)
+	}
+	return refs, head, nil
+}
+
+// parseRefAdvertisement parses the refs advertised by a smart HTTP git
+// server, a sequence of pkt-lines: the service announcement, then one ref
+// per line, the first one followed by the capabilities, which tell the
+// branch HEAD points to. Annotated tags map to the commit they point to.
+func parseRefAdvertisement(b []byte) (refs map[string]string, head string, err error) {
+	refs = map[string]string{}
+	announced := false
+	for len(b) > 0 {
+		if len(b) < 4 {
+			return nil, "", fmt.Errorf("truncated pkt-line")
+		}
+		n, err := strconv.ParseUint(string(b[:4]), 16, 16)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid pkt-line length %q", b[:4])
+		}
+		// Flush packets separate the announcement from the refs.
+		if n == 0 {
+			b = b[4:]
+			continue
+		}
+		if n < 4 || int(n) > len(b) {
+			return nil, "", fmt.Errorf("invalid pkt-line length %d", n)
+		}
+		line := strings.TrimSuffix(string(b[4:n]), "\n")
+		b = b[n:]
+
+		if !announced {
+			if line != "# service=git-upload-pack" {
+				return nil, "", fmt.Errorf("not a git-upload-pack advertisement")
+			}
+			announced = true
+			continue
+		}
+
+		if i := strings.IndexByte(line, 0); i >= 0 {
+			for _, c := range strings.Fields(line[i+1:]) {
+				if strings.HasPrefix(c, "symref=HEAD:refs/heads/") {
+					head = strings.TrimPrefix(c, "symref=HEAD:refs/heads/")
+				}
+			}
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 2 && (strings.HasPrefix(fields[1], "refs/heads/") || strings.HasPrefix(fields[1], "refs/tags/")) {
+			// Peeled tags are listed after the tag object.
+			refs[strings.TrimSuffix(fields[1], "^{}")] = fields[0]
+		}
+	}
+	if !announced {
+		return nil, "", fmt.Errorf("not a git-upload-pack advertisement")
+	}
+	return refs, head, nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+// pktLines encodes lines as a ref advertisement of a smart HTTP server.
+func pktLines(lines ...string) []byte {
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if line == "" {
+			buf.WriteString("0000")
+			continue
+		}
+		fmt.Fprintf(&buf, "%04x%s\n", len(line)+5, line)
+	}
+	return buf.Bytes()
+}
+
+func TestParseRefAdvertisement(t *testing.T) {
+	head := "1111111111111111111111111111111111111111"
+	tag := "2222222222222222222222222222222222222222"
+	peeled := "3333333333333333333333333333333333333333"
+	b := pktLines(
+		"# service=git-upload-pack",
+		"",
+		head+" HEAD\x00multi_ack symref=HEAD:refs/heads/main agent=git/2",
+		head+" refs/heads/main",
+		tag+" refs/tags/v1.0.0",
+		peeled+" refs/tags/v1.0.0^{}",
+		head+" refs/pull/1/head",
+		"",
+	)
+
+	refs, branch, err := parseRefAdvertisement(b)
+	assert.NoError(t, err)
+	assert.Equal(t, "main", branch)
+	assert.Equal(t, map[string]string{"refs/heads/main": head, "refs/tags/v1.0.0": peeled}, refs)
+
+	_, _, err = parseRefAdvertisement([]byte("<html>"))
+	assert.Error(t, err)
+	_, _, err = parseRefAdvertisement(pktLines("hello"))
+	assert.EqualError(t, err, "not a git-upload-pack advertisement")
+}
+
+func TestGitPackageInstallTarballConstraint(t *testing.T) {
+	commit := "0123456789abcdef0123456789abcdef01234567"
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeXGlobalHeader, Name: "pax_global_header", PAXRecords: map[string]string{"comment": commit}}))
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "bar-1.2.0/main.libsonnet", Mode: 0644, Size: 2}))
+	_, err := tw.Write([]byte("{}"))
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/foo/bar/info/refs":
+			w.Write(pktLines(
+				"# service=git-upload-pack",
+				"",
+				commit+" refs/heads/main\x00symref=HEAD:refs/heads/main",
+				commit+" refs/tags/v1.0.0",
+				commit+" refs/tags/v1.2.0",
+				commit+" refs/tags/v2.0.0",
+				"",
+			))
+		case "/foo/bar/tar.gz/v1.2.0":
+			w.Write(buf.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	defer func(tarball, repo, path string) {
+		githubTarballURL = tarball
+		githubRepoURL = repo
+		os.Setenv("PATH", path)
+	}(githubTarballURL, githubRepoURL, os.Getenv("PATH"))
+	githubTarballURL = srv.URL + "/%s/%s/tar.gz/%s"
+	githubRepoURL = srv.URL + "/%s/%s"
+	os.Setenv("PATH", "")
+
+	tempDir, err := ioutil.TempDir("", "jb-git-install")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	p := &GitPackage{Source: &spec.GitSource{Remote: "https://github.com/foo/bar"}}
+	lockVersion, err := p.Install(context.TODO(), filepath.Join(tempDir, "pkg"), "^1.0")
+	assert.NoError(t, err)
+	assert.Equal(t, commit, lockVersion)
+	assert.Equal(t, "v1.2.0", p.LockTag())
+
+	_, err = p.Install(context.TODO(), filepath.Join(tempDir, "other"), "^3.0")
+	assert.EqualError(t, err, "no tag of https://github.com/foo/bar satisfies version ^3.0")
+
+	// Refs of HTTPS remotes are listed without git as well.
+	p = &GitPackage{Source: &spec.GitSource{Remote: srv.URL + "/foo/bar"}}
+	refs, head, err := p.remoteRefs(context.TODO())
+	assert.NoError(t, err)
+	assert.Equal(t, "main", head)
+	assert.Len(t, refs, 4)
+}
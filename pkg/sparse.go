@@ -0,0 +1,154 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/imports"
+	"github.com/pkg/errors"
+)
+
+// sparsify removes the files of the packages of the session that the
+// project in projectDir does not import, directly or through other imported
+// files, see spec.JsonnetFile.Sparse. Imports are resolved the way jsonnet
+// resolves them with Jpath, the directory vendor of the project is not
+// scanned for them. The lock records the files every package kept, with
+// the digest of these files. Packages without digest, like linked ones,
+// are left whole.
+func (i *Installer) sparsify(projectDir, vendor string) error {
+	own, err := imports.Scan(projectDir, vendor)
+	if err != nil {
+		return errors.Wrap(err, "failed to scan the project for imports")
+	}
+	kept, err := importedFiles(own, Jpath(i.JsonnetHome, *i.lock))
+	if err != nil {
+		return err
+	}
+
+	for n, d := range i.lock.Dependencies {
+		if d.Sum == "" {
+			continue
+		}
+		dir, err := filepath.Abs(VendorPath(i.JsonnetHome, d))
+		if err != nil {
+			return err
+		}
+		files, err := keepFiles(dir, kept, d.Include)
+		if err != nil {
+			return errors.Wrapf(err, "failed to remove the files %s does not import", d.Name)
+		}
+		sum, err := hashDir(dir)
+		if err != nil {
+			return errors.Wrap(err, "failed to compute checksum")
+		}
+		i.lock.Dependencies[n].Sum = sum
+		i.lock.Dependencies[n].Files = files
+	}
+	return nil
+}
+
+// importedFiles returns the absolute paths of the files imports import,
+// and of those that the imported jsonnet files import in turn. Files
+// imported through a symlink are returned along with the file linked to.
+// Imports that do not resolve are left out.
+func importedFiles(queue []imports.Import, jpath []string) (map[string]bool, error) {
+	files := map[string]bool{}
+	for len(queue) > 0 {
+		imp := queue[0]
+		queue = queue[1:]
+
+		file := imports.Resolve(imp, jpath)
+		if file == "" {
+			continue
+		}
+		file, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		if files[file] {
+			continue
+		}
+		files[file] = true
+		if target, err := filepath.EvalSymlinks(file); err == nil {
+			files[target] = true
+		}
+		if imp.Kind != "import" {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", file)
+		}
+		queue = append(queue, imports.Parse(file, content)...)
+	}
+	return files, nil
+}
+
+// keepFiles removes the files of the tree at dir that are neither in kept
+// nor matched by one of include, and the directories left empty, and
+// returns the sorted paths of those it kept relative to dir, with forward
+// slashes. dir itself is kept, and so are the links to directories that
+// kept files were imported through.
+func keepFiles(dir string, kept map[string]bool, include []string) ([]string, error) {
+	parents := map[string]bool{}
+	for file := range kept {
+		for p := filepath.Dir(file); !parents[p] && p != filepath.Dir(p); p = filepath.Dir(p) {
+			parents[p] = true
+		}
+	}
+
+	files := []string{}
+	dirs := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir {
+				dirs = append(dirs, path)
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if !kept[path] && !(info.Mode()&os.ModeSymlink != 0 && parents[path]) && !matchAny(include, filepath.ToSlash(rel)) {
+			return os.Remove(path)
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Nested directories come after their parents, removing them in
+	// reverse removes them before their parents.
+	for n := len(dirs) - 1; n >= 0; n-- {
+		if entries, err := ioutil.ReadDir(dirs[n]); err == nil && len(entries) == 0 {
+			if err := os.Remove(dirs[n]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
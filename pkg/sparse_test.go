@@ -0,0 +1,104 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallerSparse(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-installer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	packages := map[string]map[string]string{
+		"https://example.com/foo": {
+			"main.libsonnet":       "(import 'util.libsonnet') + { data: importstr 'data.txt' }",
+			"util.libsonnet":       "{}",
+			"data.txt":             "data",
+			"unused.libsonnet":     "{}",
+			"docs/guide.libsonnet": "{}",
+		},
+		"https://example.com/bar": {
+			"main.libsonnet": "{}",
+		},
+	}
+	fetcher := FetcherFunc(func(dep spec.Dependency, from string) (Interface, error) {
+		return &memPackage{files: packages[dep.Source.GitSource.Remote]}, nil
+	})
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "main.jsonnet"), []byte("import 'foo/main.libsonnet'"), 0644))
+
+	// Included files are kept even though nothing imports them.
+	foo := gitDependency("foo", "https://example.com/foo", "1.0.0")
+	foo.Include = []string{"docs/**"}
+	m := spec.JsonnetFile{
+		Sparse: true,
+		Dependencies: []spec.Dependency{
+			foo,
+			gitDependency("bar", "https://example.com/bar", "1.0.0"),
+		},
+	}
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor"), Fetchers: []Fetcher{fetcher}}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.True(t, lock.Sparse)
+	assert.Equal(t, []string{"data.txt", "docs/guide.libsonnet", "main.libsonnet", "util.libsonnet"}, lock.Dependencies[0].Files)
+	assert.Equal(t, []string{}, lock.Dependencies[1].Files)
+	for name, want := range map[string]bool{"foo/main.libsonnet": true, "foo/data.txt": true, "foo/unused.libsonnet": false, "foo/docs/guide.libsonnet": true, "bar": true, "bar/main.libsonnet": false} {
+		exists, err := FileExists(filepath.Join(i.JsonnetHome, filepath.FromSlash(name)))
+		assert.NoError(t, err)
+		assert.Equal(t, want, exists, name)
+	}
+	assert.NoError(t, Verify(i.JsonnetHome, *lock))
+
+	// Packages at their locked version are verified against the files
+	// they kept.
+	i.locked = map[string]spec.Dependency{}
+	for _, d := range lock.Dependencies {
+		i.locked[d.Name] = d
+	}
+	i.lockedSparse = true
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	packages["https://example.com/foo"]["util.libsonnet"] = "{ tampered: true }"
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.IsType(t, &SumMismatchError{}, err)
+	packages["https://example.com/foo"]["util.libsonnet"] = "{}"
+
+	// Vendoring whole packages again verifies the sparse lock as well.
+	m.Sparse = false
+	whole, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.False(t, whole.Sparse)
+	assert.Nil(t, whole.Dependencies[0].Files)
+	exists, err := FileExists(filepath.Join(i.JsonnetHome, "foo", "unused.libsonnet"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestCheckLockSparse(t *testing.T) {
+	m := spec.JsonnetFile{Sparse: true, Dependencies: []spec.Dependency{}}
+	err := CheckLock(m, spec.JsonnetFile{Dependencies: []spec.Dependency{}})
+	assert.EqualError(t, err, "jsonnetfile.lock.json is out of sync with jsonnetfile.json: the sparse vendoring differs from the locked one")
+}
@@ -0,0 +1,251 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Environment variables configuring the connections of git to ssh remotes,
+// see Installer.SSHKey and Installer.SSHKnownHosts.
+const (
+	SSHKeyEnv        = "JB_SSH_KEY"
+	SSHKnownHostsEnv = "JB_SSH_KNOWN_HOSTS"
+)
+
+var (
+	// hostKeySSHRegex matches the messages of ssh about unknown or changed
+	// host keys.
+	hostKeySSHRegex = regexp.MustCompile(`(?i)host key verification failed|remote host identification has changed|no \S+ host key is known`)
+	// publicKeySSHRegex matches the message of ssh about a server that
+	// accepted none of the keys offered.
+	publicKeySSHRegex = regexp.MustCompile(`(?i)permission denied \(publickey`)
+)
+
+// isSSHRemote reports whether git talks to remote over ssh, for ssh:// and
+// git+ssh:// URLs and scp-like remotes like git@github.com:org/repo.
+func isSSHRemote(remote string) bool {
+	if strings.HasPrefix(remote, "ssh://") || strings.HasPrefix(remote, "git+ssh://") {
+		return true
+	}
+	if strings.Contains(remote, "://") {
+		return false
+	}
+	colon := strings.Index(remote, ":")
+	// A single letter before the colon is a Windows drive.
+	return colon > 1 && !strings.ContainsAny(remote[:colon], `/\`)
+}
+
+// The transports git remotes can be translated to, see TranslateRemote.
+const (
+	TransportHTTPS = "https"
+	TransportSSH   = "ssh"
+)
+
+// Transports lists the transports of TranslateRemote.
+var Transports = []string{TransportHTTPS, TransportSSH}
+
+// PreferEnv names the transport git remotes are preferably fetched over,
+// see Installer.Prefer, like https in CI that only has HTTPS tokens.
+const PreferEnv = "JB_PREFER"
+
+// TranslateRemote returns remote in the form of transport: scp-like ssh
+// remotes like git@github.com:org/repo and ssh:// URLs become
+// https://github.com/org/repo for https, and HTTPS remotes become
+// git@github.com:org/repo for ssh. Ports and credentials do not carry over
+// to the other form, remotes already in the form of transport are kept. It
+// returns false for remotes that are neither, like local paths, which are
+// used as they are.
+func TranslateRemote(remote, transport string) (string, bool) {
+	web := strings.HasPrefix(remote, "https://") || strings.HasPrefix(remote, "http://")
+	if transport == TransportHTTPS && web || transport == TransportSSH && isSSHRemote(remote) {
+		return remote, true
+	}
+
+	var host, repo string
+	switch {
+	case web || strings.Contains(remote, "://") && isSSHRemote(remote):
+		u, err := url.Parse(remote)
+		if err != nil || u.Hostname() == "" {
+			return "", false
+		}
+		host, repo = u.Hostname(), u.Path
+	case isSSHRemote(remote):
+		host, repo = sshHost(remote), remote[strings.Index(remote, ":")+1:]
+	default:
+		return "", false
+	}
+	repo = strings.TrimPrefix(repo, "/")
+	if repo == "" {
+		return "", false
+	}
+
+	switch transport {
+	case TransportHTTPS:
+		return "https://" + host + "/" + repo, true
+	case TransportSSH:
+		return "git@" + host + ":" + repo, true
+	}
+	return "", false
+}
+
+// transportFailed reports whether err is a remote refusing access or that
+// cannot be reached, see AuthError and NetworkError, which the remote may
+// not be over another transport.
+func transportFailed(err error) bool {
+	for err != nil {
+		switch err.(type) {
+		case *AuthError, *NetworkError:
+			return true
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
+// checkTransport fails if transport is neither empty nor one of
+// Transports.
+func checkTransport(transport string) error {
+	for _, t := range Transports {
+		if transport == "" || transport == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown transport %q, use %s", transport, strings.Join(Transports, " or "))
+}
+
+// sshHost returns the host of remote, an ssh remote.
+func sshHost(remote string) string {
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	host := remote[:strings.Index(remote, ":")]
+	return host[strings.Index(host, "@")+1:]
+}
+
+// sshEnv returns the environment variables the git commands talking to
+// remote need on top of those of jb: a GIT_SSH_COMMAND using key and
+// knownHosts, if set, and the socket of a running ssh agent if
+// SSH_AUTH_SOCK is not set, see sshAgentSocket. Remotes other than ssh
+// need none.
+func sshEnv(remote, key, knownHosts string) []string {
+	if !isSSHRemote(remote) {
+		return nil
+	}
+
+	env := []string{}
+	// A GIT_SSH program is left alone, it takes no ssh options.
+	if (key != "" || knownHosts != "") && os.Getenv("GIT_SSH") == "" {
+		ssh := os.Getenv("GIT_SSH_COMMAND")
+		if ssh == "" {
+			ssh = "ssh"
+		}
+		if key != "" {
+			ssh += " -i " + shellQuote(absPath(key)) + " -o IdentitiesOnly=yes"
+		}
+		if knownHosts != "" {
+			ssh += " -o UserKnownHostsFile=" + shellQuote(absPath(knownHosts)) + " -o StrictHostKeyChecking=yes"
+		}
+		env = append(env, "GIT_SSH_COMMAND="+ssh)
+	}
+	if os.Getenv("SSH_AUTH_SOCK") == "" {
+		if socket := sshAgentSocket(); socket != "" {
+			env = append(env, "SSH_AUTH_SOCK="+socket)
+		}
+	}
+	return env
+}
+
+// sshAgentSocket returns the socket of the ssh agent: SSH_AUTH_SOCK, or
+// else the first existing socket of the agents usually left unexported in
+// containers and desktop sessions, like the one Docker Desktop forwards.
+// It is empty if there is none.
+func sshAgentSocket() string {
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		return socket
+	}
+
+	candidates := []string{}
+	if runtime := os.Getenv("XDG_RUNTIME_DIR"); runtime != "" {
+		candidates = append(candidates,
+			filepath.Join(runtime, "ssh-agent.socket"),
+			filepath.Join(runtime, "gcr", "ssh"),
+			filepath.Join(runtime, "keyring", "ssh"),
+		)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(home, ".1password", "agent.sock"))
+	}
+	candidates = append(candidates, "/run/host-services/ssh-auth.sock")
+
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && info.Mode()&os.ModeSocket != 0 {
+			return c
+		}
+	}
+	return ""
+}
+
+// sshAuthError explains why ssh refused access to remote, from its stderr,
+// and how to fix it, given the key and known hosts file git was told to
+// use. It returns nil for failures it cannot tell apart.
+func sshAuthError(remote, stderr, key, knownHosts string) error {
+	host := sshHost(remote)
+	switch {
+	case hostKeySSHRegex.MatchString(stderr):
+		if knownHosts != "" {
+			return fmt.Errorf("the host key of %s is not in the known hosts file %s, or it changed: add it, like ssh-keyscan %s >> %s", host, knownHosts, host, knownHosts)
+		}
+		return fmt.Errorf("ssh does not know the host key of %s, or it changed: add it to ~/.ssh/known_hosts, like ssh-keyscan %s >> ~/.ssh/known_hosts, or set %s to a known hosts file holding it", host, host, SSHKnownHostsEnv)
+	case !publicKeySSHRegex.MatchString(stderr):
+		return nil
+	case key != "":
+		if _, err := os.Stat(absPath(key)); err != nil {
+			return fmt.Errorf("ssh was refused access to %s, the key %s does not exist", remote, key)
+		}
+		return fmt.Errorf("ssh was refused access to %s, the key %s is not authorized for it", remote, key)
+	case sshAgentSocket() == "":
+		return fmt.Errorf("ssh was refused access to %s, no key in ~/.ssh is authorized for it and no ssh agent is running: set %s to a key with access, or load one into an agent", remote, SSHKeyEnv)
+	}
+	return fmt.Errorf("ssh was refused access to %s, none of the keys of the ssh agent or in ~/.ssh is authorized for it: set %s to a key with access", remote, SSHKeyEnv)
+}
+
+// absPath returns p made absolute, ~/ being the home directory, as git runs
+// ssh in directories of its own.
+func absPath(p string) string {
+	if strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			p = filepath.Join(home, p[2:])
+		}
+	}
+	if abs, err := filepath.Abs(p); err == nil {
+		return abs
+	}
+	return p
+}
+
+// shellQuote quotes s for the shell git runs GIT_SSH_COMMAND with.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
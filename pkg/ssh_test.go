@@ -0,0 +1,213 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsSSHRemote(t *testing.T) {
+	for remote, ssh := range map[string]bool{
+		"git@github.com:org/repo.git":        true,
+		"github.com:org/repo":                true,
+		"ssh://git@github.com/org/repo.git":  true,
+		"git+ssh://git@github.com/org/repo":  true,
+		"https://github.com/org/repo":        false,
+		"/srv/git/repo":                      false,
+		`C:\git\repo`:                        false,
+		"./libs/repo:with-colon":             false,
+		"file:///srv/git/repo":               false,
+		"git@github.com:org/repo.git/subdir": true,
+	} {
+		assert.Equal(t, ssh, isSSHRemote(remote), remote)
+	}
+	assert.Equal(t, "github.com", sshHost("git@github.com:org/repo.git"))
+	assert.Equal(t, "git.example.com", sshHost("ssh://git@git.example.com:2222/org/repo"))
+}
+
+func TestSSHEnv(t *testing.T) {
+	defer setenv(map[string]string{"GIT_SSH": "", "GIT_SSH_COMMAND": "ssh -o BatchMode=yes", "SSH_AUTH_SOCK": "/tmp/agent.sock"})()
+
+	assert.Nil(t, sshEnv("https://github.com/org/repo", "/keys/id_ed25519", ""))
+	assert.Empty(t, sshEnv("git@github.com:org/repo", "", ""))
+	assert.Equal(t, []string{
+		"GIT_SSH_COMMAND=ssh -o BatchMode=yes -i '/keys/id'\\''s' -o IdentitiesOnly=yes -o UserKnownHostsFile='/keys/known_hosts' -o StrictHostKeyChecking=yes",
+	}, sshEnv("git@github.com:org/repo", "/keys/id's", "/keys/known_hosts"))
+
+	// A GIT_SSH program takes no ssh options.
+	os.Setenv("GIT_SSH", "plink")
+	assert.Empty(t, sshEnv("git@github.com:org/repo", "/keys/id_ed25519", ""))
+}
+
+func TestSSHAgentSocket(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix sockets")
+	}
+	tempDir, err := ioutil.TempDir("", "jb-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer setenv(map[string]string{"SSH_AUTH_SOCK": "", "XDG_RUNTIME_DIR": tempDir, "HOME": tempDir})()
+
+	assert.Equal(t, "", sshAgentSocket())
+
+	socket := filepath.Join(tempDir, "ssh-agent.socket")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	assert.Equal(t, socket, sshAgentSocket())
+	assert.Equal(t, []string{"SSH_AUTH_SOCK=" + socket}, sshEnv("git@github.com:org/repo", "", ""))
+}
+
+func TestSSHAuthError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ssh is a shell script")
+	}
+	tempDir, err := ioutil.TempDir("", "jb-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The fake ssh records its arguments and refuses like a server
+	// accepting none of the keys.
+	ssh := filepath.Join(tempDir, "ssh")
+	args := filepath.Join(tempDir, "args")
+	assert.NoError(t, ioutil.WriteFile(ssh, []byte("#!/bin/sh\necho \"$@\" > "+args+"\necho 'git@example.com: Permission denied (publickey).' >&2\nexit 255\n"), 0755))
+	key := filepath.Join(tempDir, "id_ed25519")
+	assert.NoError(t, ioutil.WriteFile(key, []byte("key"), 0600))
+	defer setenv(map[string]string{"GIT_SSH": "", "GIT_SSH_COMMAND": ssh, "SSH_AUTH_SOCK": "", "XDG_RUNTIME_DIR": tempDir, "HOME": tempDir})()
+
+	p := &GitPackage{Source: &spec.GitSource{Remote: "git@example.com:org/repo.git"}, SSHKey: key}
+	_, err = p.Install(context.TODO(), filepath.Join(tempDir, "vendor"), "master")
+	assert.True(t, causedByAuth(err), err)
+	assert.Contains(t, err.Error(), "ssh was refused access to git@example.com:org/repo.git, the key "+key+" is not authorized for it")
+	b, err := ioutil.ReadFile(args)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "-i "+key+" -o IdentitiesOnly=yes")
+
+	for stderr, msg := range map[string]string{
+		"Host key verification failed.":                   "ssh does not know the host key of example.com",
+		"git@example.com: Permission denied (publickey).": "no ssh agent is running: set JB_SSH_KEY",
+		"fatal: Could not read from remote repository.":   "",
+	} {
+		err := sshAuthError("git@example.com:org/repo", stderr, "", "")
+		if msg == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		assert.True(t, strings.Contains(err.Error(), msg), err.Error())
+	}
+	err = sshAuthError("git@example.com:org/repo", "Permission denied (publickey)", filepath.Join(tempDir, "missing"), "")
+	assert.Contains(t, err.Error(), "does not exist")
+}
+
+// causedByAuth reports whether err or one of its causes is an AuthError,
+// which errors.Cause looks through.
+func causedByAuth(err error) bool {
+	for err != nil {
+		if _, ok := err.(*AuthError); ok {
+			return true
+		}
+		c, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = c.Cause()
+	}
+	return false
+}
+
+func TestTranslateRemote(t *testing.T) {
+	for _, c := range []struct {
+		remote, transport, translated string
+		ok                            bool
+	}{
+		{"git@github.com:org/repo.git", TransportHTTPS, "https://github.com/org/repo.git", true},
+		{"ssh://git@git.example.com:2222/org/repo", TransportHTTPS, "https://git.example.com/org/repo", true},
+		{"https://github.com/org/repo", TransportSSH, "git@github.com:org/repo", true},
+		{"https://token@github.com/org/repo", TransportSSH, "git@github.com:org/repo", true},
+		{"https://github.com/org/repo", TransportHTTPS, "https://github.com/org/repo", true},
+		{"git@github.com:org/repo", TransportSSH, "git@github.com:org/repo", true},
+		{"https://github.com", TransportSSH, "", false},
+		{"/srv/git/repo", TransportHTTPS, "", false},
+		{"git@github.com:org/repo", "", "", false},
+	} {
+		translated, ok := TranslateRemote(c.remote, c.transport)
+		assert.Equal(t, c.ok, ok, c.remote)
+		assert.Equal(t, c.translated, translated, c.remote)
+	}
+
+	assert.NoError(t, checkTransport(""))
+	assert.NoError(t, checkTransport(TransportSSH))
+	assert.EqualError(t, checkTransport("ftp"), `unknown transport "ftp", use https or ssh`)
+}
+
+func TestInstallerPrefer(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-prefer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Only the https form of the remote exists, behind its mirror: the ssh
+	// form of the first is translated to it, the second falls back to it.
+	mirrors := Mirrors{{From: "https://jb.invalid/", To: filepath.Dir(repo.Dir) + "/"}}
+	name := filepath.Base(repo.Dir)
+	ssh := gitDependency("ssh", "git@jb.invalid:"+name, "master")
+	ssh.Transport = TransportHTTPS
+	https := gitDependency("https", "https://jb.invalid/"+name, "master")
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{https, ssh}}
+
+	i := &Installer{
+		JsonnetHome: filepath.Join(tempDir, "vendor"),
+		Mirrors:     mirrors,
+		Prefer:      TransportSSH,
+	}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	if !assert.NoError(t, err) {
+		return
+	}
+	for n, d := range m.Dependencies {
+		locked := lock.Dependencies[n]
+		assert.Equal(t, d.Source.GitSource.Remote, locked.Source.GitSource.Remote)
+		assert.Equal(t, d.Transport, locked.Transport)
+
+		exists, err := FileExists(filepath.Join(i.JsonnetHome, d.Name, "main.libsonnet"))
+		assert.NoError(t, err)
+		assert.True(t, exists)
+	}
+
+	i.Prefer = "ftp"
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.Error(t, err)
+}
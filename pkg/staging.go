@@ -0,0 +1,144 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// staging is a copy of the vendor directory that an install vendors into,
+// so that the vendor directory is only replaced once everything was
+// installed and stays as it was if anything fails.
+type staging struct {
+	vendor    string
+	dir       string
+	committed bool
+}
+
+// stage creates the staging copy of jsonnetHome, a hidden directory next to
+// it with the same mode, creating jsonnetHome if need be. Files are hard linked instead of copied where possible, which is safe
+// because the installer replaces vendored files but never writes to them.
+// It returns nil if jsonnetHome is a symlink or holds a jsonnetfile, most
+// likely the project itself, which are installed into in place.
+func stage(jsonnetHome string) (*staging, error) {
+	if info, err := os.Lstat(jsonnetHome); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return nil, nil
+	}
+	if exists, _ := FileExists(filepath.Join(jsonnetHome, JsonnetFile)); exists {
+		return nil, nil
+	}
+
+	vendor, err := filepath.Abs(jsonnetHome)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(vendor, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "failed to create jsonnet home path")
+	}
+	info, err := os.Stat(vendor)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir(filepath.Dir(vendor), "."+filepath.Base(vendor)+"-staging-")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create staging directory")
+	}
+	s := &staging{vendor: vendor, dir: dir}
+	// Temporary directories are private, the copy replacing the vendor
+	// directory gets its mode instead.
+	if err := os.Chmod(dir, info.Mode().Perm()); err != nil {
+		s.discard()
+		return nil, errors.Wrap(err, "failed to create staging directory")
+	}
+
+	if err := linkTree(vendor, dir); err != nil && !os.IsNotExist(err) {
+		s.discard()
+		return nil, errors.Wrap(err, "failed to stage the vendor directory")
+	}
+	return s, nil
+}
+
+// commit replaces the vendor directory with the staging copy. If that
+// fails, the vendor directory is restored.
+func (s *staging) commit() error {
+	backup := s.dir + "-old"
+	if err := os.Rename(s.vendor, backup); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to replace the vendor directory")
+	}
+	if err := os.Rename(s.dir, s.vendor); err != nil {
+		os.Rename(backup, s.vendor)
+		return errors.Wrap(err, "failed to replace the vendor directory")
+	}
+	s.committed = true
+	return errors.Wrap(os.RemoveAll(backup), "failed to remove the previous vendor directory")
+}
+
+// discard removes the staging copy unless it was committed.
+func (s *staging) discard() {
+	if !s.committed {
+		os.RemoveAll(s.dir)
+	}
+}
+
+// linkTree recreates the tree at src in dst, which must exist, with hard
+// links to the files of src, or copies where they cannot be linked. Links
+// are recreated like LocalPackage.Link does. The temporary directory of the
+// installer is left out.
+func linkTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case rel == ".tmp":
+			return filepath.SkipDir
+		case info.IsDir():
+			return os.MkdirAll(target, os.ModePerm)
+		case !info.Mode().IsRegular():
+			// Symlinks, and junctions on Windows.
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			dir := link
+			if !filepath.IsAbs(link) {
+				dir = filepath.Join(filepath.Dir(path), link)
+			}
+			return linkDir(link, dir, target)
+		}
+
+		if err := os.Link(path, target); err == nil {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		return writeFile(target, in, info.Mode())
+	})
+}
@@ -0,0 +1,179 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// VendorStats describe the files vendored for the packages of a lock, see
+// Stats.
+type VendorStats struct {
+	// Packages are sorted by size, the largest first.
+	Packages []PackageStats `json:"packages"`
+	Files    int            `json:"files"`
+	Size     int64          `json:"size"`
+	// Largest are the largest files of all packages, the largest first.
+	Largest []FileStats `json:"largest"`
+	// Duplicates are the contents vendored more than once, those wasting
+	// the most space first.
+	Duplicates []DuplicateStats `json:"duplicates"`
+}
+
+// PackageStats describe the files vendored for a package. Linked packages,
+// like local ones, are not counted.
+type PackageStats struct {
+	Name   string `json:"name"`
+	Files  int    `json:"files"`
+	Size   int64  `json:"size"`
+	Linked bool   `json:"linked,omitempty"`
+}
+
+// FileStats is a vendored file, its path relative to the vendor directory
+// with forward slashes.
+type FileStats struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+// DuplicateStats is a content held by several vendored files.
+type DuplicateStats struct {
+	Size  int64    `json:"size"`
+	Paths []string `json:"paths"`
+}
+
+// Wasted is the space taken by all copies of the content but one.
+func (d DuplicateStats) Wasted() int64 {
+	return d.Size * int64(len(d.Paths)-1)
+}
+
+// Stats counts the files vendored into jsonnetHome for the packages of
+// lock, reports the largest ones, up to top, and the contents vendored more
+// than once, in the same package or across packages, for deciding what to
+// exclude. Empty files are never duplicates. Missing packages are counted
+// as empty, those vendored within the directory of another package only
+// once.
+func Stats(jsonnetHome string, lock spec.JsonnetFile, top int) (*VendorStats, error) {
+	stats := &VendorStats{Packages: []PackageStats{}, Largest: []FileStats{}, Duplicates: []DuplicateStats{}}
+	files := []FileStats{}
+	contents := map[string]*DuplicateStats{}
+	sums := []string{}
+	// Qualified names may nest packages in each other's directory.
+	dirs := map[string]bool{}
+	for _, d := range lock.Dependencies {
+		dirs[VendorPath(jsonnetHome, d)] = true
+	}
+
+	for _, d := range lock.Dependencies {
+		p := PackageStats{Name: d.Name}
+		dir := VendorPath(jsonnetHome, d)
+		info, err := os.Lstat(dir)
+		switch {
+		case os.IsNotExist(err):
+			stats.Packages = append(stats.Packages, p)
+			continue
+		case err != nil:
+			return nil, err
+		case !info.IsDir():
+			p.Linked = true
+			stats.Packages = append(stats.Packages, p)
+			continue
+		}
+
+		err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != dir && dirs[path] {
+				return filepath.SkipDir
+			}
+			// Links are vendored as they are, their targets are not.
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(jsonnetHome, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+
+			p.Files++
+			p.Size += info.Size()
+			files = append(files, FileStats{Path: rel, Size: info.Size()})
+			if info.Size() == 0 {
+				return nil
+			}
+
+			sum, err := hashFile(path)
+			if err != nil {
+				return err
+			}
+			if contents[sum] == nil {
+				contents[sum] = &DuplicateStats{Size: info.Size()}
+				sums = append(sums, sum)
+			}
+			contents[sum].Paths = append(contents[sum].Paths, rel)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		stats.Packages = append(stats.Packages, p)
+		stats.Files += p.Files
+		stats.Size += p.Size
+	}
+
+	sort.SliceStable(stats.Packages, func(i, j int) bool {
+		return stats.Packages[i].Size > stats.Packages[j].Size
+	})
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Size > files[j].Size
+	})
+	if len(files) > top {
+		files = files[:top]
+	}
+	stats.Largest = append(stats.Largest, files...)
+
+	for _, sum := range sums {
+		if d := contents[sum]; len(d.Paths) > 1 {
+			stats.Duplicates = append(stats.Duplicates, *d)
+		}
+	}
+	sort.SliceStable(stats.Duplicates, func(i, j int) bool {
+		return stats.Duplicates[i].Wasted() > stats.Duplicates[j].Wasted()
+	})
+	return stats, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jb-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a/main.libsonnet":        "{ a: 1 }",
+		"a/lib/util.libsonnet":    "{ util: true }",
+		"a/empty.libsonnet":       "",
+		"b/main.libsonnet":        "{ b: 2, padding: 'xxxxxxxxxxxxxxxxxxxx' }",
+		"b/vendor/util.libsonnet": "{ util: true }",
+		"b/nested/c/x.libsonnet":  "{}",
+		"other/empty.libsonnet":   "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	lock := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		{Name: "a"}, {Name: "b"}, {Name: "b/nested/c"}, {Name: "missing"},
+	}}
+
+	stats, err := Stats(dir, lock, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []PackageStats{
+		{Name: "b", Files: 2, Size: 55},
+		{Name: "a", Files: 3, Size: 22},
+		{Name: "b/nested/c", Files: 1, Size: 2},
+		{Name: "missing"},
+	}, stats.Packages)
+	assert.Equal(t, 6, stats.Files)
+	assert.Equal(t, int64(79), stats.Size)
+	assert.Equal(t, []FileStats{{Path: "b/main.libsonnet", Size: 41}, {Path: "a/lib/util.libsonnet", Size: 14}}, stats.Largest)
+	assert.Equal(t, []DuplicateStats{{Size: 14, Paths: []string{"a/lib/util.libsonnet", "b/vendor/util.libsonnet"}}}, stats.Duplicates)
+}
@@ -0,0 +1,126 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Store keeps the contents of vendored packages once per digest, so that
+// projects vendoring the same version of a package share its files through
+// hard links instead of holding copies of them. The links share the files
+// of the store, which are therefore read-only: a vendored file edited anyway
+// changes in every project linking it. It is locked while packages are
+// linked from it, as entries that were modified are replaced.
+type Store struct {
+	Dir string
+	// LockTimeout limits how long the lock of the store held by another
+	// process is waited for, see Cache. No limit if zero.
+	LockTimeout time.Duration
+}
+
+func NewStore(dir string) *Store {
+	return &Store{
+		Dir: dir,
+	}
+}
+
+func (s *Store) path(sum string) string {
+	h := sha256.Sum256([]byte(sum))
+	return filepath.Join(s.Dir, hex.EncodeToString(h[:]))
+}
+
+// Link vendors the package at src, of the digest sum, into dest by hard
+// linking the files of the entry of sum, which is added from src if there
+// is none yet. With move, src is moved into the store rather than copied.
+// Files that cannot be linked, like across file systems, are copied.
+// Entries modified through the links of a vendor directory no longer match
+// their digest and are replaced.
+func (s *Store) Link(ctx context.Context, src, sum string, move bool, dest string) error {
+	release, err := acquireLock(ctx, filepath.Join(s.Dir, cacheLockFile), s.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entry := s.path(sum)
+	if _, err := os.Stat(entry); err == nil {
+		if actual, err := hashDir(entry); err != nil || actual != sum {
+			if err := os.RemoveAll(entry); err != nil {
+				return errors.Wrap(err, "failed to remove modified store entry")
+			}
+		}
+	}
+	if _, err := os.Stat(entry); os.IsNotExist(err) {
+		if err := s.put(src, move, entry); err != nil {
+			return errors.Wrap(err, "failed to add package to store")
+		}
+	}
+
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return err
+	}
+	return linkTree(entry, dest)
+}
+
+// put adds the package at src as entry, with its files made read-only.
+// Entries are written to a temporary directory first, so concurrent installs
+// never see a partial entry.
+func (s *Store) put(src string, move bool, entry string) error {
+	if err := os.MkdirAll(s.Dir, os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(s.Dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	pkg := filepath.Join(tmp, "package")
+	if !move || os.Rename(src, pkg) != nil {
+		if err := copyDir(src, pkg); err != nil {
+			return err
+		}
+	}
+	if err := readOnlyTree(pkg); err != nil {
+		return err
+	}
+	// Another install may have added the same entry in the meantime.
+	if err := os.Rename(pkg, entry); err != nil {
+		if exists, _ := FileExists(entry); !exists {
+			return err
+		}
+	}
+	return nil
+}
+
+// readOnlyTree removes the write permissions of the files of the tree at dir.
+// Directories stay writable, so that entries can be removed.
+func readOnlyTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		return os.Chmod(path, info.Mode().Perm()&^0222)
+	})
+}
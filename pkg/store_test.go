@@ -0,0 +1,79 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallerStore(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Both projects vendor the files of the store.
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, "master")}}
+	files := []os.FileInfo{}
+	for _, project := range []string{"a", "b"} {
+		i := &Installer{JsonnetHome: filepath.Join(tempDir, project, "vendor"), StoreDir: filepath.Join(tempDir, "store")}
+		_, err := i.Install(context.TODO(), filepath.Join(tempDir, project, JsonnetFile), m)
+		if !assert.NoError(t, err) {
+			return
+		}
+		fi, err := os.Stat(filepath.Join(i.JsonnetHome, "foo", "main.libsonnet"))
+		assert.NoError(t, err)
+		files = append(files, fi)
+	}
+	assert.True(t, os.SameFile(files[0], files[1]))
+	// They are read-only, an edit would change every project.
+	assert.Equal(t, os.FileMode(0), files[0].Mode().Perm()&0222)
+
+	// Reproducible installs copy them, so that normalizing their modes and
+	// times leaves the store alone.
+	r := &Installer{JsonnetHome: filepath.Join(tempDir, "r", "vendor"), StoreDir: filepath.Join(tempDir, "store"), Reproducible: true}
+	_, err = r.Install(context.TODO(), filepath.Join(tempDir, "r", JsonnetFile), m)
+	assert.NoError(t, err)
+	fi, err := os.Stat(filepath.Join(r.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.False(t, os.SameFile(files[0], fi))
+	shared, err := os.Stat(filepath.Join(tempDir, "a", "vendor", "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, files[0].Mode(), shared.Mode())
+	assert.Equal(t, files[0].ModTime(), shared.ModTime())
+
+	// A file modified through the vendor directory of one project anyway is
+	// not vendored into others.
+	assert.NoError(t, os.Chmod(filepath.Join(tempDir, "a", "vendor", "foo", "main.libsonnet"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tempDir, "a", "vendor", "foo", "main.libsonnet"), []byte("modified"), 0644))
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "c", "vendor"), StoreDir: filepath.Join(tempDir, "store")}
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, "c", JsonnetFile), m)
+	assert.NoError(t, err)
+	b, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(b))
+}
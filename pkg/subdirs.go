@@ -0,0 +1,278 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// versionPlaceholderRegex matches the placeholders of a subdir for the tag
+// it is installed from, see ExpandVersion.
+var versionPlaceholderRegex = regexp.MustCompile(`\{(version|major|minor|patch)\}`)
+
+// IsSubdirPattern reports whether the subdir of a git dependency selects
+// several subdirs of the repository, by alternatives in braces like
+// {lib-a,lib-b} or by globs like libs/*. Such a dependency is vendored as
+// one package per matching subdir, all installed from a single clone.
+// Version placeholders are no alternatives.
+func IsSubdirPattern(subdir string) bool {
+	return strings.ContainsAny(versionPlaceholderRegex.ReplaceAllString(subdir, ""), "*?[{")
+}
+
+// HasVersionPlaceholder reports whether subdir depends on the version
+// installed, like release-{version}/lib, see ExpandVersion.
+func HasVersionPlaceholder(subdir string) bool {
+	return versionPlaceholderRegex.MatchString(subdir)
+}
+
+// ExpandVersion replaces the version placeholders of subdir by the tag it
+// is installed from: {version} by the tag itself, and {major}, {minor} and
+// {patch} by the components of a tag that is a semantic version, so that
+// release-{major}.{minor}/lib is release-1.2/lib at tag v1.2.3. This
+// keeps the jsonnetfile of upstreams that move their package with every
+// release unchanged across upgrades.
+func ExpandVersion(subdir, tag string) (string, error) {
+	if !HasVersionPlaceholder(subdir) {
+		return subdir, nil
+	}
+	if tag == "" {
+		return "", fmt.Errorf("subdir %s needs a tag for its version placeholders, use a tag or a version constraint", subdir)
+	}
+
+	var err error
+	expanded := versionPlaceholderRegex.ReplaceAllStringFunc(subdir, func(placeholder string) string {
+		if placeholder == "{version}" {
+			return tag
+		}
+		v, perr := semver.Parse(tag)
+		if perr != nil {
+			err = fmt.Errorf("subdir %s needs a semantic version for %s, tag %s is none", subdir, placeholder, tag)
+			return placeholder
+		}
+		return strconv.Itoa(map[string]int{"{major}": v.Major, "{minor}": v.Minor, "{patch}": v.Patch}[placeholder])
+	})
+	return expanded, err
+}
+
+// SubdirPatterns expands the braces of subdir, which do not nest, into the
+// subdirs or globs they stand for: libs/{a,b}/* becomes libs/a/* and
+// libs/b/*. Version placeholders are kept.
+func SubdirPatterns(subdir string) []string {
+	masked := versionPlaceholderRegex.ReplaceAllStringFunc(subdir, func(placeholder string) string {
+		return strings.Repeat("_", len(placeholder))
+	})
+	open := strings.Index(masked, "{")
+	if open < 0 {
+		return []string{subdir}
+	}
+	end := strings.Index(masked[open:], "}")
+	if end < 0 {
+		return []string{subdir}
+	}
+	end += open
+
+	patterns := []string{}
+	for _, alt := range strings.Split(subdir[open+1:end], ",") {
+		patterns = append(patterns, SubdirPatterns(subdir[:open]+alt+subdir[end+1:])...)
+	}
+	return patterns
+}
+
+// MatchSubdir reports whether subdir is selected by pattern, see
+// IsSubdirPattern.
+func MatchSubdir(pattern, subdir string) bool {
+	for _, p := range SubdirPatterns(pattern) {
+		if ok, _ := path.Match(strings.Trim(p, "/"), subdir); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isGlob(pattern string) bool {
+	return strings.ContainsAny(pattern, "*?[")
+}
+
+func isPatternDependency(dep spec.Dependency) bool {
+	return dep.Source.GitSource != nil && IsSubdirPattern(dep.Source.GitSource.Subdir)
+}
+
+// expandSubdirs replaces the git dependencies of deps with subdir patterns
+// by one dependency per subdir they select. Repositories are cloned to
+// match globs, along with the subdirs of the other dependencies on the
+// same repository at the same version, and the clones added to clones by
+// cloneKey to be shared by all of them. The expanded dependencies are named
+// after their subdirs, qualified if the dependency was.
+func (i *Installer) expandSubdirs(ctx context.Context, wg *sync.WaitGroup, deps []spec.Dependency, from string, clones map[string]*job) ([]spec.Dependency, error) {
+	groups := map[string][]spec.Dependency{}
+	sparse := map[string][]string{}
+	globbed := []string{}
+	for _, dep := range deps {
+		dep = i.lockedDependency(dep)
+		key, ok := i.cloneKey(dep, from)
+		if !ok {
+			continue
+		}
+		groups[key] = append(groups[key], dep)
+		for _, p := range SubdirPatterns(dep.Source.GitSource.Subdir) {
+			sparse[key] = append(sparse[key], p)
+			if isGlob(p) && clones[key] == nil {
+				globbed = append(globbed, key)
+			}
+		}
+	}
+
+	for _, key := range globbed {
+		if clones[key] != nil {
+			continue
+		}
+		d, err := i.startShared(ctx, wg, groups[key], sparse[key])
+		if err != nil {
+			return nil, err
+		}
+		clones[key] = d
+	}
+	for _, key := range globbed {
+		if err := clones[key].wait(); err != nil {
+			return nil, errors.Wrap(err, "failed to install package")
+		}
+	}
+
+	expanded := []spec.Dependency{}
+	for _, dep := range deps {
+		key, ok := i.cloneKey(i.lockedDependency(dep), from)
+		if !ok || !isPatternDependency(dep) {
+			expanded = append(expanded, dep)
+			continue
+		}
+
+		subdirs, err := matchSubdirs(clones[key], dep.Source.GitSource.Subdir)
+		if err != nil {
+			return nil, err
+		}
+		if len(subdirs) == 0 {
+			return nil, fmt.Errorf("no subdir of %s matches %s", dep.Source.GitSource.Remote, dep.Source.GitSource.Subdir)
+		}
+		for _, subdir := range subdirs {
+			d := expandDependency(dep, subdir)
+			// Updating the dependency updates all of its subdirs.
+			if i.only[dep.Name] {
+				i.only[d.Name] = true
+			}
+			expanded = append(expanded, d)
+		}
+		i.progress(dep.Name, StageDone)
+	}
+	return expanded, nil
+}
+
+// matchSubdirs returns the subdirs pattern selects, globs being matched
+// against the directories of the clone d. Hidden directories never match.
+func matchSubdirs(d *job, pattern string) ([]string, error) {
+	subdirs := []string{}
+	seen := map[string]bool{}
+	add := func(subdir string) {
+		if !seen[subdir] {
+			seen[subdir] = true
+			subdirs = append(subdirs, subdir)
+		}
+	}
+
+	for _, p := range SubdirPatterns(pattern) {
+		p = strings.Trim(p, "/")
+		if !isGlob(p) {
+			add(p)
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(d.tmpDir, filepath.FromSlash(p)))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid subdir %s", pattern)
+		}
+		for _, m := range matches {
+			rel, err := filepath.Rel(d.tmpDir, m)
+			if err != nil {
+				return nil, err
+			}
+			rel = filepath.ToSlash(rel)
+			if info, err := os.Stat(m); err != nil || !info.IsDir() || hiddenPath(rel) {
+				continue
+			}
+			add(rel)
+		}
+	}
+	return subdirs, nil
+}
+
+func hiddenPath(p string) bool {
+	for _, segment := range strings.Split(p, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// expandDependency returns the dependency on subdir selected by the pattern
+// of dep.
+func expandDependency(dep spec.Dependency, subdir string) spec.Dependency {
+	source := *dep.Source.GitSource
+	source.Subdir = subdir
+
+	d := dep
+	d.Source = spec.Source{GitSource: &source}
+	d.Name = path.Base(subdir)
+	if dep.Name == QualifiedName(dep) {
+		d.Name = QualifiedName(d)
+	}
+	return d
+}
+
+// ExpandLockedSubdirs replaces the git dependencies of deps with subdir
+// patterns by the dependencies of lock on the subdirs they selected when
+// they were installed. Patterns that selected nothing are kept.
+func ExpandLockedSubdirs(deps, lock []spec.Dependency) []spec.Dependency {
+	expanded := []spec.Dependency{}
+	for _, dep := range deps {
+		if !isPatternDependency(dep) {
+			expanded = append(expanded, dep)
+			continue
+		}
+
+		matched := false
+		for _, l := range lock {
+			if l.Source.GitSource != nil && l.Source.GitSource.Remote == dep.Source.GitSource.Remote && MatchSubdir(dep.Source.GitSource.Subdir, l.Source.GitSource.Subdir) {
+				expanded = append(expanded, expandDependency(dep, l.Source.GitSource.Subdir))
+				matched = true
+			}
+		}
+		if !matched {
+			expanded = append(expanded, dep)
+		}
+	}
+	return expanded
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubdirPatterns(t *testing.T) {
+	tests := []struct {
+		subdir   string
+		pattern  bool
+		patterns []string
+	}{
+		{subdir: "lib", patterns: []string{"lib"}},
+		{subdir: "libs/*", pattern: true, patterns: []string{"libs/*"}},
+		{subdir: "{lib-a,lib-b}", pattern: true, patterns: []string{"lib-a", "lib-b"}},
+		{subdir: "libs/{a,b}/{x,y}", pattern: true, patterns: []string{"libs/a/x", "libs/a/y", "libs/b/x", "libs/b/y"}},
+		{subdir: "libs/{a", pattern: true, patterns: []string{"libs/{a"}},
+		{subdir: "release-{version}/lib", patterns: []string{"release-{version}/lib"}},
+		{subdir: "release-{major}/{a,b}", pattern: true, patterns: []string{"release-{major}/a", "release-{major}/b"}},
+	}
+	for _, tc := range tests {
+		assert.Equal(t, tc.pattern, IsSubdirPattern(tc.subdir), tc.subdir)
+		assert.Equal(t, tc.patterns, SubdirPatterns(tc.subdir), tc.subdir)
+	}
+
+	assert.True(t, MatchSubdir("libs/*", "libs/a"))
+	assert.False(t, MatchSubdir("libs/*", "libs/a/b"))
+	assert.True(t, MatchSubdir("{libs/*,other}", "other"))
+}
+
+func TestExpandVersion(t *testing.T) {
+	tests := []struct {
+		subdir, tag, expanded, err string
+	}{
+		{subdir: "lib", expanded: "lib"},
+		{subdir: "release-{version}/lib", tag: "v1.2.3", expanded: "release-v1.2.3/lib"},
+		{subdir: "release-{major}.{minor}/lib-{patch}", tag: "v1.2.3", expanded: "release-1.2/lib-3"},
+		{subdir: "release-{version}/lib", err: "subdir release-{version}/lib needs a tag for its version placeholders, use a tag or a version constraint"},
+		{subdir: "release-{major}/lib", tag: "stable", err: "subdir release-{major}/lib needs a semantic version for {major}, tag stable is none"},
+	}
+	for _, tc := range tests {
+		expanded, err := ExpandVersion(tc.subdir, tc.tag)
+		if tc.err != "" {
+			assert.EqualError(t, err, tc.err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tc.expanded, expanded)
+	}
+}
+
+func TestInstallerVersionPlaceholder(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("release-1/lib/main.libsonnet", "{ v: 1 }")
+	repo.git("tag", "v1.0.0")
+	repo.commit("release-2/lib/main.libsonnet", "{ v: 2 }")
+	repo.git("tag", "v2.0.0")
+	tree := repo.git("rev-parse", "v1.0.0:release-1/lib")
+
+	tempDir, err := ioutil.TempDir("", "jb-subdirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lib := gitDependency("lib", repo.Dir, "^1.0")
+	lib.Source.GitSource.Subdir = "release-{major}/lib"
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{lib}}
+
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, "lib", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", string(content))
+	// The lock keeps the placeholder, along with the tag it is expanded for.
+	assert.Equal(t, "release-{major}/lib", lock.Dependencies[0].Source.GitSource.Subdir)
+	assert.Equal(t, "v1.0.0", lock.Dependencies[0].Tag)
+	assert.Equal(t, tree, lock.Dependencies[0].Tree)
+	assert.NoError(t, CheckLock(m, *lock))
+
+	// Locked commits are expanded for their locked tag.
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+	assert.NoError(t, Verify(i.JsonnetHome, *lock))
+
+	pinned := gitDependency("lib", repo.Dir, "master")
+	pinned.Source.GitSource.Subdir = "release-{major}/lib"
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{pinned}})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "subdir release-{major}/lib needs a tag for its version placeholders")
+}
+
+func TestInstallerSubdirPatterns(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("libs/a/main.libsonnet", "{ a: 1 }")
+	repo.commit("libs/b/main.libsonnet", "{ b: 1 }")
+	repo.commit("libs/.hidden/main.libsonnet", "{}")
+	repo.commit("other/main.libsonnet", "{ other: 1 }")
+	repo.commit("unrelated/main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-subdirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	var mu sync.Mutex
+	clones := 0
+	i := &Installer{
+		JsonnetHome: filepath.Join(tempDir, "vendor"),
+		Progress: func(name string, stage Stage) {
+			mu.Lock()
+			defer mu.Unlock()
+			if stage == StageCloning {
+				clones++
+			}
+		},
+	}
+	libs := gitDependency("*", repo.Dir, "master")
+	libs.Source.GitSource.Subdir = "libs/*"
+	other := gitDependency("other", repo.Dir, "master")
+	other.Source.GitSource.Subdir = "other"
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{libs, other}}
+
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "other"}, names(lock.Dependencies))
+	assert.Equal(t, "libs/a", lock.Dependencies[0].Source.GitSource.Subdir)
+	assert.Equal(t, lock.Dependencies[0].Version, lock.Dependencies[2].Version)
+	// The glob and the other subdir are checked out from a single clone,
+	// reported for both dependencies.
+	assert.Equal(t, 2, clones)
+
+	for _, name := range []string{"a", "b", "other"} {
+		exists, err := FileExists(filepath.Join(i.JsonnetHome, name, "main.libsonnet"))
+		assert.NoError(t, err)
+		assert.True(t, exists, name)
+	}
+	exists, err := FileExists(filepath.Join(i.JsonnetHome, "unrelated"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.NoError(t, CheckLock(m, *lock))
+	assert.NoError(t, Verify(i.JsonnetHome, *lock))
+
+	// The lock holds the expanded dependencies, which share one clone.
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	var wg sync.WaitGroup
+	fetches, err := i.prefetch(ctx, &wg, lock.Dependencies, filepath.Join(tempDir, JsonnetLockFile), map[string]*job{})
+	wg.Wait()
+	removeFetches(fetches)
+	assert.NoError(t, err)
+	assert.True(t, fetches[0].job == fetches[1].job && fetches[1].job == fetches[2].job)
+	assert.True(t, fetches[0].shared)
+
+	none := gitDependency("none", repo.Dir, "master")
+	none.Source.GitSource.Subdir = "{missing/*}"
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{none}})
+	assert.EqualError(t, err, "no subdir of "+repo.Dir+" matches {missing/*}")
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// SumMismatchError is returned when a vendored dependency does not match the
+// digest recorded in the lock file.
+type SumMismatchError struct {
+	Name     string
+	Version  string
+	Expected string
+	Actual   string
+}
+
+func (e *SumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s version %s: lock file has %s, downloaded %s", e.Name, e.Version, e.Expected, e.Actual)
+}
+
+// hashDir returns the base64 encoded SHA256 digest of the tree at dir. The
+// digest covers the path and the content of every file, so renaming a file
+// changes it as well. Symlinks are hashed by their target.
+func hashDir(dir string) (string, error) {
+	files, err := fileSums(dir)
+	if err != nil {
+		return "", err
+	}
+	return hashSums(files), nil
+}
+
+// hashFiles returns the digest hashDir would return for the tree at dir if
+// it held only the files named, see Dependency.Files. Files that are not
+// in the tree are left out.
+func hashFiles(dir string, names []string) (string, error) {
+	files, err := fileSums(dir)
+	if err != nil {
+		return "", err
+	}
+	kept := make(map[string][]byte, len(names))
+	for _, name := range names {
+		if sum, ok := files[name]; ok {
+			kept[name] = sum
+		}
+	}
+	return hashSums(kept), nil
+}
+
+func hashSums(files map[string][]byte) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(h, "%x  %s\n", files[name], name)
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// fileSums returns the SHA256 digest of every file of the tree at dir, by
+// its path relative to dir with forward slashes. Symlinks are hashed by
+// their target.
+func fileSums(dir string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		h := sha256.New()
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			// Targets are hashed with forward slashes, so the digest is the
+			// same on Windows.
+			io.WriteString(h, filepath.ToSlash(target))
+		} else {
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(h, f); err != nil {
+				return err
+			}
+		}
+		files[filepath.ToSlash(rel)] = h.Sum(nil)
+		return nil
+	})
+	return files, err
+}
+
+// expectedSum returns the digest that dep, resolved to lockVersion, must
+// have, which is the one recorded in the lock file for that version. It is
+// empty if there is nothing to verify against. The digest of a sparse lock
+// covers the files it lists, which are returned along with it, nil for all
+// of them otherwise, see Dependency.Files.
+func (i *Installer) expectedSum(dep spec.Dependency, lockVersion string) (string, []string) {
+	sum, files := "", []string(nil)
+	if dep.Sum != "" && dep.Version == lockVersion {
+		sum, files = dep.Sum, dep.Files
+	} else if locked, ok := i.locked[dep.Name]; ok && SameSource(locked.Source, dep.Source) && locked.Version == lockVersion && sameExcludes(locked.Exclude, dep.Exclude) && sameExcludes(locked.Include, dep.Include) && locked.Submodules == dep.Submodules {
+		sum, files = locked.Sum, locked.Files
+	}
+	if sum != "" && i.lockedSparse && files == nil {
+		files = []string{}
+	}
+	return sum, files
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashDir(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "jb-sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	write := func(name, content string) {
+		path := filepath.Join(tempDir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	write("main.libsonnet", "{}")
+	write("lib/util.libsonnet", "{ a: 1 }")
+	first, err := hashDir(tempDir)
+	assert.NoError(t, err)
+
+	again, err := hashDir(tempDir)
+	assert.NoError(t, err)
+	assert.Equal(t, first, again)
+
+	write("lib/util.libsonnet", "{ a: 2 }")
+	changed, err := hashDir(tempDir)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, changed)
+
+	assert.NoError(t, os.Rename(filepath.Join(tempDir, "lib"), filepath.Join(tempDir, "other")))
+	renamed, err := hashDir(tempDir)
+	assert.NoError(t, err)
+	assert.NotEqual(t, changed, renamed)
+}
+
+func TestInstallerSum(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("main.libsonnet", "{ v: 1 }")
+
+	tempDir, err := ioutil.TempDir("", "jb-sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{gitDependency("foo", repo.Dir, "master")}}
+
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), m)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, lock.Dependencies[0].Sum)
+
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.NoError(t, err)
+
+	// A lock file recording a different digest for the same commit fails
+	// the install and leaves the vendored package alone.
+	lock.Dependencies[0].Sum = "tampered"
+	_, err = i.Install(context.TODO(), filepath.Join(tempDir, JsonnetLockFile), *lock)
+	assert.IsType(t, &SumMismatchError{}, err)
+
+	content, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, "foo", "main.libsonnet"))
+	assert.NoError(t, err)
+	assert.Equal(t, "{ v: 1 }", string(content))
+}
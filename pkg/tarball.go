@@ -0,0 +1,113 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// openTarball returns the tarball at url, downloaded to a temporary file
+// that is removed when it is closed. With a cacheDir, the tarball is kept
+// there together with its ETag instead, and the kept one is returned if the
+// server reports that it did not change, so that a ref that did not move
+// is not downloaded again.
+func openTarball(ctx context.Context, caFile, cacheDir, url string) (io.ReadCloser, error) {
+	if cacheDir == "" {
+		f, err := ioutil.TempFile("", "jsonnetpkg-tarball")
+		if err != nil {
+			return nil, err
+		}
+		t := tempFile{f}
+		if _, err := download(ctx, caFile, url, f); err != nil {
+			t.Close()
+			return nil, err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Close()
+			return nil, err
+		}
+		return t, nil
+	}
+
+	key := sha256.Sum256([]byte(url))
+	filename := filepath.Join(cacheDir, hex.EncodeToString(key[:])+".tar.gz")
+	header := http.Header{}
+	if etag, err := ioutil.ReadFile(filename + ".etag"); err == nil {
+		if exists, _ := FileExists(filename); exists {
+			header.Set("If-None-Match", string(etag))
+		}
+	}
+
+	resp, err := get(ctx, caFile, url, header)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return os.Open(filename)
+	}
+
+	if err := os.MkdirAll(cacheDir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(cacheDir, "tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	if err := transfer(ctx, caFile, url, nil, resp, f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	// Concurrent installs of the same tarball replace it with the same
+	// content.
+	if err := os.Rename(f.Name(), filename); err != nil {
+		return nil, errors.Wrap(err, "failed to cache tarball")
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		err = ioutil.WriteFile(filename+".etag", []byte(etag), 0644)
+	} else {
+		err = os.Remove(filename + ".etag")
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "failed to cache tarball")
+	}
+
+	return os.Open(filename)
+}
+
+// tempFile is a temporary file that is removed when it is closed.
+type tempFile struct {
+	*os.File
+}
+
+func (f tempFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CAFileEnv names a PEM file of the certificate authorities trusted for
+// HTTPS, for networks with TLS intercepting proxies.
+const CAFileEnv = "JB_CA_FILE"
+
+// httpClient returns the client downloading packages. Like git, it goes
+// through the proxies of HTTPS_PROXY and HTTP_PROXY, except for the hosts of
+// NO_PROXY. If caFile is set, its certificates are trusted instead of the
+// system ones.
+func httpClient(caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return http.DefaultClient, nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read CA file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in CA file %s", caFile)
+	}
+
+	// The settings of http.DefaultTransport, with the certificates.
+	return &http.Client{Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       &tls.Config{RootCAs: pool},
+	}}, nil
+}
+
+// gitCAArgs returns the git arguments trusting the certificates of caFile,
+// if set, instead of the system ones.
+func gitCAArgs(caFile string) []string {
+	if caFile == "" {
+		return nil
+	}
+	return []string{"-c", "http.sslCAInfo=" + caFile}
+}
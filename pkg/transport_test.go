@@ -0,0 +1,58 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadCAFile(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("intercepted"))
+	}))
+	defer srv.Close()
+
+	tempDir, err := ioutil.TempDir("", "jb-transport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The certificate of the server is not signed by a system authority.
+	var buf bytes.Buffer
+	_, err = download(context.TODO(), "", srv.URL, &buf)
+	assert.Error(t, err)
+
+	caFile := filepath.Join(tempDir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caFile, cert, 0644))
+	_, err = download(context.TODO(), caFile, srv.URL, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "intercepted", buf.String())
+
+	assert.NoError(t, ioutil.WriteFile(caFile, []byte("garbage"), 0644))
+	_, err = download(context.TODO(), caFile, srv.URL, &buf)
+	assert.EqualError(t, err, "no certificates found in CA file "+caFile)
+}
@@ -0,0 +1,114 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/jsonnetfile"
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// UnmanagedFile records the packages of the vendor directory that are
+// neither in the jsonnetfile nor in the lock file, like those vendored by
+// jb install --single. It is hidden, so it is never taken for a package.
+const UnmanagedFile = ".unmanaged.json"
+
+// LoadUnmanaged returns the unmanaged packages recorded in jsonnetHome, as
+// the dependencies of a lock.
+func LoadUnmanaged(jsonnetHome string) (spec.JsonnetFile, error) {
+	m, err := LoadJsonnetfile(filepath.Join(jsonnetHome, UnmanagedFile))
+	if os.IsNotExist(err) {
+		return spec.JsonnetFile{Dependencies: []spec.Dependency{}}, nil
+	}
+	if err != nil {
+		return m, errors.Wrapf(err, "failed to load %s", UnmanagedFile)
+	}
+	return m, nil
+}
+
+// MarkUnmanaged records deps, as locked by an install, as unmanaged
+// packages of jsonnetHome, replacing the records of the same names.
+func MarkUnmanaged(jsonnetHome string, deps []spec.Dependency) error {
+	m, err := LoadUnmanaged(jsonnetHome)
+	if err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for _, d := range deps {
+		names[d.Name] = true
+	}
+	kept := []spec.Dependency{}
+	for _, d := range m.Dependencies {
+		if !names[d.Name] {
+			kept = append(kept, d)
+		}
+	}
+	m.Dependencies = append(kept, deps...)
+	return writeUnmanaged(jsonnetHome, m)
+}
+
+// forgetUnmanaged drops the records of the unmanaged packages of
+// jsonnetHome named names, because they were pruned or installed again.
+// The record is removed once it is empty.
+func forgetUnmanaged(jsonnetHome string, names map[string]bool) error {
+	m, err := LoadUnmanaged(jsonnetHome)
+	if err != nil || len(m.Dependencies) == 0 {
+		return err
+	}
+
+	kept := []spec.Dependency{}
+	for _, d := range m.Dependencies {
+		if !names[d.Name] {
+			kept = append(kept, d)
+		}
+	}
+	if len(kept) == len(m.Dependencies) {
+		return nil
+	}
+	m.Dependencies = kept
+	return writeUnmanaged(jsonnetHome, m)
+}
+
+func writeUnmanaged(jsonnetHome string, m spec.JsonnetFile) error {
+	filename := filepath.Join(jsonnetHome, UnmanagedFile)
+	if len(m.Dependencies) == 0 {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := jsonnetfile.Write(filename, m); err != nil {
+		return errors.Wrapf(err, "failed to write %s", UnmanagedFile)
+	}
+	return nil
+}
+
+// unmanagedNames returns the names of the unmanaged packages recorded in
+// jsonnetHome, see UnmanagedFile.
+func unmanagedNames(jsonnetHome string) (map[string]bool, error) {
+	m, err := LoadUnmanaged(jsonnetHome)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for _, d := range m.Dependencies {
+		names[filepath.ToSlash(d.Name)] = true
+	}
+	return names, nil
+}
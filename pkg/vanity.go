@@ -0,0 +1,200 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// goImport is a go-import meta tag, telling that the import paths below
+// Prefix are in the VCS repository at Root.
+type goImport struct {
+	Prefix, VCS, Root string
+}
+
+// IsImportPath reports whether the git remote is an import path like
+// libs.example.com/jsonnet/foo, which is resolved to the remote of the
+// repository it is in by the go-import meta tag its host serves, the way
+// the go command does for vanity import paths, see ResolveImport. Remotes
+// with a scheme, ssh remotes and local paths are none.
+func IsImportPath(remote string) bool {
+	if strings.Contains(remote, ":") || strings.HasPrefix(remote, ".") || strings.HasPrefix(remote, "~") || filepath.IsAbs(remote) || hasVariables(remote) {
+		return false
+	}
+	i := strings.Index(remote, "/")
+	return i > 0 && strings.Contains(remote[:i], ".")
+}
+
+// resolveImports replaces the dependencies of deps on import paths, see
+// IsImportPath, by dependencies on the repositories they are in. Those
+// kept at their locked version by an Update are resolved from the lock.
+func (i *Installer) resolveImports(ctx context.Context, deps []spec.Dependency) ([]spec.Dependency, error) {
+	res := make([]spec.Dependency, 0, len(deps))
+	for _, d := range deps {
+		if d.Source.GitSource == nil || !IsImportPath(d.Source.GitSource.Remote) {
+			res = append(res, d)
+			continue
+		}
+		if l, ok := i.locked[d.Name]; ok && i.keepLocked(d.Name) && l.Import == strings.TrimSuffix(d.Source.GitSource.Remote, "/") {
+			res = append(res, lockedImport(d, l))
+			continue
+		}
+
+		if i.Offline {
+			return nil, &OfflineError{Name: d.Name, Reason: fmt.Sprintf("the import path %s is not locked", d.Source.GitSource.Remote)}
+		}
+		resolved, err := ResolveImport(ctx, i.CAFile, d)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, resolved)
+	}
+	return res, nil
+}
+
+// ResolveImport returns dep, a dependency on an import path, see
+// IsImportPath, with the source of the repository the go-import meta tags
+// served at https://<import path>?go-get=1 point to, git or Mercurial. The
+// rest of the import path below the prefix of the repository is prepended
+// to the subdir, and the import path recorded in Import.
+func ResolveImport(ctx context.Context, caFile string, dep spec.Dependency) (spec.Dependency, error) {
+	importPath := strings.TrimSuffix(dep.Source.GitSource.Remote, "/")
+	imp, err := lookupImport(ctx, caFile, importPath)
+	if err != nil {
+		return spec.Dependency{}, errors.Wrapf(err, "failed to resolve the import path %s of %s", importPath, dep.Name)
+	}
+
+	subdir := path.Join(strings.Trim(strings.TrimPrefix(importPath, imp.Prefix), "/"), dep.Source.GitSource.Subdir)
+	switch imp.VCS {
+	case "git":
+		dep.Source = spec.Source{GitSource: &spec.GitSource{Remote: imp.Root, Subdir: subdir}}
+	case "hg":
+		dep.Source = spec.Source{HgSource: &spec.HgSource{Remote: imp.Root, Subdir: subdir}}
+	}
+	dep.Import = importPath
+	return dep, nil
+}
+
+// lookupImport returns the go-import meta tag served for importPath whose
+// prefix it is in.
+func lookupImport(ctx context.Context, caFile, importPath string) (goImport, error) {
+	rawurl := "https://" + importPath + "?go-get=1"
+	resp, err := get(ctx, caFile, rawurl, nil)
+	if err != nil {
+		return goImport{}, err
+	}
+	defer resp.Body.Close()
+
+	imports, err := parseGoImports(resp.Body)
+	if err != nil {
+		return goImport{}, errors.Wrapf(err, "failed to parse %s", rawurl)
+	}
+	for _, imp := range imports {
+		if imp.Prefix != importPath && !strings.HasPrefix(importPath, imp.Prefix+"/") {
+			continue
+		}
+		switch {
+		case imp.VCS != "git" && imp.VCS != "hg":
+			return goImport{}, fmt.Errorf("%s is in a repository of the VCS %s, only git and hg are supported", importPath, imp.VCS)
+		case IsImportPath(imp.Root):
+			return goImport{}, fmt.Errorf("%s redirects to the import path %s instead of a repository", importPath, imp.Root)
+		}
+		return imp, nil
+	}
+	return goImport{}, &NotFoundError{Err: fmt.Errorf("%s serves no go-import meta tag for %s", rawurl, importPath)}
+}
+
+// parseGoImports returns the go-import meta tags in the head of the HTML
+// document r, ignoring those of Go module proxies, like the go command.
+func parseGoImports(r io.Reader) ([]goImport, error) {
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+	// Meta tags are ASCII, whatever the charset of the page.
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	imports := []goImport{}
+	for {
+		t, err := d.RawToken()
+		if err != nil {
+			if err == io.EOF || len(imports) > 0 {
+				return imports, nil
+			}
+			return nil, err
+		}
+		if e, ok := t.(xml.StartElement); ok && strings.EqualFold(e.Name.Local, "body") {
+			return imports, nil
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return imports, nil
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok || !strings.EqualFold(e.Name.Local, "meta") || attrValue(e.Attr, "name") != "go-import" {
+			continue
+		}
+		if f := strings.Fields(attrValue(e.Attr, "content")); len(f) == 3 && f[1] != "mod" {
+			imports = append(imports, goImport{Prefix: f[0], VCS: f[1], Root: f[2]})
+		}
+	}
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// lockedImport returns dep, a dependency on an import path, with the
+// source it was resolved to when l was locked.
+func lockedImport(dep, l spec.Dependency) spec.Dependency {
+	dep.Source = l.Source
+	dep.Import = l.Import
+	return dep
+}
+
+// ExpandLockedImports replaces the dependencies of deps on import paths,
+// see IsImportPath, by the sources the dependencies of lock of the same
+// name were resolved to when they were installed, without resolving them
+// again. Import paths that are not locked are kept.
+func ExpandLockedImports(deps, lock []spec.Dependency) []spec.Dependency {
+	locked := make(map[string]spec.Dependency, len(lock))
+	for _, l := range lock {
+		locked[l.Name] = l
+	}
+
+	expanded := make([]spec.Dependency, 0, len(deps))
+	for _, d := range deps {
+		if l, ok := locked[d.Name]; ok && d.Source.GitSource != nil && l.Import != "" && l.Import == strings.TrimSuffix(d.Source.GitSource.Remote, "/") {
+			d = lockedImport(d, l)
+		}
+		expanded = append(expanded, d)
+	}
+	return expanded
+}
@@ -0,0 +1,143 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsImportPath(t *testing.T) {
+	for remote, ok := range map[string]bool{
+		"libs.example.com/jsonnet/foo":   true,
+		"example.com/foo":                true,
+		"https://example.com/foo":        false,
+		"git@github.com:org/repo":        false,
+		"/srv/git/repo":                  false,
+		"./libs/repo":                    false,
+		"../repo":                        false,
+		"repo":                           false,
+		"libs/repo":                      false,
+		"${GIT_HOST}/jsonnet/foo":        false,
+		"example.com:8443/jsonnet/foo":   false,
+		"hg.example.com/jsonnet/foo.git": true,
+	} {
+		assert.Equal(t, ok, IsImportPath(remote), remote)
+	}
+}
+
+func TestParseGoImports(t *testing.T) {
+	doc := `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="iso-8859-1">
+<meta name="go-import" content="libs.example.com/jsonnet git https://github.com/example/jsonnet">
+<meta name="go-import" content="libs.example.com/jsonnet mod https://proxy.example.com">
+<meta name="go-source" content="libs.example.com/jsonnet https://github.com/example/jsonnet _ _">
+<META NAME="go-import" CONTENT="libs.example.com/hg hg https://hg.example.com/hg">
+</head>
+<body>
+<meta name="go-import" content="libs.example.com/body git https://github.com/example/body">
+</body>
+</html>`
+	imports, err := parseGoImports(strings.NewReader(doc))
+	assert.NoError(t, err)
+	assert.Equal(t, []goImport{
+		{Prefix: "libs.example.com/jsonnet", VCS: "git", Root: "https://github.com/example/jsonnet"},
+		{Prefix: "libs.example.com/hg", VCS: "hg", Root: "https://hg.example.com/hg"},
+	}, imports)
+}
+
+func TestResolveImport(t *testing.T) {
+	var host string
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("go-get") != "1" {
+			http.NotFound(w, r)
+			return
+		}
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/jsonnet"):
+			fmt.Fprintf(w, `<meta name="go-import" content="%s/jsonnet git https://github.com/example/jsonnet">`, host)
+		case strings.HasPrefix(r.URL.Path, "/hg"):
+			fmt.Fprintf(w, `<meta name="go-import" content="%s/hg hg https://hg.example.com/hg">`, host)
+		case strings.HasPrefix(r.URL.Path, "/svn"):
+			fmt.Fprintf(w, `<meta name="go-import" content="%s/svn svn https://svn.example.com/svn">`, host)
+		default:
+			w.Write([]byte("<html></html>"))
+		}
+	}))
+	defer srv.Close()
+	host = strings.TrimPrefix(srv.URL, "https://")
+
+	tempDir, err := ioutil.TempDir("", "jb-vanity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	caFile := filepath.Join(tempDir, "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caFile, cert, 0644))
+
+	dep := gitDependency("foo", host+"/jsonnet/lib/foo", "v1")
+	dep.Source.GitSource.Subdir = "src"
+	resolved, err := ResolveImport(context.TODO(), caFile, dep)
+	assert.NoError(t, err)
+	assert.Equal(t, spec.Dependency{
+		Name:    "foo",
+		Source:  spec.Source{GitSource: &spec.GitSource{Remote: "https://github.com/example/jsonnet", Subdir: "lib/foo/src"}},
+		Version: "v1",
+		Import:  host + "/jsonnet/lib/foo",
+	}, resolved)
+
+	resolved, err = ResolveImport(context.TODO(), caFile, gitDependency("hg", host+"/hg", ""))
+	assert.NoError(t, err)
+	assert.Equal(t, spec.Source{HgSource: &spec.HgSource{Remote: "https://hg.example.com/hg"}}, resolved.Source)
+
+	_, err = ResolveImport(context.TODO(), caFile, gitDependency("svn", host+"/svn", ""))
+	assert.EqualError(t, err, "failed to resolve the import path "+host+"/svn of svn: "+host+"/svn is in a repository of the VCS svn, only git and hg are supported")
+
+	_, err = ResolveImport(context.TODO(), caFile, gitDependency("none", host+"/none", ""))
+	assert.EqualError(t, err, "failed to resolve the import path "+host+"/none of none: https://"+host+"/none?go-get=1 serves no go-import meta tag for "+host+"/none")
+}
+
+func TestExpandLockedImports(t *testing.T) {
+	m := spec.JsonnetFile{Dependencies: []spec.Dependency{
+		gitDependency("foo", "libs.example.com/jsonnet/foo", "v1"),
+		gitDependency("bar", "libs.example.com/jsonnet/bar", "v1"),
+	}}
+	locked := gitDependency("foo", "https://github.com/example/jsonnet", "0123456789abcdef0123456789abcdef01234567")
+	locked.Source.GitSource.Subdir = "foo"
+	locked.Import = "libs.example.com/jsonnet/foo"
+	lock := spec.JsonnetFile{Dependencies: []spec.Dependency{locked}}
+
+	expanded := ExpandLockedImports(m.Dependencies, lock.Dependencies)
+	assert.Equal(t, locked.Source, expanded[0].Source)
+	assert.Equal(t, m.Dependencies[1], expanded[1])
+
+	assert.EqualError(t, CheckLock(m, lock), "jsonnetfile.lock.json is out of sync with jsonnetfile.json: bar is not locked")
+	m.Dependencies = m.Dependencies[:1]
+	assert.NoError(t, CheckLock(m, lock))
+}
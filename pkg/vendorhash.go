@@ -0,0 +1,76 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/pkg/errors"
+)
+
+// HashFile records the hash of the jsonnetfile and the lock file the vendor
+// directory was installed from, see VendorHash. It is hidden, so it is
+// never taken for a package, and removed by every install, so that it only
+// describes the vendor directory of the last install of the whole lock.
+const HashFile = ".jb-hash"
+
+// VendorHash returns the hash of the contents of files, the jsonnetfile
+// and the lock file, along with the options of i that change what is
+// vendored from them. Missing files hash as empty.
+func (i *Installer) VendorHash(files ...string) (string, error) {
+	h := sha256.New()
+	for _, f := range files {
+		data, err := ioutil.ReadFile(f)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", filepath.Base(f), len(data))
+		h.Write(data)
+	}
+	fmt.Fprintf(h, "jb %s\n", spec.JBVersion)
+	fmt.Fprintf(h, "strip %s docs %t\n", strings.Join(i.Strip, ","), i.StripDocs)
+	fmt.Fprintf(h, "subdirs %t qualified %t\n", i.PreserveSubdirs, i.QualifiedNames)
+	fmt.Fprintf(h, "prune %t reproducible %t hooks %t\n", i.Prune, i.Reproducible, i.AllowHooks)
+	// A vendor directory is only up to date if it was vendored under the
+	// same policy.
+	if p := i.Policy; p != nil {
+		fmt.Fprintf(h, "policy %q %q %q %q %q\n", p.AllowSources, p.DenySources, p.AllowLicenses, p.DenyLicenses, p.Hook)
+	}
+	return "sha256-" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// UpToDate reports whether the vendor directory was installed from files
+// hashing to hash, see VendorHash, and still matches lock, see Verify. An
+// install of lock would not change it then.
+func (i *Installer) UpToDate(hash string, lock spec.JsonnetFile) bool {
+	data, err := ioutil.ReadFile(filepath.Join(i.JsonnetHome, HashFile))
+	if err != nil || strings.TrimSpace(string(data)) != hash {
+		return false
+	}
+	return Verify(i.JsonnetHome, lock) == nil
+}
+
+// WriteVendorHash records hash in the vendor directory.
+func (i *Installer) WriteVendorHash(hash string) error {
+	err := ioutil.WriteFile(filepath.Join(i.JsonnetHome, HashFile), []byte(hash+"\n"), 0644)
+	return errors.Wrapf(err, "failed to write %s", HashFile)
+}
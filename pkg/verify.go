@@ -0,0 +1,166 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// VendorMismatchError is returned by Verify when the vendor directory does
+// not match the lock file.
+type VendorMismatchError struct {
+	// Diff holds one line per difference: "- name" for a missing package,
+	// "~ name" for a modified one and "+ name" for an extraneous directory.
+	Diff []string
+	// Broken holds the names of the packages of the lock that are missing
+	// or modified, in the order of the lock.
+	Broken []string
+}
+
+func (e *VendorMismatchError) Error() string {
+	return fmt.Sprintf("vendor directory does not match %s: %d differences", JsonnetLockFile, len(e.Diff))
+}
+
+// Verify checks that jsonnetHome holds exactly the packages of lock, with
+// the recorded digests, and returns a VendorMismatchError otherwise.
+// Packages without digest, like local ones, only need to exist.
+func Verify(jsonnetHome string, lock spec.JsonnetFile) error {
+	diff := []string{}
+	var broken []string
+	for _, d := range lock.Dependencies {
+		dir := VendorPath(jsonnetHome, d)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			diff = append(diff, fmt.Sprintf("- %s: missing (%s %s)", d.Name, SourceString(d.Source), d.Version))
+			broken = append(broken, d.Name)
+			continue
+		} else if err != nil {
+			return err
+		}
+		if d.Sum == "" {
+			continue
+		}
+
+		sum, err := hashDir(dir)
+		if err != nil {
+			return err
+		}
+		if sum != d.Sum {
+			diff = append(diff, fmt.Sprintf("~ %s: modified, digest is %s instead of %s", d.Name, sum, d.Sum))
+			broken = append(broken, d.Name)
+		}
+	}
+
+	stray, err := extraneous(jsonnetHome, lock)
+	if err != nil {
+		return err
+	}
+	unmanaged, err := unmanagedNames(jsonnetHome)
+	if err != nil {
+		return err
+	}
+	for _, name := range stray {
+		if unmanaged[name] {
+			diff = append(diff, fmt.Sprintf("+ %s: unmanaged, not in %s", name, JsonnetLockFile))
+		} else {
+			diff = append(diff, fmt.Sprintf("+ %s: not in %s", name, JsonnetLockFile))
+		}
+	}
+
+	if len(diff) > 0 {
+		return &VendorMismatchError{Diff: diff, Broken: broken}
+	}
+	return nil
+}
+
+// extraneous returns the sorted names of the entries of jsonnetHome that
+// belong to no package of lock, nor are links to one, see legacyLinks, nor
+// are the Kubernetes libraries it generates.
+// Directories holding qualified packages, like github.com, are searched
+// for extraneous entries themselves. Hidden entries, like the temporary
+// directory of the installer, are left out.
+func extraneous(jsonnetHome string, lock spec.JsonnetFile) ([]string, error) {
+	known := map[string]bool{}
+	parents := map[string]bool{}
+	for _, d := range lock.Dependencies {
+		name := filepath.ToSlash(d.Name)
+		known[name] = true
+		segments := strings.Split(name, "/")
+		for n := 1; n < len(segments); n++ {
+			parents[strings.Join(segments[:n], "/")] = true
+		}
+	}
+	for short := range legacyLinks(lock) {
+		known[short] = true
+	}
+	// The Kubernetes libraries of versions no longer configured are stale.
+	if k := lock.Kubernetes; k != nil && len(k.Versions) > 0 {
+		output := kubernetesOutput(*k)
+		segments := strings.Split(output, "/")
+		for n := 1; n <= len(segments); n++ {
+			parents[strings.Join(segments[:n], "/")] = true
+		}
+		for _, version := range k.Versions {
+			known[output+"/"+version] = true
+		}
+	}
+
+	names := []string{}
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		entries, err := ioutil.ReadDir(filepath.Join(jsonnetHome, filepath.FromSlash(dir)))
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			name := path.Join(dir, e.Name())
+			switch {
+			case known[name] || strings.HasPrefix(e.Name(), "."):
+			case parents[name] && e.IsDir():
+				if err := walk(name); err != nil {
+					return err
+				}
+			default:
+				names = append(names, name)
+			}
+		}
+		return nil
+	}
+
+	if err := walk(""); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// VendorPath returns the directory the content of dep was vendored to,
+// which includes its subdir if the lock records it was not flattened.
+func VendorPath(jsonnetHome string, dep spec.Dependency) string {
+	p := filepath.Join(jsonnetHome, dep.Name)
+	if dep.Flatten != nil && !*dep.Flatten {
+		p = filepath.Join(p, sourceSubdir(dep.Source))
+	}
+	return p
+}
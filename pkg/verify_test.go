@@ -0,0 +1,62 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pkg
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerify(t *testing.T) {
+	repo := newTestRepo(t)
+	defer repo.Close()
+	repo.commit("lib/main.libsonnet", "{}")
+
+	tempDir, err := ioutil.TempDir("", "jb-verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	foo := gitDependency("foo", repo.Dir, "master")
+	bar := gitDependency("bar", repo.Dir, "master")
+	bar.Source.GitSource.Subdir = "lib"
+	flatten := false
+	bar.Flatten = &flatten
+
+	i := &Installer{JsonnetHome: filepath.Join(tempDir, "vendor")}
+	lock, err := i.Install(context.TODO(), filepath.Join(tempDir, JsonnetFile), spec.JsonnetFile{Dependencies: []spec.Dependency{foo, bar}})
+	assert.NoError(t, err)
+	assert.NoError(t, Verify(i.JsonnetHome, *lock))
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(i.JsonnetHome, "foo", "lib", "main.libsonnet"), []byte("{ edited: true }"), 0644))
+	assert.NoError(t, os.RemoveAll(filepath.Join(i.JsonnetHome, "bar")))
+	assert.NoError(t, os.MkdirAll(filepath.Join(i.JsonnetHome, "stray"), os.ModePerm))
+
+	err = Verify(i.JsonnetHome, *lock)
+	assert.IsType(t, &VendorMismatchError{}, err)
+	diff := err.(*VendorMismatchError).Diff
+	assert.Len(t, diff, 3)
+	assert.Contains(t, diff[0], "~ foo: modified")
+	assert.Contains(t, diff[1], "- bar: missing")
+	assert.Equal(t, "+ stray: not in jsonnetfile.lock.json", diff[2])
+	assert.Equal(t, []string{"foo", "bar"}, err.(*VendorMismatchError).Broken)
+}
@@ -0,0 +1,156 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+)
+
+// LatestVersion is the newest version of the format that is understood,
+// files of newer versions are rejected instead of being misread.
+const LatestVersion = QualifiedVersion
+
+// UnsupportedVersionError is returned by Parse for files of a version newer
+// than LatestVersion, written by a newer jsonnet-bundler.
+type UnsupportedVersionError struct {
+	Version int
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("version %d of the jsonnetfile format is not supported, the latest supported version is %d: upgrade jsonnet-bundler", e.Version, LatestVersion)
+}
+
+// JBVersion is the version of jsonnet-bundler checked against the jb
+// requirement of files, set by jb from its build. Builds whose version is
+// no semantic version, like development builds, satisfy any requirement.
+var JBVersion = ""
+
+// Features are the features of the format files can require, see
+// JsonnetFile.Features.
+var Features = []string{
+	"annotations",
+	"archive-sources",
+	"custom-sources",
+	"excludes",
+	"frozen",
+	"hg-sources",
+	"hooks",
+	"includes",
+	"kubernetes-libraries",
+	"local-sources",
+	"oci-sources",
+	"release-assets",
+	"remote-variables",
+	"replace",
+	"signed-tags",
+	"sparse",
+	"submodules",
+	"subdir-patterns",
+	"targets",
+	"transports",
+	"version-placeholders",
+	"workspaces",
+}
+
+// UnsupportedRequirementError is returned by Parse for files requiring a
+// newer jsonnet-bundler than JBVersion, or features it does not support.
+type UnsupportedRequirementError struct {
+	// JB is the version constraint JBVersion does not satisfy, empty if
+	// it does.
+	JB       string
+	Features []string
+}
+
+func (e *UnsupportedRequirementError) Error() string {
+	requires := []string{}
+	if e.JB != "" {
+		requires = append(requires, fmt.Sprintf("jsonnet-bundler %s, this is %s", e.JB, JBVersion))
+	}
+	if len(e.Features) > 0 {
+		requires = append(requires, fmt.Sprintf("the features %s, which are not supported", strings.Join(e.Features, ", ")))
+	}
+	return fmt.Sprintf("the jsonnetfile requires %s: upgrade jsonnet-bundler", strings.Join(requires, " and "))
+}
+
+// checkRequirements returns an UnsupportedRequirementError if jb does not
+// satisfy the constraint or support the features of a file. Invalid
+// constraints are left to validate.
+func checkRequirements(constraint string, features []string) error {
+	e := &UnsupportedRequirementError{}
+	if v, err := semver.Parse(JBVersion); constraint != "" && err == nil {
+		// Builds past a release, like v0.5.0-3-g0123abc, count as it.
+		v.Pre = ""
+		if c, err := semver.ParseConstraint(constraint); err == nil && !c.Check(v) {
+			e.JB = constraint
+		}
+	}
+
+	supported := map[string]bool{}
+	for _, f := range Features {
+		supported[f] = true
+	}
+	for _, f := range features {
+		if !supported[f] {
+			e.Features = append(e.Features, f)
+		}
+	}
+
+	if e.JB == "" && len(e.Features) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Parse decodes a jsonnetfile or lock file of any version up to
+// LatestVersion. Files written before the format was versioned have no
+// version and are read as LegacyVersion. Files with unknown fields, values
+// of the wrong type or duplicate dependencies fail with a ValidationError,
+// and files requiring a newer jsonnet-bundler or features it does not
+// support with an UnsupportedRequirementError.
+func Parse(b []byte) (JsonnetFile, error) {
+	var header struct {
+		Version  int      `json:"version"`
+		JB       string   `json:"jb"`
+		Features []string `json:"features"`
+	}
+	if err := json.Unmarshal(b, &header); err != nil {
+		if verr := validate(b); verr != nil {
+			return JsonnetFile{}, verr
+		}
+		return JsonnetFile{}, err
+	}
+	if header.Version < LegacyVersion || header.Version > LatestVersion {
+		return JsonnetFile{}, &UnsupportedVersionError{Version: header.Version}
+	}
+	// Requirements are checked first, files needing a newer
+	// jsonnet-bundler likely have fields this one does not know.
+	if err := checkRequirements(header.JB, header.Features); err != nil {
+		return JsonnetFile{}, err
+	}
+
+	if err := validate(b); err != nil {
+		return JsonnetFile{}, err
+	}
+
+	m := JsonnetFile{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return JsonnetFile{}, err
+	}
+	return m, nil
+}
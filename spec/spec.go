@@ -14,12 +14,137 @@
 
 package spec
 
+// Versions of the jsonnetfile format.
+const (
+	// LegacyVersion vendors every dependency under its short name, like
+	// vendor/utils for github.com/a/utils.
+	LegacyVersion = 0
+	// QualifiedVersion names git dependencies after their host, repository
+	// and subdir, vendoring github.com/a/utils into vendor/github.com/a/utils
+	// so that it cannot collide with github.com/b/utils.
+	QualifiedVersion = 1
+)
+
+// DefaultGroup is the group of the dependencies that list no groups, see
+// Dependency.Groups.
+const DefaultGroup = "prod"
+
 type JsonnetFile struct {
+	// Version is the version of the format, LegacyVersion if not set.
+	Version int `json:"version,omitempty"`
+	// JB is the version constraint jsonnet-bundler must satisfy to read
+	// the file, like >=0.5, and Features lists the features it must
+	// support, from Features. The lock file keeps those of the project.
+	JB       string   `json:"jb,omitempty"`
+	Features []string `json:"features,omitempty"`
+	// Name is the name of the package, informational only: dependents
+	// vendor it under the name they choose.
+	Name string `json:"name,omitempty"`
+	// LegacyName is a former name of the package, for importers still
+	// using it.
+	LegacyName   string       `json:"legacyName,omitempty"`
+	Dependencies []Dependency `json:"dependencies"`
+	// Workspace lists the directories of the subprojects sharing the vendor
+	// directory and lock file of this project in a workspace install,
+	// globs like envs/* allowed.
+	Workspace []string `json:"workspace,omitempty"`
+	// Hooks are run after installing, when allowed: those of a package in
+	// its vendor directory once it was vendored, those of the project in
+	// its directory once all dependencies were.
+	Hooks []Hook `json:"hooks,omitempty"`
+	// TrustedKeys are the files of the armored OpenPGP public keys trusted
+	// to sign the tags of dependencies to verify, relative to the
+	// jsonnetfile. Only those of the project are used, and they are kept in
+	// its lock file.
+	TrustedKeys []string `json:"trustedKeys,omitempty"`
+	// Strip lists the exclude patterns of the files that were removed from
+	// every vendored package, set in lock files only: the digests of the
+	// packages are computed without these files.
+	Strip []string `json:"strip,omitempty"`
+	// Sparse vendors only the files of the packages that the project
+	// imports, directly or through other vendored files, instead of whole
+	// packages, see Dependency.Files. Only that of the project is used, and
+	// it is kept in its lock file.
+	Sparse bool `json:"sparse,omitempty"`
+	// Replace substitutes the source or version of dependencies wherever
+	// they are required, without changing the jsonnetfiles requiring them.
+	// Only those of the project are used, and they are kept in its lock
+	// file.
+	Replace []Replace `json:"replace,omitempty"`
+	// Kubernetes configures the Kubernetes libraries generated into the
+	// vendor directory after installing. Only that of the project is used,
+	// and it is kept in its lock file.
+	Kubernetes *Kubernetes `json:"kubernetes,omitempty"`
+	// Targets are named sets of dependencies, like those of an environment
+	// or of a generation of clusters, installed instead of Dependencies
+	// with jb install --target. The lock file records the dependencies
+	// each target installed, separately from those of the project.
+	Targets map[string]Target `json:"targets,omitempty"`
+}
+
+// Target is a named set of dependencies of a project, see
+// JsonnetFile.Targets.
+type Target struct {
+	// Dependencies are installed along with those of the project, replacing
+	// the dependencies of the project of the same name, so that a target
+	// can require another version of them.
 	Dependencies []Dependency `json:"dependencies"`
+	// JsonnetHome is the directory the target is vendored into, relative to
+	// the project, like vendor/prod. The target replaces the contents of
+	// the vendor directory of the project if it is empty.
+	JsonnetHome string `json:"vendor,omitempty"`
+}
+
+// Kubernetes configures the generation of a Kubernetes library, like
+// k8s-libsonnet, for each of Versions from the OpenAPI spec of the version.
+type Kubernetes struct {
+	// Versions are the Kubernetes versions to generate a library for, a
+	// minor version like 1.29 for the latest spec of its release branch or
+	// a patch version like 1.29.3 for the spec of that release.
+	Versions []string `json:"versions"`
+	// Generator is the command generating a library, with {version}
+	// replaced by the version, {spec} by the file of its OpenAPI spec and
+	// {output} by the directory to generate into. It defaults to
+	// ksonnet-gen {spec} {output}.
+	Generator []string `json:"generator,omitempty"`
+	// OpenAPI is the URL of the OpenAPI spec of a version, with {ref}
+	// replaced by the git ref of the version in the Kubernetes repository,
+	// like release-1.29 or v1.29.3. It defaults to the spec in the
+	// Kubernetes repository on GitHub.
+	OpenAPI string `json:"openapi,omitempty"`
+	// Output is the directory of the vendor directory the libraries are
+	// generated into, in a subdir per version, kubernetes if empty.
+	Output string `json:"output,omitempty"`
+}
+
+// Replace overrides the dependency named Name, like a fork or a local
+// checkout of it to be used instead: Source replaces its source if set, and
+// Version its version if not empty. Local directories are relative to the
+// jsonnetfile of the project.
+type Replace struct {
+	Name    string  `json:"name"`
+	Source  *Source `json:"source,omitempty"`
+	Version string  `json:"version,omitempty"`
+}
+
+// Hook is a post-install step, like generating a library or formatting the
+// vendored files.
+type Hook struct {
+	// Name describes the hook in the output of jb.
+	Name string `json:"name,omitempty"`
+	// Command is the program to run and its arguments, run directly
+	// rather than by a shell.
+	Command []string `json:"command"`
 }
 
 type Source struct {
-	GitSource *GitSource `json:"git"`
+	GitSource          *GitSource          `json:"git,omitempty"`
+	ReleaseAssetSource *ReleaseAssetSource `json:"release,omitempty"`
+	LocalSource        *LocalSource        `json:"local,omitempty"`
+	ArchiveSource      *ArchiveSource      `json:"archive,omitempty"`
+	HgSource           *HgSource           `json:"hg,omitempty"`
+	CustomSource       *CustomSource       `json:"custom,omitempty"`
+	OCISource          *OCISource          `json:"oci,omitempty"`
 }
 
 type GitSource struct {
@@ -27,9 +152,129 @@ type GitSource struct {
 	Subdir string `json:"subdir"`
 }
 
+// ReleaseAssetSource is a single file downloaded over HTTP(S), published as
+// a release asset, for example on GitHub, or a raw file of a repository. The
+// SHA256 checksum is optional in the jsonnetfile and always recorded in the
+// lock.
+type ReleaseAssetSource struct {
+	URL    string `json:"url"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// ArchiveSource is a tarball or zip archive downloaded over HTTP(S), or from
+// object storage with s3:// and gs:// URLs, of which only Subdir is vendored
+// if set. The SHA256 checksum of the archive is optional in the jsonnetfile
+// and always recorded in the lock, along with the ETag of S3 objects and the
+// generation of Google Cloud Storage objects.
+type ArchiveSource struct {
+	URL        string `json:"url"`
+	Subdir     string `json:"subdir,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+	ETag       string `json:"etag,omitempty"`
+	Generation string `json:"generation,omitempty"`
+}
+
+// HgSource is a Mercurial repository, of which only Subdir is vendored if
+// set. It is locked at the changeset ID installed.
+type HgSource struct {
+	Remote string `json:"remote"`
+	Subdir string `json:"subdir,omitempty"`
+}
+
+// OCISource is a package pushed as an OCI artifact to a container registry,
+// like ghcr.io/org/lib. Versions are tags, locked at the digest of the
+// manifest installed.
+type OCISource struct {
+	Repository string `json:"repository"`
+}
+
+// CustomSource is installed by the plugin for its Type, a program outside of
+// jsonnet-bundler, which is passed Config as is.
+type CustomSource struct {
+	Type   string                 `json:"type"`
+	Config map[string]interface{} `json:"config,omitempty"`
+}
+
+// LocalSource is a directory on disk, relative to the jsonnetfile requiring
+// it unless absolute.
+type LocalSource struct {
+	Directory string `json:"directory"`
+}
+
 type Dependency struct {
-	Name      string `json:"name"`
-	Source    Source `json:"source"`
-	Version   string `json:"version"`
+	Name    string `json:"name"`
+	Source  Source `json:"source"`
+	Version string `json:"version"`
+	Flatten *bool  `json:"flatten,omitempty"`
+	// Sum is the digest of the vendored tree, recorded in the lock and
+	// verified when the same version is installed again.
+	Sum string `json:"sum,omitempty"`
+	// Tag is the tag a version constraint like ^1.2.0 resolved to, recorded
+	// in the lock next to the commit.
+	Tag string `json:"tag,omitempty"`
+	// Import is the import path, like libs.example.com/jsonnet/foo, the
+	// source of a dependency was resolved from by the go-import meta tag
+	// its host serves. It is recorded in the lock next to the resolved
+	// source.
+	Import string `json:"import,omitempty"`
+	// Groups are the groups a dependency of the project belongs to, like
+	// dev or docs, so that only some of them can be installed, DefaultGroup
+	// if it lists none. The lock records for every dependency the groups
+	// of the dependencies of the project that require it.
+	Groups []string `json:"groups,omitempty"`
+	// Date is the commit date of a git dependency and Tree the git tree
+	// hash of its subdir, recorded in the lock so that a diff of the lock
+	// tells whether a version moved back or forth in time and whether the
+	// vendored files changed.
+	Date string `json:"date,omitempty"`
+	Tree string `json:"tree,omitempty"`
+	// License is the SPDX identifier of the license of the package, as
+	// detected from its license file when it was installed.
+	License string `json:"license,omitempty"`
+	// Verify requires a git dependency to be installed from a tag signed by
+	// one of the trusted keys of the project, see JsonnetFile.TrustedKeys.
+	// It is kept in the lock, so installing the lock verifies the locked
+	// tag.
+	Verify bool `json:"verify,omitempty"`
+	// Exclude lists globs of the files and directories of the package that
+	// are not vendored, relative to it, where ** matches any number of
+	// directories, like **/*_test.jsonnet or docs/**. They are kept in the
+	// lock, whose digest is the one of the package without them.
+	Exclude []string `json:"exclude,omitempty"`
+	// Include lists globs like those of Exclude of the files of the package
+	// that are vendored even though they are excluded, stripped or not
+	// imported by a sparse project, like the JSON schemas or templates a
+	// library reads with importstr in a hidden directory. They are kept in
+	// the lock.
+	Include []string `json:"include,omitempty"`
+	// Files lists the files of a package that were vendored sparsely, see
+	// JsonnetFile.Sparse, relative to it. It is set in lock files only,
+	// whose digest is the one of these files.
+	Files []string `json:"files,omitempty"`
+	// Submodules initializes the submodules of a git dependency
+	// recursively. It is kept in the lock, next to SubmoduleCommits, the
+	// commit each submodule below the subdir was checked out at, by path
+	// relative to the repository.
+	Submodules       bool              `json:"submodules,omitempty"`
+	SubmoduleCommits map[string]string `json:"submoduleCommits,omitempty"`
+	// Transport pins a git dependency to a transport, https or ssh, its
+	// remote is translated to if written in the other form, like https
+	// for a git@github.com:org/repo remote in CI that only has HTTPS
+	// tokens. It is kept in the lock, which records the remote as
+	// written whatever the transport.
+	Transport string `json:"transport,omitempty"`
+	// Frozen keeps a dependency of the jsonnetfile at its locked version
+	// when the project is updated, whether all dependencies or only some
+	// are, unless frozen ones are included. It protects versions that were
+	// carefully validated from blanket updates, and is not recorded in the
+	// lock.
+	Frozen bool `json:"frozen,omitempty"`
+	// Comment, Owner and Link annotate a dependency of the jsonnetfile,
+	// like why it is required, who maintains it and where its
+	// documentation is. jb keeps them when it rewrites the jsonnetfile,
+	// but does not record them in the lock.
+	Comment   string `json:"comment,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+	Link      string `json:"link,omitempty"`
 	DepSource string `json:"-"`
 }
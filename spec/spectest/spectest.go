@@ -0,0 +1,228 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package spectest helps tools embedding the spec types test that they read
+// and write jsonnetfiles and lock files without losing anything, against
+// golden files of the expected output:
+//
+//	func TestRoundTrip(t *testing.T) {
+//		spectest.RunFixtures(t, "testdata", spectest.Indent)
+//	}
+//
+// Running the tests with SPECTEST_UPDATE=1 writes the golden files instead.
+package spectest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/spec"
+)
+
+// UpdateEnv names the environment variable that, when set, has Golden
+// write the golden files instead of comparing with them.
+const UpdateEnv = "SPECTEST_UPDATE"
+
+// GoldenSuffix is appended to the name of a fixture for its golden file.
+const GoldenSuffix = ".golden"
+
+// Marshal encodes a file for RoundTrip.
+type Marshal func(spec.JsonnetFile) ([]byte, error)
+
+// Indent encodes a file the way jb writes it: as JSON indented by four
+// spaces, with a trailing newline.
+func Indent(m spec.JsonnetFile) ([]byte, error) {
+	b, err := json.MarshalIndent(m, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// RoundTrip parses b with spec.Parse, encodes it again with marshal and
+// returns the result. It fails t if b does not parse, if anything of b is
+// lost or changed on the way, see Lossless, or if the result does not
+// encode to itself again.
+func RoundTrip(t testing.TB, b []byte, marshal Marshal) []byte {
+	t.Helper()
+
+	m, err := spec.Parse(b)
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	out, err := marshal(m)
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+	if err := Lossless(b, out); err != nil {
+		t.Errorf("round trip is lossy: %v", err)
+	}
+
+	again, err := spec.Parse(out)
+	if err != nil {
+		t.Fatalf("failed to parse the marshaled file: %v", err)
+	}
+	if !reflect.DeepEqual(m, again) {
+		t.Errorf("the marshaled file parses to\n%#v\ninstead of\n%#v", again, m)
+	}
+	if twice, err := marshal(again); err != nil || !bytes.Equal(out, twice) {
+		t.Errorf("the marshaled file does not marshal to itself again:\n%s", twice)
+	}
+	return out
+}
+
+// Lossless returns an error naming the first value of the JSON document in
+// that out, another encoding of it, lost or changed. Values out leaves
+// out or adds are only accepted if they are empty, like fields omitted if
+// empty or written even if they are, and the order of fields does not
+// matter.
+func Lossless(in, out []byte) error {
+	var a, b interface{}
+	if err := json.Unmarshal(in, &a); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(out, &b); err != nil {
+		return err
+	}
+	return compare("", a, b)
+}
+
+func compare(path string, a, b interface{}) error {
+	switch a := a.(type) {
+	case map[string]interface{}:
+		b, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(a)+len(b))
+		for k := range a {
+			keys = append(keys, k)
+		}
+		for k := range b {
+			if _, ok := a[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			av, aok := a[k]
+			bv, bok := b[k]
+			switch {
+			case !aok && !empty(bv):
+				return fmt.Errorf("%s was added", path+"."+k)
+			case !bok && !empty(av):
+				return fmt.Errorf("%s was lost", path+"."+k)
+			case aok && bok:
+				if err := compare(path+"."+k, av, bv); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			break
+		}
+		for n := range a {
+			if err := compare(fmt.Sprintf("%s[%d]", path, n), a[n], b[n]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if empty(a) && empty(b) || reflect.DeepEqual(a, b) {
+		return nil
+	}
+	if path == "" {
+		path = "the document"
+	}
+	return fmt.Errorf("%s changed from %s to %s", path, encode(a), encode(b))
+}
+
+// empty reports whether v, a decoded JSON value, is null or an empty
+// string, array or object, which encoders omit or write alike.
+func empty(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+func encode(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// Golden compares got with the golden file filename, failing t if they
+// differ. With UpdateEnv set, got is written to the golden file instead.
+func Golden(t testing.TB, filename string, got []byte) {
+	t.Helper()
+
+	if os.Getenv(UpdateEnv) != "" {
+		if err := ioutil.WriteFile(filename, got, 0644); err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("failed to read golden file, run the test with %s=1 to write it: %v", UpdateEnv, err)
+	}
+	if !bytes.Equal(want, got) {
+		t.Errorf("%s differs, run the test with %s=1 to update it if this is intended:\n--- want\n%s\n--- got\n%s", filename, UpdateEnv, want, got)
+	}
+}
+
+// RunFixtures runs RoundTrip with marshal on every .json file of dir, in a
+// subtest named after it, and compares the result with its golden file,
+// the fixture followed by GoldenSuffix.
+func RunFixtures(t *testing.T, dir string, marshal Marshal) {
+	t.Helper()
+
+	fixtures, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatalf("no fixtures in %s", dir)
+	}
+
+	for _, fixture := range fixtures {
+		fixture := fixture
+		t.Run(filepath.Base(fixture), func(t *testing.T) {
+			b, err := ioutil.ReadFile(fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			Golden(t, fixture+GoldenSuffix, RoundTrip(t, b, marshal))
+		})
+	}
+}
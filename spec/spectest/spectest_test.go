@@ -0,0 +1,44 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spectest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLossless(t *testing.T) {
+	for _, c := range []struct {
+		in, out, err string
+	}{
+		{`{"a": 1, "b": [1, 2]}`, `{"b": [1, 2], "a": 1}`, ""},
+		// Empty values may be omitted or added.
+		{`{"a": "", "b": [], "c": null}`, `{"d": {}}`, ""},
+		{`{"a": {"b": ["x", {"c": true}]}}`, `{"a": {"b": ["x", {}]}}`, ".a.b[1].c was lost"},
+		{`{"a": false}`, `{}`, ".a was lost"},
+		{`{}`, `{"a": 0}`, ".a was added"},
+		{`{"a": [1]}`, `{"a": [1, 2]}`, `.a changed from [1] to [1,2]`},
+		{`{"a": "x"}`, `{"a": "y"}`, `.a changed from "x" to "y"`},
+		{`1`, `2`, "the document changed from 1 to 2"},
+	} {
+		err := Lossless([]byte(c.in), []byte(c.out))
+		if c.err == "" {
+			assert.NoError(t, err, c.in)
+		} else {
+			assert.EqualError(t, err, c.err, c.in)
+		}
+	}
+}
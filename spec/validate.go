@@ -0,0 +1,306 @@
+// Copyright 2018 jsonnet-bundler authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/jsonnet-bundler/jsonnet-bundler/pkg/semver"
+)
+
+// ValidationError is returned by Parse for files that are not a valid
+// jsonnetfile or lock file, with one problem per offending element,
+// prefixed with its path like dependencies[1].source.git.remote.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid jsonnetfile: " + strings.Join(e.Problems, "; ")
+}
+
+// obsoleteFields are fields of the jsonnetfile written by former versions
+// of jsonnet-bundler, which are accepted and ignored.
+var obsoleteFields = map[string]bool{
+	"legacyImports": true,
+}
+
+// validate checks b against the fields and types of JsonnetFile, and that
+// no dependency is listed twice with the same name and source, nor any
+// dependency replaced twice or by nothing.
+func validate(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		if serr, ok := err.(*json.SyntaxError); ok {
+			// The offset is the one after the offending character.
+			line, column := position(b, serr.Offset-1)
+			return &ValidationError{Problems: []string{fmt.Sprintf("line %d, column %d: %v", line, column, err)}}
+		}
+		return &ValidationError{Problems: []string{err.Error()}}
+	}
+
+	problems := []string{}
+	if root, ok := v.(map[string]interface{}); ok {
+		for name := range obsoleteFields {
+			delete(root, name)
+		}
+	}
+	validateValue("", v, reflect.TypeOf(JsonnetFile{}), &problems)
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	m := JsonnetFile{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return &ValidationError{Problems: []string{err.Error()}}
+	}
+	for n, d := range m.Dependencies {
+		for prev := 0; prev < n; prev++ {
+			if m.Dependencies[prev].Name == d.Name && reflect.DeepEqual(m.Dependencies[prev].Source, d.Source) {
+				problems = append(problems, fmt.Sprintf("dependencies[%d]: duplicate of dependencies[%d], both named %q with the same source", n, prev, d.Name))
+				break
+			}
+		}
+	}
+	targets := make([]string, 0, len(m.Targets))
+	for name := range m.Targets {
+		targets = append(targets, name)
+	}
+	sort.Strings(targets)
+	for _, name := range targets {
+		if name == "" {
+			problems = append(problems, "targets: target without a name")
+		}
+		deps := m.Targets[name].Dependencies
+		for n, d := range deps {
+			for prev := 0; prev < n; prev++ {
+				if deps[prev].Name == d.Name {
+					problems = append(problems, fmt.Sprintf("targets.%s.dependencies[%d]: duplicate of targets.%s.dependencies[%d], both named %q", name, n, name, prev, d.Name))
+					break
+				}
+			}
+		}
+	}
+	if m.JB != "" {
+		if _, err := semver.ParseConstraint(m.JB); err != nil {
+			problems = append(problems, fmt.Sprintf("jb: %v", err))
+		}
+	}
+	for n, r := range m.Replace {
+		switch {
+		case r.Name == "":
+			problems = append(problems, fmt.Sprintf("replace[%d]: no name of the dependency to replace", n))
+		case r.Source == nil && r.Version == "":
+			problems = append(problems, fmt.Sprintf("replace[%d]: %q is replaced by neither a source nor a version", n, r.Name))
+		}
+		for prev := 0; prev < n; prev++ {
+			if r.Name != "" && m.Replace[prev].Name == r.Name {
+				problems = append(problems, fmt.Sprintf("replace[%d]: duplicate of replace[%d], both replacing %q", n, prev, r.Name))
+				break
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// validateValue checks the decoded JSON value v at path against the type t,
+// adding a problem for every unknown field and for every value of the wrong
+// type. Null is accepted for any type.
+func validateValue(path string, v interface{}, t reflect.Type, problems *[]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if v == nil {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected an object, got %s", describePath(path), jsonType(v)))
+			return
+		}
+		fields := jsonFields(t)
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			f, ok := fields[k]
+			if !ok {
+				msg := fmt.Sprintf("%s: unknown field", joinPath(path, k))
+				if s := suggestField(k, fields); s != "" {
+					msg += fmt.Sprintf(", did you mean %q?", s)
+				}
+				*problems = append(*problems, msg)
+				continue
+			}
+			validateValue(joinPath(path, k), obj[k], f.Type, problems)
+		}
+	case reflect.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected an object, got %s", describePath(path), jsonType(v)))
+			return
+		}
+		for k, e := range obj {
+			validateValue(joinPath(path, k), e, t.Elem(), problems)
+		}
+	case reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected an array, got %s", describePath(path), jsonType(v)))
+			return
+		}
+		for n, e := range arr {
+			validateValue(fmt.Sprintf("%s[%d]", path, n), e, t.Elem(), problems)
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected a string, got %s", describePath(path), jsonType(v)))
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			*problems = append(*problems, fmt.Sprintf("%s: expected a boolean, got %s", describePath(path), jsonType(v)))
+		}
+	case reflect.Int:
+		if f, ok := v.(float64); !ok || f != math.Trunc(f) {
+			*problems = append(*problems, fmt.Sprintf("%s: expected an integer, got %s", describePath(path), jsonType(v)))
+		}
+	}
+}
+
+// jsonFields returns the fields of the struct type t by their JSON names.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for n := 0; n < t.NumField(); n++ {
+		f := t.Field(n)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// suggestField returns the field of fields that name is most likely a typo
+// of, empty if there is none close enough.
+func suggestField(name string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for f := range fields {
+		if strings.EqualFold(f, name) {
+			return f
+		}
+		if d := editDistance(strings.ToLower(name), strings.ToLower(f)); d < bestDistance || (d == bestDistance && f < best) {
+			best, bestDistance = f, d
+		}
+	}
+	if bestDistance > 2 {
+		return ""
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "top level"
+	}
+	return path
+}
+
+// jsonType names the type of the decoded JSON value v.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return fmt.Sprintf("the string %q", v)
+	case bool:
+		return fmt.Sprintf("%t", v)
+	case float64:
+		return fmt.Sprintf("the number %v", v)
+	}
+	return "null"
+}
+
+// position returns the line and column of the byte offset in b, both
+// starting at 1.
+func position(b []byte, offset int64) (line, column int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	before := b[:offset]
+	line = bytes.Count(before, []byte("\n")) + 1
+	column = int(offset) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}